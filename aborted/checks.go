@@ -5,6 +5,7 @@ Copyright 2021 Adevinta
 package aborted
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,6 +14,8 @@ import (
 	"net/http"
 	"net/url"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/adevinta/vulcan-agent/log"
 	"github.com/adevinta/vulcan-agent/retryer"
@@ -32,10 +35,28 @@ type Checks struct {
 	client   http.Client
 	canceled map[string]struct{}
 	retryer  Retryer
+	log      log.Logger
+
+	// pushed contains the checks marked as aborted by Push since the last
+	// query of the aborted checks started, so they are not lost when the
+	// result of the query replaces the current ones.
+	pushed     map[string]struct{}
+	refreshMu  sync.Mutex
+	subscribed int32
+
+	// TTL, if greater than 0, is the time the aborted checks queried are
+	// considered up to date. When a check is not known to be aborted and the
+	// aborted checks are older, they are queried in the background and the
+	// check is considered not aborted meanwhile, instead of waiting for the
+	// query.
+	TTL          time.Duration
+	updated      time.Time
+	revalidating int32
 }
 
 // New return a new Checks structure that can be used to test if a concrete
-// check has been aborted or not.
+// check has been aborted or not. If the addr is empty the aborted checks are
+// never queried, so only the ones received by Push are known.
 func New(l log.Logger, addr string, retryer Retryer) (*Checks, error) {
 	_, err := url.Parse(addr)
 	if err != nil {
@@ -48,34 +69,143 @@ func New(l log.Logger, addr string, retryer Retryer) (*Checks, error) {
 		addr:     addr,
 		client:   c,
 		canceled: make(map[string]struct{}),
+		pushed:   make(map[string]struct{}),
 		retryer:  retryer,
+		log:      l,
 	}, nil
 }
 
 // IsAborted returns true if the specified ID has been marked to be aborted.
+// The aborted checks are queried only when the ID is not known to be aborted
+// and the abort events are not being received.
 func (c *Checks) IsAborted(ID string) (bool, error) {
 	c.RWMutex.RLock()
 	_, ok := c.canceled[ID]
 	c.RWMutex.RUnlock()
-	if ok {
-		return true, nil
+	if ok || c.Subscribed() || c.addr == "" {
+		return ok, nil
+	}
+	if c.TTL > 0 {
+		c.RWMutex.RLock()
+		updated := c.updated
+		c.RWMutex.RUnlock()
+		if !updated.IsZero() {
+			if time.Since(updated) > c.TTL {
+				c.revalidate()
+			}
+			return false, nil
+		}
 	}
 	// Update the internal list of checks and check again.
+	if err := c.refresh(); err != nil {
+		return false, err
+	}
+	c.RWMutex.RLock()
+	_, ok = c.canceled[ID]
+	c.RWMutex.RUnlock()
+	return ok, nil
+}
+
+// Push marks the given checks as aborted. It's called for each abort event
+// received.
+func (c *Checks) Push(IDs ...string) {
+	c.RWMutex.Lock()
+	defer c.RWMutex.Unlock()
+	for _, id := range IDs {
+		c.canceled[id] = struct{}{}
+		c.pushed[id] = struct{}{}
+	}
+}
+
+// SetSubscribed sets if the abort events are being received. When they
+// start to be received, the aborted checks are queried once so the ones
+// aborted before are also known. If that query fails the events are not
+// considered to be received, so the checks keep being queried.
+func (c *Checks) SetSubscribed(subscribed bool) {
+	if !subscribed {
+		if atomic.SwapInt32(&c.subscribed, 0) == 1 {
+			c.log.Infof("not receiving abort events, querying the aborted checks")
+		}
+		return
+	}
+	if c.Subscribed() {
+		return
+	}
+	if c.addr != "" {
+		if err := c.refresh(); err != nil {
+			c.log.Errorf("error querying the aborted checks: %+v", err)
+			return
+		}
+	}
+	atomic.StoreInt32(&c.subscribed, 1)
+	c.log.Infof("receiving abort events")
+}
+
+// RefreshEvery queries the aborted checks every interval, while the abort
+// events are not being received, until the context is done.
+func (c *Checks) RefreshEvery(ctx context.Context, interval time.Duration) {
+	if c.addr == "" {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if c.Subscribed() {
+			continue
+		}
+		if err := c.refresh(); err != nil {
+			c.log.Errorf("error querying the aborted checks: %+v", err)
+		}
+	}
+}
+
+// revalidate queries the aborted checks in the background, unless they are
+// already being queried.
+func (c *Checks) revalidate() {
+	if !atomic.CompareAndSwapInt32(&c.revalidating, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&c.revalidating, 0)
+		if err := c.refresh(); err != nil {
+			c.log.Errorf("error querying the aborted checks: %+v", err)
+		}
+	}()
+}
+
+// Subscribed returns true if the abort events are being received.
+func (c *Checks) Subscribed() bool {
+	return atomic.LoadInt32(&c.subscribed) == 1
+}
+
+// refresh replaces the aborted checks by the ones returned by the aborted
+// checks service plus the ones pushed meanwhile.
+func (c *Checks) refresh() error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+	c.RWMutex.Lock()
+	c.pushed = make(map[string]struct{})
+	c.RWMutex.Unlock()
 	ids, err := c.get()
 	if err != nil {
-		return false, err
+		return err
 	}
 	c.RWMutex.Lock()
+	defer c.RWMutex.Unlock()
 	c.canceled = make(map[string]struct{})
 	for _, id := range ids {
 		c.canceled[id] = struct{}{}
 	}
-	c.RWMutex.Unlock()
-
-	c.RWMutex.RLock()
-	_, ok = c.canceled[ID]
-	c.RWMutex.RUnlock()
-	return ok, nil
+	for id := range c.pushed {
+		c.canceled[id] = struct{}{}
+	}
+	c.updated = time.Now()
+	return nil
 }
 
 func (c *Checks) get() ([]string, error) {
@@ -90,6 +220,10 @@ func (c *Checks) get() ([]string, error) {
 				return err
 			}
 			defer resp.Body.Close()
+			if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+				err := fmt.Errorf("getting current aborted checks, unexpected status code: %d", resp.StatusCode)
+				return retryer.WithRetryAfter(err, resp)
+			}
 			if resp.StatusCode != http.StatusOK {
 				errStr := fmt.Sprintf("getting current aborted checks, unexpected status code: %d", resp.StatusCode)
 				err = fmt.Errorf("%s, %w", errStr, retryer.ErrPermanent)
@@ -0,0 +1,230 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package aborted
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/retryer"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/google/go-cmp/cmp"
+)
+
+func newTestChecks(t *testing.T, aborted []string) (*Checks, *int32) {
+	var queries int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&queries, 1)
+		json.NewEncoder(w).Encode(aborted)
+	}))
+	t.Cleanup(srv.Close)
+	c, err := New(&log.NullLog{}, srv.URL, retryer.NewRetryer(0, 1, &log.NullLog{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, &queries
+}
+
+func TestChecks_IsAborted(t *testing.T) {
+	tests := []struct {
+		name        string
+		subscribed  bool
+		pushed      []string
+		id          string
+		want        bool
+		wantQueries int32
+	}{
+		{
+			name:        "QueriesWhenNotSubscribed",
+			id:          "check1",
+			want:        true,
+			wantQueries: 1,
+		},
+		{
+			name:        "DoesNotQueryPushed",
+			pushed:      []string{"check2"},
+			id:          "check2",
+			want:        true,
+			wantQueries: 0,
+		},
+		{
+			name:        "DoesNotQueryWhenSubscribed",
+			subscribed:  true,
+			id:          "check3",
+			want:        false,
+			wantQueries: 1,
+		},
+		{
+			name:        "KnowsAbortedBeforeSubscribing",
+			subscribed:  true,
+			id:          "check1",
+			want:        true,
+			wantQueries: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, queries := newTestChecks(t, []string{"check1"})
+			c.Push(tt.pushed...)
+			if tt.subscribed {
+				c.SetSubscribed(true)
+			}
+			got, err := c.IsAborted(tt.id)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("want aborted %v, got %v", tt.want, got)
+			}
+			if n := atomic.LoadInt32(queries); n != tt.wantQueries {
+				t.Errorf("want %d queries, got %d", tt.wantQueries, n)
+			}
+		})
+	}
+}
+
+type sqsMock struct {
+	sqsiface.SQSAPI
+	mu       sync.Mutex
+	messages []*sqs.Message
+	deleted  []string
+}
+
+func (m *sqsMock) ReceiveMessageWithContext(ctx context.Context, in *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	m.mu.Lock()
+	msgs := m.messages
+	m.messages = nil
+	m.mu.Unlock()
+	if len(msgs) == 0 {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return &sqs.ReceiveMessageOutput{Messages: msgs}, nil
+}
+
+func (m *sqsMock) DeleteMessageWithContext(ctx context.Context, in *sqs.DeleteMessageInput, opts ...request.Option) (*sqs.DeleteMessageOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deleted = append(m.deleted, aws.StringValue(in.ReceiptHandle))
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+type aborterMock struct {
+	mu      sync.Mutex
+	aborted []string
+}
+
+func (a *aborterMock) AbortCheck(ID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.aborted = append(a.aborted, ID)
+}
+
+func TestSQSSubscriber_Subscribe(t *testing.T) {
+	c, queries := newTestChecks(t, nil)
+	mock := &sqsMock{messages: []*sqs.Message{
+		{Body: aws.String(`{"action":"abort","check_id":"check1"}`), ReceiptHandle: aws.String("m1")},
+		{Body: aws.String(`{"Type":"Notification","Message":"{\"action\":\"abort\",\"check_id\":\"check2\"}"}`), ReceiptHandle: aws.String("m2")},
+		{Body: aws.String(`invalid`), ReceiptHandle: aws.String("m3")},
+	}}
+	aborter := &aborterMock{}
+	s := &SQSSubscriber{sqs: mock, queueURL: "queue", checks: c, log: &log.NullLog{}, Aborter: aborter}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Subscribe(ctx)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mock.mu.Lock()
+		n := len(mock.deleted)
+		mock.mu.Unlock()
+		if n == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("messages not processed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !c.Subscribed() {
+		t.Error("checks not subscribed")
+	}
+	for _, id := range []string{"check1", "check2"} {
+		if ok, _ := c.IsAborted(id); !ok {
+			t.Errorf("check %s not aborted", id)
+		}
+	}
+	if diff := cmp.Diff([]string{"check1", "check2"}, aborter.aborted); diff != "" {
+		t.Errorf("aborted checks mismatch (-want +got):\n%v", diff)
+	}
+	if n := atomic.LoadInt32(queries); n != 1 {
+		t.Errorf("want 1 query, got %d", n)
+	}
+	cancel()
+	<-done
+	if c.Subscribed() {
+		t.Error("checks still subscribed after the subscription finished")
+	}
+}
+
+func waitQueries(t *testing.T, queries *int32, want int32) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(queries) < want {
+		if time.Now().After(deadline) {
+			t.Fatalf("want %d queries, got %d", want, atomic.LoadInt32(queries))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestChecks_TTL(t *testing.T) {
+	c, queries := newTestChecks(t, []string{"check1"})
+	c.TTL = time.Hour
+	// The first time the aborted checks are queried before answering.
+	if ok, err := c.IsAborted("check1"); err != nil || !ok {
+		t.Fatalf("want check1 aborted, got %v, err %v", ok, err)
+	}
+	if ok, _ := c.IsAborted("check2"); ok {
+		t.Errorf("want check2 not aborted")
+	}
+	if n := atomic.LoadInt32(queries); n != 1 {
+		t.Errorf("want 1 query while up to date, got %d", n)
+	}
+	// When they are stale, they are queried in the background.
+	c.RWMutex.Lock()
+	c.updated = time.Now().Add(-2 * time.Hour)
+	c.RWMutex.Unlock()
+	if ok, _ := c.IsAborted("check2"); ok {
+		t.Errorf("want check2 not aborted")
+	}
+	waitQueries(t, queries, 2)
+}
+
+func TestChecks_RefreshEvery(t *testing.T) {
+	c, queries := newTestChecks(t, []string{"check1"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.RefreshEvery(ctx, 10*time.Millisecond)
+	waitQueries(t, queries, 2)
+	c.RWMutex.RLock()
+	_, ok := c.canceled["check1"]
+	c.RWMutex.RUnlock()
+	if !ok {
+		t.Errorf("want check1 aborted")
+	}
+}
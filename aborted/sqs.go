@@ -0,0 +1,153 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package aborted
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/awscreds"
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// SubscribeRetryInterval is the time the SQSSubscriber waits before receiving
+// the events again after an error.
+var SubscribeRetryInterval = 5 * time.Second
+
+// Aborter defines the component used to abort the checks that are running
+// when an abort event is received.
+type Aborter interface {
+	AbortCheck(ID string)
+}
+
+// event is an abort event. It has the same format as the abort messages of
+// the stream.
+type event struct {
+	CheckID string `json:"check_id"`
+	Action  string `json:"action"`
+}
+
+// snsEnvelope contains the fields used of the messages that SNS sends to the
+// queues subscribed to a topic without raw message delivery.
+type snsEnvelope struct {
+	Type    string `json:"Type"`
+	Message string `json:"Message"`
+}
+
+// SQSSubscriber receives the abort events from an SQS queue, usually
+// subscribed to an SNS topic, and pushes them to the aborted checks. Every
+// agent must read from its own queue because the events are deleted once
+// received.
+type SQSSubscriber struct {
+	sqs      sqsiface.SQSAPI
+	queueURL string
+	checks   *Checks
+	log      log.Logger
+	// Aborter, if not nil, is used to abort the checks that are running when
+	// their abort events are received.
+	Aborter Aborter
+}
+
+// NewSQSSubscriber creates a SQSSubscriber that reads the abort events from
+// the given queue and pushes them to checks.
+func NewSQSSubscriber(l log.Logger, cfg config.AbortQueueConfig, checks *Checks) (*SQSSubscriber, error) {
+	sess, err := awscreds.NewSession(cfg.Credentials)
+	if err != nil {
+		return nil, fmt.Errorf("error creating AWS session: %w", err)
+	}
+	qarn, err := arn.Parse(cfg.ARN)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing SQS queue ARN: %w", err)
+	}
+	awsCfg := aws.NewConfig()
+	if qarn.Region != "" {
+		awsCfg = awsCfg.WithRegion(qarn.Region)
+	}
+	if cfg.Endpoint != "" {
+		awsCfg = awsCfg.WithEndpoint(cfg.Endpoint)
+	}
+	params := &sqs.GetQueueUrlInput{
+		QueueName: aws.String(qarn.Resource),
+	}
+	if qarn.AccountID != "" {
+		params.SetQueueOwnerAWSAccountId(qarn.AccountID)
+	}
+	srv := sqs.New(sess, awsCfg)
+	resp, err := srv.GetQueueUrl(params)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving SQS queue URL: %w", err)
+	}
+	return &SQSSubscriber{
+		sqs:      srv,
+		queueURL: aws.StringValue(resp.QueueUrl),
+		checks:   checks,
+		log:      l,
+	}, nil
+}
+
+// Subscribe receives the abort events until the context is done. While the
+// events can't be received the aborted checks are queried as usual.
+func (s *SQSSubscriber) Subscribe(ctx context.Context) {
+	defer s.checks.SetSubscribed(false)
+	for {
+		out, err := s.sqs.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(s.queueURL),
+			MaxNumberOfMessages: aws.Int64(10),
+			WaitTimeSeconds:     aws.Int64(20),
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			s.log.Errorf("error receiving abort events: %+v", err)
+			s.checks.SetSubscribed(false)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(SubscribeRetryInterval):
+			}
+			continue
+		}
+		s.checks.SetSubscribed(true)
+		for _, m := range out.Messages {
+			s.process(m)
+			_, err := s.sqs.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+				QueueUrl:      aws.String(s.queueURL),
+				ReceiptHandle: m.ReceiptHandle,
+			})
+			if err != nil {
+				s.log.Errorf("error deleting abort event: %+v", err)
+			}
+		}
+	}
+}
+
+func (s *SQSSubscriber) process(m *sqs.Message) {
+	body := []byte(aws.StringValue(m.Body))
+	var env snsEnvelope
+	if err := json.Unmarshal(body, &env); err == nil && env.Type == "Notification" {
+		body = []byte(env.Message)
+	}
+	var ev event
+	if err := json.Unmarshal(body, &ev); err != nil {
+		s.log.Errorf("error decoding abort event %s: %+v", body, err)
+		return
+	}
+	if ev.Action != "abort" || ev.CheckID == "" {
+		s.log.Errorf("invalid abort event: %s", body)
+		return
+	}
+	s.checks.Push(ev.CheckID)
+	if s.Aborter != nil {
+		s.Aborter.AbortCheck(ev.CheckID)
+	}
+}
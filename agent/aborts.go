@@ -0,0 +1,27 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package agent
+
+import (
+	"github.com/adevinta/vulcan-agent/aborted"
+	"github.com/adevinta/vulcan-agent/stream"
+)
+
+// streamAborts pushes the abort messages received from the stream to the
+// aborted checks, so they don't have to be queried before starting each
+// check while the stream is connected.
+type streamAborts struct {
+	stream.MsgProcessor
+	checks *aborted.Checks
+}
+
+func (s streamAborts) AbortCheck(ID string) {
+	s.checks.Push(ID)
+	s.MsgProcessor.AbortCheck(ID)
+}
+
+func (s streamAborts) StreamConnected(connected bool) {
+	s.checks.SetSubscribed(connected)
+}
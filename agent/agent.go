@@ -7,93 +7,371 @@ package agent
 import (
 	"context"
 	"errors"
+	"io"
 	"net/http"
 	"os"
 	"os/signal"
+	"reflect"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/adevinta/vulcan-agent/aborted"
 	"github.com/adevinta/vulcan-agent/api"
 	httpapi "github.com/adevinta/vulcan-agent/api/http"
+	"github.com/adevinta/vulcan-agent/audit"
 	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/adevinta/vulcan-agent/backend/faulty"
+	"github.com/adevinta/vulcan-agent/cloudwatch"
 	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/events"
+	"github.com/adevinta/vulcan-agent/heartbeat"
+	"github.com/adevinta/vulcan-agent/hooks"
 	"github.com/adevinta/vulcan-agent/jobrunner"
+	"github.com/adevinta/vulcan-agent/lifecycle"
 	"github.com/adevinta/vulcan-agent/log"
 	"github.com/adevinta/vulcan-agent/metrics"
+	"github.com/adevinta/vulcan-agent/msgauth"
+	"github.com/adevinta/vulcan-agent/notify"
+	"github.com/adevinta/vulcan-agent/postprocess"
 	"github.com/adevinta/vulcan-agent/queue"
-	"github.com/adevinta/vulcan-agent/queue/sqs"
+	"github.com/adevinta/vulcan-agent/reload"
+	"github.com/adevinta/vulcan-agent/resultcache"
+	"github.com/adevinta/vulcan-agent/resultcache/redis"
 	"github.com/adevinta/vulcan-agent/results"
 	"github.com/adevinta/vulcan-agent/retryer"
+	"github.com/adevinta/vulcan-agent/secrets"
+	"github.com/adevinta/vulcan-agent/selfupdate"
+	"github.com/adevinta/vulcan-agent/spool"
 	"github.com/adevinta/vulcan-agent/stateupdater"
 	"github.com/adevinta/vulcan-agent/stream"
 	"github.com/julienschmidt/httprouter"
 )
 
+// ReloadOptions defines how the agent reloads its config while it's running.
+type ReloadOptions struct {
+	// ConfigFile is the file the config is reloaded from when the agent
+	// receives a SIGHUP or, if the config_reload_interval is defined, when
+	// the file changes.
+	ConfigFile string
+	// Resolve, if not nil, is applied to the reloaded configs, e.g. to
+	// resolve the references to secrets.
+	Resolve func(cfg config.Config) (config.Config, error)
+	// RefreshInterval, if greater than 0, makes the agent to reload its
+	// config periodically so the values returned by Resolve are refreshed.
+	RefreshInterval time.Duration
+}
+
 // Run executes the agent using the given config and backend.
 // When the function finishes it returns an exit code of
 // 0 if the agent terminated gracefully, either by receiving a TERM signal or
 // because it passed more time than configured without reading a message.
 func Run(cfg config.Config, b backend.Backend, l log.Logger) int {
+	return RunReloadable(cfg, ReloadOptions{}, b, l)
+}
+
+// RunReloadable executes the agent in the same way Run does but it also
+// reloads, according to the given options, the params of the config that can
+// be changed without restarting, see the reload package.
+func RunReloadable(cfg config.Config, opts ReloadOptions, b backend.Backend, l log.Logger) (code int) {
+	cfg = config.Resolve(cfg)
+	// When the agent finishes gracefully after downloading a new version of
+	// itself, and all its components are stopped, it's replaced by the new
+	// version.
+	var update *selfupdate.Update
+	defer func() {
+		if update == nil {
+			return
+		}
+		if code != 0 {
+			os.Remove(update.Path)
+			return
+		}
+		l.Infof("updating agent to version %s", update.Version)
+		if err := selfupdate.Apply(*update); err != nil {
+			l.Errorf("error updating the agent: %+v", err)
+			code = 1
+		}
+	}()
 	// Build the results service.
-	timeout := time.Duration(cfg.Uploader.Timeout * int(time.Second))
-	interval := cfg.Uploader.RetryInterval
-	retries := cfg.Uploader.Retries
-	re := retryer.NewRetryer(retries, interval, l)
-	endpoint := cfg.Uploader.Endpoint
-	r := results.New(endpoint, re, timeout)
+	r, err := newUploader(cfg.Uploader, l)
+	if err != nil {
+		l.Errorf("error creating the results uploader %+v", err)
+		return 1
+	}
+	// Stop running new checks while the results can't be stored.
+	var breaker *results.Breaker
+	if cfg.Uploader.BreakerThreshold > 0 {
+		interval := time.Duration(cfg.Uploader.BreakerProbeInterval) * time.Second
+		if interval <= 0 {
+			interval = config.DefaultBreakerProbeInterval * time.Second
+		}
+		breaker = results.NewBreaker(l, r, cfg.Uploader.BreakerThreshold, interval)
+		r = breaker
+	}
+	uploader := r
 
-	// Build the sqs writer.
-	qw, err := sqs.NewWriter(cfg.SQSWriter.ARN, cfg.SQSWriter.Endpoint, l)
+	// Build the writer of the check states.
+	qw, err := newStateWriter(cfg, l)
 	if err != nil {
-		l.Errorf("error creating sqs writer %+v", err)
+		l.Errorf("error creating the state writer %+v", err)
 		return 1
 	}
+	if c, ok := qw.(io.Closer); ok {
+		// Send the pending state updates before exiting.
+		defer c.Close()
+	}
+
+	// Sign the state updates, including the ones sent from the spool.
+	if cfg.MessageAuth.SigningKey != "" {
+		qw = msgauth.NewSigningWriter(qw, cfg.MessageAuth.SigningKey)
+	}
+
+	// Persist the state updates and the results before sending them.
+	var spooled []spool.Replayer
+	if cfg.Spool.Dir != "" {
+		sw, err := spool.NewStateWriter(l, cfg.Spool.Dir, qw)
+		if err != nil {
+			l.Errorf("error creating the state updates spool %+v", err)
+			return 1
+		}
+		ss, err := spool.NewSink(l, cfg.Spool.Dir, r, sw)
+		if err != nil {
+			l.Errorf("error creating the results spool %+v", err)
+			return 1
+		}
+		qw, r = sw, ss
+		spooled = append(spooled, sw, ss)
+	}
 
 	// Build the state updater.
-	stateUpdater := stateupdater.New(qw)
+	su := stateupdater.New(qw)
+	if cfg.StateUpdater.FinalStatusesPath != "" {
+		su, err = stateupdater.NewPersistent(qw, cfg.StateUpdater.FinalStatusesPath)
+		if err != nil {
+			l.Errorf("error creating the state updater: %+v", err)
+			return 1
+		}
+		defer su.Close()
+	}
+	var stateUpdater notify.StateUpdater = su
+	// The backend used to run the checks, the original one is still used to
+	// apply the config changes.
+	runBackend := b
+	if cfg.Runtime.Faulty.Enabled {
+		l.Infof("injecting failures in the checks with the faulty backend, it must only be used for testing")
+		runBackend = faulty.New(l, b, cfg.Runtime.Faulty)
+	}
+	notifier, err := newNotifier(cfg.Notifications, l)
+	if err != nil {
+		l.Errorf("error creating the notifications %+v", err)
+		return 1
+	}
+	if notifier != nil {
+		// Closing the notifier sends the agent shutdown event.
+		defer notifier.Close()
+		stateUpdater = notify.NewUpdater(stateUpdater, notifier)
+		runBackend = notify.NewBackend(runBackend, notifier, cfg.Notifications.DegradedThreshold)
+	}
+	var cwPublisher *cloudwatch.Publisher
+	if cfg.CloudWatch.Enabled {
+		cwPublisher, err = cloudwatch.NewPublisher(l, cfg.CloudWatch)
+		if err != nil {
+			l.Errorf("error creating the CloudWatch publisher: %+v", err)
+			return 1
+		}
+		stateUpdater = cloudWatchUpdater{stateUpdater, cwPublisher}
+	}
+	// The events of the agent are streamed through the API.
+	bus := events.NewBus()
+	stateUpdater = eventsUpdater{stateUpdater, bus}
+	var bt *batch
+	if cfg.Agent.MaxChecks > 0 {
+		bt = newBatch(l, cfg.Agent.MaxChecks)
+		stateUpdater = batchUpdater{stateUpdater, bt}
+	}
+	// Call the hooks registered by the programs embedding the agent.
+	if hs := hooks.Registered(); len(hs) > 0 {
+		runBackend = hooks.NewBackend(runBackend, hs...)
+	}
+	// Process the reports of the checks before storing them.
+	procs, err := postprocess.FromConfig(cfg.PostProcessing)
+	if err != nil {
+		l.Errorf("error creating the report processors %+v", err)
+		return 1
+	}
+	var reports results.Sink = r
+	var processed *postprocess.Sink
+	if len(procs) > 0 {
+		processed = postprocess.NewSink(l, r, procs...)
+		reports = processed
+	}
 	updater := struct {
-		*stateupdater.Updater
-		*results.Uploader
-	}{stateUpdater, r}
+		notify.StateUpdater
+		results.Sink
+	}{stateUpdater, reports}
+
+	// Remove the checks left running by previous executions of the agent
+	// before starting to run new ones.
+	reapOrphans(cfg.Agent, l, b, stateUpdater)
+
+	// Pre-pull the images of the checks in background, so the agent can
+	// start reading messages meanwhile. The pulls are stopped when the agent
+	// finishes.
+	if pp, ok := b.(backend.PrePuller); ok {
+		ctxpp, cancelpp := context.WithCancel(context.Background())
+		ppDone := pp.PrePull(ctxpp)
+		defer func() {
+			cancelpp()
+			<-ppDone
+		}()
+	}
 
 	var abortedChecks jobrunner.AbortedChecks
 
 	// Build the aborted checks component that will be used to know if a check
 	// has been aborted or not defore starting to execute it.
-	endpoint = cfg.Stream.QueryEndpoint
-	retries = cfg.Stream.Retries
-	interval = cfg.Stream.RetryInterval
-	re = retryer.NewRetryer(retries, interval, l)
-	if endpoint == "" {
+	endpoint := cfg.Stream.QueryEndpoint
+	retries := cfg.Stream.Retries
+	interval := cfg.Stream.RetryInterval
+	re := retryer.NewRetryer(retries, interval, l)
+	switch cfg.Stream.AbortEvents {
+	case "", config.AbortEventsStream, config.AbortEventsSQS:
+	default:
+		l.Errorf("invalid stream abort_events: %s", cfg.Stream.AbortEvents)
+		return 1
+	}
+	var pushedAborts *aborted.Checks
+	if endpoint == "" && cfg.Stream.AbortEvents == "" {
 		l.Infof("stream query_endpoint is empty, the agent will not check for aborted checks")
 		abortedChecks = &aborted.None{}
 	} else {
-		abortedChecks, err = aborted.New(l, endpoint, re)
+		pushedAborts, err = aborted.New(l, endpoint, re)
 		if err != nil {
-			l.Errorf("error creating aborted checks %+v", abortedChecks)
+			l.Errorf("error creating aborted checks %+v", err)
 			return 1
 		}
+		pushedAborts.TTL = time.Duration(cfg.Stream.AbortedTTL) * time.Second
+		abortedChecks = pushedAborts
 	}
 
+	switch cfg.Agent.DuplicateChecks {
+	case "", config.DuplicateChecksReject, config.DuplicateChecksRequeue:
+	default:
+		l.Errorf("invalid agent duplicate_checks: %s", cfg.Agent.DuplicateChecks)
+		return 1
+	}
 	runnerCfg := jobrunner.RunnerConfig{
 		MaxTokens:              cfg.Agent.ConcurrentJobs,
 		DefaultTimeout:         cfg.Agent.Timeout,
 		MaxProcessMessageTimes: cfg.Agent.MaxProcessMessageTimes,
+		CheckCosts:             cfg.Agent.CheckCosts,
+		TargetRateLimit:        cfg.Agent.TargetRateLimit,
+		TeamRateLimit:          cfg.Agent.TeamRateLimit,
+		KillGrace:              cfg.Check.KillGrace,
+		WatchdogGrace:          cfg.Agent.WatchdogGrace,
+		RequeueDuplicates:      cfg.Agent.DuplicateChecks == config.DuplicateChecksRequeue,
+		UploadWorkers:          cfg.Uploader.Workers,
+		Admission: jobrunner.AdmissionConfig{
+			DiskPath:          cfg.Agent.Admission.DiskPath,
+			MinFreeDiskMB:     cfg.Agent.Admission.MinFreeDiskMB,
+			MaxLoad:           cfg.Agent.Admission.MaxLoad,
+			MinFreeMemoryMB:   cfg.Agent.Admission.MinFreeMemoryMB,
+			MaxMemoryPressure: cfg.Agent.Admission.MaxMemoryPressure,
+			Interval:          cfg.Agent.Admission.Interval,
+		},
 	}
 
-	jrunner := jobrunner.New(l, b, updater, abortedChecks, runnerCfg)
+	jrunner := jobrunner.New(l, runBackend, updater, abortedChecks, runnerCfg)
+	if as, ok := r.(results.ArtifactSink); ok {
+		jrunner.Artifacts = as
+	}
+	jrunner.Completed = su
+	jrunner.Events = bus
+	if cwPublisher != nil {
+		jrunner.Duplicates = cwPublisher
+	}
+	verifier, err := msgauth.NewVerifier(cfg.MessageAuth)
+	if err != nil {
+		l.Errorf("error creating the message verifier: %+v", err)
+		return 1
+	}
+	jrunner.Verifier = verifier
+	if cfg.Audit.Enabled() {
+		auditLog, err := audit.New(l, cfg.Audit, cfg.Heartbeat.AgentID)
+		if err != nil {
+			l.Errorf("error creating the audit log: %+v", err)
+			return 1
+		}
+		// Closing the audit log sends the pending records.
+		defer auditLog.Close()
+		jrunner.Audit = auditLog
+	}
+	if cfg.Agent.CheckLogs.Dir != "" {
+		checkLogs, err := log.NewCheckLogs(l, cfg.Agent.CheckLogs)
+		if err != nil {
+			l.Errorf("error creating the check logs: %+v", err)
+			return 1
+		}
+		jrunner.CheckLogs = checkLogs
+	}
+	if processed != nil {
+		processed.Checks = jrunner
+	}
+	if breaker != nil {
+		breaker.Intake = jrunner
+	}
+	if cfg.Agent.WatchdogInterval > 0 {
+		ctxwd, cancelwd := context.WithCancel(context.Background())
+		defer cancelwd()
+		go jrunner.Watchdog(ctxwd, time.Duration(cfg.Agent.WatchdogInterval)*time.Second)
+	}
+
+	// The API sends the updates of the checks through the results cache, when
+	// enabled, so it can store the reports of the checks.
+	var apiUpdater api.CheckStateUpdater = updater
+	if cfg.ResultCache.Enabled {
+		var store resultcache.Store = resultcache.NewMemory()
+		if cfg.ResultCache.Redis != "" {
+			store = redis.New(cfg.ResultCache.Redis, cfg.ResultCache.RedisPassword, cfg.ResultCache.RedisDB)
+		}
+		ttl := time.Duration(cfg.ResultCache.TTL) * time.Second
+		cache := resultcache.New(l, updater, store, ttl)
+		jrunner.ResultCache = cache
+		apiUpdater = cache
+	}
+
+	if len(cfg.Check.DynamicVars) > 0 {
+		issuer, err := secrets.NewIssuer(l, cfg.Secrets, cfg.Check.DynamicVars)
+		if err != nil {
+			l.Errorf("error creating the dynamic vars issuer: %+v", err)
+			return 1
+		}
+		jrunner.DynamicVars = issuer
+	}
 
 	// Setup metrics.
 	metrics := metrics.NewMetrics(l, cfg.DataDog, jrunner)
 
 	ctxqr, cancelqr := context.WithCancel(context.Background())
 
+	if len(spooled) > 0 {
+		interval := time.Duration(cfg.Spool.ReplayInterval) * time.Second
+		if interval <= 0 {
+			interval = config.DefaultSpoolReplayInterval * time.Second
+		}
+		go spool.Run(ctxqr, l, interval, spooled...)
+	}
+
 	var streamDone <-chan error
 	if cfg.Stream.Endpoint == "" {
 		l.Infof("Check cancel stream disabled")
 	} else {
-		stream := stream.New(l, metrics, re, cfg.Stream.Endpoint)
+		var processor stream.MsgProcessor = metrics
+		if cfg.Stream.AbortEvents == config.AbortEventsStream {
+			processor = streamAborts{metrics, pushedAborts}
+		}
+		stream := stream.New(l, processor, re, cfg.Stream.Endpoint)
 		streamDone, err = stream.ListenAndProcess(ctxqr)
 		if err != nil {
 			l.Errorf("error starting stream: %+v", err)
@@ -101,52 +379,256 @@ func Run(cfg config.Config, b backend.Backend, l log.Logger) int {
 			return 1
 		}
 	}
+	if pushedAborts != nil && cfg.Stream.AbortedRefreshInterval > 0 {
+		go pushedAborts.RefreshEvery(ctxqr, time.Duration(cfg.Stream.AbortedRefreshInterval)*time.Second)
+	}
+	if cfg.Stream.AbortEvents == config.AbortEventsSQS {
+		sub, err := aborted.NewSQSSubscriber(l, cfg.Stream.AbortQueue, pushedAborts)
+		if err != nil {
+			l.Errorf("error creating the abort events subscriber: %+v", err)
+			cancelqr()
+			return 1
+		}
+		sub.Aborter = metrics
+		go sub.Subscribe(ctxqr)
+	}
 
-	var maxTimeNoMsg *time.Duration
-	if cfg.Agent.MaxNoMsgsInterval > 0 {
-		t := time.Duration(cfg.Agent.MaxNoMsgsInterval) * time.Second
-		maxTimeNoMsg = &t
+	idle := time.Duration(cfg.Agent.MaxNoMsgsInterval) * time.Second
+	if cfg.Agent.ExitWhenEmpty {
+		// Any read without messages exceeds the limit.
+		idle = time.Nanosecond
 	}
+	maxTimeNoMsg := queue.NewIdleLimit(idle, time.Duration(cfg.Agent.MinLifetime)*time.Second)
 
-	qr, err := sqs.NewReader(l, cfg.SQSReader, maxTimeNoMsg, jrunner)
+	qtype := cfg.QueueType()
+	if qtype == config.QueueTypeSchedules && cfg.Queue.Type == "" {
+		l.Infof("sqs_reader arn is empty, the agent will only run the scheduled checks")
+	}
+	var processor queue.MessageProcessor = jrunner
+	if cwPublisher != nil {
+		processor = cloudWatchProcessor{jrunner, cwPublisher}
+	}
+	if bt != nil {
+		bt.stop = cancelqr
+		jrunner.Quota = bt
+		processor = batchProcessor{jrunner, processor, bt}
+	}
+	qr, err := queue.NewReader(qtype, l, cfg, maxTimeNoMsg, processor)
 	if err != nil {
-		l.Errorf("error starting SQSReader: %+v", err)
+		l.Errorf("error starting queue reader: %+v", err)
 		cancelqr()
 		return 1
 	}
 	stats := struct {
 		*jobrunner.Runner
-		*sqs.Reader
+		queue.Reader
 	}{
 		jrunner,
 		qr,
 	}
-	api := api.New(l, updater, stats)
+	drain := newDrainer(time.Duration(cfg.Agent.DrainTimeout) * time.Second)
+	api := api.New(l, apiUpdater, stats)
+	api.Drainer = drain
+	api.Pauser = jrunner
+	api.IdleLimit = maxTimeNoMsg
+	var levels *log.LevelSwitch
+	if ls, ok := l.(log.LevelSetter); ok {
+		levels = log.NewLevelSwitch(l, ls, cfg.Agent.LogLevel)
+		api.Leveler = levels
+	}
+	api.Aborter = jrunner
+	api.Lister = jrunner
+	api.CapacityStats = jrunner
+	api.Events = bus
+	if c, ok := qr.(interface{ ProcessingMessages() int }); ok {
+		api.InFlight = c
+	}
+	if ls, ok := b.(backend.LogStreamer); ok {
+		api.Logs = ls
+	}
+	if cfg.API.Debug.Enabled {
+		if cfg.API.Debug.Token == "" {
+			l.Errorf("the api debug endpoints require a token")
+			cancelqr()
+			return 1
+		}
+		if ex, ok := b.(backend.Execer); ok {
+			api.Execer = ex
+			api.ExecToken = cfg.API.Debug.Token
+		} else {
+			l.Errorf("the backend does not support the api debug endpoints")
+		}
+	}
+	api.LivenessProbes, api.ReadinessProbes = newProbes(b, qr, uploader, jrunner)
 	router := httprouter.New()
-	httpapi.NewREST(l, api, router)
-	srv := http.Server{
-		Addr:    cfg.API.Port,
-		Handler: router,
-	}
-	httpDone := make(chan error)
-	go func() {
-		err := srv.ListenAndServe()
-		httpDone <- err
-		close(httpDone)
-	}()
+	rest := httpapi.NewREST(l, api, router)
+	srv, auth, err := newAPIServer(cfg.API, router)
+	if err != nil {
+		l.Errorf("error creating the api server: %+v", err)
+		cancelqr()
+		return 1
+	}
+	rest.Auth = auth
+	httpDone := srv.Serve()
+
+	// The errors of the diagnostics server don't stop the agent.
+	if cfg.Diagnostics.Port != "" {
+		diag := newDiagnosticsServer(cfg.Diagnostics.Port, cfg, jrunner, qr)
+		go func() {
+			err := diag.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				l.Errorf("diagnostics server stopped with error: %+v", err)
+			}
+		}()
+		defer diag.Close()
+	}
+
+	if cwPublisher != nil {
+		// The metrics are published until the agent finishes, including
+		// while it waits for the running checks.
+		cwPublisher.Stats = cloudWatchStats(jrunner, qr)
+		ctxcw, cancelcw := context.WithCancel(context.Background())
+		cwDone := cwPublisher.Start(ctxcw)
+		defer func() {
+			cancelcw()
+			<-cwDone
+		}()
+	}
+
+	hbStore, err := newHeartbeatStore(cfg.Heartbeat)
+	if err != nil {
+		l.Errorf("error creating the heartbeat store: %+v", err)
+		cancelqr()
+		return 1
+	}
+	if hbStore != nil {
+		// The heartbeats are sent until the agent finishes, including
+		// while it waits for the running checks.
+		ctxhb, cancelhb := context.WithCancel(context.Background())
+		reporter := heartbeat.NewReporter(l, hbStore, cfg.Heartbeat.AgentID, Version, heartbeatInterval(cfg.Heartbeat), func() heartbeat.Stats {
+			status, _ := api.Status()
+			return heartbeat.Stats{
+				Status:        status.State,
+				Capacity:      jrunner.Capacity(),
+				ChecksRunning: status.ChecksRunning,
+			}
+		})
+		hbDone := reporter.Start(ctxhb)
+		defer func() {
+			cancelhb()
+			<-hbDone
+		}()
+	}
 
 	qrdone := qr.StartReading(ctxqr)
 	metricsDone := metrics.StartPolling(ctxqr)
 
-	l.Infof("agent running on address %s", srv.Addr)
+	if opts.ConfigFile != "" || opts.RefreshInterval > 0 {
+		interval := time.Duration(cfg.Agent.ConfigReloadInterval) * time.Second
+		// The credentials of the registries are only validated again when
+		// they change.
+		registry := cfg.Runtime.Docker.Registry
+		// The max time without messages is only changed when it changes
+		// in the config, so the value set through the API is kept.
+		maxNoMsgs := cfg.Agent.MaxNoMsgsInterval
+		// The same happens with the log level.
+		logLevel := cfg.Agent.LogLevel
+		w := reload.NewWatcher(l, opts.ConfigFile, cfg, interval, func(cfg config.Config) error {
+			if err := jrunner.SetMaxTokens(cfg.Agent.ConcurrentJobs); err != nil {
+				return err
+			}
+			rs, ok := b.(backend.RegistryAuthsSetter)
+			if ok && !reflect.DeepEqual(registry, cfg.Runtime.Docker.Registry) {
+				if err := rs.SetRegistryAuths(cfg.Runtime.Docker.Registry); err != nil {
+					return err
+				}
+				registry = cfg.Runtime.Docker.Registry
+			}
+			jrunner.SetDefaultTimeout(cfg.Agent.Timeout)
+			if cfg.Agent.MaxNoMsgsInterval != maxNoMsgs {
+				maxTimeNoMsg.SetMax(time.Duration(cfg.Agent.MaxNoMsgsInterval) * time.Second)
+				maxNoMsgs = cfg.Agent.MaxNoMsgsInterval
+			}
+			if levels != nil && cfg.Agent.LogLevel != logLevel {
+				levels.SetBase(cfg.Agent.LogLevel)
+				logLevel = cfg.Agent.LogLevel
+			}
+			if vs, ok := b.(backend.CheckVarsSetter); ok {
+				checkVars, err := backend.NewCheckVars(cfg.Check)
+				if err != nil {
+					return err
+				}
+				vs.SetCheckVars(checkVars)
+			}
+			return nil
+		})
+		w.Resolve = opts.Resolve
+		w.RefreshInterval = opts.RefreshInterval
+		go w.Run(ctxqr)
+	}
+
+	l.Infof("agent running on address %s", strings.Join(srv.Addrs(), ", "))
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	sigDrain := make(chan os.Signal, 1)
+	signal.Notify(sigDrain, syscall.SIGUSR1)
 
+	var (
+		lifecycleWatcher *lifecycle.Watcher
+		notices          <-chan lifecycle.Notice
+		noticed          bool
+	)
+	if cfg.Lifecycle.Enabled {
+		lifecycleWatcher, err = lifecycle.New(l, cfg.Lifecycle)
+		if err != nil {
+			l.Errorf("error creating the lifecycle watcher: %+v", err)
+			cancelqr()
+			return 1
+		}
+		notices = lifecycleWatcher.Watch(ctxqr)
+	}
+
+	var updates <-chan selfupdate.Update
+	if cfg.SelfUpdate.ManifestURL != "" {
+		updater, err := selfupdate.New(l, cfg.SelfUpdate, Version)
+		if err != nil {
+			l.Errorf("error creating the self-updater: %+v", err)
+			cancelqr()
+			return 1
+		}
+		updates = updater.Watch(ctxqr)
+	}
+
+	var shutdownDeadline <-chan time.Time
 	select {
 	case <-sig:
 		// Signal the sqs queue reader to stop reading messages from the queue.
 		l.Infof("SIG received, stoping agent")
 		cancelqr()
+		if cfg.Agent.ShutdownTimeout > 0 {
+			shutdownDeadline = time.After(time.Duration(cfg.Agent.ShutdownTimeout) * time.Second)
+		}
+	case <-sigDrain:
+		l.Infof("SIGUSR1 received, draining agent")
+		drain.Drain()
+		cancelqr()
+	case <-drain.Started():
+		l.Infof("draining agent")
+		cancelqr()
+	case n := <-notices:
+		l.Infof("%s termination notice received, draining agent", n.Kind)
+		noticed = true
+		deadline := n.Time
+		if !deadline.IsZero() {
+			deadline = deadline.Add(-time.Duration(cfg.Lifecycle.RequeueMargin) * time.Second)
+		}
+		drain.DrainUntil(deadline)
+		cancelqr()
+	case u := <-updates:
+		l.Infof("agent version %s downloaded, draining agent to update it", u.Version)
+		update = &u
+		drain.Drain()
+		cancelqr()
 	case err := <-httpDone:
 		l.Errorf("error running the the agent %+v", err)
 		cancelqr()
@@ -162,7 +644,31 @@ func Run(cfg config.Config, b backend.Backend, l log.Logger) int {
 
 	// Wait for all the pending jobs to finish.
 	l.Infof("waiting for the checks to finish")
-	err = <-qrdone
+	select {
+	case err = <-qrdone:
+	case <-drain.Deadline():
+		if drain.Requeue() {
+			l.Infof("drain deadline reached, stopping the running checks to be run again")
+			jrunner.RequeueAllChecks()
+		} else {
+			l.Infof("drain deadline reached, aborting the running checks")
+			jrunner.AbortAllChecks("")
+		}
+		err = <-qrdone
+	case <-shutdownDeadline:
+		l.Infof("shutdown timeout reached, stopping the running checks to be run again")
+		jrunner.RequeueAllChecks()
+		err = <-qrdone
+	case <-sig:
+		l.Infof("SIG received again, stopping the running checks to be run again")
+		jrunner.RequeueAllChecks()
+		err = <-qrdone
+	}
+	if noticed {
+		if err := lifecycleWatcher.Complete(context.Background()); err != nil {
+			l.Errorf("%+v", err)
+		}
+	}
 	if err != nil && !errors.Is(err, context.Canceled) {
 		l.Errorf("error waiting for the checks to finish %+v", err)
 	}
@@ -176,10 +682,11 @@ func Run(cfg config.Config, b backend.Backend, l log.Logger) int {
 		l.Errorf("error stoping http server: %+v", err)
 		return 1
 	}
-	err = <-httpDone
-	if err != nil && !errors.Is(err, http.ErrServerClosed) {
-		l.Errorf("http server stopped with error: %+v", err)
-		return 1
+	for err := range httpDone {
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			l.Errorf("http server stopped with error: %+v", err)
+			return 1
+		}
 	}
 	if streamDone != nil {
 		// Wait for the stream to finish.
@@ -190,6 +697,9 @@ func Run(cfg config.Config, b backend.Backend, l log.Logger) int {
 			return 1
 		}
 	}
+	if bt != nil {
+		l.Infof("batch finished, %s", bt.summary())
+	}
 	l.Infof("agent finished gracefully")
 	return 0
 }
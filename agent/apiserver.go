@@ -0,0 +1,113 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	httpapi "github.com/adevinta/vulcan-agent/api/http"
+	"github.com/adevinta/vulcan-agent/config"
+)
+
+// apiServer serves the agent API over plain HTTP, used by the checks, and,
+// when TLS is configured, also over HTTPS.
+type apiServer struct {
+	servers []*http.Server
+	tls     *http.Server
+}
+
+// newAPIServer returns the apiServer for the given config and the Auth that
+// the REST API must apply to the control endpoints.
+func newAPIServer(cfg config.APIConfig, h http.Handler) (*apiServer, httpapi.Auth, error) {
+	auth := httpapi.Auth{Token: cfg.Auth.Token}
+	s := &apiServer{
+		servers: []*http.Server{{Addr: cfg.Port, Handler: h}},
+	}
+	if cfg.Auth.TLSCert == "" && cfg.Auth.TLSKey == "" {
+		if cfg.Auth.ClientCA != "" {
+			return nil, httpapi.Auth{}, errors.New("the api client_ca requires a tls_cert and a tls_key")
+		}
+		return s, auth, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.Auth.TLSCert, cfg.Auth.TLSKey)
+	if err != nil {
+		return nil, httpapi.Auth{}, fmt.Errorf("loading the api certificate: %w", err)
+	}
+	tlsCfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if cfg.Auth.ClientCA != "" {
+		pem, err := os.ReadFile(cfg.Auth.ClientCA)
+		if err != nil {
+			return nil, httpapi.Auth{}, fmt.Errorf("reading the api client ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, httpapi.Auth{}, fmt.Errorf("no certificates found in the api client ca %s", cfg.Auth.ClientCA)
+		}
+		tlsCfg.ClientCAs = pool
+		tlsCfg.ClientAuth = tls.VerifyClientCertIfGiven
+		auth.ClientCerts = true
+	}
+	auth.RequireTLS = true
+	s.tls = &http.Server{Addr: cfg.Auth.TLSPort, Handler: h, TLSConfig: tlsCfg}
+	s.servers = append(s.servers, s.tls)
+	return s, auth, nil
+}
+
+// Serve starts serving the API. The returned channel is written with the
+// error returned by each server when it stops, and closed when all of them
+// stopped.
+func (s *apiServer) Serve() <-chan error {
+	done := make(chan error, len(s.servers))
+	var wg sync.WaitGroup
+	for _, srv := range s.servers {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			if srv == s.tls {
+				done <- srv.ListenAndServeTLS("", "")
+				return
+			}
+			done <- srv.ListenAndServe()
+		}(srv)
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return done
+}
+
+// Shutdown gracefully stops all the servers.
+func (s *apiServer) Shutdown(ctx context.Context) error {
+	var errs []error
+	for _, srv := range s.servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
+
+// Addrs returns the addresses the API is served on.
+func (s *apiServer) Addrs() []string {
+	var addrs []string
+	for _, srv := range s.servers {
+		addrs = append(addrs, srv.Addr)
+	}
+	return addrs
+}
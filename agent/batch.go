@@ -0,0 +1,120 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package agent
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/adevinta/vulcan-agent/jobrunner"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/notify"
+	"github.com/adevinta/vulcan-agent/queue"
+	"github.com/adevinta/vulcan-agent/stateupdater"
+)
+
+// batch limits the number of checks the agent runs before exiting and
+// counts, by status, the checks that finish to write a summary at exit. It's
+// the quota of checks of the Runner, so only the jobs that are actually going
+// to be run are counted, not the duplicated, invalid or not admitted ones.
+type batch struct {
+	max int
+	log log.Logger
+	// stop is called to stop reading messages when the max is reached.
+	stop func()
+
+	mu       sync.Mutex
+	taken    int
+	statuses map[string]int
+}
+
+// newBatch returns a batch that stops reading messages when max checks have
+// been taken.
+func newBatch(l log.Logger, max int) *batch {
+	return &batch{max: max, log: l, statuses: make(map[string]int)}
+}
+
+// Take returns true if a new check can be run. The max-th check stops the
+// agent from reading more messages.
+func (b *batch) Take() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.taken >= b.max {
+		return false
+	}
+	b.taken++
+	if b.taken == b.max {
+		b.log.Infof("max number of checks reached, stopping reading messages")
+		b.stop()
+	}
+	return true
+}
+
+// exhausted returns true if the max number of checks has been taken.
+func (b *batch) exhausted() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.taken >= b.max
+}
+
+func (b *batch) completed(status string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.statuses[status]++
+}
+
+// summary returns the number of checks taken and of the checks finished by
+// status.
+func (b *batch) summary() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var statuses []string
+	for s, n := range b.statuses {
+		statuses = append(statuses, fmt.Sprintf("%s=%d", s, n))
+	}
+	sort.Strings(statuses)
+	return fmt.Sprintf("checks run: %d, checks by status: %s", b.taken, strings.Join(statuses, ", "))
+}
+
+// batchUpdater decorates a StateUpdater counting the checks that finish in
+// the summary of the batch.
+type batchUpdater struct {
+	notify.StateUpdater
+	b *batch
+}
+
+func (u batchUpdater) UpdateState(s stateupdater.CheckState) error {
+	if err := u.StateUpdater.UpdateState(s); err != nil {
+		return err
+	}
+	if s.Status != nil && stateupdater.IsFinal(*s.Status) {
+		u.b.completed(*s.Status)
+	}
+	return nil
+}
+
+// batchProcessor decorates the processor of the messages not passing to it
+// the messages read after the max number of checks of the batch is reached.
+// Those messages are returned to the queue, as the reader has already been
+// stopped. The messages read before are always passed, and the Runner
+// returns them to the queue if the max is reached before running their
+// checks.
+type batchProcessor struct {
+	*jobrunner.Runner
+	next queue.MessageProcessor
+	b    *batch
+}
+
+func (p batchProcessor) ProcessMessage(msg queue.Message, token interface{}) <-chan bool {
+	if !p.b.exhausted() {
+		return p.next.ProcessMessage(msg, token)
+	}
+	p.Runner.ReleaseToken(token)
+	processed := make(chan bool, 1)
+	processed <- false
+	return processed
+}
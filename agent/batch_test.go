@@ -0,0 +1,232 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/jobrunner"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/queue"
+	"github.com/adevinta/vulcan-agent/stateupdater"
+	"github.com/adevinta/vulcan-agent/testutil"
+	report "github.com/adevinta/vulcan-report"
+)
+
+func TestBatch_Take(t *testing.T) {
+	b := newBatch(&log.NullLog{}, 2)
+	var stops int
+	b.stop = func() { stops++ }
+
+	var got []bool
+	for i := 0; i < 3; i++ {
+		got = append(got, b.Take())
+	}
+	want := []bool{true, true, false}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("want takes %v, got %v", want, got)
+		}
+	}
+	if stops != 1 {
+		t.Errorf("want the reader stopped once, got %d", stops)
+	}
+	if !b.exhausted() {
+		t.Errorf("batch not exhausted")
+	}
+	b.completed(stateupdater.StatusFinished)
+	b.completed(stateupdater.StatusFailed)
+	b.completed(stateupdater.StatusFinished)
+	want2 := "checks run: 2, checks by status: FAILED=1, FINISHED=2"
+	if s := b.summary(); s != want2 {
+		t.Errorf("want summary %q, got %q", want2, s)
+	}
+}
+
+type processorMock struct {
+	*jobrunner.Runner
+	msgs []queue.Message
+}
+
+func (p *processorMock) ProcessMessage(msg queue.Message, token interface{}) <-chan bool {
+	p.msgs = append(p.msgs, msg)
+	p.Runner.ReleaseToken(token)
+	processed := make(chan bool, 1)
+	processed <- true
+	return processed
+}
+
+func TestBatchProcessor_ProcessMessage(t *testing.T) {
+	runner := jobrunner.New(&log.NullLog{}, nil, nil, nil, jobrunner.RunnerConfig{MaxTokens: 1})
+	b := newBatch(&log.NullLog{}, 1)
+	b.stop = func() {}
+	next := &processorMock{Runner: runner}
+	p := batchProcessor{runner, next, b}
+
+	token := <-runner.Tokens
+	if delete := <-p.ProcessMessage(queue.Message{Body: "job1"}, token); !delete {
+		t.Errorf("message read before reaching the max not processed")
+	}
+	b.Take()
+	token = <-runner.Tokens
+	if delete := <-p.ProcessMessage(queue.Message{Body: "job2"}, token); delete {
+		t.Errorf("message read after reaching the max processed")
+	}
+	if len(next.msgs) != 1 || next.msgs[0].Body != "job1" {
+		t.Errorf("unexpected messages passed to the processor: %+v", next.msgs)
+	}
+	if n := len(runner.Tokens); n != 1 {
+		t.Errorf("token of the returned message not released, free tokens %d", n)
+	}
+}
+
+func TestRun_MaxChecks(t *testing.T) {
+	tests := []struct {
+		name           string
+		concurrentJobs int
+		maxChecks      int
+		// jobs are sent in order to the queue.
+		jobs []jobrunner.Job
+		// wantRuns is the number of checks run, and wantRun the checks that
+		// must be among them.
+		wantRuns int
+		wantRun  []string
+		// wantQueued is the number of messages left in the queue, that are
+		// the ones of the checks not run.
+		wantQueued int
+		wantStatus map[string]string
+	}{
+		{
+			name:           "CountsOnlyRunChecks",
+			concurrentJobs: 1,
+			maxChecks:      2,
+			jobs: []jobrunner.Job{
+				{CheckID: "invalid", Target: "example.com"},
+				{CheckID: "check1", Image: "vulcan-nmap:1", Target: "example.com"},
+				{CheckID: "check1", Image: "vulcan-nmap:1", Target: "example.com"},
+				{CheckID: "check2", Image: "vulcan-nmap:1", Target: "example.com"},
+				{CheckID: "check3", Image: "vulcan-nmap:1", Target: "example.com"},
+				{CheckID: "check4", Image: "vulcan-nmap:1", Target: "example.com"},
+			},
+			wantRuns:   2,
+			wantRun:    []string{"check1", "check2"},
+			wantQueued: 2,
+			wantStatus: map[string]string{
+				"invalid": stateupdater.StatusMalformed,
+				"check1":  stateupdater.StatusFinished,
+				"check2":  stateupdater.StatusFinished,
+				"check3":  "",
+				"check4":  "",
+			},
+		},
+		{
+			name:           "ReturnsMessagesOverTheMax",
+			concurrentJobs: 3,
+			maxChecks:      1,
+			jobs: []jobrunner.Job{
+				{CheckID: "check1", Image: "vulcan-nmap:1", Target: "example.com"},
+				{CheckID: "check2", Image: "vulcan-nmap:1", Target: "example.com"},
+				{CheckID: "check3", Image: "vulcan-nmap:1", Target: "example.com"},
+			},
+			wantRuns:   1,
+			wantQueued: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, err := testutil.NewEnv()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer env.Close()
+			for _, j := range tt.jobs {
+				if err := env.SendJob(j); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			var (
+				mu  sync.Mutex
+				ran []string
+			)
+			b := testutil.NewBackend(env.AgentAddr(), func(ctx context.Context, params backend.RunParams) (report.Report, error) {
+				mu.Lock()
+				ran = append(ran, params.CheckID)
+				mu.Unlock()
+				// Give time to the other messages to be read while the
+				// check runs.
+				time.Sleep(200 * time.Millisecond)
+				return report.Report{}, nil
+			})
+			cfg := env.Config()
+			cfg.Agent.ConcurrentJobs = tt.concurrentJobs
+			cfg.Agent.MaxChecks = tt.maxChecks
+			code := run(t, cfg, b)
+			if code != 0 {
+				t.Errorf("want exit code 0, got %d", code)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(ran) != tt.wantRuns {
+				t.Errorf("want %d checks run, got %v", tt.wantRuns, ran)
+			}
+			for _, id := range tt.wantRun {
+				if !strings.Contains(strings.Join(ran, ","), id) {
+					t.Errorf("check %s not run, run %v", id, ran)
+				}
+			}
+			queued := env.SQS.Messages(testutil.JobsQueue)
+			if len(queued) != tt.wantQueued {
+				t.Errorf("want %d messages in the queue, got %v", tt.wantQueued, queued)
+			}
+			// The messages read by a receive interrupted when the reader
+			// is stopped may be hidden, as in SQS, until their visibility
+			// timeout expires, so only the messages are checked and not
+			// their visibility.
+			for _, body := range queued {
+				var j jobrunner.Job
+				if err := json.Unmarshal([]byte(body), &j); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if strings.Contains(strings.Join(ran, ","), j.CheckID) {
+					t.Errorf("message of the check %s run left in the queue", j.CheckID)
+				}
+			}
+			for id, want := range tt.wantStatus {
+				status, err := env.Status(id)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if status != want {
+					t.Errorf("want status %q for %s, got %q", want, id, status)
+				}
+			}
+		})
+	}
+}
+
+// run runs an agent with the given config and backend and returns its exit
+// code.
+func run(t *testing.T, cfg config.Config, b backend.Backend) int {
+	t.Helper()
+	done := make(chan int, 1)
+	go func() {
+		done <- Run(cfg, b, &log.NullLog{})
+	}()
+	select {
+	case code := <-done:
+		return code
+	case <-time.After(30 * time.Second):
+		t.Fatalf("agent didn't exit")
+	}
+	return 0
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package agent
+
+import (
+	"github.com/adevinta/vulcan-agent/cloudwatch"
+	"github.com/adevinta/vulcan-agent/jobrunner"
+	"github.com/adevinta/vulcan-agent/notify"
+	"github.com/adevinta/vulcan-agent/queue"
+	"github.com/adevinta/vulcan-agent/stateupdater"
+)
+
+// cloudWatchUpdater decorates a StateUpdater counting the checks that
+// finish, by status, in the metrics published to CloudWatch.
+type cloudWatchUpdater struct {
+	notify.StateUpdater
+	pub *cloudwatch.Publisher
+}
+
+func (u cloudWatchUpdater) UpdateState(s stateupdater.CheckState) error {
+	if err := u.StateUpdater.UpdateState(s); err != nil {
+		return err
+	}
+	if s.Status != nil && stateupdater.IsFinal(*s.Status) {
+		u.pub.CheckCompleted(*s.Status)
+	}
+	return nil
+}
+
+// cloudWatchProcessor decorates the Runner counting the messages received
+// from the queue in the metrics published to CloudWatch.
+type cloudWatchProcessor struct {
+	*jobrunner.Runner
+	pub *cloudwatch.Publisher
+}
+
+func (p cloudWatchProcessor) ProcessMessage(msg queue.Message, token interface{}) <-chan bool {
+	p.pub.MessageReceived()
+	return p.Runner.ProcessMessage(msg, token)
+}
+
+// cloudWatchStats returns the func that gets the state of the agent
+// published to CloudWatch.
+func cloudWatchStats(jrunner *jobrunner.Runner, qr queue.Reader) func() cloudwatch.Stats {
+	return func() cloudwatch.Stats {
+		s := cloudwatch.Stats{
+			Capacity:         jrunner.Capacity(),
+			ChecksRunning:    jrunner.ChecksRunning(),
+			MessagesInFlight: -1,
+		}
+		if p, ok := qr.(interface{ ProcessingMessages() int }); ok {
+			s.MessagesInFlight = p.ProcessingMessages()
+		}
+		return s
+	}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package agent
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/jobrunner"
+	"github.com/adevinta/vulcan-agent/queue"
+)
+
+// diagnostics contains the components whose state is published in the expvar
+// variables. The variables can only be published once per process, so they
+// read the components of the agent running from this value.
+var diagnostics atomic.Value
+
+var publishVars sync.Once
+
+type diagnosticsState struct {
+	cfg    config.Config
+	runner *jobrunner.Runner
+	reader queue.Reader
+}
+
+// newDiagnosticsServer returns a server that exposes the pprof profiles and
+// the expvar variables, including the given config, with the values of its
+// secret params redacted, the state of the pool of tokens of the given runner
+// and the stats of the given queue reader.
+func newDiagnosticsServer(addr string, cfg config.Config, jrunner *jobrunner.Runner, qr queue.Reader) *http.Server {
+	diagnostics.Store(diagnosticsState{cfg: config.Redact(cfg), runner: jrunner, reader: qr})
+	publishVars.Do(func() {
+		expvar.Publish("goroutines", expvar.Func(func() interface{} {
+			return runtime.NumGoroutine()
+		}))
+		expvar.Publish("tokens", expvar.Func(tokensVar))
+		expvar.Publish("queue", expvar.Func(queueVar))
+		expvar.Publish("config", expvar.Func(configVar))
+	})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return &http.Server{Addr: addr, Handler: mux}
+}
+
+func tokensVar() interface{} {
+	s := diagnostics.Load().(diagnosticsState)
+	return map[string]int{
+		"capacity":         s.runner.Capacity(),
+		"free":             len(s.runner.FreeTokens()),
+		"idle":             s.runner.IdleTokens(),
+		"checks_running":   s.runner.ChecksRunning(),
+		"checks_uploading": s.runner.ChecksUploading(),
+		"duplicate_jobs":   int(s.runner.DuplicateJobs()),
+	}
+}
+
+func queueVar() interface{} {
+	s := diagnostics.Load().(diagnosticsState)
+	stats := map[string]interface{}{
+		"last_message_received": s.reader.LastMessageReceived(),
+	}
+	if p, ok := s.reader.(interface{ ProcessingMessages() int }); ok {
+		stats["processing_messages"] = p.ProcessingMessages()
+	}
+	return stats
+}
+
+func configVar() interface{} {
+	return diagnostics.Load().(diagnosticsState).cfg
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/jobrunner"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/google/go-cmp/cmp"
+)
+
+type readerMock struct {
+	last       *time.Time
+	processing int
+}
+
+func (r *readerMock) StartReading(ctx context.Context) <-chan error {
+	return nil
+}
+
+func (r *readerMock) LastMessageReceived() *time.Time {
+	return r.last
+}
+
+func (r *readerMock) ProcessingMessages() int {
+	return r.processing
+}
+
+func TestDiagnosticsServer(t *testing.T) {
+	cfg := config.Config{
+		Agent: config.AgentConfig{LogLevel: "debug"},
+		API:   config.APIConfig{Auth: config.APIAuthConfig{Token: "api-token"}},
+		Check: config.CheckConfig{Vars: map[string]string{"GITHUB_TOKEN": "github-token"}},
+		AWS:   config.AWSConfig{Credentials: config.AWSCredentialsConfig{AccessKeyID: "id", SecretAccessKey: "aws-secret"}},
+	}
+	runner := jobrunner.New(&log.NullLog{}, nil, nil, nil, jobrunner.RunnerConfig{MaxTokens: 3})
+	// Take a token as the queue reader does while it waits for messages.
+	<-runner.Tokens
+	last := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	srv := httptest.NewServer(newDiagnosticsServer("", cfg, runner, &readerMock{last: &last, processing: 1}).Handler)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/debug/vars")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var vars struct {
+		Goroutines int                    `json:"goroutines"`
+		Tokens     map[string]int         `json:"tokens"`
+		Queue      map[string]interface{} `json:"queue"`
+		Config     config.Config          `json:"config"`
+	}
+	if err := json.Unmarshal(body, &vars); err != nil {
+		t.Fatalf("invalid vars %s: %v", body, err)
+	}
+
+	if vars.Goroutines < 1 {
+		t.Errorf("invalid number of goroutines %d", vars.Goroutines)
+	}
+	wantTokens := map[string]int{
+		"capacity":         3,
+		"free":             2,
+		"idle":             1,
+		"checks_running":   0,
+		"checks_uploading": 0,
+		"duplicate_jobs":   0,
+	}
+	if diff := cmp.Diff(wantTokens, vars.Tokens); diff != "" {
+		t.Errorf("tokens mismatch (-want +got):\n%s", diff)
+	}
+	wantQueue := map[string]interface{}{
+		"last_message_received": last.Format(time.RFC3339),
+		"processing_messages":   float64(1),
+	}
+	if diff := cmp.Diff(wantQueue, vars.Queue); diff != "" {
+		t.Errorf("queue mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(config.Redact(cfg), vars.Config); diff != "" {
+		t.Errorf("config mismatch (-want +got):\n%s", diff)
+	}
+	for _, secret := range []string{"api-token", "github-token", "aws-secret"} {
+		if strings.Contains(string(body), secret) {
+			t.Errorf("secret %q reported", secret)
+		}
+	}
+
+	resp, err = http.Get(srv.URL + "/debug/pprof/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("want pprof status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+}
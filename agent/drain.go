@@ -0,0 +1,96 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package agent
+
+import (
+	"sync"
+	"time"
+)
+
+// drainer coordinates the drain of the agent: when it's started the agent
+// stops reading new checks and waits for the running ones to finish until
+// the deadline, if any, is reached. Then the checks are aborted or, if the
+// drain was started with DrainUntil, stopped to be run again.
+type drainer struct {
+	timeout time.Duration
+	started chan struct{}
+
+	mu       sync.Mutex
+	draining bool
+	deadline *time.Time
+	requeue  bool
+}
+
+// newDrainer returns a drainer with the given timeout, 0 means waiting for
+// the running checks without a deadline.
+func newDrainer(timeout time.Duration) *drainer {
+	return &drainer{timeout: timeout, started: make(chan struct{})}
+}
+
+// Drain starts the drain. Calling it more than once has no effect.
+func (d *drainer) Drain() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.start(time.Time{})
+}
+
+// DrainUntil starts the drain, if it's not started yet, making the checks
+// that are still running at the given deadline to be run again instead of
+// aborted. The deadline is only used if it's earlier than the one defined by
+// the drain timeout. A zero deadline means using only the drain timeout.
+func (d *drainer) DrainUntil(deadline time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.requeue = true
+	d.start(deadline)
+}
+
+func (d *drainer) start(deadline time.Time) {
+	if d.draining {
+		return
+	}
+	d.draining = true
+	if d.timeout > 0 {
+		timeout := time.Now().Add(d.timeout)
+		if deadline.IsZero() || timeout.Before(deadline) {
+			deadline = timeout
+		}
+	}
+	if !deadline.IsZero() {
+		d.deadline = &deadline
+	}
+	close(d.started)
+}
+
+// Requeue returns true if the checks running at the deadline must be run
+// again instead of aborted.
+func (d *drainer) Requeue() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.requeue
+}
+
+// Draining returns true if the drain started and its deadline.
+func (d *drainer) Draining() (bool, *time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.draining, d.deadline
+}
+
+// Started returns a channel that is closed when the drain starts.
+func (d *drainer) Started() <-chan struct{} {
+	return d.started
+}
+
+// Deadline returns a channel that is written when the deadline of the drain
+// is reached. If the agent is not draining, or the drain has no deadline, the
+// channel is never written.
+func (d *drainer) Deadline() <-chan time.Time {
+	_, deadline := d.Draining()
+	if deadline == nil {
+		return nil
+	}
+	return time.After(time.Until(*deadline))
+}
@@ -0,0 +1,42 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package agent
+
+import (
+	"github.com/adevinta/vulcan-agent/events"
+	"github.com/adevinta/vulcan-agent/notify"
+	"github.com/adevinta/vulcan-agent/stateupdater"
+)
+
+// eventsUpdater decorates a StateUpdater publishing the updates of the state
+// of the checks, and the uploads of their reports and logs, as events.
+type eventsUpdater struct {
+	notify.StateUpdater
+	bus *events.Bus
+}
+
+func (u eventsUpdater) UpdateState(s stateupdater.CheckState) error {
+	if err := u.StateUpdater.UpdateState(s); err != nil {
+		return err
+	}
+	if s.Status != nil || s.Progress != nil {
+		e := events.Event{Type: events.TypeCheckState, CheckID: s.ID, Progress: s.Progress}
+		if s.Status != nil {
+			e.Status = *s.Status
+		}
+		u.bus.Publish(e)
+	}
+	if s.Report != nil || s.Raw != nil {
+		e := events.Event{Type: events.TypeCheckUploaded, CheckID: s.ID}
+		if s.Report != nil {
+			e.Report = *s.Report
+		}
+		if s.Raw != nil {
+			e.Raw = *s.Raw
+		}
+		u.bus.Publish(e)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/adevinta/vulcan-agent/api"
+	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/adevinta/vulcan-agent/jobrunner"
+	"github.com/adevinta/vulcan-agent/queue"
+	"github.com/adevinta/vulcan-agent/results"
+)
+
+// maxIdleTokens is the max number of tokens that can be out of the pool of
+// the runner without being held by a check. The queue reader holds one of
+// them while it waits for new messages.
+const maxIdleTokens = 1
+
+// newProbes returns the liveness and readiness probes of the agent API. The
+// readiness probes only include the dependencies that can be checked.
+func newProbes(b backend.Backend, qr queue.Reader, uploader results.Sink, jrunner *jobrunner.Runner) (liveness, readiness map[string]api.Probe) {
+	liveness = map[string]api.Probe{
+		"tokens": api.ProbeFunc(func(ctx context.Context) error {
+			if n := jrunner.IdleTokens(); n > maxIdleTokens {
+				return fmt.Errorf("token pool wedged: %d tokens not held by any check", n)
+			}
+			return nil
+		}),
+	}
+	readiness = map[string]api.Probe{}
+	if p, ok := b.(backend.Pinger); ok {
+		readiness["backend"] = p
+	}
+	if p, ok := qr.(api.Probe); ok {
+		readiness["queue"] = p
+	}
+	if p, ok := uploader.(api.Probe); ok {
+		readiness["results"] = p
+	}
+	return liveness, readiness
+}
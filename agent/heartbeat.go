@@ -0,0 +1,44 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package agent
+
+import (
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/heartbeat"
+)
+
+const heartbeatTimeout = 10 * time.Second
+
+// Version is the version of the agent reported in the heartbeats. It's set
+// at build time with:
+//
+//	-ldflags "-X github.com/adevinta/vulcan-agent/agent.Version=<version>"
+var Version = "dev"
+
+// newHeartbeatStore builds the store defined in the config. It returns nil
+// if the heartbeats are disabled.
+func newHeartbeatStore(cfg config.HeartbeatConfig) (heartbeat.Store, error) {
+	switch {
+	case cfg.DynamoDBTable != "":
+		ttl := time.Duration(cfg.TTL) * time.Second
+		if ttl <= 0 {
+			ttl = 3 * heartbeatInterval(cfg)
+		}
+		return heartbeat.NewDynamoDBStore(cfg.DynamoDBTable, cfg.DynamoDBRegion, cfg.DynamoDBEndpoint, ttl)
+	case cfg.Endpoint != "":
+		return heartbeat.NewHTTPStore(cfg.Endpoint, heartbeatTimeout), nil
+	default:
+		return nil, nil
+	}
+}
+
+func heartbeatInterval(cfg config.HeartbeatConfig) time.Duration {
+	if cfg.Interval <= 0 {
+		return config.DefaultHeartbeatInterval * time.Second
+	}
+	return time.Duration(cfg.Interval) * time.Second
+}
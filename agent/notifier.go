@@ -0,0 +1,31 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package agent
+
+import (
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/notify"
+)
+
+// newNotifier builds a notifier that sends the events to the webhooks and
+// chats defined in the config. It returns nil if none is defined.
+func newNotifier(cfg config.NotificationsConfig, l log.Logger) (*notify.Notifier, error) {
+	var senders []notify.Sender
+	for _, w := range cfg.Webhooks {
+		senders = append(senders, notify.NewWebhook(l, w))
+	}
+	for _, c := range cfg.Chats {
+		chat, err := notify.NewChat(l, c)
+		if err != nil {
+			return nil, err
+		}
+		senders = append(senders, chat)
+	}
+	if len(senders) == 0 {
+		return nil, nil
+	}
+	return notify.NewNotifier(l, senders...), nil
+}
@@ -0,0 +1,44 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package agent
+
+import (
+	"context"
+
+	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/notify"
+	"github.com/adevinta/vulcan-agent/stateupdater"
+)
+
+// reapOrphans removes the checks left running by the previous executions of
+// the agent, if the backend supports it, and sets their status to FAILED if
+// configured so. The errors are only logged because they must not prevent
+// the agent from starting.
+func reapOrphans(cfg config.AgentConfig, l log.Logger, b backend.Backend, updater notify.StateUpdater) {
+	if !cfg.ReapOrphans {
+		return
+	}
+	r, ok := b.(backend.OrphanReaper)
+	if !ok {
+		l.Infof("the backend does not support removing orphan checks")
+		return
+	}
+	checks, err := r.ReapOrphans(context.Background())
+	if err != nil {
+		l.Errorf("error removing orphan checks: %+v", err)
+		return
+	}
+	if !cfg.FailOrphans {
+		return
+	}
+	status := stateupdater.StatusFailed
+	for _, id := range checks {
+		if err := updater.UpdateState(stateupdater.CheckState{ID: id, Status: &status}); err != nil {
+			l.Errorf("error setting the status of orphan check %s: %+v", id, err)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package agent
+
+// The queue readers built into the agent register themselves in the queue
+// registry when their packages are imported.
+import (
+	_ "github.com/adevinta/vulcan-agent/queue/pubsub"
+	_ "github.com/adevinta/vulcan-agent/queue/servicebus"
+	_ "github.com/adevinta/vulcan-agent/queue/sqs"
+	_ "github.com/adevinta/vulcan-agent/scheduler"
+)
@@ -0,0 +1,48 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package agent
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/queue/eventbridge"
+	"github.com/adevinta/vulcan-agent/queue/pubsub"
+	"github.com/adevinta/vulcan-agent/queue/servicebus"
+	"github.com/adevinta/vulcan-agent/queue/sns"
+	"github.com/adevinta/vulcan-agent/queue/sqs"
+	"github.com/adevinta/vulcan-agent/stateupdater"
+)
+
+// newStateWriter builds the writer used to send the state updates of the
+// checks to the backend defined in the config. If the returned writer
+// implements io.Closer it must be closed to send the pending updates.
+func newStateWriter(cfg config.Config, l log.Logger) (stateupdater.QueueWriter, error) {
+	switch cfg.StateUpdater.Backend {
+	case config.StateBackendSQS, "":
+		w, err := sqs.NewWriter(cfg.SQSWriter.ARN, cfg.SQSWriter.Endpoint, cfg.SQSWriter.Credentials, l)
+		if err != nil {
+			return nil, err
+		}
+		w.ContentBasedDedup = cfg.SQSWriter.ContentBasedDedup
+		if cfg.SQSWriter.BatchSize <= 1 {
+			return w, nil
+		}
+		interval := time.Duration(cfg.SQSWriter.FlushIntervalMs) * time.Millisecond
+		return sqs.NewBatchWriter(l, w, cfg.SQSWriter.BatchSize, interval, cfg.SQSWriter.BufferSize), nil
+	case config.StateBackendSNS:
+		return sns.NewWriter(cfg.StateUpdater.SNS.ARN, cfg.StateUpdater.SNS.Endpoint, l)
+	case config.StateBackendEventBridge:
+		return eventbridge.NewWriter(cfg.StateUpdater.EventBridge)
+	case config.StateBackendPubSub:
+		return pubsub.NewWriter(cfg.StateUpdater.PubSub)
+	case config.StateBackendServiceBus:
+		return servicebus.NewWriter(cfg.StateUpdater.ServiceBus)
+	default:
+		return nil, fmt.Errorf("invalid state updater backend %q", cfg.StateUpdater.Backend)
+	}
+}
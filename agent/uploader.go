@@ -0,0 +1,66 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package agent
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/results"
+	"github.com/adevinta/vulcan-agent/results/localdir"
+	s3results "github.com/adevinta/vulcan-agent/results/s3"
+	"github.com/adevinta/vulcan-agent/results/sarif"
+	"github.com/adevinta/vulcan-agent/results/webhook"
+	"github.com/adevinta/vulcan-agent/retryer"
+)
+
+// newUploader builds the sink defined in the uploader config and, if any
+// additional sinks are configured, a composite sink that sends the results
+// to all of them.
+func newUploader(cfg config.UploaderConfig, l log.Logger) (results.Sink, error) {
+	primary, err := newSink(cfg, l)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Sinks) == 0 {
+		return primary, nil
+	}
+	var secondary []results.Sink
+	for i, sc := range cfg.Sinks {
+		s, err := newSink(sc, l)
+		if err != nil {
+			return nil, fmt.Errorf("uploader sink %d: %w", i, err)
+		}
+		secondary = append(secondary, s)
+	}
+	return results.NewMulti(l, primary, secondary...), nil
+}
+
+func newSink(cfg config.UploaderConfig, l log.Logger) (results.Sink, error) {
+	s, err := newBaseSink(cfg, l)
+	if err != nil || !cfg.SARIF {
+		return s, err
+	}
+	return sarif.NewSink(l, s), nil
+}
+
+func newBaseSink(cfg config.UploaderConfig, l log.Logger) (results.Sink, error) {
+	timeout := time.Duration(cfg.Timeout * int(time.Second))
+	re := retryer.NewRetryer(cfg.Retries, cfg.RetryInterval, l)
+	switch cfg.Type {
+	case config.UploaderTypeHTTP, "":
+		return results.New(cfg.Endpoint, re, timeout), nil
+	case config.UploaderTypeS3:
+		return s3results.New(cfg.S3, re)
+	case config.UploaderTypeWebhook:
+		return webhook.New(cfg.Endpoint, re, timeout), nil
+	case config.UploaderTypeLocal:
+		return localdir.New(l, cfg.LocalDir)
+	default:
+		return nil, fmt.Errorf("invalid uploader type %q", cfg.Type)
+	}
+}
@@ -5,10 +5,16 @@ Copyright 2019 Adevinta
 package api
 
 import (
+	"context"
+	"crypto/subtle"
 	"errors"
 	"fmt"
+	"io"
 	"time"
 
+	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/adevinta/vulcan-agent/events"
+	"github.com/adevinta/vulcan-agent/jobrunner"
 	"github.com/adevinta/vulcan-agent/log"
 	"github.com/adevinta/vulcan-agent/stateupdater"
 	report "github.com/adevinta/vulcan-report"
@@ -22,8 +28,88 @@ var (
 	// ErrStatusMandatory is returned when the API is asked to update the state
 	// of the check but no status is provided.
 	ErrStatusMandatory = errors.New("a check must inform always a stauts when updating its state")
+
+	// ErrDrainNotSupported is returned when the API is asked to drain the
+	// agent but it has no Drainer.
+	ErrDrainNotSupported = errors.New("drain not supported")
+
+	// ErrAbortNotSupported is returned when the API is asked to abort a
+	// check but it has no CheckAborter.
+	ErrAbortNotSupported = errors.New("abort not supported")
+
+	// ErrCheckNotRunning is returned when the API is asked to abort a check
+	// that is not running in the agent.
+	ErrCheckNotRunning = errors.New("check not running")
+
+	// ErrScanNotRunning is returned when the API is asked to abort a scan
+	// that has no checks running in the agent.
+	ErrScanNotRunning = errors.New("scan not running")
+
+	// ErrLogsNotSupported is returned when the API is asked for the logs of a
+	// check but the backend can not stream them.
+	ErrLogsNotSupported = errors.New("logs not supported")
+
+	// ErrExecNotSupported is returned when the API is asked to execute a
+	// command in a check but the debug endpoints are disabled or the backend
+	// can not execute commands.
+	ErrExecNotSupported = errors.New("exec not supported")
+
+	// ErrUnauthorized is returned when a request to the API does not contain
+	// a valid token.
+	ErrUnauthorized = errors.New("unauthorized")
+
+	// ErrEmptyCommand is returned when the API is asked to execute an empty
+	// command in a check.
+	ErrEmptyCommand = errors.New("empty command")
+
+	// ErrInvalidProgress is returned when a check reports a progress out of
+	// the range [0, 1].
+	ErrInvalidProgress = errors.New("progress must be between 0 and 1")
+
+	// ErrInvalidReport is returned when a check pushes a report that is not
+	// valid.
+	ErrInvalidReport = errors.New("invalid report")
+
+	// ErrPauseNotSupported is returned when the API is asked to pause or
+	// resume the agent but it has no Pauser.
+	ErrPauseNotSupported = errors.New("pause not supported")
+
+	// ErrIdleShutdownNotSupported is returned when the API is asked for the
+	// settings of the idle shutdown of the agent but it has no IdleLimiter.
+	ErrIdleShutdownNotSupported = errors.New("idle shutdown not supported")
+
+	// ErrInvalidIdleShutdown is returned when the API is asked to set a
+	// negative max time without reading messages.
+	ErrInvalidIdleShutdown = errors.New("max_no_msgs_interval can not be negative")
+
+	// ErrLogLevelNotSupported is returned when the API is asked for the
+	// level of the log of the agent but it has no LogLeveler.
+	ErrLogLevelNotSupported = errors.New("log level not supported")
+
+	// ErrInvalidLogLevel is returned when the API is asked to set an unknown
+	// log level or a negative duration.
+	ErrInvalidLogLevel = errors.New("invalid log level")
+
+	// ErrCapacityNotSupported is returned when the API is asked for the
+	// capacity of the agent but it has no CapacityStats.
+	ErrCapacityNotSupported = errors.New("capacity not supported")
+
+	// ErrEventsNotSupported is returned when the API is asked to stream the
+	// events of the agent but it has no EventSubscriber.
+	ErrEventsNotSupported = errors.New("events not supported")
+)
+
+// States of the agent reported by the API.
+const (
+	StateRunning  = "running"
+	StateDraining = "draining"
+	StatePaused   = "paused"
 )
 
+// PauseReason is the reason passed to the Pauser when the agent is paused
+// through the API.
+const PauseReason = "paused through the API"
+
 // CheckState holds the values related to the state of a check. The values
 // defined here the one written to the check states queue.
 type CheckState struct {
@@ -33,6 +119,14 @@ type CheckState struct {
 	Status   *string        `json:"status,omitempty"`
 }
 
+// CheckProgress holds the intermediate progress reported by a running check
+// and, optionally, the findings it found so far.
+type CheckProgress struct {
+	ID       string
+	Progress float32                `json:"progress"`
+	Findings []report.Vulnerability `json:"findings,omitempty"`
+}
+
 // Stats defines the general information that the API provides about the agent.
 type Stats struct {
 	// Timestamp of the last queue message received.
@@ -40,6 +134,71 @@ type Stats struct {
 	ChecksRunning       int        `json:"checks_running"`
 }
 
+// Status defines the state of the agent and the progress of the drain, if
+// the agent is draining.
+type Status struct {
+	State         string `json:"state"`
+	ChecksRunning int    `json:"checks_running"`
+	// DrainDeadline is the time when the checks still running are aborted.
+	// It's only set when the agent is draining with a deadline.
+	DrainDeadline *time.Time `json:"drain_deadline,omitempty"`
+	// PauseReasons are the reasons why the agent is not reading new checks,
+	// if it's paused.
+	PauseReasons []string `json:"pause_reasons,omitempty"`
+}
+
+// Capacity describes the capacity of the agent to run checks, in a form
+// suited for the external autoscalers.
+type Capacity struct {
+	// Capacity is the maximum number of tokens of the agent.
+	Capacity      int `json:"capacity"`
+	FreeTokens    int `json:"free_tokens"`
+	ChecksRunning int `json:"checks_running"`
+	// AvgCheckDuration is the average duration, in seconds, of the last
+	// checks run by the agent.
+	AvgCheckDuration float64 `json:"avg_check_duration"`
+	// MessagesInFlight is the number of messages read from the queue that
+	// are still being processed. It's only set if the queue reader can
+	// provide it.
+	MessagesInFlight *int `json:"messages_in_flight,omitempty"`
+	// Accepting is false when the agent is not taking new checks because
+	// it's draining or paused.
+	Accepting bool `json:"accepting"`
+}
+
+// IdleShutdown defines when the agent exits because it's not reading
+// messages.
+type IdleShutdown struct {
+	// MaxNoMsgsInterval is the time, in seconds, the agent can run without
+	// reading messages. 0 means the agent never exits because of that.
+	MaxNoMsgsInterval int `json:"max_no_msgs_interval"`
+}
+
+// LogLevel defines the level of the log of the agent.
+type LogLevel struct {
+	// Level is one of debug, info, warn or error.
+	Level string `json:"level"`
+	// Duration is the time, in seconds, the level is kept before restoring
+	// the level of the config. 0 means the level is kept until it's changed
+	// again.
+	Duration int `json:"duration,omitempty"`
+	// RevertAt is the time when the level of the config will be restored,
+	// if the level is temporary.
+	RevertAt *time.Time `json:"revert_at,omitempty"`
+}
+
+// Check describes a check running in the agent.
+type Check struct {
+	ID        string    `json:"check_id"`
+	ScanID    string    `json:"scan_id,omitempty"`
+	Checktype string    `json:"checktype"`
+	Image     string    `json:"image"`
+	Target    string    `json:"target"`
+	StartTime time.Time `json:"start_time"`
+	// Elapsed is the number of seconds the check has been running.
+	Elapsed int64 `json:"elapsed"`
+}
+
 // CheckStateUpdater defines the method needed by the API in order to send check
 // updates messages to the corresponding queue.
 type CheckStateUpdater interface {
@@ -54,11 +213,119 @@ type AgentStats interface {
 	LastMessageReceived() *time.Time
 }
 
+// Drainer defines the methods needed by the API to drain the agent: stop
+// reading new checks, let the running ones finish and exit.
+type Drainer interface {
+	Drain()
+	// Draining returns true if the agent is draining and the deadline to
+	// finish the running checks, if any.
+	Draining() (bool, *time.Time)
+}
+
+// Pauser defines the methods needed by the API to stop and restart reading
+// new checks without affecting the running ones.
+type Pauser interface {
+	Pause(reason string)
+	Resume(reason string)
+	// Paused returns the reasons why the agent is paused, if any.
+	Paused() []string
+}
+
+// IdleLimiter defines the methods needed by the API to change the max time
+// the agent can run without reading messages.
+type IdleLimiter interface {
+	Max() time.Duration
+	SetMax(max time.Duration)
+}
+
+// LogLeveler defines the methods needed by the API to change the level of
+// the log of the agent.
+type LogLeveler interface {
+	Level() (string, *time.Time)
+	Set(level string, d time.Duration) error
+}
+
+// CheckAborter defines the methods needed by the API to abort the checks
+// running in the agent.
+type CheckAborter interface {
+	CheckRunning(ID string) bool
+	AbortCheck(ID string)
+	// AbortScan aborts the running checks of the scan with the given ID and
+	// returns their IDs.
+	AbortScan(scanID string) []string
+}
+
+// CheckLister defines the methods needed by the API to list the checks
+// running in the agent.
+type CheckLister interface {
+	RunningChecks() []jobrunner.RunningCheck
+	RunningCheck(ID string) (jobrunner.RunningCheck, bool)
+}
+
+// CapacityStats defines the methods needed by the API to report the capacity
+// of the agent.
+type CapacityStats interface {
+	Capacity() int
+	FreeSlots() int
+	ChecksRunning() int
+	AvgCheckDuration() time.Duration
+	Paused() []string
+}
+
+// InFlightCounter defines the method needed by the API to report the number
+// of messages read from the queue that are still being processed.
+type InFlightCounter interface {
+	ProcessingMessages() int
+}
+
+// EventSubscriber defines the method needed by the API to stream the events
+// of the agent.
+type EventSubscriber interface {
+	Subscribe(f events.Filter) (<-chan events.Event, func())
+}
+
 // API defines the methods of the API that the agent exposes to the outside.
 type API struct {
 	stateUpdate CheckStateUpdater
 	agentStats  AgentStats
 	log         log.Logger
+	// Drainer, if not nil, allows to drain the agent through the API.
+	Drainer Drainer
+	// Pauser, if not nil, allows to pause and resume the agent through the
+	// API.
+	Pauser Pauser
+	// IdleLimit, if not nil, allows to change the max time without reading
+	// messages through the API.
+	IdleLimit IdleLimiter
+	// Leveler, if not nil, allows to change the level of the log through
+	// the API.
+	Leveler LogLeveler
+	// Aborter, if not nil, allows to abort the running checks through the
+	// API.
+	Aborter CheckAborter
+	// Lister, if not nil, allows to list the running checks through the
+	// API.
+	Lister CheckLister
+	// Logs, if not nil, allows to stream the output of the running checks
+	// through the API.
+	Logs backend.LogStreamer
+	// Execer, if not nil, allows to execute commands inside the running
+	// checks through the API. The requests must include the ExecToken.
+	Execer    backend.Execer
+	ExecToken string
+	// LivenessProbes check the health of the agent itself and
+	// ReadinessProbes the dependencies it needs to run checks, indexed by
+	// the name reported in the results.
+	LivenessProbes  map[string]Probe
+	ReadinessProbes map[string]Probe
+	// CapacityStats, if not nil, allows to get the capacity of the agent
+	// through the API, and InFlight, if not nil, adds to it the messages in
+	// flight.
+	CapacityStats CapacityStats
+	InFlight      InFlightCounter
+	// Events, if not nil, allows to stream the events of the agent through
+	// the API.
+	Events EventSubscriber
 }
 
 // New returns an API filled with the provided check state updater and the agent
@@ -126,6 +393,12 @@ func (a *API) CheckUpdate(c CheckState) error {
 		ustate.Progress = c.Progress
 	}
 	err := a.stateUpdate.UpdateState(ustate)
+	// The final status of a check is sent only once, so sending it again is
+	// not an error.
+	if errors.Is(err, stateupdater.ErrDuplicateStatus) {
+		a.log.Infof("discarding duplicated update of check %s: %+v", c.ID, err)
+		return nil
+	}
 	if err != nil {
 		err = fmt.Errorf("error updating check state, checkID %s, error: %w", c.ID, err)
 		a.log.Errorf("%+v", err)
@@ -134,9 +407,318 @@ func (a *API) CheckUpdate(c CheckState) error {
 	return nil
 }
 
+// CheckReport attends the request sent by a check to push its final report.
+// The report is validated and then uploaded, and the state of the check
+// updated with the status of the report, as CheckUpdate does.
+func (a *API) CheckReport(ID string, r report.Report) error {
+	if r.CheckID != ID {
+		return fmt.Errorf("%w: check id %q does not match %q", ErrInvalidReport, r.CheckID, ID)
+	}
+	if err := r.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidReport, err)
+	}
+	status := r.Status
+	return a.CheckUpdate(CheckState{ID: ID, Report: &r, Status: &status})
+}
+
+// CheckProgress attends the request sent by a running check to report its
+// progress. The progress is sent as a state update of the check with the
+// RUNNING status. The findings, if any, are uploaded as a partial report of
+// the check, that is replaced by the final one when the check finishes.
+func (a *API) CheckProgress(p CheckProgress) error {
+	if p.ID == "" {
+		return ErrCheckIDMandatory
+	}
+	if !(p.Progress >= 0 && p.Progress <= 1) {
+		return ErrInvalidProgress
+	}
+	if a.Lister == nil {
+		return ErrCheckNotRunning
+	}
+	rc, ok := a.Lister.RunningCheck(p.ID)
+	if !ok {
+		return ErrCheckNotRunning
+	}
+	status := stateupdater.StatusRunning
+	ustate := stateupdater.CheckState{
+		ID:       p.ID,
+		Status:   &status,
+		Progress: &p.Progress,
+	}
+	if len(p.Findings) > 0 {
+		partial := report.Report{
+			CheckData: report.CheckData{
+				CheckID:       rc.CheckID,
+				ChecktypeName: rc.Checktype,
+				Target:        rc.Target,
+				StartTime:     rc.StartTime,
+				Status:        status,
+			},
+			ResultData: report.ResultData{
+				Vulnerabilities: p.Findings,
+			},
+		}
+		link, err := a.stateUpdate.UpdateCheckReport(p.ID, rc.StartTime, partial)
+		if err != nil {
+			err = fmt.Errorf("error uploading partial check report, checkID %s, error: %w", p.ID, err)
+			a.log.Errorf("%+v", err)
+			return err
+		}
+		ustate.Report = &link
+	}
+	if err := a.stateUpdate.UpdateState(ustate); err != nil {
+		err = fmt.Errorf("error updating check progress, checkID %s, error: %w", p.ID, err)
+		a.log.Errorf("%+v", err)
+		return err
+	}
+	return nil
+}
+
 // Stats exposed some stats about the agent to the outside world.
 func (a *API) Stats() (Stats, error) {
 	last := a.agentStats.LastMessageReceived()
 	n := a.agentStats.ChecksRunning()
 	return Stats{LastMessageReceived: last, ChecksRunning: n}, nil
 }
+
+// Drain starts draining the agent and returns its status.
+func (a *API) Drain() (Status, error) {
+	if a.Drainer == nil {
+		return Status{}, ErrDrainNotSupported
+	}
+	a.Drainer.Drain()
+	return a.Status()
+}
+
+// Status returns the state of the agent.
+func (a *API) Status() (Status, error) {
+	s := Status{
+		State:         StateRunning,
+		ChecksRunning: a.agentStats.ChecksRunning(),
+	}
+	if a.Pauser != nil {
+		if reasons := a.Pauser.Paused(); len(reasons) > 0 {
+			s.State = StatePaused
+			s.PauseReasons = reasons
+		}
+	}
+	if a.Drainer != nil {
+		if draining, deadline := a.Drainer.Draining(); draining {
+			s.State = StateDraining
+			s.DrainDeadline = deadline
+		}
+	}
+	return s, nil
+}
+
+// Pause stops the agent from reading new checks, without affecting the
+// running ones, and returns its status.
+func (a *API) Pause() (Status, error) {
+	if a.Pauser == nil {
+		return Status{}, ErrPauseNotSupported
+	}
+	a.Pauser.Pause(PauseReason)
+	return a.Status()
+}
+
+// Resume makes the agent read new checks again, if it was paused through the
+// API, and returns its status. The agent keeps paused if there are other
+// reasons to, for instance, the results service being unavailable.
+func (a *API) Resume() (Status, error) {
+	if a.Pauser == nil {
+		return Status{}, ErrPauseNotSupported
+	}
+	a.Pauser.Resume(PauseReason)
+	return a.Status()
+}
+
+// IdleShutdown returns when the agent exits because it's not reading
+// messages.
+func (a *API) IdleShutdown() (IdleShutdown, error) {
+	if a.IdleLimit == nil {
+		return IdleShutdown{}, ErrIdleShutdownNotSupported
+	}
+	max := a.IdleLimit.Max()
+	return IdleShutdown{MaxNoMsgsInterval: int(max / time.Second)}, nil
+}
+
+// SetIdleShutdown changes when the agent exits because it's not reading
+// messages and returns the new settings.
+func (a *API) SetIdleShutdown(s IdleShutdown) (IdleShutdown, error) {
+	if a.IdleLimit == nil {
+		return IdleShutdown{}, ErrIdleShutdownNotSupported
+	}
+	if s.MaxNoMsgsInterval < 0 {
+		return IdleShutdown{}, ErrInvalidIdleShutdown
+	}
+	a.IdleLimit.SetMax(time.Duration(s.MaxNoMsgsInterval) * time.Second)
+	return a.IdleShutdown()
+}
+
+// LogLevel returns the current level of the log of the agent.
+func (a *API) LogLevel() (LogLevel, error) {
+	if a.Leveler == nil {
+		return LogLevel{}, ErrLogLevelNotSupported
+	}
+	level, revertAt := a.Leveler.Level()
+	l := LogLevel{Level: level, RevertAt: revertAt}
+	if revertAt != nil {
+		// The duration is rounded up so a level about to be reverted is not
+		// reported as permanent.
+		l.Duration = int((time.Until(*revertAt) + time.Second - 1) / time.Second)
+	}
+	return l, nil
+}
+
+// SetLogLevel changes the level of the log of the agent, for the given
+// duration if it's greater than 0, and returns the new level.
+func (a *API) SetLogLevel(l LogLevel) (LogLevel, error) {
+	if a.Leveler == nil {
+		return LogLevel{}, ErrLogLevelNotSupported
+	}
+	if l.Duration < 0 {
+		return LogLevel{}, fmt.Errorf("%w: duration can not be negative", ErrInvalidLogLevel)
+	}
+	err := a.Leveler.Set(l.Level, time.Duration(l.Duration)*time.Second)
+	if errors.Is(err, log.ErrInvalidLevel) {
+		return LogLevel{}, fmt.Errorf("%w: %v", ErrInvalidLogLevel, err)
+	}
+	if err != nil {
+		return LogLevel{}, err
+	}
+	return a.LogLevel()
+}
+
+// Capacity returns the capacity of the agent to run new checks.
+func (a *API) Capacity() (Capacity, error) {
+	if a.CapacityStats == nil {
+		return Capacity{}, ErrCapacityNotSupported
+	}
+	c := Capacity{
+		Capacity:         a.CapacityStats.Capacity(),
+		FreeTokens:       a.CapacityStats.FreeSlots(),
+		ChecksRunning:    a.CapacityStats.ChecksRunning(),
+		AvgCheckDuration: a.CapacityStats.AvgCheckDuration().Seconds(),
+		Accepting:        len(a.CapacityStats.Paused()) == 0,
+	}
+	if a.InFlight != nil {
+		n := a.InFlight.ProcessingMessages()
+		c.MessagesInFlight = &n
+	}
+	if a.Drainer != nil {
+		if draining, _ := a.Drainer.Draining(); draining {
+			c.Accepting = false
+			c.FreeTokens = 0
+		}
+	}
+	return c, nil
+}
+
+// AbortCheck aborts a running check. The check is stopped and its state set
+// to ABORTED.
+func (a *API) AbortCheck(ID string) error {
+	if a.Aborter == nil {
+		return ErrAbortNotSupported
+	}
+	if !a.Aborter.CheckRunning(ID) {
+		return ErrCheckNotRunning
+	}
+	a.log.Infof("aborting check %s", ID)
+	a.Aborter.AbortCheck(ID)
+	return nil
+}
+
+// AbortScan aborts all the running checks that belong to a scan and returns
+// their IDs. The checks are stopped and their state set to ABORTED.
+func (a *API) AbortScan(ID string) ([]string, error) {
+	if a.Aborter == nil {
+		return nil, ErrAbortNotSupported
+	}
+	ids := a.Aborter.AbortScan(ID)
+	if len(ids) == 0 {
+		return nil, ErrScanNotRunning
+	}
+	a.log.Infof("aborted checks %v of scan %s", ids, ID)
+	return ids, nil
+}
+
+// RunningChecks returns the checks running in the agent.
+func (a *API) RunningChecks() ([]Check, error) {
+	checks := []Check{}
+	if a.Lister == nil {
+		return checks, nil
+	}
+	for _, rc := range a.Lister.RunningChecks() {
+		checks = append(checks, runningCheck(rc))
+	}
+	return checks, nil
+}
+
+// RunningCheck returns the check with the given ID if it is running in the
+// agent.
+func (a *API) RunningCheck(ID string) (Check, error) {
+	if a.Lister == nil {
+		return Check{}, ErrCheckNotRunning
+	}
+	rc, ok := a.Lister.RunningCheck(ID)
+	if !ok {
+		return Check{}, ErrCheckNotRunning
+	}
+	return runningCheck(rc), nil
+}
+
+func runningCheck(rc jobrunner.RunningCheck) Check {
+	return Check{
+		ID:        rc.CheckID,
+		ScanID:    rc.ScanID,
+		Checktype: rc.Checktype,
+		Image:     rc.Image,
+		Target:    rc.Target,
+		StartTime: rc.StartTime,
+		Elapsed:   int64(time.Since(rc.StartTime).Seconds()),
+	}
+}
+
+// CheckLogs writes the output of a running check to the given writer. If
+// follow is true it keeps writing it until the check finishes or the context
+// is done.
+func (a *API) CheckLogs(ctx context.Context, ID string, follow bool, w io.Writer) error {
+	if a.Logs == nil {
+		return ErrLogsNotSupported
+	}
+	err := a.Logs.StreamLogs(ctx, ID, follow, w)
+	if errors.Is(err, backend.ErrCheckNotFound) {
+		return ErrCheckNotRunning
+	}
+	return err
+}
+
+// SubscribeEvents returns a channel receiving the events of the agent that
+// match the given filter, and the func to cancel the subscription.
+func (a *API) SubscribeEvents(f events.Filter) (<-chan events.Event, func(), error) {
+	if a.Events == nil {
+		return nil, nil, ErrEventsNotSupported
+	}
+	evts, cancel := a.Events.Subscribe(f)
+	return evts, cancel, nil
+}
+
+// ExecCheck executes a command inside a running check. The given token must
+// match the ExecToken of the API.
+func (a *API) ExecCheck(ctx context.Context, token, ID string, cmd []string) (backend.ExecResult, error) {
+	if a.Execer == nil || a.ExecToken == "" {
+		return backend.ExecResult{}, ErrExecNotSupported
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(a.ExecToken)) != 1 {
+		return backend.ExecResult{}, ErrUnauthorized
+	}
+	if len(cmd) == 0 {
+		return backend.ExecResult{}, ErrEmptyCommand
+	}
+	a.log.Infof("executing %q in check %s", cmd, ID)
+	res, err := a.Execer.Exec(ctx, ID, cmd)
+	if errors.Is(err, backend.ErrCheckNotFound) {
+		return backend.ExecResult{}, ErrCheckNotRunning
+	}
+	return res, err
+}
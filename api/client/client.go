@@ -0,0 +1,349 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+// Package client provides a client of the HTTP API of the agent, described in
+// api/http/openapi.yaml, so other services can call the agent without
+// writing their own HTTP code.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/adevinta/vulcan-agent/api"
+	httpapi "github.com/adevinta/vulcan-agent/api/http"
+	"github.com/adevinta/vulcan-agent/events"
+	report "github.com/adevinta/vulcan-report"
+)
+
+// Error is returned when the agent responds to a request with an error
+// status, e.g. 404 when a check is not running in the agent or 501 when the
+// agent doesn't support the operation.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("agent responded with status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("agent responded with status %d: %s", e.StatusCode, e.Message)
+}
+
+// StatusCode returns the status of the response of the agent if the given
+// error is an Error, or 0 otherwise.
+func StatusCode(err error) int {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.StatusCode
+	}
+	return 0
+}
+
+// Client calls the API of an agent.
+type Client struct {
+	url string
+	// Token is sent as a bearer token in the requests to the control
+	// endpoints, when the API authentication is enabled in the agent.
+	Token string
+	// HTTPClient is the client used to send the requests. If it's nil the
+	// http.DefaultClient is used. It must be configured with the client
+	// certificate when the agent authorizes them.
+	HTTPClient *http.Client
+}
+
+// New returns a Client of the API of the agent served in the given URL, e.g.
+// http://localhost:8080.
+func New(url string) *Client {
+	return &Client{url: strings.TrimSuffix(url, "/")}
+}
+
+// UpdateCheck updates the state of a check.
+func (c *Client) UpdateCheck(ctx context.Context, s api.CheckState) error {
+	return c.do(ctx, http.MethodPatch, "/check/"+url.PathEscape(s.ID), "", s, nil)
+}
+
+// CheckProgress reports the progress of a running check.
+func (c *Client) CheckProgress(ctx context.Context, p api.CheckProgress) error {
+	return c.do(ctx, http.MethodPatch, "/check/"+url.PathEscape(p.ID)+"/progress", "", p, nil)
+}
+
+// CheckReport pushes the report of a check.
+func (c *Client) CheckReport(ctx context.Context, ID string, r report.Report) error {
+	return c.do(ctx, http.MethodPost, "/check/"+url.PathEscape(ID)+"/report", "", r, nil)
+}
+
+// Stats returns the stats of the agent.
+func (c *Client) Stats(ctx context.Context) (api.Stats, error) {
+	var resp httpapi.StatsResponse
+	err := c.do(ctx, http.MethodGet, "/stats", "", nil, &resp)
+	return resp.Stats, err
+}
+
+// Status returns the state of the agent.
+func (c *Client) Status(ctx context.Context) (api.Status, error) {
+	var resp httpapi.StatusResponse
+	err := c.do(ctx, http.MethodGet, "/status", "", nil, &resp)
+	return resp.Status, err
+}
+
+// Capacity returns the capacity of the agent to run checks.
+func (c *Client) Capacity(ctx context.Context) (api.Capacity, error) {
+	var resp httpapi.CapacityResponse
+	err := c.do(ctx, http.MethodGet, "/capacity", "", nil, &resp)
+	return resp.Capacity, err
+}
+
+// Live returns the result of the liveness probes of the agent. The failed
+// probes are not returned as an error, but in the Health.
+func (c *Client) Live(ctx context.Context) (api.Health, error) {
+	return c.health(ctx, "/healthz")
+}
+
+// Ready returns the result of the readiness probes of the agent. The failed
+// probes are not returned as an error, but in the Health.
+func (c *Client) Ready(ctx context.Context) (api.Health, error) {
+	return c.health(ctx, "/readyz")
+}
+
+func (c *Client) health(ctx context.Context, path string) (api.Health, error) {
+	var h api.Health
+	err := c.do(ctx, http.MethodGet, path, "", nil, &h)
+	if StatusCode(err) == http.StatusServiceUnavailable && h.Status != "" {
+		err = nil
+	}
+	return h, err
+}
+
+// Drain makes the agent stop reading checks, wait for the running ones and
+// exit.
+func (c *Client) Drain(ctx context.Context) (api.Status, error) {
+	return c.control(ctx, "/drain")
+}
+
+// Pause makes the agent stop reading checks.
+func (c *Client) Pause(ctx context.Context) (api.Status, error) {
+	return c.control(ctx, "/pause")
+}
+
+// Resume makes a paused agent read checks again.
+func (c *Client) Resume(ctx context.Context) (api.Status, error) {
+	return c.control(ctx, "/resume")
+}
+
+func (c *Client) control(ctx context.Context, path string) (api.Status, error) {
+	var resp httpapi.StatusResponse
+	err := c.do(ctx, http.MethodPost, path, c.Token, nil, &resp)
+	return resp.Status, err
+}
+
+// IdleShutdown returns the max time the agent runs without reading messages.
+func (c *Client) IdleShutdown(ctx context.Context) (api.IdleShutdown, error) {
+	var resp httpapi.IdleShutdownResponse
+	err := c.do(ctx, http.MethodGet, "/idle-shutdown", c.Token, nil, &resp)
+	return resp.IdleShutdown, err
+}
+
+// SetIdleShutdown sets the max time the agent runs without reading messages.
+func (c *Client) SetIdleShutdown(ctx context.Context, s api.IdleShutdown) (api.IdleShutdown, error) {
+	var resp httpapi.IdleShutdownResponse
+	err := c.do(ctx, http.MethodPatch, "/idle-shutdown", c.Token, s, &resp)
+	return resp.IdleShutdown, err
+}
+
+// LogLevel returns the level of the log of the agent.
+func (c *Client) LogLevel(ctx context.Context) (api.LogLevel, error) {
+	var resp httpapi.LogLevelResponse
+	err := c.do(ctx, http.MethodGet, "/loglevel", c.Token, nil, &resp)
+	return resp.LogLevel, err
+}
+
+// SetLogLevel sets the level of the log of the agent.
+func (c *Client) SetLogLevel(ctx context.Context, l api.LogLevel) (api.LogLevel, error) {
+	var resp httpapi.LogLevelResponse
+	err := c.do(ctx, http.MethodPut, "/loglevel", c.Token, l, &resp)
+	return resp.LogLevel, err
+}
+
+// RunningChecks returns the checks running in the agent.
+func (c *Client) RunningChecks(ctx context.Context) ([]api.Check, error) {
+	var resp httpapi.ChecksResponse
+	err := c.do(ctx, http.MethodGet, "/checks", c.Token, nil, &resp)
+	return resp.Checks, err
+}
+
+// RunningCheck returns a check running in the agent.
+func (c *Client) RunningCheck(ctx context.Context, ID string) (api.Check, error) {
+	var resp httpapi.CheckResponse
+	err := c.do(ctx, http.MethodGet, "/checks/"+url.PathEscape(ID), c.Token, nil, &resp)
+	return resp.Check, err
+}
+
+// CheckLogs writes the output of a running check to the given writer. If
+// follow is true it keeps writing it until the check finishes or the context
+// is done.
+func (c *Client) CheckLogs(ctx context.Context, ID string, follow bool, w io.Writer) error {
+	path := "/checks/" + url.PathEscape(ID) + "/logs?follow=" + strconv.FormatBool(follow)
+	req, err := c.newRequest(ctx, http.MethodGet, path, c.Token, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("error requesting logs of check %s: %w", ID, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// AbortCheck aborts a running check.
+func (c *Client) AbortCheck(ctx context.Context, ID string) error {
+	return c.do(ctx, http.MethodPost, "/checks/"+url.PathEscape(ID)+"/abort", c.Token, nil, nil)
+}
+
+// AbortScan aborts the checks of a scan running in the agent and returns
+// their IDs.
+func (c *Client) AbortScan(ctx context.Context, ID string) ([]string, error) {
+	var resp httpapi.AbortScanResponse
+	err := c.do(ctx, http.MethodPost, "/scans/"+url.PathEscape(ID)+"/abort", c.Token, nil, &resp)
+	return resp.Checks, err
+}
+
+// ExecCheck executes a command in a running check. The token is the debug
+// token of the agent.
+func (c *Client) ExecCheck(ctx context.Context, token, ID string, cmd []string) (httpapi.ExecResponse, error) {
+	var resp httpapi.ExecResponse
+	req := httpapi.ExecRequest{Cmd: cmd}
+	err := c.do(ctx, http.MethodPost, "/checks/"+url.PathEscape(ID)+"/exec", token, req, &resp)
+	return resp, err
+}
+
+// Events calls the given func with each event of the agent that matches the
+// given filter, until the func returns an error, the context is done or the
+// agent closes the stream.
+func (c *Client) Events(ctx context.Context, f events.Filter, fn func(events.Event) error) error {
+	q := url.Values{"type": f.Types}
+	if f.CheckID != "" {
+		q.Set("check_id", f.CheckID)
+	}
+	req, err := c.newRequest(ctx, http.MethodGet, "/events?"+q.Encode(), c.Token, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("error requesting events: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	s := bufio.NewScanner(resp.Body)
+	for s.Scan() {
+		// Only the data lines contain the events, the others contain
+		// their names or keep-alive comments.
+		line := s.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var e events.Event
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+			return fmt.Errorf("error decoding event: %w", err)
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	if err := s.Err(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("error reading events: %w", err)
+	}
+	return ctx.Err()
+}
+
+// do sends a request with the given body encoded as JSON, if it's not nil,
+// and decodes the JSON response in out, if it's not nil. The responses with
+// an error status are returned as an Error, after decoding them in out.
+func (c *Client) do(ctx context.Context, method, path, token string, body, out interface{}) error {
+	req, err := c.newRequest(ctx, method, path, token, body)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("error requesting %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response of %s %s: %w", method, path, err)
+	}
+	if resp.StatusCode >= 300 {
+		if out != nil {
+			json.Unmarshal(content, out)
+		}
+		return newError(resp.StatusCode, content)
+	}
+	if out == nil || len(content) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(content, out); err != nil {
+		return fmt.Errorf("error decoding response of %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path, token string, body interface{}) (*http.Request, error) {
+	var r io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding request: %w", err)
+		}
+		r = bytes.NewReader(content)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return c.HTTPClient
+}
+
+func responseError(resp *http.Response) error {
+	content, _ := ioutil.ReadAll(resp.Body)
+	return newError(resp.StatusCode, content)
+}
+
+func newError(code int, content []byte) *Error {
+	var e httpapi.ErrorResponse
+	json.Unmarshal(content, &e)
+	return &Error{StatusCode: code, Message: e.Error}
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/api"
+	httpapi "github.com/adevinta/vulcan-agent/api/http"
+	"github.com/adevinta/vulcan-agent/events"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/google/go-cmp/cmp"
+	"github.com/julienschmidt/httprouter"
+)
+
+type fakeStats struct{}
+
+func (fakeStats) ChecksRunning() int {
+	return 1
+}
+
+func (fakeStats) LastMessageReceived() *time.Time {
+	return nil
+}
+
+type fakeDrainer struct {
+	draining bool
+}
+
+func (d *fakeDrainer) Drain() {
+	d.draining = true
+}
+
+func (d *fakeDrainer) Draining() (bool, *time.Time) {
+	return d.draining, nil
+}
+
+type fakeLeveler struct {
+	level string
+}
+
+func (l *fakeLeveler) Level() (string, *time.Time) {
+	return l.level, nil
+}
+
+func (l *fakeLeveler) Set(level string, d time.Duration) error {
+	l.level = level
+	return nil
+}
+
+// newAgent returns the URL of an agent API, with the given token, that can
+// be drained and whose log level can be changed.
+func newAgent(t *testing.T, token string) string {
+	a := api.New(&log.NullLog{}, nil, fakeStats{})
+	a.Drainer = &fakeDrainer{}
+	a.Leveler = &fakeLeveler{level: "info"}
+	router := httprouter.New()
+	rest := httpapi.NewREST(&log.NullLog{}, a, router)
+	rest.Auth = httpapi.Auth{Token: token}
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	c := New(newAgent(t, "token"))
+	c.Token = "token"
+
+	status, err := c.Status(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := api.Status{State: api.StateRunning, ChecksRunning: 1}
+	if diff := cmp.Diff(want, status); diff != "" {
+		t.Errorf("status mismatch (-want +got):\n%v", diff)
+	}
+
+	status, err = c.Drain(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status.State != api.StateDraining {
+		t.Errorf("want state %s, got %s", api.StateDraining, status.State)
+	}
+
+	l, err := c.SetLogLevel(ctx, api.LogLevel{Level: "debug"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if l.Level != "debug" {
+		t.Errorf("want log level debug, got %s", l.Level)
+	}
+
+	// The agent is not ready while draining, which is not an error of the
+	// request.
+	h, err := c.Ready(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if h.Status != api.HealthFail {
+		t.Errorf("want health %s, got %s", api.HealthFail, h.Status)
+	}
+}
+
+func TestClient_Errors(t *testing.T) {
+	ctx := context.Background()
+	url := newAgent(t, "token")
+	tests := []struct {
+		name     string
+		token    string
+		call     func(c *Client) error
+		wantCode int
+	}{
+		{
+			name:  "Unauthorized",
+			token: "other",
+			call: func(c *Client) error {
+				_, err := c.Drain(ctx)
+				return err
+			},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:  "CheckNotRunning",
+			token: "token",
+			call: func(c *Client) error {
+				_, err := c.RunningCheck(ctx, "check1")
+				return err
+			},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:  "NotSupported",
+			token: "token",
+			call: func(c *Client) error {
+				_, err := c.Pause(ctx)
+				return err
+			},
+			wantCode: http.StatusNotImplemented,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(url)
+			c.Token = tt.token
+			err := tt.call(c)
+			var e *Error
+			if !errors.As(err, &e) {
+				t.Fatalf("want an Error, got %v", err)
+			}
+			if e.StatusCode != tt.wantCode || e.Message == "" {
+				t.Errorf("want status %d with a message, got %+v", tt.wantCode, e)
+			}
+		})
+	}
+}
+
+func TestClient_Events(t *testing.T) {
+	bus := events.NewBus()
+	a := api.New(&log.NullLog{}, nil, fakeStats{})
+	a.Events = bus
+	router := httprouter.New()
+	httpapi.NewREST(&log.NullLog{}, a, router)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	// The events are published until the client is subscribed and receives
+	// one.
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				bus.Publish(events.Event{Type: events.TypeJobReceived, CheckID: "check2"})
+				bus.Publish(events.Event{Type: events.TypeCheckStarted, CheckID: "check1"})
+			}
+		}
+	}()
+	defer close(done)
+
+	errStop := errors.New("stop")
+	var got events.Event
+	f := events.Filter{Types: []string{"check."}}
+	err := New(srv.URL).Events(context.Background(), f, func(e events.Event) error {
+		got = e
+		return errStop
+	})
+	if !errors.Is(err, errStop) {
+		t.Fatalf("want error %v, got %v", errStop, err)
+	}
+	if got.Type != events.TypeCheckStarted || got.CheckID != "check1" {
+		t.Errorf("unexpected event received: %+v", got)
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package api
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Health statuses reported by the API.
+const (
+	HealthOK   = "ok"
+	HealthFail = "fail"
+)
+
+// probeTimeout is the max time a Probe can take to check a dependency.
+const probeTimeout = 5 * time.Second
+
+// errDraining is reported by the readiness probe of a draining agent.
+var errDraining = errors.New("agent draining")
+
+// Probe checks if a dependency of the agent is available.
+type Probe interface {
+	Ping(ctx context.Context) error
+}
+
+// ProbeFunc is an adapter to allow the use of ordinary functions as Probes.
+type ProbeFunc func(ctx context.Context) error
+
+// Ping calls f(ctx).
+func (f ProbeFunc) Ping(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Health contains the result of checking the health of the agent and its
+// dependencies.
+type Health struct {
+	Status string                 `json:"status"`
+	Checks map[string]ProbeResult `json:"checks"`
+}
+
+// ProbeResult contains the result of checking a dependency.
+type ProbeResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Live checks the health of the agent itself using the liveness probes.
+func (a *API) Live(ctx context.Context) Health {
+	return runProbes(ctx, a.LivenessProbes)
+}
+
+// Ready checks if the agent is ready to run checks using the liveness and
+// readiness probes. A draining agent is not ready.
+func (a *API) Ready(ctx context.Context) Health {
+	probes := make(map[string]Probe, len(a.LivenessProbes)+len(a.ReadinessProbes)+1)
+	for name, p := range a.LivenessProbes {
+		probes[name] = p
+	}
+	for name, p := range a.ReadinessProbes {
+		probes[name] = p
+	}
+	if a.Drainer != nil {
+		probes["agent"] = ProbeFunc(func(ctx context.Context) error {
+			if draining, _ := a.Drainer.Draining(); draining {
+				return errDraining
+			}
+			return nil
+		})
+	}
+	return runProbes(ctx, probes)
+}
+
+func runProbes(ctx context.Context, probes map[string]Probe) Health {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	h := Health{Status: HealthOK, Checks: make(map[string]ProbeResult, len(probes))}
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for name, p := range probes {
+		wg.Add(1)
+		go func(name string, p Probe) {
+			defer wg.Done()
+			res := ProbeResult{Status: HealthOK}
+			if err := p.Ping(ctx); err != nil {
+				res = ProbeResult{Status: HealthFail, Error: err.Error()}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			h.Checks[name] = res
+			if res.Status == HealthFail {
+				h.Status = HealthFail
+			}
+		}(name, p)
+	}
+	wg.Wait()
+	return h
+}
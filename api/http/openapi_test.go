@@ -0,0 +1,73 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/adevinta/vulcan-agent/api"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/google/go-cmp/cmp"
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/yaml.v3"
+)
+
+// routesRecorder is a Router that records the endpoints registered.
+type routesRecorder []string
+
+func (r *routesRecorder) add(method, path string) {
+	*r = append(*r, method+" "+path)
+}
+
+func (r *routesRecorder) GET(path string, _ httprouter.Handle)   { r.add("GET", path) }
+func (r *routesRecorder) PATCH(path string, _ httprouter.Handle) { r.add("PATCH", path) }
+func (r *routesRecorder) POST(path string, _ httprouter.Handle)  { r.add("POST", path) }
+func (r *routesRecorder) PUT(path string, _ httprouter.Handle)   { r.add("PUT", path) }
+
+var reRouteParam = regexp.MustCompile(`:([a-z]+)`)
+
+func TestOpenAPI_Endpoints(t *testing.T) {
+	var spec struct {
+		Paths map[string]map[string]interface{} `yaml:"paths"`
+	}
+	if err := yaml.Unmarshal(OpenAPI, &spec); err != nil {
+		t.Fatalf("invalid OpenAPI spec: %v", err)
+	}
+	var documented []string
+	for path, ops := range spec.Paths {
+		for method := range ops {
+			documented = append(documented, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(documented)
+
+	var routes routesRecorder
+	NewREST(&log.NullLog{}, api.New(&log.NullLog{}, nil, fakeStats{}), &routes)
+	for i, r := range routes {
+		routes[i] = reRouteParam.ReplaceAllString(r, "{$1}")
+	}
+	sort.Strings(routes)
+	if diff := cmp.Diff([]string(routes), documented); diff != "" {
+		t.Errorf("endpoints not documented in the OpenAPI spec (-registered +documented):\n%v", diff)
+	}
+}
+
+func TestREST_OpenAPI(t *testing.T) {
+	router := httprouter.New()
+	NewREST(&log.NullLog{}, api.New(&log.NullLog{}, nil, fakeStats{}), router)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.yaml", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want code %d, got %d", http.StatusOK, rec.Code)
+	}
+	if rec.Body.String() != string(OpenAPI) {
+		t.Errorf("unexpected OpenAPI spec served")
+	}
+}
@@ -5,16 +5,51 @@ Copyright 2021 Adevinta
 package http
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/subtle"
+	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/adevinta/vulcan-agent/api"
+	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/adevinta/vulcan-agent/events"
 	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/results"
+	"github.com/adevinta/vulcan-agent/stateupdater"
+	report "github.com/adevinta/vulcan-report"
 	"github.com/julienschmidt/httprouter"
 )
 
+// MaxReportSize is the max size, in bytes, of the reports pushed by the
+// checks, once decompressed. It's the max size accepted by the results
+// service.
+const MaxReportSize = results.MaxEntitySize
+
+// errReportTooBig is returned when a check pushes a report bigger than
+// MaxReportSize.
+var errReportTooBig = errors.New("report too big")
+
+// eventsKeepAlive is the interval at which a comment is sent to the clients
+// of the events endpoint when there are no events, so the idle connections
+// are not closed by the proxies in between.
+var eventsKeepAlive = 30 * time.Second
+
+// OpenAPI is the description of the API, in the OpenAPI 3 format, served in
+// /openapi.yaml. It must be updated with the endpoints of the API.
+//
+//go:embed openapi.yaml
+var OpenAPI []byte
+
 // ErrorResponse represents and http response when an error processing
 // a request occurs.
 type ErrorResponse struct {
@@ -26,35 +61,175 @@ type StatsResponse struct {
 	api.Stats `json:"stats"`
 }
 
+// AbortScanResponse represents the response to a request to abort a scan.
+type AbortScanResponse struct {
+	Checks []string `json:"checks"`
+}
+
+// ChecksResponse represents a running checks response.
+type ChecksResponse struct {
+	Checks []api.Check `json:"checks"`
+}
+
+// CheckResponse represents a running check response.
+type CheckResponse struct {
+	api.Check `json:"check"`
+}
+
+// ExecRequest represents a request to execute a command in a check.
+type ExecRequest struct {
+	Cmd []string `json:"cmd"`
+}
+
+// ExecResponse represents the result of executing a command in a check.
+type ExecResponse struct {
+	Output   string `json:"output"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// StatusResponse represents a status response.
+type StatusResponse struct {
+	api.Status `json:"status"`
+}
+
+// IdleShutdownResponse represents an idle shutdown settings response.
+type IdleShutdownResponse struct {
+	api.IdleShutdown `json:"idle_shutdown"`
+}
+
+// LogLevelResponse represents a log level response.
+type LogLevelResponse struct {
+	api.LogLevel `json:"log_level"`
+}
+
+// CapacityResponse represents a capacity response.
+type CapacityResponse struct {
+	api.Capacity `json:"capacity"`
+}
+
+// Router defines the shape of the router where the endpoints of the API are
+// registered.
 type Router interface {
 	GET(path string, handle httprouter.Handle)
 	PATCH(path string, handle httprouter.Handle)
+	POST(path string, handle httprouter.Handle)
+	PUT(path string, handle httprouter.Handle)
 }
 
 // API defines the shape of the services that the http.REST exposes.
 type API interface {
 	CheckUpdate(s api.CheckState) error
+	CheckProgress(p api.CheckProgress) error
+	CheckReport(ID string, r report.Report) error
 	Stats() (api.Stats, error)
+	Status() (api.Status, error)
+	Capacity() (api.Capacity, error)
+	Drain() (api.Status, error)
+	Pause() (api.Status, error)
+	Resume() (api.Status, error)
+	IdleShutdown() (api.IdleShutdown, error)
+	SetIdleShutdown(s api.IdleShutdown) (api.IdleShutdown, error)
+	LogLevel() (api.LogLevel, error)
+	SetLogLevel(l api.LogLevel) (api.LogLevel, error)
+	AbortCheck(ID string) error
+	AbortScan(ID string) ([]string, error)
+	RunningChecks() ([]api.Check, error)
+	RunningCheck(ID string) (api.Check, error)
+	CheckLogs(ctx context.Context, ID string, follow bool, w io.Writer) error
+	ExecCheck(ctx context.Context, token, ID string, cmd []string) (backend.ExecResult, error)
+	SubscribeEvents(f events.Filter) (<-chan events.Event, func(), error)
+	Live(ctx context.Context) api.Health
+	Ready(ctx context.Context) api.Health
+}
+
+// Auth defines how the requests to the control endpoints of the API are
+// authorized. When RequireTLS is true the control endpoints are only served
+// over TLS. A request is authorized if it was sent with a verified client
+// certificate, when ClientCerts is true, or if it contains the Token as a
+// bearer token. When the Token is empty and ClientCerts is false the requests
+// don't need any credential.
+type Auth struct {
+	Token       string
+	ClientCerts bool
+	RequireTLS  bool
 }
 
 // REST exposes an API using http REST endpoints.
 type REST struct {
 	api API
 	log log.Logger
+	// Auth defines how the control endpoints are authorized.
+	Auth Auth
 }
 
 // NewREST returns a REST components that exposes a given API using http REST
 // endpoints through the given router.
-func NewREST(log log.Logger, a API, router *httprouter.Router) *REST {
+func NewREST(log log.Logger, a API, router Router) *REST {
 	r := &REST{
 		api: a,
 		log: log,
 	}
 	router.PATCH("/check/:id", r.handleCheckUpdate)
+	router.PATCH("/check/:id/progress", r.handleCheckProgress)
+	router.POST("/check/:id/report", r.handleCheckReport)
 	router.GET("/stats", r.handleStats)
+	router.GET("/status", r.handleStatus)
+	router.GET("/capacity", r.handleCapacity)
+	router.GET("/healthz", r.handleHealthz)
+	router.GET("/readyz", r.handleReadyz)
+	router.GET("/openapi.yaml", r.handleOpenAPI)
+	router.POST("/drain", r.control(r.handleDrain))
+	router.POST("/pause", r.control(r.handlePause))
+	router.POST("/resume", r.control(r.handleResume))
+	router.GET("/idle-shutdown", r.control(r.handleIdleShutdown))
+	router.PATCH("/idle-shutdown", r.control(r.handleSetIdleShutdown))
+	router.GET("/loglevel", r.control(r.handleLogLevel))
+	router.PUT("/loglevel", r.control(r.handleSetLogLevel))
+	router.GET("/checks", r.control(r.handleRunningChecks))
+	router.GET("/checks/:id", r.control(r.handleRunningCheck))
+	router.GET("/checks/:id/logs", r.control(r.handleCheckLogs))
+	router.POST("/checks/:id/abort", r.control(r.handleAbortCheck))
+	// The exec endpoint is authorized with its own token, see
+	// api.ExecCheck, so the bearer token is not checked here.
+	router.POST("/checks/:id/exec", r.protect(r.handleExecCheck, false))
+	router.POST("/scans/:id/abort", r.control(r.handleAbortScan))
+	router.GET("/events", r.control(r.handleEvents))
 	return r
 }
 
+// control returns a handler that only calls the given one if the request is
+// authorized to use the control endpoints.
+func (re *REST) control(h httprouter.Handle) httprouter.Handle {
+	return re.protect(h, true)
+}
+
+func (re *REST) protect(h httprouter.Handle, checkToken bool) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		auth := re.Auth
+		if auth.RequireTLS && r.TLS == nil {
+			writeJSONResponse(w, http.StatusForbidden, ErrorResponse{"tls required"})
+			return
+		}
+		if auth.ClientCerts && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			h(w, r, ps)
+			return
+		}
+		if checkToken && auth.Token != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(auth.Token)) == 1 {
+				h(w, r, ps)
+				return
+			}
+		}
+		if !auth.ClientCerts && (!checkToken || auth.Token == "") {
+			h(w, r, ps)
+			return
+		}
+		re.log.Errorf("unauthorized request %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		writeJSONResponse(w, http.StatusUnauthorized, ErrorResponse{api.ErrUnauthorized.Error()})
+	}
+}
+
 func (re *REST) handleCheckUpdate(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	id := ps.ByName("id")
 	body, err := ioutil.ReadAll(r.Body)
@@ -75,14 +250,97 @@ func (re *REST) handleCheckUpdate(w http.ResponseWriter, r *http.Request, ps htt
 	state.ID = id
 	err = re.api.CheckUpdate(*state)
 	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, stateupdater.ErrInvalidTransition) {
+			code = http.StatusConflict
+		}
 		err = fmt.Errorf("updating check state, %v", err.Error())
 		re.log.Errorf(err.Error())
-		writeJSONResponse(w, http.StatusInternalServerError, ErrorResponse{err.Error()})
+		writeJSONResponse(w, code, ErrorResponse{err.Error()})
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
 
+func (re *REST) handleCheckProgress(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	progress := api.CheckProgress{}
+	if err := json.NewDecoder(r.Body).Decode(&progress); err != nil {
+		err = fmt.Errorf("error decoding check progress request: %v", err.Error())
+		re.log.Errorf("error: %+v", err)
+		writeJSONResponse(w, http.StatusBadRequest, ErrorResponse{err.Error()})
+		return
+	}
+	progress.ID = id
+	err := re.api.CheckProgress(progress)
+	switch {
+	case errors.Is(err, api.ErrInvalidProgress):
+		writeJSONResponse(w, http.StatusBadRequest, ErrorResponse{err.Error()})
+	case errors.Is(err, api.ErrCheckNotRunning):
+		writeJSONResponse(w, http.StatusNotFound, ErrorResponse{err.Error()})
+	case errors.Is(err, stateupdater.ErrInvalidTransition):
+		writeJSONResponse(w, http.StatusConflict, ErrorResponse{err.Error()})
+	case err != nil:
+		writeJSONResponse(w, http.StatusInternalServerError, ErrorResponse{err.Error()})
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (re *REST) handleCheckReport(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	content, err := readLimited(r.Body, MaxReportSize)
+	if err == nil && r.Header.Get("Content-Encoding") == "gzip" {
+		var gz *gzip.Reader
+		gz, err = gzip.NewReader(bytes.NewReader(content))
+		if err == nil {
+			content, err = readLimited(gz, MaxReportSize)
+		}
+	}
+	if err != nil {
+		code := http.StatusBadRequest
+		if errors.Is(err, errReportTooBig) {
+			code = http.StatusRequestEntityTooLarge
+		}
+		err = fmt.Errorf("error reading check report: %v", err)
+		re.log.Errorf("error: %+v", err)
+		writeJSONResponse(w, code, ErrorResponse{err.Error()})
+		return
+	}
+	var rep report.Report
+	if err := json.Unmarshal(content, &rep); err != nil {
+		err = fmt.Errorf("error decoding check report: %v", err)
+		re.log.Errorf("error: %+v", err)
+		writeJSONResponse(w, http.StatusBadRequest, ErrorResponse{err.Error()})
+		return
+	}
+	err = re.api.CheckReport(id, rep)
+	switch {
+	case errors.Is(err, api.ErrInvalidReport):
+		re.log.Errorf("error: %+v", err)
+		writeJSONResponse(w, http.StatusUnprocessableEntity, ErrorResponse{err.Error()})
+	case errors.Is(err, stateupdater.ErrInvalidTransition):
+		writeJSONResponse(w, http.StatusConflict, ErrorResponse{err.Error()})
+	case err != nil:
+		writeJSONResponse(w, http.StatusInternalServerError, ErrorResponse{err.Error()})
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// readLimited reads all the content of the reader, returning errReportTooBig
+// if it's bigger than max bytes.
+func readLimited(r io.Reader, max int64) ([]byte, error) {
+	content, err := ioutil.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > max {
+		return nil, fmt.Errorf("%w: max %d bytes", errReportTooBig, max)
+	}
+	return content, nil
+}
+
 func (re *REST) handleStats(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	stats, err := re.api.Stats()
 	if err != nil {
@@ -95,6 +353,378 @@ func (re *REST) handleStats(w http.ResponseWriter, r *http.Request, ps httproute
 	writeJSONResponse(w, http.StatusOK, resp)
 }
 
+func (re *REST) handleStatus(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	status, err := re.api.Status()
+	if err != nil {
+		err = fmt.Errorf("error getting agent status: %v", err.Error())
+		re.log.Errorf("error: %+v", err)
+		writeJSONResponse(w, http.StatusInternalServerError, ErrorResponse{err.Error()})
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, StatusResponse{status})
+}
+
+func (re *REST) handleCapacity(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	c, err := re.api.Capacity()
+	if errors.Is(err, api.ErrCapacityNotSupported) {
+		writeJSONResponse(w, http.StatusNotImplemented, ErrorResponse{err.Error()})
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("error getting agent capacity: %v", err.Error())
+		re.log.Errorf("error: %+v", err)
+		writeJSONResponse(w, http.StatusInternalServerError, ErrorResponse{err.Error()})
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, CapacityResponse{c})
+}
+
+func (re *REST) handleRunningChecks(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	checks, err := re.api.RunningChecks()
+	if err != nil {
+		err = fmt.Errorf("error getting running checks: %v", err.Error())
+		re.log.Errorf("error: %+v", err)
+		writeJSONResponse(w, http.StatusInternalServerError, ErrorResponse{err.Error()})
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, ChecksResponse{checks})
+}
+
+func (re *REST) handleRunningCheck(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	check, err := re.api.RunningCheck(id)
+	if errors.Is(err, api.ErrCheckNotRunning) {
+		writeJSONResponse(w, http.StatusNotFound, ErrorResponse{err.Error()})
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("error getting running check %s: %v", id, err.Error())
+		re.log.Errorf("error: %+v", err)
+		writeJSONResponse(w, http.StatusInternalServerError, ErrorResponse{err.Error()})
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, CheckResponse{check})
+}
+
+func (re *REST) handleCheckLogs(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	var follow bool
+	if v := r.URL.Query().Get("follow"); v != "" {
+		var err error
+		follow, err = strconv.ParseBool(v)
+		if err != nil {
+			err = fmt.Errorf("invalid follow param: %v", v)
+			writeJSONResponse(w, http.StatusBadRequest, ErrorResponse{err.Error()})
+			return
+		}
+	}
+	fw := &flushWriter{w: w}
+	err := re.api.CheckLogs(r.Context(), id, follow, fw)
+	if fw.started {
+		if err != nil {
+			re.log.Errorf("error streaming logs of check %s: %+v", id, err)
+		}
+		return
+	}
+	switch {
+	case errors.Is(err, api.ErrLogsNotSupported):
+		writeJSONResponse(w, http.StatusNotImplemented, ErrorResponse{err.Error()})
+	case errors.Is(err, api.ErrCheckNotRunning):
+		writeJSONResponse(w, http.StatusNotFound, ErrorResponse{err.Error()})
+	case err != nil:
+		err = fmt.Errorf("error getting logs of check %s: %v", id, err.Error())
+		re.log.Errorf("error: %+v", err)
+		writeJSONResponse(w, http.StatusInternalServerError, ErrorResponse{err.Error()})
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// flushWriter writes the logs of a check to a response flushing them after
+// each write, so they are received by the client as soon as they are
+// produced. The status and headers of the response are written with the
+// first write, so the errors returned before that can still be reported with
+// the right status code.
+type flushWriter struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	if !fw.started {
+		fw.w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fw.w.Header().Set("X-Content-Type-Options", "nosniff")
+		fw.w.WriteHeader(http.StatusOK)
+		fw.started = true
+	}
+	n, err := fw.w.Write(p)
+	if f, ok := fw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return n, err
+}
+
+func (re *REST) handleExecCheck(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	req := ExecRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		err = fmt.Errorf("error decoding exec request: %v", err.Error())
+		writeJSONResponse(w, http.StatusBadRequest, ErrorResponse{err.Error()})
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	res, err := re.api.ExecCheck(r.Context(), token, id, req.Cmd)
+	switch {
+	case errors.Is(err, api.ErrExecNotSupported):
+		writeJSONResponse(w, http.StatusNotImplemented, ErrorResponse{err.Error()})
+	case errors.Is(err, api.ErrUnauthorized):
+		re.log.Errorf("unauthorized exec request for check %s", id)
+		writeJSONResponse(w, http.StatusUnauthorized, ErrorResponse{err.Error()})
+	case errors.Is(err, api.ErrEmptyCommand):
+		writeJSONResponse(w, http.StatusBadRequest, ErrorResponse{err.Error()})
+	case errors.Is(err, api.ErrCheckNotRunning):
+		writeJSONResponse(w, http.StatusNotFound, ErrorResponse{err.Error()})
+	case err != nil:
+		err = fmt.Errorf("error executing command in check %s: %v", id, err.Error())
+		re.log.Errorf("error: %+v", err)
+		writeJSONResponse(w, http.StatusInternalServerError, ErrorResponse{err.Error()})
+	default:
+		writeJSONResponse(w, http.StatusOK, ExecResponse{Output: string(res.Output), ExitCode: res.ExitCode})
+	}
+}
+
+// handleEvents streams the events of the agent as server-sent events, where
+// the name of each event is its type and its data is the event encoded as
+// JSON. The events can be filtered by check and by type, or prefix of the
+// type, e.g. /events?check_id=ID&type=check.&type=job.failed.
+func (re *REST) handleEvents(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	q := r.URL.Query()
+	f := events.Filter{Types: q["type"], CheckID: q.Get("check_id")}
+	evts, cancel, err := re.api.SubscribeEvents(f)
+	switch {
+	case errors.Is(err, api.ErrEventsNotSupported):
+		writeJSONResponse(w, http.StatusNotImplemented, ErrorResponse{err.Error()})
+		return
+	case err != nil:
+		err = fmt.Errorf("error subscribing to events: %v", err.Error())
+		re.log.Errorf("error: %+v", err)
+		writeJSONResponse(w, http.StatusInternalServerError, ErrorResponse{err.Error()})
+		return
+	}
+	defer cancel()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flush := func() {
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	flush()
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case e := <-evts:
+			data, err := json.Marshal(e)
+			if err != nil {
+				re.log.Errorf("error encoding event %s: %+v", e.Type, err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data); err != nil {
+				return
+			}
+		}
+		flush()
+	}
+}
+
+func (re *REST) handleHealthz(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	writeHealthResponse(w, re.api.Live(r.Context()))
+}
+
+func (re *REST) handleReadyz(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	writeHealthResponse(w, re.api.Ready(r.Context()))
+}
+
+func (re *REST) handleOpenAPI(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/yaml")
+	w.WriteHeader(http.StatusOK)
+	w.Write(OpenAPI)
+}
+
+func writeHealthResponse(w http.ResponseWriter, h api.Health) {
+	code := http.StatusOK
+	if h.Status != api.HealthOK {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSONResponse(w, code, h)
+}
+
+func (re *REST) handleDrain(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	status, err := re.api.Drain()
+	if errors.Is(err, api.ErrDrainNotSupported) {
+		writeJSONResponse(w, http.StatusNotImplemented, ErrorResponse{err.Error()})
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("error draining agent: %v", err.Error())
+		re.log.Errorf("error: %+v", err)
+		writeJSONResponse(w, http.StatusInternalServerError, ErrorResponse{err.Error()})
+		return
+	}
+	re.log.Infof("drain requested through the API")
+	writeJSONResponse(w, http.StatusAccepted, StatusResponse{status})
+}
+
+func (re *REST) handlePause(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	re.handlePauser(w, "pause", re.api.Pause)
+}
+
+func (re *REST) handleResume(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	re.handlePauser(w, "resume", re.api.Resume)
+}
+
+// handlePauser writes the response of the pause and resume endpoints, which
+// only differ in the action performed.
+func (re *REST) handlePauser(w http.ResponseWriter, action string, f func() (api.Status, error)) {
+	status, err := f()
+	if errors.Is(err, api.ErrPauseNotSupported) {
+		writeJSONResponse(w, http.StatusNotImplemented, ErrorResponse{err.Error()})
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("error trying to %s agent: %v", action, err.Error())
+		re.log.Errorf("error: %+v", err)
+		writeJSONResponse(w, http.StatusInternalServerError, ErrorResponse{err.Error()})
+		return
+	}
+	re.log.Infof("%s requested through the API", action)
+	writeJSONResponse(w, http.StatusOK, StatusResponse{status})
+}
+
+func (re *REST) handleIdleShutdown(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s, err := re.api.IdleShutdown()
+	if errors.Is(err, api.ErrIdleShutdownNotSupported) {
+		writeJSONResponse(w, http.StatusNotImplemented, ErrorResponse{err.Error()})
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("error getting idle shutdown: %v", err.Error())
+		re.log.Errorf("error: %+v", err)
+		writeJSONResponse(w, http.StatusInternalServerError, ErrorResponse{err.Error()})
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, IdleShutdownResponse{s})
+}
+
+func (re *REST) handleSetIdleShutdown(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var s api.IdleShutdown
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		err = fmt.Errorf("error decoding idle shutdown request: %v", err.Error())
+		re.log.Errorf("error: %+v", err)
+		writeJSONResponse(w, http.StatusBadRequest, ErrorResponse{err.Error()})
+		return
+	}
+	s, err := re.api.SetIdleShutdown(s)
+	switch {
+	case errors.Is(err, api.ErrIdleShutdownNotSupported):
+		writeJSONResponse(w, http.StatusNotImplemented, ErrorResponse{err.Error()})
+		return
+	case errors.Is(err, api.ErrInvalidIdleShutdown):
+		writeJSONResponse(w, http.StatusBadRequest, ErrorResponse{err.Error()})
+		return
+	case err != nil:
+		err = fmt.Errorf("error setting idle shutdown: %v", err.Error())
+		re.log.Errorf("error: %+v", err)
+		writeJSONResponse(w, http.StatusInternalServerError, ErrorResponse{err.Error()})
+		return
+	}
+	re.log.Infof("max time without messages set to %d seconds through the API", s.MaxNoMsgsInterval)
+	writeJSONResponse(w, http.StatusOK, IdleShutdownResponse{s})
+}
+
+func (re *REST) handleLogLevel(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	l, err := re.api.LogLevel()
+	if errors.Is(err, api.ErrLogLevelNotSupported) {
+		writeJSONResponse(w, http.StatusNotImplemented, ErrorResponse{err.Error()})
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("error getting log level: %v", err.Error())
+		re.log.Errorf("error: %+v", err)
+		writeJSONResponse(w, http.StatusInternalServerError, ErrorResponse{err.Error()})
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, LogLevelResponse{l})
+}
+
+func (re *REST) handleSetLogLevel(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var l api.LogLevel
+	if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
+		err = fmt.Errorf("error decoding log level request: %v", err.Error())
+		re.log.Errorf("error: %+v", err)
+		writeJSONResponse(w, http.StatusBadRequest, ErrorResponse{err.Error()})
+		return
+	}
+	l, err := re.api.SetLogLevel(l)
+	switch {
+	case errors.Is(err, api.ErrLogLevelNotSupported):
+		writeJSONResponse(w, http.StatusNotImplemented, ErrorResponse{err.Error()})
+		return
+	case errors.Is(err, api.ErrInvalidLogLevel):
+		writeJSONResponse(w, http.StatusBadRequest, ErrorResponse{err.Error()})
+		return
+	case err != nil:
+		err = fmt.Errorf("error setting log level: %v", err.Error())
+		re.log.Errorf("error: %+v", err)
+		writeJSONResponse(w, http.StatusInternalServerError, ErrorResponse{err.Error()})
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, LogLevelResponse{l})
+}
+
+func (re *REST) handleAbortCheck(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	err := re.api.AbortCheck(id)
+	switch {
+	case errors.Is(err, api.ErrAbortNotSupported):
+		writeJSONResponse(w, http.StatusNotImplemented, ErrorResponse{err.Error()})
+	case errors.Is(err, api.ErrCheckNotRunning):
+		writeJSONResponse(w, http.StatusNotFound, ErrorResponse{err.Error()})
+	case err != nil:
+		err = fmt.Errorf("error aborting check %s: %v", id, err.Error())
+		re.log.Errorf("error: %+v", err)
+		writeJSONResponse(w, http.StatusInternalServerError, ErrorResponse{err.Error()})
+	default:
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (re *REST) handleAbortScan(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	checks, err := re.api.AbortScan(id)
+	switch {
+	case errors.Is(err, api.ErrAbortNotSupported):
+		writeJSONResponse(w, http.StatusNotImplemented, ErrorResponse{err.Error()})
+	case errors.Is(err, api.ErrScanNotRunning):
+		writeJSONResponse(w, http.StatusNotFound, ErrorResponse{err.Error()})
+	case err != nil:
+		err = fmt.Errorf("error aborting scan %s: %v", id, err.Error())
+		re.log.Errorf("error: %+v", err)
+		writeJSONResponse(w, http.StatusInternalServerError, ErrorResponse{err.Error()})
+	default:
+		writeJSONResponse(w, http.StatusAccepted, AbortScanResponse{checks})
+	}
+}
+
 func writeJSONResponse(w http.ResponseWriter, code int, v interface{}) {
 	w.WriteHeader(code)
 	w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,1290 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/api"
+	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/adevinta/vulcan-agent/events"
+	"github.com/adevinta/vulcan-agent/jobrunner"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/queue"
+	"github.com/adevinta/vulcan-agent/stateupdater"
+	report "github.com/adevinta/vulcan-report"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/julienschmidt/httprouter"
+)
+
+type fakeStats struct{}
+
+func (fakeStats) ChecksRunning() int {
+	return 2
+}
+
+func (fakeStats) LastMessageReceived() *time.Time {
+	return nil
+}
+
+type fakeDrainer struct {
+	draining bool
+	deadline *time.Time
+}
+
+func (d *fakeDrainer) Drain() {
+	d.draining = true
+}
+
+func (d *fakeDrainer) Draining() (bool, *time.Time) {
+	return d.draining, d.deadline
+}
+
+func TestREST_Drain(t *testing.T) {
+	deadline := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		drainer  *fakeDrainer
+		requests []string
+		wantCode int
+		want     api.Status
+	}{
+		{
+			name:     "StatusRunning",
+			drainer:  &fakeDrainer{deadline: &deadline},
+			requests: []string{"GET /status"},
+			wantCode: http.StatusOK,
+			want:     api.Status{State: api.StateRunning, ChecksRunning: 2},
+		},
+		{
+			name:     "Drain",
+			drainer:  &fakeDrainer{deadline: &deadline},
+			requests: []string{"POST /drain"},
+			wantCode: http.StatusAccepted,
+			want:     api.Status{State: api.StateDraining, ChecksRunning: 2, DrainDeadline: &deadline},
+		},
+		{
+			name:     "StatusDraining",
+			drainer:  &fakeDrainer{},
+			requests: []string{"POST /drain", "GET /status"},
+			wantCode: http.StatusOK,
+			want:     api.Status{State: api.StateDraining, ChecksRunning: 2},
+		},
+		{
+			name:     "DrainNotSupported",
+			requests: []string{"POST /drain"},
+			wantCode: http.StatusNotImplemented,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := api.New(&log.NullLog{}, nil, fakeStats{})
+			if tt.drainer != nil {
+				a.Drainer = tt.drainer
+			}
+			router := httprouter.New()
+			NewREST(&log.NullLog{}, a, router)
+			var rec *httptest.ResponseRecorder
+			for _, r := range tt.requests {
+				parts := strings.SplitN(r, " ", 2)
+				rec = httptest.NewRecorder()
+				router.ServeHTTP(rec, httptest.NewRequest(parts[0], parts[1], nil))
+			}
+			if rec.Code != tt.wantCode {
+				t.Fatalf("want code %d, got %d", tt.wantCode, rec.Code)
+			}
+			if rec.Code == http.StatusNotImplemented {
+				return
+			}
+			var got StatusResponse
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got.Status); diff != "" {
+				t.Errorf("want status != got status, diff: %s", diff)
+			}
+		})
+	}
+}
+
+type fakePauser struct {
+	reasons map[string]bool
+}
+
+func (p *fakePauser) Pause(reason string) {
+	p.reasons[reason] = true
+}
+
+func (p *fakePauser) Resume(reason string) {
+	delete(p.reasons, reason)
+}
+
+func (p *fakePauser) Paused() []string {
+	var reasons []string
+	for r := range p.reasons {
+		reasons = append(reasons, r)
+	}
+	return reasons
+}
+
+func TestREST_Pause(t *testing.T) {
+	tests := []struct {
+		name     string
+		pauser   *fakePauser
+		requests []string
+		wantCode int
+		want     api.Status
+	}{
+		{
+			name:     "Pause",
+			pauser:   &fakePauser{reasons: map[string]bool{}},
+			requests: []string{"POST /pause"},
+			wantCode: http.StatusOK,
+			want: api.Status{
+				State:         api.StatePaused,
+				ChecksRunning: 2,
+				PauseReasons:  []string{api.PauseReason},
+			},
+		},
+		{
+			name:     "Resume",
+			pauser:   &fakePauser{reasons: map[string]bool{}},
+			requests: []string{"POST /pause", "POST /resume", "GET /status"},
+			wantCode: http.StatusOK,
+			want:     api.Status{State: api.StateRunning, ChecksRunning: 2},
+		},
+		{
+			name:     "ResumeKeepsOtherReasons",
+			pauser:   &fakePauser{reasons: map[string]bool{"other": true}},
+			requests: []string{"POST /pause", "POST /resume"},
+			wantCode: http.StatusOK,
+			want: api.Status{
+				State:         api.StatePaused,
+				ChecksRunning: 2,
+				PauseReasons:  []string{"other"},
+			},
+		},
+		{
+			name:     "PauseNotSupported",
+			requests: []string{"POST /pause"},
+			wantCode: http.StatusNotImplemented,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := api.New(&log.NullLog{}, nil, fakeStats{})
+			if tt.pauser != nil {
+				a.Pauser = tt.pauser
+			}
+			router := httprouter.New()
+			NewREST(&log.NullLog{}, a, router)
+			var rec *httptest.ResponseRecorder
+			for _, r := range tt.requests {
+				parts := strings.SplitN(r, " ", 2)
+				rec = httptest.NewRecorder()
+				router.ServeHTTP(rec, httptest.NewRequest(parts[0], parts[1], nil))
+			}
+			if rec.Code != tt.wantCode {
+				t.Fatalf("want code %d, got %d", tt.wantCode, rec.Code)
+			}
+			if rec.Code == http.StatusNotImplemented {
+				return
+			}
+			var got StatusResponse
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got.Status); diff != "" {
+				t.Errorf("want status != got status, diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestREST_IdleShutdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		limit    api.IdleLimiter
+		method   string
+		body     string
+		wantCode int
+		want     api.IdleShutdown
+	}{
+		{
+			name:     "Get",
+			limit:    queue.NewIdleLimit(time.Hour, 0),
+			method:   http.MethodGet,
+			wantCode: http.StatusOK,
+			want:     api.IdleShutdown{MaxNoMsgsInterval: 3600},
+		},
+		{
+			name:     "Set",
+			limit:    queue.NewIdleLimit(time.Hour, 0),
+			method:   http.MethodPatch,
+			body:     `{"max_no_msgs_interval": 0}`,
+			wantCode: http.StatusOK,
+			want:     api.IdleShutdown{MaxNoMsgsInterval: 0},
+		},
+		{
+			name:     "Negative",
+			limit:    queue.NewIdleLimit(time.Hour, 0),
+			method:   http.MethodPatch,
+			body:     `{"max_no_msgs_interval": -1}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "NotSupported",
+			method:   http.MethodGet,
+			wantCode: http.StatusNotImplemented,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := api.New(&log.NullLog{}, nil, fakeStats{})
+			a.IdleLimit = tt.limit
+			router := httprouter.New()
+			NewREST(&log.NullLog{}, a, router)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(tt.method, "/idle-shutdown", strings.NewReader(tt.body)))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("want code %d, got %d", tt.wantCode, rec.Code)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var got IdleShutdownResponse
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got.IdleShutdown); diff != "" {
+				t.Errorf("want idle shutdown != got idle shutdown, diff: %s", diff)
+			}
+		})
+	}
+}
+
+type fakeLevelSetter struct{}
+
+func (fakeLevelSetter) SetLevel(level string) {}
+
+func TestREST_LogLevel(t *testing.T) {
+	tests := []struct {
+		name          string
+		leveler       api.LogLeveler
+		method        string
+		body          string
+		wantCode      int
+		want          api.LogLevel
+		wantTemporary bool
+	}{
+		{
+			name:     "Get",
+			leveler:  log.NewLevelSwitch(&log.NullLog{}, fakeLevelSetter{}, "info"),
+			method:   http.MethodGet,
+			wantCode: http.StatusOK,
+			want:     api.LogLevel{Level: "info"},
+		},
+		{
+			name:     "Set",
+			leveler:  log.NewLevelSwitch(&log.NullLog{}, fakeLevelSetter{}, "info"),
+			method:   http.MethodPut,
+			body:     `{"level": "error"}`,
+			wantCode: http.StatusOK,
+			want:     api.LogLevel{Level: "error"},
+		},
+		{
+			name:          "SetTemporary",
+			leveler:       log.NewLevelSwitch(&log.NullLog{}, fakeLevelSetter{}, "info"),
+			method:        http.MethodPut,
+			body:          `{"level": "debug", "duration": 600}`,
+			wantCode:      http.StatusOK,
+			want:          api.LogLevel{Level: "debug", Duration: 600},
+			wantTemporary: true,
+		},
+		{
+			name:     "InvalidLevel",
+			leveler:  log.NewLevelSwitch(&log.NullLog{}, fakeLevelSetter{}, "info"),
+			method:   http.MethodPut,
+			body:     `{"level": "verbose"}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "NegativeDuration",
+			leveler:  log.NewLevelSwitch(&log.NullLog{}, fakeLevelSetter{}, "info"),
+			method:   http.MethodPut,
+			body:     `{"level": "debug", "duration": -1}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "NotSupported",
+			method:   http.MethodGet,
+			wantCode: http.StatusNotImplemented,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := api.New(&log.NullLog{}, nil, fakeStats{})
+			a.Leveler = tt.leveler
+			router := httprouter.New()
+			NewREST(&log.NullLog{}, a, router)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(tt.method, "/loglevel", strings.NewReader(tt.body)))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("want code %d, got %d", tt.wantCode, rec.Code)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var got LogLevelResponse
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if (got.RevertAt != nil) != tt.wantTemporary {
+				t.Errorf("got revert at %v, want temporary %v", got.RevertAt, tt.wantTemporary)
+			}
+			if diff := cmp.Diff(tt.want, got.LogLevel, cmpopts.IgnoreFields(api.LogLevel{}, "RevertAt")); diff != "" {
+				t.Errorf("want log level != got log level, diff: %s", diff)
+			}
+		})
+	}
+}
+
+type fakeCapacity struct {
+	paused []string
+}
+
+func (fakeCapacity) Capacity() int {
+	return 4
+}
+
+func (fakeCapacity) FreeSlots() int {
+	return 1
+}
+
+func (fakeCapacity) ChecksRunning() int {
+	return 3
+}
+
+func (fakeCapacity) AvgCheckDuration() time.Duration {
+	return 90 * time.Second
+}
+
+func (c fakeCapacity) Paused() []string {
+	return c.paused
+}
+
+type fakeInFlight int
+
+func (f fakeInFlight) ProcessingMessages() int {
+	return int(f)
+}
+
+func TestREST_Capacity(t *testing.T) {
+	inFlight := 2
+	tests := []struct {
+		name     string
+		stats    api.CapacityStats
+		inFlight api.InFlightCounter
+		drainer  *fakeDrainer
+		wantCode int
+		want     api.Capacity
+	}{
+		{
+			name:     "Accepting",
+			stats:    fakeCapacity{},
+			inFlight: fakeInFlight(2),
+			wantCode: http.StatusOK,
+			want: api.Capacity{
+				Capacity:         4,
+				FreeTokens:       1,
+				ChecksRunning:    3,
+				AvgCheckDuration: 90,
+				MessagesInFlight: &inFlight,
+				Accepting:        true,
+			},
+		},
+		{
+			name:     "Paused",
+			stats:    fakeCapacity{paused: []string{"disk"}},
+			wantCode: http.StatusOK,
+			want: api.Capacity{
+				Capacity:         4,
+				FreeTokens:       1,
+				ChecksRunning:    3,
+				AvgCheckDuration: 90,
+			},
+		},
+		{
+			name:     "Draining",
+			stats:    fakeCapacity{},
+			drainer:  &fakeDrainer{draining: true},
+			wantCode: http.StatusOK,
+			want: api.Capacity{
+				Capacity:         4,
+				ChecksRunning:    3,
+				AvgCheckDuration: 90,
+			},
+		},
+		{
+			name:     "NotSupported",
+			wantCode: http.StatusNotImplemented,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := api.New(&log.NullLog{}, nil, fakeStats{})
+			a.CapacityStats = tt.stats
+			a.InFlight = tt.inFlight
+			if tt.drainer != nil {
+				a.Drainer = tt.drainer
+			}
+			router := httprouter.New()
+			NewREST(&log.NullLog{}, a, router)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/capacity", nil))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("want code %d, got %d", tt.wantCode, rec.Code)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var got CapacityResponse
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got.Capacity); diff != "" {
+				t.Errorf("want capacity != got capacity, diff: %s", diff)
+			}
+		})
+	}
+}
+
+type fakeAborter struct {
+	running map[string]bool
+	scans   map[string][]string
+	aborted []string
+}
+
+func (a *fakeAborter) CheckRunning(ID string) bool {
+	return a.running[ID]
+}
+
+func (a *fakeAborter) AbortCheck(ID string) {
+	a.aborted = append(a.aborted, ID)
+}
+
+func (a *fakeAborter) AbortScan(scanID string) []string {
+	ids := a.scans[scanID]
+	a.aborted = append(a.aborted, ids...)
+	return ids
+}
+
+func TestREST_AbortCheck(t *testing.T) {
+	tests := []struct {
+		name        string
+		aborter     *fakeAborter
+		check       string
+		wantCode    int
+		wantAborted []string
+	}{
+		{
+			name:        "AbortsRunningCheck",
+			aborter:     &fakeAborter{running: map[string]bool{"check1": true}},
+			check:       "check1",
+			wantCode:    http.StatusAccepted,
+			wantAborted: []string{"check1"},
+		},
+		{
+			name:     "CheckNotRunning",
+			aborter:  &fakeAborter{running: map[string]bool{"check1": true}},
+			check:    "check2",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "AbortNotSupported",
+			check:    "check1",
+			wantCode: http.StatusNotImplemented,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := api.New(&log.NullLog{}, nil, fakeStats{})
+			if tt.aborter != nil {
+				a.Aborter = tt.aborter
+			}
+			router := httprouter.New()
+			NewREST(&log.NullLog{}, a, router)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/checks/"+tt.check+"/abort", nil))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("want code %d, got %d", tt.wantCode, rec.Code)
+			}
+			if tt.aborter == nil {
+				return
+			}
+			if diff := cmp.Diff(tt.wantAborted, tt.aborter.aborted); diff != "" {
+				t.Errorf("want aborted checks != got aborted checks, diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestREST_AbortScan(t *testing.T) {
+	tests := []struct {
+		name     string
+		aborter  *fakeAborter
+		scan     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "AbortsChecksOfScan",
+			aborter:  &fakeAborter{scans: map[string][]string{"scan1": {"check1", "check2"}}},
+			scan:     "scan1",
+			wantCode: http.StatusAccepted,
+			wantBody: `{"checks":["check1","check2"]}`,
+		},
+		{
+			name:     "ScanNotRunning",
+			aborter:  &fakeAborter{scans: map[string][]string{"scan1": {"check1"}}},
+			scan:     "scan2",
+			wantCode: http.StatusNotFound,
+			wantBody: `{"error":"scan not running"}`,
+		},
+		{
+			name:     "AbortNotSupported",
+			scan:     "scan1",
+			wantCode: http.StatusNotImplemented,
+			wantBody: `{"error":"abort not supported"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := api.New(&log.NullLog{}, nil, fakeStats{})
+			if tt.aborter != nil {
+				a.Aborter = tt.aborter
+			}
+			router := httprouter.New()
+			NewREST(&log.NullLog{}, a, router)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/scans/"+tt.scan+"/abort", nil))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("want code %d, got %d", tt.wantCode, rec.Code)
+			}
+			if diff := cmp.Diff(tt.wantBody, strings.TrimSpace(rec.Body.String())); diff != "" {
+				t.Errorf("want body != got body, diff: %s", diff)
+			}
+		})
+	}
+}
+
+type fakeLister []jobrunner.RunningCheck
+
+func (l fakeLister) RunningChecks() []jobrunner.RunningCheck {
+	return l
+}
+
+func (l fakeLister) RunningCheck(ID string) (jobrunner.RunningCheck, bool) {
+	for _, c := range l {
+		if c.CheckID == ID {
+			return c, true
+		}
+	}
+	return jobrunner.RunningCheck{}, false
+}
+
+type fakeStateUpdater struct {
+	states  []stateupdater.CheckState
+	reports []report.Report
+}
+
+func (u *fakeStateUpdater) UpdateState(s stateupdater.CheckState) error {
+	u.states = append(u.states, s)
+	return nil
+}
+
+func (u *fakeStateUpdater) UpdateCheckReport(checkID string, startTime time.Time, r report.Report) (string, error) {
+	u.reports = append(u.reports, r)
+	return "reports/" + checkID + ".json", nil
+}
+
+func TestREST_CheckProgress(t *testing.T) {
+	start := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	lister := fakeLister{{CheckID: "check1", Checktype: "vulcan-zap", Target: "example.com", StartTime: start}}
+	running := stateupdater.StatusRunning
+	progress := float32(0.5)
+	link := "reports/check1.json"
+	tests := []struct {
+		name        string
+		check       string
+		body        string
+		wantCode    int
+		wantStates  []stateupdater.CheckState
+		wantReports []report.Report
+	}{
+		{
+			name:       "ForwardsProgress",
+			check:      "check1",
+			body:       `{"progress": 0.5}`,
+			wantCode:   http.StatusOK,
+			wantStates: []stateupdater.CheckState{{ID: "check1", Status: &running, Progress: &progress}},
+		},
+		{
+			name:       "UploadsPartialFindings",
+			check:      "check1",
+			body:       `{"progress": 0.5, "findings": [{"summary": "XSS"}]}`,
+			wantCode:   http.StatusOK,
+			wantStates: []stateupdater.CheckState{{ID: "check1", Status: &running, Progress: &progress, Report: &link}},
+			wantReports: []report.Report{{
+				CheckData: report.CheckData{
+					CheckID:       "check1",
+					ChecktypeName: "vulcan-zap",
+					Target:        "example.com",
+					StartTime:     start,
+					Status:        running,
+				},
+				ResultData: report.ResultData{
+					Vulnerabilities: []report.Vulnerability{{Summary: "XSS"}},
+				},
+			}},
+		},
+		{
+			name:     "InvalidProgress",
+			check:    "check1",
+			body:     `{"progress": 50}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "CheckNotRunning",
+			check:    "check2",
+			body:     `{"progress": 0.5}`,
+			wantCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updater := &fakeStateUpdater{}
+			a := api.New(&log.NullLog{}, updater, fakeStats{})
+			a.Lister = lister
+			router := httprouter.New()
+			NewREST(&log.NullLog{}, a, router)
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPatch, "/check/"+tt.check+"/progress", strings.NewReader(tt.body))
+			router.ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("want code %d, got %d", tt.wantCode, rec.Code)
+			}
+			if diff := cmp.Diff(tt.wantStates, updater.states); diff != "" {
+				t.Errorf("states mismatch (-want +got):\n%v", diff)
+			}
+			if diff := cmp.Diff(tt.wantReports, updater.reports); diff != "" {
+				t.Errorf("reports mismatch (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestREST_CheckReport(t *testing.T) {
+	valid := `{"check_id": "check1", "checktype_name": "vulcan-zap", "checktype_version": "1",
+		"status": "FINISHED", "target": "example.com", "start_time": "2022-03-01T10:00:00Z",
+		"vulnerabilities": [{"summary": "XSS", "affected_resource": "https://example.com"}]}`
+	gzipped := &bytes.Buffer{}
+	gw := gzip.NewWriter(gzipped)
+	gw.Write([]byte(valid))
+	gw.Close()
+	tests := []struct {
+		name       string
+		check      string
+		body       []byte
+		gzip       bool
+		wantCode   int
+		wantStatus string
+	}{
+		{
+			name:       "UploadsTheReport",
+			check:      "check1",
+			body:       []byte(valid),
+			wantCode:   http.StatusOK,
+			wantStatus: stateupdater.StatusFinished,
+		},
+		{
+			name:       "UploadsAGzippedReport",
+			check:      "check1",
+			body:       gzipped.Bytes(),
+			gzip:       true,
+			wantCode:   http.StatusOK,
+			wantStatus: stateupdater.StatusFinished,
+		},
+		{
+			name:     "CheckIDMismatch",
+			check:    "check2",
+			body:     []byte(valid),
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "InvalidReport",
+			check:    "check1",
+			body:     []byte(`{"check_id": "check1", "status": "FINISHED"}`),
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "InvalidJSON",
+			check:    "check1",
+			body:     []byte(`{`),
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "TooBig",
+			check:    "check1",
+			body:     bytes.Repeat([]byte(" "), MaxReportSize+1),
+			wantCode: http.StatusRequestEntityTooLarge,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updater := &fakeStateUpdater{}
+			a := api.New(&log.NullLog{}, updater, fakeStats{})
+			router := httprouter.New()
+			NewREST(&log.NullLog{}, a, router)
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/check/"+tt.check+"/report", bytes.NewReader(tt.body))
+			if tt.gzip {
+				req.Header.Set("Content-Encoding", "gzip")
+			}
+			router.ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("want code %d, got %d: %s", tt.wantCode, rec.Code, rec.Body.String())
+			}
+			if tt.wantStatus == "" {
+				if len(updater.reports) > 0 || len(updater.states) > 0 {
+					t.Errorf("unexpected report uploaded")
+				}
+				return
+			}
+			if len(updater.reports) != 1 || updater.reports[0].Vulnerabilities[0].Summary != "XSS" {
+				t.Errorf("report not uploaded: %+v", updater.reports)
+			}
+			if len(updater.states) != 1 || *updater.states[0].Status != tt.wantStatus || updater.states[0].Report == nil {
+				t.Errorf("state not updated: %+v", updater.states)
+			}
+		})
+	}
+}
+
+func TestREST_RunningChecks(t *testing.T) {
+	start := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+	lister := fakeLister{
+		{CheckID: "check1", ScanID: "scan1", Checktype: "vulcan-nessus", Image: "vulcan-nessus:1", Target: "example.com", StartTime: start},
+		{CheckID: "check2", Checktype: "vulcan-zap", Image: "vulcan-zap:1", Target: "example.org", StartTime: start},
+	}
+	check1 := api.Check{ID: "check1", ScanID: "scan1", Checktype: "vulcan-nessus", Image: "vulcan-nessus:1", Target: "example.com", StartTime: start}
+	check2 := api.Check{ID: "check2", Checktype: "vulcan-zap", Image: "vulcan-zap:1", Target: "example.org", StartTime: start}
+	tests := []struct {
+		name     string
+		lister   api.CheckLister
+		path     string
+		wantCode int
+		want     interface{}
+	}{
+		{
+			name:     "ListChecks",
+			lister:   lister,
+			path:     "/checks",
+			wantCode: http.StatusOK,
+			want:     &ChecksResponse{Checks: []api.Check{check1, check2}},
+		},
+		{
+			name:     "ListChecksNoLister",
+			path:     "/checks",
+			wantCode: http.StatusOK,
+			want:     &ChecksResponse{Checks: []api.Check{}},
+		},
+		{
+			name:     "GetCheck",
+			lister:   lister,
+			path:     "/checks/check2",
+			wantCode: http.StatusOK,
+			want:     &CheckResponse{check2},
+		},
+		{
+			name:     "CheckNotRunning",
+			lister:   lister,
+			path:     "/checks/check3",
+			wantCode: http.StatusNotFound,
+			want:     &ErrorResponse{Error: api.ErrCheckNotRunning.Error()},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := api.New(&log.NullLog{}, nil, fakeStats{})
+			a.Lister = tt.lister
+			router := httprouter.New()
+			NewREST(&log.NullLog{}, a, router)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("want code %d, got %d", tt.wantCode, rec.Code)
+			}
+			got := reflect.New(reflect.TypeOf(tt.want).Elem()).Interface()
+			if err := json.Unmarshal(rec.Body.Bytes(), got); err != nil {
+				t.Fatalf("unexpected error decoding response: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got, cmpopts.IgnoreFields(api.Check{}, "Elapsed")); diff != "" {
+				t.Errorf("want response != got response, diff: %s", diff)
+			}
+		})
+	}
+}
+
+type fakeLogStreamer map[string]string
+
+func (s fakeLogStreamer) StreamLogs(ctx context.Context, checkID string, follow bool, w io.Writer) error {
+	logs, ok := s[checkID]
+	if !ok {
+		return backend.ErrCheckNotFound
+	}
+	if follow {
+		logs += "following\n"
+	}
+	_, err := io.WriteString(w, logs)
+	return err
+}
+
+func TestREST_CheckLogs(t *testing.T) {
+	streamer := fakeLogStreamer{"check1": "line1\nline2\n"}
+	tests := []struct {
+		name     string
+		streamer backend.LogStreamer
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Logs",
+			streamer: streamer,
+			path:     "/checks/check1/logs",
+			wantCode: http.StatusOK,
+			wantBody: "line1\nline2\n",
+		},
+		{
+			name:     "FollowLogs",
+			streamer: streamer,
+			path:     "/checks/check1/logs?follow=true",
+			wantCode: http.StatusOK,
+			wantBody: "line1\nline2\nfollowing\n",
+		},
+		{
+			name:     "InvalidFollow",
+			streamer: streamer,
+			path:     "/checks/check1/logs?follow=maybe",
+			wantCode: http.StatusBadRequest,
+			wantBody: "{\"error\":\"invalid follow param: maybe\"}\n",
+		},
+		{
+			name:     "CheckNotRunning",
+			streamer: streamer,
+			path:     "/checks/check2/logs",
+			wantCode: http.StatusNotFound,
+			wantBody: "{\"error\":\"check not running\"}\n",
+		},
+		{
+			name:     "LogsNotSupported",
+			path:     "/checks/check1/logs",
+			wantCode: http.StatusNotImplemented,
+			wantBody: "{\"error\":\"logs not supported\"}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := api.New(&log.NullLog{}, nil, fakeStats{})
+			a.Logs = tt.streamer
+			router := httprouter.New()
+			NewREST(&log.NullLog{}, a, router)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("want code %d, got %d", tt.wantCode, rec.Code)
+			}
+			if diff := cmp.Diff(tt.wantBody, rec.Body.String()); diff != "" {
+				t.Errorf("want body != got body, diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestREST_Events(t *testing.T) {
+	bus := events.NewBus()
+	a := api.New(&log.NullLog{}, nil, fakeStats{})
+	a.Events = bus
+	router := httprouter.New()
+	NewREST(&log.NullLog{}, a, router)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events?type=check.&check_id=check1", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("want code %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("want content type text/event-stream, got %s", ct)
+	}
+
+	// The subscription is created before the response headers are sent.
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	bus.Publish(events.Event{Type: events.TypeJobReceived, CheckID: "check1", Time: now})
+	bus.Publish(events.Event{Type: events.TypeCheckState, CheckID: "check2", Status: "RUNNING", Time: now})
+	bus.Publish(events.Event{Type: events.TypeCheckState, CheckID: "check1", Status: "RUNNING", Time: now})
+
+	r := bufio.NewReader(resp.Body)
+	var got []string
+	for len(got) < 3 {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got = append(got, line)
+	}
+	want := []string{
+		"event: check.state\n",
+		"data: {\"type\":\"check.state\",\"time\":\"2022-01-01T00:00:00Z\",\"check_id\":\"check1\",\"status\":\"RUNNING\"}\n",
+		"\n",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("events mismatch (-want +got):\n%v", diff)
+	}
+}
+
+func TestREST_EventsNotSupported(t *testing.T) {
+	router := httprouter.New()
+	NewREST(&log.NullLog{}, api.New(&log.NullLog{}, nil, fakeStats{}), router)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/events", nil))
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("want code %d, got %d", http.StatusNotImplemented, rec.Code)
+	}
+}
+
+type fakeExecer map[string]string
+
+func (e fakeExecer) Exec(ctx context.Context, checkID string, cmd []string) (backend.ExecResult, error) {
+	if _, ok := e[checkID]; !ok {
+		return backend.ExecResult{}, backend.ErrCheckNotFound
+	}
+	return backend.ExecResult{Output: []byte(e[checkID] + " " + strings.Join(cmd, " ")), ExitCode: 1}, nil
+}
+
+func TestREST_ExecCheck(t *testing.T) {
+	execer := fakeExecer{"check1": "exec"}
+	tests := []struct {
+		name     string
+		execer   backend.Execer
+		token    string
+		auth     string
+		path     string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Exec",
+			execer:   execer,
+			token:    "secret",
+			auth:     "Bearer secret",
+			path:     "/checks/check1/exec",
+			body:     `{"cmd":["ps","aux"]}`,
+			wantCode: http.StatusOK,
+			wantBody: `{"output":"exec ps aux","exit_code":1}`,
+		},
+		{
+			name:     "InvalidToken",
+			execer:   execer,
+			token:    "secret",
+			auth:     "Bearer other",
+			path:     "/checks/check1/exec",
+			body:     `{"cmd":["ps","aux"]}`,
+			wantCode: http.StatusUnauthorized,
+			wantBody: `{"error":"unauthorized"}`,
+		},
+		{
+			name:     "NoToken",
+			execer:   execer,
+			token:    "secret",
+			path:     "/checks/check1/exec",
+			body:     `{"cmd":["ps","aux"]}`,
+			wantCode: http.StatusUnauthorized,
+			wantBody: `{"error":"unauthorized"}`,
+		},
+		{
+			name:     "EmptyCommand",
+			execer:   execer,
+			token:    "secret",
+			auth:     "Bearer secret",
+			path:     "/checks/check1/exec",
+			body:     `{"cmd":[]}`,
+			wantCode: http.StatusBadRequest,
+			wantBody: `{"error":"empty command"}`,
+		},
+		{
+			name:     "CheckNotRunning",
+			execer:   execer,
+			token:    "secret",
+			auth:     "Bearer secret",
+			path:     "/checks/check2/exec",
+			body:     `{"cmd":["ps","aux"]}`,
+			wantCode: http.StatusNotFound,
+			wantBody: `{"error":"check not running"}`,
+		},
+		{
+			name:     "Disabled",
+			auth:     "Bearer secret",
+			path:     "/checks/check1/exec",
+			body:     `{"cmd":["ps","aux"]}`,
+			wantCode: http.StatusNotImplemented,
+			wantBody: `{"error":"exec not supported"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := api.New(&log.NullLog{}, nil, fakeStats{})
+			a.Execer = tt.execer
+			a.ExecToken = tt.token
+			router := httprouter.New()
+			NewREST(&log.NullLog{}, a, router)
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			router.ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("want code %d, got %d", tt.wantCode, rec.Code)
+			}
+			if diff := cmp.Diff(tt.wantBody, strings.TrimSpace(rec.Body.String())); diff != "" {
+				t.Errorf("want body != got body, diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestREST_Auth(t *testing.T) {
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{&x509.Certificate{}}}}
+	tests := []struct {
+		name     string
+		auth     Auth
+		path     string
+		token    string
+		tls      *tls.ConnectionState
+		wantCode int
+	}{
+		{
+			name:     "NoAuth",
+			path:     "/checks",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "ValidToken",
+			auth:     Auth{Token: "secret"},
+			path:     "/checks",
+			token:    "secret",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "InvalidToken",
+			auth:     Auth{Token: "secret"},
+			path:     "/checks",
+			token:    "other",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "MissingToken",
+			auth:     Auth{Token: "secret"},
+			path:     "/checks",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "PublicEndpoint",
+			auth:     Auth{Token: "secret", ClientCerts: true, RequireTLS: true},
+			path:     "/status",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "TLSRequired",
+			auth:     Auth{Token: "secret", RequireTLS: true},
+			path:     "/checks",
+			token:    "secret",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "ClientCert",
+			auth:     Auth{Token: "secret", ClientCerts: true, RequireTLS: true},
+			path:     "/checks",
+			tls:      verified,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "NoClientCert",
+			auth:     Auth{ClientCerts: true, RequireTLS: true},
+			path:     "/checks",
+			tls:      &tls.ConnectionState{},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "TokenWithoutClientCert",
+			auth:     Auth{Token: "secret", ClientCerts: true, RequireTLS: true},
+			path:     "/checks",
+			token:    "secret",
+			tls:      &tls.ConnectionState{},
+			wantCode: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := api.New(&log.NullLog{}, nil, fakeStats{})
+			router := httprouter.New()
+			rest := NewREST(&log.NullLog{}, a, router)
+			rest.Auth = tt.auth
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			req.TLS = tt.tls
+			router.ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("want code %d, got %d", tt.wantCode, rec.Code)
+			}
+		})
+	}
+}
+
+func TestREST_Health(t *testing.T) {
+	ok := api.ProbeFunc(func(ctx context.Context) error { return nil })
+	failing := api.ProbeFunc(func(ctx context.Context) error { return errors.New("unreachable") })
+	tests := []struct {
+		name      string
+		liveness  map[string]api.Probe
+		readiness map[string]api.Probe
+		drainer   *fakeDrainer
+		path      string
+		wantCode  int
+		want      api.Health
+	}{
+		{
+			name:      "Live",
+			liveness:  map[string]api.Probe{"tokens": ok},
+			readiness: map[string]api.Probe{"queue": failing},
+			path:      "/healthz",
+			wantCode:  http.StatusOK,
+			want: api.Health{
+				Status: api.HealthOK,
+				Checks: map[string]api.ProbeResult{"tokens": {Status: api.HealthOK}},
+			},
+		},
+		{
+			name:     "NotLive",
+			liveness: map[string]api.Probe{"tokens": failing},
+			path:     "/healthz",
+			wantCode: http.StatusServiceUnavailable,
+			want: api.Health{
+				Status: api.HealthFail,
+				Checks: map[string]api.ProbeResult{"tokens": {Status: api.HealthFail, Error: "unreachable"}},
+			},
+		},
+		{
+			name:      "Ready",
+			liveness:  map[string]api.Probe{"tokens": ok},
+			readiness: map[string]api.Probe{"backend": ok, "queue": ok},
+			drainer:   &fakeDrainer{},
+			path:      "/readyz",
+			wantCode:  http.StatusOK,
+			want: api.Health{
+				Status: api.HealthOK,
+				Checks: map[string]api.ProbeResult{
+					"tokens":  {Status: api.HealthOK},
+					"backend": {Status: api.HealthOK},
+					"queue":   {Status: api.HealthOK},
+					"agent":   {Status: api.HealthOK},
+				},
+			},
+		},
+		{
+			name:      "DependencyFailing",
+			liveness:  map[string]api.Probe{"tokens": ok},
+			readiness: map[string]api.Probe{"backend": ok, "queue": failing},
+			path:      "/readyz",
+			wantCode:  http.StatusServiceUnavailable,
+			want: api.Health{
+				Status: api.HealthFail,
+				Checks: map[string]api.ProbeResult{
+					"tokens":  {Status: api.HealthOK},
+					"backend": {Status: api.HealthOK},
+					"queue":   {Status: api.HealthFail, Error: "unreachable"},
+				},
+			},
+		},
+		{
+			name:     "Draining",
+			drainer:  &fakeDrainer{draining: true},
+			path:     "/readyz",
+			wantCode: http.StatusServiceUnavailable,
+			want: api.Health{
+				Status: api.HealthFail,
+				Checks: map[string]api.ProbeResult{
+					"agent": {Status: api.HealthFail, Error: "agent draining"},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := api.New(&log.NullLog{}, nil, fakeStats{})
+			a.LivenessProbes = tt.liveness
+			a.ReadinessProbes = tt.readiness
+			if tt.drainer != nil {
+				a.Drainer = tt.drainer
+			}
+			router := httprouter.New()
+			rest := NewREST(&log.NullLog{}, a, router)
+			rest.Auth = Auth{Token: "secret", RequireTLS: true}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("want code %d, got %d", tt.wantCode, rec.Code)
+			}
+			var got api.Health
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("unexpected error decoding response: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("want health != got health, diff: %s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+// Package audit implements an append-only log of the jobs received by the
+// agent and the executions of their checks, for forensic and compliance
+// purposes. Every line of the log is a JSON record signed with the
+// HMAC-SHA256 of its content, as described in the msgauth package, including
+// the signature of the previous line, so removing, reordering or modifying
+// lines can be detected with Verify.
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/msgauth"
+)
+
+// Events recorded in the audit log.
+const (
+	// EventReceived is recorded when a job is received, with the full
+	// message of the job.
+	EventReceived = "received"
+	// EventStarted is recorded when the check of a job starts running.
+	EventStarted = "started"
+	// EventFinished is recorded when the processing of a job finishes,
+	// whether its check ran or not.
+	EventFinished = "finished"
+)
+
+// Record is an entry of the audit log.
+type Record struct {
+	Seq     uint64    `json:"seq"`
+	Time    time.Time `json:"time"`
+	Event   string    `json:"event"`
+	AgentID string    `json:"agent_id,omitempty"`
+	CheckID string    `json:"check_id,omitempty"`
+	// Message is the body of the message of the job, only recorded when the
+	// job is received.
+	Message     string     `json:"message,omitempty"`
+	TimesRead   int        `json:"times_read,omitempty"`
+	Image       string     `json:"image,omitempty"`
+	ImageDigest string     `json:"image_digest,omitempty"`
+	StartTime   *time.Time `json:"start_time,omitempty"`
+	EndTime     *time.Time `json:"end_time,omitempty"`
+	ExitCode    *int       `json:"exit_code,omitempty"`
+	Status      string     `json:"status,omitempty"`
+	// Deleted tells if the message of the job was deleted from the queue
+	// when the job finished, otherwise it will be received again.
+	Deleted bool   `json:"deleted,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// Prev is the signature of the previous record of the log.
+	Prev string `json:"prev,omitempty"`
+}
+
+// Sink is implemented by the destinations of the lines of the audit log.
+type Sink interface {
+	Write(line []byte) error
+	Close() error
+}
+
+// Log signs the records and writes them to its sinks.
+type Log struct {
+	key     []byte
+	agentID string
+	sinks   []Sink
+	log     log.Logger
+
+	mu     sync.Mutex
+	seq    uint64
+	prev   string
+	closed bool
+}
+
+// New creates a Log that writes the records to the file and the URL defined
+// in the config. When the file already exists, the new records are appended
+// to it continuing its chain of signatures. The agentID identifies the agent
+// in the records, it defaults to the hostname.
+func New(l log.Logger, cfg config.AuditConfig, agentID string) (*Log, error) {
+	if cfg.Key == "" {
+		return nil, errors.New("the audit log requires a key")
+	}
+	if agentID == "" {
+		agentID, _ = os.Hostname()
+	}
+	a := &Log{key: []byte(cfg.Key), agentID: agentID, log: l}
+	if cfg.Path != "" {
+		fs, last, err := newFileSink(cfg.Path)
+		if err != nil {
+			return nil, err
+		}
+		a.sinks = append(a.sinks, fs)
+		if last != nil {
+			var r Record
+			if err := json.Unmarshal(last, &r); err != nil {
+				fs.Close()
+				return nil, fmt.Errorf("invalid last line in audit log %s: %w", cfg.Path, err)
+			}
+			_, sig, err := msgauth.Split(last)
+			if err != nil {
+				fs.Close()
+				return nil, fmt.Errorf("invalid last line in audit log %s: %w", cfg.Path, err)
+			}
+			a.seq, a.prev = r.Seq, sig
+		}
+	}
+	if cfg.URL != "" {
+		a.sinks = append(a.sinks, newHTTPSink(l, cfg))
+	}
+	return a, nil
+}
+
+// Record signs the given record and writes it to the sinks. The errors
+// writing it are logged.
+func (a *Log) Record(r Record) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		a.log.Errorf("discarding audit record of check %s, the audit log is closed", r.CheckID)
+		return
+	}
+	a.seq++
+	r.Seq = a.seq
+	r.AgentID = a.agentID
+	r.Prev = a.prev
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	r.Time = r.Time.UTC()
+	line, sig, err := sign(a.key, r)
+	if err != nil {
+		a.log.Errorf("error signing audit record of check %s: %+v", r.CheckID, err)
+		return
+	}
+	a.prev = sig
+	for _, s := range a.sinks {
+		if err := s.Write(line); err != nil {
+			a.log.Errorf("error writing audit record of check %s: %+v", r.CheckID, err)
+		}
+	}
+}
+
+// Close closes the sinks of the Log, waiting for the pending records to be
+// written.
+func (a *Log) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+	var errs []error
+	for _, s := range a.sinks {
+		if err := s.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("error closing audit log: %v", errs)
+	}
+	return nil
+}
+
+// sign encodes the record in JSON and appends to it the signature of the
+// encoded record. It returns the line and the signature.
+func sign(key []byte, r Record) ([]byte, string, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, "", err
+	}
+	return msgauth.Sign(key, data)
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package audit
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+)
+
+func TestLog_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	cfg := config.AuditConfig{Path: path, Key: "key"}
+	a, err := New(&log.NullLog{}, cfg, "agent1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	a.Record(Record{Event: EventReceived, CheckID: "check1", Message: `{"check_id":"check1"}`})
+	a.Record(Record{Event: EventStarted, CheckID: "check1", Image: "vulcansec/vulcan-nessus:1"})
+	if err := a.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The chain of signatures continues when the log is opened again.
+	a, err = New(&log.NullLog{}, cfg, "agent1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	a.Record(Record{Event: EventFinished, CheckID: "check1", Status: "FINISHED"})
+	if err := a.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	n, err := Verify(bytes.NewReader(data), []byte("key"))
+	if err != nil {
+		t.Fatalf("unexpected error verifying the log: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("got %d records, want 3", n)
+	}
+	if _, err := Verify(bytes.NewReader(data), []byte("other")); err == nil {
+		t.Errorf("log verified with a wrong key")
+	}
+	if !strings.Contains(string(data), `"agent_id":"agent1"`) {
+		t.Errorf("agent id not recorded: %s", data)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	var lines [][]byte
+	a := &Log{key: []byte("key"), log: &log.NullLog{}, sinks: []Sink{sinkFunc(func(l []byte) error {
+		lines = append(lines, l)
+		return nil
+	})}}
+	for _, id := range []string{"check1", "check2", "check3"} {
+		a.Record(Record{Event: EventReceived, CheckID: id})
+	}
+	join := func(ls ...[]byte) io.Reader {
+		return bytes.NewReader(bytes.Join(ls, []byte("\n")))
+	}
+	tests := []struct {
+		name    string
+		log     io.Reader
+		want    int
+		wantErr bool
+	}{
+		{
+			name: "Valid",
+			log:  join(lines...),
+			want: 3,
+		},
+		{
+			name: "Tail",
+			log:  join(lines[1:]...),
+			want: 2,
+		},
+		{
+			name:    "Modified",
+			log:     join(lines[0], bytes.Replace(lines[1], []byte("check2"), []byte("check9"), 1), lines[2]),
+			want:    1,
+			wantErr: true,
+		},
+		{
+			name:    "Removed",
+			log:     join(lines[0], lines[2]),
+			want:    1,
+			wantErr: true,
+		},
+		{
+			name:    "Reordered",
+			log:     join(lines[1], lines[0], lines[2]),
+			want:    1,
+			wantErr: true,
+		},
+		{
+			name:    "Unsigned",
+			log:     join(lines[0], []byte(`{"seq":2}`)),
+			want:    1,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Verify(tt.log, []byte("key"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %d records verified, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLog_URL(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		lines []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		lines = append(lines, string(body))
+		mu.Unlock()
+	}))
+	defer srv.Close()
+	a, err := New(&log.NullLog{}, config.AuditConfig{URL: srv.URL, Key: "key", Timeout: 1}, "agent1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	a.Record(Record{Event: EventReceived, CheckID: "check1"})
+	a.Record(Record{Event: EventFinished, CheckID: "check1"})
+	// Closing the log waits for the records to be sent.
+	if err := a.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	n, err := Verify(strings.NewReader(strings.Join(lines, "")), []byte("key"))
+	if err != nil {
+		t.Fatalf("unexpected error verifying the records sent: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("got %d records, want 2", n)
+	}
+}
+
+func TestNew_RequiresKey(t *testing.T) {
+	_, err := New(&log.NullLog{}, config.AuditConfig{Path: filepath.Join(t.TempDir(), "audit.log")}, "")
+	if err == nil {
+		t.Errorf("audit log created without a key")
+	}
+}
+
+type sinkFunc func(line []byte) error
+
+func (f sinkFunc) Write(line []byte) error {
+	return f(line)
+}
+
+func (f sinkFunc) Close() error {
+	return nil
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/retryer"
+)
+
+// httpQueueSize is the max number of lines pending to be sent to the URL of
+// the audit log. When it's full the new lines are discarded.
+const httpQueueSize = 1000
+
+// fileSink appends the lines of the audit log to a file, syncing it after
+// each line.
+type fileSink struct {
+	f *os.File
+}
+
+// newFileSink opens, or creates, the file in the given path for appending
+// lines to it. It also returns the last line of the file, nil if the file is
+// empty.
+func newFileSink(path string) (*fileSink, []byte, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening audit log %s: %w", path, err)
+	}
+	var last []byte
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 16*1024*1024)
+	for s.Scan() {
+		if len(bytes.TrimSpace(s.Bytes())) > 0 {
+			last = append(last[:0], s.Bytes()...)
+		}
+	}
+	if err := s.Err(); err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("error reading audit log %s: %w", path, err)
+	}
+	return &fileSink{f: f}, last, nil
+}
+
+func (s *fileSink) Write(line []byte) error {
+	if _, err := s.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+func (s *fileSink) Close() error {
+	return s.f.Close()
+}
+
+// httpSink posts the lines of the audit log to a URL. The lines are sent in
+// background, so a slow or unavailable endpoint doesn't delay the checks.
+type httpSink struct {
+	url     string
+	client  *http.Client
+	retryer retryer.Retryer
+	log     log.Logger
+	lines   chan []byte
+	wg      sync.WaitGroup
+}
+
+func newHTTPSink(l log.Logger, cfg config.AuditConfig) *httpSink {
+	s := &httpSink{
+		url:     cfg.URL,
+		client:  &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second},
+		retryer: retryer.NewRetryer(cfg.Retries, cfg.RetryInterval, l),
+		log:     l,
+		lines:   make(chan []byte, httpQueueSize),
+	}
+	s.wg.Add(1)
+	go s.send()
+	return s
+}
+
+func (s *httpSink) Write(line []byte) error {
+	select {
+	case s.lines <- line:
+		return nil
+	default:
+		return errors.New("too many audit records pending to be sent")
+	}
+}
+
+func (s *httpSink) send() {
+	defer s.wg.Done()
+	for line := range s.lines {
+		err := s.retryer.WithRetries("PostAuditRecord", func() error {
+			return s.post(line)
+		})
+		if err != nil {
+			s.log.Errorf("error sending audit record to %s: %+v", s.url, err)
+		}
+	}
+}
+
+func (s *httpSink) post(line []byte) error {
+	resp, err := s.client.Post(s.url, "application/x-ndjson", bytes.NewReader(append(line, '\n')))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Close waits for the pending lines to be sent.
+func (s *httpSink) Close() error {
+	close(s.lines)
+	s.wg.Wait()
+	return nil
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/adevinta/vulcan-agent/msgauth"
+)
+
+// Verify checks the signatures of the lines of an audit log and that they
+// form an unbroken chain. It returns the number of records verified or an
+// error describing the first invalid line.
+func Verify(r io.Reader, key []byte) (int, error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 16*1024*1024)
+	var (
+		n    int
+		prev string
+		seq  uint64
+	)
+	for s.Scan() {
+		line := bytes.TrimSpace(s.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		n++
+		data, err := msgauth.Check(key, line)
+		if err != nil {
+			return n - 1, fmt.Errorf("line %d: %w", n, err)
+		}
+		_, sig, _ := msgauth.Split(line)
+		var rec Record
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return n - 1, fmt.Errorf("line %d: %w", n, err)
+		}
+		// The first line verified can be in the middle of the log, e.g.
+		// after it was rotated.
+		if n > 1 && (rec.Prev != prev || rec.Seq != seq+1) {
+			return n - 1, fmt.Errorf("line %d: broken chain", n)
+		}
+		prev, seq = sig, rec.Seq
+	}
+	if err := s.Err(); err != nil {
+		return n, err
+	}
+	return n, nil
+}
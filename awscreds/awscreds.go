@@ -0,0 +1,93 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+// Package awscreds creates the AWS sessions used by the agent to access the
+// AWS services, with the default endpoint, region and credentials defined in
+// the config, and assuming a role when configured so.
+package awscreds
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// DefaultSessionName is the name of the sessions of the assumed roles when no
+// other is configured.
+const DefaultSessionName = config.DefaultAWSSessionName
+
+var (
+	defaultsMu sync.RWMutex
+	defaults   config.AWSConfig
+)
+
+// SetDefaults defines the endpoint, region, addressing style and credentials
+// of the sessions returned by NewSession. The endpoint and the region are
+// used by the clients that don't define their own, and the credentials when
+// no credentials are passed to NewSession. It must be called before creating
+// the clients.
+func SetDefaults(cfg config.AWSConfig) {
+	defaultsMu.Lock()
+	defer defaultsMu.Unlock()
+	defaults = cfg
+}
+
+// NewSession returns an AWS session that uses the given credentials, or the
+// default ones if they are empty. When no static keys are defined the
+// credentials of the environment are used. When a role is defined the
+// credentials are used to assume the role and the session refreshes the
+// temporary credentials of the role before they expire.
+func NewSession(creds config.AWSCredentialsConfig) (*session.Session, error) {
+	defaultsMu.RLock()
+	d := defaults
+	defaultsMu.RUnlock()
+	if creds == (config.AWSCredentialsConfig{}) {
+		creds = d.Credentials
+	}
+	awsCfg := aws.NewConfig()
+	if d.Endpoint != "" {
+		awsCfg = awsCfg.WithEndpoint(d.Endpoint)
+	}
+	if d.Region != "" {
+		awsCfg = awsCfg.WithRegion(d.Region)
+	}
+	if d.S3ForcePathStyle {
+		awsCfg = awsCfg.WithS3ForcePathStyle(true)
+	}
+	if creds.AccessKeyID != "" {
+		awsCfg = awsCfg.WithCredentials(credentials.NewStaticCredentials(creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken))
+	}
+	sess, err := session.NewSession(awsCfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating AWS session %w", err)
+	}
+	if creds.RoleARN == "" {
+		return sess, nil
+	}
+	stsCfg := aws.NewConfig()
+	if creds.STSRegion != "" {
+		stsCfg = stsCfg.WithRegion(creds.STSRegion).WithSTSRegionalEndpoint(endpoints.RegionalSTSEndpoint)
+	}
+	if creds.STSEndpoint != "" {
+		stsCfg = stsCfg.WithEndpoint(creds.STSEndpoint)
+	}
+	name := creds.SessionName
+	if name == "" {
+		name = DefaultSessionName
+	}
+	role := stscreds.NewCredentialsWithClient(sts.New(sess, stsCfg), creds.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = name
+		if creds.ExternalID != "" {
+			p.ExternalID = aws.String(creds.ExternalID)
+		}
+	})
+	return session.NewSession(awsCfg.Copy().WithCredentials(role))
+}
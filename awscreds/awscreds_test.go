@@ -0,0 +1,117 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package awscreds
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const assumeRoleResponse = `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ROLEKEY</AccessKeyId>
+      <SecretAccessKey>rolesecret</SecretAccessKey>
+      <SessionToken>roletoken</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`
+
+func TestNewSession(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "ENVKEY")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "envsecret")
+	t.Setenv("AWS_REGION", "eu-west-1")
+
+	var form url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		form = r.PostForm
+		w.Header().Set("Content-Type", "text/xml")
+		exp := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+		w.Write([]byte(fmt.Sprintf(assumeRoleResponse, exp)))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name         string
+		defaults     config.AWSConfig
+		cfg          config.AWSCredentialsConfig
+		wantKey      string
+		wantEndpoint string
+		// wantForm contains the params expected in the AssumeRole request.
+		wantForm map[string]string
+	}{
+		{
+			name:    "Environment",
+			wantKey: "ENVKEY",
+		},
+		{
+			name:    "StaticKeys",
+			cfg:     config.AWSCredentialsConfig{AccessKeyID: "STATICKEY", SecretAccessKey: "secret"},
+			wantKey: "STATICKEY",
+		},
+		{
+			name: "Defaults",
+			defaults: config.AWSConfig{
+				Endpoint:    "http://localstack:4566",
+				Credentials: config.AWSCredentialsConfig{AccessKeyID: "test", SecretAccessKey: "test"},
+			},
+			wantKey:      "test",
+			wantEndpoint: "http://localstack:4566",
+		},
+		{
+			name: "AssumeRole",
+			cfg: config.AWSCredentialsConfig{
+				RoleARN:     "arn:aws:iam::123456789012:role/vulcan-agent",
+				ExternalID:  "external",
+				STSEndpoint: srv.URL,
+			},
+			wantKey: "ROLEKEY",
+			wantForm: map[string]string{
+				"Action":          "AssumeRole",
+				"RoleArn":         "arn:aws:iam::123456789012:role/vulcan-agent",
+				"ExternalId":      "external",
+				"RoleSessionName": DefaultSessionName,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form = nil
+			SetDefaults(tt.defaults)
+			defer SetDefaults(config.AWSConfig{})
+			sess, err := NewSession(tt.cfg)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			creds, err := sess.Config.Credentials.Get()
+			if err != nil {
+				t.Fatalf("unexpected error getting credentials: %v", err)
+			}
+			if creds.AccessKeyID != tt.wantKey {
+				t.Errorf("got access key %q, want %q", creds.AccessKeyID, tt.wantKey)
+			}
+			if got := aws.StringValue(sess.Config.Endpoint); got != tt.wantEndpoint {
+				t.Errorf("got endpoint %q, want %q", got, tt.wantEndpoint)
+			}
+			for k, v := range tt.wantForm {
+				if got := form.Get(k); got != v {
+					t.Errorf("got %s %q, want %q", k, got, v)
+				}
+			}
+		})
+	}
+}
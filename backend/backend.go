@@ -7,8 +7,13 @@ package backend
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/adevinta/vulcan-agent/config"
 	"github.com/docker/distribution/reference"
 )
 
@@ -28,15 +33,31 @@ const (
 // finished with an exit code different from 0.
 var ErrNonZeroExitCode = errors.New("container finished unexpectedly")
 
+// ErrCheckNotFound is returned by the backends when they are asked about a
+// check that they are not running.
+var ErrCheckNotFound = errors.New("check not found")
+
 // RunResult defines the info that must be returned when a check is
 // finished.
 type RunResult struct {
 	Output []byte
 	Error  error
+	// Artifacts contains the files collected from the check after it
+	// finished, if the backend supports it.
+	Artifacts []Artifact
+}
+
+// Artifact is a file produced by a check, e.g. a pcap or a screenshot.
+type Artifact struct {
+	// Name is the path of the file relative to the artifact path it was
+	// collected from.
+	Name string
+	Data []byte
 }
 
 type RunParams struct {
 	CheckID          string
+	ScanID           string
 	CheckTypeName    string
 	ChecktypeVersion string
 	Image            string
@@ -45,11 +66,117 @@ type RunParams struct {
 	Options          string
 	RequiredVars     []string
 	Metadata         map[string]string
+	// Vars contains values for the required vars that are specific to this
+	// run, like short-lived credentials. They take precedence over the check
+	// vars configured in the backend.
+	Vars map[string]string
+	// KillGrace is the time the backend waits for the check to stop, when
+	// the context of the run is done, before killing it. 0 means using the
+	// default of the backend.
+	KillGrace time.Duration
 }
 
 // CheckVars contains the static checks vars that some checks needs to be
-// injected in their docker to run.
-type CheckVars = map[string]string
+// injected in their docker to run. The vars of a checktype are only injected
+// in the checks of that checktype, so the credentials of a scanner are never
+// available to other checktypes, and take precedence over the global vars.
+// The Templates define vars injected in all the checks whose values depend
+// on the job.
+type CheckVars struct {
+	Global     map[string]string
+	Checktypes map[string]map[string]string
+	Templates  EnvTemplates
+}
+
+// NewCheckVars returns the CheckVars defined in the given config.
+func NewCheckVars(cfg config.CheckConfig) (CheckVars, error) {
+	tmpls, err := NewEnvTemplates(cfg.EnvTemplates)
+	if err != nil {
+		return CheckVars{}, err
+	}
+	return CheckVars{Global: cfg.Vars, Checktypes: cfg.ChecktypeVars, Templates: tmpls}, nil
+}
+
+// Lookup returns the value of a var for the checks of the given checktype.
+func (v CheckVars) Lookup(checktype, name string) (string, bool) {
+	if value, ok := v.Checktypes[checktype][name]; ok {
+		return value, true
+	}
+	value, ok := v.Global[name]
+	return value, ok
+}
+
+// TemplateEnv returns the vars rendered from the env templates for the given
+// run. The templates can't override the vars set by the agent nor the
+// required vars of the run.
+func (v CheckVars) TemplateEnv(params RunParams) []string {
+	if len(v.Templates) == 0 {
+		return nil
+	}
+	reserved := map[string]bool{
+		CheckIDVar:          true,
+		ChecktypeNameVar:    true,
+		ChecktypeVersionVar: true,
+		CheckTargetVar:      true,
+		CheckAssetTypeVar:   true,
+		CheckOptionsVar:     true,
+		CheckLogLevelVar:    true,
+		AgentAddressVar:     true,
+	}
+	for _, name := range params.RequiredVars {
+		reserved[name] = true
+	}
+	return v.Templates.Env(params, reserved)
+}
+
+// CheckEnv returns the environment variables, in the form KEY=value, that
+// must be injected in a check. The vars of the run take precedence over the
+// given check vars.
+func CheckEnv(params RunParams, agentAddr string, checkVars CheckVars) []string {
+	env := []string{
+		fmt.Sprintf("%s=%s", CheckIDVar, params.CheckID),
+		fmt.Sprintf("%s=%s", ChecktypeNameVar, params.CheckTypeName),
+		fmt.Sprintf("%s=%s", ChecktypeVersionVar, params.ChecktypeVersion),
+		fmt.Sprintf("%s=%s", CheckTargetVar, params.Target),
+		fmt.Sprintf("%s=%s", CheckAssetTypeVar, params.AssetType),
+		fmt.Sprintf("%s=%s", CheckOptionsVar, params.Options),
+		fmt.Sprintf("%s=%s", AgentAddressVar, agentAddr),
+	}
+	env = append(env, checkVars.TemplateEnv(params)...)
+	for _, v := range params.RequiredVars {
+		value, ok := params.Vars[v]
+		if !ok {
+			value, _ = checkVars.Lookup(params.CheckTypeName, v)
+		}
+		env = append(env, fmt.Sprintf("%s=%s", v, value))
+	}
+	return env
+}
+
+// ErrMissingVars is returned by the backends when some of the vars required
+// by a check have no value, so the check is not started with, e.g., empty
+// credentials.
+var ErrMissingVars = errors.New("missing required vars")
+
+// CheckRequiredVars returns an error wrapping ErrMissingVars, with the names
+// of the vars, if any of the required vars of a run is not defined, or is
+// empty, in the vars of the run or in the given check vars.
+func CheckRequiredVars(params RunParams, checkVars CheckVars) error {
+	var missing []string
+	for _, v := range params.RequiredVars {
+		value, ok := params.Vars[v]
+		if !ok {
+			value, _ = checkVars.Lookup(params.CheckTypeName, v)
+		}
+		if value == "" {
+			missing = append(missing, v)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s", ErrMissingVars, strings.Join(missing, ", "))
+	}
+	return nil
+}
 
 // APIConfig defines address where a component of the agent will be listening to
 // the http requests sent by the checks running.
@@ -64,6 +191,91 @@ type Backend interface {
 	Run(ctx context.Context, params RunParams) (<-chan RunResult, error)
 }
 
+// CheckVarsSetter is implemented by the backends that allow to change the
+// check vars while they are running.
+type CheckVarsSetter interface {
+	SetCheckVars(vars CheckVars)
+}
+
+// RegistryAuthsSetter is implemented by the backends that allow to change the
+// credentials of the registries while they are running.
+type RegistryAuthsSetter interface {
+	SetRegistryAuths(cfg config.RegistryConfig) error
+}
+
+// LogStreamer is implemented by the backends that can stream the output of
+// the checks while they are running. StreamLogs writes the output of the
+// check with the given ID to the writer and, if follow is true, keeps writing
+// it until the check finishes or the context is done. It returns
+// ErrCheckNotFound if the check is not running.
+type LogStreamer interface {
+	StreamLogs(ctx context.Context, checkID string, follow bool, w io.Writer) error
+}
+
+// ExecResult contains the output and the exit code of a command executed
+// inside a running check.
+type ExecResult struct {
+	Output   []byte
+	ExitCode int
+}
+
+// Execer is implemented by the backends that can execute commands inside the
+// running checks. Exec returns ErrCheckNotFound if the check is not running.
+type Execer interface {
+	Exec(ctx context.Context, checkID string, cmd []string) (ExecResult, error)
+}
+
+// Pinger is implemented by the backends that can check if the runtime they
+// use to run the checks is available.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// ImageDigester is implemented by the backends that can return the digest of
+// an image.
+type ImageDigester interface {
+	ImageDigest(ctx context.Context, image string) (string, error)
+}
+
+// OrphanReaper is implemented by the backends that can remove the checks left
+// running by previous executions of the agent, e.g. because it crashed.
+// ReapOrphans returns the IDs of the checks removed.
+type OrphanReaper interface {
+	ReapOrphans(ctx context.Context) ([]string, error)
+}
+
+// PrePuller is implemented by the backends that can pull in advance the
+// images of the checks, so the first checks of each checktype don't wait for
+// their images to be pulled. PrePull pulls them in background until the
+// context is done and returns a channel that is closed when it finishes.
+type PrePuller interface {
+	PrePull(ctx context.Context) <-chan struct{}
+}
+
+// ExitCode returns the exit code contained in an error returned by a backend
+// when a check finished with an exit code different from 0. It returns false
+// if the error doesn't wrap ErrNonZeroExitCode or doesn't contain the code.
+func ExitCode(err error) (int, bool) {
+	if !errors.Is(err, ErrNonZeroExitCode) {
+		return 0, false
+	}
+	msg := err.Error()
+	i := strings.LastIndex(msg, "exit: ")
+	if i < 0 {
+		return 0, false
+	}
+	msg = msg[i+len("exit: "):]
+	end := 0
+	for end < len(msg) && (msg[end] == '-' && end == 0 || msg[end] >= '0' && msg[end] <= '9') {
+		end++
+	}
+	code, err := strconv.Atoi(msg[:end])
+	if err != nil {
+		return 0, false
+	}
+	return code, true
+}
+
 // ParseImage validates and enrich the image with domain (docker.io if domain missing), tag (latest if missing),.
 func ParseImage(image string) (domain, path, tag string, err error) {
 	named, err := reference.ParseNormalizedNamed(image)
@@ -84,3 +296,19 @@ func ParseImage(image string) (domain, path, tag string, err error) {
 	}
 	return
 }
+
+// ChecktypeInfo extracts the checktype name and version from a Docker image
+// URI.
+func ChecktypeInfo(imageURI string) (checktypeName string, checktypeVersion string, err error) {
+	domain, path, tag, err := ParseImage(imageURI)
+	if err != nil {
+		err = fmt.Errorf("unable to parse image %s - %w", imageURI, err)
+		return
+	}
+	checktypeName = fmt.Sprintf("%s/%s", domain, path)
+	if domain == "docker.io" {
+		checktypeName = path
+	}
+	checktypeVersion = tag
+	return
+}
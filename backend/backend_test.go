@@ -0,0 +1,167 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantCode int
+		wantOK   bool
+	}{
+		{
+			name:     "NonZeroExitCode",
+			err:      fmt.Errorf("%w exit: %d", ErrNonZeroExitCode, 2),
+			wantCode: 2,
+			wantOK:   true,
+		},
+		{
+			name:     "Wrapped",
+			err:      fmt.Errorf("running check: %w", fmt.Errorf("%w exit: %d", ErrNonZeroExitCode, 137)),
+			wantCode: 137,
+			wantOK:   true,
+		},
+		{
+			name:   "WithoutCode",
+			err:    ErrNonZeroExitCode,
+			wantOK: false,
+		},
+		{
+			name:   "OtherError",
+			err:    errors.New("exit: 1"),
+			wantOK: false,
+		},
+		{
+			name:   "Canceled",
+			err:    context.Canceled,
+			wantOK: false,
+		},
+		{
+			name:   "Nil",
+			wantOK: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, ok := ExitCode(tt.err)
+			if ok != tt.wantOK || code != tt.wantCode {
+				t.Errorf("got (%d, %v), want (%d, %v)", code, ok, tt.wantCode, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestCheckEnv_ChecktypeVars(t *testing.T) {
+	checkVars := CheckVars{
+		Global: map[string]string{"TOKEN": "token", "PASSWORD": "global"},
+		Checktypes: map[string]map[string]string{
+			"vulcan-nessus": {"PASSWORD": "nessus", "NESSUS_KEY": "key"},
+		},
+	}
+	tests := []struct {
+		name   string
+		params RunParams
+		want   []string
+	}{
+		{
+			name: "Checktype",
+			params: RunParams{
+				CheckTypeName: "vulcan-nessus",
+				RequiredVars:  []string{"TOKEN", "PASSWORD", "NESSUS_KEY"},
+			},
+			want: []string{"TOKEN=token", "PASSWORD=nessus", "NESSUS_KEY=key"},
+		},
+		{
+			name: "OtherChecktype",
+			params: RunParams{
+				CheckTypeName: "vulcan-zap",
+				RequiredVars:  []string{"TOKEN", "PASSWORD", "NESSUS_KEY"},
+			},
+			want: []string{"TOKEN=token", "PASSWORD=global", "NESSUS_KEY="},
+		},
+		{
+			name: "RunVars",
+			params: RunParams{
+				CheckTypeName: "vulcan-nessus",
+				RequiredVars:  []string{"PASSWORD"},
+				Vars:          map[string]string{"PASSWORD": "dynamic"},
+			},
+			want: []string{"PASSWORD=dynamic"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := CheckEnv(tt.params, "", checkVars)
+			// The required vars are the last ones.
+			got := env[len(env)-len(tt.params.RequiredVars):]
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("env mismatch (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestCheckRequiredVars(t *testing.T) {
+	checkVars := CheckVars{
+		Global: map[string]string{"TOKEN": "token", "EMPTY": ""},
+		Checktypes: map[string]map[string]string{
+			"vulcan-nessus": {"NESSUS_KEY": "key"},
+		},
+	}
+	tests := []struct {
+		name    string
+		params  RunParams
+		wantErr string
+	}{
+		{
+			name: "AllDefined",
+			params: RunParams{
+				CheckTypeName: "vulcan-nessus",
+				RequiredVars:  []string{"TOKEN", "NESSUS_KEY", "DYNAMIC"},
+				Vars:          map[string]string{"DYNAMIC": "value"},
+			},
+		},
+		{
+			name: "Missing",
+			params: RunParams{
+				CheckTypeName: "vulcan-zap",
+				RequiredVars:  []string{"TOKEN", "NESSUS_KEY", "ZAP_KEY"},
+			},
+			wantErr: "missing required vars: NESSUS_KEY, ZAP_KEY",
+		},
+		{
+			name: "Empty",
+			params: RunParams{
+				CheckTypeName: "vulcan-nessus",
+				RequiredVars:  []string{"EMPTY", "TOKEN"},
+				Vars:          map[string]string{"TOKEN": ""},
+			},
+			wantErr: "missing required vars: EMPTY, TOKEN",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckRequiredVars(tt.params, checkVars)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrMissingVars) || err.Error() != tt.wantErr {
+				t.Errorf("got error %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,325 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+// Package containerd implements a backend that runs the checks in containerd,
+// bypassing the Docker daemon, for the hosts that only run containerd. It
+// drives containerd through its ctr command line tool.
+package containerd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	osexec "os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/retryer"
+	"github.com/docker/distribution/reference"
+)
+
+// Default values of the config of the backend.
+const (
+	DefaultAddress   = config.DefaultContainerdAddress
+	DefaultNamespace = config.DefaultContainerdNamespace
+	DefaultCtr       = config.DefaultContainerdCtr
+)
+
+const (
+	abortTimeout     = 5 * time.Second
+	defaultAgentHost = "localhost"
+)
+
+// Containerd implements a backend that runs the checks as containerd tasks.
+type Containerd struct {
+	ctrPath   string
+	address   string
+	namespace string
+	registry  config.RegistryConfig
+	agentAddr string
+	checkVars backend.CheckVars
+	// mu protects the check vars and the registry credentials.
+	mu      sync.RWMutex
+	retryer retryer.Retryer
+	log     log.Logger
+}
+
+func init() {
+	backend.Register(config.BackendContainerd, func(l log.Logger, cfg config.Config) (backend.Backend, error) {
+		b, err := NewBackend(l, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return b, nil
+	})
+}
+
+// NewBackend creates a new containerd backend using the given config. It
+// checks that containerd is reachable before returning.
+func NewBackend(log log.Logger, cfg config.Config) (*Containerd, error) {
+	ccfg := cfg.Runtime.Containerd
+	if ccfg.Address == "" {
+		ccfg.Address = DefaultAddress
+	}
+	if ccfg.Namespace == "" {
+		ccfg.Namespace = DefaultNamespace
+	}
+	if ccfg.Ctr == "" {
+		ccfg.Ctr = DefaultCtr
+	}
+	host := cfg.API.Host
+	if host == "" {
+		host = defaultAgentHost
+	}
+	checkVars, err := backend.NewCheckVars(cfg.Check)
+	if err != nil {
+		return nil, err
+	}
+	reg := cfg.Runtime.Docker.Registry
+	b := &Containerd{
+		ctrPath:   ccfg.Ctr,
+		address:   ccfg.Address,
+		namespace: ccfg.Namespace,
+		registry:  reg,
+		agentAddr: host + cfg.API.Port,
+		checkVars: checkVars,
+		retryer:   retryer.NewRetryer(reg.BackoffMaxRetries, reg.BackoffInterval, log),
+		log:       log,
+	}
+	if err := b.Ping(context.Background()); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Run starts executing a check as a containerd task and returns a channel
+// that will contain the result of the execution when it finishes.
+func (b *Containerd) Run(ctx context.Context, params backend.RunParams) (<-chan backend.RunResult, error) {
+	b.mu.RLock()
+	err := backend.CheckRequiredVars(params, b.checkVars)
+	b.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	named, err := reference.ParseNormalizedNamed(params.Image)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image %s: %w", params.Image, err)
+	}
+	// containerd requires the fully qualified reference of the images, e.g.:
+	// docker.io/library/alpine:latest for alpine.
+	named = reference.TagNameOnly(named)
+	ref := named.String()
+	if err := b.pull(ctx, ref, reference.Domain(named)); err != nil {
+		return nil, err
+	}
+	res := make(chan backend.RunResult)
+	go b.run(ctx, ref, params, res)
+	return res, nil
+}
+
+func (b *Containerd) run(ctx context.Context, ref string, params backend.RunParams, res chan<- backend.RunResult) {
+	// The checks use the network of the host, so they can reach the agent in
+	// the address of its API and the targets without configuring CNI.
+	args := []string{"run", "--rm", "--net-host"}
+	b.mu.RLock()
+	for _, e := range backend.CheckEnv(params, b.agentAddr, b.checkVars) {
+		args = append(args, "--env", e)
+	}
+	b.mu.RUnlock()
+	args = append(args, ref, params.CheckID)
+
+	bout, berr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd := b.command(args...)
+	cmd.Stdout = bout
+	cmd.Stderr = berr
+	if err := cmd.Start(); err != nil {
+		err = fmt.Errorf("error starting task for check %s: %w", params.CheckID, err)
+		res <- backend.RunResult{Error: err}
+		return
+	}
+	waitC := make(chan error, 1)
+	go func() {
+		waitC <- cmd.Wait()
+	}()
+	var err error
+	select {
+	case err = <-waitC:
+	case <-ctx.Done():
+		// Send a SIGTERM to the task and, if it doesn't finish in the
+		// configured time, a SIGKILL, as the docker backend does when it
+		// stops a container.
+		b.log.Infof("check: %s timeout or aborted ensure task is stopped", params.CheckID)
+		timeout := abortTimeout
+		if params.KillGrace > 0 {
+			timeout = params.KillGrace
+		}
+		if err := b.kill(params.CheckID, "SIGTERM"); err != nil {
+			b.log.Errorf("error stopping task of check %s: %+v", params.CheckID, err)
+		}
+		select {
+		case <-waitC:
+		case <-time.After(timeout):
+			if err := b.kill(params.CheckID, "SIGKILL"); err != nil {
+				b.log.Errorf("error killing task of check %s: %+v", params.CheckID, err)
+			}
+			<-waitC
+		}
+		res <- backend.RunResult{Output: output(bout, berr), Error: ctx.Err()}
+		return
+	}
+	var exitErr *osexec.ExitError
+	if errors.As(err, &exitErr) {
+		err = fmt.Errorf("%w exit: %d", backend.ErrNonZeroExitCode, exitErr.ExitCode())
+	} else if err != nil {
+		err = fmt.Errorf("error running task for check %s: %w", params.CheckID, err)
+	}
+	res <- backend.RunResult{Output: output(bout, berr), Error: err}
+}
+
+// kill sends the given signal to the task of a check.
+func (b *Containerd) kill(checkID, signal string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), abortTimeout)
+	defer cancel()
+	_, err := b.ctr(ctx, "task", "kill", "--signal", signal, checkID)
+	return err
+}
+
+// pull pulls the given image according to the configured pull policy.
+func (b *Containerd) pull(ctx context.Context, ref, domain string) error {
+	if b.registry.PullPolicy == config.PullPolicyNever {
+		return nil
+	}
+	if b.registry.PullPolicy == config.PullPolicyIfNotPresent {
+		exists, err := b.imageExists(ctx, ref)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return nil
+		}
+	}
+	args := []string{"images", "pull"}
+	auth := b.registryAuth(domain)
+	if auth != nil {
+		args = append(args, "--user", auth.User+":"+auth.Pass)
+	}
+	args = append(args, ref)
+	start := time.Now()
+	err := b.retryer.WithRetries("PullContainerdImage", func() error {
+		_, err := b.ctr(ctx, args...)
+		return err
+	})
+	b.log.Infof(
+		"pulled image=%s domain=%s auth=%v duration=%f err=%v",
+		ref,
+		domain,
+		auth != nil,
+		time.Since(start).Seconds(),
+		err,
+	)
+	return err
+}
+
+func (b *Containerd) imageExists(ctx context.Context, ref string) (bool, error) {
+	out, err := b.ctr(ctx, "images", "ls", "--quiet")
+	if err != nil {
+		return false, err
+	}
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		if strings.TrimSpace(s.Text()) == ref {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// registryAuth returns the credentials configured for the given registry
+// domain, if any.
+func (b *Containerd) registryAuth(domain string) *config.Auth {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	auths := append([]config.Auth{}, b.registry.Auths...)
+	if b.registry.Server != "" {
+		auths = append(auths, config.Auth{
+			Server: b.registry.Server,
+			User:   b.registry.User,
+			Pass:   b.registry.Pass,
+		})
+	}
+	for _, a := range auths {
+		server := strings.TrimPrefix(strings.TrimPrefix(a.Server, "https://"), "http://")
+		server = strings.TrimSuffix(server, "/")
+		if server == domain {
+			return &a
+		}
+	}
+	return nil
+}
+
+// Ping checks that containerd is reachable.
+func (b *Containerd) Ping(ctx context.Context) error {
+	if _, err := b.ctr(ctx, "version"); err != nil {
+		return fmt.Errorf("error pinging containerd: %w", err)
+	}
+	return nil
+}
+
+// SetCheckVars replaces the vars available to the checks. It only affects to
+// the checks started after calling it.
+func (b *Containerd) SetCheckVars(vars backend.CheckVars) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.checkVars = vars
+}
+
+// SetRegistryAuths replaces the credentials of the registries used to pull
+// the images. Contrary to the docker backend, the credentials are not
+// validated until they are used.
+func (b *Containerd) SetRegistryAuths(cfg config.RegistryConfig) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.registry.Auths = cfg.Auths
+	b.registry.Server = cfg.Server
+	b.registry.User = cfg.User
+	b.registry.Pass = cfg.Pass
+	return nil
+}
+
+// command returns the ctr command with the given args, connected to the
+// configured containerd address and namespace.
+func (b *Containerd) command(args ...string) *osexec.Cmd {
+	return osexec.Command(b.ctrPath, b.args(args)...)
+}
+
+// ctr runs a ctr command that is expected to finish quickly and returns its
+// stdout.
+func (b *Containerd) ctr(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := osexec.CommandContext(ctx, b.ctrPath, b.args(args)...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error running ctr %s: %w, %s", args[0], err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return out, nil
+}
+
+// args prepends the global args that select the containerd address and
+// namespace to the given args of a ctr command.
+func (b *Containerd) args(args []string) []string {
+	return append([]string{"--address", b.address, "--namespace", b.namespace}, args...)
+}
+
+// output joins the stdout and the stderr of a check in the same way the
+// docker backend does with the logs of the containers.
+func output(stdout, stderr *bytes.Buffer) []byte {
+	return bytes.Join([][]byte{stdout.Bytes(), stderr.Bytes()}, []byte("\n"))
+}
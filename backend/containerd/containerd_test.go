@@ -0,0 +1,175 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package containerd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeCtr is a ctr replacement that records the commands it receives in the
+// file calls of its directory. The tasks print their env and exit with the
+// code defined by the EXIT var, or wait until they are killed if it's
+// "wait".
+const fakeCtr = `#!/bin/sh
+dir=$(dirname "$0")
+shift 4
+echo "$@" >> "$dir/calls"
+case "$1 $2" in
+"images ls")
+	echo docker.io/library/present:latest
+	;;
+"task kill")
+	touch "$dir/killed"
+	;;
+run*)
+	code=0
+	for arg in "$@"; do
+		case "$arg" in
+		VULCAN_CHECK_TARGET=*|VAR*) echo "$arg" ;;
+		EXIT=*) code=${arg#EXIT=} ;;
+		esac
+	done
+	if [ "$code" = wait ]; then
+		while [ ! -f "$dir/killed" ]; do sleep 0.05; done
+		echo killed >&2
+		exit 143
+	fi
+	exit $code
+	;;
+esac
+`
+
+func TestContainerd_Run(t *testing.T) {
+	tests := []struct {
+		name      string
+		params    backend.RunParams
+		policy    config.PullPolicy
+		timeout   time.Duration
+		want      string
+		wantErr   error
+		wantCalls []string
+	}{
+		{
+			name: "RunsTheCheck",
+			params: backend.RunParams{
+				CheckID:      "id",
+				Image:        "vulcan-check:1",
+				Target:       "example.com",
+				RequiredVars: []string{"VAR1"},
+				Vars:         map[string]string{"VAR1": "run"},
+			},
+			policy: config.PullPolicyAlways,
+			want:   "VULCAN_CHECK_TARGET=example.com\nVAR1=run\n\n",
+			wantCalls: []string{
+				"version",
+				"images pull --user user:pass docker.io/library/vulcan-check:1",
+			},
+		},
+		{
+			name: "DoesNotPullPresentImages",
+			params: backend.RunParams{
+				CheckID: "id",
+				Image:   "present",
+			},
+			policy:    config.PullPolicyIfNotPresent,
+			want:      "VULCAN_CHECK_TARGET=\n\n",
+			wantCalls: []string{"version", "images ls --quiet"},
+		},
+		{
+			name: "ReturnsNonZeroExitCode",
+			params: backend.RunParams{
+				CheckID:      "id",
+				Image:        "present",
+				RequiredVars: []string{"EXIT"},
+				Vars:         map[string]string{"EXIT": "3"},
+			},
+			policy:    config.PullPolicyNever,
+			want:      "VULCAN_CHECK_TARGET=\n\n",
+			wantErr:   backend.ErrNonZeroExitCode,
+			wantCalls: []string{"version"},
+		},
+		{
+			name: "StopsTheTaskWhenTheContextIsDone",
+			params: backend.RunParams{
+				CheckID:      "id",
+				Image:        "present",
+				RequiredVars: []string{"EXIT"},
+				Vars:         map[string]string{"EXIT": "wait"},
+			},
+			policy:    config.PullPolicyNever,
+			timeout:   200 * time.Millisecond,
+			want:      "VULCAN_CHECK_TARGET=\n\nkilled\n",
+			wantErr:   context.DeadlineExceeded,
+			wantCalls: []string{"version", "task kill --signal SIGTERM id"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ctr := filepath.Join(dir, "ctr")
+			if err := os.WriteFile(ctr, []byte(fakeCtr), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			cfg := config.Config{
+				API: config.APIConfig{Port: ":8080"},
+				Runtime: config.RuntimeConfig{
+					Containerd: config.ContainerdConfig{Ctr: ctr},
+					Docker: config.DockerConfig{
+						Registry: config.RegistryConfig{
+							PullPolicy: tt.policy,
+							Auths:      []config.Auth{{Server: "docker.io", User: "user", Pass: "pass"}},
+						},
+					},
+				},
+			}
+			b, err := NewBackend(&log.NullLog{}, cfg)
+			if err != nil {
+				t.Fatalf("error creating backend: %v", err)
+			}
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			resC, err := b.Run(ctx, tt.params)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			res := <-resC
+			if !errors.Is(res.Error, tt.wantErr) {
+				t.Errorf("want error %v, got %v", tt.wantErr, res.Error)
+			}
+			if diff := cmp.Diff(tt.want, string(res.Output)); diff != "" {
+				t.Errorf("output mismatch (-want +got):\n%v", diff)
+			}
+			content, err := os.ReadFile(filepath.Join(dir, "calls"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Only the calls that are not running a task are compared.
+			var calls []string
+			for _, c := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+				if !strings.HasPrefix(c, "run ") {
+					calls = append(calls, c)
+				}
+			}
+			if diff := cmp.Diff(tt.wantCalls, calls); diff != "" {
+				t.Errorf("ctr calls mismatch (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
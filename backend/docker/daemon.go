@@ -0,0 +1,133 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// ErrDaemonUnavailable is returned by the docker backend when the docker
+// daemon is not reachable, and as the error of the checks that were running
+// when the daemon stopped responding.
+var ErrDaemonUnavailable = errors.New("docker daemon unavailable")
+
+// daemonState tracks the availability of the docker daemon and the checks
+// that are running, so they can be stopped if the daemon becomes unavailable.
+type daemonState struct {
+	unavailable int32
+	mu          sync.Mutex
+	runs        map[string]*trackedRun
+}
+
+// trackedRun is a check that is running.
+type trackedRun struct {
+	cancel context.CancelFunc
+	lost   int32
+}
+
+// client returns the current client of the docker daemon.
+func (b *Docker) client() *client.Client {
+	b.cliMu.RLock()
+	defer b.cliMu.RUnlock()
+	return b.cli
+}
+
+// available returns false if the last ping to the docker daemon failed.
+func (b *Docker) available() bool {
+	return atomic.LoadInt32(&b.daemon.unavailable) == 0
+}
+
+// trackRun registers a check that is starting. It returns the context the
+// check must use and a func that returns true if the check was stopped
+// because the daemon became unavailable.
+func (b *Docker) trackRun(ctx context.Context, checkID string) (context.Context, func() bool) {
+	ctx, cancel := context.WithCancel(ctx)
+	r := &trackedRun{cancel: cancel}
+	b.daemon.mu.Lock()
+	if b.daemon.runs == nil {
+		b.daemon.runs = make(map[string]*trackedRun)
+	}
+	b.daemon.runs[checkID] = r
+	b.daemon.mu.Unlock()
+	return ctx, func() bool {
+		return atomic.LoadInt32(&r.lost) == 1
+	}
+}
+
+// untrackRun unregisters a check that finished.
+func (b *Docker) untrackRun(checkID string) {
+	b.daemon.mu.Lock()
+	defer b.daemon.mu.Unlock()
+	if r, ok := b.daemon.runs[checkID]; ok {
+		r.cancel()
+		delete(b.daemon.runs, checkID)
+	}
+}
+
+// monitor pings the docker daemon every interval until the context is done.
+// When the daemon stops responding, the checks that are running are stopped,
+// so they finish with ErrDaemonUnavailable instead of waiting forever for
+// their containers, and the client is re-created on every ping until the
+// daemon responds again.
+func (b *Docker) monitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		pctx, cancel := context.WithTimeout(ctx, interval)
+		err := b.Ping(pctx)
+		cancel()
+		if err == nil {
+			if atomic.CompareAndSwapInt32(&b.daemon.unavailable, 1, 0) {
+				b.log.Infof("docker daemon available again")
+			}
+			continue
+		}
+		if atomic.CompareAndSwapInt32(&b.daemon.unavailable, 0, 1) {
+			b.log.Errorf("docker daemon unavailable, stopping the running checks: %+v", err)
+			b.stopRuns()
+		}
+		b.recreateClient()
+	}
+}
+
+// stopRuns stops all the checks that are running because the daemon is
+// unavailable.
+func (b *Docker) stopRuns() {
+	b.daemon.mu.Lock()
+	defer b.daemon.mu.Unlock()
+	for _, r := range b.daemon.runs {
+		atomic.StoreInt32(&r.lost, 1)
+		r.cancel()
+	}
+}
+
+// recreateClient replaces the client of the docker daemon by a new one, in
+// case the daemon is now listening in a different address or the connections
+// of the previous client are broken.
+func (b *Docker) recreateClient() {
+	cli, err := b.newClient()
+	if err != nil {
+		b.log.Errorf("error re-creating docker client: %+v", err)
+		return
+	}
+	b.cliMu.Lock()
+	old := b.cli
+	b.cli = cli
+	b.cliMu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package docker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/docker/docker/client"
+)
+
+func TestDockerMonitor(t *testing.T) {
+	var down int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&down) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("API-Version", "1.41")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	var created int32
+	newClient := func() (*client.Client, error) {
+		atomic.AddInt32(&created, 1)
+		return client.NewClientWithOpts(client.WithHost("tcp://" + srv.Listener.Addr().String()))
+	}
+	cli, err := newClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &Docker{log: &log.NullLog{}, cli: cli, newClient: newClient}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.monitor(ctx, 10*time.Millisecond)
+
+	runCtx, lost := b.trackRun(context.Background(), "check1")
+	defer b.untrackRun("check1")
+
+	atomic.StoreInt32(&down, 1)
+	select {
+	case <-runCtx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("running check not stopped")
+	}
+	if !lost() {
+		t.Error("check not marked as lost")
+	}
+	if b.available() {
+		t.Error("daemon reported as available")
+	}
+
+	atomic.StoreInt32(&down, 0)
+	deadline := time.Now().Add(5 * time.Second)
+	for !b.available() {
+		if time.Now().After(deadline) {
+			t.Fatal("daemon not reported as available again")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if atomic.LoadInt32(&created) < 2 {
+		t.Error("client not re-created")
+	}
+}
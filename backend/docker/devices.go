@@ -0,0 +1,78 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package docker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/docker/docker/api/types/container"
+)
+
+// devices contains the devices exposed to the containers of each checktype.
+type devices struct {
+	mappings map[string][]container.DeviceMapping
+	requests map[string][]container.DeviceRequest
+}
+
+// newDevices parses the devices defined in the given config. It returns an
+// error if any of the device paths is invalid.
+func newDevices(cfg map[string]config.DevicesConfig) (devices, error) {
+	d := devices{
+		mappings: make(map[string][]container.DeviceMapping),
+		requests: make(map[string][]container.DeviceRequest),
+	}
+	for checktype, dc := range cfg {
+		for _, p := range dc.Paths {
+			m, err := parseDevice(p)
+			if err != nil {
+				return devices{}, fmt.Errorf("checktype %s: %w", checktype, err)
+			}
+			d.mappings[checktype] = append(d.mappings[checktype], m)
+		}
+		if dc.GPUs != 0 {
+			// Same request done by the --gpus flag of docker.
+			d.requests[checktype] = []container.DeviceRequest{{
+				Count:        dc.GPUs,
+				Capabilities: [][]string{{"gpu"}},
+			}}
+		}
+	}
+	return d, nil
+}
+
+// parseDevice parses a device in the format of the --device flag of docker:
+// host_path[:container_path[:permissions]].
+func parseDevice(s string) (container.DeviceMapping, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 || parts[0] == "" {
+		return container.DeviceMapping{}, fmt.Errorf("invalid device %q", s)
+	}
+	m := container.DeviceMapping{
+		PathOnHost:        parts[0],
+		PathInContainer:   parts[0],
+		CgroupPermissions: "rwm",
+	}
+	if len(parts) > 1 && parts[1] != "" {
+		m.PathInContainer = parts[1]
+	}
+	if len(parts) > 2 {
+		for _, c := range parts[2] {
+			if !strings.ContainsRune("rwm", c) {
+				return container.DeviceMapping{}, fmt.Errorf("invalid permissions of device %q", s)
+			}
+		}
+		m.CgroupPermissions = parts[2]
+	}
+	return m, nil
+}
+
+// apply exposes the devices of the given checktype in the host config of a
+// container.
+func (d devices) apply(checktype string, hc *container.HostConfig) {
+	hc.Devices = append(hc.Devices, d.mappings[checktype]...)
+	hc.DeviceRequests = append(hc.DeviceRequests, d.requests[checktype]...)
+}
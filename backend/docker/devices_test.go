@@ -0,0 +1,83 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package docker
+
+import (
+	"testing"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/docker/docker/api/types/container"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDevicesApply(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       map[string]config.DevicesConfig
+		checktype string
+		want      container.HostConfig
+		wantErr   bool
+	}{
+		{
+			name: "ExposesTheDevicesOfTheChecktype",
+			cfg: map[string]config.DevicesConfig{
+				"vulcan-vpn": {Paths: []string{"/dev/net/tun", "/dev/sda:/dev/xvda:r"}},
+				"vulcan-gpu": {GPUs: -1},
+			},
+			checktype: "vulcan-vpn",
+			want: container.HostConfig{
+				Resources: container.Resources{
+					Devices: []container.DeviceMapping{
+						{PathOnHost: "/dev/net/tun", PathInContainer: "/dev/net/tun", CgroupPermissions: "rwm"},
+						{PathOnHost: "/dev/sda", PathInContainer: "/dev/xvda", CgroupPermissions: "r"},
+					},
+				},
+			},
+		},
+		{
+			name: "RequestsTheGPUs",
+			cfg: map[string]config.DevicesConfig{
+				"vulcan-gpu": {GPUs: -1},
+			},
+			checktype: "vulcan-gpu",
+			want: container.HostConfig{
+				Resources: container.Resources{
+					DeviceRequests: []container.DeviceRequest{{Count: -1, Capabilities: [][]string{{"gpu"}}}},
+				},
+			},
+		},
+		{
+			name: "IgnoresOtherChecktypes",
+			cfg: map[string]config.DevicesConfig{
+				"vulcan-vpn": {Paths: []string{"/dev/net/tun"}},
+			},
+			checktype: "vulcan-exposed-http",
+			want:      container.HostConfig{},
+		},
+		{
+			name: "FailsWithInvalidPermissions",
+			cfg: map[string]config.DevicesConfig{
+				"vulcan-vpn": {Paths: []string{"/dev/net/tun:/dev/net/tun:x"}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := newDevices(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("want error %v, got %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			var got container.HostConfig
+			d.apply(tt.checktype, &got)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("host config mismatch (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"io"
 	"io/ioutil"
 	"net"
+	"os"
 	"sync"
 	"time"
 
@@ -28,6 +29,7 @@ import (
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 const (
@@ -57,6 +59,7 @@ type ConfigUpdater func(backend.RunParams, *RunConfig) error
 // docker registry operations.
 type Retryer interface {
 	WithRetries(op string, exec func() error) error
+	WithRetriesCtx(ctx context.Context, op string, exec func() error) error
 }
 
 type registryAuths struct {
@@ -92,12 +95,44 @@ func getAuthDomain(domain string) string {
 type Docker struct {
 	config    config.RegistryConfig
 	agentAddr string
+	// agentID identifies the containers created by the agent.
+	agentID   string
 	checkVars backend.CheckVars
+	varsMu    sync.RWMutex
 	log       log.Logger
+	// cli is the client of the docker daemon. It's re-created when the
+	// daemon becomes unavailable, so it must be accessed using the client
+	// method.
 	cli       *client.Client
+	cliMu     sync.RWMutex
+	newClient func() (*client.Client, error)
+	daemon    daemonState
 	retryer   Retryer
 	updater   ConfigUpdater
 	auths     registryAuths
+	pulls     pullGroup
+	// runtime is the OCI runtime of the containers, which can be overridden
+	// per checktype by checktypeRuntimes.
+	runtime           string
+	checktypeRuntimes map[string]string
+	// security contains the hardening applied to the containers.
+	security securityProfiles
+	// egress restricts the network destinations of the containers.
+	egress *egress
+	// sandbox mounts the scratch volumes and collects the artifacts.
+	sandbox sandbox
+	// devices contains the devices exposed to the containers.
+	devices devices
+	// containers contains the ID of the container of each running check.
+	containers sync.Map
+	// extraLabels are added to the resources created for the checks.
+	extraLabels map[string]string
+	// osType is the operating system of the containers, linux or windows,
+	// and isolation the isolation technology of the Windows containers.
+	osType    string
+	isolation container.Isolation
+	// platforms contains the platforms of the images and the containers.
+	platforms platforms
 }
 
 // getAgentAddr returns the current address of the agent API from the Docker network.
@@ -136,10 +171,20 @@ func getAgentAddr(port, ifaceName string) (string, error) {
 	return "", errors.New("failed to determine Docker agent IP address")
 }
 
+func init() {
+	backend.Register(config.BackendDocker, func(l log.Logger, cfg config.Config) (backend.Backend, error) {
+		return NewBackend(l, cfg, nil)
+	})
+}
+
 // NewBackend creates a new Docker backend using the given config, agent api address and CheckVars.
 // A ConfigUpdater function can be passed to inspect/update the final docker RunConfig
-// before creating the container for each check.
+// before creating the container for each check. When remote docker hosts are
+// configured the backend returned is a Pool.
 func NewBackend(log log.Logger, cfg config.Config, updater ConfigUpdater) (backend.Backend, error) {
+	if len(cfg.Runtime.Docker.Hosts) > 0 {
+		return NewPool(log, cfg, updater)
+	}
 	var (
 		agentAddr string
 		err       error
@@ -147,46 +192,100 @@ func NewBackend(log log.Logger, cfg config.Config, updater ConfigUpdater) (backe
 	if cfg.API.Host != "" {
 		agentAddr = cfg.API.Host + cfg.API.Port
 	} else {
-		agentAddr, err = getAgentAddr(cfg.API.Port, cfg.API.IName)
+		if cfg.API.IName != "" {
+			agentAddr, err = getAgentAddr(cfg.API.Port, cfg.API.IName)
+		} else {
+			agentAddr, err = discoverAgentAddr(cfg.API.Port)
+		}
 		if err != nil {
 			return &Docker{}, err
 		}
 	}
+	newClient := func() (*client.Client, error) {
+		return client.NewClientWithOpts(client.FromEnv)
+	}
+	b, err := newDocker(log, cfg, updater, agentAddr, newClient)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
 
+// newDocker creates a Docker backend that runs the checks in the docker
+// daemon reached with the clients returned by newClient.
+func newDocker(log log.Logger, cfg config.Config, updater ConfigUpdater, agentAddr string, newClient func() (*client.Client, error)) (*Docker, error) {
 	cfgReg := cfg.Runtime.Docker.Registry
 	interval := cfgReg.BackoffInterval
 	retries := cfgReg.BackoffMaxRetries
 	re := retryer.NewRetryer(retries, interval, log)
+	checkVars, err := backend.NewCheckVars(cfg.Check)
+	if err != nil {
+		return nil, err
+	}
 
-	envCli, err := client.NewClientWithOpts(client.FromEnv)
+	envCli, err := newClient()
 	if err != nil {
-		return &Docker{}, err
+		return nil, err
+	}
+
+	agentID := cfg.Heartbeat.AgentID
+	if agentID == "" {
+		agentID, _ = os.Hostname()
 	}
 
 	b := &Docker{
 		config:    cfg.Runtime.Docker.Registry,
 		agentAddr: agentAddr,
+		agentID:   agentID,
 		log:       log,
-		checkVars: cfg.Check.Vars,
+		checkVars: checkVars,
 		cli:       envCli,
+		newClient: newClient,
 		retryer:   re,
 		updater:   updater,
 		auths: registryAuths{
 			auths: make(map[string]*types.AuthConfig),
 		},
+		runtime:           cfg.Runtime.Docker.Runtime,
+		checktypeRuntimes: cfg.Runtime.Docker.ChecktypeRuntimes,
 	}
 
-	if b.config.Auths == nil {
-		b.config.Auths = []config.Auth{}
+	b.osType, err = b.detectOSType(context.Background(), cfg.Runtime.Docker)
+	if err != nil {
+		return nil, err
 	}
-	// Add the legacy single registry auth to the slice.
-	if b.config.Server != "" {
-		b.config.Auths = append(b.config.Auths, config.Auth{
-			Server: b.config.Server,
-			User:   b.config.User,
-			Pass:   b.config.Pass,
-		})
+	if b.windows() {
+		if err := checkWindows(cfg.Runtime.Docker); err != nil {
+			return nil, err
+		}
+		b.isolation = container.Isolation(cfg.Runtime.Docker.Isolation)
+	}
+	b.platforms, err = newPlatforms(cfg.Runtime.Docker)
+	if err != nil {
+		return nil, fmt.Errorf("invalid platform config: %w", err)
+	}
+	b.security, err = newSecurityProfiles(cfg.Runtime.Docker.Security)
+	if err != nil {
+		return nil, fmt.Errorf("invalid security config: %w", err)
 	}
+	b.egress, err = newEgress(cfg.Runtime.Docker.Egress, agentAddr, log)
+	if err != nil {
+		return nil, fmt.Errorf("invalid egress config: %w", err)
+	}
+	b.sandbox = newSandbox(cfg.Runtime.Docker.Sandbox)
+	b.extraLabels = cfg.Runtime.Docker.Labels
+	b.devices, err = newDevices(cfg.Runtime.Docker.Devices)
+	if err != nil {
+		return nil, fmt.Errorf("invalid devices config: %w", err)
+	}
+
+	// Prevent the agent to start if the daemon doesn't support the configured
+	// runtimes.
+	if err := b.checkRuntimes(context.Background()); err != nil {
+		return nil, err
+	}
+
+	b.config.Auths = configAuths(b.config)
 
 	// Eager validation of the configured registries.
 	for _, a := range b.config.Auths {
@@ -202,9 +301,48 @@ func NewBackend(log log.Logger, cfg config.Config, updater ConfigUpdater) (backe
 			return nil, err
 		}
 	}
+
+	if interval := cfg.Runtime.Docker.HealthInterval; interval > 0 {
+		go b.monitor(context.Background(), time.Duration(interval)*time.Second)
+	}
 	return b, nil
 }
 
+// configAuths returns the registry auths defined in the config, including the
+// legacy single registry auth.
+func configAuths(cfg config.RegistryConfig) []config.Auth {
+	auths := append([]config.Auth{}, cfg.Auths...)
+	if cfg.Server != "" {
+		auths = append(auths, config.Auth{
+			Server: cfg.Server,
+			User:   cfg.User,
+			Pass:   cfg.Pass,
+		})
+	}
+	return auths
+}
+
+// SetRegistryAuths validates and replaces the credentials of the registries
+// defined in the given config, for instance, after their passwords have been
+// rotated. The credentials of a registry are only replaced if they are valid.
+func (b *Docker) SetRegistryAuths(cfg config.RegistryConfig) error {
+	for _, a := range configAuths(cfg) {
+		if a.Server == "" {
+			continue
+		}
+		auth := &types.AuthConfig{
+			Username:      a.User,
+			Password:      a.Pass,
+			ServerAddress: a.Server,
+		}
+		if _, err := b.client().RegistryLogin(context.Background(), *auth); err != nil {
+			return fmt.Errorf("unable to login in %s: %w", a.Server, err)
+		}
+		b.auths.storeAuth(a.Server, auth)
+	}
+	return nil
+}
+
 // addRegistryAuth adds the auth to the map only if valid.
 func (b *Docker) addRegistryAuth(domain string, auth *types.AuthConfig) error {
 	if domain == "" {
@@ -217,7 +355,7 @@ func (b *Docker) addRegistryAuth(domain string, auth *types.AuthConfig) error {
 		return nil
 	}
 
-	if _, err := b.cli.RegistryLogin(context.Background(), *auth); err != nil {
+	if _, err := b.client().RegistryLogin(context.Background(), *auth); err != nil {
 		b.log.Errorf("wrong credentials provided for %s %s error=%+v",
 			domain, auth.ServerAddress, err,
 		)
@@ -294,7 +432,16 @@ func (b *Docker) getStoredCredentials(domain string) *types.AuthConfig {
 // Run starts executing a check as a local container and returns a channel that
 // will contain the result of the execution when it finishes.
 func (b *Docker) Run(ctx context.Context, params backend.RunParams) (<-chan backend.RunResult, error) {
-	err := b.pull(ctx, params.Image)
+	if !b.available() {
+		return nil, ErrDaemonUnavailable
+	}
+	b.varsMu.RLock()
+	err := backend.CheckRequiredVars(params, b.checkVars)
+	b.varsMu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	err = b.pull(ctx, params.Image, b.platform(params.CheckTypeName))
 	if err != nil {
 		return nil, err
 	}
@@ -304,6 +451,8 @@ func (b *Docker) Run(ctx context.Context, params backend.RunParams) (<-chan back
 }
 
 func (b *Docker) run(ctx context.Context, params backend.RunParams, res chan<- backend.RunResult) {
+	ctx, lost := b.trackRun(ctx, params.CheckID)
+	defer b.untrackRun(params.CheckID)
 	cfg := b.getRunConfig(params)
 
 	if b.updater != nil {
@@ -313,20 +462,37 @@ func (b *Docker) run(ctx context.Context, params backend.RunParams, res chan<- b
 			return
 		}
 	}
-	cc, err := b.cli.ContainerCreate(ctx, cfg.ContainerConfig, cfg.HostConfig, cfg.NetConfig, nil, "")
+	// The network and the scratch volume of the check must be removed after
+	// removing its container, so these defers must be registered before the
+	// one removing the container.
+	releaseEgress, err := b.restrictEgress(ctx, params, &cfg)
+	if err != nil {
+		res <- backend.RunResult{Error: err}
+		return
+	}
+	defer releaseEgress()
+	removeScratch, err := b.mountScratch(ctx, params, &cfg)
+	if err != nil {
+		res <- backend.RunResult{Error: err}
+		return
+	}
+	defer removeScratch()
+	cc, err := b.client().ContainerCreate(ctx, cfg.ContainerConfig, cfg.HostConfig, cfg.NetConfig, b.platform(params.CheckTypeName), "")
 	contID := cc.ID
 	if err != nil {
 		res <- backend.RunResult{Error: err}
 		return
 	}
+	b.containers.Store(params.CheckID, contID)
+	defer b.containers.Delete(params.CheckID)
 	defer func() {
 		removeOpts := types.ContainerRemoveOptions{Force: true}
-		removeErr := b.cli.ContainerRemove(context.Background(), contID, removeOpts)
+		removeErr := b.client().ContainerRemove(context.Background(), contID, removeOpts)
 		if removeErr != nil {
 			b.log.Errorf("error removing container %s: %v", params.CheckID, err)
 		}
 	}()
-	err = b.cli.ContainerStart(
+	err = b.client().ContainerStart(
 		ctx, contID, cfg.ContainerStartOptions,
 	)
 	if err != nil {
@@ -335,7 +501,7 @@ func (b *Docker) run(ctx context.Context, params backend.RunParams, res chan<- b
 		return
 	}
 
-	resultC, errC := b.cli.ContainerWait(ctx, contID, "")
+	resultC, errC := b.client().ContainerWait(ctx, contID, "")
 	var exit int64
 	select {
 	case err = <-errC:
@@ -346,6 +512,12 @@ func (b *Docker) run(ctx context.Context, params backend.RunParams, res chan<- b
 			err = fmt.Errorf("wait error %s", result.Error.Message)
 		}
 	}
+	if lost() {
+		// The container can't be stopped nor its logs retrieved.
+		err := fmt.Errorf("error running container for check %s: %w", params.CheckID, ErrDaemonUnavailable)
+		res <- backend.RunResult{Error: err}
+		return
+	}
 	if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
 		err := fmt.Errorf("error running container for check %s: %w", params.CheckID, err)
 		res <- backend.RunResult{Error: err}
@@ -364,22 +536,26 @@ func (b *Docker) run(ctx context.Context, params backend.RunParams, res chan<- b
 		// finish  a time out.
 		b.log.Infof("check: %s timeout or aborted ensure container %s is stopped", params.CheckID, contID)
 		timeout := abortTimeout
-		b.cli.ContainerStop(context.Background(), contID, &timeout)
+		if params.KillGrace > 0 {
+			timeout = params.KillGrace
+		}
+		b.client().ContainerStop(context.Background(), contID, &timeout)
 	}
 
+	artifacts := b.collectArtifacts(contID, params)
 	out, logErr := b.getContainerlogs(contID)
 	if logErr != nil {
 		b.log.Errorf("getting logs for the check %s, %+v", params.CheckID, err)
 	}
-	res <- backend.RunResult{Output: out, Error: err}
+	res <- backend.RunResult{Output: out, Error: err, Artifacts: artifacts}
 }
 
-func (b Docker) getContainerlogs(ID string) ([]byte, error) {
+func (b *Docker) getContainerlogs(ID string) ([]byte, error) {
 	logOpts := types.ContainerLogsOptions{
 		ShowStdout: true,
 		ShowStderr: true,
 	}
-	r, err := b.cli.ContainerLogs(context.Background(), ID, logOpts)
+	r, err := b.client().ContainerLogs(context.Background(), ID, logOpts)
 	if err != nil {
 		err = fmt.Errorf("error getting logs for container %s: %w", ID, err)
 		return nil, err
@@ -393,6 +569,71 @@ func (b Docker) getContainerlogs(ID string) ([]byte, error) {
 	return out, nil
 }
 
+// StreamLogs writes the stdout and stderr of the container of a running check
+// to the given writer. If follow is true it keeps writing the output until the
+// container stops or the context is done.
+func (b *Docker) StreamLogs(ctx context.Context, checkID string, follow bool, w io.Writer) error {
+	v, ok := b.containers.Load(checkID)
+	if !ok {
+		return backend.ErrCheckNotFound
+	}
+	logOpts := types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     follow,
+	}
+	r, err := b.client().ContainerLogs(ctx, v.(string), logOpts)
+	if err != nil {
+		return fmt.Errorf("error getting logs for check %s: %w", checkID, err)
+	}
+	defer r.Close()
+	_, err = stdcopy.StdCopy(w, w, r)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		return fmt.Errorf("error reading logs for check %s: %w", checkID, err)
+	}
+	return nil
+}
+
+// Ping checks that the docker daemon is reachable.
+func (b *Docker) Ping(ctx context.Context) error {
+	if _, err := b.client().Ping(ctx); err != nil {
+		return fmt.Errorf("error pinging docker daemon: %w", err)
+	}
+	return nil
+}
+
+// Exec executes a command inside the container of a running check and
+// returns its stdout and stderr combined.
+func (b *Docker) Exec(ctx context.Context, checkID string, cmd []string) (backend.ExecResult, error) {
+	v, ok := b.containers.Load(checkID)
+	if !ok {
+		return backend.ExecResult{}, backend.ErrCheckNotFound
+	}
+	execCfg := types.ExecConfig{
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	}
+	exec, err := b.client().ContainerExecCreate(ctx, v.(string), execCfg)
+	if err != nil {
+		return backend.ExecResult{}, fmt.Errorf("error creating exec in check %s: %w", checkID, err)
+	}
+	resp, err := b.client().ContainerExecAttach(ctx, exec.ID, types.ExecStartCheck{})
+	if err != nil {
+		return backend.ExecResult{}, fmt.Errorf("error attaching to exec in check %s: %w", checkID, err)
+	}
+	defer resp.Close()
+	out := &bytes.Buffer{}
+	if _, err := stdcopy.StdCopy(out, out, resp.Reader); err != nil {
+		return backend.ExecResult{}, fmt.Errorf("error reading exec output in check %s: %w", checkID, err)
+	}
+	inspect, err := b.client().ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return backend.ExecResult{}, fmt.Errorf("error inspecting exec in check %s: %w", checkID, err)
+	}
+	return backend.ExecResult{Output: out.Bytes(), ExitCode: inspect.ExitCode}, nil
+}
+
 func (b *Docker) imageExists(ctx context.Context, image string) (bool, error) {
 	domain, path, tag, err := backend.ParseImage(image)
 	if err != nil {
@@ -406,7 +647,7 @@ func (b *Docker) imageExists(ctx context.Context, image string) (bool, error) {
 		pattern = path + ":" + tag
 	}
 
-	images, err := b.cli.ImageList(ctx, types.ImageListOptions{
+	images, err := b.client().ImageList(ctx, types.ImageListOptions{
 		Filters: filters.NewArgs(filters.KeyValuePair{
 			Key:   "reference",
 			Value: pattern,
@@ -421,7 +662,36 @@ func (b *Docker) imageExists(ctx context.Context, image string) (bool, error) {
 	return true, nil
 }
 
-func (b *Docker) pull(ctx context.Context, image string) error {
+// ImageDigest returns the digest of the given image if it is present locally.
+// If the image has no repo digest, for instance because it was built locally,
+// the ID of the image is returned.
+func (b *Docker) ImageDigest(ctx context.Context, image string) (string, error) {
+	info, _, err := b.client().ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		return "", err
+	}
+	if len(info.RepoDigests) > 0 {
+		return info.RepoDigests[0], nil
+	}
+	return info.ID, nil
+}
+
+// pull pulls the given image, for the given platform, according to the
+// configured pull policy. Calls for the same image and platform done while a
+// previous pull is in progress wait for that pull to finish and share its
+// result instead of pulling the image again. The call returns the error of
+// the context if it's done before the pull finishes.
+func (b *Docker) pull(ctx context.Context, image string, platform *specs.Platform) error {
+	key := image
+	if platform != nil {
+		key += "@" + platformString(platform)
+	}
+	return b.pulls.do(ctx, key, func(ctx context.Context) error {
+		return b.pullWithBackoff(ctx, image, platform)
+	})
+}
+
+func (b *Docker) pullWithBackoff(ctx context.Context, image string, platform *specs.Platform) error {
 	if b.config.PullPolicy == config.PullPolicyNever {
 		return nil
 	}
@@ -430,11 +700,19 @@ func (b *Docker) pull(ctx context.Context, image string) error {
 		if err != nil {
 			return err
 		}
+		// The local image is pulled again if it was built for another
+		// platform, instead of running it emulated.
+		if exists {
+			exists, err = b.imageMatches(ctx, image, platform)
+			if err != nil {
+				return err
+			}
+		}
 		if exists {
 			return nil
 		}
 	}
-	pullOpts := types.ImagePullOptions{}
+	pullOpts := types.ImagePullOptions{Platform: platformString(platform)}
 
 	// Image was validated before and ParseImage always return a domain.
 	domain, _, _, err := backend.ParseImage(image)
@@ -449,10 +727,10 @@ func (b *Docker) pull(ctx context.Context, image string) error {
 		}
 		pullOpts.RegistryAuth = base64.URLEncoding.EncodeToString(buf)
 	}
-	b.log.Debugf("pulling image=%s domain=%s auth=%v", image, domain, pullOpts.RegistryAuth != "")
+	b.log.Debugf("pulling image=%s domain=%s platform=%s auth=%v", image, domain, pullOpts.Platform, pullOpts.RegistryAuth != "")
 	start := time.Now()
-	err = b.retryer.WithRetries("PullDockerImage", func() error {
-		respBody, err := b.cli.ImagePull(ctx, image, pullOpts)
+	err = b.retryer.WithRetriesCtx(ctx, "PullDockerImage", func() error {
+		respBody, err := b.client().ImagePull(ctx, image, pullOpts)
 		if err != nil {
 			return err
 		}
@@ -477,35 +755,94 @@ func (b *Docker) pull(ctx context.Context, image string) error {
 // It will inject the check options and target as environment variables.
 // It will return the generated docker.RunConfig.
 func (b *Docker) getRunConfig(params backend.RunParams) RunConfig {
-	vars := dockerVars(params.RequiredVars, b.checkVars)
+	b.varsMu.RLock()
+	vars := dockerVars(params, b.checkVars)
+	b.varsMu.RUnlock()
+	hostCfg := &container.HostConfig{
+		Runtime:   b.ociRuntime(params.CheckTypeName),
+		Isolation: b.isolation,
+	}
+	b.security.apply(params.CheckTypeName, hostCfg)
+	b.devices.apply(params.CheckTypeName, hostCfg)
+	env := append([]string{
+		fmt.Sprintf("%s=%s", backend.CheckIDVar, params.CheckID),
+		fmt.Sprintf("%s=%s", backend.ChecktypeNameVar, params.CheckTypeName),
+		fmt.Sprintf("%s=%s", backend.ChecktypeVersionVar, params.ChecktypeVersion),
+		fmt.Sprintf("%s=%s", backend.CheckTargetVar, params.Target),
+		fmt.Sprintf("%s=%s", backend.CheckAssetTypeVar, params.AssetType),
+		fmt.Sprintf("%s=%s", backend.CheckOptionsVar, params.Options),
+		fmt.Sprintf("%s=%s", backend.AgentAddressVar, b.agentAddr),
+	},
+		vars...,
+	)
+	if b.windows() {
+		env = windowsEnv(env)
+	}
 	return RunConfig{
 		ContainerConfig: &container.Config{
 			Hostname: params.CheckID,
 			Image:    params.Image,
-			Labels:   map[string]string{"CheckID": params.CheckID},
-			Env: append([]string{
-				fmt.Sprintf("%s=%s", backend.CheckIDVar, params.CheckID),
-				fmt.Sprintf("%s=%s", backend.ChecktypeNameVar, params.CheckTypeName),
-				fmt.Sprintf("%s=%s", backend.ChecktypeVersionVar, params.ChecktypeVersion),
-				fmt.Sprintf("%s=%s", backend.CheckTargetVar, params.Target),
-				fmt.Sprintf("%s=%s", backend.CheckAssetTypeVar, params.AssetType),
-				fmt.Sprintf("%s=%s", backend.CheckOptionsVar, params.Options),
-				fmt.Sprintf("%s=%s", backend.AgentAddressVar, b.agentAddr),
-			},
-				vars...,
-			),
+			Labels:   b.labels(params),
+			Env:      env,
 		},
-		HostConfig:            &container.HostConfig{},
+		HostConfig:            hostCfg,
 		NetConfig:             &network.NetworkingConfig{},
 		ContainerStartOptions: types.ContainerStartOptions{},
 	}
 }
 
+// ociRuntime returns the OCI runtime for the containers of the given
+// checktype. An empty string means the default runtime of the daemon.
+func (b *Docker) ociRuntime(checktype string) string {
+	if r, ok := b.checktypeRuntimes[checktype]; ok {
+		return r
+	}
+	return b.runtime
+}
+
+// checkRuntimes returns an error if any of the configured runtimes is not
+// available in the docker daemon.
+func (b *Docker) checkRuntimes(ctx context.Context) error {
+	if b.runtime == "" && len(b.checktypeRuntimes) == 0 {
+		return nil
+	}
+	info, err := b.client().Info(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting the runtimes of the docker daemon: %w", err)
+	}
+	runtimes := []string{b.runtime}
+	for _, r := range b.checktypeRuntimes {
+		runtimes = append(runtimes, r)
+	}
+	for _, r := range runtimes {
+		if r == "" {
+			continue
+		}
+		if _, ok := info.Runtimes[r]; !ok {
+			return fmt.Errorf("runtime %s not available in the docker daemon", r)
+		}
+	}
+	return nil
+}
+
+// SetCheckVars replaces the vars available to the checks. It only affects to
+// the checks started after calling it.
+func (b *Docker) SetCheckVars(vars backend.CheckVars) {
+	b.varsMu.Lock()
+	defer b.varsMu.Unlock()
+	b.checkVars = vars
+}
+
 // dockerVars assigns the required environment variables in a format supported by Docker.
-func dockerVars(requiredVars []string, envVars map[string]string) []string {
-	var dockerVars []string
-	for _, requiredVar := range requiredVars {
-		dockerVars = append(dockerVars, fmt.Sprintf("%s=%s", requiredVar, envVars[requiredVar]))
+// The vars of the run take precedence over the check vars.
+func dockerVars(params backend.RunParams, checkVars backend.CheckVars) []string {
+	dockerVars := checkVars.TemplateEnv(params)
+	for _, requiredVar := range params.RequiredVars {
+		value, ok := params.Vars[requiredVar]
+		if !ok {
+			value, _ = checkVars.Lookup(params.CheckTypeName, requiredVar)
+		}
+		dockerVars = append(dockerVars, fmt.Sprintf("%s=%s", requiredVar, value))
 	}
 	return dockerVars
 }
@@ -524,3 +861,78 @@ func readContainerLogs(r io.ReadCloser) ([]byte, error) {
 	out := bytes.Join(contents, []byte("\n"))
 	return out, nil
 }
+
+// pullCall represents a pull in progress.
+type pullCall struct {
+	done   chan struct{}
+	err    error
+	cancel context.CancelFunc
+	// waiters is the number of callers waiting for the pull, and dups the
+	// number of them that joined the pull in progress.
+	waiters int
+	dups    int
+}
+
+// pullGroup de-duplicates concurrent pulls of the same image.
+type pullGroup struct {
+	mu    sync.Mutex
+	calls map[string]*pullCall
+}
+
+// do executes the given pull function ensuring that only one execution is in
+// flight for a given image at a time. If a pull for the same image is already
+// in progress, the caller waits for it to finish and receives the same error.
+// The pull runs with a context detached from the callers, so a caller whose
+// context is done stops waiting, returning the error of its context, without
+// making the pull fail for the rest. The pull is canceled when no caller is
+// waiting for it.
+func (g *pullGroup) do(ctx context.Context, image string, pull func(ctx context.Context) error) error {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*pullCall)
+	}
+	c, ok := g.calls[image]
+	if ok {
+		c.dups++
+	} else {
+		var pctx context.Context
+		c = &pullCall{done: make(chan struct{})}
+		pctx, c.cancel = context.WithCancel(context.Background())
+		g.calls[image] = c
+		go func() {
+			err := pull(pctx)
+			g.mu.Lock()
+			c.err = err
+			g.remove(image, c)
+			g.mu.Unlock()
+			c.cancel()
+			close(c.done)
+		}()
+	}
+	c.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		c.waiters--
+		if c.waiters == 0 {
+			// The new callers start a new pull instead of joining the
+			// canceled one.
+			g.remove(image, c)
+			c.cancel()
+		}
+		return ctx.Err()
+	}
+}
+
+// remove removes the given call of an image, if it's still its call in
+// progress. It must be called with the mutex held.
+func (g *pullGroup) remove(image string, c *pullCall) {
+	if g.calls[image] == c {
+		delete(g.calls, image)
+	}
+}
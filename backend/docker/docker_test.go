@@ -8,9 +8,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -55,7 +60,7 @@ func TestIntegrationDockerRun(t *testing.T) {
 					agentAddr: "an addr",
 					log:       &log.NullLog{},
 					cli:       cli,
-					checkVars: map[string]string{"VULCAN_CHECK_VAR": "value_var_1"},
+					checkVars: backend.CheckVars{Global: map[string]string{"VULCAN_CHECK_VAR": "value_var_1"}},
 				}
 				err = buildDockerImage("testdata/DockerfileEnv", "vulcan-check")
 				if err != nil {
@@ -377,6 +382,200 @@ func TestIntegrationDockerFindImage(t *testing.T) {
 	}
 }
 
+func TestDockerGetRunConfigRuntime(t *testing.T) {
+	b := &Docker{
+		runtime:           "runsc",
+		checktypeRuntimes: map[string]string{"vulcan-nessus": "kata-runtime"},
+	}
+	tests := []struct {
+		checktype string
+		want      string
+	}{
+		{checktype: "vulcan-exposed-http", want: "runsc"},
+		{checktype: "vulcan-nessus", want: "kata-runtime"},
+	}
+	for _, tt := range tests {
+		cfg := b.getRunConfig(backend.RunParams{CheckTypeName: tt.checktype})
+		if got := cfg.HostConfig.Runtime; got != tt.want {
+			t.Errorf("checktype %s: want runtime %q, got %q", tt.checktype, tt.want, got)
+		}
+	}
+}
+
+func TestDocker_runKillGrace(t *testing.T) {
+	tests := []struct {
+		name      string
+		killGrace time.Duration
+		want      string
+	}{
+		{
+			name:      "KillGrace",
+			killGrace: 7 * time.Second,
+			want:      "7",
+		},
+		{
+			name: "DefaultAbortTimeout",
+			want: "5",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu       sync.Mutex
+				stopWait []string
+			)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case strings.HasSuffix(r.URL.Path, "/containers/create"):
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusCreated)
+					w.Write([]byte(`{"Id": "container1"}`))
+				case strings.HasSuffix(r.URL.Path, "/containers/container1/wait"):
+					// The check runs until it times out.
+					<-r.Context().Done()
+				case strings.HasSuffix(r.URL.Path, "/containers/container1/stop"):
+					mu.Lock()
+					stopWait = append(stopWait, r.URL.Query().Get("t"))
+					mu.Unlock()
+					w.WriteHeader(http.StatusNoContent)
+				case strings.HasSuffix(r.URL.Path, "/containers/container1/logs"):
+					w.WriteHeader(http.StatusOK)
+				default:
+					w.WriteHeader(http.StatusNoContent)
+				}
+			}))
+			defer srv.Close()
+			cli, err := client.NewClientWithOpts(client.WithHost("tcp://"+srv.Listener.Addr().String()), client.WithVersion("1.41"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := &Docker{log: &log.NullLog{}, cli: cli}
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			res := make(chan backend.RunResult, 1)
+			b.run(ctx, backend.RunParams{CheckID: "check1", Image: "check:1", KillGrace: tt.killGrace}, res)
+			if got := <-res; !errors.Is(got.Error, context.DeadlineExceeded) {
+				t.Errorf("want error %v, got %v", context.DeadlineExceeded, got.Error)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if diff := cmp.Diff([]string{tt.want}, stopWait); diff != "" {
+				t.Errorf("want stop timeouts != got stop timeouts, diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestPullGroupDo(t *testing.T) {
+	g := &pullGroup{}
+	release := make(chan struct{})
+	var calls int32
+	pull := func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return errors.New("pull error")
+	}
+	const n = 5
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			errs <- g.do(context.Background(), "vulcan-check:latest", pull)
+		}()
+	}
+	// Wait for all the callers to join the pull in progress before releasing it.
+	for g.waiting("vulcan-check:latest") != n-1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(release)
+	for i := 0; i < n; i++ {
+		if err := <-errs; err == nil || err.Error() != "pull error" {
+			t.Errorf("want error %q, got %v", "pull error", err)
+		}
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("want 1 pull, got %d", got)
+	}
+	// A new pull must execute again once the previous one finished.
+	if err := g.do(context.Background(), "vulcan-check:latest", func(ctx context.Context) error { return nil }); err != nil {
+		t.Errorf("unexpected error %+v", err)
+	}
+}
+
+func TestPullGroupDo_CallerCanceled(t *testing.T) {
+	g := &pullGroup{}
+	release := make(chan struct{})
+	pull := func(ctx context.Context) error {
+		select {
+		case <-release:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		first <- g.do(ctx, "vulcan-check:latest", pull)
+	}()
+	for g.waiting("vulcan-check:latest") != 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	second := make(chan error, 1)
+	go func() {
+		second <- g.do(context.Background(), "vulcan-check:latest", pull)
+	}()
+	for g.waiting("vulcan-check:latest") != 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// The pull continues for the second caller when the first one is
+	// canceled.
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("want error %v for the canceled caller, got %v", context.Canceled, err)
+	}
+	close(release)
+	if err := <-second; err != nil {
+		t.Errorf("unexpected error %+v", err)
+	}
+}
+
+func TestPullGroupDo_AllCallersCanceled(t *testing.T) {
+	g := &pullGroup{}
+	pullErr := make(chan error, 1)
+	pull := func(ctx context.Context) error {
+		<-ctx.Done()
+		pullErr <- ctx.Err()
+		return ctx.Err()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := g.do(ctx, "vulcan-check:latest", pull); !errors.Is(err, context.Canceled) {
+		t.Errorf("want error %v, got %v", context.Canceled, err)
+	}
+	select {
+	case err := <-pullErr:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("want pull error %v, got %v", context.Canceled, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("pull without callers not canceled")
+	}
+	if got := g.waiting("vulcan-check:latest"); got != -1 {
+		t.Errorf("canceled pull still in progress")
+	}
+}
+
+func (g *pullGroup) waiting(image string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	c, ok := g.calls[image]
+	if !ok {
+		return -1
+	}
+	return c.dups
+}
+
 func buildDockerImage(dockerFile string, tag string) (err error) {
 	path, err := filepath.Abs(dockerFile)
 	if err != nil {
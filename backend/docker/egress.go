@@ -0,0 +1,244 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	osexec "os/exec"
+	"strings"
+
+	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+)
+
+const (
+	defaultIptables = "iptables"
+	// egressIDLen is the number of chars of the check ID used to name the
+	// bridges and the chains of the checks. The name of a bridge can't be
+	// longer than 15 chars.
+	egressIDLen = 12
+)
+
+// defaultUnrestrictedAssetTypes contains the asset types whose targets are
+// not network addresses.
+var defaultUnrestrictedAssetTypes = []string{"DockerImage", "GitRepository", "AWSAccount", "GCPProject"}
+
+// lookupIP resolves the hostnames of the targets. It's a var so it can be
+// replaced in the tests.
+var lookupIP = net.LookupIP
+
+// egress restricts the destinations the containers of the checks can reach
+// by running each check in its own network and filtering the traffic that
+// leaves the bridge of the network with iptables.
+type egress struct {
+	enabled      bool
+	iptables     string
+	allow        []string
+	unrestricted map[string]bool
+	// agentPort is the port of the agent API the checks must be able to
+	// reach.
+	agentPort string
+	// run executes an iptables command. It's a field so it can be replaced in
+	// the tests.
+	run func(args ...string) error
+	log log.Logger
+}
+
+func newEgress(cfg config.EgressConfig, agentAddr string, l log.Logger) (*egress, error) {
+	e := &egress{
+		enabled:  cfg.Enabled,
+		iptables: cfg.Iptables,
+		log:      l,
+	}
+	if !e.enabled {
+		return e, nil
+	}
+	if e.iptables == "" {
+		e.iptables = defaultIptables
+	}
+	for _, c := range cfg.AllowCIDRs {
+		if _, _, err := net.ParseCIDR(c); err != nil {
+			return nil, fmt.Errorf("invalid allowed CIDR %s: %w", c, err)
+		}
+		e.allow = append(e.allow, c)
+	}
+	assetTypes := cfg.UnrestrictedAssetTypes
+	if assetTypes == nil {
+		assetTypes = defaultUnrestrictedAssetTypes
+	}
+	e.unrestricted = make(map[string]bool, len(assetTypes))
+	for _, t := range assetTypes {
+		e.unrestricted[t] = true
+	}
+	_, port, err := net.SplitHostPort(agentAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid agent address %s: %w", agentAddr, err)
+	}
+	e.agentPort = port
+	e.run = e.iptablesCmd
+	return e, nil
+}
+
+// restrictEgress creates the network of the check, connects the container to it and
+// adds the iptables rules that only allow its egress traffic to the target,
+// the agent API and the allowed CIDRs. It returns a func that removes the
+// rules and the network, that must be called after removing the container.
+func (b *Docker) restrictEgress(ctx context.Context, params backend.RunParams, cfg *RunConfig) (func(), error) {
+	e := b.egress
+	if e == nil || !e.enabled || e.unrestricted[params.AssetType] {
+		return func() {}, nil
+	}
+	dests := e.destinations(params.Target)
+	chain, bridge := egressNames(params.CheckID)
+	name := "vulcan-" + params.CheckID
+	netCfg := types.NetworkCreate{
+		CheckDuplicate: true,
+		Driver:         "bridge",
+		Options:        map[string]string{"com.docker.network.bridge.name": bridge},
+		Labels:         b.labels(params),
+	}
+	resp, err := b.client().NetworkCreate(ctx, name, netCfg)
+	if errdefs.IsConflict(err) {
+		// The network of the check already exists when the agent crashed
+		// while running it before. The stale resources of the check are
+		// removed and the network is created again.
+		if rerr := b.removeStaleCheck(ctx, params.CheckID, name); rerr != nil {
+			b.log.Errorf("error removing stale resources of check %s: %+v", params.CheckID, rerr)
+		} else {
+			resp, err = b.client().NetworkCreate(ctx, name, netCfg)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating network for check %s: %w", params.CheckID, err)
+	}
+	removeNetwork := func() {
+		if err := b.client().NetworkRemove(context.Background(), resp.ID); err != nil {
+			b.log.Errorf("error removing network of check %s: %+v", params.CheckID, err)
+		}
+	}
+	if err := e.addRules(chain, bridge, dests); err != nil {
+		e.removeRules(chain, bridge)
+		removeNetwork()
+		return nil, fmt.Errorf("error adding egress rules for check %s: %w", params.CheckID, err)
+	}
+	cfg.HostConfig.NetworkMode = container.NetworkMode(name)
+	return func() {
+		e.removeRules(chain, bridge)
+		removeNetwork()
+	}, nil
+}
+
+// egressNames returns the names of the iptables chain and the bridge of the
+// network of the given check.
+func egressNames(checkID string) (chain, bridge string) {
+	id := strings.ReplaceAll(checkID, "-", "")
+	if len(id) > egressIDLen {
+		id = id[:egressIDLen]
+	}
+	return "VULCAN-" + strings.ToUpper(id), "vc-" + id
+}
+
+// destinations returns the CIDRs of the given target. Targets that can't be
+// resolved have no destinations, so the checks can only reach the agent API
+// and the allowed CIDRs.
+func (e *egress) destinations(target string) []string {
+	dests := append([]string{}, e.allow...)
+	if _, cidr, err := net.ParseCIDR(target); err == nil {
+		return append(dests, cidr.String())
+	}
+	host := target
+	if strings.Contains(target, "://") {
+		u, err := url.Parse(target)
+		if err != nil {
+			return dests
+		}
+		host = u.Hostname()
+	}
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		var err error
+		ips, err = lookupIP(host)
+		if err != nil {
+			e.log.Errorf("error resolving target %s, egress restricted to the allowed CIDRs: %+v", target, err)
+			return dests
+		}
+	}
+	for _, ip := range ips {
+		// The networks of the checks don't have IPv6 enabled.
+		if ip.To4() != nil {
+			dests = append(dests, ip.String()+"/32")
+		}
+	}
+	return dests
+}
+
+// addRules adds the chains that filter the traffic leaving the given bridge.
+// The traffic forwarded by the host is filtered in the DOCKER-USER chain and
+// the traffic to the host itself in the INPUT chain, where only the port of
+// the agent API is allowed.
+func (e *egress) addRules(chain, bridge string, dests []string) error {
+	in := chain + "-IN"
+	cmds := [][]string{
+		{"-N", chain},
+		{"-A", chain, "-m", "conntrack", "--ctstate", "ESTABLISHED,RELATED", "-j", "RETURN"},
+	}
+	for _, d := range dests {
+		cmds = append(cmds, []string{"-A", chain, "-d", d, "-j", "RETURN"})
+	}
+	cmds = append(cmds,
+		[]string{"-A", chain, "-j", "DROP"},
+		[]string{"-N", in},
+		[]string{"-A", in, "-m", "conntrack", "--ctstate", "ESTABLISHED,RELATED", "-j", "RETURN"},
+		[]string{"-A", in, "-p", "tcp", "--dport", e.agentPort, "-j", "RETURN"},
+		[]string{"-A", in, "-j", "DROP"},
+		[]string{"-I", "DOCKER-USER", "-i", bridge, "-j", chain},
+		[]string{"-I", "INPUT", "-i", bridge, "-j", in},
+	)
+	for _, c := range cmds {
+		if err := e.run(c...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeRules removes the chains added by addRules. It removes as much as it
+// can, logging the errors.
+func (e *egress) removeRules(chain, bridge string) {
+	in := chain + "-IN"
+	cmds := [][]string{
+		{"-D", "DOCKER-USER", "-i", bridge, "-j", chain},
+		{"-D", "INPUT", "-i", bridge, "-j", in},
+		{"-F", chain},
+		{"-X", chain},
+		{"-F", in},
+		{"-X", in},
+	}
+	for _, c := range cmds {
+		if err := e.run(c...); err != nil {
+			e.log.Errorf("error removing egress rules of bridge %s: %+v", bridge, err)
+		}
+	}
+}
+
+func (e *egress) iptablesCmd(args ...string) error {
+	// Wait for the xtables lock, instead of failing, when other checks are
+	// changing the rules at the same time.
+	cmd := osexec.Command(e.iptables, append([]string{"-w"}, args...)...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("iptables %s: %w, %s", strings.Join(args, " "), err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEgressDestinations(t *testing.T) {
+	lookupIP = func(host string) ([]net.IP, error) {
+		if host != "example.com" {
+			return nil, errors.New("no such host")
+		}
+		return []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1")}, nil
+	}
+	defer func() { lookupIP = net.LookupIP }()
+	e, err := newEgress(config.EgressConfig{
+		Enabled:    true,
+		AllowCIDRs: []string{"10.0.0.2/32"},
+	}, "172.17.0.1:8080", &log.NullLog{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tests := []struct {
+		target string
+		want   []string
+	}{
+		{target: "192.0.2.10", want: []string{"10.0.0.2/32", "192.0.2.10/32"}},
+		{target: "192.0.2.0/24", want: []string{"10.0.0.2/32", "192.0.2.0/24"}},
+		{target: "example.com", want: []string{"10.0.0.2/32", "192.0.2.1/32"}},
+		{target: "https://example.com:8443/path", want: []string{"10.0.0.2/32", "192.0.2.1/32"}},
+		{target: "unknown.example.com", want: []string{"10.0.0.2/32"}},
+	}
+	for _, tt := range tests {
+		if diff := cmp.Diff(tt.want, e.destinations(tt.target)); diff != "" {
+			t.Errorf("target %s: destinations mismatch (-want +got):\n%v", tt.target, diff)
+		}
+	}
+}
+
+func TestEgressRules(t *testing.T) {
+	e, err := newEgress(config.EgressConfig{Enabled: true}, "172.17.0.1:8080", &log.NullLog{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []string
+	e.run = func(args ...string) error {
+		got = append(got, strings.Join(args, " "))
+		return nil
+	}
+	if err := e.addRules("VULCAN-ID", "vc-id", []string{"192.0.2.1/32"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	e.removeRules("VULCAN-ID", "vc-id")
+	want := []string{
+		"-N VULCAN-ID",
+		"-A VULCAN-ID -m conntrack --ctstate ESTABLISHED,RELATED -j RETURN",
+		"-A VULCAN-ID -d 192.0.2.1/32 -j RETURN",
+		"-A VULCAN-ID -j DROP",
+		"-N VULCAN-ID-IN",
+		"-A VULCAN-ID-IN -m conntrack --ctstate ESTABLISHED,RELATED -j RETURN",
+		"-A VULCAN-ID-IN -p tcp --dport 8080 -j RETURN",
+		"-A VULCAN-ID-IN -j DROP",
+		"-I DOCKER-USER -i vc-id -j VULCAN-ID",
+		"-I INPUT -i vc-id -j VULCAN-ID-IN",
+		"-D DOCKER-USER -i vc-id -j VULCAN-ID",
+		"-D INPUT -i vc-id -j VULCAN-ID-IN",
+		"-F VULCAN-ID",
+		"-X VULCAN-ID",
+		"-F VULCAN-ID-IN",
+		"-X VULCAN-ID-IN",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("iptables commands mismatch (-want +got):\n%v", diff)
+	}
+}
+
+func TestDockerRestrictEgress_StaleNetwork(t *testing.T) {
+	tests := []struct {
+		name        string
+		netAgentID  string
+		running     bool
+		wantErr     bool
+		wantRemoved []string
+	}{
+		{
+			name:        "Stale",
+			netAgentID:  "agent1",
+			wantRemoved: []string{"/containers/c1", "/networks/n1"},
+		},
+		{
+			name:       "OtherAgent",
+			netAgentID: "agent2",
+			wantErr:    true,
+		},
+		{
+			name:       "Running",
+			netAgentID: "agent1",
+			running:    true,
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu      sync.Mutex
+				created bool
+				removed []string
+			)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				// Strip the API version prefix.
+				p := r.URL.Path[strings.Index(r.URL.Path[1:], "/")+1:]
+				if r.Method == http.MethodDelete {
+					removed = append(removed, p)
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				switch p {
+				case "/networks/create":
+					// The network exists until it's removed.
+					if len(removed) == 0 {
+						w.WriteHeader(http.StatusConflict)
+						w.Write([]byte(`{"message": "network with name vulcan-check1 already exists"}`))
+						return
+					}
+					created = true
+					w.WriteHeader(http.StatusCreated)
+					w.Write([]byte(`{"Id": "n2"}`))
+				case "/networks/vulcan-check1":
+					w.Write([]byte(`{"Id": "n1", "Name": "vulcan-check1", "Labels": {"CheckID": "check1", "AgentID": "` + tt.netAgentID + `"}}`))
+				case "/containers/json":
+					w.Write([]byte(`[{"Id": "c1", "Labels": {"CheckID": "check1", "AgentID": "agent1"}}]`))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer srv.Close()
+
+			cli, err := client.NewClientWithOpts(client.WithHost("tcp://" + srv.Listener.Addr().String()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			e, err := newEgress(config.EgressConfig{Enabled: true}, "172.17.0.1:8080", &log.NullLog{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			e.run = func(args ...string) error { return nil }
+			b := &Docker{log: &log.NullLog{}, cli: cli, agentID: "agent1", egress: e}
+			if tt.running {
+				b.containers.Store("check1", "c0")
+			}
+			cfg := RunConfig{HostConfig: &container.HostConfig{}}
+			params := backend.RunParams{CheckID: "check1", Target: "192.0.2.1"}
+			release, err := b.restrictEgress(context.Background(), params, &cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil {
+				release()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if created == tt.wantErr {
+				t.Errorf("got network created %v, want %v", created, !tt.wantErr)
+			}
+			// The new network is also removed when it's released.
+			if created {
+				tt.wantRemoved = append(tt.wantRemoved, "/networks/n2")
+			}
+			if diff := cmp.Diff(tt.wantRemoved, removed); diff != "" {
+				t.Errorf("removed resources mismatch (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package docker
+
+import "github.com/adevinta/vulcan-agent/backend"
+
+const (
+	// The labels of the containers, networks and volumes created for the
+	// checks, so they can be attributed to the agent and to the checks.
+	labelCheckID   = "CheckID"
+	labelAgentID   = "AgentID"
+	labelScanID    = "ScanID"
+	labelChecktype = "Checktype"
+	labelTeam      = "Team"
+
+	// teamMetadataKey is the key of the metadata of the checks that contains
+	// their team, the same used by the jobrunner.
+	teamMetadataKey = "team"
+)
+
+// labels returns the labels of the resources created for the given check.
+// They include the extra labels of the config, which can't override the
+// labels set by the agent.
+func (b *Docker) labels(params backend.RunParams) map[string]string {
+	labels := make(map[string]string, len(b.extraLabels)+5)
+	for k, v := range b.extraLabels {
+		labels[k] = v
+	}
+	labels[labelCheckID] = params.CheckID
+	labels[labelAgentID] = b.agentID
+	labels[labelChecktype] = params.CheckTypeName
+	if params.ScanID != "" {
+		labels[labelScanID] = params.ScanID
+	}
+	if team := params.Metadata[teamMetadataKey]; team != "" {
+		labels[labelTeam] = team
+	}
+	return labels
+}
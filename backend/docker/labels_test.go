@@ -0,0 +1,73 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package docker
+
+import (
+	"testing"
+
+	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDockerLabels(t *testing.T) {
+	tests := []struct {
+		name        string
+		extraLabels map[string]string
+		params      backend.RunParams
+		want        map[string]string
+	}{
+		{
+			name: "OnlyCheck",
+			params: backend.RunParams{
+				CheckID:       "check1",
+				CheckTypeName: "vulcan-nmap",
+			},
+			want: map[string]string{
+				"CheckID":   "check1",
+				"AgentID":   "agent1",
+				"Checktype": "vulcan-nmap",
+			},
+		},
+		{
+			name: "ScanAndTeam",
+			params: backend.RunParams{
+				CheckID:       "check1",
+				ScanID:        "scan1",
+				CheckTypeName: "vulcan-nmap",
+				Metadata:      map[string]string{"team": "security", "program": "p1"},
+			},
+			want: map[string]string{
+				"CheckID":   "check1",
+				"AgentID":   "agent1",
+				"ScanID":    "scan1",
+				"Checktype": "vulcan-nmap",
+				"Team":      "security",
+			},
+		},
+		{
+			name:        "ExtraLabelsDoNotOverride",
+			extraLabels: map[string]string{"env": "pro", "CheckID": "other"},
+			params: backend.RunParams{
+				CheckID:       "check1",
+				CheckTypeName: "vulcan-nmap",
+			},
+			want: map[string]string{
+				"CheckID":   "check1",
+				"AgentID":   "agent1",
+				"Checktype": "vulcan-nmap",
+				"env":       "pro",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Docker{agentID: "agent1", extraLabels: tt.extraLabels}
+			got := b.labels(tt.params)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("labels mismatch (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
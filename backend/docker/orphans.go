@@ -0,0 +1,104 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package docker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// ReapOrphans removes the containers, and their networks and volumes, created
+// by previous executions of the agent, that are identified by having the ID
+// of the agent in their labels. It returns the IDs of the checks of the
+// containers removed. The errors removing the resources are logged.
+func (b *Docker) ReapOrphans(ctx context.Context) ([]string, error) {
+	f := filters.NewArgs(filters.Arg("label", labelAgentID+"="+b.agentID))
+	conts, err := b.client().ContainerList(ctx, types.ContainerListOptions{All: true, Filters: f})
+	if err != nil {
+		return nil, fmt.Errorf("error listing orphan containers: %w", err)
+	}
+	var checks []string
+	for _, c := range conts {
+		checkID := c.Labels[labelCheckID]
+		err := b.client().ContainerRemove(ctx, c.ID, types.ContainerRemoveOptions{Force: true})
+		if err != nil {
+			b.log.Errorf("error removing orphan container %s of check %s: %+v", c.ID, checkID, err)
+			continue
+		}
+		b.log.Infof("removed orphan container %s of check %s", c.ID, checkID)
+		if checkID != "" {
+			checks = append(checks, checkID)
+		}
+	}
+
+	// The networks and volumes must be removed after the containers using
+	// them.
+	nets, err := b.client().NetworkList(ctx, types.NetworkListOptions{Filters: f})
+	if err != nil {
+		b.log.Errorf("error listing orphan networks: %+v", err)
+	}
+	for _, n := range nets {
+		if b.egress != nil && b.egress.enabled {
+			b.egress.removeRules(egressNames(n.Labels[labelCheckID]))
+		}
+		if err := b.client().NetworkRemove(ctx, n.ID); err != nil {
+			b.log.Errorf("error removing orphan network %s: %+v", n.Name, err)
+		}
+	}
+	vols, err := b.client().VolumeList(ctx, f)
+	if err != nil {
+		b.log.Errorf("error listing orphan volumes: %+v", err)
+	}
+	for _, v := range vols.Volumes {
+		if err := b.client().VolumeRemove(ctx, v.Name, true); err != nil {
+			b.log.Errorf("error removing orphan volume %s: %+v", v.Name, err)
+		}
+	}
+	return checks, nil
+}
+
+// removeStaleCheck removes the containers and the network, with the given
+// name, left by a previous execution of the agent for the given check, for
+// instance because it crashed while running the check and the orphans are
+// not reaped. The network must have been created by the agent for the same
+// check, and the check must not be running.
+func (b *Docker) removeStaleCheck(ctx context.Context, checkID, network string) error {
+	if _, ok := b.containers.Load(checkID); ok {
+		return fmt.Errorf("check %s is running", checkID)
+	}
+	n, err := b.client().NetworkInspect(ctx, network, types.NetworkInspectOptions{})
+	if err != nil {
+		return fmt.Errorf("error inspecting network %s: %w", network, err)
+	}
+	if n.Labels[labelAgentID] != b.agentID || n.Labels[labelCheckID] != checkID {
+		return fmt.Errorf("network %s not created by the agent for check %s", network, checkID)
+	}
+	f := filters.NewArgs(
+		filters.Arg("label", labelAgentID+"="+b.agentID),
+		filters.Arg("label", labelCheckID+"="+checkID),
+	)
+	conts, err := b.client().ContainerList(ctx, types.ContainerListOptions{All: true, Filters: f})
+	if err != nil {
+		return fmt.Errorf("error listing stale containers: %w", err)
+	}
+	for _, c := range conts {
+		err := b.client().ContainerRemove(ctx, c.ID, types.ContainerRemoveOptions{Force: true})
+		if err != nil {
+			return fmt.Errorf("error removing stale container %s: %w", c.ID, err)
+		}
+		b.log.Infof("removed stale container %s of check %s", c.ID, checkID)
+	}
+	if b.egress != nil && b.egress.enabled {
+		b.egress.removeRules(egressNames(checkID))
+	}
+	if err := b.client().NetworkRemove(ctx, n.ID); err != nil {
+		return fmt.Errorf("error removing stale network %s: %w", network, err)
+	}
+	b.log.Infof("removed stale network %s of check %s", network, checkID)
+	return nil
+}
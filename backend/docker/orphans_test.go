@@ -0,0 +1,75 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package docker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/docker/docker/client"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDockerReapOrphans(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		removed []string
+		filter  string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		// Strip the API version prefix.
+		p := r.URL.Path[strings.Index(r.URL.Path[1:], "/")+1:]
+		if r.Method == http.MethodDelete {
+			removed = append(removed, p)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch p {
+		case "/containers/json":
+			filter = r.URL.Query().Get("filters")
+			w.Write([]byte(`[
+				{"Id": "c1", "Labels": {"CheckID": "check1", "AgentID": "agent1"}},
+				{"Id": "c2", "Labels": {"CheckID": "check2", "AgentID": "agent1"}}
+			]`))
+		case "/networks":
+			w.Write([]byte(`[{"Id": "n1", "Name": "vulcan-check1", "Labels": {"CheckID": "check1", "AgentID": "agent1"}}]`))
+		case "/volumes":
+			w.Write([]byte(`{"Volumes": [{"Name": "vulcan-check2", "Labels": {"CheckID": "check2", "AgentID": "agent1"}}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://" + srv.Listener.Addr().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &Docker{log: &log.NullLog{}, cli: cli, agentID: "agent1"}
+	got, err := b.ReapOrphans(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"check1", "check2"}, got); diff != "" {
+		t.Errorf("checks mismatch (-want +got):\n%v", diff)
+	}
+	sort.Strings(removed)
+	wantRemoved := []string{"/containers/c1", "/containers/c2", "/networks/n1", "/volumes/vulcan-check2"}
+	if diff := cmp.Diff(wantRemoved, removed); diff != "" {
+		t.Errorf("removed resources mismatch (-want +got):\n%v", diff)
+	}
+	if !strings.Contains(filter, "AgentID=agent1") {
+		t.Errorf("containers not filtered by the agent ID: %s", filter)
+	}
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package docker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/adevinta/vulcan-agent/config"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// platforms contains the default platform of the images and the containers
+// of the checks and the platforms of the checktypes that override it.
+type platforms struct {
+	def        *specs.Platform
+	checktypes map[string]*specs.Platform
+}
+
+// newPlatforms parses the platforms defined in the given config.
+func newPlatforms(cfg config.DockerConfig) (platforms, error) {
+	def, err := parsePlatform(cfg.Platform)
+	if err != nil {
+		return platforms{}, err
+	}
+	checktypes := make(map[string]*specs.Platform, len(cfg.ChecktypePlatforms))
+	for name, s := range cfg.ChecktypePlatforms {
+		p, err := parsePlatform(s)
+		if err != nil {
+			return platforms{}, fmt.Errorf("checktype %s: %w", name, err)
+		}
+		checktypes[name] = p
+	}
+	return platforms{def: def, checktypes: checktypes}, nil
+}
+
+// parsePlatform parses a platform in the format os/arch[/variant], e.g.:
+// linux/arm64/v8. It returns nil for an empty string.
+func parsePlatform(s string) (*specs.Platform, error) {
+	if s == "" {
+		return nil, nil
+	}
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("invalid platform %s, must be os/arch[/variant]", s)
+	}
+	for _, p := range parts {
+		if p == "" {
+			return nil, fmt.Errorf("invalid platform %s, must be os/arch[/variant]", s)
+		}
+	}
+	p := &specs.Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+// platformString formats a platform as os/arch[/variant]. It returns an
+// empty string for a nil platform.
+func platformString(p *specs.Platform) string {
+	if p == nil {
+		return ""
+	}
+	s := p.OS
+	if p.Architecture != "" {
+		s += "/" + p.Architecture
+	}
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// platform returns the platform of the image and the container of the
+// checks of the given checktype. It's nil, the default platform of the
+// daemon, when no platform is configured for linux containers.
+func (b *Docker) platform(checktype string) *specs.Platform {
+	if p, ok := b.platforms.checktypes[checktype]; ok {
+		return p
+	}
+	if b.platforms.def != nil {
+		return b.platforms.def
+	}
+	if b.windows() {
+		return &specs.Platform{OS: osWindows}
+	}
+	return nil
+}
+
+// imageMatches returns true if the given image, that must be present
+// locally, was built for the platform. It's always true for a nil platform.
+func (b *Docker) imageMatches(ctx context.Context, image string, p *specs.Platform) (bool, error) {
+	if p == nil {
+		return true, nil
+	}
+	info, _, err := b.client().ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		return false, err
+	}
+	if info.Os != p.OS {
+		return false, nil
+	}
+	if p.Architecture != "" && info.Architecture != p.Architecture {
+		return false, nil
+	}
+	if p.Variant != "" && info.Variant != p.Variant {
+		return false, nil
+	}
+	return true, nil
+}
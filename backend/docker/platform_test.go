@@ -0,0 +1,91 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package docker
+
+import (
+	"testing"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/google/go-cmp/cmp"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestParsePlatform(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    *specs.Platform
+		wantErr bool
+	}{
+		{name: "Empty"},
+		{name: "OSArch", s: "linux/arm64", want: &specs.Platform{OS: "linux", Architecture: "arm64"}},
+		{name: "Variant", s: "linux/arm/v7", want: &specs.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}},
+		{name: "OnlyOS", s: "linux", wantErr: true},
+		{name: "EmptyArch", s: "linux/", wantErr: true},
+		{name: "TooLong", s: "linux/arm/v7/x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePlatform(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("want error %v, got %v", tt.wantErr, err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("platform mismatch (-want +got):\n%v", diff)
+			}
+			if !tt.wantErr && platformString(got) != tt.s {
+				t.Errorf("want platform string %q, got %q", tt.s, platformString(got))
+			}
+		})
+	}
+}
+
+func TestDockerPlatform(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       config.DockerConfig
+		osType    string
+		checktype string
+		want      string
+	}{
+		{
+			name:      "Default",
+			checktype: "vulcan-nmap",
+		},
+		{
+			name:      "Global",
+			cfg:       config.DockerConfig{Platform: "linux/arm64"},
+			checktype: "vulcan-nmap",
+			want:      "linux/arm64",
+		},
+		{
+			name: "Checktype",
+			cfg: config.DockerConfig{
+				Platform:           "linux/arm64",
+				ChecktypePlatforms: map[string]string{"vulcan-nessus": "linux/amd64"},
+			},
+			checktype: "vulcan-nessus",
+			want:      "linux/amd64",
+		},
+		{
+			name:      "Windows",
+			osType:    osWindows,
+			checktype: "vulcan-nmap",
+			want:      "windows",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := newPlatforms(tt.cfg)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			b := &Docker{platforms: p, osType: tt.osType}
+			if got := platformString(b.platform(tt.checktype)); got != tt.want {
+				t.Errorf("want platform %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
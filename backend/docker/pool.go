@@ -0,0 +1,226 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/docker/docker/client"
+)
+
+// ErrNoHostAvailable is returned by the Pool when none of its hosts can run a
+// new check, because they are unavailable or running their max checks.
+var ErrNoHostAvailable = fmt.Errorf("%w: no docker host available", ErrDaemonUnavailable)
+
+// Pool is a docker backend that runs the checks in a pool of remote docker
+// daemons. Each check runs in the available host that is running fewer
+// checks. The availability of the hosts is tracked by their health monitor.
+type Pool struct {
+	hosts []*poolHost
+	log   log.Logger
+
+	mu sync.Mutex
+	// checks contains the host where each running check runs.
+	checks map[string]*poolHost
+}
+
+// poolHost is a docker daemon of a Pool.
+type poolHost struct {
+	addr    string
+	max     int
+	running int
+	b       *Docker
+}
+
+// NewPool creates a Pool with the remote docker hosts defined in the config.
+// The checks run in remote hosts, so the api.host param, the address where
+// they reach the agent, is mandatory, and the egress policy, that is enforced
+// with iptables rules in the host of the agent, can't be enabled.
+func NewPool(log log.Logger, cfg config.Config, updater ConfigUpdater) (*Pool, error) {
+	if cfg.API.Host == "" {
+		return nil, errors.New("the remote docker hosts require the api.host param")
+	}
+	if cfg.Runtime.Docker.Egress.Enabled {
+		return nil, errors.New("the egress policy is not supported with remote docker hosts")
+	}
+	agentAddr := cfg.API.Host + cfg.API.Port
+	p := &Pool{log: log, checks: make(map[string]*poolHost)}
+	for _, h := range cfg.Runtime.Docker.Hosts {
+		h := h
+		newClient := func() (*client.Client, error) {
+			opts := []client.Opt{client.WithHost(h.Address)}
+			if h.CACert != "" || h.Cert != "" {
+				opts = append(opts, client.WithTLSClientConfig(h.CACert, h.Cert, h.Key))
+			}
+			opts = append(opts, client.WithAPIVersionNegotiation())
+			return client.NewClientWithOpts(opts...)
+		}
+		b, err := newDocker(log, cfg, updater, agentAddr, newClient)
+		if err != nil {
+			return nil, fmt.Errorf("error creating backend for docker host %s: %w", h.Address, err)
+		}
+		p.hosts = append(p.hosts, &poolHost{addr: h.Address, max: h.MaxChecks, b: b})
+	}
+	return p, nil
+}
+
+// Run starts executing a check in the least loaded available host of the pool
+// and returns a channel that will contain the result of the execution when
+// it finishes.
+func (p *Pool) Run(ctx context.Context, params backend.RunParams) (<-chan backend.RunResult, error) {
+	h, err := p.acquire(params.CheckID)
+	if err != nil {
+		return nil, err
+	}
+	p.log.Debugf("running check %s in docker host %s", params.CheckID, h.addr)
+	res, err := h.b.Run(ctx, params)
+	if err != nil {
+		p.release(params.CheckID, h)
+		return nil, err
+	}
+	out := make(chan backend.RunResult)
+	go func() {
+		r := <-res
+		p.release(params.CheckID, h)
+		out <- r
+	}()
+	return out, nil
+}
+
+// acquire selects the host where a check runs.
+func (p *Pool) acquire(checkID string) (*poolHost, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var selected *poolHost
+	for _, h := range p.hosts {
+		if !h.b.available() || (h.max > 0 && h.running >= h.max) {
+			continue
+		}
+		if selected == nil || h.running < selected.running {
+			selected = h
+		}
+	}
+	if selected == nil {
+		return nil, ErrNoHostAvailable
+	}
+	selected.running++
+	p.checks[checkID] = selected
+	return selected, nil
+}
+
+func (p *Pool) release(checkID string, h *poolHost) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h.running--
+	delete(p.checks, checkID)
+}
+
+// host returns the host where a check is running.
+func (p *Pool) host(checkID string) (*poolHost, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h, ok := p.checks[checkID]
+	return h, ok
+}
+
+// StreamLogs writes the output of a running check, see Docker.StreamLogs.
+func (p *Pool) StreamLogs(ctx context.Context, checkID string, follow bool, w io.Writer) error {
+	h, ok := p.host(checkID)
+	if !ok {
+		return backend.ErrCheckNotFound
+	}
+	return h.b.StreamLogs(ctx, checkID, follow, w)
+}
+
+// Exec executes a command inside a running check, see Docker.Exec.
+func (p *Pool) Exec(ctx context.Context, checkID string, cmd []string) (backend.ExecResult, error) {
+	h, ok := p.host(checkID)
+	if !ok {
+		return backend.ExecResult{}, backend.ErrCheckNotFound
+	}
+	return h.b.Exec(ctx, checkID, cmd)
+}
+
+// Ping checks that at least one of the hosts of the pool is reachable.
+func (p *Pool) Ping(ctx context.Context) error {
+	var errs []string
+	for _, h := range p.hosts {
+		err := h.b.Ping(ctx)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", h.addr, err))
+	}
+	return fmt.Errorf("no docker host reachable: %s", strings.Join(errs, ", "))
+}
+
+// ImageDigest returns the digest of the image in the first available host of
+// the pool.
+func (p *Pool) ImageDigest(ctx context.Context, image string) (string, error) {
+	for _, h := range p.hosts {
+		if h.b.available() {
+			return h.b.ImageDigest(ctx, image)
+		}
+	}
+	return "", ErrNoHostAvailable
+}
+
+// ReapOrphans removes the checks left running by previous executions of the
+// agent in all the hosts of the pool.
+func (p *Pool) ReapOrphans(ctx context.Context) ([]string, error) {
+	var checks []string
+	for _, h := range p.hosts {
+		ids, err := h.b.ReapOrphans(ctx)
+		if err != nil {
+			p.log.Errorf("error removing orphan checks in docker host %s: %+v", h.addr, err)
+			continue
+		}
+		checks = append(checks, ids...)
+	}
+	return checks, nil
+}
+
+// PrePull pre-pulls the images in all the hosts of the pool. The returned
+// channel is closed when all the hosts have finished.
+func (p *Pool) PrePull(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	var hosts []<-chan struct{}
+	for _, h := range p.hosts {
+		hosts = append(hosts, h.b.PrePull(ctx))
+	}
+	go func() {
+		defer close(done)
+		for _, h := range hosts {
+			<-h
+		}
+	}()
+	return done
+}
+
+// SetCheckVars replaces the vars available to the checks in all the hosts.
+func (p *Pool) SetCheckVars(vars backend.CheckVars) {
+	for _, h := range p.hosts {
+		h.b.SetCheckVars(vars)
+	}
+}
+
+// SetRegistryAuths replaces the credentials of the registries in all the
+// hosts.
+func (p *Pool) SetRegistryAuths(cfg config.RegistryConfig) error {
+	for _, h := range p.hosts {
+		if err := h.b.SetRegistryAuths(cfg); err != nil {
+			return fmt.Errorf("docker host %s: %w", h.addr, err)
+		}
+	}
+	return nil
+}
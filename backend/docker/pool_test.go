@@ -0,0 +1,87 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package docker
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/adevinta/vulcan-agent/log"
+)
+
+func TestPool_acquire(t *testing.T) {
+	tests := []struct {
+		name        string
+		running     []int
+		max         []int
+		unavailable []bool
+		want        string
+		wantErr     error
+	}{
+		{
+			name:        "LeastLoaded",
+			running:     []int{2, 1, 3},
+			max:         []int{0, 0, 0},
+			unavailable: []bool{false, false, false},
+			want:        "h2",
+		},
+		{
+			name:        "SkipsUnavailable",
+			running:     []int{2, 1, 3},
+			max:         []int{0, 0, 0},
+			unavailable: []bool{false, true, false},
+			want:        "h1",
+		},
+		{
+			name:        "SkipsFull",
+			running:     []int{2, 1, 3},
+			max:         []int{0, 1, 0},
+			unavailable: []bool{false, false, false},
+			want:        "h1",
+		},
+		{
+			name:        "NoHostAvailable",
+			running:     []int{1, 1},
+			max:         []int{1, 0},
+			unavailable: []bool{false, true},
+			wantErr:     ErrNoHostAvailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Pool{log: &log.NullLog{}, checks: make(map[string]*poolHost)}
+			for i := range tt.running {
+				b := &Docker{log: &log.NullLog{}}
+				if tt.unavailable[i] {
+					b.daemon.unavailable = 1
+				}
+				p.hosts = append(p.hosts, &poolHost{
+					addr:    "h" + string(rune('1'+i)),
+					running: tt.running[i],
+					max:     tt.max[i],
+					b:       b,
+				})
+			}
+			h, err := p.acquire("check1")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("want error %v, got %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if h.addr != tt.want {
+				t.Errorf("want host %s, got %s", tt.want, h.addr)
+			}
+			got, ok := p.host("check1")
+			if !ok || got != h {
+				t.Errorf("check not tracked in the selected host")
+			}
+			p.release("check1", h)
+			if _, ok := p.host("check1"); ok {
+				t.Errorf("check still tracked after being released")
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+)
+
+const prePullEndpointTimeout = 30 * time.Second
+
+// PrePull pulls, in parallel, the images defined in the pre_pull_images config
+// param plus the ones returned by the pre_pull_endpoint, so the first checks of
+// each checktype don't have to wait for the image to be pulled. Errors pulling
+// an image are logged but they don't stop the process. No more images are
+// pulled after the context is done. The returned channel is closed when all
+// the pulls have finished.
+func (b *Docker) PrePull(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		images := b.prePullImages(ctx)
+		if len(images) == 0 {
+			return
+		}
+		b.log.Infof("pre-pulling %d images", len(images))
+		prePull(ctx, b.log, images, b.config.PrePullConcurrency, func(ctx context.Context, image string) error {
+			// The images are pulled for the platform of their checktype.
+			var checktype string
+			if name, _, err := backend.ChecktypeInfo(image); err == nil {
+				checktype = name
+			}
+			return b.pull(ctx, image, b.platform(checktype))
+		})
+		b.log.Infof("pre-pulling images finished")
+	}()
+	return done
+}
+
+// prePull pulls the given images using the given pull function, with at most
+// n pulls at the same time, until all the images are pulled or the context
+// is done.
+func prePull(ctx context.Context, l log.Logger, images []string, n int, pull func(ctx context.Context, image string) error) {
+	if n < 1 {
+		n = config.DefaultPrePullConcurrency
+	}
+	sem := make(chan struct{}, n)
+	wg := sync.WaitGroup{}
+	defer wg.Wait()
+	for _, image := range images {
+		select {
+		case <-ctx.Done():
+			return
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(image string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := pull(ctx, image); err != nil {
+				l.Errorf("error pre-pulling image %s: %+v", image, err)
+			}
+		}(image)
+	}
+}
+
+// prePullImages returns the list, without duplicates, of images to pre-pull.
+func (b *Docker) prePullImages(ctx context.Context) []string {
+	images := append([]string{}, b.config.PrePullImages...)
+	if b.config.PrePullEndpoint != "" {
+		remote, err := fetchPrePullImages(ctx, b.config.PrePullEndpoint)
+		if err != nil {
+			b.log.Errorf("error fetching images to pre-pull from %s: %+v", b.config.PrePullEndpoint, err)
+		}
+		images = append(images, remote...)
+	}
+	seen := map[string]struct{}{}
+	var res []string
+	for _, image := range images {
+		if _, ok := seen[image]; ok || image == "" {
+			continue
+		}
+		seen[image] = struct{}{}
+		res = append(res, image)
+	}
+	return res
+}
+
+func fetchPrePullImages(ctx context.Context, endpoint string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, prePullEndpointTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	var images []string
+	if err := json.NewDecoder(resp.Body).Decode(&images); err != nil {
+		return nil, fmt.Errorf("decoding images: %w", err)
+	}
+	return images, nil
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPrePull(t *testing.T) {
+	images := []string{"check1:1", "check2:1", "check3:1", "check4:1", "check5:1"}
+	var (
+		mu            sync.Mutex
+		pulled        []string
+		running, peak int
+	)
+	pull := func(ctx context.Context, image string) error {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		running--
+		pulled = append(pulled, image)
+		if image == "check3:1" {
+			return errors.New("pull error")
+		}
+		return nil
+	}
+	prePull(context.Background(), &log.NullLog{}, images, 2, pull)
+	sort.Strings(pulled)
+	if diff := cmp.Diff(images, pulled); diff != "" {
+		t.Errorf("images pulled mismatch (-want +got):\n%s", diff)
+	}
+	if peak != 2 {
+		t.Errorf("want 2 concurrent pulls, got %d", peak)
+	}
+}
+
+func TestPrePull_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var pulled []string
+	pull := func(ctx context.Context, image string) error {
+		pulled = append(pulled, image)
+		cancel()
+		return nil
+	}
+	prePull(ctx, &log.NullLog{}, []string{"check1:1", "check2:1", "check3:1"}, 1, pull)
+	if diff := cmp.Diff([]string{"check1:1"}, pulled); diff != "" {
+		t.Errorf("images pulled after the context was done (-want +got):\n%s", diff)
+	}
+}
+
+func TestDocker_prePullImages(t *testing.T) {
+	tests := []struct {
+		name     string
+		images   []string
+		status   int
+		response string
+		want     []string
+	}{
+		{
+			name:     "MergesEndpointImages",
+			images:   []string{"check1:1", "check2:1"},
+			status:   http.StatusOK,
+			response: `["check2:1", "check3:1", ""]`,
+			want:     []string{"check1:1", "check2:1", "check3:1"},
+		},
+		{
+			name:   "EndpointError",
+			images: []string{"check1:1"},
+			status: http.StatusInternalServerError,
+			want:   []string{"check1:1"},
+		},
+		{
+			name:     "InvalidResponse",
+			images:   []string{"check1:1"},
+			status:   http.StatusOK,
+			response: `{"images": []}`,
+			want:     []string{"check1:1"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.response))
+			}))
+			defer srv.Close()
+			b := &Docker{
+				config: config.RegistryConfig{
+					PrePullImages:   tt.images,
+					PrePullEndpoint: srv.URL,
+				},
+				log: &log.NullLog{},
+			}
+			got := b.prePullImages(context.Background())
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("images mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package docker
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/docker/docker/api/types/mount"
+	volumetypes "github.com/docker/docker/api/types/volume"
+)
+
+const defaultMaxArtifactsMB = 50
+
+// errArtifactsTooBig is returned when the artifacts of a check exceed the max
+// size allowed.
+var errArtifactsTooBig = errors.New("artifacts exceed the max size")
+
+// sandbox mounts a scratch volume in the containers of the checks and
+// collects the artifacts they produce.
+type sandbox struct {
+	scratchPath  string
+	artifacts    map[string][]string
+	maxArtifacts int64
+}
+
+func newSandbox(cfg config.SandboxConfig) sandbox {
+	max := cfg.MaxArtifactsMB
+	if max <= 0 {
+		max = defaultMaxArtifactsMB
+	}
+	return sandbox{
+		scratchPath:  cfg.ScratchPath,
+		artifacts:    cfg.Artifacts,
+		maxArtifacts: int64(max) * 1024 * 1024,
+	}
+}
+
+// mountScratch creates the scratch volume of a check and mounts it in its
+// container. It returns a func that removes the volume, that must be called
+// after removing the container.
+func (b *Docker) mountScratch(ctx context.Context, params backend.RunParams, cfg *RunConfig) (func(), error) {
+	if b.sandbox.scratchPath == "" {
+		return func() {}, nil
+	}
+	name := "vulcan-" + params.CheckID
+	_, err := b.client().VolumeCreate(ctx, volumetypes.VolumeCreateBody{
+		Name:   name,
+		Labels: b.labels(params),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating scratch volume for check %s: %w", params.CheckID, err)
+	}
+	cfg.HostConfig.Mounts = append(cfg.HostConfig.Mounts, mount.Mount{
+		Type:   mount.TypeVolume,
+		Source: name,
+		Target: b.containerPath(b.sandbox.scratchPath),
+	})
+	return func() {
+		if err := b.client().VolumeRemove(context.Background(), name, true); err != nil {
+			b.log.Errorf("error removing scratch volume of check %s: %+v", params.CheckID, err)
+		}
+	}, nil
+}
+
+// collectArtifacts copies the artifacts declared for the checktype of a check
+// from its container. The paths that don't exist are ignored.
+func (b *Docker) collectArtifacts(contID string, params backend.RunParams) []backend.Artifact {
+	var (
+		artifacts []backend.Artifact
+		size      int64
+	)
+	for _, p := range b.sandbox.artifacts[params.CheckTypeName] {
+		rc, _, err := b.client().CopyFromContainer(context.Background(), contID, b.containerPath(p))
+		if err != nil {
+			b.log.Infof("artifact %s of check %s not collected: %+v", p, params.CheckID, err)
+			continue
+		}
+		arts, err := readArtifacts(rc, b.sandbox.maxArtifacts-size)
+		rc.Close()
+		for _, a := range arts {
+			size += int64(len(a.Data))
+		}
+		artifacts = append(artifacts, arts...)
+		if err != nil {
+			b.log.Errorf("error collecting artifact %s of check %s: %+v", p, params.CheckID, err)
+		}
+		if errors.Is(err, errArtifactsTooBig) {
+			break
+		}
+	}
+	return artifacts
+}
+
+// readArtifacts reads the regular files of the tar archive returned by the
+// docker API when copying a path from a container. It stops returning
+// errArtifactsTooBig when the size of the files read exceeds max.
+func readArtifacts(r io.Reader, max int64) ([]backend.Artifact, error) {
+	var artifacts []backend.Artifact
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return artifacts, nil
+		}
+		if err != nil {
+			return artifacts, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if hdr.Size > max {
+			return artifacts, fmt.Errorf("%w: %s", errArtifactsTooBig, hdr.Name)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return artifacts, err
+		}
+		max -= int64(len(data))
+		artifacts = append(artifacts, backend.Artifact{Name: path.Clean(hdr.Name), Data: data})
+	}
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReadArtifacts(t *testing.T) {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	files := []struct {
+		name string
+		dir  bool
+		data string
+	}{
+		{name: "out/", dir: true},
+		{name: "out/capture.pcap", data: "pcap"},
+		{name: "out/screenshot.png", data: "png"},
+	}
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.name, Mode: 0o644, Size: int64(len(f.data)), Typeflag: tar.TypeReg}
+		if f.dir {
+			hdr.Typeflag = tar.TypeDir
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(f.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		max     int64
+		want    []backend.Artifact
+		wantErr error
+	}{
+		{
+			name: "ReadsTheFiles",
+			max:  1024,
+			want: []backend.Artifact{
+				{Name: "out/capture.pcap", Data: []byte("pcap")},
+				{Name: "out/screenshot.png", Data: []byte("png")},
+			},
+		},
+		{
+			name:    "StopsWhenTheMaxSizeIsExceeded",
+			max:     6,
+			want:    []backend.Artifact{{Name: "out/capture.pcap", Data: []byte("pcap")}},
+			wantErr: errArtifactsTooBig,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readArtifacts(bytes.NewReader(buf.Bytes()), tt.max)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("want error %v, got %v", tt.wantErr, err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("artifacts mismatch (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
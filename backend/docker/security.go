@@ -0,0 +1,105 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package docker
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/docker/docker/api/types/container"
+)
+
+// defaultTmpfs contains the paths where a tmpfs is mounted in the containers
+// with a read-only root filesystem when no paths are configured.
+var defaultTmpfs = []string{"/tmp"}
+
+// securityProfile contains the security settings applied to the containers
+// of the checks, with the seccomp profile already loaded.
+type securityProfile struct {
+	capAdd   []string
+	tmpfs    []string
+	seccomp  string
+	apparmor string
+}
+
+// securityProfiles contains the default security profile and the profiles
+// of the checktypes that override it.
+type securityProfiles struct {
+	enabled    bool
+	def        securityProfile
+	checktypes map[string]securityProfile
+}
+
+// newSecurityProfiles loads the security profiles defined in the given
+// config. It returns an error if any of the seccomp profiles can't be read.
+func newSecurityProfiles(cfg config.SecurityConfig) (securityProfiles, error) {
+	if !cfg.Enabled {
+		return securityProfiles{}, nil
+	}
+	def, err := loadSecurityProfile(cfg.SecurityProfile, securityProfile{tmpfs: defaultTmpfs})
+	if err != nil {
+		return securityProfiles{}, err
+	}
+	checktypes := make(map[string]securityProfile, len(cfg.Checktypes))
+	for name, p := range cfg.Checktypes {
+		sp, err := loadSecurityProfile(p, def)
+		if err != nil {
+			return securityProfiles{}, fmt.Errorf("checktype %s: %w", name, err)
+		}
+		checktypes[name] = sp
+	}
+	return securityProfiles{enabled: true, def: def, checktypes: checktypes}, nil
+}
+
+// loadSecurityProfile returns the given profile taking the settings that are
+// not defined from the base profile.
+func loadSecurityProfile(p config.SecurityProfile, base securityProfile) (securityProfile, error) {
+	sp := base
+	if p.CapAdd != nil {
+		sp.capAdd = p.CapAdd
+	}
+	if p.Tmpfs != nil {
+		sp.tmpfs = p.Tmpfs
+	}
+	if p.AppArmor != "" {
+		sp.apparmor = p.AppArmor
+	}
+	if p.Seccomp != "" {
+		// The docker API expects the content of the profile, not its path.
+		content, err := os.ReadFile(p.Seccomp)
+		if err != nil {
+			return securityProfile{}, fmt.Errorf("error reading seccomp profile: %w", err)
+		}
+		sp.seccomp = string(content)
+	}
+	return sp, nil
+}
+
+// apply sets the security settings for the given checktype in the host
+// config of a container.
+func (s securityProfiles) apply(checktype string, hc *container.HostConfig) {
+	if !s.enabled {
+		return
+	}
+	p, ok := s.checktypes[checktype]
+	if !ok {
+		p = s.def
+	}
+	hc.CapDrop = []string{"ALL"}
+	hc.CapAdd = p.capAdd
+	hc.SecurityOpt = append(hc.SecurityOpt, "no-new-privileges")
+	if p.seccomp != "" {
+		hc.SecurityOpt = append(hc.SecurityOpt, "seccomp="+p.seccomp)
+	}
+	if p.apparmor != "" {
+		hc.SecurityOpt = append(hc.SecurityOpt, "apparmor="+p.apparmor)
+	}
+	hc.ReadonlyRootfs = true
+	hc.Tmpfs = make(map[string]string, len(p.tmpfs))
+	for _, path := range p.tmpfs {
+		hc.Tmpfs[path] = ""
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/docker/docker/api/types/container"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSecurityProfilesApply(t *testing.T) {
+	seccomp := filepath.Join(t.TempDir(), "seccomp.json")
+	if err := os.WriteFile(seccomp, []byte(`{"defaultAction":"SCMP_ACT_ERRNO"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		cfg       config.SecurityConfig
+		checktype string
+		want      container.HostConfig
+		wantErr   bool
+	}{
+		{
+			name: "Disabled",
+			cfg: config.SecurityConfig{
+				SecurityProfile: config.SecurityProfile{CapAdd: []string{"NET_RAW"}},
+			},
+			checktype: "vulcan-nmap",
+			want:      container.HostConfig{},
+		},
+		{
+			name: "AppliesTheDefaultProfile",
+			cfg: config.SecurityConfig{
+				Enabled:         true,
+				SecurityProfile: config.SecurityProfile{AppArmor: "vulcan-check"},
+			},
+			checktype: "vulcan-exposed-http",
+			want: container.HostConfig{
+				CapDrop:        []string{"ALL"},
+				SecurityOpt:    []string{"no-new-privileges", "apparmor=vulcan-check"},
+				ReadonlyRootfs: true,
+				Tmpfs:          map[string]string{"/tmp": ""},
+			},
+		},
+		{
+			name: "AppliesTheProfileOfTheChecktype",
+			cfg: config.SecurityConfig{
+				Enabled:         true,
+				SecurityProfile: config.SecurityProfile{AppArmor: "vulcan-check"},
+				Checktypes: map[string]config.SecurityProfile{
+					"vulcan-nmap": {
+						CapAdd:  []string{"NET_RAW"},
+						Tmpfs:   []string{"/tmp", "/root"},
+						Seccomp: seccomp,
+					},
+				},
+			},
+			checktype: "vulcan-nmap",
+			want: container.HostConfig{
+				CapAdd:  []string{"NET_RAW"},
+				CapDrop: []string{"ALL"},
+				SecurityOpt: []string{
+					"no-new-privileges",
+					`seccomp={"defaultAction":"SCMP_ACT_ERRNO"}`,
+					"apparmor=vulcan-check",
+				},
+				ReadonlyRootfs: true,
+				Tmpfs:          map[string]string{"/tmp": "", "/root": ""},
+			},
+		},
+		{
+			name: "FailsIfTheSeccompProfileDoesNotExist",
+			cfg: config.SecurityConfig{
+				Enabled:         true,
+				SecurityProfile: config.SecurityProfile{Seccomp: filepath.Join(t.TempDir(), "missing.json")},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := newSecurityProfiles(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("want error %v, got %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			var got container.HostConfig
+			s.apply(tt.checktype, &got)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("host config mismatch (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
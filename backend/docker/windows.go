@@ -0,0 +1,145 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"strings"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/docker/docker/api/types/container"
+)
+
+const (
+	osLinux   = "linux"
+	osWindows = "windows"
+)
+
+// windowsIfacePrefix is the prefix of the names of the virtual interfaces
+// created by the Host Networking Service for the networks of the Windows
+// containers.
+const windowsIfacePrefix = "vEthernet ("
+
+// defaultIfaceNames returns the names of the interfaces, in order of
+// preference, that connect the host with the containers in the given OS.
+func defaultIfaceNames(goos string) []string {
+	if goos == osWindows {
+		return []string{"vEthernet (nat)", "vEthernet (HNS Internal NIC)"}
+	}
+	return []string{defaultDockerIfaceName}
+}
+
+// discoverAgentAddr returns the address of the agent API in the default
+// interface of the containers of the host. On Windows, where the name of the
+// interface depends on the network driver, any other interface created by
+// the Host Networking Service is also considered.
+func discoverAgentAddr(port string) (string, error) {
+	names := defaultIfaceNames(runtime.GOOS)
+	if runtime.GOOS == osWindows {
+		ifaces, err := net.Interfaces()
+		if err != nil {
+			return "", err
+		}
+		for _, i := range ifaces {
+			if strings.HasPrefix(i.Name, windowsIfacePrefix) {
+				names = append(names, i.Name)
+			}
+		}
+	}
+	var errs []string
+	for _, name := range names {
+		addr, err := getAgentAddr(port, name)
+		if err == nil {
+			return addr, nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+	}
+	return "", fmt.Errorf("failed to determine Docker agent IP address: %s", strings.Join(errs, ", "))
+}
+
+// detectOSType returns the operating system of the containers run by the
+// backend: the configured one or, if not set, the one of the docker daemon.
+// Linux is assumed when the daemon can't be reached.
+func (b *Docker) detectOSType(ctx context.Context, cfg config.DockerConfig) (string, error) {
+	switch cfg.OSType {
+	case osLinux, osWindows:
+		return cfg.OSType, nil
+	case "":
+	default:
+		return "", fmt.Errorf("invalid os_type %s", cfg.OSType)
+	}
+	info, err := b.client().Info(ctx)
+	if err != nil {
+		b.log.Errorf("error getting the OS of the docker daemon, assuming linux: %+v", err)
+		return osLinux, nil
+	}
+	if info.OSType == osWindows {
+		return osWindows, nil
+	}
+	return osLinux, nil
+}
+
+// checkWindows returns an error if the config uses features that are not
+// supported by the Windows containers.
+func checkWindows(cfg config.DockerConfig) error {
+	if cfg.Security.Enabled {
+		return errors.New("the security profiles are not supported with windows containers")
+	}
+	if cfg.Egress.Enabled {
+		return errors.New("the egress policy is not supported with windows containers")
+	}
+	if cfg.Runtime != "" || len(cfg.ChecktypeRuntimes) > 0 {
+		return errors.New("the OCI runtimes are not supported with windows containers, use isolation")
+	}
+	if len(cfg.Devices) > 0 {
+		return errors.New("the devices are not supported with windows containers")
+	}
+	switch container.Isolation(cfg.Isolation) {
+	case container.IsolationEmpty, container.IsolationDefault, container.IsolationProcess, container.IsolationHyperV:
+	default:
+		return fmt.Errorf("invalid isolation %s", cfg.Isolation)
+	}
+	return nil
+}
+
+// windows returns true if the backend runs Windows containers.
+func (b *Docker) windows() bool {
+	return b.osType == osWindows
+}
+
+// containerPath returns the given path of a container in the format of its
+// OS. The absolute paths in unix format, like the ones of the scratch volume
+// and the artifacts, are converted to paths of the C: drive in the Windows
+// containers, e.g.: /scratch/out.txt is C:\scratch\out.txt.
+func (b *Docker) containerPath(p string) string {
+	if !b.windows() || !strings.HasPrefix(p, "/") {
+		return p
+	}
+	return `C:` + strings.ReplaceAll(p, "/", `\`)
+}
+
+// windowsEnv removes the duplicated variables of an environment, keeping
+// the last value of each one in the position of its first occurrence, as
+// the names of the environment variables are case insensitive on Windows.
+func windowsEnv(env []string) []string {
+	var (
+		out []string
+		pos = make(map[string]int)
+	)
+	for _, v := range env {
+		name := strings.ToUpper(strings.SplitN(v, "=", 2)[0])
+		if i, ok := pos[name]; ok {
+			out[i] = v
+			continue
+		}
+		pos[name] = len(out)
+		out = append(out, v)
+	}
+	return out
+}
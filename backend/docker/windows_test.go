@@ -0,0 +1,84 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package docker
+
+import (
+	"testing"
+
+	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/docker/docker/api/types/container"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWindowsEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		env  []string
+		want []string
+	}{
+		{
+			name: "NoDuplicates",
+			env:  []string{"A=1", "B=2"},
+			want: []string{"A=1", "B=2"},
+		},
+		{
+			name: "DifferentCase",
+			env:  []string{"Path=C:\\Windows", "TARGET=example.com", "PATH=C:\\tools", "target=other.com"},
+			want: []string{"PATH=C:\\tools", "target=other.com"},
+		},
+		{
+			name: "ValueWithEquals",
+			env:  []string{"OPTIONS={\"a\":\"b=c\"}", "options={}"},
+			want: []string{"options={}"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := windowsEnv(tt.env)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("env mismatch (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestDockerContainerPath(t *testing.T) {
+	tests := []struct {
+		name   string
+		osType string
+		path   string
+		want   string
+	}{
+		{name: "Linux", osType: osLinux, path: "/scratch/out.txt", want: "/scratch/out.txt"},
+		{name: "Windows", osType: osWindows, path: "/scratch/out.txt", want: `C:\scratch\out.txt`},
+		{name: "WindowsPath", osType: osWindows, path: `D:\out.txt`, want: `D:\out.txt`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Docker{osType: tt.osType}
+			if got := b.containerPath(tt.path); got != tt.want {
+				t.Errorf("want path %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDockerGetRunConfigWindows(t *testing.T) {
+	b := &Docker{
+		osType:    osWindows,
+		isolation: container.IsolationHyperV,
+		checkVars: backend.CheckVars{Global: map[string]string{"vulcan_check_id": "other"}},
+	}
+	cfg := b.getRunConfig(backend.RunParams{CheckID: "check1", RequiredVars: []string{"vulcan_check_id"}})
+	if got := cfg.HostConfig.Isolation; got != container.IsolationHyperV {
+		t.Errorf("want isolation %q, got %q", container.IsolationHyperV, got)
+	}
+	if got := cfg.ContainerConfig.Env[0]; got != "vulcan_check_id=other" {
+		t.Errorf("want first var %q, got %q", "vulcan_check_id=other", got)
+	}
+	if p := b.platform(""); p == nil || p.OS != osWindows {
+		t.Errorf("want windows platform, got %+v", p)
+	}
+}
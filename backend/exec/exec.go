@@ -0,0 +1,206 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+// Package exec implements a backend that runs the checks as processes of the
+// host, for the environments where running a container runtime is not
+// possible.
+package exec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+)
+
+const (
+	abortTimeout     = 5 * time.Second
+	defaultAgentHost = "localhost"
+)
+
+// Exec implements a backend that runs the checks as local processes from a
+// directory containing the binaries of the checktypes.
+type Exec struct {
+	dir        string
+	inheritEnv bool
+	agentAddr  string
+	checkVars  backend.CheckVars
+	varsMu     sync.RWMutex
+	log        log.Logger
+}
+
+func init() {
+	backend.Register(config.BackendExec, func(l log.Logger, cfg config.Config) (backend.Backend, error) {
+		b, err := NewBackend(l, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return b, nil
+	})
+}
+
+// NewBackend creates a new exec backend using the given config.
+func NewBackend(log log.Logger, cfg config.Config) (*Exec, error) {
+	dir := cfg.Runtime.Exec.ChecksDir
+	if dir == "" {
+		return nil, errors.New("the checks dir of the exec backend is mandatory")
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error accessing checks dir: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("checks dir %s is not a directory", dir)
+	}
+	checkVars, err := backend.NewCheckVars(cfg.Check)
+	if err != nil {
+		return nil, err
+	}
+	host := cfg.API.Host
+	if host == "" {
+		host = defaultAgentHost
+	}
+	return &Exec{
+		dir:        dir,
+		inheritEnv: cfg.Runtime.Exec.InheritEnv,
+		agentAddr:  host + cfg.API.Port,
+		checkVars:  checkVars,
+		log:        log,
+	}, nil
+}
+
+// Run starts executing a check as a local process and returns a channel that
+// will contain the result of the execution when it finishes.
+func (b *Exec) Run(ctx context.Context, params backend.RunParams) (<-chan backend.RunResult, error) {
+	b.varsMu.RLock()
+	err := backend.CheckRequiredVars(params, b.checkVars)
+	b.varsMu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	path, err := b.binary(params.CheckTypeName, params.ChecktypeVersion)
+	if err != nil {
+		return nil, err
+	}
+	res := make(chan backend.RunResult)
+	go b.run(ctx, path, params, res)
+	return res, nil
+}
+
+func (b *Exec) run(ctx context.Context, path string, params backend.RunParams, res chan<- backend.RunResult) {
+	bout, berr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd := osexec.Command(path)
+	cmd.Dir = b.dir
+	cmd.Env = b.env(params)
+	cmd.Stdout = bout
+	cmd.Stderr = berr
+	if err := cmd.Start(); err != nil {
+		err = fmt.Errorf("error starting process for check %s: %w", params.CheckID, err)
+		res <- backend.RunResult{Error: err}
+		return
+	}
+	waitC := make(chan error, 1)
+	go func() {
+		waitC <- cmd.Wait()
+	}()
+	var err error
+	select {
+	case err = <-waitC:
+	case <-ctx.Done():
+		// Give the check the chance to finish by itself before killing it,
+		// as the docker backend does when stopping a container.
+		b.log.Infof("check: %s timeout or aborted ensure process %d is stopped", params.CheckID, cmd.Process.Pid)
+		timeout := abortTimeout
+		if params.KillGrace > 0 {
+			timeout = params.KillGrace
+		}
+		if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+			b.log.Errorf("error stopping process of check %s: %+v", params.CheckID, err)
+		}
+		select {
+		case <-waitC:
+		case <-time.After(timeout):
+			cmd.Process.Kill()
+			<-waitC
+		}
+		res <- backend.RunResult{Output: output(bout, berr), Error: ctx.Err()}
+		return
+	}
+	var exitErr *osexec.ExitError
+	if errors.As(err, &exitErr) {
+		err = fmt.Errorf("%w exit: %d", backend.ErrNonZeroExitCode, exitErr.ExitCode())
+	} else if err != nil {
+		err = fmt.Errorf("error running process for check %s: %w", params.CheckID, err)
+	}
+	res <- backend.RunResult{Output: output(bout, berr), Error: err}
+}
+
+// binary returns the path of the binary of the given checktype.
+func (b *Exec) binary(name, version string) (string, error) {
+	name = filepath.Base(name)
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		return "", fmt.Errorf("invalid checktype name %q", name)
+	}
+	candidates := []string{filepath.Join(b.dir, name)}
+	if version != "" {
+		candidates = append([]string{filepath.Join(b.dir, name+"-"+version)}, candidates...)
+	}
+	for _, c := range candidates {
+		info, err := os.Stat(c)
+		if err == nil && info.Mode().IsRegular() {
+			return c, nil
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("error accessing binary of checktype %s: %w", name, err)
+		}
+	}
+	return "", fmt.Errorf("binary of checktype %s not found in %s", name, b.dir)
+}
+
+// env returns the environment of the process of a check. It contains the
+// same vars the docker backend injects in the containers plus the PATH of the
+// agent, or its full environment if the backend is configured to inherit it.
+func (b *Exec) env(params backend.RunParams) []string {
+	var env []string
+	if b.inheritEnv {
+		env = os.Environ()
+	} else if path, ok := os.LookupEnv("PATH"); ok {
+		env = append(env, "PATH="+path)
+	}
+	b.varsMu.RLock()
+	defer b.varsMu.RUnlock()
+	return append(env, backend.CheckEnv(params, b.agentAddr, b.checkVars)...)
+}
+
+// Ping checks that the checks dir is accessible.
+func (b *Exec) Ping(ctx context.Context) error {
+	if _, err := os.Stat(b.dir); err != nil {
+		return fmt.Errorf("error accessing checks dir: %w", err)
+	}
+	return nil
+}
+
+// SetCheckVars replaces the vars available to the checks. It only affects to
+// the checks started after calling it.
+func (b *Exec) SetCheckVars(vars backend.CheckVars) {
+	b.varsMu.Lock()
+	defer b.varsMu.Unlock()
+	b.checkVars = vars
+}
+
+// output joins the stdout and the stderr of a check in the same way the
+// docker backend does with the logs of the containers.
+func output(stdout, stderr *bytes.Buffer) []byte {
+	return bytes.Join([][]byte{stdout.Bytes(), stderr.Bytes()}, []byte("\n"))
+}
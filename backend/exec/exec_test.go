@@ -0,0 +1,138 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package exec
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/google/go-cmp/cmp"
+)
+
+func writeCheck(t *testing.T, dir, name, script string) {
+	t.Helper()
+	err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExec_Run(t *testing.T) {
+	tests := []struct {
+		name       string
+		checks     map[string]string
+		params     backend.RunParams
+		timeout    time.Duration
+		want       string
+		wantErr    error
+		wantRunErr bool
+	}{
+		{
+			name: "InjectsTheEnv",
+			checks: map[string]string{
+				"vulcan-check": `echo "$VULCAN_CHECK_ID $VULCAN_CHECK_TARGET $VULCAN_AGENT_ADDRESS $VAR1 $VAR2"`,
+			},
+			params: backend.RunParams{
+				CheckID:       "id",
+				CheckTypeName: "adevinta/vulcan-check",
+				Target:        "example.com",
+				RequiredVars:  []string{"VAR1", "VAR2"},
+				Vars:          map[string]string{"VAR2": "run"},
+			},
+			want: "id example.com localhost:8080 static run\n\n",
+		},
+		{
+			name: "PrefersTheVersionedBinary",
+			checks: map[string]string{
+				"vulcan-check":    "echo latest",
+				"vulcan-check-v1": "echo v1",
+			},
+			params: backend.RunParams{
+				CheckID:          "id",
+				CheckTypeName:    "vulcan-check",
+				ChecktypeVersion: "v1",
+			},
+			want: "v1\n\n",
+		},
+		{
+			name: "ReturnsNonZeroExitCode",
+			checks: map[string]string{
+				"vulcan-check": "echo failed >&2\nexit 3",
+			},
+			params: backend.RunParams{
+				CheckID:       "id",
+				CheckTypeName: "vulcan-check",
+			},
+			want:    "\nfailed\n",
+			wantErr: backend.ErrNonZeroExitCode,
+		},
+		{
+			name: "StopsTheCheckWhenTheContextIsDone",
+			checks: map[string]string{
+				"vulcan-check": "trap 'echo stopped; exit 0' TERM\nwhile true; do sleep 0.1; done",
+			},
+			params: backend.RunParams{
+				CheckID:       "id",
+				CheckTypeName: "vulcan-check",
+				KillGrace:     5 * time.Second,
+			},
+			timeout: 500 * time.Millisecond,
+			want:    "stopped\n\n",
+			wantErr: context.DeadlineExceeded,
+		},
+		{
+			name: "FailsIfTheBinaryDoesNotExist",
+			params: backend.RunParams{
+				CheckID:       "id",
+				CheckTypeName: "vulcan-check",
+			},
+			wantRunErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, script := range tt.checks {
+				writeCheck(t, dir, name, script)
+			}
+			cfg := config.Config{
+				API:     config.APIConfig{Port: ":8080"},
+				Check:   config.CheckConfig{Vars: map[string]string{"VAR1": "static", "VAR2": "static"}},
+				Runtime: config.RuntimeConfig{Exec: config.ExecConfig{ChecksDir: dir}},
+			}
+			b, err := NewBackend(&log.NullLog{}, cfg)
+			if err != nil {
+				t.Fatalf("error creating backend: %v", err)
+			}
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			resC, err := b.Run(ctx, tt.params)
+			if (err != nil) != tt.wantRunErr {
+				t.Fatalf("want run error %v, got %v", tt.wantRunErr, err)
+			}
+			if err != nil {
+				return
+			}
+			res := <-resC
+			if !errors.Is(res.Error, tt.wantErr) {
+				t.Errorf("want error %v, got %v", tt.wantErr, res.Error)
+			}
+			if diff := cmp.Diff(tt.want, string(res.Output)); diff != "" {
+				t.Errorf("output mismatch (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
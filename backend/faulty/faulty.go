@@ -0,0 +1,121 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+// Package faulty implements a backend that injects failures in the checks
+// run by another backend, e.g. images that can't be pulled or checks that
+// never finish, to test how the agent copes with them. The failures are
+// selected randomly, with the probabilities configured for the checktype of
+// each check, so setting a probability to 1 makes all the checks of a
+// checktype fail in the same way.
+package faulty
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+)
+
+// DefaultSlowLogsDelay is the default time, in seconds, the logs of the
+// checks with slow logs are delayed.
+const DefaultSlowLogsDelay = config.DefaultFaultySlowLogsDelay
+
+// ErrPull is returned when a check fails to start because of an injected
+// pull error.
+var ErrPull = errors.New("injected error pulling image")
+
+// Backend decorates a backend.Backend injecting failures in the checks it
+// runs.
+type Backend struct {
+	backend.Backend
+	cfg config.FaultyConfig
+	log log.Logger
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// New returns a Backend that injects the failures defined in the given
+// config in the checks run by the given backend.
+func New(l log.Logger, b backend.Backend, cfg config.FaultyConfig) *Backend {
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &Backend{
+		Backend: b,
+		cfg:     cfg,
+		log:     l,
+		rand:    rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Run runs the check using the decorated backend, unless a pull error or a
+// hang is injected, and injects the exit code and slow logs failures in its
+// result.
+func (b *Backend) Run(ctx context.Context, params backend.RunParams) (<-chan backend.RunResult, error) {
+	faults := b.cfg.Default
+	if f, ok := b.cfg.Checktypes[params.CheckTypeName]; ok {
+		faults = f
+	}
+	if b.inject(faults.PullError) {
+		b.log.Infof("injecting pull error in check %s", params.CheckID)
+		return nil, fmt.Errorf("%w %s", ErrPull, params.Image)
+	}
+	if b.inject(faults.Hang) {
+		b.log.Infof("injecting hang in check %s", params.CheckID)
+		// The result of the check is never sent.
+		return make(chan backend.RunResult), nil
+	}
+	var exitCode int
+	if b.inject(faults.ExitCode) {
+		exitCode = 1 + b.intn(255)
+		b.log.Infof("injecting exit code %d in check %s", exitCode, params.CheckID)
+	}
+	var delay time.Duration
+	if b.inject(faults.SlowLogs) {
+		delay = time.Duration(faults.SlowLogsDelay) * time.Second
+		if delay <= 0 {
+			delay = DefaultSlowLogsDelay * time.Second
+		}
+		b.log.Infof("injecting slow logs, delayed %s, in check %s", delay, params.CheckID)
+	}
+	finished, err := b.Backend.Run(ctx, params)
+	if err != nil || (exitCode == 0 && delay == 0) {
+		return finished, err
+	}
+	res := make(chan backend.RunResult, 1)
+	go func() {
+		r := <-finished
+		if exitCode != 0 && r.Error == nil {
+			r.Error = fmt.Errorf("%w exit: %d", backend.ErrNonZeroExitCode, exitCode)
+		}
+		time.Sleep(delay)
+		res <- r
+	}()
+	return res, nil
+}
+
+// inject returns true, with the given probability, if a failure must be
+// injected.
+func (b *Backend) inject(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rand.Float64() < p
+}
+
+func (b *Backend) intn(n int) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rand.Intn(n)
+}
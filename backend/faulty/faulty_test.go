@@ -0,0 +1,132 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package faulty
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+)
+
+// okBackend runs the checks successfully, counting them.
+type okBackend struct {
+	runs int
+}
+
+func (b *okBackend) Run(ctx context.Context, params backend.RunParams) (<-chan backend.RunResult, error) {
+	b.runs++
+	res := make(chan backend.RunResult, 1)
+	res <- backend.RunResult{Output: []byte("output")}
+	return res, nil
+}
+
+func TestBackend_Run(t *testing.T) {
+	tests := []struct {
+		name         string
+		cfg          config.FaultyConfig
+		checktype    string
+		wantRunErr   error
+		wantHang     bool
+		wantExitCode bool
+		wantRuns     int
+	}{
+		{
+			name:      "NoFaults",
+			cfg:       config.FaultyConfig{Default: config.FaultsConfig{}},
+			checktype: "vulcan-nmap",
+			wantRuns:  1,
+		},
+		{
+			name:       "PullError",
+			cfg:        config.FaultyConfig{Default: config.FaultsConfig{PullError: 1}},
+			checktype:  "vulcan-nmap",
+			wantRunErr: ErrPull,
+		},
+		{
+			name:      "Hang",
+			cfg:       config.FaultyConfig{Default: config.FaultsConfig{Hang: 1}},
+			checktype: "vulcan-nmap",
+			wantHang:  true,
+		},
+		{
+			name:         "ExitCode",
+			cfg:          config.FaultyConfig{Default: config.FaultsConfig{ExitCode: 1}},
+			checktype:    "vulcan-nmap",
+			wantExitCode: true,
+			wantRuns:     1,
+		},
+		{
+			name: "Checktype",
+			cfg: config.FaultyConfig{
+				Default: config.FaultsConfig{PullError: 1},
+				Checktypes: map[string]config.FaultsConfig{
+					"vulcan-zap": {ExitCode: 1},
+				},
+			},
+			checktype:    "vulcan-zap",
+			wantExitCode: true,
+			wantRuns:     1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := &okBackend{}
+			b := New(&log.NullLog{}, inner, tt.cfg)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			finished, err := b.Run(ctx, backend.RunParams{CheckID: "check1", CheckTypeName: tt.checktype})
+			if !errors.Is(err, tt.wantRunErr) {
+				t.Fatalf("want error %v, got %v", tt.wantRunErr, err)
+			}
+			if inner.runs != tt.wantRuns {
+				t.Errorf("want %d runs of the backend, got %d", tt.wantRuns, inner.runs)
+			}
+			if err != nil {
+				return
+			}
+			cancel()
+			var res backend.RunResult
+			select {
+			case res = <-finished:
+			case <-time.After(100 * time.Millisecond):
+				if !tt.wantHang {
+					t.Fatalf("check hung")
+				}
+				return
+			}
+			if tt.wantHang {
+				t.Fatalf("check didn't hang")
+			}
+			code, ok := backend.ExitCode(res.Error)
+			if ok != tt.wantExitCode || (ok && (code < 1 || code > 255)) {
+				t.Errorf("unexpected exit code %d in error %v", code, res.Error)
+			}
+			if string(res.Output) != "output" {
+				t.Errorf("unexpected output %q", res.Output)
+			}
+		})
+	}
+}
+
+func TestBackend_Probability(t *testing.T) {
+	b := New(&log.NullLog{}, &okBackend{}, config.FaultyConfig{
+		Default: config.FaultsConfig{PullError: 0.3},
+		Seed:    1,
+	})
+	var errs int
+	for i := 0; i < 1000; i++ {
+		if _, err := b.Run(context.Background(), backend.RunParams{}); err != nil {
+			errs++
+		}
+	}
+	if errs < 250 || errs > 350 {
+		t.Errorf("got %d pull errors out of 1000 checks, want around 300", errs)
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package nomad
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/backend"
+)
+
+// job is the subset of the fields of a Nomad job used by the backend.
+type job struct {
+	ID            string         `json:"ID,omitempty"`
+	Name          string         `json:"Name,omitempty"`
+	Type          string         `json:"Type"`
+	Namespace     string         `json:"Namespace,omitempty"`
+	Region        string         `json:"Region,omitempty"`
+	Datacenters   []string       `json:"Datacenters"`
+	Parameterized *parameterized `json:"ParameterizedJob,omitempty"`
+	TaskGroups    []taskGroup    `json:"TaskGroups"`
+}
+
+type parameterized struct {
+	MetaRequired []string `json:"MetaRequired,omitempty"`
+}
+
+type taskGroup struct {
+	Name             string            `json:"Name"`
+	Count            int               `json:"Count"`
+	RestartPolicy    *restartPolicy    `json:"RestartPolicy,omitempty"`
+	ReschedulePolicy *reschedulePolicy `json:"ReschedulePolicy,omitempty"`
+	Tasks            []task            `json:"Tasks"`
+}
+
+type restartPolicy struct {
+	Attempts int    `json:"Attempts"`
+	Mode     string `json:"Mode"`
+}
+
+type reschedulePolicy struct {
+	Attempts int `json:"Attempts"`
+}
+
+type task struct {
+	Name        string                 `json:"Name"`
+	Driver      string                 `json:"Driver"`
+	Config      map[string]interface{} `json:"Config"`
+	Env         map[string]string      `json:"Env"`
+	Resources   resources              `json:"Resources"`
+	KillTimeout time.Duration          `json:"KillTimeout"`
+}
+
+type resources struct {
+	CPU      int `json:"CPU"`
+	MemoryMB int `json:"MemoryMB"`
+}
+
+// allocation is the subset of the fields of a Nomad allocation used by the
+// backend.
+type allocation struct {
+	ID           string               `json:"ID"`
+	ClientStatus string               `json:"ClientStatus"`
+	TaskStates   map[string]taskState `json:"TaskStates"`
+}
+
+type taskState struct {
+	State  string      `json:"State"`
+	Failed bool        `json:"Failed"`
+	Events []taskEvent `json:"Events"`
+}
+
+type taskEvent struct {
+	Type           string `json:"Type"`
+	ExitCode       int    `json:"ExitCode"`
+	DisplayMessage string `json:"DisplayMessage"`
+}
+
+// finished returns true if the allocation is not running anymore.
+func (a *allocation) finished() bool {
+	switch a.ClientStatus {
+	case allocComplete, allocFailed, allocLost:
+		return true
+	}
+	return false
+}
+
+// err returns the error of an allocation that finished, if any. The exit
+// codes different from 0 are reported as backend.ErrNonZeroExitCode, like
+// the docker backend does.
+func (a *allocation) err() error {
+	state := a.TaskStates[taskName]
+	for i := len(state.Events) - 1; i >= 0; i-- {
+		e := state.Events[i]
+		if e.Type != "Terminated" {
+			continue
+		}
+		if e.ExitCode != 0 {
+			return fmt.Errorf("%w exit: %d", backend.ErrNonZeroExitCode, e.ExitCode)
+		}
+		break
+	}
+	if a.ClientStatus == allocComplete {
+		return nil
+	}
+	msg := a.ClientStatus
+	if n := len(state.Events); n > 0 && state.Events[n-1].DisplayMessage != "" {
+		msg = state.Events[n-1].DisplayMessage
+	}
+	return fmt.Errorf("allocation %s of check failed: %s", a.ID, msg)
+}
@@ -0,0 +1,488 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+// Package nomad implements a backend that runs the checks in a HashiCorp
+// Nomad cluster. Each check is run by dispatching a parameterized batch job
+// that is registered, the first time it's needed, for the image of the check
+// and the names of the vars it receives. It drives Nomad through its HTTP
+// API.
+package nomad
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/docker/distribution/reference"
+)
+
+// Default values of the config of the backend.
+const (
+	DefaultAddress      = config.DefaultNomadAddress
+	DefaultDriver       = config.DefaultNomadDriver
+	DefaultCPU          = config.DefaultNomadCPU
+	DefaultMemory       = config.DefaultNomadMemory
+	DefaultPollInterval = config.DefaultNomadPollInterval
+)
+
+const (
+	abortTimeout = 5 * time.Second
+	// taskName is the name of the task group, and of its only task, of the
+	// jobs of the checks.
+	taskName = "check"
+	// jobPrefix is the prefix of the IDs of the parameterized jobs
+	// registered by the backend.
+	jobPrefix = "vulcan-check-"
+)
+
+// Statuses of the allocations that mean they finished.
+const (
+	allocComplete = "complete"
+	allocFailed   = "failed"
+	allocLost     = "lost"
+)
+
+// Nomad implements a backend that runs the checks as Nomad jobs.
+type Nomad struct {
+	cfg          config.NomadConfig
+	cli          *http.Client
+	registry     config.RegistryConfig
+	agentAddr    string
+	checkVars    backend.CheckVars
+	pollInterval time.Duration
+	// mu protects the check vars, the registry credentials and the jobs
+	// registered.
+	mu         sync.RWMutex
+	registered map[string]bool
+	log        log.Logger
+}
+
+func init() {
+	backend.Register(config.BackendNomad, func(l log.Logger, cfg config.Config) (backend.Backend, error) {
+		b, err := NewBackend(l, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return b, nil
+	})
+}
+
+// NewBackend creates a new Nomad backend using the given config. It checks
+// that the Nomad API is reachable before returning. The checks run in the
+// Nomad clients, so the api.host param, the address where they reach the
+// agent, is mandatory.
+func NewBackend(log log.Logger, cfg config.Config) (*Nomad, error) {
+	ncfg := cfg.Runtime.Nomad
+	if ncfg.Address == "" {
+		ncfg.Address = DefaultAddress
+	}
+	if ncfg.Driver == "" {
+		ncfg.Driver = DefaultDriver
+	}
+	if ncfg.CPU == 0 {
+		ncfg.CPU = DefaultCPU
+	}
+	if ncfg.Memory == 0 {
+		ncfg.Memory = DefaultMemory
+	}
+	if ncfg.PollInterval == 0 {
+		ncfg.PollInterval = DefaultPollInterval
+	}
+	if len(ncfg.Datacenters) == 0 {
+		ncfg.Datacenters = []string{"*"}
+	}
+	if cfg.API.Host == "" {
+		return nil, errors.New("the nomad backend requires the api.host param")
+	}
+	checkVars, err := backend.NewCheckVars(cfg.Check)
+	if err != nil {
+		return nil, err
+	}
+	cli, err := newClient(ncfg)
+	if err != nil {
+		return nil, err
+	}
+	b := &Nomad{
+		cfg:          ncfg,
+		cli:          cli,
+		registry:     cfg.Runtime.Docker.Registry,
+		agentAddr:    cfg.API.Host + cfg.API.Port,
+		checkVars:    checkVars,
+		pollInterval: time.Duration(ncfg.PollInterval) * time.Second,
+		registered:   make(map[string]bool),
+		log:          log,
+	}
+	if err := b.Ping(context.Background()); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// newClient returns the http client used to call the Nomad API, configured
+// with the certificates defined in the config, if any.
+func newClient(cfg config.NomadConfig) (*http.Client, error) {
+	if cfg.CACert == "" && cfg.ClientCert == "" {
+		return &http.Client{}, nil
+	}
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CACert != "" {
+		pem, err := ioutil.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("error reading nomad CA cert: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("invalid nomad CA cert %s", cfg.CACert)
+		}
+		tlsCfg.RootCAs = pool
+	}
+	if cfg.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("error loading nomad client cert: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsCfg}}, nil
+}
+
+// Run dispatches a job that runs the check and returns a channel that will
+// contain the result of the execution when it finishes.
+func (b *Nomad) Run(ctx context.Context, params backend.RunParams) (<-chan backend.RunResult, error) {
+	named, err := reference.ParseNormalizedNamed(params.Image)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image %s: %w", params.Image, err)
+	}
+	b.mu.RLock()
+	if err := backend.CheckRequiredVars(params, b.checkVars); err != nil {
+		b.mu.RUnlock()
+		return nil, err
+	}
+	env := backend.CheckEnv(params, b.agentAddr, b.checkVars)
+	auth := b.registryAuth(reference.Domain(named))
+	b.mu.RUnlock()
+	meta := make(map[string]string)
+	for _, e := range env {
+		parts := strings.SplitN(e, "=", 2)
+		meta[parts[0]] = parts[1]
+	}
+	job := b.job(params, meta, auth)
+	if err := b.register(ctx, job); err != nil {
+		return nil, err
+	}
+	var resp struct {
+		DispatchedJobID string
+	}
+	path := "/v1/job/" + url.PathEscape(job.ID) + "/dispatch"
+	err = b.do(ctx, http.MethodPost, path, nil, map[string]interface{}{"Meta": meta}, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("error dispatching job for check %s: %w", params.CheckID, err)
+	}
+	b.log.Infof("check %s dispatched as nomad job %s", params.CheckID, resp.DispatchedJobID)
+	res := make(chan backend.RunResult)
+	go b.run(ctx, resp.DispatchedJobID, params, res)
+	return res, nil
+}
+
+func (b *Nomad) run(ctx context.Context, jobID string, params backend.RunParams, res chan<- backend.RunResult) {
+	r := b.result(ctx, jobID, params)
+	b.purge(jobID)
+	res <- r
+}
+
+// result waits for the dispatched job of a check to finish and returns the
+// result of the check. If the context is done before, the job is stopped.
+func (b *Nomad) result(ctx context.Context, jobID string, params backend.RunParams) backend.RunResult {
+	a, err := b.wait(ctx, jobID)
+	if ctx.Err() != nil {
+		b.log.Infof("check: %s timeout or aborted ensure job is stopped", params.CheckID)
+		timeout := abortTimeout
+		if params.KillGrace > 0 {
+			timeout += params.KillGrace
+		}
+		stopCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := b.do(stopCtx, http.MethodDelete, "/v1/job/"+url.PathEscape(jobID), nil, nil, nil); err != nil {
+			b.log.Errorf("error stopping job of check %s: %+v", params.CheckID, err)
+		}
+		a, _ = b.wait(stopCtx, jobID)
+		return backend.RunResult{Output: b.output(a), Error: ctx.Err()}
+	}
+	if err != nil {
+		return backend.RunResult{Error: fmt.Errorf("error waiting for job of check %s: %w", params.CheckID, err)}
+	}
+	return backend.RunResult{Output: b.output(a), Error: a.err()}
+}
+
+// wait waits until the allocation of the given job finishes and returns it.
+// If the context is done before, it returns the last allocation seen, if
+// any.
+func (b *Nomad) wait(ctx context.Context, jobID string) (*allocation, error) {
+	ticker := time.NewTicker(b.pollInterval)
+	defer ticker.Stop()
+	var last *allocation
+	for {
+		var allocs []allocation
+		err := b.do(ctx, http.MethodGet, "/v1/job/"+url.PathEscape(jobID)+"/allocations", nil, nil, &allocs)
+		if err != nil && ctx.Err() == nil {
+			return nil, err
+		}
+		for i := range allocs {
+			last = &allocs[i]
+			if last.finished() {
+				return last, nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return last, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// purge removes a dispatched job that finished.
+func (b *Nomad) purge(jobID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), abortTimeout)
+	defer cancel()
+	q := url.Values{"purge": []string{"true"}}
+	if err := b.do(ctx, http.MethodDelete, "/v1/job/"+url.PathEscape(jobID), q, nil, nil); err != nil {
+		b.log.Errorf("error purging nomad job %s: %+v", jobID, err)
+	}
+}
+
+// output returns the stdout followed by the stderr of the task of the given
+// allocation, in the same way the docker backend does with the logs of the
+// containers.
+func (b *Nomad) output(a *allocation) []byte {
+	if a == nil {
+		return nil
+	}
+	var logs [][]byte
+	for _, typ := range []string{"stdout", "stderr"} {
+		ctx, cancel := context.WithTimeout(context.Background(), abortTimeout)
+		q := url.Values{
+			"task":   []string{taskName},
+			"type":   []string{typ},
+			"origin": []string{"start"},
+			"offset": []string{"0"},
+			"plain":  []string{"true"},
+		}
+		out, err := b.raw(ctx, http.MethodGet, "/v1/client/fs/logs/"+url.PathEscape(a.ID), q)
+		cancel()
+		if err != nil {
+			b.log.Errorf("error getting %s of allocation %s: %+v", typ, a.ID, err)
+		}
+		logs = append(logs, out)
+	}
+	return bytes.Join(logs, []byte("\n"))
+}
+
+// job returns the parameterized job that runs the checks with the given
+// params. The ID of the job depends on its definition, so a new job is
+// registered when, for instance, the image of the checks changes.
+func (b *Nomad) job(params backend.RunParams, meta map[string]string, auth *config.Auth) *job {
+	var names []string
+	env := make(map[string]string)
+	for k := range meta {
+		names = append(names, k)
+		env[k] = "${NOMAD_META_" + k + "}"
+	}
+	sort.Strings(names)
+	drvCfg := map[string]interface{}{
+		"image":      params.Image,
+		"force_pull": b.registry.PullPolicy == config.PullPolicyAlways,
+	}
+	if auth != nil {
+		drvCfg["auth"] = map[string]string{"username": auth.User, "password": auth.Pass}
+	}
+	killTimeout := abortTimeout
+	if params.KillGrace > 0 {
+		killTimeout = params.KillGrace
+	}
+	j := &job{
+		Type:          "batch",
+		Namespace:     b.cfg.Namespace,
+		Region:        b.cfg.Region,
+		Datacenters:   b.cfg.Datacenters,
+		Parameterized: &parameterized{MetaRequired: names},
+		TaskGroups: []taskGroup{{
+			Name:             taskName,
+			Count:            1,
+			RestartPolicy:    &restartPolicy{Attempts: 0, Mode: "fail"},
+			ReschedulePolicy: &reschedulePolicy{Attempts: 0},
+			Tasks: []task{{
+				Name:        taskName,
+				Driver:      b.cfg.Driver,
+				Config:      drvCfg,
+				Env:         env,
+				Resources:   resources{CPU: b.cfg.CPU, MemoryMB: b.cfg.Memory},
+				KillTimeout: killTimeout,
+			}},
+		}},
+	}
+	spec, _ := json.Marshal(j)
+	sum := sha256.Sum256(spec)
+	name := "check"
+	if checktype, _, err := backend.ChecktypeInfo(params.Image); err == nil {
+		name = checktype[strings.LastIndex(checktype, "/")+1:]
+	}
+	j.ID = jobPrefix + name + "-" + hex.EncodeToString(sum[:])[:12]
+	j.Name = j.ID
+	return j
+}
+
+// register registers the given job if it was not registered before by the
+// backend.
+func (b *Nomad) register(ctx context.Context, j *job) error {
+	b.mu.RLock()
+	done := b.registered[j.ID]
+	b.mu.RUnlock()
+	if done {
+		return nil
+	}
+	err := b.do(ctx, http.MethodPost, "/v1/jobs", nil, map[string]interface{}{"Job": j}, nil)
+	if err != nil {
+		return fmt.Errorf("error registering nomad job %s: %w", j.ID, err)
+	}
+	b.mu.Lock()
+	b.registered[j.ID] = true
+	b.mu.Unlock()
+	return nil
+}
+
+// registryAuth returns the credentials configured for the given registry
+// domain, if any. It must be called with the mu held.
+func (b *Nomad) registryAuth(domain string) *config.Auth {
+	auths := append([]config.Auth{}, b.registry.Auths...)
+	if b.registry.Server != "" {
+		auths = append(auths, config.Auth{
+			Server: b.registry.Server,
+			User:   b.registry.User,
+			Pass:   b.registry.Pass,
+		})
+	}
+	for _, a := range auths {
+		server := strings.TrimPrefix(strings.TrimPrefix(a.Server, "https://"), "http://")
+		server = strings.TrimSuffix(server, "/")
+		if server == domain {
+			return &a
+		}
+	}
+	return nil
+}
+
+// Ping checks that the Nomad API is reachable.
+func (b *Nomad) Ping(ctx context.Context) error {
+	if _, err := b.raw(ctx, http.MethodGet, "/v1/status/leader", nil); err != nil {
+		return fmt.Errorf("error pinging nomad: %w", err)
+	}
+	return nil
+}
+
+// SetCheckVars replaces the vars available to the checks. It only affects to
+// the checks started after calling it.
+func (b *Nomad) SetCheckVars(vars backend.CheckVars) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.checkVars = vars
+}
+
+// SetRegistryAuths replaces the credentials of the registries used to pull
+// the images. Contrary to the docker backend, the credentials are not
+// validated until they are used.
+func (b *Nomad) SetRegistryAuths(cfg config.RegistryConfig) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.registry.Auths = cfg.Auths
+	b.registry.Server = cfg.Server
+	b.registry.User = cfg.User
+	b.registry.Pass = cfg.Pass
+	return nil
+}
+
+// do calls the Nomad API sending the given input, if not nil, encoded in
+// json, and decoding the response in out, if not nil.
+func (b *Nomad) do(ctx context.Context, method, path string, q url.Values, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		payload, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(payload)
+	}
+	resp, err := b.send(ctx, method, path, q, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// raw calls the Nomad API and returns the body of the response.
+func (b *Nomad) raw(ctx context.Context, method, path string, q url.Values) ([]byte, error) {
+	resp, err := b.send(ctx, method, path, q, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (b *Nomad) send(ctx context.Context, method, path string, q url.Values, body io.Reader) (*http.Response, error) {
+	if q == nil {
+		q = url.Values{}
+	}
+	if b.cfg.Namespace != "" {
+		q.Set("namespace", b.cfg.Namespace)
+	}
+	if b.cfg.Region != "" {
+		q.Set("region", b.cfg.Region)
+	}
+	u := strings.TrimSuffix(b.cfg.Address, "/") + path
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if b.cfg.Token != "" {
+		req.Header.Set("X-Nomad-Token", b.cfg.Token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := b.cli.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: unexpected status %d: %s", method, path, resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return resp, nil
+}
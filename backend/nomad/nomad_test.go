@@ -0,0 +1,239 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package nomad
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeNomad implements the endpoints of the Nomad API used by the backend.
+// The dispatched jobs finish with the allocation defined by the EXIT meta,
+// or keep running until they are stopped if it's "wait".
+type fakeNomad struct {
+	mu         sync.Mutex
+	registered []job
+	dispatched map[string]map[string]string
+	stopped    []string
+	purged     []string
+}
+
+func (f *fakeNomad) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p := r.URL.Path
+	switch {
+	case p == "/v1/status/leader":
+		w.Write([]byte(`"127.0.0.1:4647"`))
+	case p == "/v1/jobs" && r.Method == http.MethodPost:
+		var req struct{ Job job }
+		json.NewDecoder(r.Body).Decode(&req)
+		f.registered = append(f.registered, req.Job)
+	case strings.HasSuffix(p, "/dispatch"):
+		var req struct{ Meta map[string]string }
+		json.NewDecoder(r.Body).Decode(&req)
+		id := strings.TrimSuffix(strings.TrimPrefix(p, "/v1/job/"), "/dispatch") + "/dispatch-" + req.Meta[backend.CheckIDVar]
+		f.dispatched[id] = req.Meta
+		json.NewEncoder(w).Encode(map[string]string{"DispatchedJobID": id})
+	case strings.HasSuffix(p, "/allocations"):
+		id := strings.TrimSuffix(strings.TrimPrefix(p, "/v1/job/"), "/allocations")
+		json.NewEncoder(w).Encode([]allocation{f.alloc(id)})
+	case strings.HasPrefix(p, "/v1/client/fs/logs/"):
+		id := strings.TrimPrefix(p, "/v1/client/fs/logs/")
+		if r.URL.Query().Get("type") == "stdout" {
+			w.Write([]byte("target " + f.dispatched[id][backend.CheckTargetVar]))
+		} else {
+			w.Write([]byte("stderr"))
+		}
+	case strings.HasPrefix(p, "/v1/job/") && r.Method == http.MethodDelete:
+		id := strings.TrimPrefix(p, "/v1/job/")
+		if r.URL.Query().Get("purge") == "true" {
+			f.purged = append(f.purged, id)
+		} else {
+			f.stopped = append(f.stopped, id)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// alloc returns the allocation of a dispatched job. The ID of the
+// allocation is the ID of the job.
+func (f *fakeNomad) alloc(jobID string) allocation {
+	a := allocation{ID: jobID, ClientStatus: "running"}
+	code := f.dispatched[jobID]["EXIT"]
+	switch {
+	case code == "wait":
+		for _, s := range f.stopped {
+			if s == jobID {
+				a.ClientStatus = allocComplete
+				a.TaskStates = map[string]taskState{taskName: {Events: []taskEvent{{Type: "Terminated", ExitCode: 143}}}}
+			}
+		}
+	case code == "0":
+		a.ClientStatus = allocComplete
+		a.TaskStates = map[string]taskState{taskName: {Events: []taskEvent{{Type: "Terminated"}}}}
+	case code == "pull":
+		a.ClientStatus = allocFailed
+		a.TaskStates = map[string]taskState{taskName: {Events: []taskEvent{{Type: "Driver Failure", DisplayMessage: "image not found"}}}}
+	default:
+		a.ClientStatus = allocFailed
+		a.TaskStates = map[string]taskState{taskName: {Events: []taskEvent{{Type: "Terminated", ExitCode: 1}}}}
+	}
+	return a
+}
+
+func TestNomad_Run(t *testing.T) {
+	tests := []struct {
+		name       string
+		exit       string
+		timeout    time.Duration
+		wantOutput string
+		wantErr    error
+		wantMsg    string
+		wantStop   bool
+	}{
+		{
+			name:       "Finished",
+			exit:       "0",
+			wantOutput: "target example.com\nstderr",
+		},
+		{
+			name:       "NonZeroExit",
+			exit:       "1",
+			wantOutput: "target example.com\nstderr",
+			wantErr:    backend.ErrNonZeroExitCode,
+		},
+		{
+			name:       "DriverFailure",
+			exit:       "pull",
+			wantOutput: "target example.com\nstderr",
+			wantMsg:    "image not found",
+		},
+		{
+			name:       "Timeout",
+			exit:       "wait",
+			timeout:    50 * time.Millisecond,
+			wantOutput: "target example.com\nstderr",
+			wantErr:    context.DeadlineExceeded,
+			wantStop:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeNomad{dispatched: make(map[string]map[string]string)}
+			srv := httptest.NewServer(f)
+			defer srv.Close()
+			cfg := config.Config{
+				API:   config.APIConfig{Host: "agent", Port: ":8080"},
+				Check: config.CheckConfig{Vars: map[string]string{"EXIT": tt.exit}},
+			}
+			cfg.Runtime.Nomad.Address = srv.URL
+			b, err := NewBackend(&log.NullLog{}, cfg)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			b.pollInterval = 10 * time.Millisecond
+
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			params := backend.RunParams{
+				CheckID:      "check1",
+				Image:        "adevinta/vulcan-exposed-http:1",
+				Target:       "example.com",
+				RequiredVars: []string{"EXIT"},
+			}
+			res, err := b.Run(ctx, params)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := <-res
+			if string(got.Output) != tt.wantOutput {
+				t.Errorf("want output %q, got %q", tt.wantOutput, got.Output)
+			}
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(got.Error, tt.wantErr) {
+					t.Errorf("want error %v, got %v", tt.wantErr, got.Error)
+				}
+			case tt.wantMsg != "":
+				if got.Error == nil || !strings.Contains(got.Error.Error(), tt.wantMsg) {
+					t.Errorf("want error containing %q, got %v", tt.wantMsg, got.Error)
+				}
+			case got.Error != nil:
+				t.Errorf("unexpected error: %v", got.Error)
+			}
+
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			if len(f.registered) != 1 {
+				t.Fatalf("want 1 job registered, got %d", len(f.registered))
+			}
+			j := f.registered[0]
+			if !strings.HasPrefix(j.ID, jobPrefix+"vulcan-exposed-http-") {
+				t.Errorf("unexpected job ID %s", j.ID)
+			}
+			tk := j.TaskGroups[0].Tasks[0]
+			if tk.Config["image"] != params.Image || tk.Env["EXIT"] != "${NOMAD_META_EXIT}" {
+				t.Errorf("unexpected task %+v", tk)
+			}
+			dispatched := j.ID + "/dispatch-check1"
+			if f.dispatched[dispatched][backend.AgentAddressVar] != "agent:8080" {
+				t.Errorf("unexpected meta %+v", f.dispatched[dispatched])
+			}
+			var wantStopped []string
+			if tt.wantStop {
+				wantStopped = []string{dispatched}
+			}
+			if diff := cmp.Diff(wantStopped, f.stopped); diff != "" {
+				t.Errorf("stopped jobs mismatch (-want +got):\n%v", diff)
+			}
+			if diff := cmp.Diff([]string{dispatched}, f.purged); diff != "" {
+				t.Errorf("purged jobs mismatch (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestNomad_RegistersOnce(t *testing.T) {
+	f := &fakeNomad{dispatched: make(map[string]map[string]string)}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	cfg := config.Config{API: config.APIConfig{Host: "agent", Port: ":8080"}}
+	cfg.Runtime.Nomad.Address = srv.URL
+	b, err := NewBackend(&log.NullLog{}, cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, id := range []string{"check1", "check2"} {
+		if _, err := b.Run(context.Background(), backend.RunParams{CheckID: id, Image: "check:1"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, err := b.Run(context.Background(), backend.RunParams{CheckID: "check3", Image: "check:2"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.registered) != 2 {
+		t.Errorf("want 2 jobs registered, got %d", len(f.registered))
+	}
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package backend
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+)
+
+// Factory creates a Backend from the config of the agent.
+type Factory func(l log.Logger, cfg config.Config) (Backend, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes a backend available by the provided name, so it can be
+// selected with the runtime.backend config param. It's intended to be called
+// from the init function of the packages implementing the backends. If
+// Register is called twice with the same name or if the factory is nil, it
+// panics.
+func Register(name string, f Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if f == nil {
+		panic("backend: register factory is nil")
+	}
+	if _, dup := factories[name]; dup {
+		panic("backend: register called twice for backend " + name)
+	}
+	factories[name] = f
+}
+
+// Names returns the sorted list of the registered backends.
+func Names() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates the backend with the given name using the factory registered
+// for it.
+func New(name string, l log.Logger, cfg config.Config) (Backend, error) {
+	factoriesMu.RLock()
+	f, ok := factories[name]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown backend %q, available backends: %s", name, strings.Join(Names(), ", "))
+	}
+	return f(l, cfg)
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+)
+
+type backendMock struct {
+	cfg config.Config
+}
+
+func (b *backendMock) Run(ctx context.Context, params RunParams) (<-chan RunResult, error) {
+	return nil, nil
+}
+
+func TestNew(t *testing.T) {
+	errFactory := errors.New("factory error")
+	Register("test", func(l log.Logger, cfg config.Config) (Backend, error) {
+		return &backendMock{cfg: cfg}, nil
+	})
+	Register("test-error", func(l log.Logger, cfg config.Config) (Backend, error) {
+		return nil, errFactory
+	})
+	cfg := config.Config{Runtime: config.RuntimeConfig{Backend: "test"}}
+	b, err := New("test", &log.NullLog{}, cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m, ok := b.(*backendMock); !ok || m.cfg.Runtime.Backend != "test" {
+		t.Errorf("backend not created by the registered factory: %#v", b)
+	}
+	if _, err := New("test-error", &log.NullLog{}, cfg); !errors.Is(err, errFactory) {
+		t.Errorf("want error %v, got %v", errFactory, err)
+	}
+	if _, err := New("unknown", &log.NullLog{}, cfg); err == nil {
+		t.Errorf("want error creating an unknown backend")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("registering a backend twice must panic")
+		}
+	}()
+	Register("test", func(l log.Logger, cfg config.Config) (Backend, error) {
+		return nil, nil
+	})
+}
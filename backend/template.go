@@ -0,0 +1,122 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"text/template"
+)
+
+// maxEnvTemplateSize is the max size of the value of a var rendered from a
+// template, so a template can't produce a huge environment for a job.
+const maxEnvTemplateSize = 4096
+
+var errEnvTemplateTooBig = errors.New("value too big")
+
+// EnvTemplateData contains the fields of a job available to the env
+// templates. It doesn't include the vars of the job, so the templates can't
+// copy credentials to other vars.
+type EnvTemplateData struct {
+	CheckID          string
+	ScanID           string
+	CheckTypeName    string
+	ChecktypeVersion string
+	Image            string
+	Target           string
+	AssetType        string
+	Metadata         map[string]string
+}
+
+// EnvTemplates contains the templates of the vars injected in all the checks
+// whose values depend on the job, e.g.: TEAM = "{{.Metadata.team}}". The
+// templates use the text/template syntax with an EnvTemplateData as data.
+// The metadata keys that are not defined in a job are rendered as empty
+// strings.
+type EnvTemplates map[string]*template.Template
+
+// NewEnvTemplates parses the given templates, indexed by the name of their
+// vars. The templates that reference fields not defined in the
+// EnvTemplateData are invalid.
+func NewEnvTemplates(templates map[string]string) (EnvTemplates, error) {
+	if len(templates) == 0 {
+		return nil, nil
+	}
+	tmpls := make(EnvTemplates, len(templates))
+	for name, text := range templates {
+		tmpl, err := template.New(name).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid env template %s: %w", name, err)
+		}
+		// The references to unknown fields are only detected when the
+		// template is executed.
+		data := EnvTemplateData{Metadata: map[string]string{}}
+		if _, err := render(tmpl, data); err != nil && !errors.Is(err, errEnvTemplateTooBig) {
+			return nil, fmt.Errorf("invalid env template %s: %w", name, err)
+		}
+		tmpls[name] = tmpl
+	}
+	return tmpls, nil
+}
+
+// Env returns the vars, sorted by name, rendered from the templates for the
+// given run. The vars with the given reserved names, and the ones whose
+// templates fail or render a value bigger than 4KB for the run, are not
+// returned.
+func (t EnvTemplates) Env(params RunParams, reserved map[string]bool) []string {
+	if len(t) == 0 {
+		return nil
+	}
+	data := EnvTemplateData{
+		CheckID:          params.CheckID,
+		ScanID:           params.ScanID,
+		CheckTypeName:    params.CheckTypeName,
+		ChecktypeVersion: params.ChecktypeVersion,
+		Image:            params.Image,
+		Target:           params.Target,
+		AssetType:        params.AssetType,
+		Metadata:         params.Metadata,
+	}
+	names := make([]string, 0, len(t))
+	for name := range t {
+		if !reserved[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	env := make([]string, 0, len(names))
+	for _, name := range names {
+		value, err := render(t[name], data)
+		if err != nil {
+			continue
+		}
+		env = append(env, fmt.Sprintf("%s=%s", name, value))
+	}
+	return env
+}
+
+func render(tmpl *template.Template, data EnvTemplateData) (string, error) {
+	w := &limitedBuffer{max: maxEnvTemplateSize}
+	if err := tmpl.Execute(w, data); err != nil {
+		return "", err
+	}
+	return w.String(), nil
+}
+
+// limitedBuffer is a buffer that fails when more than max bytes are written
+// to it.
+type limitedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.max {
+		return 0, errEnvTemplateTooBig
+	}
+	return b.Buffer.Write(p)
+}
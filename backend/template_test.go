@@ -0,0 +1,90 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package backend
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNewEnvTemplates(t *testing.T) {
+	tests := []struct {
+		name      string
+		templates map[string]string
+		wantErr   bool
+	}{
+		{
+			name: "Valid",
+			templates: map[string]string{
+				"TEAM":   "{{.Metadata.team}}",
+				"SCAN":   "{{.ScanID}}-{{.CheckTypeName}}",
+				"REGION": `{{index .Metadata "region"}}`,
+			},
+		},
+		{
+			name:      "InvalidSyntax",
+			templates: map[string]string{"TEAM": "{{.Metadata.team"},
+			wantErr:   true,
+		},
+		{
+			name:      "UnknownField",
+			templates: map[string]string{"TEAM": "{{.Team}}"},
+			wantErr:   true,
+		},
+		{
+			name:      "Vars",
+			templates: map[string]string{"TOKEN": "{{.Vars.TOKEN}}"},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewEnvTemplates(tt.templates)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckVars_TemplateEnv(t *testing.T) {
+	tmpls, err := NewEnvTemplates(map[string]string{
+		"TEAM":              "{{.Metadata.team}}",
+		"REGION":            "{{.Metadata.region}}",
+		"SCAN":              "{{.ScanID}}/{{.Target}}",
+		"TOKEN":             "{{.CheckID}}",
+		CheckTargetVar:      "overridden",
+		"BIG":               `{{printf "%5000s" .Target}}`,
+		"VULCAN_SCAN_OWNER": `{{with .Metadata.owner}}{{.}}{{else}}unknown{{end}}`,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	checkVars := CheckVars{Templates: tmpls}
+	params := RunParams{
+		CheckID:      "check1",
+		ScanID:       "scan1",
+		Target:       "example.com",
+		RequiredVars: []string{"TOKEN"},
+		Metadata:     map[string]string{"team": "security"},
+	}
+	want := []string{
+		"REGION=",
+		"SCAN=scan1/example.com",
+		"TEAM=security",
+		"VULCAN_SCAN_OWNER=unknown",
+	}
+	if diff := cmp.Diff(want, checkVars.TemplateEnv(params)); diff != "" {
+		t.Errorf("env mismatch (-want +got):\n%v", diff)
+	}
+	// The templates are rendered before the required vars.
+	env := CheckEnv(params, "", checkVars)
+	got := strings.Join(env[len(env)-len(want)-1:], " ")
+	if wantEnv := strings.Join(append(want, "TOKEN="), " "); got != wantEnv {
+		t.Errorf("got env %s, want %s", got, wantEnv)
+	}
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+// Package cloudwatch periodically publishes the metrics of the agent to AWS
+// CloudWatch, so the groups of agents can be scaled on the checks they run
+// instead of on their CPU usage.
+package cloudwatch
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/awscreds"
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/aws/aws-sdk-go/aws"
+	awscw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+)
+
+const finalTimeout = 10 * time.Second
+
+// Names of the metrics published.
+const (
+	MetricCapacity         = "Capacity"
+	MetricChecksRunning    = "ChecksRunning"
+	MetricTokenUtilization = "TokenUtilization"
+	MetricMessagesInFlight = "MessagesInFlight"
+	MetricMessagesReceived = "MessagesReceived"
+	MetricChecksCompleted  = "ChecksCompleted"
+	MetricDuplicateJobs    = "DuplicateJobs"
+)
+
+// Stats contains the current state of the agent.
+type Stats struct {
+	Capacity      int
+	ChecksRunning int
+	// MessagesInFlight is the number of messages being processed, or -1 if
+	// the queue reader doesn't report it.
+	MessagesInFlight int
+}
+
+// Publisher publishes periodically the metrics of the agent to CloudWatch:
+// the current state of the agent returned by the Stats func, and the number
+// of messages received, duplicated jobs and checks completed, by status, since
+// the last publication.
+type Publisher struct {
+	cw        cloudwatchiface.CloudWatchAPI
+	namespace string
+	dims      []*awscw.Dimension
+	interval  time.Duration
+	log       log.Logger
+	// Stats is called to get the state of the agent published.
+	Stats func() Stats
+
+	mu         sync.Mutex
+	received   int
+	duplicates int
+	completed  map[string]int
+}
+
+// NewPublisher returns a Publisher that publishes the metrics in the
+// namespace and with the dimensions defined in the config.
+func NewPublisher(l log.Logger, cfg config.CloudWatchConfig) (*Publisher, error) {
+	sess, err := awscreds.NewSession(cfg.Credentials)
+	if err != nil {
+		return nil, fmt.Errorf("error creating AWS session: %w", err)
+	}
+	awsCfg := aws.NewConfig()
+	if cfg.Region != "" {
+		awsCfg = awsCfg.WithRegion(cfg.Region)
+	}
+	if cfg.Endpoint != "" {
+		awsCfg = awsCfg.WithEndpoint(cfg.Endpoint)
+	}
+	return newPublisher(l, awscw.New(sess, awsCfg), cfg), nil
+}
+
+func newPublisher(l log.Logger, cw cloudwatchiface.CloudWatchAPI, cfg config.CloudWatchConfig) *Publisher {
+	interval := time.Duration(cfg.Interval) * time.Second
+	if interval <= 0 {
+		interval = config.DefaultCloudWatchInterval * time.Second
+	}
+	namespace := cfg.Namespace
+	if namespace == "" {
+		namespace = config.DefaultCloudWatchNamespace
+	}
+	var dims []*awscw.Dimension
+	for k, v := range cfg.Dimensions {
+		dims = append(dims, &awscw.Dimension{Name: aws.String(k), Value: aws.String(v)})
+	}
+	sort.Slice(dims, func(i, j int) bool {
+		return *dims[i].Name < *dims[j].Name
+	})
+	return &Publisher{
+		cw:        cw,
+		namespace: namespace,
+		dims:      dims,
+		interval:  interval,
+		log:       l,
+		completed: make(map[string]int),
+	}
+}
+
+// MessageReceived counts a message received from the queue.
+func (p *Publisher) MessageReceived() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.received++
+}
+
+// DuplicateJob counts a job received for a check that was already being
+// processed.
+func (p *Publisher) DuplicateJob() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.duplicates++
+}
+
+// CheckCompleted counts a check that finished with the given status.
+func (p *Publisher) CheckCompleted(status string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.completed[status]++
+}
+
+// Start publishes the metrics every interval until the context is canceled.
+// Then it publishes them a last time and writes to the returned channel.
+func (p *Publisher) Start(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				ctx, cancel := context.WithTimeout(context.Background(), finalTimeout)
+				p.publish(ctx)
+				cancel()
+				return
+			case <-ticker.C:
+				p.publish(ctx)
+			}
+		}
+	}()
+	return done
+}
+
+func (p *Publisher) publish(ctx context.Context) {
+	_, err := p.cw.PutMetricDataWithContext(ctx, &awscw.PutMetricDataInput{
+		Namespace:  aws.String(p.namespace),
+		MetricData: p.datums(time.Now()),
+	})
+	if err != nil {
+		p.log.Errorf("error publishing metrics to CloudWatch: %+v", err)
+	}
+}
+
+// datums returns the metrics to publish and resets the counters.
+func (p *Publisher) datums(now time.Time) []*awscw.MetricDatum {
+	var s Stats
+	if p.Stats != nil {
+		s = p.Stats()
+	}
+	p.mu.Lock()
+	received := p.received
+	duplicates := p.duplicates
+	completed := p.completed
+	p.received = 0
+	p.duplicates = 0
+	p.completed = make(map[string]int)
+	p.mu.Unlock()
+
+	datums := []*awscw.MetricDatum{
+		p.datum(MetricCapacity, float64(s.Capacity), awscw.StandardUnitCount, now),
+		p.datum(MetricChecksRunning, float64(s.ChecksRunning), awscw.StandardUnitCount, now),
+		p.datum(MetricMessagesReceived, float64(received), awscw.StandardUnitCount, now),
+		p.datum(MetricDuplicateJobs, float64(duplicates), awscw.StandardUnitCount, now),
+	}
+	if s.Capacity > 0 {
+		utilization := 100 * float64(s.ChecksRunning) / float64(s.Capacity)
+		datums = append(datums, p.datum(MetricTokenUtilization, utilization, awscw.StandardUnitPercent, now))
+	}
+	if s.MessagesInFlight >= 0 {
+		datums = append(datums, p.datum(MetricMessagesInFlight, float64(s.MessagesInFlight), awscw.StandardUnitCount, now))
+	}
+	statuses := make([]string, 0, len(completed))
+	for status := range completed {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	for _, status := range statuses {
+		d := p.datum(MetricChecksCompleted, float64(completed[status]), awscw.StandardUnitCount, now)
+		d.Dimensions = append(d.Dimensions, &awscw.Dimension{Name: aws.String("Status"), Value: aws.String(status)})
+		datums = append(datums, d)
+	}
+	return datums
+}
+
+func (p *Publisher) datum(name string, value float64, unit string, now time.Time) *awscw.MetricDatum {
+	dims := make([]*awscw.Dimension, len(p.dims))
+	copy(dims, p.dims)
+	return &awscw.MetricDatum{
+		MetricName: aws.String(name),
+		Value:      aws.Float64(value),
+		Unit:       aws.String(unit),
+		Timestamp:  aws.Time(now),
+		Dimensions: dims,
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package cloudwatch
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	awscw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/google/go-cmp/cmp"
+)
+
+type cloudWatchMock struct {
+	cloudwatchiface.CloudWatchAPI
+	mu     sync.Mutex
+	inputs []*awscw.PutMetricDataInput
+}
+
+func (m *cloudWatchMock) PutMetricDataWithContext(ctx context.Context, in *awscw.PutMetricDataInput, opts ...request.Option) (*awscw.PutMetricDataOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inputs = append(m.inputs, in)
+	return &awscw.PutMetricDataOutput{}, nil
+}
+
+// metric is the relevant information of a datum.
+type metric struct {
+	Name  string
+	Value float64
+	Dims  map[string]string
+}
+
+func metrics(datums []*awscw.MetricDatum) []metric {
+	var ms []metric
+	for _, d := range datums {
+		m := metric{Name: *d.MetricName, Value: *d.Value, Dims: map[string]string{}}
+		for _, dim := range d.Dimensions {
+			m.Dims[*dim.Name] = *dim.Value
+		}
+		ms = append(ms, m)
+	}
+	return ms
+}
+
+func TestPublisher_datums(t *testing.T) {
+	cfg := config.CloudWatchConfig{Dimensions: map[string]string{"AutoScalingGroupName": "agents"}}
+	p := newPublisher(&log.NullLog{}, &cloudWatchMock{}, cfg)
+	p.Stats = func() Stats {
+		return Stats{Capacity: 4, ChecksRunning: 3, MessagesInFlight: -1}
+	}
+	p.MessageReceived()
+	p.MessageReceived()
+	p.DuplicateJob()
+	p.CheckCompleted("FINISHED")
+	p.CheckCompleted("FINISHED")
+	p.CheckCompleted("FAILED")
+	asg := map[string]string{"AutoScalingGroupName": "agents"}
+	want := []metric{
+		{Name: MetricCapacity, Value: 4, Dims: asg},
+		{Name: MetricChecksRunning, Value: 3, Dims: asg},
+		{Name: MetricMessagesReceived, Value: 2, Dims: asg},
+		{Name: MetricDuplicateJobs, Value: 1, Dims: asg},
+		{Name: MetricTokenUtilization, Value: 75, Dims: asg},
+		{Name: MetricChecksCompleted, Value: 1, Dims: map[string]string{"AutoScalingGroupName": "agents", "Status": "FAILED"}},
+		{Name: MetricChecksCompleted, Value: 2, Dims: map[string]string{"AutoScalingGroupName": "agents", "Status": "FINISHED"}},
+	}
+	if diff := cmp.Diff(want, metrics(p.datums(time.Now()))); diff != "" {
+		t.Errorf("metrics mismatch (-want +got):\n%v", diff)
+	}
+	// The counters are reset after each publication.
+	want = []metric{
+		{Name: MetricCapacity, Value: 4, Dims: asg},
+		{Name: MetricChecksRunning, Value: 3, Dims: asg},
+		{Name: MetricMessagesReceived, Value: 0, Dims: asg},
+		{Name: MetricDuplicateJobs, Value: 0, Dims: asg},
+		{Name: MetricTokenUtilization, Value: 75, Dims: asg},
+	}
+	if diff := cmp.Diff(want, metrics(p.datums(time.Now()))); diff != "" {
+		t.Errorf("metrics mismatch after reset (-want +got):\n%v", diff)
+	}
+}
+
+func TestPublisher_Start(t *testing.T) {
+	cw := &cloudWatchMock{}
+	p := newPublisher(&log.NullLog{}, cw, config.CloudWatchConfig{Namespace: "Test"})
+	p.interval = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := p.Start(ctx)
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if len(cw.inputs) < 2 {
+		t.Fatalf("want at least 2 publications, got %d", len(cw.inputs))
+	}
+	for _, in := range cw.inputs {
+		if aws.StringValue(in.Namespace) != "Test" {
+			t.Errorf("want namespace Test, got %s", aws.StringValue(in.Namespace))
+		}
+	}
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+// The loadgen command publishes synthetic check jobs to the queue the agents
+// read from and reports the latency of the checks and the throughput of the
+// agents, measured from the state updates they send. See the loadgen
+// package.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/loadgen"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/queue/sqs"
+)
+
+// Exit codes of the command.
+const (
+	exitOK = iota
+	exitError
+	// exitSlow is returned when the checks don't finish in time or the p99
+	// of their latency exceeds the max one.
+	exitSlow
+)
+
+func main() {
+	// NOTE: This is done in order to be able to return custom exit codes
+	// while still executing deferred functions as expected.
+	// Using os.Exit inside the main function is not an option:
+	// https://golang.org/pkg/os/#Exit
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	fs := flag.NewFlagSet("loadgen", flag.ContinueOnError)
+	var (
+		cfg        loadgen.Config
+		checktypes = fs.String("checktypes", "", "comma separated images of the checks, each one optionally followed by =weight (required)")
+		targets    = fs.String("targets", "", "comma separated targets of the checks (required)")
+		jobsARN    = fs.String("queue-arn", "", "ARN of the SQS queue where the jobs are published (required)")
+		statesARN  = fs.String("states-arn", "", "ARN of a SQS queue, only used by the load test, where the agents send the state updates")
+		endpoint   = fs.String("endpoint", "", "endpoint of SQS, e.g. to use LocalStack")
+		wait       = fs.Duration("wait", 10*time.Minute, "max time waiting for the checks to finish after publishing the jobs")
+		maxP99     = fs.Duration("max-p99", 0, "max p99 of the end to end latency of the checks, 0 means no limit")
+		jsonOut    = fs.Bool("json", false, "write the summary in JSON")
+		logLevel   = fs.String("log-level", "info", "log level")
+	)
+	fs.Float64Var(&cfg.Rate, "rate", 1, "jobs published per second")
+	fs.StringVar(&cfg.Arrival, "arrival", loadgen.ArrivalConstant, "arrival process of the jobs: constant or poisson")
+	fs.DurationVar(&cfg.Duration, "duration", time.Minute, "time the jobs are published for")
+	fs.IntVar(&cfg.Jobs, "jobs", 0, "max number of jobs published, 0 means no limit")
+	fs.StringVar(&cfg.AssetType, "assettype", "", "asset type of the targets")
+	fs.StringVar(&cfg.Options, "options", "{}", "options of the checks in json")
+	fs.IntVar(&cfg.Timeout, "timeout", 600, "timeout of the checks in seconds")
+	fs.Int64Var(&cfg.Seed, "seed", 0, "seed of the random choices, by default it depends on the time")
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+	if *checktypes == "" || *targets == "" || *jobsARN == "" {
+		fmt.Fprintln(os.Stderr, "the checktypes, targets and queue-arn flags are mandatory")
+		fs.Usage()
+		return exitError
+	}
+	var err error
+	cfg.Checktypes, err = loadgen.ParseChecktypes(*checktypes)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	cfg.Targets = strings.Split(*targets, ",")
+
+	// The standard output is reserved for the summary.
+	l, err := log.New(config.AgentConfig{LogFile: log.StderrLogFile, LogLevel: *logLevel})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error creating log: %v", err)
+		return exitError
+	}
+	stats := loadgen.NewStats()
+	// The AWS clients use the credentials of the environment.
+	w, err := sqs.NewWriter(*jobsARN, *endpoint, config.AWSCredentialsConfig{}, l)
+	if err != nil {
+		l.Errorf("error creating the jobs writer: %v", err)
+		return exitError
+	}
+	gen, err := loadgen.NewGenerator(cfg, w, stats)
+	if err != nil {
+		l.Errorf("invalid load: %v", err)
+		return exitError
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ctxc, cancelc := context.WithCancel(context.Background())
+	collected := make(chan struct{})
+	if *statesARN == "" {
+		close(collected)
+	} else {
+		c, err := loadgen.NewCollector(l, *statesARN, *endpoint, config.AWSCredentialsConfig{}, stats)
+		if err != nil {
+			l.Errorf("error creating the state updates collector: %v", err)
+			cancelc()
+			return exitError
+		}
+		go func() {
+			c.Run(ctxc)
+			close(collected)
+		}()
+	}
+
+	l.Infof("publishing jobs at %.2f jobs/s", cfg.Rate)
+	n, err := gen.Run(ctx)
+	code := exitOK
+	if err != nil {
+		l.Errorf("error publishing jobs: %v", err)
+		code = exitError
+	}
+	l.Infof("%d jobs published", n)
+	if *statesARN != "" {
+		waitChecks(ctx, l, stats, *wait)
+	}
+	cancelc()
+	<-collected
+
+	sum := stats.Summary()
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(sum)
+	} else {
+		sum.Write(os.Stdout)
+	}
+	if code != exitOK || *statesARN == "" {
+		return code
+	}
+	if sum.Pending > 0 {
+		l.Errorf("%d checks didn't finish in %s", sum.Pending, *wait)
+		return exitSlow
+	}
+	if *maxP99 > 0 && sum.Latency.P99 > maxP99.Seconds() {
+		l.Errorf("p99 of the latency %.3fs exceeds the max %s", sum.Latency.P99, *maxP99)
+		return exitSlow
+	}
+	return exitOK
+}
+
+// waitChecks waits until all the checks published finish, the given time
+// elapses or the context is canceled, logging the progress periodically.
+func waitChecks(ctx context.Context, l log.Logger, stats *loadgen.Stats, wait time.Duration) {
+	deadline := time.After(wait)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var last time.Time
+	for {
+		pending := stats.Pending()
+		if pending == 0 {
+			return
+		}
+		if time.Since(last) >= 10*time.Second {
+			l.Infof("waiting for %d checks to finish", pending)
+			last = time.Now()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-deadline:
+			return
+		case <-ticker.C:
+		}
+	}
+}
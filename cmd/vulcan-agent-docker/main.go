@@ -5,41 +5,282 @@ Copyright 2019 Adevinta
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/adevinta/vulcan-agent/agent"
-	"github.com/adevinta/vulcan-agent/backend/docker"
+	"github.com/adevinta/vulcan-agent/awscreds"
+	"github.com/adevinta/vulcan-agent/backend"
+	// The backends built into the agent register themselves when their
+	// packages are imported.
+	_ "github.com/adevinta/vulcan-agent/backend/containerd"
+	_ "github.com/adevinta/vulcan-agent/backend/docker"
+	_ "github.com/adevinta/vulcan-agent/backend/exec"
+	_ "github.com/adevinta/vulcan-agent/backend/nomad"
 	"github.com/adevinta/vulcan-agent/config"
 	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/oneshot"
+	"github.com/adevinta/vulcan-agent/secrets"
 )
 
+const usage = `Usage:
+  vulcan-agent [config_file]
+  vulcan-agent run-check [flags]
+  vulcan-agent config validate config_file
+  vulcan-agent config print-defaults [config_file]
+
+Every config param can be overridden with an environment variable named
+` + config.EnvPrefix + `<SECTION>_<PARAM>, e.g.: ` + config.EnvPrefix + `AGENT_LOG_LEVEL.
+When no config file is given the agent is configured only from the
+environment.
+`
+
 func main() {
 	if len(os.Args) < 2 {
-		fmt.Fprint(os.Stderr, "Usage: vulcan-agent config_file")
-		os.Exit(1)
+		if !configInEnv() {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(1)
+		}
+		os.Exit(runAgent(""))
+	}
+	// NOTE: This is done in order to be able to return custom exit codes
+	// while still executing deferred functions as expected.
+	// Using os.Exit inside the main function is not an option:
+	// https://golang.org/pkg/os/#Exit
+	switch os.Args[1] {
+	case "run-check":
+		os.Exit(runCheck(os.Args[2:]))
+	case "config":
+		os.Exit(configCmd(os.Args[2:]))
+	default:
+		os.Exit(runAgent(os.Args[1]))
 	}
-	cfg, err := config.ReadConfig(os.Args[1])
+}
+
+// configInEnv returns true if any config param is defined in the
+// environment.
+func configInEnv() bool {
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, config.EnvPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func runAgent(configFile string) int {
+	cfg, err := config.ReadConfig(configFile)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error reading configuration file: %v", err)
-		os.Exit(1)
+		return 1
 	}
 	l, err := log.New(cfg.Agent)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error reading creating log: %v", err)
-		os.Exit(1)
+		return 1
+	}
+
+	// The AWS clients, including the ones reading the secrets, use the
+	// defaults defined in the config.
+	awscreds.SetDefaults(cfg.AWS)
+
+	// Resolve the references to secrets in the config.
+	resolver, err := secrets.NewResolver(cfg.Secrets)
+	if err != nil {
+		l.Errorf("error creating the secrets resolver: %v", err)
+		return 1
+	}
+	resolve := func(cfg config.Config) (config.Config, error) {
+		return resolver.ResolveConfig(context.Background(), cfg)
+	}
+	cfg, err = resolve(cfg)
+	if err != nil {
+		l.Errorf("error resolving secrets: %v", err)
+		return 1
 	}
+	awscreds.SetDefaults(cfg.AWS)
 
-	// Build the docker backend.
-	b, err := docker.NewBackend(l, cfg, nil)
+	// Build the backend.
+	b, err := backend.New(cfg.Runtime.Backend, l, cfg)
 	if err != nil {
 		l.Errorf("error creating the backend to run the checks %v", err)
-		os.Exit(1)
+		return 1
 	}
+	opts := agent.ReloadOptions{
+		ConfigFile:      configFile,
+		Resolve:         resolve,
+		RefreshInterval: time.Duration(cfg.Secrets.RefreshInterval) * time.Second,
+	}
+	return agent.RunReloadable(cfg, opts, b, l)
+}
 
-	// NOTE: This is done in order to be able to return custom exit codes
-	// while still executing deferred functions as expected.
-	// Using os.Exit inside the main function is not an option:
-	// https://golang.org/pkg/os/#Exit
-	os.Exit(agent.Run(cfg, b, l))
+// varsFlag collects the NAME=VALUE vars passed to the run-check command.
+type varsFlag map[string]string
+
+func (v varsFlag) String() string {
+	var s []string
+	for k, val := range v {
+		s = append(s, k+"="+val)
+	}
+	return strings.Join(s, ",")
+}
+
+func (v varsFlag) Set(s string) error {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("invalid var %q, expected NAME=VALUE", s)
+	}
+	v[parts[0]] = parts[1]
+	return nil
+}
+
+// runCheck runs a single check and prints its result to the standard output.
+// The exit code reflects the status of the check, see the oneshot package.
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("run-check", flag.ContinueOnError)
+	var (
+		configFile = fs.String("config", "", "optional agent config file")
+		port       = fs.String("port", "", "address where the agent API listens (default \""+config.DefaultAPIPort+"\")")
+		logLevel   = fs.String("log-level", "info", "log level")
+		p          oneshot.Params
+		vars       = varsFlag{}
+		summary    = fs.Bool("summary", true, "write a table with the findings to the standard error")
+		sarifFile  = fs.String("sarif", "", "file where the report of the check is written in SARIF format")
+	)
+	fs.StringVar(&p.Image, "image", "", "checktype image (required)")
+	fs.StringVar(&p.Target, "target", "", "target of the check (required)")
+	fs.StringVar(&p.AssetType, "assettype", "", "asset type of the target")
+	fs.StringVar(&p.Options, "options", "{}", "options of the check in json")
+	fs.IntVar(&p.Timeout, "timeout", oneshot.DefaultTimeout, "timeout of the check in seconds")
+	fs.Var(vars, "var", "required var passed to the check as NAME=VALUE, can be repeated")
+	fs.StringVar(&p.FailOn, "fail-on", "", "min severity of the findings that makes the command fail: none, low, medium, high or critical")
+	fs.IntVar(&p.FindingsExitCode, "findings-exit-code", oneshot.ExitFindings, "exit code returned when the check finds vulnerabilities of the fail-on severity")
+	if err := fs.Parse(args); err != nil {
+		return oneshot.ExitError
+	}
+	if p.Image == "" || p.Target == "" {
+		fmt.Fprintln(os.Stderr, "the image and target flags are mandatory")
+		fs.Usage()
+		return oneshot.ExitError
+	}
+	if p.FailOn != "" {
+		if _, err := oneshot.ParseSeverity(p.FailOn); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return oneshot.ExitError
+		}
+	}
+	if *summary {
+		p.Summary = os.Stderr
+	}
+
+	cfg, err := config.ReadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading configuration file: %v", err)
+		return oneshot.ExitError
+	}
+	if *port != "" {
+		cfg.API.Port = *port
+	}
+	if cfg.API.Port == "" {
+		cfg.API.Port = config.DefaultAPIPort
+	}
+	if cfg.Check.Vars == nil {
+		cfg.Check.Vars = map[string]string{}
+	}
+	for k, v := range vars {
+		cfg.Check.Vars[k] = v
+		p.RequiredVars = append(p.RequiredVars, k)
+	}
+	awscreds.SetDefaults(cfg.AWS)
+	resolver, err := secrets.NewResolver(cfg.Secrets)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error creating the secrets resolver: %v", err)
+		return oneshot.ExitError
+	}
+	cfg, err = resolver.ResolveConfig(context.Background(), cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error resolving secrets: %v", err)
+		return oneshot.ExitError
+	}
+	awscreds.SetDefaults(cfg.AWS)
+	// The standard output is reserved for the result of the check.
+	cfg.Agent.LogFile = log.StderrLogFile
+	cfg.Agent.LogLevel = *logLevel
+	l, err := log.New(cfg.Agent)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading creating log: %v", err)
+		return oneshot.ExitError
+	}
+	b, err := backend.New(cfg.Runtime.Backend, l, cfg)
+	if err != nil {
+		l.Errorf("error creating the backend to run the checks %v", err)
+		return oneshot.ExitError
+	}
+	if *sarifFile != "" {
+		f, err := os.Create(*sarifFile)
+		if err != nil {
+			l.Errorf("error creating the SARIF file: %v", err)
+			return oneshot.ExitError
+		}
+		defer f.Close()
+		p.SARIF = f
+	}
+	return oneshot.Run(context.Background(), cfg, b, l, p, os.Stdout)
+}
+
+// configCmd validates a config file or prints the effective configuration,
+// that is, the default values of the params overridden by the ones defined in
+// the config file, if given, and in the environment, with the values of the
+// secret params redacted.
+func configCmd(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return 1
+	}
+	switch args[0] {
+	case "validate":
+		if len(args) != 2 {
+			fmt.Fprint(os.Stderr, usage)
+			return 1
+		}
+		data, err := ioutil.ReadFile(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error reading configuration file: %v\n", err)
+			return 1
+		}
+		issues := config.Validate(data, config.FormatOf(args[1]))
+		for _, i := range issues {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", args[1], i)
+		}
+		if len(issues) > 0 {
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "%s: valid\n", args[1])
+		return 0
+	case "print-defaults":
+		var configFile string
+		if len(args) > 1 {
+			configFile = args[1]
+		}
+		cfg, err := config.ReadConfig(configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error reading configuration file: %v\n", err)
+			return 1
+		}
+		// The values of the secret params are not printed.
+		cfg = config.Redact(config.Resolve(cfg))
+		if err := config.Encode(os.Stdout, cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "error encoding configuration: %v\n", err)
+			return 1
+		}
+		return 0
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return 1
+	}
 }
@@ -0,0 +1,107 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/oneshot"
+)
+
+func TestRunCheck(t *testing.T) {
+	tests := []struct {
+		name string
+		// checks are the scripts of the checktypes run by the exec backend.
+		checks   map[string]string
+		args     []string
+		wantCode int
+		// maxDuration is the max time the command can run.
+		maxDuration time.Duration
+	}{
+		{
+			name:     "Failed",
+			checks:   map[string]string{"vulcan-check": "exit 1"},
+			args:     []string{"-image", "vulcan-check:1", "-target", "example.com"},
+			wantCode: oneshot.ExitFailed,
+		},
+		{
+			name:        "Timeout",
+			checks:      map[string]string{"vulcan-check": "exec sleep 30"},
+			args:        []string{"-image", "vulcan-check:1", "-target", "example.com", "-timeout", "1"},
+			wantCode:    oneshot.ExitTimeout,
+			maxDuration: 10 * time.Second,
+		},
+		{
+			name:     "UnknownCheck",
+			args:     []string{"-image", "vulcan-unknown:1", "-target", "example.com"},
+			wantCode: oneshot.ExitError,
+		},
+		{
+			name:     "InvalidImage",
+			args:     []string{"-image", "Invalid Image:1", "-target", "example.com"},
+			wantCode: oneshot.ExitError,
+		},
+		{
+			name:     "MissingTarget",
+			args:     []string{"-image", "vulcan-check:1"},
+			wantCode: oneshot.ExitError,
+		},
+		{
+			name:     "InvalidFailOn",
+			args:     []string{"-image", "vulcan-check:1", "-target", "example.com", "-fail-on", "severe"},
+			wantCode: oneshot.ExitError,
+		},
+		{
+			name:     "InvalidVar",
+			args:     []string{"-image", "vulcan-check:1", "-target", "example.com", "-var", "TOKEN"},
+			wantCode: oneshot.ExitError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, script := range tt.checks {
+				err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), 0o755)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			t.Setenv(config.EnvName("runtime.backend"), config.BackendExec)
+			t.Setenv(config.EnvName("runtime.exec.checks_dir"), dir)
+			addr := freeAddr(t)
+			args := append([]string{"-port", addr, "-summary=false", "-log-level", "error"}, tt.args...)
+
+			start := time.Now()
+			if code := runCheck(args); code != tt.wantCode {
+				t.Errorf("want exit code %d, got %d", tt.wantCode, code)
+			}
+			if tt.maxDuration > 0 && time.Since(start) > tt.maxDuration {
+				t.Errorf("check not stopped at its timeout, run %s", time.Since(start))
+			}
+			// The API of the agent is stopped when the command returns.
+			l, err := net.Listen("tcp", addr)
+			if err != nil {
+				t.Fatalf("agent API not stopped: %v", err)
+			}
+			l.Close()
+		})
+	}
+}
+
+// freeAddr returns a local address with a port that is not in use.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
@@ -7,8 +7,6 @@ package config
 import (
 	"fmt"
 	"io/ioutil"
-
-	"github.com/BurntSushi/toml"
 )
 
 // Config represents the configuration for the agent.
@@ -17,23 +15,236 @@ type Config struct {
 	Stream    StreamConfig   `toml:"stream"`
 	Uploader  UploaderConfig `toml:"uploader"`
 	SQSReader SQSReader      `toml:"sqs_reader"`
-	SQSWriter SQSWriter      `toml:"sqs_writer"`
-	API       APIConfig      `toml:"api"`
-	Check     CheckConfig    `toml:"check"`
-	Runtime   RuntimeConfig  `toml:"runtime"`
-	DataDog   DatadogConfig  `toml:"datadog"`
+	// SQSPriorityReader, when it contains queues, makes the agent read from
+	// them instead of reading from the queue defined in SQSReader.
+	SQSPriorityReader SQSPriorityReader   `toml:"sqs_priority_reader"`
+	SQSWriter         SQSWriter           `toml:"sqs_writer"`
+	StateUpdater      StateUpdaterConfig  `toml:"stateupdater"`
+	API               APIConfig           `toml:"api"`
+	Check             CheckConfig         `toml:"check"`
+	Runtime           RuntimeConfig       `toml:"runtime"`
+	DataDog           DatadogConfig       `toml:"datadog"`
+	ResultCache       ResultCacheConfig   `toml:"result_cache"`
+	Schedules         []ScheduleConfig    `toml:"schedules"`
+	Secrets           SecretsConfig       `toml:"secrets"`
+	Notifications     NotificationsConfig `toml:"notifications"`
+	Spool             SpoolConfig         `toml:"spool"`
+	Lifecycle         LifecycleConfig     `toml:"lifecycle"`
+	Heartbeat         HeartbeatConfig     `toml:"heartbeat"`
+	Diagnostics       DiagnosticsConfig   `toml:"diagnostics"`
+	// PubSubReader, when it defines a subscription, makes the agent read
+	// the checks from it instead of reading from SQS.
+	PubSubReader PubSubReader `toml:"pubsub_reader"`
+	// ServiceBusReader, when it defines a connection string, makes the
+	// agent read the checks from an Azure Service Bus queue instead of
+	// reading from SQS.
+	ServiceBusReader ServiceBusReader `toml:"servicebus_reader"`
+	Queue            QueueConfig      `toml:"queue"`
+	// PostProcessing defines the processors applied to the reports of the
+	// checks before uploading them.
+	PostProcessing PostProcessingConfig `toml:"postprocessing"`
+	// SQSReaders, when it contains queues, makes the agent read from all of
+	// them concurrently instead of reading from the queue defined in
+	// SQSReader.
+	SQSReaders []SQSReader `toml:"sqs_readers"`
+	// AWS defines the defaults of the AWS clients.
+	AWS AWSConfig `toml:"aws"`
+	// CloudWatch defines the publication of the metrics of the agent to
+	// AWS CloudWatch.
+	CloudWatch CloudWatchConfig `toml:"cloudwatch"`
+	// Audit defines the audit log of the jobs run by the agent.
+	Audit AuditConfig `toml:"audit"`
+	// MessageAuth defines the authentication of the messages of the jobs
+	// and the signature of the state updates.
+	MessageAuth MessageAuthConfig `toml:"message_auth"`
+	// SelfUpdate defines how the agent updates itself to new versions.
+	SelfUpdate SelfUpdateConfig `toml:"selfupdate"`
+}
+
+// Types of the queues the agent can read the checks from.
+const (
+	QueueTypeSQS         = "sqs"
+	QueueTypeSQSPriority = "sqs_priority"
+	QueueTypeSQSMulti    = "sqs_multi"
+	QueueTypePubSub      = "pubsub"
+	QueueTypeServiceBus  = "servicebus"
+	QueueTypeSchedules   = "schedules"
+)
+
+// QueueConfig defines the queue the agent reads the checks from.
+type QueueConfig struct {
+	// Type is the type of the queue, any of the QueueType constants or the
+	// type of a queue reader registered by a third party package. When it's
+	// empty the type is inferred from the sections of the config that are
+	// defined.
+	Type string `toml:"type"`
+}
+
+// QueueType returns the type of the queue the agent reads the checks from.
+func (c Config) QueueType() string {
+	switch {
+	case c.Queue.Type != "":
+		return c.Queue.Type
+	case c.PubSubReader.Subscription != "":
+		return QueueTypePubSub
+	case c.ServiceBusReader.ConnectionString != "":
+		return QueueTypeServiceBus
+	case len(c.SQSPriorityReader.Queues) > 0:
+		return QueueTypeSQSPriority
+	case len(c.SQSReaders) > 0:
+		return QueueTypeSQSMulti
+	case c.SQSReader.ARN == "" && len(c.Schedules) > 0:
+		return QueueTypeSchedules
+	default:
+		return QueueTypeSQS
+	}
 }
 
 // AgentConfig defines the higher level configuration for the agent.
 type AgentConfig struct {
 	LogLevel       string `toml:"log_level"`
-	LogFile        string `toml:"log_file"`
-	Timeout        int    `toml:"timeout"` // Timeout to start running a check.
+	LogFile        string `toml:"log_file"` // "stderr" writes the log to the standard error.
+	Timeout        int    `toml:"timeout"`  // Timeout to start running a check.
 	ConcurrentJobs int    `toml:"concurrent_jobs"`
+	// LogOutput is where the log is written: "file", the default, writes it
+	// to the LogFile, "syslog" sends it to the syslog server defined in
+	// Syslog and "journald" to the systemd journal.
+	LogOutput string       `toml:"log_output"`
+	Syslog    SyslogConfig `toml:"syslog"`
 	// MaxMsgsInterval defines the maximun time, in seconds, the agent can
-	// running without reading any message from the queue.
+	// running without reading any message from the queue. 0 means the agent
+	// never exits because of that.
 	MaxNoMsgsInterval      int `toml:"max_no_msgs_interval"`
 	MaxProcessMessageTimes int `toml:"max_message_processed_times"`
+	// CheckCosts defines, per checktype name, the number of concurrent jobs
+	// a check of that checktype counts as.
+	CheckCosts map[string]int `toml:"check_costs"`
+	// TargetRateLimit defines the maximum number of checks per minute that
+	// can be launched against the same target. 0 means no limit.
+	TargetRateLimit int `toml:"target_rate_limit"`
+	// TeamRateLimit defines the maximum number of checks per minute that can
+	// be launched for the same team, as defined in the "team" metadata of the
+	// checks. 0 means no limit.
+	TeamRateLimit int `toml:"team_rate_limit"`
+	// ConfigReloadInterval defines, in seconds, how often the config file is
+	// checked for changes to reload it. 0 means the config is only reloaded
+	// when the agent receives a SIGHUP.
+	ConfigReloadInterval int `toml:"config_reload_interval"`
+	// DrainTimeout is the maximum time, in seconds, the agent waits for the
+	// running checks to finish when it's drained, after that the checks are
+	// aborted. 0 means waiting until they finish.
+	DrainTimeout int `toml:"drain_timeout"`
+	// ShutdownTimeout is the maximum time, in seconds, the agent waits for
+	// the running checks to finish when it receives a SIGINT or a SIGTERM,
+	// after that the checks are stopped and their messages returned to the
+	// queue. 0 means waiting until they finish. A signal received while
+	// waiting stops the checks immediately.
+	ShutdownTimeout int `toml:"shutdown_timeout"`
+	// WatchdogInterval defines, in seconds, how often the agent looks for
+	// checks that are stuck: still running, or storing their results, after
+	// their timeout plus the kill grace plus the WatchdogGrace. The stuck
+	// checks are aborted, their status set to TIMEOUT, or FAILED if they
+	// finished, and their tokens freed. 0, the default, disables the
+	// watchdog. The time the checks wait for a free uploader counts towards
+	// the deadline, so the WatchdogGrace must cover it.
+	WatchdogInterval int `toml:"watchdog_interval"`
+	WatchdogGrace    int `toml:"watchdog_grace"`
+	// ReapOrphans makes the agent remove, when it starts, the checks left
+	// running by its previous executions, identified by the agent ID of the
+	// heartbeat config. FailOrphans also sets the status of those checks to
+	// FAILED.
+	ReapOrphans bool `toml:"reap_orphans"`
+	FailOrphans bool `toml:"fail_orphans"`
+	// MinLifetime is the minimum time, in seconds, the agent runs before
+	// exiting because of the MaxNoMsgsInterval.
+	MinLifetime int `toml:"min_lifetime"`
+	// MaxChecks is the number of checks the agent runs before exiting. When
+	// it's reached the agent stops reading messages and waits for the
+	// running checks to finish. 0 means no limit.
+	MaxChecks int `toml:"max_checks"`
+	// ExitWhenEmpty makes the agent exit the first time it finds the queue
+	// empty while no checks are running.
+	ExitWhenEmpty bool `toml:"exit_when_empty"`
+	// Admission defines the conditions of the host required to run new
+	// checks.
+	Admission AdmissionConfig `toml:"admission"`
+	// CheckLogs defines the files where the logs about each check are
+	// written apart from the main log.
+	CheckLogs CheckLogsConfig `toml:"check_logs"`
+	// DuplicateChecks defines what the agent does with the jobs received for
+	// a check that is already being processed: "reject", the default,
+	// deletes their messages and "requeue" returns them to the queue, so
+	// they are received again once the check finishes.
+	DuplicateChecks string `toml:"duplicate_checks"`
+}
+
+// CheckLogsConfig defines the files where the agent writes its logs about
+// each check, named as the ID of the check. The files are not written when
+// Dir is empty.
+type CheckLogsConfig struct {
+	Dir string `toml:"dir"`
+	// Level of the logs written to the files of the checks, so they can
+	// include, for instance, the debug logs that the main log doesn't.
+	Level string `toml:"level"`
+	// MaxSizeMB is the size of a file after which it's rotated, keeping
+	// MaxBackups rotated files. 0 means the files are never rotated.
+	MaxSizeMB  int `toml:"max_size_mb"`
+	MaxBackups int `toml:"max_backups"`
+	// MaxAge is the time, in hours, the files are kept after their last
+	// write. 0 means they are never removed.
+	MaxAge int `toml:"max_age"`
+}
+
+// Actions on the jobs of the checks already being processed.
+const (
+	DuplicateChecksReject  = "reject"
+	DuplicateChecksRequeue = "requeue"
+)
+
+// Log outputs of the agent.
+const (
+	LogOutputFile     = "file"
+	LogOutputSyslog   = "syslog"
+	LogOutputJournald = "journald"
+)
+
+// SyslogConfig defines the syslog server the log is sent to, in the RFC 5424
+// format, when the log output is "syslog".
+type SyslogConfig struct {
+	// Network is "udp", the default, "tcp" or "tls".
+	Network string `toml:"network"`
+	Address string `toml:"address"`
+	// Tag is the app name of the messages, "vulcan-agent" by default.
+	Tag string `toml:"tag"`
+	// Facility is the name of the facility of the messages, like "daemon",
+	// the default, or "local0".
+	Facility string `toml:"facility"`
+	// CACert, ClientCert and ClientKey are the paths of the files used to
+	// connect to the server with TLS.
+	CACert     string `toml:"ca_cert"`
+	ClientCert string `toml:"client_cert"`
+	ClientKey  string `toml:"client_key"`
+}
+
+// AdmissionConfig defines the conditions of the host required to run new
+// checks. While they are not met the agent stops reading messages and the
+// messages of the checks received are returned to the queue. The conditions
+// with a zero value are not checked.
+type AdmissionConfig struct {
+	// DiskPath is the path of the filesystem that must have at least
+	// MinFreeDiskMB of free space, /var/lib/docker by default.
+	DiskPath      string `toml:"disk_path"`
+	MinFreeDiskMB int    `toml:"min_free_disk_mb"`
+	// MaxLoad is the max 1 minute load average per CPU.
+	MaxLoad float64 `toml:"max_load"`
+	// MinFreeMemoryMB is the min memory available for new processes.
+	MinFreeMemoryMB int `toml:"min_free_memory_mb"`
+	// MaxMemoryPressure is the max percentage of time, in the last 10
+	// seconds, that some processes were stalled waiting for memory.
+	MaxMemoryPressure float64 `toml:"max_memory_pressure"`
+	// Interval is the time, in seconds, between the checks of the
+	// conditions while they are not met, 10 by default.
+	Interval int `toml:"interval"`
 }
 
 // StreamConfig defines the configuration for the event stream.
@@ -43,14 +254,142 @@ type StreamConfig struct {
 	Timeout       int    `toml:"timeout"`
 	Retries       int    `toml:"retries"`
 	RetryInterval int    `toml:"retry_interval"`
+
+	// AbortEvents defines where the agent receives the abort events from, so
+	// it doesn't have to query the aborted checks before starting each
+	// check: "stream", the websocket of the stream, or "sqs", the queue
+	// defined in AbortQueue. When empty, or while the agent is not receiving
+	// the events, the aborted checks are queried from QueryEndpoint.
+	AbortEvents string           `toml:"abort_events"`
+	AbortQueue  AbortQueueConfig `toml:"abort_queue"`
+
+	// AbortedTTL is the time, in seconds, the aborted checks queried are
+	// considered up to date. When they are older they are queried in the
+	// background instead of before starting the check. 0 means querying
+	// them before starting every check not known to be aborted.
+	AbortedTTL int `toml:"aborted_ttl"`
+	// AbortedRefreshInterval, if greater than 0, is the interval, in
+	// seconds, at which the aborted checks are queried in the background.
+	AbortedRefreshInterval int `toml:"aborted_refresh_interval"`
+}
+
+// Sources of the abort events.
+const (
+	AbortEventsStream = "stream"
+	AbortEventsSQS    = "sqs"
+)
+
+// AbortQueueConfig defines the SQS queue, usually subscribed to an SNS topic,
+// the abort events are read from.
+type AbortQueueConfig struct {
+	ARN         string               `toml:"arn"`
+	Endpoint    string               `toml:"endpoint"`
+	Credentials AWSCredentialsConfig `toml:"credentials"`
 }
 
+// Types of uploaders.
+const (
+	UploaderTypeHTTP    = "http"
+	UploaderTypeS3      = "s3"
+	UploaderTypeWebhook = "webhook"
+	UploaderTypeLocal   = "local"
+)
+
 // UploaderConfig defines the configuration for the results service.
 type UploaderConfig struct {
-	Endpoint      string `toml:"endpoint"`
-	Timeout       int    `toml:"timeout"`
-	Retries       int    `toml:"retries"`
-	RetryInterval int    `toml:"retry_interval"`
+	// Type defines where the results are stored: "http", the default, sends
+	// them to the vulcan-results service in the Endpoint, "s3" stores them
+	// in the bucket defined in S3, "webhook" posts them to the Endpoint and
+	// "local" writes them to the directory defined in LocalDir.
+	Type          string           `toml:"type"`
+	Endpoint      string           `toml:"endpoint"`
+	Timeout       int              `toml:"timeout"`
+	Retries       int              `toml:"retries"`
+	RetryInterval int              `toml:"retry_interval"`
+	S3            S3UploaderConfig `toml:"s3"`
+	LocalDir      LocalDirConfig   `toml:"local_dir"`
+	// Sinks defines additional uploaders the results are also sent to, each
+	// one with its own retry policy. Their errors don't make the checks fail.
+	Sinks []UploaderConfig `toml:"sinks"`
+	// SARIF, if true, makes the uploader also store the reports in SARIF
+	// format, as the artifact report.sarif of the checks.
+	SARIF bool `toml:"sarif"`
+	// BreakerThreshold, if greater than 0, is the number of consecutive
+	// errors storing the results, after the retries, that make the agent
+	// stop running new checks until the uploader works again, which is
+	// checked every BreakerProbeInterval seconds. Only used in the main
+	// uploader.
+	BreakerThreshold     int `toml:"breaker_threshold"`
+	BreakerProbeInterval int `toml:"breaker_probe_interval"`
+	// Workers is the maximum number of checks whose logs and artifacts are
+	// stored at the same time, after they finish running and free their
+	// slot for new checks. 0 means the number of concurrent jobs. Only used
+	// in the main uploader.
+	Workers int `toml:"workers"`
+}
+
+// LocalDirConfig defines the directory where the local uploader writes the
+// results and how long they are kept.
+type LocalDirConfig struct {
+	Path string `toml:"path"`
+	// MaxAgeDays defines the days the results are kept. 0 means forever.
+	MaxAgeDays int `toml:"max_age_days"`
+	// MaxSizeMB defines the maximum size of the results in the directory,
+	// when exceeded the oldest results are removed. 0 means no limit.
+	MaxSizeMB int `toml:"max_size_mb"`
+}
+
+// S3UploaderConfig defines the bucket where the S3 uploader stores the
+// results.
+type S3UploaderConfig struct {
+	Bucket   string `toml:"bucket"`
+	Prefix   string `toml:"prefix"`
+	Region   string `toml:"region"`
+	Endpoint string `toml:"endpoint"`
+	// Credentials defines how the bucket is accessed.
+	Credentials AWSCredentialsConfig `toml:"credentials"`
+}
+
+// AWSConfig defines the defaults of all the AWS clients of the agent, e.g.
+// to run it against LocalStack.
+type AWSConfig struct {
+	// Endpoint, if not empty, is used by the clients that don't define their
+	// own endpoint.
+	Endpoint string `toml:"endpoint"`
+	// Region, if not empty, is used by the clients whose region is not
+	// defined by the ARN of the resource they access or in their config.
+	Region string `toml:"region"`
+	// S3ForcePathStyle makes the S3 clients use path-style addressing.
+	S3ForcePathStyle bool `toml:"s3_force_path_style"`
+	// Credentials are used by the clients that don't define their own.
+	Credentials AWSCredentialsConfig `toml:"credentials"`
+}
+
+// AWSCredentialsConfig defines the credentials used to access an AWS
+// service. By default the credentials of the environment, e.g. the instance
+// profile, are used.
+type AWSCredentialsConfig struct {
+	// AccessKeyID, SecretAccessKey and SessionToken, if the access key is
+	// not empty, are used instead of the credentials of the environment,
+	// e.g. the test credentials of LocalStack.
+	AccessKeyID     string `toml:"access_key_id"`
+	SecretAccessKey string `toml:"secret_access_key" secret:"true"`
+	SessionToken    string `toml:"session_token" secret:"true"`
+	// RoleARN, if not empty, is the role assumed, with the credentials above
+	// or the ones of the environment, to access the service, e.g. a role of
+	// the account of the queue.
+	RoleARN string `toml:"role_arn"`
+	// ExternalID is the external ID required by the trust policy of the
+	// role, if any.
+	ExternalID string `toml:"external_id"`
+	// SessionName is the name of the sessions of the role. By default
+	// "vulcan-agent".
+	SessionName string `toml:"session_name"`
+	// STSRegion, if not empty, makes the role to be assumed using the
+	// regional STS endpoint of that region instead of the global one.
+	STSRegion string `toml:"sts_region"`
+	// STSEndpoint overrides the endpoint of STS, e.g. to use a VPC endpoint.
+	STSEndpoint string `toml:"sts_endpoint"`
 }
 
 // SQSReader defines the config of sqs reader.
@@ -60,12 +399,164 @@ type SQSReader struct {
 	VisibilityTimeout int    `toml:"visibility_timeout"`
 	PollingInterval   int    `toml:"polling_interval"`
 	ProcessQuantum    int    `toml:"process_quantum"`
+	// WaitTimeSeconds, if greater than 0, is the time every receive call
+	// waits for messages, up to 20 seconds. By default the first receive
+	// doesn't wait and the next ones wait PollingInterval seconds.
+	WaitTimeSeconds int `toml:"wait_time_seconds"`
+	// MaxMessages is the max number of messages read in a receive call, up
+	// to 10. The reader never reads more messages than free tokens.
+	MaxMessages int `toml:"max_messages"`
+	// BackoffMinMs and BackoffMaxMs define the wait, in milliseconds,
+	// between receive calls that return no messages. It starts at
+	// BackoffMinMs and doubles after each empty receive up to BackoffMaxMs.
+	// A BackoffMaxMs of 0 disables the backoff.
+	BackoffMinMs int `toml:"backoff_min_ms"`
+	BackoffMaxMs int `toml:"backoff_max_ms"`
+	// DLQARN is the ARN of the queue where the messages that can't be parsed
+	// or that were received more than MaxReceiveCount times are moved to.
+	DLQARN          string `toml:"dlq_arn"`
+	MaxReceiveCount int    `toml:"max_receive_count"`
+	// Priority and Weight are only used when the queue is part of a
+	// SQSPriorityReader.
+	Priority int `toml:"priority"`
+	Weight   int `toml:"weight"`
+	// Share is only used when the queue is one of the SQSReaders. It's the
+	// max number of checks read from the queue that can be running at the
+	// same time. 0 means no limit other than the concurrent jobs.
+	Share int `toml:"share"`
+	// Credentials defines how the queue, and its dead letter queue, are
+	// accessed.
+	Credentials AWSCredentialsConfig `toml:"credentials"`
+}
+
+// SQSPriorityReader defines the config of a reader that reads from several sqs
+// queues with different priorities.
+type SQSPriorityReader struct {
+	// Strategy defines how the queues are polled. "strict", the default,
+	// always reads from the queue with the highest priority that has messages.
+	// "weighted" polls the queues in a random order proportional to their
+	// weights.
+	Strategy string      `toml:"strategy"`
+	Queues   []SQSReader `toml:"queues"`
 }
 
 // SQSWriter defines the config params from the sqs writer.
 type SQSWriter struct {
 	Endpoint string `toml:"endpoint"`
 	ARN      string `toml:"arn"`
+	// BatchSize, if greater than 1, makes the writer to send the messages
+	// asynchronously in batches of up to BatchSize messages, 10 at most.
+	BatchSize int `toml:"batch_size"`
+	// FlushIntervalMs is the maximum time, in milliseconds, a message waits
+	// for its batch to be sent.
+	FlushIntervalMs int `toml:"flush_interval_ms"`
+	// BufferSize is the maximum number of messages pending to be sent.
+	BufferSize int `toml:"buffer_size"`
+	// ContentBasedDedup must be true when the queue is a FIFO queue with
+	// content based deduplication enabled.
+	ContentBasedDedup bool `toml:"content_based_dedup"`
+	// Credentials defines how the queue is accessed.
+	Credentials AWSCredentialsConfig `toml:"credentials"`
+}
+
+// Backends where the state updates of the checks can be written to.
+const (
+	StateBackendSQS         = "sqs"
+	StateBackendSNS         = "sns"
+	StateBackendEventBridge = "eventbridge"
+	StateBackendPubSub      = "pubsub"
+	StateBackendServiceBus  = "servicebus"
+)
+
+// StateUpdaterConfig defines where the agent writes the state updates of the
+// checks.
+type StateUpdaterConfig struct {
+	// Backend is "sqs", the default, to write to the queue defined in the
+	// sqs_writer section, "sns", "eventbridge", "pubsub" or "servicebus".
+	Backend     string            `toml:"backend"`
+	SNS         SNSWriter         `toml:"sns"`
+	EventBridge EventBridgeWriter `toml:"eventbridge"`
+	PubSub      PubSubWriter      `toml:"pubsub"`
+	ServiceBus  ServiceBusWriter  `toml:"servicebus"`
+
+	// FinalStatusesPath, if not empty, is the file where the final statuses
+	// of the checks are stored, so they are known after a restart of the
+	// agent.
+	FinalStatusesPath string `toml:"final_statuses_path"`
+}
+
+// PubSubReader defines the config of the Google Cloud Pub/Sub reader.
+type PubSubReader struct {
+	Project      string `toml:"project"`
+	Subscription string `toml:"subscription"`
+	// Endpoint of the Pub/Sub API, the public one by default.
+	Endpoint string `toml:"endpoint"`
+	// Token is the OAuth2 access token used to call the API. When empty the
+	// tokens of the service account of the instance are requested to the
+	// GCE metadata server.
+	Token string `toml:"token" secret:"true"`
+	// Emulator disables the authentication, for the Pub/Sub emulator.
+	Emulator bool `toml:"emulator"`
+	// AckDeadline is the time, in seconds, the messages being processed
+	// are kept unavailable for other agents. It's extended every
+	// ProcessQuantum seconds while their checks run.
+	AckDeadline    int `toml:"ack_deadline"`
+	ProcessQuantum int `toml:"process_quantum"`
+	// PollingInterval is the time, in seconds, to wait after a pull that
+	// returns no messages.
+	PollingInterval int `toml:"polling_interval"`
+}
+
+// PubSubWriter defines the config params of the Google Cloud Pub/Sub state
+// writer.
+type PubSubWriter struct {
+	Project  string `toml:"project"`
+	Topic    string `toml:"topic"`
+	Endpoint string `toml:"endpoint"`
+	Token    string `toml:"token" secret:"true"`
+	Emulator bool   `toml:"emulator"`
+}
+
+// ServiceBusReader defines the config of the Azure Service Bus reader.
+type ServiceBusReader struct {
+	// ConnectionString is a connection string with a shared access key, as
+	// shown in the Azure portal.
+	ConnectionString string `toml:"connection_string" secret:"true"`
+	// Queue is the name of the queue, by default the EntityPath of the
+	// connection string.
+	Queue string `toml:"queue"`
+	// WaitTime is the time, in seconds, every receive call waits for
+	// messages.
+	WaitTime int `toml:"wait_time"`
+	// LockRenewal is the interval, in seconds, at which the locks of the
+	// messages being processed are renewed. It must be lower than the lock
+	// duration of the queue.
+	LockRenewal int `toml:"lock_renewal"`
+}
+
+// ServiceBusWriter defines the config params of the Azure Service Bus state
+// writer.
+type ServiceBusWriter struct {
+	ConnectionString string `toml:"connection_string" secret:"true"`
+	Queue            string `toml:"queue"`
+}
+
+// SNSWriter defines the config params of the SNS state writer.
+type SNSWriter struct {
+	Endpoint string `toml:"endpoint"`
+	ARN      string `toml:"arn"`
+}
+
+// EventBridgeWriter defines the config params of the EventBridge state
+// writer.
+type EventBridgeWriter struct {
+	// EventBus is the name or the ARN of the event bus. Empty means the
+	// default event bus.
+	EventBus   string `toml:"event_bus"`
+	Source     string `toml:"source"`
+	DetailType string `toml:"detail_type"`
+	Region     string `toml:"region"`
+	Endpoint   string `toml:"endpoint"`
 }
 
 // APIConfig defines the configuration for the agent API.
@@ -73,30 +564,227 @@ type APIConfig struct {
 	Port  string `json:"port"`               // Port where the api for for the check should listen on
 	IName string `json:"iname" toml:"iname"` // Interface name that defines the ip a check should use to reach the agent api.
 	Host  string `json:"host" toml:"host"`   // Hostname a check should use to reach the agent. Overrides the IName config param.
+	// Debug defines the endpoints used to debug the running checks.
+	Debug DebugConfig `json:"debug" toml:"debug"`
+	// Auth defines how the control endpoints of the API are protected.
+	Auth APIAuthConfig `json:"auth" toml:"auth"`
+}
+
+// APIAuthConfig defines how the control endpoints of the API, the ones used
+// to drain the agent or to list, abort and debug checks, are protected. The
+// endpoints used by the checks to send their state and the stats and status
+// endpoints are always public.
+type APIAuthConfig struct {
+	// Token that the requests to the control endpoints must send in the
+	// Authorization header as a bearer token.
+	Token string `toml:"token" secret:"true"`
+	// TLSCert and TLSKey are the files of the certificate and key used to
+	// serve the API over TLS in the TLSPort. When they are defined the control
+	// endpoints are only served over TLS, while the checks still use the
+	// plain HTTP port.
+	TLSPort string `toml:"tls_port"`
+	TLSCert string `toml:"tls_cert"`
+	TLSKey  string `toml:"tls_key"`
+	// ClientCA is the file with the CA certificates used to verify the client
+	// certificates. The requests with a verified client certificate are
+	// authorized without a token.
+	ClientCA string `toml:"client_ca"`
+}
+
+// DebugConfig defines the config of the endpoint of the API that executes
+// commands inside the running checks. It's disabled by default.
+type DebugConfig struct {
+	Enabled bool `toml:"enabled"`
+	// Token that the requests to the debug endpoints must send in the
+	// Authorization header as a bearer token. It's required when the debug
+	// endpoints are enabled.
+	Token string `toml:"token" secret:"true"`
 }
 
 // CheckConfig defines the configuration for the checks.
 type CheckConfig struct {
-	AbortTimeout int               `toml:"abort_timeout"` // Time to wait for a check container to stop gracefully.
-	LogLevel     string            `toml:"log_level"`     // Log level for the check default logger.
-	Vars         map[string]string `toml:"vars"`          // Environment variables to inject to checks.
+	AbortTimeout int               `toml:"abort_timeout"`                    // Time to wait for a check container to stop gracefully.
+	LogLevel     string            `toml:"log_level"`                        // Log level for the check default logger.
+	Vars         map[string]string `toml:"vars" secret:"true" env:"entries"` // Environment variables to inject to checks.
+	// KillGrace is the time, in seconds, to wait for a check that timed out
+	// or was aborted to stop, after sending it a SIGTERM, before sending it
+	// a SIGKILL. If it's 0 the AbortTimeout is used.
+	KillGrace int `toml:"kill_grace"`
+	// ChecktypeVars defines the vars that are only injected in the checks of
+	// each checktype. They are usually defined as tables of the vars, e.g.:
+	// [check.vars.vulcan-nessus].
+	ChecktypeVars map[string]map[string]string `toml:"checktype_vars" secret:"true"`
+	// DynamicVars defines the required vars whose values are short-lived
+	// credentials generated by Vault for each check, e.g.:
+	// DB_PASSWORD = "vault://database/creds/readonly#password".
+	DynamicVars map[string]string `toml:"dynamic_vars" env:"entries"`
+	// EnvTemplates defines vars injected in all the checks whose values are
+	// rendered, using the text/template syntax, from the fields of each job,
+	// e.g.: TEAM = "{{.Metadata.team}}".
+	EnvTemplates map[string]string `toml:"env_templates" env:"entries"`
 }
 
 // RuntimeConfig defines the configuration for the check runtimes.
 type RuntimeConfig struct {
+	// Backend is the name of the backend that runs the checks, "docker" by
+	// default or the name of a backend registered by a third party package.
+	Backend    string           `toml:"backend"`
 	Docker     DockerConfig     `toml:"docker"`
 	Kubernetes KubernetesConfig `toml:"kubernetes"`
+	// Exec contains the config of the backend that runs the checks as local
+	// processes.
+	Exec ExecConfig `toml:"exec"`
+	// Containerd contains the config of the backend that runs the checks in
+	// containerd.
+	Containerd ContainerdConfig `toml:"containerd"`
+	// Nomad contains the config of the backend that runs the checks as
+	// Nomad jobs.
+	Nomad NomadConfig `toml:"nomad"`
+	// Faulty defines the failures injected in the checks to test the
+	// resilience of the agent.
+	Faulty FaultyConfig `toml:"faulty"`
 }
 
+// Backends built into the agent.
+const (
+	BackendDocker     = "docker"
+	BackendExec       = "exec"
+	BackendContainerd = "containerd"
+	BackendNomad      = "nomad"
+)
+
 // DockerConfig defines the configuration for the Docker runtime environment.
 type DockerConfig struct {
 	Registry RegistryConfig `toml:"registry"`
+	// Runtime is the OCI runtime used to run the containers of the checks,
+	// e.g.: kata-runtime or runsc, to isolate the checks in microVMs or
+	// sandboxes. When empty the default runtime of the docker daemon is used.
+	Runtime string `toml:"runtime"`
+	// ChecktypeRuntimes overrides the OCI runtime for the checktypes, by
+	// name, in the map.
+	ChecktypeRuntimes map[string]string `toml:"checktype_runtimes"`
+	// Security defines the hardening of the containers of the checks.
+	Security SecurityConfig `toml:"security"`
+	// Egress defines the network policy of the containers of the checks.
+	Egress EgressConfig `toml:"egress"`
+	// Sandbox defines the scratch volume and the artifacts of the checks.
+	Sandbox SandboxConfig `toml:"sandbox"`
+	// Devices contains, per checktype name, the devices exposed to the
+	// containers of the checks.
+	Devices map[string]DevicesConfig `toml:"devices"`
+	// HealthInterval is the interval, in seconds, between the pings to the
+	// docker daemon used to detect that it became unavailable. 0 disables
+	// them.
+	HealthInterval int `toml:"health_interval"`
+	// Labels contains extra labels added to the containers, networks and
+	// volumes of the checks.
+	Labels map[string]string `toml:"labels"`
+	// Hosts are remote docker daemons where the checks are run instead of
+	// the daemon defined by the environment.
+	Hosts []DockerHostConfig `toml:"hosts"`
+	// OSType is the operating system of the images of the checks, linux or
+	// windows. When empty the OS of the docker daemon is used.
+	OSType string `toml:"os_type"`
+	// Isolation is the isolation technology of the Windows containers,
+	// process or hyperv. When empty the default of the daemon is used.
+	Isolation string `toml:"isolation"`
+	// Platform is the platform, os/arch[/variant], of the images pulled and
+	// the containers created for the checks, e.g.: linux/arm64. When empty
+	// the platform of the docker daemon is used.
+	Platform string `toml:"platform"`
+	// ChecktypePlatforms overrides the platform for the checktypes, by name,
+	// in the map.
+	ChecktypePlatforms map[string]string `toml:"checktype_platforms"`
+}
+
+// DockerHostConfig defines a remote docker daemon where the checks can run.
+type DockerHostConfig struct {
+	// Address is the address of the daemon, e.g.: tcp://scanner1:2376.
+	Address string `toml:"address"`
+	// CACert, Cert and Key are the paths of the files used to connect to the
+	// daemon with TLS.
+	CACert string `toml:"ca_cert"`
+	Cert   string `toml:"cert"`
+	Key    string `toml:"key"`
+	// MaxChecks is the max number of checks running at the same time in the
+	// host. 0 means no limit other than the concurrent jobs of the agent.
+	MaxChecks int `toml:"max_checks"`
+}
+
+// DevicesConfig defines the devices exposed to the containers of a
+// checktype.
+type DevicesConfig struct {
+	// Paths contains the devices of the host mapped into the containers,
+	// in the format of the --device flag of docker:
+	// host_path[:container_path[:permissions]], e.g.: /dev/net/tun.
+	Paths []string `toml:"paths"`
+	// GPUs is the number of GPUs requested to the docker daemon, -1 means
+	// all the GPUs of the host.
+	GPUs int `toml:"gpus"`
+}
+
+// SandboxConfig defines the scratch volume mounted in the containers of the
+// checks and the artifacts collected from them after they finish.
+type SandboxConfig struct {
+	// ScratchPath is the path in the containers where a volume, created for
+	// each check and removed when the check finishes, is mounted. No volume
+	// is mounted when it's empty.
+	ScratchPath string `toml:"scratch_path"`
+	// Artifacts contains, per checktype name, the paths of the files or
+	// directories collected from the containers after the checks finish.
+	Artifacts map[string][]string `toml:"artifacts"`
+	// MaxArtifactsMB is the max size, in megabytes, of the artifacts
+	// collected from a check. Defaults to 50.
+	MaxArtifactsMB int `toml:"max_artifacts_mb"`
+}
+
+// EgressConfig defines the per check egress network policy. When it's
+// enabled each check runs in its own docker network, and iptables rules only
+// allow the container to reach its target, the agent API and the CIDRs
+// explicitly allowed.
+type EgressConfig struct {
+	Enabled bool `toml:"enabled"`
+	// Iptables is the path to the iptables binary. Defaults to the iptables
+	// found in the PATH.
+	Iptables string `toml:"iptables"`
+	// AllowCIDRs contains the CIDRs all the checks can reach, e.g.: the DNS
+	// resolvers.
+	AllowCIDRs []string `toml:"allow_cidrs"`
+	// UnrestrictedAssetTypes contains the asset types whose targets are not
+	// network addresses, so the checks for them run without restrictions.
+	// Defaults to DockerImage, GitRepository, AWSAccount and GCPProject.
+	UnrestrictedAssetTypes []string `toml:"unrestricted_asset_types"`
+}
+
+// SecurityConfig defines the hardening applied to the containers of the
+// checks when it's enabled: all the capabilities are dropped except the ones
+// allowed, the processes can't gain new privileges and the root filesystem is
+// read-only, with tmpfs mounts for the scratch directories. The profile can be
+// overridden per checktype, by name.
+type SecurityConfig struct {
+	Enabled bool `toml:"enabled"`
+	SecurityProfile
+	Checktypes map[string]SecurityProfile `toml:"checktypes"`
+}
+
+// SecurityProfile defines the security settings of the containers of the
+// checks. The settings not defined in the profile of a checktype are taken
+// from the default profile.
+type SecurityProfile struct {
+	// CapAdd contains the capabilities allowed to the checks.
+	CapAdd []string `toml:"cap_add"`
+	// Tmpfs contains the paths where a tmpfs is mounted. Defaults to /tmp.
+	Tmpfs []string `toml:"tmpfs"`
+	// Seccomp is the path to a seccomp profile in JSON format.
+	Seccomp string `toml:"seccomp"`
+	// AppArmor is the name of an AppArmor profile loaded in the host.
+	AppArmor string `toml:"apparmor"`
 }
 
 type Auth struct {
 	Server string `toml:"server"`
 	User   string `toml:"user"`
-	Pass   string `toml:"pass"`
+	Pass   string `toml:"pass" secret:"true"`
 }
 
 // RegistryConfig defines the configuration for the Docker registry.
@@ -104,11 +792,114 @@ type RegistryConfig struct {
 	Auths               []Auth     `toml:"auths"`
 	Server              string     `toml:"server"`
 	User                string     `toml:"user"`
-	Pass                string     `toml:"pass"`
+	Pass                string     `toml:"pass" secret:"true"`
 	BackoffInterval     int        `toml:"backoff_interval"`
 	BackoffMaxRetries   int        `toml:"backoff_max_retries"`
 	BackoffJitterFactor float64    `toml:"backoff_jitter_factor"`
 	PullPolicy          PullPolicy `toml:"pull_policy"`
+	// PrePullImages contains the checktype images the agent pulls, in
+	// parallel, when it starts.
+	PrePullImages []string `toml:"pre_pull_images"`
+	// PrePullEndpoint defines an optional http endpoint returning a json
+	// array with the checktype images to pull when the agent starts.
+	PrePullEndpoint string `toml:"pre_pull_endpoint"`
+	// PrePullConcurrency is the maximum number of images pulled at the same
+	// time when pre-pulling. Defaults to 4.
+	PrePullConcurrency int `toml:"pre_pull_concurrency"`
+}
+
+// ExecConfig defines the configuration for the backend that runs the checks as
+// processes of the host.
+type ExecConfig struct {
+	// ChecksDir is the directory containing the binaries of the checktypes.
+	// The binary of a checktype is looked for first as
+	// <checks_dir>/<name>-<version> and then as <checks_dir>/<name>, where
+	// name is the last element of the checktype name.
+	ChecksDir string `toml:"checks_dir"`
+	// InheritEnv makes the checks inherit the environment of the agent, not
+	// only the PATH.
+	InheritEnv bool `toml:"inherit_env"`
+}
+
+// ContainerdConfig defines the configuration for the backend that runs the
+// checks in containerd, without the Docker daemon, using its ctr command line
+// tool. The credentials of the registries and the pull policy are read from
+// the config of the docker runtime.
+type ContainerdConfig struct {
+	// Address is the address of the containerd socket. Defaults to
+	// /run/containerd/containerd.sock.
+	Address string `toml:"address"`
+	// Namespace is the containerd namespace where the images are pulled and
+	// the checks are run. Defaults to vulcan.
+	Namespace string `toml:"namespace"`
+	// Ctr is the path to the ctr binary. Defaults to the ctr found in the
+	// PATH.
+	Ctr string `toml:"ctr"`
+}
+
+// NomadConfig defines the configuration for the backend that runs the checks
+// as dispatched jobs of parameterized batch jobs in a Nomad cluster. The
+// credentials of the registries and the pull policy are read from the config
+// of the docker runtime.
+type NomadConfig struct {
+	// Address is the address of the Nomad HTTP API. Defaults to
+	// http://127.0.0.1:4646.
+	Address string `toml:"address"`
+	// Token is the ACL token sent to the Nomad API, if any.
+	Token     string `toml:"token" secret:"true"`
+	Namespace string `toml:"namespace"`
+	Region    string `toml:"region"`
+	// Datacenters are the datacenters where the checks can run. Defaults to
+	// all of them.
+	Datacenters []string `toml:"datacenters"`
+	// Driver is the task driver used to run the checks. Defaults to docker.
+	Driver string `toml:"driver"`
+	// CPU, in MHz, and Memory, in MB, are the resources reserved for each
+	// check. Default to 500 and 512.
+	CPU    int `toml:"cpu"`
+	Memory int `toml:"memory"`
+	// PollInterval is the time, in seconds, between the queries of the
+	// state of the checks. Defaults to 2.
+	PollInterval int `toml:"poll_interval"`
+	// CACert, ClientCert and ClientKey are the paths of the files used to
+	// connect to the Nomad API with TLS.
+	CACert     string `toml:"ca_cert"`
+	ClientCert string `toml:"client_cert"`
+	ClientKey  string `toml:"client_key"`
+}
+
+// FaultyConfig defines the failures injected by the faulty backend, see the
+// backend/faulty package, in the checks run by the backend of the agent. It
+// must only be enabled to test the resilience of the agent.
+type FaultyConfig struct {
+	Enabled bool `toml:"enabled"`
+	// Default defines the failures injected in the checks of the
+	// checktypes not defined in Checktypes.
+	Default FaultsConfig `toml:"default"`
+	// Checktypes defines the failures injected in the checks of each
+	// checktype, by name.
+	Checktypes map[string]FaultsConfig `toml:"checktypes"`
+	// Seed of the random failures. If it's 0 the failures are different in
+	// each execution of the agent.
+	Seed int64 `toml:"seed"`
+}
+
+// FaultsConfig defines the probabilities, between 0 and 1, of the failures
+// injected in a check.
+type FaultsConfig struct {
+	// PullError is the probability that the check fails to start as if its
+	// image couldn't be pulled.
+	PullError float64 `toml:"pull_error"`
+	// ExitCode is the probability that the check finishes with a random
+	// exit code different from 0.
+	ExitCode float64 `toml:"exit_code"`
+	// Hang is the probability that the check never finishes, even when it's
+	// aborted or it times out.
+	Hang float64 `toml:"hang"`
+	// SlowLogs is the probability that the logs of the check are returned
+	// SlowLogsDelay seconds after it finishes.
+	SlowLogs      float64 `toml:"slow_logs"`
+	SlowLogsDelay int     `toml:"slow_logs_delay"`
 }
 
 // KubernetesConfig defines the configuration for the Kubernetes runtime environment.
@@ -135,7 +926,231 @@ type ContextConfig struct {
 // CredentialsConfig defines the configuration for the Kubernetes user.
 type CredentialsConfig struct {
 	Name  string `toml:"name"`
-	Token string `toml:"token"`
+	Token string `toml:"token" secret:"true"`
+}
+
+// ResultCacheConfig defines the configuration of the cache of check results.
+type ResultCacheConfig struct {
+	Enabled bool `toml:"enabled"`
+	// TTL defines, in seconds, the time the result of a check is reused.
+	TTL int `toml:"ttl"`
+	// Redis defines the address of the Redis server used to store the
+	// results. If it's empty the results are stored in memory.
+	Redis         string `toml:"redis"`
+	RedisPassword string `toml:"redis_password" secret:"true"`
+	RedisDB       int    `toml:"redis_db"`
+}
+
+// PostProcessingConfig defines the pipeline of processors applied, in order,
+// to the reports of the checks before uploading them.
+type PostProcessingConfig struct {
+	// Processors contains the names of the processors applied, in order.
+	Processors []string `toml:"processors"`
+	// SeverityOverrides contains the score of the vulnerabilities, by
+	// summary, used by the severity_overrides processor.
+	SeverityOverrides map[string]float32 `toml:"severity_overrides"`
+	// MetadataTags contains the keys of the metadata of the checks added as
+	// labels to the vulnerabilities by the metadata_tags processor.
+	MetadataTags []string `toml:"metadata_tags"`
+}
+
+// ScheduleConfig defines a check that the agent generates and runs
+// periodically according to a cron expression.
+type ScheduleConfig struct {
+	Cron         string            `toml:"cron"`
+	Image        string            `toml:"image"`
+	Target       string            `toml:"target"`
+	AssetType    string            `toml:"assettype"`
+	Options      string            `toml:"options"`
+	Timeout      int               `toml:"timeout"`
+	RequiredVars []string          `toml:"required_vars"`
+	Metadata     map[string]string `toml:"metadata"`
+}
+
+// SecretsConfig defines the configuration of the providers used to resolve
+// the references to secrets in the check vars and the registry passwords.
+type SecretsConfig struct {
+	// RefreshInterval defines, in seconds, how often the secrets are resolved
+	// again. 0 means they are only resolved when the agent starts or reloads
+	// its config.
+	RefreshInterval int `toml:"refresh_interval"`
+	// VaultAddress is the address of the Vault server. If it's empty the
+	// VAULT_ADDR environment variable is used, if it's also empty the vault
+	// references can't be resolved.
+	VaultAddress string `toml:"vault_address"`
+	// VaultToken is the token used to authenticate to Vault. If it's empty
+	// the VAULT_TOKEN environment variable is used.
+	VaultToken             string `toml:"vault_token" secret:"true"`
+	SecretsManagerEndpoint string `toml:"secrets_manager_endpoint"`
+	SSMEndpoint            string `toml:"ssm_endpoint"`
+}
+
+// NotificationsConfig defines where the agent sends the notifications about
+// the lifecycle of the checks and the agent.
+type NotificationsConfig struct {
+	Webhooks []WebhookConfig `toml:"webhooks"`
+	Chats    []ChatConfig    `toml:"chats"`
+	// DegradedThreshold is the number of consecutive checks that must fail
+	// to start, e.g. because their images can't be pulled, to consider the
+	// agent degraded.
+	DegradedThreshold int `toml:"degraded_threshold"`
+}
+
+// WebhookConfig defines a URL the notifications are posted to.
+type WebhookConfig struct {
+	URL string `toml:"url"`
+	// Secret, if not empty, is used to sign the requests, see the notify
+	// package.
+	Secret string `toml:"secret" secret:"true"`
+	// Events defines the types of the events sent to the webhook. If it's
+	// empty all the events are sent.
+	Events        []string `toml:"events"`
+	Timeout       int      `toml:"timeout"`
+	Retries       int      `toml:"retries"`
+	RetryInterval int      `toml:"retry_interval"`
+}
+
+// ChatConfig defines a Slack or MS Teams incoming webhook the summaries of
+// the notifications are posted to.
+type ChatConfig struct {
+	// Type is "slack" or "teams".
+	Type string `toml:"type"`
+	URL  string `toml:"url" secret:"true"`
+	// MinSeverity is the minimum severity of the events sent to the chat:
+	// "info", "warning", "error" or "critical". Defaults to "error".
+	MinSeverity   string `toml:"min_severity"`
+	Timeout       int    `toml:"timeout"`
+	Retries       int    `toml:"retries"`
+	RetryInterval int    `toml:"retry_interval"`
+}
+
+// SpoolConfig defines the local directory where the state updates and the
+// results of the checks are persisted until they are sent.
+type SpoolConfig struct {
+	// Dir is the directory of the spool. If it's empty the spool is
+	// disabled.
+	Dir string `toml:"dir"`
+	// ReplayInterval defines, in seconds, how often the agent tries to send
+	// the pending entries of the spool.
+	ReplayInterval int `toml:"replay_interval"`
+}
+
+// LifecycleConfig defines how the agent reacts to the termination notices of
+// the EC2 instance where it runs.
+type LifecycleConfig struct {
+	// Enabled makes the agent to watch the instance metadata for spot
+	// interruptions and auto scaling group terminations and drain when
+	// they are noticed.
+	Enabled bool `toml:"enabled"`
+	// PollInterval defines, in seconds, how often the metadata is polled.
+	PollInterval int `toml:"poll_interval"`
+	// RequeueMargin is the time, in seconds, before the termination of the
+	// instance when the checks still running are stopped to be run again by
+	// other agents.
+	RequeueMargin int `toml:"requeue_margin"`
+	// AutoScalingGroup and LifecycleHook define the lifecycle hook that is
+	// completed when the agent finishes draining.
+	AutoScalingGroup string `toml:"autoscaling_group"`
+	LifecycleHook    string `toml:"lifecycle_hook"`
+	Region           string `toml:"region"`
+	MetadataEndpoint string `toml:"metadata_endpoint"`
+}
+
+// HeartbeatConfig defines where the agent reports periodically its identity
+// and state. The heartbeats are disabled if neither an endpoint nor a
+// DynamoDB table are defined.
+type HeartbeatConfig struct {
+	// AgentID identifies the agent, it defaults to the hostname.
+	AgentID string `toml:"agent_id"`
+	// Interval defines, in seconds, how often the heartbeats are sent.
+	Interval int `toml:"interval"`
+	// Endpoint is the URL the heartbeats are posted to.
+	Endpoint string `toml:"endpoint"`
+	// DynamoDBTable is the table the heartbeats are written to.
+	DynamoDBTable    string `toml:"dynamodb_table"`
+	DynamoDBRegion   string `toml:"dynamodb_region"`
+	DynamoDBEndpoint string `toml:"dynamodb_endpoint"`
+	// TTL defines, in seconds, the time after which the heartbeats written
+	// to DynamoDB expire. It defaults to three times the interval.
+	TTL int `toml:"ttl"`
+}
+
+// DiagnosticsConfig defines the server that exposes the pprof profiles and
+// the expvar variables of the agent.
+type DiagnosticsConfig struct {
+	// Port is the address the diagnostics server listens on. The server is
+	// disabled when it's empty.
+	Port string `toml:"port"`
+}
+
+// CloudWatchConfig defines the publication of the metrics of the agent to
+// AWS CloudWatch.
+type CloudWatchConfig struct {
+	Enabled   bool   `toml:"enabled"`
+	Namespace string `toml:"namespace"`
+	// Interval defines, in seconds, how often the metrics are published.
+	Interval int `toml:"interval"`
+	// Dimensions are added to all the metrics, e.g. the AutoScalingGroupName
+	// of the agent, so the metrics of a group of agents can be aggregated.
+	Dimensions  map[string]string    `toml:"dimensions"`
+	Region      string               `toml:"region"`
+	Endpoint    string               `toml:"endpoint"`
+	Credentials AWSCredentialsConfig `toml:"credentials"`
+}
+
+// AuditConfig defines where the agent writes the audit log of the jobs it
+// runs. The audit log is disabled if neither a path nor a URL are defined.
+type AuditConfig struct {
+	// Path is the file the records are appended to.
+	Path string `toml:"path"`
+	// URL is the endpoint the records are posted to.
+	URL string `toml:"url"`
+	// Key is used to sign the records, see the audit package.
+	Key           string `toml:"key" secret:"true"`
+	Timeout       int    `toml:"timeout"`
+	Retries       int    `toml:"retries"`
+	RetryInterval int    `toml:"retry_interval"`
+}
+
+// Enabled returns true if the audit log has a destination.
+func (c AuditConfig) Enabled() bool {
+	return c.Path != "" || c.URL != ""
+}
+
+// MessageAuthConfig defines how the agent authenticates the messages of the
+// jobs it receives and signs the state updates it sends, see the msgauth
+// package.
+type MessageAuthConfig struct {
+	// Type is "hmac" or "jwt". If it's empty the messages are not
+	// authenticated.
+	Type string `toml:"type"`
+	// Key is the key of the HMAC signatures or the secret of the JWTs signed
+	// with HS256.
+	Key string `toml:"key" secret:"true"`
+	// PublicKey is the public key, in PEM format, of the JWTs signed with
+	// RS256 or ES256.
+	PublicKey string `toml:"public_key"`
+	// Issuer and Audience, if not empty, must match the "iss" and "aud"
+	// claims of the JWTs.
+	Issuer   string `toml:"issuer"`
+	Audience string `toml:"audience"`
+	// SigningKey, if not empty, is used to sign the state updates with
+	// HMAC-SHA256.
+	SigningKey string `toml:"signing_key" secret:"true"`
+}
+
+// SelfUpdateConfig defines how the agent updates itself to the new versions
+// described in a release manifest, see the selfupdate package. The
+// self-update is disabled when the ManifestURL is empty.
+type SelfUpdateConfig struct {
+	// ManifestURL is the http, https or s3 URL of the manifest. It can
+	// contain the placeholders {os} and {arch}.
+	ManifestURL string `toml:"manifest_url"`
+	// PublicKey is the base64 encoded ed25519 key whose signature the
+	// binaries of the releases must have.
+	PublicKey string `toml:"public_key"`
+	// Interval defines, in seconds, how often the manifest is checked.
+	Interval int `toml:"interval"`
 }
 
 // DatadogConfig defines the configuration for DataDog.
@@ -144,15 +1159,24 @@ type DatadogConfig struct {
 	Statsd  string `toml:"dogstatsd"`
 }
 
-// ReadConfig reads and parses a configuration file.
+// ReadConfig reads and parses a configuration file in any of the formats
+// supported, see FormatOf. The params not present in the file take their
+// default values, see Defaults. The params defined in the environment,
+// see ApplyEnv, override the ones in the file. If the configFile is empty the
+// config is built only from the defaults and the environment.
 func ReadConfig(configFile string) (Config, error) {
-	configData, err := ioutil.ReadFile(configFile)
-	if err != nil {
-		return Config{}, err
+	config := Defaults()
+	if configFile != "" {
+		configData, err := ioutil.ReadFile(configFile)
+		if err != nil {
+			return Config{}, err
+		}
+		if _, err := decode(configData, FormatOf(configFile), &config); err != nil {
+			return Config{}, err
+		}
 	}
 
-	var config Config
-	if _, err := toml.Decode(string(configData), &config); err != nil {
+	if err := ApplyEnv(&config); err != nil {
 		return Config{}, err
 	}
 
@@ -174,7 +1198,7 @@ var pullPolicyStrings = map[PullPolicy]string{
 }
 
 // MarshalText returns string representation of a PullPolicy instance.
-func (a *PullPolicy) MarshalText() (text []byte, err error) {
+func (a PullPolicy) MarshalText() (text []byte, err error) {
 	s, err := a.String()
 	if err != nil {
 		return nil, err
@@ -0,0 +1,37 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package config
+
+import "reflect"
+
+// Diff returns the dotted keys of the params with different values in the
+// given configs, for instance: agent.log_level. Maps and lists are compared
+// as a whole, so a change in check.vars is returned as "check.vars".
+func Diff(a, b Config) []string {
+	return diff(reflect.ValueOf(a), reflect.ValueOf(b), "")
+}
+
+func diff(a, b reflect.Value, key string) []string {
+	if a.Kind() != reflect.Struct {
+		if reflect.DeepEqual(a.Interface(), b.Interface()) {
+			return nil
+		}
+		return []string{key}
+	}
+	var keys []string
+	t := a.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := fieldKey(f)
+		if key != "" {
+			name = key + "." + name
+		}
+		keys = append(keys, diff(a.Field(i), b.Field(i), name)...)
+	}
+	return keys
+}
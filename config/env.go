@@ -0,0 +1,216 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package config
+
+import (
+	"encoding"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// EnvPrefix is the prefix of the environment variables that override the
+// config params.
+const EnvPrefix = "VULCAN_AGENT_"
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// EnvName returns the name of the environment variable that overrides the
+// config param with the given dotted key, for instance: agent.log_level is
+// overridden by VULCAN_AGENT_AGENT_LOG_LEVEL.
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+}
+
+// ApplyEnv overrides the params of the given config with the values defined
+// in the environment. Strings, numbers and booleans are taken literally.
+// Lists of strings can be defined as comma separated values. The rest of the
+// params, like maps or lists of tables, must be defined as TOML values, e.g.:
+// VULCAN_AGENT_AGENT_CHECK_COSTS='{ vulcan-nessus = 4 }'. Additionally, every
+// entry of the maps whose keys are names of environment variables, the ones
+// with the tag env:"entries", can be set using its own variable, for
+// instance: VULCAN_AGENT_CHECK_VARS_GITHUB_TOKEN sets the check var
+// GITHUB_TOKEN. The keys of the other maps, e.g. the names of the checktypes,
+// can't be written in the name of a variable, so setting one of their
+// entries in its own variable returns an error.
+func ApplyEnv(cfg *Config) error {
+	env := map[string]string{}
+	for _, kv := range os.Environ() {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 && strings.HasPrefix(parts[0], EnvPrefix) {
+			env[parts[0]] = parts[1]
+		}
+	}
+	params := map[string]bool{}
+	envNames(reflect.TypeOf(*cfg), "", params)
+	return applyEnv(reflect.ValueOf(cfg).Elem(), "", "", env, params)
+}
+
+// envNames adds to names the names of the variables that override the params
+// of the given type and of all the params nested in them.
+func envNames(t reflect.Type, key string, names map[string]bool) {
+	if key != "" {
+		names[EnvName(key)] = true
+	}
+	if t.Kind() != reflect.Struct || reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := fieldKey(f)
+		if key != "" {
+			name = key + "." + name
+		}
+		envNames(f.Type, name, names)
+	}
+}
+
+// applyEnv sets the params of v, whose key is the given one, defined in env.
+// The tag is the env tag of the param and params contains the names of the
+// variables of all the params of the config.
+func applyEnv(v reflect.Value, key, tag string, env map[string]string, params map[string]bool) error {
+	if key != "" {
+		name := EnvName(key)
+		if val, ok := env[name]; ok {
+			if err := setEnvValue(v, val); err != nil {
+				return fmt.Errorf("invalid value in %s: %w", name, err)
+			}
+		}
+		if v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String {
+			return applyEnvEntries(v, name, tag == "entries", env, params)
+		}
+	}
+	if v.Kind() != reflect.Struct || v.Addr().Type().Implements(textUnmarshalerType) {
+		return nil
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := fieldKey(f)
+		if key != "" {
+			name = key + "." + name
+		}
+		if err := applyEnv(v.Field(i), name, f.Tag.Get("env"), env, params); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyEnvEntries sets the entries of a map defined by the variables whose
+// names are the given name of the map followed by an underscore and the key
+// of the entry, taken literally. The variables that belong to other params
+// whose names start in the same way are skipped. If entries is false, the
+// keys of the map can't be written in the names of the variables and an
+// error is returned if any entry is defined.
+func applyEnvEntries(m reflect.Value, mapName string, entries bool, env map[string]string, params map[string]bool) error {
+	prefix := mapName + "_"
+	for name, val := range env {
+		k := strings.TrimPrefix(name, prefix)
+		if k == name || k == "" || isParamEnv(name, prefix, params) {
+			continue
+		}
+		if !entries {
+			return fmt.Errorf("invalid variable %s: the entries of the map can't be set in their own variables, set the whole map in %s", name, mapName)
+		}
+		elem := reflect.New(m.Type().Elem()).Elem()
+		if err := setEnvValue(elem, val); err != nil {
+			return fmt.Errorf("invalid value in %s: %w", name, err)
+		}
+		if m.IsNil() {
+			m.Set(reflect.MakeMap(m.Type()))
+		}
+		m.SetMapIndex(reflect.ValueOf(k), elem)
+	}
+	return nil
+}
+
+// isParamEnv returns true if the variable with the given name overrides a
+// param, or an entry of a param, whose variable starts with the given prefix.
+func isParamEnv(name, prefix string, params map[string]bool) bool {
+	for p := range params {
+		if strings.HasPrefix(p, prefix) && (name == p || strings.HasPrefix(name, p+"_")) {
+			return true
+		}
+	}
+	return false
+}
+
+func setEnvValue(v reflect.Value, val string) error {
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(val))
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(val)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(val, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(val, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(val), "[") {
+			var items []string
+			for _, s := range strings.Split(val, ",") {
+				if s = strings.TrimSpace(s); s != "" {
+					items = append(items, s)
+				}
+			}
+			v.Set(reflect.ValueOf(items).Convert(v.Type()))
+			return nil
+		}
+		return decodeTOMLValue(v, val)
+	default:
+		return decodeTOMLValue(v, val)
+	}
+	return nil
+}
+
+// decodeTOMLValue decodes the given TOML value, e.g. an inline table or an
+// array, into v.
+func decodeTOMLValue(v reflect.Value, val string) error {
+	holder := reflect.New(reflect.StructOf([]reflect.StructField{{
+		Name: "V",
+		Type: v.Type(),
+		Tag:  `toml:"v"`,
+	}}))
+	if _, err := toml.Decode("v = "+val, holder.Interface()); err != nil {
+		return err
+	}
+	v.Set(holder.Elem().Field(0))
+	return nil
+}
+
+// fieldKey returns the key of a config param in the same way the TOML
+// decoder does.
+func fieldKey(f reflect.StructField) string {
+	if tag := strings.Split(f.Tag.Get("toml"), ",")[0]; tag != "" {
+		return tag
+	}
+	return strings.ToLower(f.Name)
+}
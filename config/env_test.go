@@ -0,0 +1,139 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestApplyEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    func(c *Config)
+		wantErr bool
+	}{
+		{
+			name: "Scalars",
+			env: map[string]string{
+				"VULCAN_AGENT_AGENT_LOG_LEVEL":                               "debug",
+				"VULCAN_AGENT_AGENT_CONCURRENT_JOBS":                         "5",
+				"VULCAN_AGENT_API_PORT":                                      ":9090",
+				"VULCAN_AGENT_RESULT_CACHE_ENABLED":                          "true",
+				"VULCAN_AGENT_RUNTIME_DOCKER_REGISTRY_PULL_POLICY":           "Always",
+				"VULCAN_AGENT_RUNTIME_DOCKER_REGISTRY_BACKOFF_JITTER_FACTOR": "0.5",
+			},
+			want: func(c *Config) {
+				c.Agent.LogLevel = "debug"
+				c.Agent.ConcurrentJobs = 5
+				c.API.Port = ":9090"
+				c.ResultCache.Enabled = true
+				c.Runtime.Docker.Registry.PullPolicy = PullPolicyAlways
+				c.Runtime.Docker.Registry.BackoffJitterFactor = 0.5
+			},
+		},
+		{
+			name: "Composites",
+			env: map[string]string{
+				"VULCAN_AGENT_RUNTIME_DOCKER_REGISTRY_PRE_PULL_IMAGES": "a, b",
+				"VULCAN_AGENT_AGENT_CHECK_COSTS":                       `{ vulcan-nessus = 4 }`,
+				"VULCAN_AGENT_CHECK_VARS_GITHUB_TOKEN":                 "token",
+				"VULCAN_AGENT_SCHEDULES":                               `[{ cron = "@daily", image = "img", target = "example.com" }]`,
+			},
+			want: func(c *Config) {
+				c.Runtime.Docker.Registry.PrePullImages = []string{"a", "b"}
+				c.Agent.CheckCosts = map[string]int{"vulcan-nessus": 4}
+				c.Check.Vars = map[string]string{"GITHUB_TOKEN": "token"}
+				c.Schedules = []ScheduleConfig{{Cron: "@daily", Image: "img", Target: "example.com"}}
+			},
+		},
+		{
+			name: "MapEntries",
+			env: map[string]string{
+				"VULCAN_AGENT_CHECK_VARS":                          `{ GITHUB_TOKEN = "old", NPM_TOKEN = "npm" }`,
+				"VULCAN_AGENT_CHECK_VARS_GITHUB_TOKEN":             "token",
+				"VULCAN_AGENT_CHECK_VARS_Api_Key":                  "key",
+				"VULCAN_AGENT_CHECK_DYNAMIC_VARS_DB_PASSWORD":      "vault://database/creds/readonly#password",
+				"VULCAN_AGENT_CHECK_ENV_TEMPLATES_TEAM":            "{{.Metadata.team}}",
+				"VULCAN_AGENT_RUNTIME_DOCKER_REGISTRY_PULL_POLICY": "Always",
+			},
+			want: func(c *Config) {
+				c.Check.Vars = map[string]string{"GITHUB_TOKEN": "token", "NPM_TOKEN": "npm", "Api_Key": "key"}
+				c.Check.DynamicVars = map[string]string{"DB_PASSWORD": "vault://database/creds/readonly#password"}
+				c.Check.EnvTemplates = map[string]string{"TEAM": "{{.Metadata.team}}"}
+				c.Runtime.Docker.Registry.PullPolicy = PullPolicyAlways
+			},
+		},
+		{
+			name:    "ChecktypeMapEntry",
+			env:     map[string]string{"VULCAN_AGENT_AGENT_CHECK_COSTS_VULCAN_NESSUS": "4"},
+			wantErr: true,
+		},
+		{
+			name:    "ChecktypeVarsEntry",
+			env:     map[string]string{"VULCAN_AGENT_CHECK_CHECKTYPE_VARS_VULCAN_NESSUS": `{ TOKEN = "token" }`},
+			wantErr: true,
+		},
+		{
+			name:    "InvalidValue",
+			env:     map[string]string{"VULCAN_AGENT_AGENT_TIMEOUT": "ten"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			got := Defaults()
+			err := ApplyEnv(&got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			want := Defaults()
+			tt.want(&want)
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("want config != got config, diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestApplyEnv_FieldBoundary(t *testing.T) {
+	type section struct {
+		Vars     map[string]string `toml:"vars" env:"entries"`
+		VarsFile string            `toml:"vars_file"`
+		Costs    map[string]int    `toml:"costs"`
+		CostsMax int               `toml:"costs_max"`
+	}
+	type cfg struct {
+		Section section `toml:"section"`
+	}
+	env := map[string]string{
+		"VULCAN_AGENT_SECTION_VARS_TOKEN": "token",
+		"VULCAN_AGENT_SECTION_VARS_FILE":  "/etc/vars",
+		"VULCAN_AGENT_SECTION_COSTS_MAX":  "4",
+	}
+	params := map[string]bool{}
+	envNames(reflect.TypeOf(cfg{}), "", params)
+	var got cfg
+	if err := applyEnv(reflect.ValueOf(&got).Elem(), "", "", env, params); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := cfg{Section: section{
+		Vars:     map[string]string{"TOKEN": "token"},
+		VarsFile: "/etc/vars",
+		CostsMax: 4,
+	}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("want config != got config, diff: %s", diff)
+	}
+}
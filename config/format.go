@@ -0,0 +1,168 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Formats of the config files.
+const (
+	FormatTOML = "toml"
+	FormatYAML = "yaml"
+	FormatJSON = "json"
+)
+
+// FormatOf returns the format of a config file according to its extension:
+// ".yaml" and ".yml" files are YAML, ".json" files are JSON and the rest are
+// TOML.
+func FormatOf(file string) string {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".json":
+		return FormatJSON
+	default:
+		return FormatTOML
+	}
+}
+
+// decode decodes the config data, in the given format, into cfg. The YAML and
+// JSON documents use the same keys as the TOML ones, so they are converted to
+// TOML before decoding them. The TOML documents are also converted when they
+// define vars of checktypes, see scopeCheckVars.
+func decode(data []byte, format string, cfg *Config) (toml.MetaData, error) {
+	if format != FormatTOML {
+		var err error
+		data, err = toTOML(data, format)
+		if err != nil {
+			return toml.MetaData{}, err
+		}
+		return toml.Decode(string(data), cfg)
+	}
+	doc := map[string]interface{}{}
+	if _, err := toml.Decode(string(data), &doc); err != nil {
+		return toml.MetaData{}, err
+	}
+	if scopeCheckVars(doc) {
+		var buf bytes.Buffer
+		if err := toml.NewEncoder(&buf).Encode(doc); err != nil {
+			return toml.MetaData{}, err
+		}
+		data = buf.Bytes()
+	}
+	return toml.Decode(string(data), cfg)
+}
+
+// scopeCheckVars moves the tables of the check vars, that contain the vars
+// of a checktype, e.g. [check.vars.vulcan-nessus], to check.checktype_vars,
+// so the rest of the vars can be decoded as a map of strings. It returns true
+// if the document was modified.
+func scopeCheckVars(doc map[string]interface{}) bool {
+	check, ok := doc["check"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	vars, ok := check["vars"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	scoped, ok := check["checktype_vars"].(map[string]interface{})
+	if !ok {
+		scoped = map[string]interface{}{}
+	}
+	modified := false
+	for k, v := range vars {
+		table, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		ct, ok := scoped[k].(map[string]interface{})
+		if !ok {
+			ct = map[string]interface{}{}
+			scoped[k] = ct
+		}
+		for name, value := range table {
+			ct[name] = value
+		}
+		delete(vars, k)
+		modified = true
+	}
+	if modified {
+		check["checktype_vars"] = scoped
+	}
+	return modified
+}
+
+func toTOML(data []byte, format string) ([]byte, error) {
+	doc := map[string]interface{}{}
+	switch format {
+	case FormatYAML:
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+	case FormatJSON:
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.UseNumber()
+		if err := d.Decode(&doc); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported config format %q", format)
+	}
+	doc = normalize(doc).(map[string]interface{})
+	scopeCheckVars(doc)
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(doc); err != nil {
+		return nil, fmt.Errorf("converting %s config: %w", format, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// normalize converts the values decoded from YAML or JSON documents to
+// values that can be encoded to TOML.
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := map[string]interface{}{}
+		for k, e := range v {
+			if e != nil {
+				m[k] = normalize(e)
+			}
+		}
+		return m
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for k, e := range v {
+			if e != nil {
+				m[fmt.Sprint(k)] = normalize(e)
+			}
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, 0, len(v))
+		for _, e := range v {
+			if e != nil {
+				s = append(s, normalize(e))
+			}
+		}
+		return s
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	default:
+		return v
+	}
+}
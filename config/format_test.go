@@ -0,0 +1,126 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const tomlConfig = `
+[agent]
+log_level = "debug"
+concurrent_jobs = 5
+
+[api]
+port = ":8081"
+
+[runtime.docker.registry]
+backoff_jitter_factor = 0.5
+pull_policy = "Always"
+pre_pull_images = ["vulcansec/vulcan-nessus"]
+
+[[runtime.docker.registry.auths]]
+server = "registry.example.com"
+user = "user"
+
+[check.vars]
+TOKEN = "token"
+
+[check.vars.vulcan-nessus]
+NESSUS_PASSWORD = "password"
+`
+
+const yamlConfig = `
+agent:
+  log_level: debug
+  concurrent_jobs: 5
+api:
+  port: ":8081"
+runtime:
+  docker:
+    registry:
+      backoff_jitter_factor: 0.5
+      pull_policy: Always
+      pre_pull_images:
+        - vulcansec/vulcan-nessus
+      auths:
+        - server: registry.example.com
+          user: user
+check:
+  vars:
+    TOKEN: token
+    vulcan-nessus:
+      NESSUS_PASSWORD: password
+`
+
+const jsonConfig = `{
+  "agent": {"log_level": "debug", "concurrent_jobs": 5},
+  "api": {"port": ":8081"},
+  "runtime": {
+    "docker": {
+      "registry": {
+        "backoff_jitter_factor": 0.5,
+        "pull_policy": "Always",
+        "pre_pull_images": ["vulcansec/vulcan-nessus"],
+        "auths": [{"server": "registry.example.com", "user": "user"}]
+      }
+    }
+  },
+  "check": {"vars": {"TOKEN": "token", "vulcan-nessus": {"NESSUS_PASSWORD": "password"}}}
+}`
+
+func TestReadConfig_Formats(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	want, err := ReadConfig(write("config.toml", tomlConfig))
+	if err != nil {
+		t.Fatalf("unexpected error reading toml config: %v", err)
+	}
+	wantCheck := CheckConfig{
+		Vars:          map[string]string{"TOKEN": "token"},
+		ChecktypeVars: map[string]map[string]string{"vulcan-nessus": {"NESSUS_PASSWORD": "password"}},
+	}
+	if diff := cmp.Diff(wantCheck, want.Check); diff != "" {
+		t.Errorf("want check config != got check config, diff: %s", diff)
+	}
+	for _, file := range []string{
+		write("config.yaml", yamlConfig),
+		write("config.yml", yamlConfig),
+		write("config.json", jsonConfig),
+	} {
+		got, err := ReadConfig(file)
+		if err != nil {
+			t.Fatalf("unexpected error reading %s: %v", file, err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("%s: want config != got config, diff: %s", file, diff)
+		}
+	}
+}
+
+func TestValidate_Formats(t *testing.T) {
+	want := []Issue{{Key: "agent.foo", Message: "unknown config param"}}
+	got := Validate([]byte("agent:\n  foo: 1\n"), FormatYAML)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("want issues != got issues, diff: %s", diff)
+	}
+	got = Validate([]byte(`{"agent": {"foo": 1}}`), FormatJSON)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("want issues != got issues, diff: %s", diff)
+	}
+	if got := Validate([]byte(tomlConfig), FormatTOML); len(got) > 0 {
+		t.Errorf("unexpected issues in the toml config: %v", got)
+	}
+}
@@ -0,0 +1,251 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package config
+
+import "reflect"
+
+// Default values of the config params applied by the components of the agent
+// when the params are not defined, see Resolve.
+const (
+	DefaultLogLevel               = "info"
+	DefaultMaxProcessMessageTimes = 200
+	DefaultWatchdogGrace          = 300
+	DefaultAdmissionDiskPath      = "/var/lib/docker"
+	DefaultAdmissionInterval      = 10
+	DefaultSyslogTag              = "vulcan-agent"
+	DefaultAWSSessionName         = "vulcan-agent"
+	DefaultSQSBackoffMinMs        = 1000
+	DefaultSQSMaxReceiveCount     = 5
+	DefaultPubSubEndpoint         = "https://pubsub.googleapis.com"
+	DefaultPubSubAckDeadline      = 60
+	DefaultPubSubProcessQuantum   = 45
+	DefaultServiceBusWaitTime     = 20
+	DefaultServiceBusLockRenewal  = 30
+	DefaultEventBridgeSource      = "vulcan.agent"
+	DefaultEventBridgeDetailType  = "Check State Update"
+	DefaultContainerdAddress      = "/run/containerd/containerd.sock"
+	DefaultContainerdNamespace    = "vulcan"
+	DefaultContainerdCtr          = "ctr"
+	DefaultNomadAddress           = "http://127.0.0.1:4646"
+	DefaultNomadDriver            = "docker"
+	DefaultNomadCPU               = 500
+	DefaultNomadMemory            = 512
+	DefaultNomadPollInterval      = 2
+	DefaultFaultySlowLogsDelay    = 30
+	DefaultChatSeverity           = "error"
+	DefaultDegradedThreshold      = 3
+	DefaultLifecyclePollInterval  = 5
+	defaultHTTPPort               = ":80"
+)
+
+// Resolve returns the given config with the params that are not defined set
+// to the values that the components of the agent use for them, so it
+// contains the values the agent actually runs with. The params whose zero
+// value has a meaning of its own, e.g. disabling a feature, are not changed.
+func Resolve(cfg Config) Config {
+	if cfg.Agent.LogLevel == "" {
+		cfg.Agent.LogLevel = DefaultLogLevel
+	}
+	if cfg.Agent.MaxProcessMessageTimes < 1 {
+		cfg.Agent.MaxProcessMessageTimes = DefaultMaxProcessMessageTimes
+	}
+	if cfg.Agent.WatchdogGrace < 1 {
+		cfg.Agent.WatchdogGrace = DefaultWatchdogGrace
+	}
+	if cfg.Agent.Admission.DiskPath == "" {
+		cfg.Agent.Admission.DiskPath = DefaultAdmissionDiskPath
+	}
+	if cfg.Agent.Admission.Interval < 1 {
+		cfg.Agent.Admission.Interval = DefaultAdmissionInterval
+	}
+	if cfg.Agent.Syslog.Tag == "" {
+		cfg.Agent.Syslog.Tag = DefaultSyslogTag
+	}
+	// The API listens in the default HTTP port when no port is defined.
+	if cfg.API.Port == "" {
+		cfg.API.Port = defaultHTTPPort
+	}
+	if cfg.Check.KillGrace == 0 {
+		cfg.Check.KillGrace = cfg.Check.AbortTimeout
+	}
+
+	resolveCredentials(&cfg.AWS.Credentials)
+	resolveCredentials(&cfg.Stream.AbortQueue.Credentials)
+	resolveCredentials(&cfg.Uploader.S3.Credentials)
+	resolveCredentials(&cfg.SQSWriter.Credentials)
+	resolveCredentials(&cfg.CloudWatch.Credentials)
+	resolveSQSReader(&cfg.SQSReader)
+	if len(cfg.SQSReaders) > 0 {
+		cfg.SQSReaders = append([]SQSReader(nil), cfg.SQSReaders...)
+		for i := range cfg.SQSReaders {
+			resolveSQSReader(&cfg.SQSReaders[i])
+		}
+	}
+	if len(cfg.SQSPriorityReader.Queues) > 0 {
+		cfg.SQSPriorityReader.Queues = append([]SQSReader(nil), cfg.SQSPriorityReader.Queues...)
+		for i := range cfg.SQSPriorityReader.Queues {
+			resolveSQSReader(&cfg.SQSPriorityReader.Queues[i])
+		}
+	}
+
+	if cfg.PubSubReader.Endpoint == "" {
+		cfg.PubSubReader.Endpoint = DefaultPubSubEndpoint
+	}
+	if cfg.PubSubReader.AckDeadline == 0 {
+		cfg.PubSubReader.AckDeadline = DefaultPubSubAckDeadline
+	}
+	if cfg.PubSubReader.ProcessQuantum == 0 {
+		cfg.PubSubReader.ProcessQuantum = DefaultPubSubProcessQuantum
+	}
+	if cfg.StateUpdater.PubSub.Endpoint == "" {
+		cfg.StateUpdater.PubSub.Endpoint = DefaultPubSubEndpoint
+	}
+	if cfg.ServiceBusReader.WaitTime <= 0 {
+		cfg.ServiceBusReader.WaitTime = DefaultServiceBusWaitTime
+	}
+	if cfg.ServiceBusReader.LockRenewal <= 0 {
+		cfg.ServiceBusReader.LockRenewal = DefaultServiceBusLockRenewal
+	}
+	if cfg.StateUpdater.EventBridge.Source == "" {
+		cfg.StateUpdater.EventBridge.Source = DefaultEventBridgeSource
+	}
+	if cfg.StateUpdater.EventBridge.DetailType == "" {
+		cfg.StateUpdater.EventBridge.DetailType = DefaultEventBridgeDetailType
+	}
+
+	containerd := &cfg.Runtime.Containerd
+	if containerd.Address == "" {
+		containerd.Address = DefaultContainerdAddress
+	}
+	if containerd.Namespace == "" {
+		containerd.Namespace = DefaultContainerdNamespace
+	}
+	if containerd.Ctr == "" {
+		containerd.Ctr = DefaultContainerdCtr
+	}
+	nomad := &cfg.Runtime.Nomad
+	if nomad.Address == "" {
+		nomad.Address = DefaultNomadAddress
+	}
+	if nomad.Driver == "" {
+		nomad.Driver = DefaultNomadDriver
+	}
+	if nomad.CPU == 0 {
+		nomad.CPU = DefaultNomadCPU
+	}
+	if nomad.Memory == 0 {
+		nomad.Memory = DefaultNomadMemory
+	}
+	if nomad.PollInterval == 0 {
+		nomad.PollInterval = DefaultNomadPollInterval
+	}
+	resolveFaults(&cfg.Runtime.Faulty.Default)
+	if len(cfg.Runtime.Faulty.Checktypes) > 0 {
+		checktypes := make(map[string]FaultsConfig, len(cfg.Runtime.Faulty.Checktypes))
+		for name, faults := range cfg.Runtime.Faulty.Checktypes {
+			resolveFaults(&faults)
+			checktypes[name] = faults
+		}
+		cfg.Runtime.Faulty.Checktypes = checktypes
+	}
+
+	if cfg.Notifications.DegradedThreshold < 1 {
+		cfg.Notifications.DegradedThreshold = DefaultDegradedThreshold
+	}
+	if len(cfg.Notifications.Chats) > 0 {
+		cfg.Notifications.Chats = append([]ChatConfig(nil), cfg.Notifications.Chats...)
+		for i := range cfg.Notifications.Chats {
+			if cfg.Notifications.Chats[i].MinSeverity == "" {
+				cfg.Notifications.Chats[i].MinSeverity = DefaultChatSeverity
+			}
+		}
+	}
+	if cfg.Lifecycle.PollInterval <= 0 {
+		cfg.Lifecycle.PollInterval = DefaultLifecyclePollInterval
+	}
+	return cfg
+}
+
+func resolveSQSReader(r *SQSReader) {
+	// The min backoff is only used when the backoff is enabled.
+	if r.BackoffMaxMs > 0 && r.BackoffMinMs <= 0 {
+		r.BackoffMinMs = DefaultSQSBackoffMinMs
+	}
+	if r.MaxReceiveCount < 1 {
+		r.MaxReceiveCount = DefaultSQSMaxReceiveCount
+	}
+	resolveCredentials(&r.Credentials)
+}
+
+// resolveCredentials sets the default session name of the credentials that
+// assume a role. The other credentials are not changed, because the empty
+// ones mean that the default credentials are used.
+func resolveCredentials(c *AWSCredentialsConfig) {
+	if c.RoleARN != "" && c.SessionName == "" {
+		c.SessionName = DefaultAWSSessionName
+	}
+}
+
+func resolveFaults(f *FaultsConfig) {
+	if f.SlowLogsDelay <= 0 {
+		f.SlowLogsDelay = DefaultFaultySlowLogsDelay
+	}
+}
+
+// Redacted replaces the values of the secret params in the configs returned
+// by Redact.
+const Redacted = "REDACTED"
+
+// Redact returns a copy of the given config with the values of the secret
+// params, the ones with the tag secret:"true", replaced by Redacted. The
+// secret params that are not defined are kept empty.
+func Redact(cfg Config) Config {
+	return redact(reflect.ValueOf(cfg), false).Interface().(Config)
+}
+
+// redact returns a copy of the given value with its strings replaced by
+// Redacted if it's secret, or with the strings of its secret fields replaced
+// if it's a struct. The maps and the lists are copied, so the given value is
+// never modified.
+func redact(v reflect.Value, secret bool) reflect.Value {
+	switch v.Kind() {
+	case reflect.String:
+		if secret && v.Len() > 0 {
+			return reflect.ValueOf(Redacted).Convert(v.Type())
+		}
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			c.Field(i).Set(redact(v.Field(i), secret || f.Tag.Get("secret") == "true"))
+		}
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(redact(v.Index(i), secret))
+		}
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			c.SetMapIndex(iter.Key(), redact(iter.Value(), secret))
+		}
+		return c
+	}
+	return v
+}
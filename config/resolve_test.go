@@ -0,0 +1,150 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package config
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   Config
+		check func(t *testing.T, cfg Config)
+	}{
+		{
+			name: "Empty",
+			check: func(t *testing.T, cfg Config) {
+				got := []interface{}{
+					cfg.Agent.LogLevel,
+					cfg.Agent.MaxProcessMessageTimes,
+					cfg.Agent.WatchdogGrace,
+					cfg.Agent.Admission.DiskPath,
+					cfg.API.Port,
+					cfg.Runtime.Nomad.Address,
+					cfg.Runtime.Containerd.Namespace,
+					cfg.Notifications.DegradedThreshold,
+					cfg.SQSReader.MaxReceiveCount,
+					cfg.SQSReader.BackoffMinMs,
+					cfg.AWS.Credentials.SessionName,
+				}
+				want := []interface{}{
+					DefaultLogLevel,
+					DefaultMaxProcessMessageTimes,
+					DefaultWatchdogGrace,
+					DefaultAdmissionDiskPath,
+					":80",
+					DefaultNomadAddress,
+					DefaultContainerdNamespace,
+					DefaultDegradedThreshold,
+					DefaultSQSMaxReceiveCount,
+					0,
+					"",
+				}
+				if diff := cmp.Diff(want, got); diff != "" {
+					t.Errorf("resolved params mismatch (-want +got):\n%s", diff)
+				}
+			},
+		},
+		{
+			name: "KeepsDefined",
+			cfg: Config{
+				Agent: AgentConfig{LogLevel: "debug", WatchdogGrace: 60},
+				API:   APIConfig{Port: ":8080"},
+				Check: CheckConfig{AbortTimeout: 5},
+				SQSReaders: []SQSReader{
+					{ARN: "queue1", BackoffMaxMs: 5000, MaxReceiveCount: 2},
+					{ARN: "queue2", Credentials: AWSCredentialsConfig{RoleARN: "role"}},
+				},
+				Notifications: NotificationsConfig{
+					Chats: []ChatConfig{{Type: "slack"}, {Type: "teams", MinSeverity: "info"}},
+				},
+			},
+			check: func(t *testing.T, cfg Config) {
+				want := Config{
+					Agent: AgentConfig{LogLevel: "debug", WatchdogGrace: 60},
+					API:   APIConfig{Port: ":8080"},
+					Check: CheckConfig{AbortTimeout: 5, KillGrace: 5},
+					SQSReaders: []SQSReader{
+						{ARN: "queue1", BackoffMaxMs: 5000, BackoffMinMs: DefaultSQSBackoffMinMs, MaxReceiveCount: 2},
+						{ARN: "queue2", MaxReceiveCount: DefaultSQSMaxReceiveCount, Credentials: AWSCredentialsConfig{RoleARN: "role", SessionName: DefaultAWSSessionName}},
+					},
+					Notifications: NotificationsConfig{
+						Chats: []ChatConfig{{Type: "slack", MinSeverity: DefaultChatSeverity}, {Type: "teams", MinSeverity: "info"}},
+					},
+				}
+				got := Config{
+					Agent:         AgentConfig{LogLevel: cfg.Agent.LogLevel, WatchdogGrace: cfg.Agent.WatchdogGrace},
+					API:           APIConfig{Port: cfg.API.Port},
+					Check:         cfg.Check,
+					SQSReaders:    cfg.SQSReaders,
+					Notifications: NotificationsConfig{Chats: cfg.Notifications.Chats},
+				}
+				if diff := cmp.Diff(want, got); diff != "" {
+					t.Errorf("resolved params mismatch (-want +got):\n%s", diff)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orig := Config{}
+			if tt.cfg.SQSReaders != nil {
+				orig.SQSReaders = append([]SQSReader(nil), tt.cfg.SQSReaders...)
+			}
+			tt.check(t, Resolve(tt.cfg))
+			if diff := cmp.Diff(orig.SQSReaders, tt.cfg.SQSReaders); diff != "" {
+				t.Errorf("given config modified (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRedact(t *testing.T) {
+	cfg := Config{
+		Agent: AgentConfig{LogLevel: "debug"},
+		API:   APIConfig{Auth: APIAuthConfig{Token: "api-token", TLSPort: ":8443"}},
+		Check: CheckConfig{Vars: map[string]string{"API_KEY": "key"}},
+		AWS:   AWSConfig{Credentials: AWSCredentialsConfig{AccessKeyID: "id", SecretAccessKey: "secret"}},
+		Secrets: SecretsConfig{
+			VaultAddress: "https://vault.example.com",
+		},
+		Notifications: NotificationsConfig{
+			Chats:    []ChatConfig{{Type: "slack", URL: "https://hooks.slack.com/services/secret"}},
+			Webhooks: []WebhookConfig{{URL: "https://example.com", Secret: "webhook-secret"}},
+		},
+		MessageAuth: MessageAuthConfig{Type: "hmac", Key: "hmac-key", SigningKey: "signing-key"},
+	}
+	got := Redact(cfg)
+
+	want := cfg
+	want.API.Auth.Token = Redacted
+	want.Check.Vars = map[string]string{"API_KEY": Redacted}
+	want.AWS.Credentials.SecretAccessKey = Redacted
+	want.Notifications.Chats = []ChatConfig{{Type: "slack", URL: Redacted}}
+	want.Notifications.Webhooks = []WebhookConfig{{URL: "https://example.com", Secret: Redacted}}
+	want.MessageAuth.Key = Redacted
+	want.MessageAuth.SigningKey = Redacted
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("redacted config mismatch (-want +got):\n%s", diff)
+	}
+	if cfg.Check.Vars["API_KEY"] != "key" || cfg.Notifications.Chats[0].URL == Redacted {
+		t.Errorf("given config modified")
+	}
+
+	var b bytes.Buffer
+	if err := Encode(&b, got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, secret := range []string{"api-token", "secret", "hmac-key", "signing-key", "hooks.slack.com"} {
+		if strings.Contains(b.String(), `"`+secret) {
+			t.Errorf("secret %q printed", secret)
+		}
+	}
+}
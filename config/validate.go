@@ -0,0 +1,218 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Default values of the config params.
+const (
+	DefaultPrePullConcurrency     = 4
+	DefaultAPIPort                = ":8080"
+	DefaultAPITLSPort             = ":8443"
+	DefaultResultCacheTTL         = 3600
+	DefaultPriorityReaderStrategy = "strict"
+	DefaultSpoolReplayInterval    = 30
+	DefaultHeartbeatInterval      = 30
+	DefaultBackend                = BackendDocker
+	DefaultDockerHealthInterval   = 10
+	DefaultBreakerProbeInterval   = 30
+	DefaultCloudWatchNamespace    = "Vulcan/Agent"
+	DefaultCloudWatchInterval     = 60
+	DefaultCheckLogsLevel         = "debug"
+	DefaultCheckLogsMaxSizeMB     = 10
+	DefaultCheckLogsMaxBackups    = 1
+	DefaultCheckLogsMaxAge        = 168
+	DefaultSelfUpdateInterval     = 3600
+)
+
+var reTypeErrKey = regexp.MustCompile(`TOML key "([^"]*)"`)
+
+// Issue describes a problem found when validating a config file.
+type Issue struct {
+	// Line where the issue was found, 0 if unknown.
+	Line    int
+	Key     string
+	Message string
+}
+
+func (i Issue) String() string {
+	var b strings.Builder
+	if i.Line > 0 {
+		fmt.Fprintf(&b, "line %d: ", i.Line)
+	}
+	if i.Key != "" {
+		fmt.Fprintf(&b, "%s: ", i.Key)
+	}
+	b.WriteString(i.Message)
+	return b.String()
+}
+
+// Defaults returns a Config filled with the default values of the config
+// params that ReadConfig applies. Those are only the params added after the
+// first versions of the agent: the older params keep their zero values, that
+// the components using them already handle, so the existing config files are
+// read as before. The same applies to the params whose defaults are defined
+// by the components that use them, e.g. the ones of the job runner.
+func Defaults() Config {
+	return Config{
+		Agent: AgentConfig{
+			CheckLogs: CheckLogsConfig{
+				Level:      DefaultCheckLogsLevel,
+				MaxSizeMB:  DefaultCheckLogsMaxSizeMB,
+				MaxBackups: DefaultCheckLogsMaxBackups,
+				MaxAge:     DefaultCheckLogsMaxAge,
+			},
+		},
+		Uploader: UploaderConfig{
+			Type:                 UploaderTypeHTTP,
+			BreakerProbeInterval: DefaultBreakerProbeInterval,
+		},
+		StateUpdater: StateUpdaterConfig{
+			Backend: StateBackendSQS,
+		},
+		API: APIConfig{
+			Auth: APIAuthConfig{
+				TLSPort: DefaultAPITLSPort,
+			},
+		},
+		Runtime: RuntimeConfig{
+			Backend: DefaultBackend,
+			Docker: DockerConfig{
+				HealthInterval: DefaultDockerHealthInterval,
+				Registry: RegistryConfig{
+					PrePullConcurrency: DefaultPrePullConcurrency,
+				},
+			},
+		},
+		SQSPriorityReader: SQSPriorityReader{
+			Strategy: DefaultPriorityReaderStrategy,
+		},
+		ResultCache: ResultCacheConfig{
+			TTL: DefaultResultCacheTTL,
+		},
+		Spool: SpoolConfig{
+			ReplayInterval: DefaultSpoolReplayInterval,
+		},
+		Heartbeat: HeartbeatConfig{
+			Interval: DefaultHeartbeatInterval,
+		},
+		CloudWatch: CloudWatchConfig{
+			Namespace: DefaultCloudWatchNamespace,
+			Interval:  DefaultCloudWatchInterval,
+		},
+		SelfUpdate: SelfUpdateConfig{
+			Interval: DefaultSelfUpdateInterval,
+		},
+	}
+}
+
+// Validate parses the given config file contents, in the given format, and
+// returns the issues found: syntax errors, params with a wrong type and
+// unknown params. The lines of the issues are only reported for TOML files.
+func Validate(data []byte, format string) []Issue {
+	cfg := Defaults()
+	md, err := decode(data, format, &cfg)
+	if err != nil {
+		issue := decodeIssue(data, err)
+		if format != FormatTOML {
+			issue.Line = 0
+		}
+		return []Issue{issue}
+	}
+	var issues []Issue
+	for _, k := range md.Undecoded() {
+		var line int
+		if format == FormatTOML {
+			line = keyLine(data, k.String())
+		}
+		issues = append(issues, Issue{
+			Line:    line,
+			Key:     k.String(),
+			Message: "unknown config param",
+		})
+	}
+	return issues
+}
+
+// Encode writes the given config to the writer in TOML format.
+func Encode(w io.Writer, cfg Config) error {
+	return toml.NewEncoder(w).Encode(cfg)
+}
+
+func decodeIssue(data []byte, err error) Issue {
+	var perr toml.ParseError
+	if errors.As(err, &perr) {
+		msg := perr.Message
+		if msg == "" {
+			msg = perr.Error()
+		}
+		return Issue{Line: perr.Position.Line, Key: perr.LastKey, Message: msg}
+	}
+	issue := Issue{Message: err.Error()}
+	if m := reTypeErrKey.FindStringSubmatch(err.Error()); m != nil {
+		issue.Key = m[1]
+		issue.Line = keyLine(data, m[1])
+	}
+	return issue
+}
+
+// keyLine returns the line where the given dotted key is defined, or 0 if it
+// can't be found. It only understands the subset of TOML used by the agent
+// config files: tables, arrays of tables and key/value pairs.
+func keyLine(data []byte, key string) int {
+	var (
+		table string
+		n     int
+	)
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		n++
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			name := strings.Trim(line, "[] ")
+			if i := strings.Index(name, "]"); i >= 0 {
+				name = strings.TrimSpace(name[:i])
+			}
+			table = unquoteKey(name)
+			if table == key {
+				return n
+			}
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i < 0 {
+			continue
+		}
+		k := unquoteKey(strings.TrimSpace(line[:i]))
+		if table != "" {
+			k = table + "." + k
+		}
+		if k == key {
+			return n
+		}
+	}
+	return 0
+}
+
+func unquoteKey(k string) string {
+	parts := strings.Split(k, ".")
+	for i, p := range parts {
+		parts[i] = strings.Trim(strings.TrimSpace(p), `"'`)
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []Issue
+	}{
+		{
+			name: "Valid",
+			data: "[agent]\nlog_level = \"debug\"\n[check.vars]\nFOO = \"bar\"\n",
+		},
+		{
+			name: "UnknownParams",
+			data: "[agent]\nlog_level = \"debug\"\nfoo = 1\n\n[api]\n  \"bar\" = true\n",
+			want: []Issue{
+				{Line: 3, Key: "agent.foo", Message: "unknown config param"},
+				{Line: 6, Key: "api.bar", Message: "unknown config param"},
+			},
+		},
+		{
+			name: "WrongType",
+			data: "[agent]\n\nconcurrent_jobs = \"5\"\n",
+			want: []Issue{
+				{
+					Line:    3,
+					Key:     "agent.concurrent_jobs",
+					Message: `toml: incompatible types: TOML key "agent.concurrent_jobs" has type string; destination has type integer`,
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Validate([]byte(tt.data), FormatTOML)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("want issues != got issues, diff: %s", diff)
+			}
+		})
+	}
+}
+
+// baselineConfig contains only params supported by the first versions of the
+// agent, leaving out the ones that have default values in the components
+// that use them.
+const baselineConfig = `
+[agent]
+log_file = "agent.log"
+concurrent_jobs = 5
+
+[uploader]
+endpoint = "http://vulcan-results.example.com/v1/"
+timeout = 10
+
+[stream]
+endpoint = "ws://vulcan-stream.example.com/stream"
+
+[sqs_reader]
+arn = "arn:aws:sqs:region:account:checks"
+visibility_timeout = 60
+polling_interval = 10
+
+[sqs_writer]
+arn = "arn:aws:sqs:region:account:checks-status"
+
+[api]
+host = "host.docker.internal"
+
+[check]
+abort_timeout = 60
+
+[runtime.docker.registry]
+backoff_interval = 5
+`
+
+func TestReadConfig_Baseline(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(file, []byte(baselineConfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadConfig(file)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The config as it was read before the defaults were applied.
+	var want Config
+	if _, err := toml.Decode(baselineConfig, &want); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The params added later, with their own defaults, are not compared.
+	opts := cmpopts.IgnoreFields(Config{},
+		"Agent.CheckLogs",
+		"Uploader.Type",
+		"Uploader.BreakerProbeInterval",
+		"API.Auth",
+		"Runtime.Docker.Registry.PrePullConcurrency",
+	)
+	sections := []struct {
+		name      string
+		want, got Config
+	}{
+		{"agent", Config{Agent: want.Agent}, Config{Agent: got.Agent}},
+		{"uploader", Config{Uploader: want.Uploader}, Config{Uploader: got.Uploader}},
+		{"stream", Config{Stream: want.Stream}, Config{Stream: got.Stream}},
+		{"sqs_reader", Config{SQSReader: want.SQSReader}, Config{SQSReader: got.SQSReader}},
+		{"sqs_writer", Config{SQSWriter: want.SQSWriter}, Config{SQSWriter: got.SQSWriter}},
+		{"api", Config{API: want.API}, Config{API: got.API}},
+		{"check", Config{Check: want.Check}, Config{Check: got.Check}},
+		{"registry", Config{Runtime: RuntimeConfig{Docker: DockerConfig{Registry: want.Runtime.Docker.Registry}}},
+			Config{Runtime: RuntimeConfig{Docker: DockerConfig{Registry: got.Runtime.Docker.Registry}}}},
+		{"datadog", Config{DataDog: want.DataDog}, Config{DataDog: got.DataDog}},
+	}
+	for _, s := range sections {
+		if diff := cmp.Diff(s.want, s.got, opts); diff != "" {
+			t.Errorf("%s: want config != got config, diff: %s", s.name, diff)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+// Package events distributes the events of the agent, e.g. the jobs received
+// or the state changes of the checks, to the subscribers interested in
+// following the agent in real time, like dashboards or debugging tools.
+package events
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Types of the events.
+const (
+	// TypeJobReceived is published when a valid job is received.
+	TypeJobReceived = "job.received"
+	// TypeJobFinished is published when the processing of a job finishes
+	// without errors, whether its check ran or not.
+	TypeJobFinished = "job.finished"
+	// TypeJobFailed is published when the processing of a job finishes with
+	// an error, e.g. because the backend couldn't run its check.
+	TypeJobFailed = "job.failed"
+	// TypeCheckStarted is published when the backend starts running a check.
+	TypeCheckStarted = "check.started"
+	// TypeCheckState is published when the status or the progress of a check
+	// is updated.
+	TypeCheckState = "check.state"
+	// TypeCheckUploaded is published when the report or the logs of a check
+	// are uploaded.
+	TypeCheckUploaded = "check.uploaded"
+)
+
+// bufferSize is the number of events buffered for each subscriber.
+const bufferSize = 256
+
+// Event describes something that happened in the agent.
+type Event struct {
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	CheckID  string    `json:"check_id,omitempty"`
+	Status   string    `json:"status,omitempty"`
+	Progress *float32  `json:"progress,omitempty"`
+	Report   string    `json:"report,omitempty"`
+	Raw      string    `json:"raw,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Filter selects the events sent to a subscriber. The empty fields match
+// any event.
+type Filter struct {
+	// Types contains the types of the events, or their prefixes ending with
+	// a dot, e.g. "check.".
+	Types   []string
+	CheckID string
+}
+
+// Match returns true if the given event is selected by the filter.
+func (f Filter) Match(e Event) bool {
+	if f.CheckID != "" && f.CheckID != e.CheckID {
+		return false
+	}
+	if len(f.Types) == 0 {
+		return true
+	}
+	for _, t := range f.Types {
+		if t == e.Type || (strings.HasSuffix(t, ".") && strings.HasPrefix(e.Type, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+type subscriber struct {
+	filter Filter
+	events chan Event
+}
+
+// Bus delivers the events published to all its subscribers. The events are
+// never blocked by the subscribers, when a subscriber doesn't keep up with
+// the events and its buffer is full the new events are discarded for it.
+type Bus struct {
+	mu   sync.Mutex
+	subs map[*subscriber]struct{}
+}
+
+// NewBus returns a Bus without subscribers.
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*subscriber]struct{})}
+}
+
+// Publish delivers the given event to the subscribers whose filter matches
+// it. The time of the event is set if it's zero.
+func (b *Bus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.subs {
+		if !s.filter.Match(e) {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving the events published that match the
+// given filter, and the func to cancel the subscription, which closes the
+// channel.
+func (b *Bus) Subscribe(f Filter) (<-chan Event, func()) {
+	s := &subscriber{filter: f, events: make(chan Event, bufferSize)}
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, s)
+			b.mu.Unlock()
+			close(s.events)
+		})
+	}
+	return s.events, cancel
+}
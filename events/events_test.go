@@ -0,0 +1,104 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package events
+
+import (
+	"testing"
+)
+
+func TestFilter_Match(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+		event  Event
+		want   bool
+	}{
+		{
+			name:  "Empty",
+			event: Event{Type: TypeJobReceived, CheckID: "check1"},
+			want:  true,
+		},
+		{
+			name:   "Type",
+			filter: Filter{Types: []string{TypeCheckState, TypeJobFailed}},
+			event:  Event{Type: TypeJobFailed},
+			want:   true,
+		},
+		{
+			name:   "OtherType",
+			filter: Filter{Types: []string{TypeCheckState}},
+			event:  Event{Type: TypeCheckStarted},
+		},
+		{
+			name:   "Prefix",
+			filter: Filter{Types: []string{"check."}},
+			event:  Event{Type: TypeCheckUploaded},
+			want:   true,
+		},
+		{
+			name:   "NotPrefix",
+			filter: Filter{Types: []string{"check"}},
+			event:  Event{Type: TypeCheckUploaded},
+		},
+		{
+			name:   "OtherCheck",
+			filter: Filter{CheckID: "check1"},
+			event:  Event{Type: TypeCheckState, CheckID: "check2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Match(tt.event); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBus(t *testing.T) {
+	b := NewBus()
+	all, cancelAll := b.Subscribe(Filter{})
+	check1, cancelCheck1 := b.Subscribe(Filter{CheckID: "check1"})
+	defer cancelCheck1()
+
+	b.Publish(Event{Type: TypeJobReceived, CheckID: "check1"})
+	b.Publish(Event{Type: TypeJobReceived, CheckID: "check2"})
+	cancelAll()
+	b.Publish(Event{Type: TypeCheckStarted, CheckID: "check1"})
+
+	var got []string
+	for e := range all {
+		if e.Time.IsZero() {
+			t.Errorf("time of event %s not set", e.Type)
+		}
+		got = append(got, e.CheckID)
+	}
+	if len(got) != 2 || got[0] != "check1" || got[1] != "check2" {
+		t.Errorf("unexpected events received after cancelling: %v", got)
+	}
+	if e := <-check1; e.Type != TypeJobReceived {
+		t.Errorf("want event %s, got %s", TypeJobReceived, e.Type)
+	}
+	if e := <-check1; e.Type != TypeCheckStarted {
+		t.Errorf("want event %s, got %s", TypeCheckStarted, e.Type)
+	}
+}
+
+func TestBus_SlowSubscriber(t *testing.T) {
+	b := NewBus()
+	events, cancel := b.Subscribe(Filter{})
+	// The events are not blocked by a subscriber that doesn't read them.
+	for i := 0; i < bufferSize+10; i++ {
+		b.Publish(Event{Type: TypeCheckState})
+	}
+	cancel()
+	var n int
+	for range events {
+		n++
+	}
+	if n != bufferSize {
+		t.Errorf("want %d events buffered, got %d", bufferSize, n)
+	}
+}
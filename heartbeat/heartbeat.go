@@ -0,0 +1,121 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+// Package heartbeat periodically reports the identity and the state of the
+// agent to a registry, so the fleet of agents can be monitored and the agents
+// that stopped reporting detected.
+package heartbeat
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/log"
+)
+
+// StatusStopped is the status reported by the last heartbeat sent when the
+// agent stops.
+const StatusStopped = "stopped"
+
+const finalTimeout = 10 * time.Second
+
+// Heartbeat contains the information reported by an agent.
+type Heartbeat struct {
+	AgentID       string    `json:"agent_id" dynamodbav:"agent_id"`
+	Hostname      string    `json:"hostname" dynamodbav:"hostname"`
+	Version       string    `json:"version" dynamodbav:"version"`
+	StartedAt     time.Time `json:"started_at" dynamodbav:"started_at"`
+	Time          time.Time `json:"time" dynamodbav:"time"`
+	Status        string    `json:"status" dynamodbav:"status"`
+	Capacity      int       `json:"capacity" dynamodbav:"capacity"`
+	ChecksRunning int       `json:"checks_running" dynamodbav:"checks_running"`
+}
+
+// Stats contains the current state of the agent.
+type Stats struct {
+	Status        string
+	Capacity      int
+	ChecksRunning int
+}
+
+// Store saves the heartbeats of the agents.
+type Store interface {
+	Put(ctx context.Context, hb Heartbeat) error
+}
+
+// Reporter sends periodically the heartbeats of the agent to a Store.
+type Reporter struct {
+	store    Store
+	interval time.Duration
+	stats    func() Stats
+	base     Heartbeat
+	log      log.Logger
+	now      func() time.Time
+}
+
+// NewReporter returns a Reporter that sends the heartbeats of the agent with
+// the given ID, or the hostname if it's empty, and version to the store every
+// interval. The stats func is called to get the state of the agent reported
+// in each heartbeat.
+func NewReporter(l log.Logger, store Store, agentID, version string, interval time.Duration, stats func() Stats) *Reporter {
+	hostname, _ := os.Hostname()
+	if agentID == "" {
+		agentID = hostname
+	}
+	return &Reporter{
+		store:    store,
+		interval: interval,
+		stats:    stats,
+		base: Heartbeat{
+			AgentID:   agentID,
+			Hostname:  hostname,
+			Version:   version,
+			StartedAt: time.Now(),
+		},
+		log: l,
+		now: time.Now,
+	}
+}
+
+// Start sends a heartbeat and then one every interval until the context is
+// canceled. Then it sends a last heartbeat with the status stopped and writes
+// to the returned channel.
+func (r *Reporter) Start(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			r.send(ctx, r.heartbeat(r.stats()))
+			select {
+			case <-ctx.Done():
+				stats := r.stats()
+				stats.Status = StatusStopped
+				ctx, cancel := context.WithTimeout(context.Background(), finalTimeout)
+				r.send(ctx, r.heartbeat(stats))
+				cancel()
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return done
+}
+
+func (r *Reporter) heartbeat(s Stats) Heartbeat {
+	hb := r.base
+	hb.Time = r.now()
+	hb.Status = s.Status
+	hb.Capacity = s.Capacity
+	hb.ChecksRunning = s.ChecksRunning
+	return hb
+}
+
+func (r *Reporter) send(ctx context.Context, hb Heartbeat) {
+	if err := r.store.Put(ctx, hb); err != nil {
+		r.log.Errorf("error sending heartbeat: %+v", err)
+	}
+}
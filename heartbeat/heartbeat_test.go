@@ -0,0 +1,101 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package heartbeat
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/google/go-cmp/cmp"
+)
+
+type inMemStore struct {
+	sync.Mutex
+	hbs []Heartbeat
+}
+
+func (s *inMemStore) Put(ctx context.Context, hb Heartbeat) error {
+	s.Lock()
+	defer s.Unlock()
+	s.hbs = append(s.hbs, hb)
+	return nil
+}
+
+func TestReporter(t *testing.T) {
+	store := &inMemStore{}
+	stats := Stats{Status: "running", Capacity: 4, ChecksRunning: 1}
+	r := NewReporter(&log.NullLog{}, store, "agent1", "v1", time.Hour, func() Stats { return stats })
+	now := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+	r.base.StartedAt = now
+	ctx, cancel := context.WithCancel(context.Background())
+	done := r.Start(ctx)
+	cancel()
+	<-done
+	base := Heartbeat{
+		AgentID:   "agent1",
+		Hostname:  r.base.Hostname,
+		Version:   "v1",
+		StartedAt: now,
+		Time:      now,
+		Capacity:  4,
+	}
+	running, stopped := base, base
+	running.Status, running.ChecksRunning = "running", 1
+	stopped.Status, stopped.ChecksRunning = StatusStopped, 1
+	want := []Heartbeat{running, stopped}
+	if diff := cmp.Diff(want, store.hbs); diff != "" {
+		t.Errorf("want heartbeats != got heartbeats, diff: %s", diff)
+	}
+}
+
+type inMemDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	items []*dynamodb.PutItemInput
+}
+
+func (db *inMemDynamoDB) PutItemWithContext(ctx aws.Context, in *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	db.items = append(db.items, in)
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func TestDynamoDBStore_Put(t *testing.T) {
+	db := &inMemDynamoDB{}
+	s := &DynamoDBStore{db: db, table: "agents", ttl: time.Minute}
+	now := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	hb := Heartbeat{AgentID: "agent1", Time: now, Status: "running", Capacity: 2}
+	if err := s.Put(context.Background(), hb); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(db.items) != 1 {
+		t.Fatalf("want 1 item, got %d", len(db.items))
+	}
+	item := db.items[0]
+	if aws.StringValue(item.TableName) != "agents" {
+		t.Errorf("unexpected table %s", aws.StringValue(item.TableName))
+	}
+	got := map[string]string{
+		"agent_id":   aws.StringValue(item.Item["agent_id"].S),
+		"status":     aws.StringValue(item.Item["status"].S),
+		"capacity":   aws.StringValue(item.Item["capacity"].N),
+		"expires_at": aws.StringValue(item.Item["expires_at"].N),
+	}
+	want := map[string]string{
+		"agent_id":   "agent1",
+		"status":     "running",
+		"capacity":   "2",
+		"expires_at": "1646128860",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("want item != got item, diff: %s", diff)
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package heartbeat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/awscreds"
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// HTTPStore posts the heartbeats, encoded in JSON, to an endpoint.
+type HTTPStore struct {
+	endpoint string
+	client   *http.Client
+}
+
+// NewHTTPStore returns an HTTPStore that posts the heartbeats to the given
+// endpoint.
+func NewHTTPStore(endpoint string, timeout time.Duration) *HTTPStore {
+	return &HTTPStore{endpoint: endpoint, client: &http.Client{Timeout: timeout}}
+}
+
+// Put posts the heartbeat to the endpoint.
+func (s *HTTPStore) Put(ctx context.Context, hb Heartbeat) error {
+	body, err := json.Marshal(hb)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("invalid response status: %s", res.Status)
+	}
+	return nil
+}
+
+// DynamoDBStore writes the heartbeats to a DynamoDB table with the partition
+// key "agent_id". Each item has an "expires_at" attribute, with the time in
+// Unix seconds when the heartbeat expires, that can be used as the TTL
+// attribute of the table so the agents that stopped reporting are removed.
+type DynamoDBStore struct {
+	db    dynamodbiface.DynamoDBAPI
+	table string
+	ttl   time.Duration
+}
+
+// NewDynamoDBStore returns a DynamoDBStore that writes to the given table.
+// The region and the endpoint are optional.
+func NewDynamoDBStore(table, region, endpoint string, ttl time.Duration) (*DynamoDBStore, error) {
+	sess, err := awscreds.NewSession(config.AWSCredentialsConfig{})
+	if err != nil {
+		return nil, err
+	}
+	awsCfg := aws.NewConfig()
+	if region != "" {
+		awsCfg = awsCfg.WithRegion(region)
+	}
+	if endpoint != "" {
+		awsCfg = awsCfg.WithEndpoint(endpoint)
+	}
+	return &DynamoDBStore{
+		db:    dynamodb.New(sess, awsCfg),
+		table: table,
+		ttl:   ttl,
+	}, nil
+}
+
+// Put writes the heartbeat to the table replacing the previous one of the
+// agent.
+func (s *DynamoDBStore) Put(ctx context.Context, hb Heartbeat) error {
+	item, err := dynamodbattribute.MarshalMap(hb)
+	if err != nil {
+		return err
+	}
+	expires := hb.Time.Add(s.ttl).Unix()
+	item["expires_at"] = &dynamodb.AttributeValue{N: aws.String(fmt.Sprint(expires))}
+	_, err = s.db.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item:      item,
+	})
+	return err
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package hooks
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/adevinta/vulcan-agent/backend"
+)
+
+// Backend decorates a backend.Backend calling the hooks in the lifecycle of
+// the checks it runs.
+type Backend struct {
+	backend.Backend
+	hooks []Hook
+}
+
+// NewBackend returns a Backend that calls the given hooks.
+func NewBackend(b backend.Backend, hooks ...Hook) *Backend {
+	return &Backend{Backend: b, hooks: hooks}
+}
+
+// Run runs the check using the decorated backend.
+func (b *Backend) Run(ctx context.Context, params backend.RunParams) (<-chan backend.RunResult, error) {
+	params = copyParams(params)
+	for _, h := range b.hooks {
+		if err := h.BeforeRun(ctx, &params); err != nil {
+			err = fmt.Errorf("before run hook: %w", err)
+			b.onError(params, err)
+			return nil, err
+		}
+	}
+	finished, err := b.Backend.Run(ctx, params)
+	if err != nil {
+		b.onError(params, err)
+		return finished, err
+	}
+	res := make(chan backend.RunResult, 1)
+	go func() {
+		r := <-finished
+		switch {
+		case ctx.Err() != nil:
+			for _, h := range b.hooks {
+				h.OnAbort(params, ctx.Err())
+			}
+		case r.Error != nil:
+			b.onError(params, r.Error)
+		}
+		for _, h := range b.hooks {
+			h.AfterRun(params, r)
+		}
+		res <- r
+	}()
+	return res, nil
+}
+
+func (b *Backend) onError(params backend.RunParams, err error) {
+	for _, h := range b.hooks {
+		h.OnError(params, err)
+	}
+}
+
+// copyParams returns a copy of the params that the hooks can modify without
+// changing the job they come from.
+func copyParams(params backend.RunParams) backend.RunParams {
+	params.RequiredVars = append([]string(nil), params.RequiredVars...)
+	params.Metadata = copyMap(params.Metadata)
+	params.Vars = copyMap(params.Vars)
+	return params
+}
+
+func copyMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
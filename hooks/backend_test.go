@@ -0,0 +1,161 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package hooks
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/google/go-cmp/cmp"
+)
+
+type backendMock struct {
+	params backend.RunParams
+	err    error
+	res    backend.RunResult
+	// wait makes the run finish when the context is done.
+	wait bool
+}
+
+func (b *backendMock) Run(ctx context.Context, params backend.RunParams) (<-chan backend.RunResult, error) {
+	b.params = params
+	if b.err != nil {
+		return nil, b.err
+	}
+	res := make(chan backend.RunResult, 1)
+	if b.wait {
+		go func() {
+			<-ctx.Done()
+			res <- backend.RunResult{Error: ctx.Err()}
+		}()
+		return res, nil
+	}
+	res <- b.res
+	return res, nil
+}
+
+type recordHook struct {
+	calls     []string
+	beforeErr error
+}
+
+func (h *recordHook) BeforeRun(ctx context.Context, params *backend.RunParams) error {
+	h.calls = append(h.calls, "BeforeRun")
+	if h.beforeErr != nil {
+		return h.beforeErr
+	}
+	params.RequiredVars = append(params.RequiredVars, "TOKEN")
+	if params.Vars == nil {
+		params.Vars = make(map[string]string)
+	}
+	params.Vars["TOKEN"] = "secret"
+	return nil
+}
+
+func (h *recordHook) AfterRun(params backend.RunParams, res backend.RunResult) {
+	h.calls = append(h.calls, "AfterRun")
+}
+
+func (h *recordHook) OnError(params backend.RunParams, err error) {
+	h.calls = append(h.calls, "OnError")
+}
+
+func (h *recordHook) OnAbort(params backend.RunParams, err error) {
+	h.calls = append(h.calls, "OnAbort")
+}
+
+func TestBackendRun(t *testing.T) {
+	errRun := errors.New("run error")
+	tests := []struct {
+		name      string
+		backend   *backendMock
+		beforeErr error
+		abort     bool
+		wantCalls []string
+		wantErr   error
+		wantRes   backend.RunResult
+	}{
+		{
+			name:      "Finished",
+			backend:   &backendMock{res: backend.RunResult{Output: []byte("output")}},
+			wantCalls: []string{"BeforeRun", "AfterRun"},
+			wantRes:   backend.RunResult{Output: []byte("output")},
+		},
+		{
+			name:      "FinishedWithError",
+			backend:   &backendMock{res: backend.RunResult{Error: errRun}},
+			wantCalls: []string{"BeforeRun", "OnError", "AfterRun"},
+			wantRes:   backend.RunResult{Error: errRun},
+		},
+		{
+			name:      "NotStarted",
+			backend:   &backendMock{err: errRun},
+			wantCalls: []string{"BeforeRun", "OnError"},
+			wantErr:   errRun,
+		},
+		{
+			name:      "BeforeRunError",
+			backend:   &backendMock{},
+			beforeErr: errRun,
+			wantCalls: []string{"BeforeRun", "OnError"},
+			wantErr:   errRun,
+		},
+		{
+			name:      "Aborted",
+			backend:   &backendMock{wait: true},
+			abort:     true,
+			wantCalls: []string{"BeforeRun", "OnAbort", "AfterRun"},
+			wantRes:   backend.RunResult{Error: context.Canceled},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &recordHook{beforeErr: tt.beforeErr}
+			b := NewBackend(tt.backend, h)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			params := backend.RunParams{CheckID: "check1", Vars: map[string]string{"A": "a"}}
+			finished, err := b.Run(ctx, params)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("want error %v, got %v", tt.wantErr, err)
+			}
+			if err == nil {
+				if tt.abort {
+					cancel()
+				}
+				res := <-finished
+				if diff := cmp.Diff(tt.wantRes, res, cmp.Comparer(func(a, b error) bool { return errors.Is(a, b) })); diff != "" {
+					t.Errorf("result mismatch (-want +got):\n%v", diff)
+				}
+				if tt.backend.params.Vars["TOKEN"] != "secret" {
+					t.Errorf("params not modified by the hook: %+v", tt.backend.params)
+				}
+			}
+			if diff := cmp.Diff(tt.wantCalls, h.calls); diff != "" {
+				t.Errorf("calls mismatch (-want +got):\n%v", diff)
+			}
+			if _, ok := params.Vars["TOKEN"]; ok {
+				t.Errorf("params of the caller modified by the hook")
+			}
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	h1, h2 := &recordHook{}, &recordHook{}
+	Register("h1", h1)
+	Register("h2", h2)
+	if diff := cmp.Diff([]Hook{h1, h2}, Registered(), cmp.Comparer(func(a, b Hook) bool { return a == b })); diff != "" {
+		t.Errorf("registered hooks mismatch (-want +got):\n%v", diff)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("registering a hook twice must panic")
+		}
+	}()
+	Register("h1", h1)
+}
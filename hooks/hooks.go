@@ -0,0 +1,77 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+// Package hooks allows the programs embedding the agent to run their own code
+// in the lifecycle of the checks, for instance, to modify the vars of the
+// checks, to inject credentials or to record audit events.
+package hooks
+
+import (
+	"context"
+	"sync"
+
+	"github.com/adevinta/vulcan-agent/backend"
+)
+
+// Hook is implemented by the plugins called in the lifecycle of the checks.
+// The plugins that only need some of the methods can embed Nop.
+type Hook interface {
+	// BeforeRun is called before running a check and can modify its params.
+	// If it returns an error the check is not run and finishes with that
+	// error.
+	BeforeRun(ctx context.Context, params *backend.RunParams) error
+	// AfterRun is called when a check finishes, whatever its result.
+	AfterRun(params backend.RunParams, res backend.RunResult)
+	// OnError is called when a check can't be run or finishes with an error,
+	// except when it's aborted.
+	OnError(params backend.RunParams, err error)
+	// OnAbort is called when a check is stopped because it was aborted or it
+	// timed out. The err is the error of the context of the check.
+	OnAbort(params backend.RunParams, err error)
+}
+
+// Nop is a Hook that does nothing.
+type Nop struct{}
+
+// BeforeRun does nothing.
+func (Nop) BeforeRun(ctx context.Context, params *backend.RunParams) error { return nil }
+
+// AfterRun does nothing.
+func (Nop) AfterRun(params backend.RunParams, res backend.RunResult) {}
+
+// OnError does nothing.
+func (Nop) OnError(params backend.RunParams, err error) {}
+
+// OnAbort does nothing.
+func (Nop) OnAbort(params backend.RunParams, err error) {}
+
+var (
+	hooksMu sync.RWMutex
+	names   = make(map[string]bool)
+	hooks   []Hook
+)
+
+// Register adds a hook to the ones called by the agent, in the order they are
+// registered. It must be called before running the agent, for instance from
+// the init function of the package implementing the hook. If Register is
+// called twice with the same name or if the hook is nil, it panics.
+func Register(name string, h Hook) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	if h == nil {
+		panic("hooks: register hook is nil")
+	}
+	if names[name] {
+		panic("hooks: register called twice for hook " + name)
+	}
+	names[name] = true
+	hooks = append(hooks, h)
+}
+
+// Registered returns the registered hooks in the order they were registered.
+func Registered() []Hook {
+	hooksMu.RLock()
+	defer hooksMu.RUnlock()
+	return append([]Hook(nil), hooks...)
+}
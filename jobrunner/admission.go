@@ -0,0 +1,256 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package jobrunner
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+)
+
+const (
+	// DefaultAdmissionDiskPath is the path whose filesystem is checked for
+	// free space when no path is configured, the data root of docker.
+	DefaultAdmissionDiskPath = config.DefaultAdmissionDiskPath
+	// DefaultAdmissionInterval is the default time, in seconds, between the
+	// checks of the conditions of the host while it's unhealthy.
+	DefaultAdmissionInterval = config.DefaultAdmissionInterval
+
+	// AdmissionPauseReason is the reason of the pause of the Runner while
+	// the host is unhealthy.
+	AdmissionPauseReason = "host unhealthy"
+)
+
+// ErrHostUnhealthy is returned when the conditions of the host don't allow to
+// accept new jobs.
+var ErrHostUnhealthy = errors.New("host unhealthy")
+
+// AdmissionConfig defines the conditions of the host required to accept new
+// jobs. The conditions with a zero value are not checked.
+type AdmissionConfig struct {
+	// DiskPath is a path in the filesystem that must have at least
+	// MinFreeDiskMB of free space.
+	DiskPath      string
+	MinFreeDiskMB int
+	// MaxLoad is the max 1 minute load average, per CPU, of the host.
+	MaxLoad float64
+	// MinFreeMemoryMB is the min memory available in the host.
+	MinFreeMemoryMB int
+	// MaxMemoryPressure is the max percentage of the time, in the last 10
+	// seconds, some tasks of the host were stalled waiting for memory.
+	MaxMemoryPressure float64
+	// Interval is the time, in seconds, between the checks of the conditions
+	// of the host while it's unhealthy.
+	Interval int
+}
+
+// enabled returns true if any condition is defined.
+func (c AdmissionConfig) enabled() bool {
+	return c.MinFreeDiskMB > 0 || c.MaxLoad > 0 || c.MinFreeMemoryMB > 0 || c.MaxMemoryPressure > 0
+}
+
+// admission checks the conditions of the host before accepting new jobs.
+type admission struct {
+	cfg      AdmissionConfig
+	interval time.Duration
+	// The funcs that read the conditions of the host.
+	diskFree     func(path string) (uint64, error)
+	loadAvg      func() (float64, error)
+	memAvailable func() (uint64, error)
+	memPressure  func() (float64, error)
+
+	mu sync.Mutex
+	// waiting is true while the Runner is paused waiting for the host to be
+	// healthy.
+	waiting bool
+}
+
+func newAdmission(cfg AdmissionConfig) *admission {
+	if !cfg.enabled() {
+		return nil
+	}
+	if cfg.DiskPath == "" {
+		cfg.DiskPath = DefaultAdmissionDiskPath
+	}
+	if cfg.Interval < 1 {
+		cfg.Interval = DefaultAdmissionInterval
+	}
+	return &admission{
+		cfg:          cfg,
+		interval:     time.Duration(cfg.Interval) * time.Second,
+		diskFree:     diskFree,
+		loadAvg:      loadAvg,
+		memAvailable: memAvailable,
+		memPressure:  memPressure,
+	}
+}
+
+// check returns an ErrHostUnhealthy error describing the conditions of the
+// host that are not met. The conditions that can't be read, e.g. because
+// they are not available in the OS, are logged and considered met.
+func (a *admission) check(l log.Logger) error {
+	var reasons []string
+	if a.cfg.MinFreeDiskMB > 0 {
+		free, err := a.diskFree(a.cfg.DiskPath)
+		switch {
+		case err != nil:
+			l.Errorf("error reading the free disk of %s: %+v", a.cfg.DiskPath, err)
+		case free < uint64(a.cfg.MinFreeDiskMB)*1024*1024:
+			reasons = append(reasons, fmt.Sprintf("free disk in %s %dMB", a.cfg.DiskPath, free/1024/1024))
+		}
+	}
+	if a.cfg.MaxLoad > 0 {
+		load, err := a.loadAvg()
+		switch {
+		case err != nil:
+			l.Errorf("error reading the load average: %+v", err)
+		case load/float64(runtime.NumCPU()) > a.cfg.MaxLoad:
+			reasons = append(reasons, fmt.Sprintf("load average %.2f", load))
+		}
+	}
+	if a.cfg.MinFreeMemoryMB > 0 {
+		free, err := a.memAvailable()
+		switch {
+		case err != nil:
+			l.Errorf("error reading the available memory: %+v", err)
+		case free < uint64(a.cfg.MinFreeMemoryMB)*1024*1024:
+			reasons = append(reasons, fmt.Sprintf("available memory %dMB", free/1024/1024))
+		}
+	}
+	if a.cfg.MaxMemoryPressure > 0 {
+		p, err := a.memPressure()
+		switch {
+		case err != nil:
+			l.Errorf("error reading the memory pressure: %+v", err)
+		case p > a.cfg.MaxMemoryPressure:
+			reasons = append(reasons, fmt.Sprintf("memory pressure %.2f%%", p))
+		}
+	}
+	if len(reasons) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrHostUnhealthy, strings.Join(reasons, ", "))
+}
+
+// admit returns an ErrHostUnhealthy error if the conditions of the host
+// don't allow to accept a new job. In that case the Runner is paused until
+// the host is healthy again, so no more messages are read meanwhile.
+func (cr *Runner) admit() error {
+	a := cr.admission
+	if a == nil {
+		return nil
+	}
+	err := a.check(cr.Logger)
+	if err == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.waiting {
+		a.waiting = true
+		cr.Pause(AdmissionPauseReason)
+		go cr.awaitHealthyHost()
+	}
+	return err
+}
+
+// awaitHealthyHost checks the conditions of the host periodically until
+// they are met, then it resumes the Runner.
+func (cr *Runner) awaitHealthyHost() {
+	a := cr.admission
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := a.check(cr.Logger); err != nil {
+			cr.Logger.Debugf("%+v", err)
+			continue
+		}
+		a.mu.Lock()
+		a.waiting = false
+		cr.Resume(AdmissionPauseReason)
+		a.mu.Unlock()
+		return
+	}
+}
+
+// loadAvg returns the 1 minute load average of the host.
+func loadAvg() (float64, error) {
+	data, err := ioutil.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, err
+	}
+	return parseLoadAvg(data)
+}
+
+func parseLoadAvg(data []byte) (float64, error) {
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, errors.New("invalid loadavg")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// memAvailable returns the memory, in bytes, available in the host for
+// starting new applications.
+func memAvailable() (uint64, error) {
+	data, err := ioutil.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	return parseMemAvailable(data)
+}
+
+func parseMemAvailable(data []byte) (uint64, error) {
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 || fields[0] != "MemAvailable:" {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid MemAvailable: %w", err)
+		}
+		return kb * 1024, nil
+	}
+	return 0, errors.New("MemAvailable not found in meminfo")
+}
+
+// memPressure returns the percentage of the time, in the last 10 seconds,
+// some tasks of the host were stalled waiting for memory, as reported by the
+// pressure stall information of Linux.
+func memPressure() (float64, error) {
+	data, err := ioutil.ReadFile("/proc/pressure/memory")
+	if err != nil {
+		return 0, err
+	}
+	return parseMemPressure(data)
+}
+
+func parseMemPressure(data []byte) (float64, error) {
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 || fields[0] != "some" {
+			continue
+		}
+		for _, f := range fields[1:] {
+			if v := strings.TrimPrefix(f, "avg10="); v != f {
+				return strconv.ParseFloat(v, 64)
+			}
+		}
+	}
+	return 0, errors.New("avg10 not found in memory pressure")
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package jobrunner
+
+import (
+	"errors"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAdmission_check(t *testing.T) {
+	cpus := float64(runtime.NumCPU())
+	tests := []struct {
+		name     string
+		cfg      AdmissionConfig
+		disk     uint64
+		load     float64
+		mem      uint64
+		pressure float64
+		readErr  error
+		wantErr  bool
+	}{
+		{
+			name:     "Healthy",
+			cfg:      AdmissionConfig{MinFreeDiskMB: 100, MaxLoad: 1, MinFreeMemoryMB: 100, MaxMemoryPressure: 10},
+			disk:     200 * 1024 * 1024,
+			load:     cpus / 2,
+			mem:      200 * 1024 * 1024,
+			pressure: 5,
+		},
+		{
+			name:    "NoFreeDisk",
+			cfg:     AdmissionConfig{MinFreeDiskMB: 100},
+			disk:    50 * 1024 * 1024,
+			wantErr: true,
+		},
+		{
+			name:    "HighLoad",
+			cfg:     AdmissionConfig{MaxLoad: 1},
+			load:    cpus * 2,
+			wantErr: true,
+		},
+		{
+			name:    "NoFreeMemory",
+			cfg:     AdmissionConfig{MinFreeMemoryMB: 100},
+			mem:     50 * 1024 * 1024,
+			wantErr: true,
+		},
+		{
+			name:     "MemoryPressure",
+			cfg:      AdmissionConfig{MaxMemoryPressure: 10},
+			pressure: 20,
+			wantErr:  true,
+		},
+		{
+			name:    "ConditionsNotAvailable",
+			cfg:     AdmissionConfig{MinFreeDiskMB: 100, MaxLoad: 1, MinFreeMemoryMB: 100, MaxMemoryPressure: 10},
+			readErr: errors.New("not available"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAdmission(tt.cfg)
+			a.diskFree = func(string) (uint64, error) { return tt.disk, tt.readErr }
+			a.loadAvg = func() (float64, error) { return tt.load, tt.readErr }
+			a.memAvailable = func() (uint64, error) { return tt.mem, tt.readErr }
+			a.memPressure = func() (float64, error) { return tt.pressure, tt.readErr }
+			err := a.check(&log.NullLog{})
+			if tt.wantErr != errors.Is(err, ErrHostUnhealthy) {
+				t.Errorf("want unhealthy %v, got error %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestRunner_admit(t *testing.T) {
+	cr := New(&log.NullLog{}, nil, nil, nil, RunnerConfig{
+		MaxTokens: 2,
+		Admission: AdmissionConfig{MinFreeDiskMB: 100},
+	})
+	cr.admission.interval = 10 * time.Millisecond
+	var free uint64
+	cr.admission.diskFree = func(string) (uint64, error) {
+		return atomic.LoadUint64(&free), nil
+	}
+	if err := cr.admit(); !errors.Is(err, ErrHostUnhealthy) {
+		t.Fatalf("want error %v, got %v", ErrHostUnhealthy, err)
+	}
+	if diff := cmp.Diff([]string{AdmissionPauseReason}, cr.Paused()); diff != "" {
+		t.Fatalf("pause reasons mismatch (-want +got):\n%v", diff)
+	}
+	atomic.StoreUint64(&free, 200*1024*1024)
+	deadline := time.Now().Add(time.Second)
+	for len(cr.Paused()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("runner not resumed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := cr.admit(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestParseHostConditions(t *testing.T) {
+	load, err := parseLoadAvg([]byte("1.50 0.80 0.40 2/345 6789\n"))
+	if err != nil || load != 1.5 {
+		t.Errorf("want load 1.5, got %v, error %v", load, err)
+	}
+	mem, err := parseMemAvailable([]byte("MemTotal:       16000000 kB\nMemFree:         1000000 kB\nMemAvailable:    8000000 kB\n"))
+	if err != nil || mem != 8000000*1024 {
+		t.Errorf("want memory %d, got %d, error %v", 8000000*1024, mem, err)
+	}
+	pressure, err := parseMemPressure([]byte("some avg10=12.50 avg60=3.00 avg300=1.00 total=123\nfull avg10=1.00 avg60=0.00 avg300=0.00 total=12\n"))
+	if err != nil || pressure != 12.5 {
+		t.Errorf("want pressure 12.5, got %v, error %v", pressure, err)
+	}
+	if _, err := parseMemAvailable([]byte("MemTotal: 1 kB\n")); err == nil {
+		t.Errorf("want error parsing meminfo without MemAvailable")
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package jobrunner
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/audit"
+	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/adevinta/vulcan-agent/queue"
+)
+
+// AuditLog defines the shape of the component used by a Runner to record the
+// jobs it processes. It is optional, when the Audit of a Runner is nil the
+// jobs are not recorded.
+type AuditLog interface {
+	Record(r audit.Record)
+}
+
+// CheckStatuses is implemented by the CompletedChecks components that can
+// return the current status of a check. It's used to record the final status
+// of the checks in the AuditLog.
+type CheckStatuses interface {
+	State(ID string) (string, bool)
+}
+
+// jobAudit contains the information about the execution of a job that is
+// recorded when the job finishes.
+type jobAudit struct {
+	mu       sync.Mutex
+	checkID  string
+	image    string
+	digest   string
+	start    *time.Time
+	end      *time.Time
+	exitCode *int
+}
+
+// auditReceived records a job received in the given message and starts
+// tracking it until it's finished. The jobs are identified by the channel
+// used to signal that they are processed, as it's the only value shared by
+// all the paths that finish a job, including the watchdog, and it's unique
+// even for invalid messages or duplicated checks.
+func (cr *Runner) auditReceived(m queue.Message, checkID string, processed chan<- bool) {
+	if cr.Audit == nil {
+		return
+	}
+	cr.Audit.Record(audit.Record{
+		Event:     audit.EventReceived,
+		CheckID:   checkID,
+		Message:   m.Body,
+		TimesRead: m.TimesRead,
+	})
+	cr.audits.Store(processed, &jobAudit{checkID: checkID})
+}
+
+// auditStarted records that the check of a job started running.
+func (cr *Runner) auditStarted(processed chan<- bool, j *Job, start time.Time) {
+	v, ok := cr.audits.Load(processed)
+	if !ok {
+		return
+	}
+	ja := v.(*jobAudit)
+	ja.mu.Lock()
+	ja.image = j.Image
+	ja.start = &start
+	ja.mu.Unlock()
+	cr.Audit.Record(audit.Record{
+		Event:     audit.EventStarted,
+		CheckID:   j.CheckID,
+		Image:     j.Image,
+		StartTime: &start,
+	})
+}
+
+// auditRan stores the result of the execution of the check of a job, to be
+// recorded when the job finishes. The digest of the image executed is taken
+// when the backend is able to provide it.
+func (cr *Runner) auditRan(processed chan<- bool, j *Job, res backend.RunResult) {
+	v, ok := cr.audits.Load(processed)
+	if !ok {
+		return
+	}
+	end := time.Now()
+	var exitCode *int
+	if res.Error == nil {
+		code := 0
+		exitCode = &code
+	} else if code, ok := backend.ExitCode(res.Error); ok {
+		exitCode = &code
+	}
+	var digest string
+	if d, ok := cr.Backend.(backend.ImageDigester); ok {
+		var err error
+		digest, err = d.ImageDigest(context.Background(), j.Image)
+		if err != nil {
+			cr.logger(j.CheckID).Errorf("error getting the digest of the image %s of the check %s: %+v", j.Image, j.CheckID, err)
+		}
+	}
+	ja := v.(*jobAudit)
+	ja.mu.Lock()
+	defer ja.mu.Unlock()
+	ja.end = &end
+	ja.exitCode = exitCode
+	ja.digest = digest
+}
+
+// auditFinished records that a job finished, with the result of the
+// execution of its check, if it ran, and stops tracking it.
+func (cr *Runner) auditFinished(processed chan<- bool, delete bool, err error) {
+	v, ok := cr.audits.LoadAndDelete(processed)
+	if !ok {
+		return
+	}
+	ja := v.(*jobAudit)
+	ja.mu.Lock()
+	defer ja.mu.Unlock()
+	r := audit.Record{
+		Event:       audit.EventFinished,
+		CheckID:     ja.checkID,
+		Image:       ja.image,
+		ImageDigest: ja.digest,
+		StartTime:   ja.start,
+		EndTime:     ja.end,
+		ExitCode:    ja.exitCode,
+		Deleted:     delete,
+	}
+	// The checks finished by the watchdog didn't return a result.
+	if r.StartTime != nil && r.EndTime == nil {
+		end := time.Now()
+		r.EndTime = &end
+	}
+	if s, ok := cr.Completed.(CheckStatuses); ok && ja.checkID != "" {
+		r.Status, _ = s.State(ja.checkID)
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	cr.Audit.Record(r)
+}
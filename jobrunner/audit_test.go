@@ -0,0 +1,155 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package jobrunner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/adevinta/vulcan-agent/audit"
+	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/queue"
+	"github.com/adevinta/vulcan-agent/stateupdater"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+type auditLogMock struct {
+	mu      sync.Mutex
+	records []audit.Record
+}
+
+func (a *auditLogMock) Record(r audit.Record) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.records = append(a.records, r)
+}
+
+type checkStatusesMock map[string]string
+
+func (c checkStatusesMock) Completed(ID string) bool {
+	return false
+}
+
+func (c checkStatusesMock) State(ID string) (string, bool) {
+	s, ok := c[ID]
+	return s, ok
+}
+
+type digesterBackendMock struct {
+	mockBackend
+	digest string
+}
+
+func (d *digesterBackendMock) ImageDigest(ctx context.Context, image string) (string, error) {
+	return d.digest, nil
+}
+
+func TestRunner_Audit(t *testing.T) {
+	body, err := json.Marshal(runJobFixture1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exitCode := 3
+	tests := []struct {
+		name string
+		msg  queue.Message
+		want []audit.Record
+	}{
+		{
+			name: "RunsCheck",
+			msg:  queue.Message{Body: string(body), TimesRead: 1},
+			want: []audit.Record{
+				{
+					Event:     audit.EventReceived,
+					CheckID:   runJobFixture1.CheckID,
+					Message:   string(body),
+					TimesRead: 1,
+				},
+				{
+					Event:   audit.EventStarted,
+					CheckID: runJobFixture1.CheckID,
+					Image:   runJobFixture1.Image,
+				},
+				{
+					Event:       audit.EventFinished,
+					CheckID:     runJobFixture1.CheckID,
+					Image:       runJobFixture1.Image,
+					ImageDigest: "job1@sha256:1234",
+					ExitCode:    &exitCode,
+					Status:      stateupdater.StatusFailed,
+					Deleted:     true,
+				},
+			},
+		},
+		{
+			name: "InvalidMessage",
+			msg:  queue.Message{Body: "{", TimesRead: 1},
+			want: []audit.Record{
+				{
+					Event:     audit.EventReceived,
+					Message:   "{",
+					TimesRead: 1,
+				},
+				{
+					Event:   audit.EventFinished,
+					Deleted: true,
+					Error:   "unexpected end of JSON input",
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &digesterBackendMock{
+				mockBackend: mockBackend{
+					CheckRunner: func(ctx context.Context, params backend.RunParams) (<-chan backend.RunResult, error) {
+						res := make(chan backend.RunResult, 1)
+						res <- backend.RunResult{Error: fmt.Errorf("%w exit: %d", backend.ErrNonZeroExitCode, exitCode)}
+						return res, nil
+					},
+				},
+				digest: "job1@sha256:1234",
+			}
+			cr := New(&log.NullLog{}, b, &inMemChecksUpdater{}, &inMemAbortedChecks{}, RunnerConfig{
+				MaxTokens:              1,
+				DefaultTimeout:         60,
+				MaxProcessMessageTimes: 1,
+			})
+			cr.Completed = checkStatusesMock{runJobFixture1.CheckID: stateupdater.StatusFailed}
+			al := &auditLogMock{}
+			cr.Audit = al
+			<-cr.ProcessMessage(tt.msg, <-cr.Tokens)
+			for _, r := range al.records {
+				if r.Event != audit.EventFinished || r.Image == "" {
+					continue
+				}
+				if r.StartTime == nil || r.EndTime == nil || r.EndTime.Before(*r.StartTime) {
+					t.Errorf("invalid start and end times: %v, %v", r.StartTime, r.EndTime)
+				}
+			}
+			ignoreTimes := cmpopts.IgnoreFields(audit.Record{}, "StartTime", "EndTime")
+			if diff := cmp.Diff(tt.want, al.records, ignoreTimes, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("records mismatch (-want +got):\n%v", diff)
+			}
+			if n := countAudits(cr); n != 0 {
+				t.Errorf("%d jobs still tracked", n)
+			}
+		})
+	}
+}
+
+func countAudits(cr *Runner) int {
+	n := 0
+	cr.audits.Range(func(_, _ interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
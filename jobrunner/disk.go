@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+/*
+Copyright 2022 Adevinta
+*/
+
+package jobrunner
+
+import "syscall"
+
+// diskFree returns the space, in bytes, available to unprivileged users in
+// the filesystem of the given path.
+func diskFree(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return st.Bavail * uint64(st.Bsize), nil
+}
@@ -0,0 +1,12 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package jobrunner
+
+import "errors"
+
+// diskFree is not supported on Windows.
+func diskFree(path string) (uint64, error) {
+	return 0, errors.New("free disk not supported on windows")
+}
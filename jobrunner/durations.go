@@ -0,0 +1,50 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package jobrunner
+
+import (
+	"sync"
+	"time"
+)
+
+// durationWindowSize is the number of durations used to compute the average
+// duration of the checks.
+const durationWindowSize = 100
+
+// durationWindow keeps the last durations added to compute their average.
+// The zero value is ready to use.
+type durationWindow struct {
+	mu     sync.Mutex
+	values []time.Duration
+	next   int
+}
+
+// add adds a duration to the window, replacing the oldest one when the
+// window is full.
+func (w *durationWindow) add(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.values) < durationWindowSize {
+		w.values = append(w.values, d)
+		return
+	}
+	w.values[w.next] = d
+	w.next = (w.next + 1) % durationWindowSize
+}
+
+// avg returns the average of the durations in the window, or 0 if it's
+// empty.
+func (w *durationWindow) avg() time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.values) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, d := range w.values {
+		total += d
+	}
+	return total / time.Duration(len(w.values))
+}
@@ -5,13 +5,23 @@ Copyright 2021 Adevinta
 package jobrunner
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
 	"time"
 )
 
+// epochMillisThreshold is the value from which the epoch timestamps are
+// considered to be in milliseconds instead of seconds.
+const epochMillisThreshold = 1e11
+
 // Job stores the information necessary to create a new check job. This is the
 // information written in the queue where the agents read the messages from.
 type Job struct {
 	CheckID      string            `json:"check_id"`      // Required
+	ScanID       string            `json:"scan_id"`       // Optional
 	StartTime    time.Time         `json:"start_time"`    // Required
 	Image        string            `json:"image"`         // Required
 	Target       string            `json:"target"`        // Required
@@ -20,4 +30,79 @@ type Job struct {
 	Options      string            `json:"options"`       // Optional
 	RequiredVars []string          `json:"required_vars"` // Optional
 	Metadata     map[string]string `json:"metadata"`      // Optional
+	// SchemaVersion is the version of the schema of the job, see
+	// SchemaVersion.
+	SchemaVersion int `json:"schema_version"` // Optional
+
+	// warnings contains the legacy formats found when decoding the job.
+	warnings []string
+}
+
+// UnmarshalJSON decodes a job accepting, apart from the current format, the
+// formats still published by older schedulers: the start time as an epoch
+// timestamp, in seconds or milliseconds, the scan_start_time field of the
+// payloads that only identify the scan of the check, instead of the
+// start_time, and the asset_type field instead of the assettype. The legacy
+// formats found are returned by the Warnings method.
+func (j *Job) UnmarshalJSON(data []byte) error {
+	// The job type doesn't have the UnmarshalJSON method, so decoding it
+	// doesn't recurse.
+	type job Job
+	aux := struct {
+		*job
+		StartTime       json.RawMessage `json:"start_time"`
+		ScanStartTime   json.RawMessage `json:"scan_start_time"`
+		LegacyAssetType string          `json:"asset_type"`
+	}{job: (*job)(j)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	j.warnings = nil
+	var err error
+	switch {
+	case !isNull(aux.StartTime):
+		j.StartTime, err = j.parseTime("start_time", aux.StartTime)
+	case !isNull(aux.ScanStartTime):
+		j.warnings = append(j.warnings, "legacy field scan_start_time used as start_time")
+		j.StartTime, err = j.parseTime("scan_start_time", aux.ScanStartTime)
+	}
+	if err != nil {
+		return err
+	}
+	if j.AssetType == "" && aux.LegacyAssetType != "" {
+		j.warnings = append(j.warnings, "legacy field asset_type used as assettype")
+		j.AssetType = aux.LegacyAssetType
+	}
+	return nil
+}
+
+// Warnings returns the legacy formats found when decoding the job.
+func (j Job) Warnings() []string {
+	return j.warnings
+}
+
+// parseTime parses a timestamp in RFC3339 format or as an epoch timestamp,
+// either a number or a string.
+func (j *Job) parseTime(field string, raw json.RawMessage) (time.Time, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		// Not a string, it must be a number.
+		s = string(raw)
+	} else if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	epoch, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(epoch) || math.IsInf(epoch, 0) {
+		return time.Time{}, fmt.Errorf("invalid %s %s", field, raw)
+	}
+	j.warnings = append(j.warnings, fmt.Sprintf("legacy epoch timestamp in %s", field))
+	if math.Abs(epoch) >= epochMillisThreshold {
+		return time.UnixMilli(int64(epoch)).UTC(), nil
+	}
+	sec, frac := math.Modf(epoch)
+	return time.Unix(int64(sec), int64(frac*1e9)).UTC(), nil
+}
+
+func isNull(raw json.RawMessage) bool {
+	return len(raw) == 0 || bytes.Equal(raw, []byte("null"))
 }
@@ -0,0 +1,87 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package jobrunner
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestJob_UnmarshalJSON(t *testing.T) {
+	start := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		data         string
+		wantStart    time.Time
+		wantAsset    string
+		wantWarnings []string
+		wantErr      bool
+	}{
+		{
+			name:      "RFC3339",
+			data:      `{"check_id": "c1", "start_time": "2022-03-01T11:00:00+01:00", "assettype": "Hostname"}`,
+			wantStart: start,
+			wantAsset: "Hostname",
+		},
+		{
+			name:         "EpochSeconds",
+			data:         `{"check_id": "c1", "start_time": 1646128800}`,
+			wantStart:    start,
+			wantWarnings: []string{"legacy epoch timestamp in start_time"},
+		},
+		{
+			name:         "EpochMillisString",
+			data:         `{"check_id": "c1", "start_time": "1646128800000"}`,
+			wantStart:    start,
+			wantWarnings: []string{"legacy epoch timestamp in start_time"},
+		},
+		{
+			name:      "LegacyScanOnly",
+			data:      `{"check_id": "c1", "scan_id": "s1", "scan_start_time": "2022-03-01T10:00:00Z", "asset_type": "IP"}`,
+			wantStart: start,
+			wantAsset: "IP",
+			wantWarnings: []string{
+				"legacy field scan_start_time used as start_time",
+				"legacy field asset_type used as assettype",
+			},
+		},
+		{
+			name: "NoStartTime",
+			data: `{"check_id": "c1"}`,
+		},
+		{
+			name:    "InvalidStartTime",
+			data:    `{"check_id": "c1", "start_time": "yesterday"}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var j Job
+			err := json.Unmarshal([]byte(tt.data), &j)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("want error %v, got %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if j.CheckID != "c1" {
+				t.Errorf("want check id c1, got %s", j.CheckID)
+			}
+			if !j.StartTime.Equal(tt.wantStart) {
+				t.Errorf("want start time %v, got %v", tt.wantStart, j.StartTime)
+			}
+			if j.AssetType != tt.wantAsset {
+				t.Errorf("want asset type %s, got %s", tt.wantAsset, j.AssetType)
+			}
+			if diff := cmp.Diff(tt.wantWarnings, j.Warnings()); diff != "" {
+				t.Errorf("warnings mismatch (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package jobrunner
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TeamMetadataKey defines the key of the job metadata that contains the team
+// the check belongs to.
+const TeamMetadataKey = "team"
+
+// rateLimitPeriod defines the period used by the rate limiters.
+const rateLimitPeriod = time.Minute
+
+// rateLimiter limits the number of checks that can be launched per period of
+// time for a given key, e.g. a target or a team. The launches of each key are
+// kept sorted by time.
+type rateLimiter struct {
+	mu       sync.Mutex
+	limit    int
+	period   time.Duration
+	launches map[string][]time.Time
+}
+
+func newRateLimiter(limit int, period time.Duration) *rateLimiter {
+	if limit < 1 {
+		return nil
+	}
+	return &rateLimiter{
+		limit:    limit,
+		period:   period,
+		launches: make(map[string][]time.Time),
+	}
+}
+
+// next returns the first time, not before now, a check can be launched for
+// the given key without exceeding the limit. It forgets the launches that
+// are out of the current window. The caller must hold the lock of the
+// limiter.
+func (l *rateLimiter) next(key string, now time.Time) time.Time {
+	// Forget the keys without launches in the current window.
+	for k, launches := range l.launches {
+		if !launches[len(launches)-1].After(now.Add(-l.period)) {
+			delete(l.launches, k)
+		}
+	}
+	// Forget the launches of the key that are out of the current window.
+	launches := l.launches[key]
+	i := 0
+	for i < len(launches) && !launches[i].After(now.Add(-l.period)) {
+		i++
+	}
+	launches = launches[i:]
+	if len(launches) == 0 {
+		delete(l.launches, key)
+		return now
+	}
+	l.launches[key] = launches
+	if len(launches) < l.limit {
+		return now
+	}
+	return launches[len(launches)-l.limit].Add(l.period)
+}
+
+// record records a launch for the given key at the given time. The launches
+// reserved by other limiters can be in the future, so the launch is inserted
+// keeping the launches of the key sorted. The caller must hold the lock of
+// the limiter.
+func (l *rateLimiter) record(key string, at time.Time) {
+	launches := l.launches[key]
+	i := sort.Search(len(launches), func(i int) bool {
+		return launches[i].After(at)
+	})
+	launches = append(launches, time.Time{})
+	copy(launches[i+1:], launches[i:])
+	launches[i] = at
+	l.launches[key] = launches
+}
+
+// rateLimit is a rate limiter together with the key a check is limited by.
+type rateLimit struct {
+	limiter *rateLimiter
+	key     string
+}
+
+// reserveLaunch reserves the launch of a check in the given rate limits and
+// returns the time the caller must wait before actually launching it. The
+// launch is recorded, in all the limiters, at the first time none of them
+// is exceeded, so the launches delayed by one limiter are not counted at an
+// earlier time by the others. Nil limiters and empty keys never limit.
+func reserveLaunch(now time.Time, limits ...rateLimit) time.Duration {
+	var active []rateLimit
+	for _, rl := range limits {
+		if rl.limiter != nil && rl.key != "" {
+			active = append(active, rl)
+		}
+	}
+	// The limiters are always locked in the same order.
+	for _, rl := range active {
+		rl.limiter.mu.Lock()
+		defer rl.limiter.mu.Unlock()
+	}
+	at := now
+	for _, rl := range active {
+		if next := rl.limiter.next(rl.key, now); next.After(at) {
+			at = next
+		}
+	}
+	for _, rl := range active {
+		rl.limiter.record(rl.key, at)
+	}
+	return at.Sub(now)
+}
+
+// reserveRateLimits reserves the launch of the job in the rate limiters of
+// its target and its team and returns the time to wait before launching it.
+func (cr *Runner) reserveRateLimits(j *Job) time.Duration {
+	return reserveLaunch(time.Now(),
+		rateLimit{cr.targetLimiter, j.Target},
+		rateLimit{cr.teamLimiter, j.Metadata[TeamMetadataKey]},
+	)
+}
+
+// waitRateLimits waits the given time, reserved by the job in the rate
+// limiters, before launching it. It returns the error of the context if the
+// context is done while waiting, e.g. because the check was aborted.
+func (cr *Runner) waitRateLimits(ctx context.Context, j *Job, wait time.Duration) error {
+	if wait <= 0 {
+		return nil
+	}
+	cr.logger(j.CheckID).Infof("check %s rate limited, waiting %s before running it", j.CheckID, wait)
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
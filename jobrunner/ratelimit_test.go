@@ -0,0 +1,182 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package jobrunner
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/queue"
+	"github.com/adevinta/vulcan-agent/stateupdater"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRateLimiter_reserve(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := newRateLimiter(2, time.Minute)
+
+	wants := []time.Duration{0, 0, time.Minute, time.Minute}
+	for i, want := range wants {
+		if got := reserveLaunch(now, rateLimit{l, "example.com"}); got != want {
+			t.Fatalf("reservation %d: want wait %s, got %s", i, want, got)
+		}
+	}
+	// Other keys are not affected.
+	if got := reserveLaunch(now, rateLimit{l, "other.example.com"}); got != 0 {
+		t.Fatalf("want no wait for other key, got %s", got)
+	}
+	// After the window passes the first reservations are forgotten.
+	now = now.Add(90 * time.Second)
+	if got := reserveLaunch(now, rateLimit{l, "example.com"}); got != 30*time.Second {
+		t.Fatalf("want wait %s, got %s", 30*time.Second, got)
+	}
+	if _, ok := l.launches["other.example.com"]; ok {
+		t.Fatalf("expired key not removed")
+	}
+	// A nil limiter never limits.
+	var nilLimiter *rateLimiter
+	if got := reserveLaunch(now, rateLimit{nilLimiter, "example.com"}); got != 0 {
+		t.Fatalf("want no wait for nil limiter, got %s", got)
+	}
+}
+
+func TestRateLimiter_reserveCombined(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	targets := newRateLimiter(1, time.Minute)
+	teams := newRateLimiter(1, time.Minute)
+	launches := []struct {
+		target string
+		team   string
+		want   time.Duration
+	}{
+		{target: "example.com", team: "team1", want: 0},
+		// Delayed by the team.
+		{target: "example.org", team: "team1", want: time.Minute},
+		// Delayed by the target, that was launched when the team allowed
+		// it, not when it was reserved.
+		{target: "example.org", team: "team2", want: 2 * time.Minute},
+	}
+	for i, l := range launches {
+		got := reserveLaunch(now, rateLimit{targets, l.target}, rateLimit{teams, l.team})
+		if got != l.want {
+			t.Fatalf("reservation %d: want wait %s, got %s", i, l.want, got)
+		}
+	}
+}
+
+func TestRateLimiter_reserveDelayedLaunches(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	targets := newRateLimiter(2, time.Minute)
+	teams := newRateLimiter(1, time.Minute)
+	launches := []struct {
+		at     time.Duration
+		target string
+		team   string
+		want   time.Duration
+	}{
+		{at: 0, target: "example.com", team: "team1", want: 0},
+		{at: 0, target: "example.org", team: "team1", want: time.Minute},
+		// Reserved by the team in the future.
+		{at: 0, target: "example.com", team: "team1", want: 2 * time.Minute},
+		// Not delayed, so it's launched before the previous reservation of
+		// the target.
+		{at: 61 * time.Second, target: "example.com", team: "team2", want: 0},
+		{at: 130 * time.Second, target: "example.com", team: "team3", want: 0},
+		// The reservation of the target at 120s is still in the window.
+		{at: 130 * time.Second, target: "example.com", team: "team4", want: 50 * time.Second},
+	}
+	for i, l := range launches {
+		got := reserveLaunch(start.Add(l.at), rateLimit{targets, l.target}, rateLimit{teams, l.team})
+		if got != l.want {
+			t.Fatalf("reservation %d: want wait %s, got %s", i, l.want, got)
+		}
+	}
+	want := []time.Time{start.Add(120 * time.Second), start.Add(130 * time.Second), start.Add(180 * time.Second)}
+	if diff := cmp.Diff(want, targets.launches["example.com"]); diff != "" {
+		t.Errorf("launches mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRunner_waitRateLimits(t *testing.T) {
+	aborted := stateupdater.StatusAborted
+	tests := []struct {
+		name        string
+		stop        func(cr *Runner)
+		wantDeleted bool
+		wantUpdates []stateupdater.CheckState
+	}{
+		{
+			name: "Aborted",
+			stop: func(cr *Runner) {
+				cr.AbortCheck("check2")
+			},
+			wantDeleted: true,
+			wantUpdates: []stateupdater.CheckState{{ID: "check2", Status: &aborted}},
+		},
+		{
+			name: "Requeued",
+			stop: func(cr *Runner) {
+				cr.RequeueAllChecks()
+			},
+			wantDeleted: false,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			started := make(chan string, 2)
+			b := &mockBackend{
+				CheckRunner: func(ctx context.Context, params backend.RunParams) (<-chan backend.RunResult, error) {
+					started <- params.CheckID
+					res := make(chan backend.RunResult, 1)
+					go func() {
+						<-ctx.Done()
+						res <- backend.RunResult{Error: ctx.Err()}
+					}()
+					return res, nil
+				},
+			}
+			updater := &inMemChecksUpdater{}
+			cr := New(&log.NullLog{}, b, updater, &inMemAbortedChecks{}, RunnerConfig{
+				MaxTokens:              2,
+				DefaultTimeout:         60,
+				MaxProcessMessageTimes: 1,
+				TargetRateLimit:        1,
+			})
+			job1 := runJobFixture1
+			job1.CheckID = "check1"
+			job2 := runJobFixture1
+			job2.CheckID = "check2"
+			processed1 := cr.ProcessMessage(queue.Message{Body: string(mustMarshal(job1)), TimesRead: 1}, <-cr.Tokens)
+			<-started
+			processed2 := cr.ProcessMessage(queue.Message{Body: string(mustMarshal(job2)), TimesRead: 1}, <-cr.Tokens)
+			for !cr.CheckRunning("check2") {
+				time.Sleep(time.Millisecond)
+			}
+			tt.stop(cr)
+			select {
+			case deleted := <-processed2:
+				if deleted != tt.wantDeleted {
+					t.Errorf("got message deleted %v, want %v", deleted, tt.wantDeleted)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("rate limited check not stopped")
+			}
+			select {
+			case id := <-started:
+				t.Errorf("rate limited check %s launched", id)
+			default:
+			}
+			if diff := cmp.Diff(tt.wantUpdates, updater.updates); diff != "" {
+				t.Errorf("want updates != got updates, diff: %s", diff)
+			}
+			cr.AbortCheck("check1")
+			<-processed1
+		})
+	}
+}
@@ -6,13 +6,20 @@ package jobrunner
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/events"
 	"github.com/adevinta/vulcan-agent/log"
 	"github.com/adevinta/vulcan-agent/queue"
 	"github.com/adevinta/vulcan-agent/stateupdater"
@@ -30,9 +37,13 @@ var (
 	// DefaultMaxMessageProcessedTimes defines the maximun number of times the
 	// processor tries to processe a checks message before it declares the check
 	// as failed.
-	DefaultMaxMessageProcessedTimes = 200
+	DefaultMaxMessageProcessedTimes = config.DefaultMaxProcessMessageTimes
 )
 
+// CostMetadataKey defines the key of the job metadata that can be used to
+// specify the number of tokens a check consumes when running.
+const CostMetadataKey = "cost"
+
 type token = struct{}
 
 type checkAborter struct {
@@ -73,6 +84,16 @@ func (c *checkAborter) AbortAll() {
 	})
 }
 
+// IDs returns the IDs of the checks tracked by the aborter.
+func (c *checkAborter) IDs() []string {
+	var ids []string
+	c.cancels.Range(func(k, _ interface{}) bool {
+		ids = append(ids, k.(string))
+		return true
+	})
+	return ids
+}
+
 // Running returns the number the checks that are in a given point of time being
 // tracked by the Aborter component. In other words the number of checks
 // running.
@@ -98,6 +119,76 @@ type AbortedChecks interface {
 	IsAborted(ID string) (bool, error)
 }
 
+// CompletedChecks defines the shape of the component used by a Runner to know
+// if a check already has a final status. It is optional, when the Completed
+// field of a Runner is nil the duplicated jobs of a check are run again.
+type CompletedChecks interface {
+	Completed(ID string) bool
+}
+
+// ResultCache defines the shape of the component used by a Runner to reuse the
+// results of identical checks that finished successfully. It is optional, when
+// the ResultCache of a Runner is nil all the checks are executed.
+type ResultCache interface {
+	PublishCached(key, checkID string, startTime time.Time) (bool, error)
+	Track(checkID, key string)
+	Untrack(checkID string)
+}
+
+// DynamicVars defines the shape of the component used by a Runner to issue
+// values, like short-lived credentials, for the required vars of a check. The
+// returned func must be called when the check finishes. It is optional, when
+// the DynamicVars of a Runner is nil the values of the vars are the ones
+// configured in the backend.
+type DynamicVars interface {
+	Issue(ctx context.Context, checkID string, requiredVars []string) (map[string]string, func(), error)
+}
+
+// ArtifactStore defines the shape of the component used by a Runner to store
+// the artifacts collected by the backend from the checks. It is optional,
+// when the Artifacts of a Runner is nil the artifacts are discarded.
+type ArtifactStore interface {
+	UpdateCheckArtifact(checkID string, startTime time.Time, name string, data []byte) (string, error)
+}
+
+// MessageVerifier defines the shape of the component used by a Runner to
+// authenticate the messages of the jobs before running them. Verify returns
+// the job contained in an authentic message. It is optional, when the
+// Verifier of a Runner is nil the messages are not authenticated.
+type MessageVerifier interface {
+	Verify(body string) (string, error)
+}
+
+// DuplicateCounter defines the shape of the component used by a Runner to
+// count the jobs received for a check that is already being processed. It
+// is optional, the Runner always counts them, see DuplicateJobs.
+type DuplicateCounter interface {
+	DuplicateJob()
+}
+
+// EventPublisher defines the shape of the component used by a Runner to
+// publish the events of the jobs it processes. It is optional, when the
+// Events of a Runner is nil the events are not published.
+type EventPublisher interface {
+	Publish(e events.Event)
+}
+
+// CheckLogger defines the shape of the component used by a Runner to write
+// the logs about each check to its own log, apart from the main one. It is
+// optional, when the CheckLogs of a Runner is nil only the Logger is used.
+type CheckLogger interface {
+	For(checkID string) log.Logger
+}
+
+// CheckQuota defines the shape of the component used by a Runner to limit the
+// number of checks it runs. Take is called once per job that is going to be
+// run, after the job has been validated and admitted, and the job returns to
+// the queue if it returns false. It is optional, when the Quota of a Runner
+// is nil the number of checks is not limited.
+type CheckQuota interface {
+	Take() bool
+}
+
 // Runner runs the checks associated to a concreate message by receiving calls
 // to it ProcessMessage function.
 type Runner struct {
@@ -109,10 +200,78 @@ type Runner struct {
 	Tokens                   chan interface{}
 	Logger                   log.Logger
 	CheckUpdater             CheckStateUpdater
+	ResultCache              ResultCache
+	DynamicVars              DynamicVars
+	Artifacts                ArtifactStore
+	Completed                CompletedChecks
+	Audit                    AuditLog
+	Verifier                 MessageVerifier
+	CheckLogs                CheckLogger
+	Duplicates               DuplicateCounter
+	Events                   EventPublisher
+	Quota                    CheckQuota
 	cAborter                 *checkAborter
 	abortedChecks            AbortedChecks
 	defaultTimeout           time.Duration
 	maxMessageProcessedTimes int
+	checkCosts               map[string]int
+	killGrace                time.Duration
+	targetLimiter            *rateLimiter
+	teamLimiter              *rateLimiter
+	// weightedMu serializes the acquisition of the extra tokens needed by the
+	// checks with a cost greater than one, and weightedWaiting is the number
+	// of those checks waiting for their tokens.
+	weightedMu      sync.Mutex
+	weightedWaiting int32
+	// settingsMu protects the settings that can be changed while the Runner
+	// is running.
+	settingsMu sync.Mutex
+	// withhold is the number of tokens of the pool that must be kept out of
+	// circulation to honor the current max number of tokens, and held the
+	// number of them actually kept.
+	withhold int
+	held     int
+	// pauses contains the reasons why the Runner must keep all the tokens
+	// out of circulation.
+	pauses map[string]struct{}
+	// requeued contains the IDs of the checks stopped to be run again.
+	requeued sync.Map
+	// running contains the RunningCheck of each check that is running.
+	running sync.Map
+	// jobTokens is the number of tokens held by the jobs being processed.
+	jobTokens int32
+	// watched contains the watchedJob of each check that is running.
+	watched       sync.Map
+	watchdogGrace time.Duration
+	// durations contains the durations of the last checks run.
+	durations durationWindow
+	// admission checks the conditions of the host before running the jobs.
+	admission *admission
+	// audits contains the jobAudit of each job being processed, indexed by
+	// the channel used to signal that the job is processed.
+	audits sync.Map
+	// jobs contains the channel used to signal that a job is processed of
+	// each job being processed, indexed by the ID of its check.
+	jobs sync.Map
+	// requeueDuplicates makes the duplicated jobs return to the queue
+	// instead of being discarded, and duplicates counts them.
+	requeueDuplicates bool
+	duplicates        int64
+	// uploaders limits the number of jobs storing the results of their
+	// checks at the same time, and uploading is the number of them.
+	uploaders chan token
+	uploading int32
+}
+
+// RunningCheck describes a check that is running.
+type RunningCheck struct {
+	CheckID   string
+	ScanID    string
+	Checktype string
+	Image     string
+	Target    string
+	StartTime time.Time
+	Metadata  map[string]string
 }
 
 // RunnerConfig contains config parameters for a Runner.
@@ -120,6 +279,35 @@ type RunnerConfig struct {
 	MaxTokens              int
 	DefaultTimeout         int
 	MaxProcessMessageTimes int
+	// CheckCosts defines the number of tokens that the checks of a given
+	// checktype consume. The checktypes not present in the map consume one
+	// token.
+	CheckCosts map[string]int
+	// TargetRateLimit and TeamRateLimit define the maximum number of checks
+	// per minute that can be launched against the same target or for the
+	// same team. A value of 0 means no limit.
+	TargetRateLimit int
+	TeamRateLimit   int
+	// KillGrace is the time, in seconds, the backend waits for a check that
+	// timed out or was aborted to stop after asking it to, before killing
+	// it. 0 means using the default of the backend.
+	KillGrace int
+	// WatchdogGrace is the time, in seconds, that a check can run over its
+	// timeout and kill grace before the watchdog finishes it. If it's 0 the
+	// DefaultWatchdogGrace is used.
+	WatchdogGrace int
+	// Admission defines the conditions of the host required to run new
+	// jobs.
+	Admission AdmissionConfig
+	// RequeueDuplicates makes the jobs received for a check that is already
+	// being processed return to the queue, so they are received again once
+	// that check finishes, instead of being discarded.
+	RequeueDuplicates bool
+	// UploadWorkers is the maximum number of checks whose logs and
+	// artifacts are stored at the same time. The checks free their tokens
+	// when they finish running, so new checks can run while their results
+	// are stored. 0 means MaxTokens.
+	UploadWorkers int
 }
 
 // New creates a Runner initialized with the given log, backend and
@@ -134,6 +322,12 @@ func New(logger log.Logger, backend backend.Backend, checkUpdater CheckStateUpda
 	if cfg.MaxProcessMessageTimes < 1 {
 		cfg.MaxProcessMessageTimes = DefaultMaxMessageProcessedTimes
 	}
+	if cfg.WatchdogGrace < 1 {
+		cfg.WatchdogGrace = DefaultWatchdogGrace
+	}
+	if cfg.UploadWorkers < 1 {
+		cfg.UploadWorkers = cfg.MaxTokens
+	}
 	return &Runner{
 		Backend:      backend,
 		Tokens:       tokens,
@@ -145,6 +339,14 @@ func New(logger log.Logger, backend backend.Backend, checkUpdater CheckStateUpda
 		Logger:                   logger,
 		maxMessageProcessedTimes: cfg.MaxProcessMessageTimes,
 		defaultTimeout:           time.Duration(cfg.DefaultTimeout * int(time.Second)),
+		checkCosts:               cfg.CheckCosts,
+		targetLimiter:            newRateLimiter(cfg.TargetRateLimit, rateLimitPeriod),
+		teamLimiter:              newRateLimiter(cfg.TeamRateLimit, rateLimitPeriod),
+		killGrace:                time.Duration(cfg.KillGrace) * time.Second,
+		watchdogGrace:            time.Duration(cfg.WatchdogGrace) * time.Second,
+		admission:                newAdmission(cfg.Admission),
+		requeueDuplicates:        cfg.RequeueDuplicates,
+		uploaders:                make(chan token, cfg.UploadWorkers),
 	}
 }
 
@@ -153,11 +355,64 @@ func (cr *Runner) AbortCheck(ID string) {
 	cr.cAborter.Abort(ID)
 }
 
+// CheckRunning returns true if the check with the given ID is running.
+func (cr *Runner) CheckRunning(ID string) bool {
+	return cr.cAborter.Exist(ID)
+}
+
+// AbortScan aborts all the running checks that belong to the scan with the
+// given ID and returns their IDs.
+func (cr *Runner) AbortScan(scanID string) []string {
+	var ids []string
+	cr.running.Range(func(k, v interface{}) bool {
+		if v.(RunningCheck).ScanID == scanID {
+			ids = append(ids, k.(string))
+		}
+		return true
+	})
+	for _, id := range ids {
+		cr.cAborter.Abort(id)
+	}
+	return ids
+}
+
+// RunningChecks returns the checks that are running sorted by start time.
+func (cr *Runner) RunningChecks() []RunningCheck {
+	var checks []RunningCheck
+	cr.running.Range(func(_, v interface{}) bool {
+		checks = append(checks, v.(RunningCheck))
+		return true
+	})
+	sort.Slice(checks, func(i, j int) bool {
+		return checks[i].StartTime.Before(checks[j].StartTime)
+	})
+	return checks
+}
+
+// RunningCheck returns the check with the given ID if it is running.
+func (cr *Runner) RunningCheck(ID string) (RunningCheck, bool) {
+	v, ok := cr.running.Load(ID)
+	if !ok {
+		return RunningCheck{}, false
+	}
+	return v.(RunningCheck), true
+}
+
 // AbortAllChecks aborts all the checks that are running.
 func (cr *Runner) AbortAllChecks(ID string) {
 	cr.cAborter.AbortAll()
 }
 
+// RequeueAllChecks stops all the checks that are running without setting
+// their status nor deleting their messages, so they are run again when the
+// messages are visible again in the queue.
+func (cr *Runner) RequeueAllChecks() {
+	for _, id := range cr.cAborter.IDs() {
+		cr.requeued.Store(id, struct{}{})
+	}
+	cr.cAborter.AbortAll()
+}
+
 // FreeTokens returns a channel that can be used to get a free token to call the
 // ProcessMessage method.
 func (cr *Runner) FreeTokens() chan interface{} {
@@ -169,31 +424,133 @@ func (cr *Runner) FreeTokens() chan interface{} {
 // anything with the token, the parameter is present just to make obvious that
 // there must be free tokens on the channel before calling this method. When the
 // message if processed the channel returned will indicate if the message must
-// be deleted or not.
+// be deleted or not. The token is returned to the pool when the check finishes
+// running, that can be before its results are stored and the message is
+// processed.
 func (cr *Runner) ProcessMessage(msg queue.Message, token interface{}) <-chan bool {
 	processed := make(chan bool, 1)
+	atomic.AddInt32(&cr.jobTokens, 1)
 	go cr.runJob(msg, token, processed)
 	return processed
 }
 
+// TakeTokens takes, without blocking, up to n free tokens, so a queue reader
+// can receive as many messages as tokens it holds. No tokens are returned
+// while there are checks waiting for the extra tokens they need, so the
+// tokens freed go to them before being used to receive more messages.
+func (cr *Runner) TakeTokens(n int) []interface{} {
+	var tokens []interface{}
+	for len(tokens) < n && atomic.LoadInt32(&cr.weightedWaiting) == 0 {
+		select {
+		case t := <-cr.Tokens:
+			tokens = append(tokens, t)
+		default:
+			return tokens
+		}
+	}
+	return tokens
+}
+
+// CheckMessage returns an error if the message doesn't contain a valid job.
+func (cr *Runner) CheckMessage(msg queue.Message) error {
+	body, err := cr.jobBody(msg)
+	if err != nil {
+		return err
+	}
+	j := &Job{}
+	if err := json.Unmarshal([]byte(body), j); err != nil {
+		return fmt.Errorf("invalid job: %w", err)
+	}
+	return j.Validate()
+}
+
+// jobBody returns the job contained in a message, once the message is
+// authenticated, if the Runner has a Verifier. The messages generated by
+// the agent itself are not authenticated.
+func (cr *Runner) jobBody(msg queue.Message) (string, error) {
+	if cr.Verifier == nil || msg.Local {
+		return msg.Body, nil
+	}
+	return cr.Verifier.Verify(msg.Body)
+}
+
+// ReleaseToken gives back to the pool a token obtained from the Tokens channel
+// that is not going to be used to process a message.
+func (cr *Runner) ReleaseToken(t interface{}) {
+	if _, ok := t.(token); !ok {
+		cr.Logger.Errorf("releasing token: %v", ErrInvalidToken)
+		return
+	}
+	cr.putToken()
+}
+
 func (cr *Runner) runJob(m queue.Message, t interface{}, processed chan bool) {
 	// Check the token is valid.
 	if _, ok := t.(token); !ok {
 		cr.finishJob("", processed, false, ErrInvalidToken)
 		return
 	}
+	// The messages that can't be authenticated are discarded without
+	// trusting any of their contents, not even the ID of their check.
 	j := &Job{}
-	err := json.Unmarshal([]byte(m.Body), j)
+	body, err := cr.jobBody(m)
+	if err == nil {
+		err = json.Unmarshal([]byte(body), j)
+	}
+	cr.auditReceived(m, j.CheckID, processed)
 	if err != nil {
 		cr.finishJob("", processed, true, err)
 		return
 	}
-	cr.Logger.Infof("running check %s", j.CheckID)
+	cr.publish(events.Event{Type: events.TypeJobReceived, CheckID: j.CheckID})
+	// The invalid jobs are not run, and their checks are set as MALFORMED
+	// when they can be identified.
+	if err := j.Validate(); err != nil {
+		if j.CheckID != "" {
+			status := stateupdater.StatusMalformed
+			uerr := cr.updateFinalState(
+				stateupdater.CheckState{
+					ID:     j.CheckID,
+					Status: &status,
+				})
+			if uerr != nil {
+				uerr = fmt.Errorf("error updating the status of the check: %s, error: %w", j.CheckID, uerr)
+				cr.finishJob(j.CheckID, processed, false, uerr)
+				return
+			}
+		}
+		cr.finishJob(j.CheckID, processed, true, err)
+		return
+	}
+	// The jobs of the checks that already have a final status are
+	// duplicated deliveries of jobs already run, so they are discarded.
+	if cr.Completed != nil && cr.Completed.Completed(j.CheckID) {
+		cr.logger(j.CheckID).Infof("check %s already completed, discarding its job", j.CheckID)
+		cr.finishJob(j.CheckID, processed, true, nil)
+		return
+	}
+	// The jobs of the checks that are already being processed, e.g. the
+	// messages delivered again while their checks run, are not run twice.
+	if _, loaded := cr.jobs.LoadOrStore(j.CheckID, (chan<- bool)(processed)); loaded {
+		cr.duplicateJob(j.CheckID, processed)
+		return
+	}
+	// The jobs are not run while the host is unhealthy, e.g. without free
+	// disk, and their messages are returned to the queue.
+	if err := cr.admit(); err != nil {
+		cr.finishJob(j.CheckID, processed, false, err)
+		return
+	}
+	cr.logger(j.CheckID).Infof("running check %s", j.CheckID)
+	cr.logger(j.CheckID).Debugf("check %s: image %s, target %s, asset type %s", j.CheckID, j.Image, j.Target, j.AssetType)
+	for _, w := range j.Warnings() {
+		cr.logger(j.CheckID).Infof("warning, job of check %s uses a deprecated format: %s", j.CheckID, w)
+	}
 	// Check if the message has been processed more than the maximum defined
 	// times.
 	if m.TimesRead > cr.maxMessageProcessedTimes {
 		status := stateupdater.StatusFailed
-		err = cr.CheckUpdater.UpdateState(
+		err = cr.updateFinalState(
 			stateupdater.CheckState{
 				ID:     j.CheckID,
 				Status: &status,
@@ -203,7 +560,7 @@ func (cr *Runner) runJob(m queue.Message, t interface{}, processed chan bool) {
 			cr.finishJob(j.CheckID, processed, false, err)
 			return
 		}
-		cr.Logger.Errorf("error max processed times exceeded for check: %s", j.CheckID)
+		cr.logger(j.CheckID).Errorf("error max processed times exceeded for check: %s", j.CheckID)
 		cr.finishJob(j.CheckID, processed, true, nil)
 		return
 	}
@@ -217,7 +574,7 @@ func (cr *Runner) runJob(m queue.Message, t interface{}, processed chan bool) {
 
 	if aborted {
 		status := stateupdater.StatusAborted
-		err = cr.CheckUpdater.UpdateState(
+		err = cr.updateFinalState(
 			stateupdater.CheckState{
 				ID:     j.CheckID,
 				Status: &status,
@@ -227,87 +584,197 @@ func (cr *Runner) runJob(m queue.Message, t interface{}, processed chan bool) {
 			cr.finishJob(j.CheckID, processed, false, err)
 			return
 		}
-		cr.Logger.Infof("check %s already aborted", j.CheckID)
+		cr.logger(j.CheckID).Infof("check %s already aborted", j.CheckID)
 		cr.finishJob(j.CheckID, processed, true, nil)
 		return
 	}
 
+	if cr.ResultCache != nil {
+		key := cr.resultCacheKey(j)
+		published, err := cr.ResultCache.PublishCached(key, j.CheckID, j.StartTime)
+		if err != nil {
+			cr.logger(j.CheckID).Errorf("error publishing cached result for check %s: %+v", j.CheckID, err)
+		}
+		if published {
+			cr.CheckUpdater.DeleteCheckStatusTerminal(j.CheckID)
+			cr.finishJob(j.CheckID, processed, true, nil)
+			return
+		}
+		cr.ResultCache.Track(j.CheckID, key)
+		defer cr.ResultCache.Untrack(j.CheckID)
+	}
+
 	var timeout time.Duration
 	if j.Timeout != 0 {
 		timeout = time.Duration(j.Timeout * int(time.Second))
 	} else {
+		cr.settingsMu.Lock()
 		timeout = cr.defaultTimeout
+		cr.settingsMu.Unlock()
+	}
+
+	ctName, ctVersion, err := getChecktypeInfo(j.Image)
+	if err != nil {
+		cr.finishJob(j.CheckID, processed, false, err)
+		return
 	}
 
+	if cr.Quota != nil && !cr.Quota.Take() {
+		cr.logger(j.CheckID).Infof("quota of checks exhausted, returning check %s to the queue", j.CheckID)
+		cr.finishJob(j.CheckID, processed, false, nil)
+		return
+	}
+
+	// Take the extra tokens needed by the check, if any, before starting to
+	// count the timeout.
+	extra := cr.acquireExtraTokens(cr.jobCost(j, ctName))
+	defer func() { cr.releaseTokens(extra) }()
+
 	// Create the context under which the backend will execute the check. The
 	// context will be cancelled either because the function cancel will be
 	// called by the aborter or because the timeout for the check has elapsed.
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
-	err = cr.cAborter.Add(j.CheckID, cancel)
+	ctx, abort := context.WithCancel(context.Background())
+	defer abort()
+	err = cr.cAborter.Add(j.CheckID, abort)
 	// The above function can only return an error if the check already exists.
 	// So we just avoid executing it twice.
 	if err != nil {
 		cr.finishJob(j.CheckID, processed, true, err)
 		return
 	}
-	ctName, ctVersion, err := getChecktypeInfo(j.Image)
-	if err != nil {
+	// Reserve the launch of the check, so the rate limits of its target and
+	// its team are not exceeded, and wait for it. The check is already
+	// tracked, so it can be aborted or stopped to be run again while it
+	// waits.
+	wait := cr.reserveRateLimits(j)
+	cr.running.Store(j.CheckID, RunningCheck{
+		CheckID:   j.CheckID,
+		ScanID:    j.ScanID,
+		Checktype: ctName,
+		Image:     j.Image,
+		Target:    j.Target,
+		StartTime: time.Now().Add(wait),
+		Metadata:  j.Metadata,
+	})
+	defer cr.running.Delete(j.CheckID)
+	if err := cr.waitRateLimits(ctx, j, wait); err != nil {
 		cr.cAborter.Remove(j.CheckID)
-		cr.finishJob(j.CheckID, processed, false, err)
+		cr.finishCanceled(j, processed)
 		return
 	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	// The watchdog finishes the job if it's stuck after the deadline.
+	// From now on the extra tokens are returned through the watched job, so
+	// the watchdog can return them if the job gets stuck.
+	wj := cr.watch(j.CheckID, processed, time.Now().Add(timeout+cr.killGrace+cr.watchdogGrace), extra)
+	extra = 0
+	defer cr.unwatch(wj)
+	defer cr.freeExtraTokens(wj)
 	runParams := backend.RunParams{
 		CheckID:          j.CheckID,
+		ScanID:           j.ScanID,
 		Target:           j.Target,
 		Image:            j.Image,
 		AssetType:        j.AssetType,
 		Options:          j.Options,
 		RequiredVars:     j.RequiredVars,
+		Metadata:         j.Metadata,
 		CheckTypeName:    ctName,
 		ChecktypeVersion: ctVersion,
+		KillGrace:        cr.killGrace,
 	}
+	release := func() {}
+	if cr.DynamicVars != nil {
+		runParams.Vars, release, err = cr.DynamicVars.Issue(ctx, j.CheckID, j.RequiredVars)
+		if err != nil {
+			cr.cAborter.Remove(j.CheckID)
+			cr.finishWatched(wj, false, err)
+			return
+		}
+	}
+	started := time.Now()
+	cr.auditStarted(processed, j, started)
 	finished, err := cr.Backend.Run(ctx, runParams)
 	if err != nil {
+		release()
 		cr.cAborter.Remove(j.CheckID)
-		cr.finishJob(j.CheckID, processed, false, err)
+		// The checks missing required vars are not started, so they don't
+		// run with, e.g., empty credentials, and are not retried because
+		// they would fail in the same way.
+		if errors.Is(err, backend.ErrMissingVars) {
+			status := stateupdater.StatusMissingVars
+			uerr := cr.updateFinalState(
+				stateupdater.CheckState{
+					ID:     j.CheckID,
+					Status: &status,
+				})
+			if uerr != nil {
+				uerr = fmt.Errorf("error updating the status of the check: %s, error: %w", j.CheckID, uerr)
+				cr.finishWatched(wj, false, uerr)
+				return
+			}
+			cr.finishWatched(wj, true, err)
+			return
+		}
+		cr.finishWatched(wj, false, err)
 		return
 	}
-	var logsLink string
+	cr.publish(events.Event{Type: events.TypeCheckStarted, CheckID: j.CheckID})
 	// The finished channel is written by the backend when a check has finished.
 	// The value written to the channel contains the logs of the check(stdin and
 	// stdout) plus a field Error indicanting if there were any unexpected error
 	// running the execution. If that error is not nil the backend was unable to
 	// retrieve the output of the check so the Output field will be nil.
 	res := <-finished
+	atomic.StoreInt32(&wj.ran, 1)
+	cr.auditRan(processed, j, res)
+	cr.durations.add(time.Since(started))
+	// The values issued for the vars of the check are not needed anymore.
+	release()
 	// When the check is finished it can not be aborted anymore
 	// so we remove it from aborter.
 	cr.cAborter.Remove(j.CheckID)
 
+	// The checks stopped to be run again finish without updating their
+	// status and without deleting their messages.
+	if _, ok := cr.requeued.LoadAndDelete(j.CheckID); ok && errors.Is(res.Error, context.Canceled) {
+		cr.CheckUpdater.DeleteCheckStatusTerminal(j.CheckID)
+		cr.logger(j.CheckID).Infof("check %s stopped to be run again", j.CheckID)
+		cr.finishWatched(wj, false, nil)
+		return
+	}
+
 	// We query if the check has sent any status update with a terminal status.
 	isterminal := cr.CheckUpdater.CheckStatusTerminal(j.CheckID)
 	// We signal the CheckUpdater that we don't need it to store that
 	// information anymore.
 	cr.CheckUpdater.DeleteCheckStatusTerminal(j.CheckID)
 
-	// Try always to upload the logs of the check if present.
-	if res.Output != nil {
-		logsLink, err = cr.CheckUpdater.UpdateCheckRaw(j.CheckID, j.StartTime, res.Output)
-		if err != nil {
-			err = fmt.Errorf("error storing the logs of the check: %s, error %w", j.CheckID, err)
-			cr.finishJob(j.CheckID, processed, false, err)
-			return
-		}
-		// Set the link for the logs of the check.
-		err = cr.CheckUpdater.UpdateState(stateupdater.CheckState{
-			ID:  j.CheckID,
-			Raw: &logsLink,
-		})
-		if err != nil {
-			err = fmt.Errorf("error updating the link to the logs of the check: %s, error: %w", j.CheckID, err)
-			cr.finishJob(j.CheckID, processed, false, err)
-			return
-		}
+	// The results of the check are stored once an uploader is free, and
+	// then the tokens of the check are freed, so new checks can run while
+	// they are stored, even when the results service is slow. Waiting for
+	// the uploader before freeing the tokens bounds the number of checks
+	// whose results are pending to be stored.
+	freeUploader := cr.acquireUploader()
+	defer freeUploader()
+	cr.running.Delete(j.CheckID)
+	cr.freeExtraTokens(wj)
+	cr.freeToken(wj)
+
+	// The logs and the artifacts of the check are stored at the same time.
+	artifactsStored := make(chan struct{})
+	go func() {
+		// The artifacts are stored on a best effort basis, so an error
+		// storing them doesn't make the check fail.
+		cr.storeArtifacts(j, res.Artifacts)
+		close(artifactsStored)
+	}()
+	err = cr.storeLogs(j, res.Output)
+	<-artifactsStored
+	if err != nil {
+		cr.finishWatched(wj, false, err)
+		return
 	}
 	// Check if the backend returned any not expected error while running the check.
 	execErr := res.Error
@@ -315,7 +782,7 @@ func (cr *Runner) runJob(m queue.Message, t interface{}, processed chan bool) {
 		!errors.Is(execErr, context.DeadlineExceeded) &&
 		!errors.Is(execErr, context.Canceled) &&
 		!errors.Is(execErr, backend.ErrNonZeroExitCode) {
-		cr.finishJob(j.CheckID, processed, false, execErr)
+		cr.finishWatched(wj, false, execErr)
 		return
 	}
 
@@ -339,10 +806,36 @@ func (cr *Runner) runJob(m queue.Message, t interface{}, processed chan bool) {
 	}
 	// If the check was not canceled or aborted we just finish its execution.
 	if status == "" {
-		cr.finishJob(j.CheckID, processed, true, err)
+		cr.finishWatched(wj, true, err)
 		return
 	}
-	err = cr.CheckUpdater.UpdateState(stateupdater.CheckState{
+	state := stateupdater.CheckState{
+		ID:     j.CheckID,
+		Status: &status,
+	}
+	if status == stateupdater.StatusTimeout {
+		elapsed := int64(time.Since(started).Seconds())
+		state.Elapsed = &elapsed
+		cr.logger(j.CheckID).Infof("check %s timed out after %ds", j.CheckID, elapsed)
+	}
+	err = cr.updateFinalState(state)
+	if err != nil {
+		err = fmt.Errorf("error updating the status of the check: %s, error: %w", j.CheckID, err)
+	}
+	cr.finishWatched(wj, err == nil, err)
+}
+
+// finishCanceled finishes a job whose check was canceled before being
+// launched. The checks stopped to be run again finish without updating their
+// status and without deleting their messages, the rest are aborted.
+func (cr *Runner) finishCanceled(j *Job, processed chan<- bool) {
+	if _, ok := cr.requeued.LoadAndDelete(j.CheckID); ok {
+		cr.logger(j.CheckID).Infof("check %s stopped to be run again", j.CheckID)
+		cr.finishJob(j.CheckID, processed, false, nil)
+		return
+	}
+	status := stateupdater.StatusAborted
+	err := cr.updateFinalState(stateupdater.CheckState{
 		ID:     j.CheckID,
 		Status: &status,
 	})
@@ -352,27 +845,370 @@ func (cr *Runner) runJob(m queue.Message, t interface{}, processed chan bool) {
 	cr.finishJob(j.CheckID, processed, err == nil, err)
 }
 
+// updateFinalState updates the state of a check with a final status. The
+// updates rejected because the check already has a final status, e.g. the
+// one set by the check itself, or the same one, are not considered an error.
+func (cr *Runner) updateFinalState(s stateupdater.CheckState) error {
+	err := cr.CheckUpdater.UpdateState(s)
+	if errors.Is(err, stateupdater.ErrInvalidTransition) || errors.Is(err, stateupdater.ErrDuplicateStatus) {
+		cr.logger(s.ID).Infof("not updating the status of the check %s: %+v", s.ID, err)
+		return nil
+	}
+	return err
+}
+
+// acquireUploader waits until an uploader is free and returns the func that
+// frees it. The Runners not created with New don't limit the uploads.
+func (cr *Runner) acquireUploader() func() {
+	if cr.uploaders == nil {
+		return func() {}
+	}
+	cr.uploaders <- token{}
+	atomic.AddInt32(&cr.uploading, 1)
+	return func() {
+		atomic.AddInt32(&cr.uploading, -1)
+		<-cr.uploaders
+	}
+}
+
+// storeLogs stores the logs of a check, if present, and sets the link to
+// them in the state of the check.
+func (cr *Runner) storeLogs(j *Job, output []byte) error {
+	if output == nil {
+		return nil
+	}
+	link, err := cr.CheckUpdater.UpdateCheckRaw(j.CheckID, j.StartTime, output)
+	if err != nil {
+		return fmt.Errorf("error storing the logs of the check: %s, error %w", j.CheckID, err)
+	}
+	err = cr.CheckUpdater.UpdateState(stateupdater.CheckState{
+		ID:  j.CheckID,
+		Raw: &link,
+	})
+	if err != nil {
+		return fmt.Errorf("error updating the link to the logs of the check: %s, error: %w", j.CheckID, err)
+	}
+	return nil
+}
+
+func (cr *Runner) storeArtifacts(j *Job, artifacts []backend.Artifact) {
+	if len(artifacts) == 0 {
+		return
+	}
+	if cr.Artifacts == nil {
+		cr.logger(j.CheckID).Infof("discarding %d artifacts of the check %s, storing artifacts is not supported", len(artifacts), j.CheckID)
+		return
+	}
+	for _, a := range artifacts {
+		link, err := cr.Artifacts.UpdateCheckArtifact(j.CheckID, j.StartTime, a.Name, a.Data)
+		if err != nil {
+			cr.logger(j.CheckID).Errorf("error storing the artifact %s of the check %s: %+v", a.Name, j.CheckID, err)
+			continue
+		}
+		cr.logger(j.CheckID).Infof("artifact %s of the check %s stored in %s", a.Name, j.CheckID, link)
+	}
+}
+
 func (cr *Runner) finishJob(checkID string, processed chan<- bool, delete bool, err error) {
+	cr.completeJob(checkID, processed, delete, err, true)
+}
+
+// completeJob finishes a job and, if freeToken is true, returns the token it
+// holds to the pool. The jobs storing the results of their checks already
+// returned it.
+func (cr *Runner) completeJob(checkID string, processed chan<- bool, delete bool, err error, freeToken bool) {
 	if err == nil && checkID != "" {
-		cr.Logger.Infof("finished running check %s with no error, mark to be deleted: %+v", checkID, delete)
+		cr.logger(checkID).Infof("finished running check %s with no error, mark to be deleted: %+v", checkID, delete)
 	}
 	if err != nil && checkID != "" {
-		cr.Logger.Errorf("error %+v running check_id %s", err, checkID)
+		cr.logger(checkID).Errorf("error %+v running check_id %s", err, checkID)
 	}
 	if err != nil && checkID == "" {
 		cr.Logger.Errorf("invalid message %+v", err)
 	}
-	// Return a token to free tokens channel.
+	cr.auditFinished(processed, delete, err)
+	cr.publishFinished(checkID, err)
+	if checkID != "" {
+		if p, ok := cr.jobs.Load(checkID); ok && p.(chan<- bool) == processed {
+			cr.jobs.Delete(checkID)
+		}
+	}
+	if freeToken {
+		cr.releaseJobToken()
+	}
+	// Signal the caller that the job related to a message is finalized. It also
+	// states if the message related to the job must be deleted or not.
+	processed <- delete
+	close(processed)
+}
+
+// publish publishes the given event if the Runner has an EventPublisher.
+func (cr *Runner) publish(e events.Event) {
+	if cr.Events != nil {
+		cr.Events.Publish(e)
+	}
+}
+
+// publishFinished publishes the event corresponding to a job that finished
+// with the given error.
+func (cr *Runner) publishFinished(checkID string, err error) {
+	e := events.Event{Type: events.TypeJobFinished, CheckID: checkID}
+	if err != nil {
+		e.Type = events.TypeJobFailed
+		e.Error = err.Error()
+	}
+	cr.publish(e)
+}
+
+// duplicateJob finishes a job received for a check that is already being
+// processed. Its message is deleted or, if the Runner requeues the duplicates,
+// returned to the queue, so it's received again once the check finishes and,
+// if the check completed, discarded then.
+func (cr *Runner) duplicateJob(checkID string, processed chan<- bool) {
+	atomic.AddInt64(&cr.duplicates, 1)
+	if cr.Duplicates != nil {
+		cr.Duplicates.DuplicateJob()
+	}
+	if cr.requeueDuplicates {
+		cr.logger(checkID).Infof("check %s already being processed, returning its duplicated job to the queue", checkID)
+		cr.finishJob(checkID, processed, false, nil)
+		return
+	}
+	cr.logger(checkID).Infof("check %s already being processed, discarding its duplicated job", checkID)
+	cr.finishJob(checkID, processed, true, nil)
+}
+
+// DuplicateJobs returns the number of jobs received for a check that was
+// already being processed.
+func (cr *Runner) DuplicateJobs() int64 {
+	return atomic.LoadInt64(&cr.duplicates)
+}
+
+// logger returns the log for the messages about the given check.
+func (cr *Runner) logger(checkID string) log.Logger {
+	if cr.CheckLogs == nil || checkID == "" {
+		return cr.Logger
+	}
+	return cr.CheckLogs.For(checkID)
+}
+
+// jobCost returns the number of tokens a job consumes. The cost defined in the
+// metadata of the job takes precedence over the one configured for the
+// checktype. The cost is capped to the size of the pool minus one, so the queue
+// reader can always hold a token while waiting for new messages.
+func (cr *Runner) jobCost(j *Job, checktypeName string) int {
+	cost := 1
+	if c, ok := cr.checkCosts[checktypeName]; ok {
+		cost = c
+	}
+	if v, ok := j.Metadata[CostMetadataKey]; ok {
+		c, err := strconv.Atoi(v)
+		if err != nil {
+			cr.logger(j.CheckID).Errorf("invalid cost %q for check %s: %+v", v, j.CheckID, err)
+		} else {
+			cost = c
+		}
+	}
+	if max := cr.maxTokens() - 1; cost > max {
+		cost = max
+	}
+	if cost < 1 {
+		cost = 1
+	}
+	return cost
+}
+
+// acquireExtraTokens takes from the pool the tokens needed by a job with the
+// given cost, apart from the one the job already holds, and returns the
+// number of extra tokens taken. In order to avoid deadlocks between jobs that
+// hold one token while waiting for more, the job gives back its token and
+// takes all the tokens it needs while holding the weightedMu lock.
+func (cr *Runner) acquireExtraTokens(cost int) int {
+	if cost <= 1 {
+		return 0
+	}
+	atomic.AddInt32(&cr.weightedWaiting, 1)
+	defer atomic.AddInt32(&cr.weightedWaiting, -1)
+	cr.weightedMu.Lock()
+	defer cr.weightedMu.Unlock()
+	cr.putToken()
+	for i := 0; i < cost; i++ {
+		<-cr.Tokens
+	}
+	atomic.AddInt32(&cr.jobTokens, int32(cost-1))
+	return cost - 1
+}
+
+// releaseJobToken returns to the pool the token held by a job.
+func (cr *Runner) releaseJobToken() {
+	atomic.AddInt32(&cr.jobTokens, -1)
+	cr.putToken()
+}
+
+// releaseTokens returns n tokens to the pool.
+func (cr *Runner) releaseTokens(n int) {
+	atomic.AddInt32(&cr.jobTokens, int32(-n))
+	for i := 0; i < n; i++ {
+		cr.putToken()
+	}
+}
+
+// putToken returns a token to the pool, unless it must be kept out of
+// circulation because the max number of tokens was decreased or the Runner
+// is paused.
+func (cr *Runner) putToken() {
+	cr.settingsMu.Lock()
+	defer cr.settingsMu.Unlock()
+	if cr.held < cr.kept() {
+		cr.held++
+		return
+	}
 	// This write must not block ever.
 	select {
 	case cr.Tokens <- token{}:
 	default:
 		cr.Logger.Errorf("error, unexpected lock when writing to the tokens channel")
 	}
-	// Signal the caller that the job related to a message is finalized. It also
-	// states if the message related to the job must be deleted or not.
-	processed <- delete
-	close(processed)
+}
+
+// maxTokens returns the current max number of jobs that the Runner can
+// execute at the same time.
+func (cr *Runner) maxTokens() int {
+	cr.settingsMu.Lock()
+	defer cr.settingsMu.Unlock()
+	return cap(cr.Tokens) - cr.withhold
+}
+
+// IdleTokens returns the number of tokens that are out of the pool but not
+// held by any job. The queue reader holds one of them while it waits for new
+// messages, so a greater value means that the tokens are being lost and the
+// Runner will eventually stop running checks.
+func (cr *Runner) IdleTokens() int {
+	cr.settingsMu.Lock()
+	defer cr.settingsMu.Unlock()
+	out := cap(cr.Tokens) - cr.held - len(cr.Tokens)
+	return out - int(atomic.LoadInt32(&cr.jobTokens))
+}
+
+// Capacity returns the current maximum number of tokens of the Runner.
+func (cr *Runner) Capacity() int {
+	return cr.maxTokens()
+}
+
+// FreeSlots returns the number of tokens that are not held by any job, that
+// is, the number of checks of cost one that the Runner could start now. It
+// returns 0 while the Runner is paused.
+func (cr *Runner) FreeSlots() int {
+	if len(cr.Paused()) > 0 {
+		return 0
+	}
+	free := cr.maxTokens() - int(atomic.LoadInt32(&cr.jobTokens))
+	if free < 0 {
+		return 0
+	}
+	return free
+}
+
+// AvgCheckDuration returns the average duration of the last checks run by
+// the Runner, or 0 if no check has been run yet.
+func (cr *Runner) AvgCheckDuration() time.Duration {
+	return cr.durations.avg()
+}
+
+// SetMaxTokens changes the max number of jobs that the Runner can execute at
+// the same time. The new value can't be greater than the size of the pool of
+// tokens, that is, the MaxTokens the Runner was created with. When the value
+// is decreased the running jobs are not affected, the tokens are taken out of
+// circulation as the jobs finish.
+func (cr *Runner) SetMaxTokens(n int) error {
+	if n < 1 || n > cap(cr.Tokens) {
+		return fmt.Errorf("max tokens must be between 1 and %d, got %d", cap(cr.Tokens), n)
+	}
+	cr.settingsMu.Lock()
+	defer cr.settingsMu.Unlock()
+	cr.withhold = cap(cr.Tokens) - n
+	cr.rebalance()
+	return nil
+}
+
+// Pause stops the Runner from handing out tokens, so no new messages are
+// read, until Resume is called with the same reason. The Runner is paused
+// while there are reasons to pause it. The running jobs are not affected and
+// their tokens are kept out of circulation as they finish. The queue reader
+// can still process the message it's waiting for with the token it holds.
+func (cr *Runner) Pause(reason string) {
+	cr.settingsMu.Lock()
+	defer cr.settingsMu.Unlock()
+	if _, ok := cr.pauses[reason]; ok {
+		return
+	}
+	cr.Logger.Infof("pausing the intake of checks: %s", reason)
+	if cr.pauses == nil {
+		cr.pauses = make(map[string]struct{})
+	}
+	cr.pauses[reason] = struct{}{}
+	cr.rebalance()
+}
+
+// Resume removes a reason to pause the Runner and, if there are no more,
+// hands out the tokens again.
+func (cr *Runner) Resume(reason string) {
+	cr.settingsMu.Lock()
+	defer cr.settingsMu.Unlock()
+	if _, ok := cr.pauses[reason]; !ok {
+		return
+	}
+	cr.Logger.Infof("resuming the intake of checks: %s", reason)
+	delete(cr.pauses, reason)
+	cr.rebalance()
+}
+
+// Paused returns the sorted reasons why the Runner is paused.
+func (cr *Runner) Paused() []string {
+	cr.settingsMu.Lock()
+	defer cr.settingsMu.Unlock()
+	var reasons []string
+	for r := range cr.pauses {
+		reasons = append(reasons, r)
+	}
+	sort.Strings(reasons)
+	return reasons
+}
+
+// kept returns the number of tokens that must be kept out of circulation. It
+// must be called with the settingsMu held.
+func (cr *Runner) kept() int {
+	if len(cr.pauses) > 0 {
+		return cap(cr.Tokens)
+	}
+	return cr.withhold
+}
+
+// rebalance puts in, or takes out of, circulation the tokens needed to honor
+// the current max number of tokens and pauses. It must be called with the
+// settingsMu held.
+func (cr *Runner) rebalance() {
+	for cr.held > cr.kept() {
+		cr.Tokens <- token{}
+		cr.held--
+	}
+	for cr.held < cr.kept() {
+		select {
+		case <-cr.Tokens:
+			cr.held++
+		default:
+			return
+		}
+	}
+	return
+}
+
+// SetDefaultTimeout changes the timeout, in seconds, of the checks that don't
+// define one. It only affects to the checks started after calling it.
+func (cr *Runner) SetDefaultTimeout(timeout int) {
+	cr.settingsMu.Lock()
+	defer cr.settingsMu.Unlock()
+	cr.defaultTimeout = time.Duration(timeout) * time.Second
 }
 
 // ChecksRunning returns the current number of checks running.
@@ -380,17 +1216,34 @@ func (cr *Runner) ChecksRunning() int {
 	return cr.cAborter.Running()
 }
 
-// getChecktypeInfo extracts checktype data from a Docker image URI.
-func getChecktypeInfo(imageURI string) (checktypeName string, checktypeVersion string, err error) {
-	domain, path, tag, err := backend.ParseImage(imageURI)
-	if err != nil {
-		err = fmt.Errorf("unable to parse image %s - %w", imageURI, err)
-		return
+// ChecksUploading returns the current number of checks that finished running
+// and are storing their results.
+func (cr *Runner) ChecksUploading() int {
+	return int(atomic.LoadInt32(&cr.uploading))
+}
+
+// resultCacheKey returns the key identifying the results of the checks with
+// the same image, target, asset type and options. The digest of the image is
+// used when the backend is able to provide it.
+func (cr *Runner) resultCacheKey(j *Job) string {
+	image := j.Image
+	if d, ok := cr.Backend.(backend.ImageDigester); ok {
+		digest, err := d.ImageDigest(context.Background(), j.Image)
+		if err != nil {
+			cr.logger(j.CheckID).Debugf("unable to get digest of image %s: %+v", j.Image, err)
+		} else {
+			image = digest
+		}
 	}
-	checktypeName = fmt.Sprintf("%s/%s", domain, path)
-	if domain == "docker.io" {
-		checktypeName = path
+	h := sha256.New()
+	for _, f := range []string{image, j.Target, j.AssetType, j.Options} {
+		h.Write([]byte(f))
+		h.Write([]byte{0})
 	}
-	checktypeVersion = tag
-	return
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// getChecktypeInfo extracts checktype data from a Docker image URI.
+func getChecktypeInfo(imageURI string) (checktypeName string, checktypeVersion string, err error) {
+	return backend.ChecktypeInfo(imageURI)
 }
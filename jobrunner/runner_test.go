@@ -9,15 +9,19 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/adevinta/vulcan-agent/events"
 	"github.com/adevinta/vulcan-agent/log"
 	"github.com/adevinta/vulcan-agent/queue"
 	"github.com/adevinta/vulcan-agent/stateupdater"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/google/uuid"
 )
 
@@ -350,6 +354,44 @@ func TestRunner_ProcessMessage(t *testing.T) {
 				return fmt.Sprintf("%s%s", rawsDiff, updateDiff)
 			},
 		},
+		{
+			name: "SetsMissingVarsStatus",
+			fields: fields{
+				Backend: &mockBackend{
+					CheckRunner: func(ctx context.Context, params backend.RunParams) (<-chan backend.RunResult, error) {
+						return nil, fmt.Errorf("%w: TOKEN", backend.ErrMissingVars)
+					},
+				},
+				cAborter: &checkAborter{
+					cancels: sync.Map{},
+				},
+				aborted:        &inMemAbortedChecks{make(map[string]struct{}), nil},
+				defaultTimeout: time.Duration(10 * time.Second),
+				Tokens:         make(chan interface{}, 10),
+				Logger:         &log.NullLog{},
+				CheckUpdater:   &inMemChecksUpdater{},
+			},
+			args: args{
+				msg: queue.Message{
+					Body: string(mustMarshal(runJobFixture1)),
+				},
+				token: token{},
+			},
+			want: true,
+			wantState: func(r *Runner) string {
+				updater := r.CheckUpdater.(*inMemChecksUpdater)
+				var wantRaws []CheckRaw
+				wantUpdates := []stateupdater.CheckState{
+					{
+						ID:     runJobFixture1.CheckID,
+						Status: str2ptr(stateupdater.StatusMissingVars),
+					},
+				}
+				rawsDiff := cmp.Diff(wantRaws, updater.raws)
+				updateDiff := cmp.Diff(wantUpdates, updater.updates)
+				return fmt.Sprintf("%s%s", rawsDiff, updateDiff)
+			},
+		},
 
 		{
 			name: "UpdatesStateWhenCheckTimedout",
@@ -408,14 +450,17 @@ func TestRunner_ProcessMessage(t *testing.T) {
 				gotUpdates := updater.updates
 				state := stateupdater.StatusTimeout
 				rawLink := fmt.Sprintf("%s/logs", runJobFixture1.CheckID)
+				// The backend of the test returns immediately.
+				var elapsed int64
 				wantUpdates := []stateupdater.CheckState{
 					{
 						ID:  runJobFixture1.CheckID,
 						Raw: &rawLink,
 					},
 					{
-						ID:     runJobFixture1.CheckID,
-						Status: &state,
+						ID:      runJobFixture1.CheckID,
+						Status:  &state,
+						Elapsed: &elapsed,
 						// Raw: &rawLink,
 					},
 				}
@@ -557,6 +602,43 @@ func TestRunner_ProcessMessage(t *testing.T) {
 			},
 		},
 
+		{
+			name: "DoesNotRunInvalidJobs",
+			fields: fields{
+				Backend: &mockBackend{
+					CheckRunner: func(ctx context.Context, params backend.RunParams) (<-chan backend.RunResult, error) {
+						panic("invalid job run")
+					},
+				},
+				cAborter: &checkAborter{
+					cancels: sync.Map{},
+				},
+				aborted:        &inMemAbortedChecks{},
+				defaultTimeout: time.Duration(10 * time.Second),
+				Tokens:         make(chan interface{}, 10),
+				Logger:         &log.NullLog{},
+				CheckUpdater:   &inMemChecksUpdater{},
+			},
+			args: args{
+				msg: queue.Message{
+					Body: `{"check_id": "` + runJobFixture1.CheckID + `", "image": "job1:latest", "target": "example.com", "timeout": -1}`,
+				},
+				token: token{},
+			},
+			want: true,
+			wantState: func(r *Runner) string {
+				updater := r.CheckUpdater.(*inMemChecksUpdater)
+				state := stateupdater.StatusMalformed
+				wantUpdates := []stateupdater.CheckState{
+					{
+						ID:     runJobFixture1.CheckID,
+						Status: &state,
+					},
+				}
+				return cmp.Diff(wantUpdates, updater.updates)
+			},
+		},
+
 		{
 			name: "DontDeleteWhenErrorUpdatingStatus",
 			fields: fields{
@@ -907,3 +989,726 @@ func TestRunner_getChecktypeInfo(t *testing.T) {
 		}
 	}
 }
+
+func TestRunner_jobCost(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxTokens     int
+		checkCosts    map[string]int
+		metadata      map[string]string
+		checktypeName string
+		want          int
+	}{
+		{
+			name:          "DefaultsToOne",
+			maxTokens:     10,
+			checktypeName: "job1",
+			want:          1,
+		},
+		{
+			name:          "UsesChecktypeCost",
+			maxTokens:     10,
+			checkCosts:    map[string]int{"job1": 3},
+			checktypeName: "job1",
+			want:          3,
+		},
+		{
+			name:          "MetadataTakesPrecedence",
+			maxTokens:     10,
+			checkCosts:    map[string]int{"job1": 3},
+			metadata:      map[string]string{CostMetadataKey: "2"},
+			checktypeName: "job1",
+			want:          2,
+		},
+		{
+			name:          "IgnoresInvalidMetadata",
+			maxTokens:     10,
+			checkCosts:    map[string]int{"job1": 3},
+			metadata:      map[string]string{CostMetadataKey: "a lot"},
+			checktypeName: "job1",
+			want:          3,
+		},
+		{
+			name:          "CapsToPoolSizeMinusOne",
+			maxTokens:     4,
+			metadata:      map[string]string{CostMetadataKey: "8"},
+			checktypeName: "job1",
+			want:          3,
+		},
+		{
+			name:          "NeverLessThanOne",
+			maxTokens:     1,
+			metadata:      map[string]string{CostMetadataKey: "4"},
+			checktypeName: "job1",
+			want:          1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := &Runner{
+				Tokens:     make(chan interface{}, tt.maxTokens),
+				Logger:     &log.NullLog{},
+				checkCosts: tt.checkCosts,
+			}
+			j := &Job{CheckID: "id", Metadata: tt.metadata}
+			got := cr.jobCost(j, tt.checktypeName)
+			if got != tt.want {
+				t.Errorf("want cost %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRunner_acquireExtraTokens(t *testing.T) {
+	cr := New(&log.NullLog{}, nil, nil, nil, RunnerConfig{MaxTokens: 4})
+	// Simulate the token taken by the reader for the job.
+	<-cr.Tokens
+	extra := cr.acquireExtraTokens(3)
+	if extra != 2 {
+		t.Fatalf("want 2 extra tokens, got %d", extra)
+	}
+	if n := len(cr.Tokens); n != 1 {
+		t.Fatalf("want 1 free token, got %d", n)
+	}
+	cr.releaseTokens(extra)
+	if n := len(cr.Tokens); n != 3 {
+		t.Fatalf("want 3 free tokens, got %d", n)
+	}
+}
+
+func TestRunner_TakeTokens(t *testing.T) {
+	cr := New(&log.NullLog{}, nil, nil, nil, RunnerConfig{MaxTokens: 4})
+	taken := cr.TakeTokens(2)
+	if len(taken) != 2 {
+		t.Fatalf("want 2 tokens taken, got %d", len(taken))
+	}
+	// Simulate the token taken by the reader for a job that costs 4 tokens.
+	<-cr.Tokens
+	acquired := make(chan int)
+	go func() {
+		acquired <- cr.acquireExtraTokens(4)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&cr.weightedWaiting) == 0 || len(cr.Tokens) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("job not waiting for its tokens")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	// The tokens freed go to the job waiting for them.
+	cr.ReleaseToken(taken[0])
+	if got := cr.TakeTokens(1); len(got) != 0 {
+		t.Fatalf("want no tokens taken while a job is waiting, got %d", len(got))
+	}
+	cr.ReleaseToken(taken[1])
+	if extra := <-acquired; extra != 3 {
+		t.Fatalf("want 3 extra tokens, got %d", extra)
+	}
+	if n := len(cr.Tokens); n != 0 {
+		t.Fatalf("want 0 free tokens, got %d", n)
+	}
+}
+
+func TestRunner_SetMaxTokens(t *testing.T) {
+	cr := New(&log.NullLog{}, nil, nil, nil, RunnerConfig{MaxTokens: 4})
+	// Simulate three jobs running.
+	for i := 0; i < 3; i++ {
+		<-cr.Tokens
+	}
+	if err := cr.SetMaxTokens(2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := len(cr.Tokens); n != 0 {
+		t.Fatalf("want 0 free tokens, got %d", n)
+	}
+	// The first finished job gives its token back to the pool of withheld
+	// tokens, the rest to the channel.
+	cr.putToken()
+	cr.putToken()
+	if n := len(cr.Tokens); n != 1 {
+		t.Fatalf("want 1 free token, got %d", n)
+	}
+	cr.putToken()
+	if n := len(cr.Tokens); n != 2 {
+		t.Fatalf("want 2 free tokens, got %d", n)
+	}
+	if err := cr.SetMaxTokens(4); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := len(cr.Tokens); n != 4 {
+		t.Fatalf("want 4 free tokens, got %d", n)
+	}
+	if err := cr.SetMaxTokens(5); err == nil {
+		t.Fatalf("want error increasing max tokens over the pool size")
+	}
+}
+
+func TestRunner_Pause(t *testing.T) {
+	cr := New(&log.NullLog{}, nil, nil, nil, RunnerConfig{MaxTokens: 3})
+	// Simulate a job running.
+	<-cr.Tokens
+	cr.Pause("results")
+	cr.Pause("api")
+	if n := len(cr.Tokens); n != 0 {
+		t.Fatalf("want 0 free tokens, got %d", n)
+	}
+	if diff := cmp.Diff([]string{"api", "results"}, cr.Paused()); diff != "" {
+		t.Fatalf("pause reasons mismatch (-want +got):\n%v", diff)
+	}
+	// The finished job gives its token back to the withheld tokens.
+	cr.putToken()
+	if n := len(cr.Tokens); n != 0 {
+		t.Fatalf("want 0 free tokens, got %d", n)
+	}
+	cr.Resume("results")
+	if n := len(cr.Tokens); n != 0 {
+		t.Fatalf("want 0 free tokens while paused, got %d", n)
+	}
+	cr.Resume("api")
+	if n := len(cr.Tokens); n != 3 {
+		t.Fatalf("want 3 free tokens, got %d", n)
+	}
+	if got := cr.Paused(); len(got) != 0 {
+		t.Fatalf("want no pause reasons, got %v", got)
+	}
+}
+
+func TestRunner_RequeueAllChecks(t *testing.T) {
+	started := make(chan struct{})
+	b := &mockBackend{
+		CheckRunner: func(ctx context.Context, params backend.RunParams) (<-chan backend.RunResult, error) {
+			res := make(chan backend.RunResult, 1)
+			go func() {
+				close(started)
+				<-ctx.Done()
+				res <- backend.RunResult{Error: ctx.Err()}
+			}()
+			return res, nil
+		},
+	}
+	updater := &inMemChecksUpdater{}
+	cr := New(&log.NullLog{}, b, updater, &inMemAbortedChecks{}, RunnerConfig{
+		MaxTokens:              1,
+		DefaultTimeout:         60,
+		MaxProcessMessageTimes: 1,
+	})
+	body, err := json.Marshal(runJobFixture1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	processed := cr.ProcessMessage(queue.Message{Body: string(body), TimesRead: 1}, <-cr.Tokens)
+	<-started
+	cr.RequeueAllChecks()
+	if deleted := <-processed; deleted {
+		t.Errorf("message of a requeued check deleted")
+	}
+	if len(updater.updates) > 0 {
+		t.Errorf("unexpected state updates of a requeued check: %+v", updater.updates)
+	}
+}
+
+type completedChecksMock map[string]bool
+
+func (c completedChecksMock) Completed(ID string) bool {
+	return c[ID]
+}
+
+func TestRunner_DiscardsCompletedJobs(t *testing.T) {
+	b := &mockBackend{
+		CheckRunner: func(ctx context.Context, params backend.RunParams) (<-chan backend.RunResult, error) {
+			t.Errorf("completed check run again")
+			res := make(chan backend.RunResult, 1)
+			res <- backend.RunResult{}
+			return res, nil
+		},
+	}
+	updater := &inMemChecksUpdater{}
+	cr := New(&log.NullLog{}, b, updater, &inMemAbortedChecks{}, RunnerConfig{
+		MaxTokens:              1,
+		DefaultTimeout:         60,
+		MaxProcessMessageTimes: 1,
+	})
+	cr.Completed = completedChecksMock{runJobFixture1.CheckID: true}
+	body, err := json.Marshal(runJobFixture1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	processed := cr.ProcessMessage(queue.Message{Body: string(body), TimesRead: 1}, <-cr.Tokens)
+	if deleted := <-processed; !deleted {
+		t.Errorf("message of a completed check not deleted")
+	}
+	if len(updater.updates) > 0 {
+		t.Errorf("unexpected state updates of a completed check: %+v", updater.updates)
+	}
+}
+
+type quotaMock struct {
+	left int
+}
+
+func (q *quotaMock) Take() bool {
+	if q.left == 0 {
+		return false
+	}
+	q.left--
+	return true
+}
+
+func TestRunner_Quota(t *testing.T) {
+	var runs []string
+	b := &mockBackend{
+		CheckRunner: func(ctx context.Context, params backend.RunParams) (<-chan backend.RunResult, error) {
+			runs = append(runs, params.CheckID)
+			res := make(chan backend.RunResult, 1)
+			res <- backend.RunResult{}
+			return res, nil
+		},
+	}
+	cr := New(&log.NullLog{}, b, &inMemChecksUpdater{}, &inMemAbortedChecks{}, RunnerConfig{
+		MaxTokens:              1,
+		DefaultTimeout:         60,
+		MaxProcessMessageTimes: 1,
+	})
+	cr.Quota = &quotaMock{left: 1}
+
+	// The invalid jobs don't take from the quota.
+	invalid := runJobFixture1
+	invalid.CheckID = uuid.NewString()
+	invalid.Image = ""
+	if deleted := <-cr.ProcessMessage(queue.Message{Body: string(mustMarshal(invalid)), TimesRead: 1}, <-cr.Tokens); !deleted {
+		t.Errorf("message of an invalid job not deleted")
+	}
+	var got []bool
+	for i := 0; i < 2; i++ {
+		j := runJobFixture1
+		j.CheckID = uuid.NewString()
+		got = append(got, <-cr.ProcessMessage(queue.Message{Body: string(mustMarshal(j)), TimesRead: 1}, <-cr.Tokens))
+	}
+	if diff := cmp.Diff([]bool{true, false}, got); diff != "" {
+		t.Errorf("deleted messages mismatch (-want +got):\n%v", diff)
+	}
+	if len(runs) != 1 {
+		t.Errorf("want 1 check run, got %v", runs)
+	}
+	if n := len(cr.Tokens); n != 1 {
+		t.Errorf("token of the job over the quota not freed, free tokens %d", n)
+	}
+}
+
+func TestRunner_DuplicateJobs(t *testing.T) {
+	tests := []struct {
+		name        string
+		requeue     bool
+		wantDeleted bool
+	}{
+		{
+			name:        "Reject",
+			wantDeleted: true,
+		},
+		{
+			name:        "Requeue",
+			requeue:     true,
+			wantDeleted: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var runs int32
+			started := make(chan struct{})
+			b := &mockBackend{
+				CheckRunner: func(ctx context.Context, params backend.RunParams) (<-chan backend.RunResult, error) {
+					if atomic.AddInt32(&runs, 1) > 1 {
+						t.Errorf("duplicated check run")
+					}
+					res := make(chan backend.RunResult, 1)
+					go func() {
+						close(started)
+						<-ctx.Done()
+						res <- backend.RunResult{Error: ctx.Err()}
+					}()
+					return res, nil
+				},
+			}
+			cr := New(&log.NullLog{}, b, &inMemChecksUpdater{}, &inMemAbortedChecks{}, RunnerConfig{
+				MaxTokens:              2,
+				DefaultTimeout:         60,
+				MaxProcessMessageTimes: 2,
+				RequeueDuplicates:      tt.requeue,
+			})
+			body, err := json.Marshal(runJobFixture1)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			processed := cr.ProcessMessage(queue.Message{Body: string(body), TimesRead: 1}, <-cr.Tokens)
+			<-started
+			duplicated := cr.ProcessMessage(queue.Message{Body: string(body), TimesRead: 2}, <-cr.Tokens)
+			if deleted := <-duplicated; deleted != tt.wantDeleted {
+				t.Errorf("got deleted %v for the duplicated job, want %v", deleted, tt.wantDeleted)
+			}
+			if n := cr.DuplicateJobs(); n != 1 {
+				t.Errorf("got %d duplicate jobs, want 1", n)
+			}
+			cr.RequeueAllChecks()
+			<-processed
+			// Once the check finishes its jobs are not duplicated anymore.
+			if _, ok := cr.jobs.Load(runJobFixture1.CheckID); ok {
+				t.Errorf("check still being processed after finishing")
+			}
+		})
+	}
+}
+
+func TestRunner_Events(t *testing.T) {
+	tests := []struct {
+		name      string
+		runErr    error
+		wantTypes []string
+	}{
+		{
+			name: "Finished",
+			wantTypes: []string{
+				events.TypeJobReceived,
+				events.TypeCheckStarted,
+				events.TypeJobFinished,
+			},
+		},
+		{
+			name:   "Failed",
+			runErr: errors.New("error pulling image"),
+			wantTypes: []string{
+				events.TypeJobReceived,
+				events.TypeJobFailed,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &mockBackend{
+				CheckRunner: func(ctx context.Context, params backend.RunParams) (<-chan backend.RunResult, error) {
+					if tt.runErr != nil {
+						return nil, tt.runErr
+					}
+					res := make(chan backend.RunResult, 1)
+					res <- backend.RunResult{}
+					return res, nil
+				},
+			}
+			cr := New(&log.NullLog{}, b, &inMemChecksUpdater{}, &inMemAbortedChecks{}, RunnerConfig{
+				MaxTokens:              1,
+				DefaultTimeout:         60,
+				MaxProcessMessageTimes: 1,
+			})
+			bus := events.NewBus()
+			evts, cancel := bus.Subscribe(events.Filter{CheckID: runJobFixture1.CheckID})
+			cr.Events = bus
+			<-cr.ProcessMessage(queue.Message{Body: string(mustMarshal(runJobFixture1)), TimesRead: 1}, <-cr.Tokens)
+			cancel()
+			var got []string
+			var errMsg string
+			for e := range evts {
+				got = append(got, e.Type)
+				errMsg = e.Error
+			}
+			if diff := cmp.Diff(tt.wantTypes, got); diff != "" {
+				t.Errorf("events mismatch (-want +got):\n%v", diff)
+			}
+			if tt.runErr != nil && !strings.Contains(errMsg, tt.runErr.Error()) {
+				t.Errorf("want error %q in the last event, got %q", tt.runErr, errMsg)
+			}
+		})
+	}
+}
+
+func TestRunner_AbortScan(t *testing.T) {
+	started := make(chan struct{}, 2)
+	b := &mockBackend{
+		CheckRunner: func(ctx context.Context, params backend.RunParams) (<-chan backend.RunResult, error) {
+			res := make(chan backend.RunResult, 1)
+			go func() {
+				started <- struct{}{}
+				<-ctx.Done()
+				res <- backend.RunResult{Error: ctx.Err()}
+			}()
+			return res, nil
+		},
+	}
+	updater := &inMemChecksUpdater{}
+	cr := New(&log.NullLog{}, b, updater, &inMemAbortedChecks{}, RunnerConfig{
+		MaxTokens:              2,
+		DefaultTimeout:         60,
+		MaxProcessMessageTimes: 1,
+	})
+	job1 := runJobFixture1
+	job1.CheckID = "check1"
+	job1.ScanID = "scan1"
+	job2 := runJobFixture1
+	job2.CheckID = "check2"
+	job2.ScanID = "scan2"
+	processed1 := cr.ProcessMessage(queue.Message{Body: string(mustMarshal(job1)), TimesRead: 1}, <-cr.Tokens)
+	processed2 := cr.ProcessMessage(queue.Message{Body: string(mustMarshal(job2)), TimesRead: 1}, <-cr.Tokens)
+	<-started
+	<-started
+
+	var running []string
+	for _, c := range cr.RunningChecks() {
+		running = append(running, c.CheckID)
+	}
+	if diff := cmp.Diff([]string{"check1", "check2"}, running, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+		t.Fatalf("want running checks != got running checks, diff: %s", diff)
+	}
+
+	got := cr.AbortScan("scan1")
+	if diff := cmp.Diff([]string{"check1"}, got); diff != "" {
+		t.Fatalf("want aborted checks != got aborted checks, diff: %s", diff)
+	}
+	if deleted := <-processed1; !deleted {
+		t.Errorf("message of an aborted check not deleted")
+	}
+	status := stateupdater.StatusAborted
+	wantUpdates := []stateupdater.CheckState{{ID: "check1", Status: &status}}
+	if diff := cmp.Diff(wantUpdates, updater.updates); diff != "" {
+		t.Errorf("want updates != got updates, diff: %s", diff)
+	}
+	if !cr.CheckRunning("check2") {
+		t.Errorf("check of another scan aborted")
+	}
+	cr.AbortCheck("check2")
+	<-processed2
+	if got := cr.AbortScan("scan1"); len(got) > 0 {
+		t.Errorf("finished checks still tracked in scan: %v", got)
+	}
+}
+
+func TestRunner_IdleTokens(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	b := &mockBackend{
+		CheckRunner: func(ctx context.Context, params backend.RunParams) (<-chan backend.RunResult, error) {
+			res := make(chan backend.RunResult, 1)
+			go func() {
+				close(started)
+				<-release
+				res <- backend.RunResult{}
+			}()
+			return res, nil
+		},
+	}
+	cr := New(&log.NullLog{}, b, &inMemChecksUpdater{}, &inMemAbortedChecks{}, RunnerConfig{
+		MaxTokens:              4,
+		DefaultTimeout:         60,
+		MaxProcessMessageTimes: 1,
+		CheckCosts:             map[string]int{"job1": 2},
+	})
+	// The token held by the queue reader while waiting for messages.
+	reader := <-cr.Tokens
+	if got := cr.IdleTokens(); got != 1 {
+		t.Fatalf("want 1 idle token, got %d", got)
+	}
+	processed := cr.ProcessMessage(queue.Message{Body: string(mustMarshal(runJobFixture1)), TimesRead: 1}, reader)
+	<-started
+	if got := cr.IdleTokens(); got != 0 {
+		t.Errorf("want 0 idle tokens while the check runs, got %d", got)
+	}
+	close(release)
+	<-processed
+	// The extra tokens of the check are returned after the message is
+	// processed.
+	for i := 0; cr.IdleTokens() != 0; i++ {
+		if i > 100 {
+			t.Fatalf("tokens of the check not returned, idle tokens: %d", cr.IdleTokens())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Simulate two tokens lost.
+	<-cr.Tokens
+	<-cr.Tokens
+	if got := cr.IdleTokens(); got != 2 {
+		t.Errorf("want 2 idle tokens, got %d", got)
+	}
+}
+
+func TestRunner_UploadWorkers(t *testing.T) {
+	b := &mockBackend{
+		CheckRunner: func(ctx context.Context, params backend.RunParams) (<-chan backend.RunResult, error) {
+			res := make(chan backend.RunResult, 1)
+			res <- backend.RunResult{Output: []byte("output")}
+			return res, nil
+		},
+	}
+	uploading := make(chan string)
+	release := make(chan struct{})
+	updater := &mockChecksUpdater{
+		stateUpdater: func(cs stateupdater.CheckState) error { return nil },
+		checkRawUpdater: func(checkID string, stime time.Time, raw []byte) (string, error) {
+			uploading <- checkID
+			<-release
+			return checkID + "/logs", nil
+		},
+		checkTerminalChecker: func(ID string) bool { return true },
+		checkTerminalDeleter: func(ID string) {},
+	}
+	cr := New(&log.NullLog{}, b, updater, &inMemAbortedChecks{}, RunnerConfig{
+		MaxTokens:              2,
+		DefaultTimeout:         60,
+		MaxProcessMessageTimes: 1,
+		UploadWorkers:          1,
+	})
+	job := runJobFixture1
+	job.CheckID = "check1"
+	processed1 := cr.ProcessMessage(queue.Message{Body: string(mustMarshal(job)), TimesRead: 1}, <-cr.Tokens)
+	<-uploading
+	// The token of a check is freed while its logs are stored.
+	if len(cr.Tokens) != 2 || cr.FreeSlots() != 2 {
+		t.Errorf("token of the check storing its logs not freed")
+	}
+	if got := cr.ChecksUploading(); got != 1 {
+		t.Errorf("want 1 check uploading, got %d", got)
+	}
+	if cr.CheckRunning("check1") || len(cr.RunningChecks()) != 0 {
+		t.Errorf("check storing its logs still running")
+	}
+	// The checks that finish while all the uploaders are busy keep their
+	// tokens.
+	job.CheckID = "check2"
+	processed2 := cr.ProcessMessage(queue.Message{Body: string(mustMarshal(job)), TimesRead: 1}, <-cr.Tokens)
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-processed1:
+		t.Fatalf("message processed before storing the logs of the check")
+	default:
+	}
+	if len(cr.Tokens) != 1 {
+		t.Errorf("token of the check waiting for an uploader freed")
+	}
+	release <- struct{}{}
+	if deleted := <-processed1; !deleted {
+		t.Errorf("message of the first check not deleted")
+	}
+	if got := <-uploading; got != "check2" {
+		t.Errorf("want logs of check2 stored, got %s", got)
+	}
+	if len(cr.Tokens) != 2 {
+		t.Errorf("tokens of the checks not freed")
+	}
+	release <- struct{}{}
+	if deleted := <-processed2; !deleted {
+		t.Errorf("message of the second check not deleted")
+	}
+	if got := cr.ChecksUploading(); got != 0 {
+		t.Errorf("want 0 checks uploading, got %d", got)
+	}
+}
+
+func TestRunner_KillGrace(t *testing.T) {
+	var killGrace time.Duration
+	b := &mockBackend{
+		CheckRunner: func(ctx context.Context, params backend.RunParams) (<-chan backend.RunResult, error) {
+			killGrace = params.KillGrace
+			res := make(chan backend.RunResult, 1)
+			go func() {
+				<-ctx.Done()
+				// The check is killed after not stopping in the grace
+				// period.
+				time.Sleep(200 * time.Millisecond)
+				res <- backend.RunResult{Error: ctx.Err()}
+			}()
+			return res, nil
+		},
+	}
+	updater := &inMemChecksUpdater{}
+	cr := New(&log.NullLog{}, b, updater, &inMemAbortedChecks{}, RunnerConfig{
+		MaxTokens:              1,
+		DefaultTimeout:         60,
+		MaxProcessMessageTimes: 1,
+		KillGrace:              2,
+	})
+	job := runJobFixture1
+	job.Timeout = 1
+	if deleted := <-cr.ProcessMessage(queue.Message{Body: string(mustMarshal(job)), TimesRead: 1}, <-cr.Tokens); !deleted {
+		t.Errorf("message of a check that timed out not deleted")
+	}
+	if killGrace != 2*time.Second {
+		t.Errorf("want kill grace %s, got %s", 2*time.Second, killGrace)
+	}
+	status := stateupdater.StatusTimeout
+	elapsed := int64(1)
+	want := []stateupdater.CheckState{{ID: job.CheckID, Status: &status, Elapsed: &elapsed}}
+	if diff := cmp.Diff(want, updater.updates); diff != "" {
+		t.Errorf("want updates != got updates, diff: %s", diff)
+	}
+}
+
+type verifierMock map[string]string
+
+func (v verifierMock) Verify(body string) (string, error) {
+	job, ok := v[body]
+	if !ok {
+		return "", errors.New("unauthenticated")
+	}
+	return job, nil
+}
+
+func TestRunner_Verifier(t *testing.T) {
+	body, err := json.Marshal(runJobFixture1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tests := []struct {
+		name        string
+		msg         queue.Message
+		wantRun     bool
+		wantDeleted bool
+	}{
+		{
+			name:        "Authenticated",
+			msg:         queue.Message{Body: "signed", TimesRead: 1},
+			wantRun:     true,
+			wantDeleted: true,
+		},
+		{
+			name:        "Unauthenticated",
+			msg:         queue.Message{Body: string(body), TimesRead: 1},
+			wantRun:     false,
+			wantDeleted: true,
+		},
+		{
+			name:        "Local",
+			msg:         queue.Message{Body: string(body), TimesRead: 1, Local: true},
+			wantRun:     true,
+			wantDeleted: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ran bool
+			b := &mockBackend{
+				CheckRunner: func(ctx context.Context, params backend.RunParams) (<-chan backend.RunResult, error) {
+					ran = true
+					res := make(chan backend.RunResult, 1)
+					res <- backend.RunResult{}
+					return res, nil
+				},
+			}
+			updater := &inMemChecksUpdater{}
+			cr := New(&log.NullLog{}, b, updater, &inMemAbortedChecks{}, RunnerConfig{
+				MaxTokens:              1,
+				DefaultTimeout:         60,
+				MaxProcessMessageTimes: 1,
+			})
+			cr.Verifier = verifierMock{"signed": string(body)}
+			if err := cr.CheckMessage(tt.msg); (err == nil) != tt.wantRun {
+				t.Errorf("got CheckMessage error %v, want error %v", err, !tt.wantRun)
+			}
+			deleted := <-cr.ProcessMessage(tt.msg, <-cr.Tokens)
+			if ran != tt.wantRun {
+				t.Errorf("got check run %v, want %v", ran, tt.wantRun)
+			}
+			if deleted != tt.wantDeleted {
+				t.Errorf("got message deleted %v, want %v", deleted, tt.wantDeleted)
+			}
+			if !tt.wantRun && len(updater.updates) > 0 {
+				t.Errorf("unexpected state updates of an unauthenticated message: %+v", updater.updates)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package jobrunner
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/adevinta/vulcan-agent/backend"
+)
+
+const (
+	// SchemaVersion is the latest version of the schema of the jobs supported
+	// by the agent. The jobs without version are considered of version 1.
+	SchemaVersion = 1
+
+	// MaxJobTimeout is the max timeout, in seconds, of a job.
+	MaxJobTimeout = 24 * 60 * 60
+)
+
+var (
+	reHostname   = regexp.MustCompile(`^([a-zA-Z0-9_]([a-zA-Z0-9_-]{0,61}[a-zA-Z0-9])?\.)*[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.?$`)
+	reAWSAccount = regexp.MustCompile(`^(arn:aws:iam::)?[0-9]{12}(:root)?$`)
+)
+
+// targetValidators contains the functions that validate the targets of the
+// asset types that have a well-known format. The targets of the other asset
+// types are not validated.
+var targetValidators = map[string]func(target string) error{
+	"IP": func(target string) error {
+		if net.ParseIP(target) == nil {
+			return fmt.Errorf("invalid IP %q", target)
+		}
+		return nil
+	},
+	"IPRange": func(target string) error {
+		if _, _, err := net.ParseCIDR(target); err != nil {
+			return fmt.Errorf("invalid IP range %q", target)
+		}
+		return nil
+	},
+	"Hostname":   validateHostname,
+	"DomainName": validateHostname,
+	"WebAddress": func(target string) error {
+		u, err := url.Parse(target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid web address %q", target)
+		}
+		return nil
+	},
+	"AWSAccount": func(target string) error {
+		if !reAWSAccount.MatchString(target) {
+			return fmt.Errorf("invalid AWS account %q", target)
+		}
+		return nil
+	},
+	"DockerImage": func(target string) error {
+		if _, _, _, err := backend.ParseImage(target); err != nil {
+			return fmt.Errorf("invalid docker image %q", target)
+		}
+		return nil
+	},
+}
+
+func validateHostname(target string) error {
+	if len(target) > 253 || !reHostname.MatchString(target) {
+		return fmt.Errorf("invalid hostname %q", target)
+	}
+	return nil
+}
+
+// ValidationError is returned when a job is not valid. It contains the field
+// that is not valid and the reason.
+type ValidationError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid job: %s: %s", e.Field, e.Reason)
+}
+
+// Validate returns a *ValidationError if the job is not valid.
+func (j Job) Validate() error {
+	if j.SchemaVersion < 0 || j.SchemaVersion > SchemaVersion {
+		return &ValidationError{"schema_version", fmt.Sprintf("unsupported version %d, max %d", j.SchemaVersion, SchemaVersion)}
+	}
+	required := []struct {
+		field string
+		value string
+	}{
+		{"check_id", j.CheckID},
+		{"image", j.Image},
+		{"target", j.Target},
+	}
+	for _, r := range required {
+		if strings.TrimSpace(r.value) == "" {
+			return &ValidationError{r.field, "required"}
+		}
+	}
+	if j.Timeout < 0 || j.Timeout > MaxJobTimeout {
+		return &ValidationError{"timeout", fmt.Sprintf("must be between 0 and %d", MaxJobTimeout)}
+	}
+	if _, _, _, err := backend.ParseImage(j.Image); err != nil {
+		return &ValidationError{"image", err.Error()}
+	}
+	if v, ok := targetValidators[j.AssetType]; ok {
+		if err := v(j.Target); err != nil {
+			return &ValidationError{"target", err.Error()}
+		}
+	}
+	return nil
+}
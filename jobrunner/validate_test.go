@@ -0,0 +1,133 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package jobrunner
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestJob_Validate(t *testing.T) {
+	valid := Job{
+		CheckID:   "check1",
+		Image:     "vulcansec/vulcan-nmap:1",
+		Target:    "example.com",
+		Timeout:   60,
+		AssetType: "Hostname",
+	}
+	tests := []struct {
+		name      string
+		job       func(j Job) Job
+		wantField string
+	}{
+		{
+			name: "Valid",
+			job:  func(j Job) Job { return j },
+		},
+		{
+			name: "UnknownAssetTypeNotValidated",
+			job: func(j Job) Job {
+				j.AssetType, j.Target = "GCPProject", "my project"
+				return j
+			},
+		},
+		{
+			name: "UnsupportedSchemaVersion",
+			job: func(j Job) Job {
+				j.SchemaVersion = SchemaVersion + 1
+				return j
+			},
+			wantField: "schema_version",
+		},
+		{
+			name: "MissingCheckID",
+			job: func(j Job) Job {
+				j.CheckID = ""
+				return j
+			},
+			wantField: "check_id",
+		},
+		{
+			name: "MissingTarget",
+			job: func(j Job) Job {
+				j.Target = " "
+				return j
+			},
+			wantField: "target",
+		},
+		{
+			name: "TimeoutTooBig",
+			job: func(j Job) Job {
+				j.Timeout = MaxJobTimeout + 1
+				return j
+			},
+			wantField: "timeout",
+		},
+		{
+			name: "InvalidImage",
+			job: func(j Job) Job {
+				j.Image = "Invalid Image"
+				return j
+			},
+			wantField: "image",
+		},
+		{
+			name: "InvalidHostname",
+			job: func(j Job) Job {
+				j.Target = "http://example.com"
+				return j
+			},
+			wantField: "target",
+		},
+		{
+			name: "InvalidIPRange",
+			job: func(j Job) Job {
+				j.AssetType, j.Target = "IPRange", "10.0.0.1"
+				return j
+			},
+			wantField: "target",
+		},
+		{
+			name: "ValidWebAddress",
+			job: func(j Job) Job {
+				j.AssetType, j.Target = "WebAddress", "https://example.com/app"
+				return j
+			},
+		},
+		{
+			name: "InvalidWebAddress",
+			job: func(j Job) Job {
+				j.AssetType, j.Target = "WebAddress", "example.com"
+				return j
+			},
+			wantField: "target",
+		},
+		{
+			name: "ValidAWSAccount",
+			job: func(j Job) Job {
+				j.AssetType, j.Target = "AWSAccount", "arn:aws:iam::123456789012:root"
+				return j
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.job(valid).Validate()
+			var verr *ValidationError
+			if tt.wantField == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if !errors.As(err, &verr) {
+				t.Fatalf("want a validation error, got %v", err)
+			}
+			if verr.Field != tt.wantField {
+				t.Errorf("want invalid field %s, got %s", tt.wantField, verr.Field)
+			}
+		})
+	}
+}
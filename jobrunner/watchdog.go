@@ -0,0 +1,140 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package jobrunner
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/stateupdater"
+)
+
+// DefaultWatchdogGrace is the time, in seconds, that a check can run over
+// its timeout, plus the kill grace, before the watchdog considers it stuck.
+const DefaultWatchdogGrace = config.DefaultWatchdogGrace
+
+// watchedJob contains the information needed by the watchdog to finish a job
+// that got stuck.
+type watchedJob struct {
+	checkID   string
+	started   time.Time
+	deadline  time.Time
+	processed chan<- bool
+	// ran is set to 1 when the backend returned the result of the check.
+	ran int32
+	// done is set to 1 by the first one that finishes the job, the job
+	// itself or the watchdog.
+	done int32
+	// freed is set to 1 when the token of the job is returned to the pool,
+	// that happens before the job finishes if the check ran.
+	freed int32
+	// extra is the number of extra tokens held by the job. extraFreed is
+	// set to 1 when they are returned to the pool, by the job or by the
+	// watchdog.
+	extra      int
+	extraFreed int32
+}
+
+// watch starts tracking a job, that holds the given number of extra tokens,
+// so the watchdog can finish it if it's not finished before the given
+// deadline.
+func (cr *Runner) watch(checkID string, processed chan<- bool, deadline time.Time, extra int) *watchedJob {
+	wj := &watchedJob{
+		checkID:   checkID,
+		started:   time.Now(),
+		deadline:  deadline,
+		processed: processed,
+		extra:     extra,
+	}
+	cr.watched.Store(checkID, wj)
+	return wj
+}
+
+// unwatch stops tracking a job, unless the check is being tracked by another
+// job because this one was finished by the watchdog.
+func (cr *Runner) unwatch(wj *watchedJob) {
+	if v, ok := cr.watched.Load(wj.checkID); ok && v == wj {
+		cr.watched.Delete(wj.checkID)
+	}
+}
+
+// finishWatched finishes a watched job unless it was already finished by the
+// watchdog.
+func (cr *Runner) finishWatched(wj *watchedJob, delete bool, err error) {
+	if !atomic.CompareAndSwapInt32(&wj.done, 0, 1) {
+		cr.logger(wj.checkID).Infof("check %s already finished by the watchdog", wj.checkID)
+		return
+	}
+	cr.completeJob(wj.checkID, wj.processed, delete, err, atomic.CompareAndSwapInt32(&wj.freed, 0, 1))
+}
+
+// freeToken returns the token of a watched job to the pool unless it was
+// already returned.
+func (cr *Runner) freeToken(wj *watchedJob) {
+	if atomic.CompareAndSwapInt32(&wj.freed, 0, 1) {
+		cr.releaseJobToken()
+	}
+}
+
+// freeExtraTokens returns the extra tokens of a watched job to the pool
+// unless they were already returned.
+func (cr *Runner) freeExtraTokens(wj *watchedJob) {
+	if atomic.CompareAndSwapInt32(&wj.extraFreed, 0, 1) {
+		cr.releaseTokens(wj.extra)
+	}
+}
+
+// Watchdog checks every interval if there are jobs that didn't finish before
+// their deadline, because the backend never returned their result or because
+// their results could not be stored, and finishes them: it aborts the check,
+// sets its status to TIMEOUT, or FAILED if the check finished, and frees its
+// token. It returns when the context is done.
+func (cr *Runner) Watchdog(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			cr.watched.Range(func(_, v interface{}) bool {
+				wj := v.(*watchedJob)
+				if now.After(wj.deadline) {
+					cr.forceFinish(wj)
+				}
+				return true
+			})
+		}
+	}
+}
+
+func (cr *Runner) forceFinish(wj *watchedJob) {
+	if !atomic.CompareAndSwapInt32(&wj.done, 0, 1) {
+		return
+	}
+	cr.watched.Delete(wj.checkID)
+	cr.cAborter.Abort(wj.checkID)
+	cr.cAborter.Remove(wj.checkID)
+	cr.running.Delete(wj.checkID)
+	cr.freeExtraTokens(wj)
+	cr.CheckUpdater.DeleteCheckStatusTerminal(wj.checkID)
+	status := stateupdater.StatusTimeout
+	if atomic.LoadInt32(&wj.ran) == 1 {
+		status = stateupdater.StatusFailed
+	}
+	cr.logger(wj.checkID).Errorf("watchdog: check %s stuck since %s, setting its status to %s", wj.checkID, wj.deadline, status)
+	state := stateupdater.CheckState{
+		ID:     wj.checkID,
+		Status: &status,
+	}
+	if status == stateupdater.StatusTimeout {
+		elapsed := int64(time.Since(wj.started).Seconds())
+		state.Elapsed = &elapsed
+	}
+	err := cr.updateFinalState(state)
+	cr.completeJob(wj.checkID, wj.processed, err == nil, err, atomic.CompareAndSwapInt32(&wj.freed, 0, 1))
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package jobrunner
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/queue"
+	"github.com/adevinta/vulcan-agent/stateupdater"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRunner_Watchdog(t *testing.T) {
+	tests := []struct {
+		name       string
+		runResult  bool
+		cost       string
+		wantStatus string
+	}{
+		{
+			name:       "BackendStuck",
+			wantStatus: stateupdater.StatusTimeout,
+		},
+		{
+			name:       "WeightedBackendStuck",
+			cost:       "2",
+			wantStatus: stateupdater.StatusTimeout,
+		},
+		{
+			name:       "ResultsStuck",
+			runResult:  true,
+			wantStatus: stateupdater.StatusFailed,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			stuck := make(chan struct{})
+			defer close(stuck)
+			b := &mockBackend{
+				CheckRunner: func(ctx context.Context, params backend.RunParams) (<-chan backend.RunResult, error) {
+					res := make(chan backend.RunResult, 1)
+					if tt.runResult {
+						res <- backend.RunResult{Output: []byte("output")}
+					}
+					return res, nil
+				},
+			}
+			var (
+				mu      sync.Mutex
+				updates []stateupdater.CheckState
+			)
+			updater := &mockChecksUpdater{
+				stateUpdater: func(cs stateupdater.CheckState) error {
+					mu.Lock()
+					defer mu.Unlock()
+					cs.Elapsed = nil
+					updates = append(updates, cs)
+					return nil
+				},
+				checkRawUpdater: func(checkID string, stime time.Time, raw []byte) (string, error) {
+					<-stuck
+					return "", nil
+				},
+				checkTerminalChecker: func(ID string) bool { return false },
+				checkTerminalDeleter: func(ID string) {},
+			}
+			cr := New(&log.NullLog{}, b, updater, &inMemAbortedChecks{}, RunnerConfig{
+				MaxTokens:              3,
+				DefaultTimeout:         60,
+				MaxProcessMessageTimes: 1,
+			})
+			cr.watchdogGrace = 0
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go cr.Watchdog(ctx, 10*time.Millisecond)
+
+			job := runJobFixture1
+			job.CheckID = "check1"
+			job.Timeout = 1
+			if tt.cost != "" {
+				job.Metadata = map[string]string{CostMetadataKey: tt.cost}
+			}
+			processed := cr.ProcessMessage(queue.Message{Body: string(mustMarshal(job)), TimesRead: 1}, <-cr.Tokens)
+			select {
+			case deleted := <-processed:
+				if !deleted {
+					t.Errorf("message of a check finished by the watchdog not deleted")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("check not finished by the watchdog")
+			}
+			if len(cr.Tokens) != 3 {
+				t.Errorf("want 3 free tokens, got %d", len(cr.Tokens))
+			}
+			if cr.CheckRunning("check1") {
+				t.Errorf("check finished by the watchdog still running")
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			status := tt.wantStatus
+			want := []stateupdater.CheckState{{ID: "check1", Status: &status}}
+			if diff := cmp.Diff(want, updates); diff != "" {
+				t.Errorf("want updates != got updates, diff: %s", diff)
+			}
+		})
+	}
+}
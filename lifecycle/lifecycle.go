@@ -0,0 +1,177 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+// Package lifecycle watches the EC2 instance metadata for the notices of spot
+// interruptions and auto scaling group terminations, so the agent can drain
+// before the instance is terminated.
+package lifecycle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/awscreds"
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+)
+
+// Kinds of notices.
+const (
+	NoticeSpot        = "spot"
+	NoticeAutoScaling = "autoscaling"
+)
+
+// DefaultPollInterval is the default interval, in seconds, to poll the
+// instance metadata.
+const DefaultPollInterval = config.DefaultLifecyclePollInterval
+
+const (
+	spotActionPath     = "spot/instance-action"
+	targetStatePath    = "autoscaling/target-lifecycle-state"
+	instanceIDPath     = "instance-id"
+	stateTerminated    = "Terminated"
+	lifecycleContinue  = "CONTINUE"
+	defaultSpotWarning = 2 * time.Minute
+)
+
+// Notice informs that the instance is going to be terminated.
+type Notice struct {
+	Kind string
+	// Time is when the instance is going to be terminated. It's zero if it's
+	// unknown.
+	Time time.Time
+}
+
+type metadataClient interface {
+	GetMetadataWithContext(ctx aws.Context, p string) (string, error)
+}
+
+// Watcher polls the instance metadata looking for termination notices.
+type Watcher struct {
+	md       metadataClient
+	asg      autoscalingiface.AutoScalingAPI
+	cfg      config.LifecycleConfig
+	interval time.Duration
+	log      log.Logger
+	now      func() time.Time
+}
+
+// New creates a Watcher from the given config.
+func New(l log.Logger, cfg config.LifecycleConfig) (*Watcher, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("creating AWS session %w", err)
+	}
+	mdCfg := aws.NewConfig()
+	if cfg.MetadataEndpoint != "" {
+		mdCfg = mdCfg.WithEndpoint(cfg.MetadataEndpoint)
+	}
+	md := ec2metadata.New(sess, mdCfg)
+	w := &Watcher{
+		md:       md,
+		cfg:      cfg,
+		interval: time.Duration(cfg.PollInterval) * time.Second,
+		log:      l,
+		now:      time.Now,
+	}
+	if w.interval <= 0 {
+		w.interval = DefaultPollInterval * time.Second
+	}
+	if cfg.LifecycleHook != "" {
+		if cfg.AutoScalingGroup == "" {
+			return nil, errors.New("lifecycle hook defined without an auto scaling group")
+		}
+		awsCfg := aws.NewConfig()
+		region := cfg.Region
+		if region == "" {
+			region, err = md.Region()
+			if err != nil {
+				return nil, fmt.Errorf("error getting the region of the instance: %w", err)
+			}
+		}
+		// The instance metadata is always read from the instance, but the
+		// auto scaling client uses the default endpoint of the AWS clients.
+		asgSess, err := awscreds.NewSession(config.AWSCredentialsConfig{})
+		if err != nil {
+			return nil, err
+		}
+		w.asg = autoscaling.New(asgSess, awsCfg.WithRegion(region))
+	}
+	return w, nil
+}
+
+// Watch polls the instance metadata until a termination notice is found or
+// the context is canceled. The notice, if any, is written to the returned
+// channel.
+func (w *Watcher) Watch(ctx context.Context) <-chan Notice {
+	notices := make(chan Notice, 1)
+	go func() {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			if n, ok := w.poll(ctx); ok {
+				notices <- n
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return notices
+}
+
+func (w *Watcher) poll(ctx context.Context) (Notice, bool) {
+	// The metadata paths return an error, 404, when there is no notice.
+	action, err := w.md.GetMetadataWithContext(ctx, spotActionPath)
+	if err == nil {
+		n := Notice{Kind: NoticeSpot, Time: w.now().Add(defaultSpotWarning)}
+		var a struct {
+			Time time.Time `json:"time"`
+		}
+		if err := json.Unmarshal([]byte(action), &a); err == nil && !a.Time.IsZero() {
+			n.Time = a.Time
+		}
+		return n, true
+	}
+	state, err := w.md.GetMetadataWithContext(ctx, targetStatePath)
+	if err == nil && state == stateTerminated {
+		return Notice{Kind: NoticeAutoScaling}, true
+	}
+	return Notice{}, false
+}
+
+// Complete completes the lifecycle hook of the instance, if one is
+// configured, so the auto scaling group can terminate it without waiting for
+// the hook to time out.
+func (w *Watcher) Complete(ctx context.Context) error {
+	if w.asg == nil {
+		return nil
+	}
+	id, err := w.md.GetMetadataWithContext(ctx, instanceIDPath)
+	if err != nil {
+		return fmt.Errorf("error getting the instance id: %w", err)
+	}
+	_, err = w.asg.CompleteLifecycleActionWithContext(ctx, &autoscaling.CompleteLifecycleActionInput{
+		AutoScalingGroupName:  aws.String(w.cfg.AutoScalingGroup),
+		LifecycleHookName:     aws.String(w.cfg.LifecycleHook),
+		InstanceId:            aws.String(id),
+		LifecycleActionResult: aws.String(lifecycleContinue),
+	})
+	if err != nil {
+		return fmt.Errorf("error completing the lifecycle action: %w", err)
+	}
+	w.log.Infof("lifecycle hook %s completed", w.cfg.LifecycleHook)
+	return nil
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/google/go-cmp/cmp"
+)
+
+type inMemMetadata map[string]string
+
+func (m inMemMetadata) GetMetadataWithContext(ctx aws.Context, p string) (string, error) {
+	v, ok := m[p]
+	if !ok {
+		return "", errors.New("404 not found")
+	}
+	return v, nil
+}
+
+func TestWatcher_poll(t *testing.T) {
+	now := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		md         inMemMetadata
+		want       Notice
+		wantNotice bool
+	}{
+		{
+			name: "NoNotice",
+			md:   inMemMetadata{targetStatePath: "InService"},
+		},
+		{
+			name:       "SpotInterruption",
+			md:         inMemMetadata{spotActionPath: `{"action": "terminate", "time": "2022-03-01T10:01:30Z"}`},
+			want:       Notice{Kind: NoticeSpot, Time: now.Add(90 * time.Second)},
+			wantNotice: true,
+		},
+		{
+			name:       "SpotInterruptionWithoutTime",
+			md:         inMemMetadata{spotActionPath: `{"action": "stop"}`},
+			want:       Notice{Kind: NoticeSpot, Time: now.Add(defaultSpotWarning)},
+			wantNotice: true,
+		},
+		{
+			name:       "AutoScalingTermination",
+			md:         inMemMetadata{targetStatePath: stateTerminated},
+			want:       Notice{Kind: NoticeAutoScaling},
+			wantNotice: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &Watcher{md: tt.md, log: &log.NullLog{}, now: func() time.Time { return now }}
+			got, ok := w.poll(context.Background())
+			if ok != tt.wantNotice {
+				t.Fatalf("want notice %v, got %v", tt.wantNotice, ok)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("want notice != got notice, diff: %s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package loadgen
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/awscreds"
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/stateupdater"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// collectorRetryInterval is the time the Collector waits after an error
+// receiving the state updates.
+var collectorRetryInterval = time.Second
+
+// Collector reads the state updates sent by the agents to a SQS queue and
+// records them in the Stats. All the messages of the queue are consumed, so
+// it must be a queue only used for the load tests.
+type Collector struct {
+	sqs      sqsiface.SQSAPI
+	queueURL string
+	stats    *Stats
+	log      log.Logger
+}
+
+// NewCollector returns a Collector that reads the state updates from the
+// given queue, using the given endpoint, or the default one if it's empty,
+// and credentials.
+func NewCollector(l log.Logger, queueARN, endpoint string, creds config.AWSCredentialsConfig, stats *Stats) (*Collector, error) {
+	sess, err := awscreds.NewSession(creds)
+	if err != nil {
+		return nil, err
+	}
+	arn, err := arn.Parse(queueARN)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing SQS queue ARN: %w", err)
+	}
+	awsCfg := aws.NewConfig()
+	if arn.Region != "" {
+		awsCfg = awsCfg.WithRegion(arn.Region)
+	}
+	if endpoint != "" {
+		awsCfg = awsCfg.WithEndpoint(endpoint)
+	}
+	srv := sqs.New(sess, awsCfg)
+	params := &sqs.GetQueueUrlInput{
+		QueueName: aws.String(arn.Resource),
+	}
+	if arn.AccountID != "" {
+		params.SetQueueOwnerAWSAccountId(arn.AccountID)
+	}
+	resp, err := srv.GetQueueUrl(params)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving SQS queue URL: %w", err)
+	}
+	return &Collector{
+		sqs:      srv,
+		queueURL: aws.StringValue(resp.QueueUrl),
+		stats:    stats,
+		log:      l,
+	}, nil
+}
+
+// Run reads the state updates until the context is canceled.
+func (c *Collector) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		resp, err := c.sqs.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(c.queueURL),
+			MaxNumberOfMessages: aws.Int64(10),
+			WaitTimeSeconds:     aws.Int64(1),
+		})
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, context.Canceled) {
+				return
+			}
+			c.log.Errorf("error receiving state updates: %+v", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(collectorRetryInterval):
+			}
+			continue
+		}
+		now := time.Now()
+		for _, msg := range resp.Messages {
+			var st stateupdater.CheckState
+			if err := json.Unmarshal([]byte(aws.StringValue(msg.Body)), &st); err != nil {
+				c.log.Errorf("invalid state update %q: %+v", aws.StringValue(msg.Body), err)
+			} else if !c.stats.Update(st, now) {
+				c.log.Debugf("discarding state update of unknown check %s", st.ID)
+			}
+			_, err := c.sqs.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+				QueueUrl:      aws.String(c.queueURL),
+				ReceiptHandle: msg.ReceiptHandle,
+			})
+			if err != nil && ctx.Err() == nil {
+				c.log.Errorf("error deleting state update: %+v", err)
+			}
+		}
+	}
+}
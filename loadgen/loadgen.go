@@ -0,0 +1,216 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+// Package loadgen publishes synthetic check jobs to the queue the agents read
+// from and measures, from the state updates the agents send, the time the
+// checks take to start and to finish and the throughput of the agents. It's
+// used by the loadgen command to size the fleets of agents and to catch
+// performance regressions.
+package loadgen
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/jobrunner"
+	"github.com/google/uuid"
+)
+
+// Arrival processes of the jobs.
+const (
+	// ArrivalConstant publishes the jobs at regular intervals.
+	ArrivalConstant = "constant"
+	// ArrivalPoisson publishes the jobs at random intervals, exponentially
+	// distributed, as the independent jobs of many users would arrive.
+	ArrivalPoisson = "poisson"
+)
+
+// Checktype is an image of the checks published and its weight, that is, the
+// proportion of the jobs that run it.
+type Checktype struct {
+	Image  string
+	Weight int
+}
+
+// ParseChecktypes parses a comma separated list of images, each one
+// optionally followed by its weight, e.g.
+// "vulcan-nmap:1=3,vulcan-zap:1". The default weight is 1.
+func ParseChecktypes(s string) ([]Checktype, error) {
+	var cts []Checktype
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		ct := Checktype{Image: part, Weight: 1}
+		if i := strings.LastIndex(part, "="); i >= 0 {
+			w, err := strconv.Atoi(part[i+1:])
+			if err != nil || w <= 0 {
+				return nil, fmt.Errorf("invalid weight in checktype %q", part)
+			}
+			ct = Checktype{Image: part[:i], Weight: w}
+		}
+		cts = append(cts, ct)
+	}
+	return cts, nil
+}
+
+// Config defines the jobs published by a Generator.
+type Config struct {
+	// Rate is the mean number of jobs published per second.
+	Rate float64
+	// Arrival is the arrival process of the jobs, ArrivalConstant by
+	// default.
+	Arrival string
+	// Duration is the time the jobs are published for. Jobs, if greater
+	// than 0, limits the number of jobs published. At least one of them is
+	// required.
+	Duration time.Duration
+	Jobs     int
+	// Checktypes are the images of the checks, selected randomly according
+	// to their weights.
+	Checktypes []Checktype
+	// Targets are the targets of the checks, selected in turns.
+	Targets   []string
+	AssetType string
+	Options   string
+	// Timeout is the timeout, in seconds, of the checks.
+	Timeout int
+	// Seed is the seed of the random choices. By default it depends on the
+	// time.
+	Seed int64
+}
+
+// Publisher writes the jobs to the queue the agents read from.
+type Publisher interface {
+	Write(body string) error
+}
+
+// Generator publishes the jobs defined in a config and records the time
+// each one was published. It must not be run concurrently.
+type Generator struct {
+	cfg    Config
+	pub    Publisher
+	stats  *Stats
+	weight int
+	rand   *rand.Rand
+}
+
+// NewGenerator returns a Generator that publishes the jobs defined in the
+// given config using the given publisher and records them in the given
+// stats.
+func NewGenerator(cfg Config, pub Publisher, stats *Stats) (*Generator, error) {
+	if cfg.Rate <= 0 {
+		return nil, errors.New("the rate must be greater than 0")
+	}
+	switch cfg.Arrival {
+	case "":
+		cfg.Arrival = ArrivalConstant
+	case ArrivalConstant, ArrivalPoisson:
+	default:
+		return nil, fmt.Errorf("invalid arrival %q", cfg.Arrival)
+	}
+	if cfg.Duration <= 0 && cfg.Jobs <= 0 {
+		return nil, errors.New("a duration or a number of jobs is required")
+	}
+	if len(cfg.Checktypes) == 0 {
+		return nil, errors.New("at least one checktype is required")
+	}
+	if len(cfg.Targets) == 0 {
+		return nil, errors.New("at least one target is required")
+	}
+	var weight int
+	for _, ct := range cfg.Checktypes {
+		if ct.Image == "" || ct.Weight <= 0 {
+			return nil, fmt.Errorf("invalid checktype %+v", ct)
+		}
+		weight += ct.Weight
+	}
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &Generator{
+		cfg:    cfg,
+		pub:    pub,
+		stats:  stats,
+		weight: weight,
+		rand:   rand.New(rand.NewSource(seed)),
+	}, nil
+}
+
+// Run publishes the jobs until the duration elapses, the number of jobs is
+// reached or the context is canceled. It returns the number of jobs
+// published. When the publisher is slower than the rate, the jobs are
+// published as fast as possible until the schedule is met again.
+func (g *Generator) Run(ctx context.Context) (int, error) {
+	start := time.Now()
+	next := start
+	var n int
+	for g.cfg.Jobs <= 0 || n < g.cfg.Jobs {
+		if g.cfg.Duration > 0 && next.Sub(start) >= g.cfg.Duration {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return n, nil
+		case <-time.After(time.Until(next)):
+		}
+		j := g.job(n)
+		body, err := json.Marshal(j)
+		if err != nil {
+			return n, err
+		}
+		g.stats.Sent(j.CheckID, j.Image, time.Now())
+		if err := g.pub.Write(string(body)); err != nil {
+			g.stats.Discard(j.CheckID)
+			return n, fmt.Errorf("error publishing job %d: %w", n, err)
+		}
+		n++
+		next = next.Add(g.interval())
+	}
+	return n, nil
+}
+
+// job returns the n-th job published.
+func (g *Generator) job(n int) jobrunner.Job {
+	return jobrunner.Job{
+		CheckID:   uuid.NewString(),
+		StartTime: time.Now(),
+		Image:     g.image(),
+		Target:    g.cfg.Targets[n%len(g.cfg.Targets)],
+		AssetType: g.cfg.AssetType,
+		Options:   g.cfg.Options,
+		Timeout:   g.cfg.Timeout,
+		Metadata:  map[string]string{"loadgen": "true"},
+	}
+}
+
+// image selects randomly the image of a job according to the weights of the
+// checktypes.
+func (g *Generator) image() string {
+	w := g.rand.Intn(g.weight)
+	for _, ct := range g.cfg.Checktypes {
+		if w < ct.Weight {
+			return ct.Image
+		}
+		w -= ct.Weight
+	}
+	return g.cfg.Checktypes[len(g.cfg.Checktypes)-1].Image
+}
+
+// interval returns the time until the next job is published.
+func (g *Generator) interval() time.Duration {
+	mean := float64(time.Second) / g.cfg.Rate
+	if g.cfg.Arrival == ArrivalConstant {
+		return time.Duration(mean)
+	}
+	return time.Duration(g.rand.ExpFloat64() * mean)
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package loadgen
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/agent"
+	"github.com/adevinta/vulcan-agent/jobrunner"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/stateupdater"
+	"github.com/adevinta/vulcan-agent/testutil"
+	"github.com/google/go-cmp/cmp"
+)
+
+type memPublisher struct {
+	bodies []string
+}
+
+func (p *memPublisher) Write(body string) error {
+	p.bodies = append(p.bodies, body)
+	return nil
+}
+
+func TestParseChecktypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    []Checktype
+		wantErr bool
+	}{
+		{
+			name: "Weights",
+			s:    "vulcan-nmap:1=3, vulcan-zap:1",
+			want: []Checktype{{Image: "vulcan-nmap:1", Weight: 3}, {Image: "vulcan-zap:1", Weight: 1}},
+		},
+		{
+			name:    "InvalidWeight",
+			s:       "vulcan-nmap:1=0",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseChecktypes(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("checktypes mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGenerator_Run(t *testing.T) {
+	pub := &memPublisher{}
+	stats := NewStats()
+	g, err := NewGenerator(Config{
+		Rate:       1000,
+		Arrival:    ArrivalPoisson,
+		Jobs:       200,
+		Checktypes: []Checktype{{Image: "vulcan-nmap:1", Weight: 3}, {Image: "vulcan-zap:1", Weight: 1}},
+		Targets:    []string{"example.com", "example.org"},
+		Timeout:    60,
+		Seed:       1,
+	}, pub, stats)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	n, err := g.Run(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 200 || len(pub.bodies) != 200 || stats.Pending() != 200 {
+		t.Fatalf("want 200 jobs, got %d published, %d written, %d pending", n, len(pub.bodies), stats.Pending())
+	}
+	images := make(map[string]int)
+	for _, body := range pub.bodies {
+		var j jobrunner.Job
+		if err := json.Unmarshal([]byte(body), &j); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if j.CheckID == "" || j.Target == "" || j.Timeout != 60 {
+			t.Errorf("invalid job %+v", j)
+		}
+		images[j.Image]++
+	}
+	if images["vulcan-nmap:1"] < 120 || images["vulcan-zap:1"] < 20 {
+		t.Errorf("images not distributed by weight: %v", images)
+	}
+}
+
+func TestStats_Summary(t *testing.T) {
+	stats := NewStats()
+	start := time.Now()
+	for i, id := range []string{"check1", "check2", "check3"} {
+		stats.Sent(id, "vulcan-nmap:1", start)
+		stats.Update(state(id, stateupdater.StatusRunning), start.Add(time.Duration(i+1)*time.Second))
+	}
+	stats.Update(state("check1", stateupdater.StatusFinished), start.Add(4*time.Second))
+	stats.Update(state("check2", stateupdater.StatusFailed), start.Add(8*time.Second))
+	// Only the first terminal status is recorded.
+	stats.Update(state("check2", stateupdater.StatusFinished), start.Add(9*time.Second))
+	if stats.Update(state("other", stateupdater.StatusFinished), start) {
+		t.Errorf("state update of unknown check recorded")
+	}
+
+	want := Summary{
+		Sent:         3,
+		Finished:     2,
+		Pending:      1,
+		Statuses:     map[string]int{stateupdater.StatusFinished: 1, stateupdater.StatusFailed: 1},
+		Elapsed:      8,
+		Throughput:   0.25,
+		QueueLatency: Latencies{Count: 3, P50: 2, P90: 3, P99: 3, Max: 3},
+		Latency:      Latencies{Count: 2, P50: 4, P90: 8, P99: 8, Max: 8},
+		Images: map[string]Latencies{
+			"vulcan-nmap:1": {Count: 2, P50: 4, P90: 8, P99: 8, Max: 8},
+		},
+	}
+	if diff := cmp.Diff(want, stats.Summary()); diff != "" {
+		t.Errorf("summary mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func state(id, status string) stateupdater.CheckState {
+	return stateupdater.CheckState{ID: id, Status: &status}
+}
+
+func TestCollector(t *testing.T) {
+	env, err := testutil.NewEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer env.Close()
+	stats := NewStats()
+	g, err := NewGenerator(Config{
+		Rate:       1000,
+		Jobs:       5,
+		Checktypes: []Checktype{{Image: "vulcan-nmap:1", Weight: 1}},
+		Targets:    []string{"example.com"},
+		Timeout:    60,
+	}, publisherFunc(func(body string) error {
+		return env.SQS.Send(testutil.JobsQueue, body)
+	}), stats)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := g.Run(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cfg := env.Config()
+	cfg.Agent.ConcurrentJobs = 2
+	cfg.Agent.ExitWhenEmpty = true
+	if code := agent.Run(cfg, testutil.NewBackend(env.AgentAddr(), nil), &log.NullLog{}); code != 0 {
+		t.Fatalf("agent exited with code %d", code)
+	}
+
+	c, err := NewCollector(&log.NullLog{}, env.SQS.ARN(testutil.StatesQueue), env.SQS.URL, testutil.Credentials, stats)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+	for stats.Pending() > 0 && ctx.Err() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	sum := stats.Summary()
+	// The checks run by the fake backend don't report the RUNNING status,
+	// so only the end to end latency is measured.
+	if sum.Finished != 5 || sum.Statuses[stateupdater.StatusFinished] != 5 || sum.Latency.Count != 5 {
+		t.Errorf("unexpected summary %+v", sum)
+	}
+	if msgs := env.SQS.Messages(testutil.StatesQueue); len(msgs) != 0 {
+		t.Errorf("state updates not deleted: %v", msgs)
+	}
+}
+
+type publisherFunc func(body string) error
+
+func (f publisherFunc) Write(body string) error {
+	return f(body)
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package loadgen
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/stateupdater"
+)
+
+// Stats records when the checks published were started and finished by the
+// agents.
+type Stats struct {
+	mu     sync.Mutex
+	checks map[string]*checkStats
+}
+
+type checkStats struct {
+	image    string
+	sent     time.Time
+	running  time.Time
+	finished time.Time
+	status   string
+}
+
+// NewStats returns empty Stats.
+func NewStats() *Stats {
+	return &Stats{checks: make(map[string]*checkStats)}
+}
+
+// Sent records that the job of the given check was published at the given
+// time.
+func (s *Stats) Sent(checkID, image string, t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checks[checkID] = &checkStats{image: image, sent: t}
+}
+
+// Discard removes a check whose job couldn't be published.
+func (s *Stats) Discard(checkID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.checks, checkID)
+}
+
+// Update records the status of the given state update, received at the given
+// time. It returns false if the state update is not of a check published by
+// the Generator. Only the first RUNNING and the first terminal status of each
+// check are recorded.
+func (s *Stats) Update(st stateupdater.CheckState, t time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.checks[st.ID]
+	if !ok {
+		return false
+	}
+	if st.Status == nil || !c.finished.IsZero() {
+		return true
+	}
+	status := *st.Status
+	if status == stateupdater.StatusRunning && c.running.IsZero() {
+		c.running = t
+	}
+	if _, ok := stateupdater.TerminalStatuses[status]; ok {
+		c.finished = t
+		c.status = status
+	}
+	return true
+}
+
+// Pending returns the number of checks published that haven't finished.
+func (s *Stats) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int
+	for _, c := range s.checks {
+		if c.finished.IsZero() {
+			n++
+		}
+	}
+	return n
+}
+
+// Latencies are the percentiles, in seconds, of the times of a set of checks.
+type Latencies struct {
+	Count int     `json:"count"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+// Summary summarizes the Stats of the checks.
+type Summary struct {
+	Sent     int            `json:"sent"`
+	Finished int            `json:"finished"`
+	Pending  int            `json:"pending"`
+	Statuses map[string]int `json:"statuses"`
+	// Elapsed is the time, in seconds, from the first job published to the
+	// last check finished.
+	Elapsed float64 `json:"elapsed"`
+	// Throughput is the number of checks finished per second.
+	Throughput float64 `json:"throughput"`
+	// QueueLatency is the time from publishing the jobs to the checks
+	// reporting the RUNNING status.
+	QueueLatency Latencies `json:"queue_latency"`
+	// Latency is the time from publishing the jobs to the checks finishing.
+	Latency Latencies `json:"latency"`
+	// Images contains the Latency of the checks of each image.
+	Images map[string]Latencies `json:"images"`
+}
+
+// Summary returns the summary of the Stats.
+func (s *Stats) Summary() Summary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sum := Summary{
+		Sent:     len(s.checks),
+		Statuses: make(map[string]int),
+		Images:   make(map[string]Latencies),
+	}
+	var (
+		first, last time.Time
+		queued      []time.Duration
+		finished    []time.Duration
+		images      = make(map[string][]time.Duration)
+	)
+	for _, c := range s.checks {
+		if first.IsZero() || c.sent.Before(first) {
+			first = c.sent
+		}
+		if !c.running.IsZero() {
+			queued = append(queued, c.running.Sub(c.sent))
+		}
+		if c.finished.IsZero() {
+			sum.Pending++
+			continue
+		}
+		sum.Finished++
+		sum.Statuses[c.status]++
+		if c.finished.After(last) {
+			last = c.finished
+		}
+		d := c.finished.Sub(c.sent)
+		finished = append(finished, d)
+		images[c.image] = append(images[c.image], d)
+	}
+	if sum.Finished > 0 {
+		sum.Elapsed = last.Sub(first).Seconds()
+		if sum.Elapsed > 0 {
+			sum.Throughput = float64(sum.Finished) / sum.Elapsed
+		}
+	}
+	sum.QueueLatency = latencies(queued)
+	sum.Latency = latencies(finished)
+	for image, ds := range images {
+		sum.Images[image] = latencies(ds)
+	}
+	return sum
+}
+
+// latencies returns the percentiles of the given durations using the nearest
+// rank method.
+func latencies(ds []time.Duration) Latencies {
+	if len(ds) == 0 {
+		return Latencies{}
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	percentile := func(p float64) float64 {
+		i := int(math.Ceil(p*float64(len(ds)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return ds[i].Seconds()
+	}
+	return Latencies{
+		Count: len(ds),
+		P50:   percentile(0.5),
+		P90:   percentile(0.9),
+		P99:   percentile(0.99),
+		Max:   ds[len(ds)-1].Seconds(),
+	}
+}
+
+// Write writes the summary in a human readable format to the given writer.
+func (sum Summary) Write(w io.Writer) {
+	fmt.Fprintf(w, "checks: %d sent, %d finished, %d pending\n", sum.Sent, sum.Finished, sum.Pending)
+	statuses := make([]string, 0, len(sum.Statuses))
+	for st := range sum.Statuses {
+		statuses = append(statuses, st)
+	}
+	sort.Strings(statuses)
+	for _, st := range statuses {
+		fmt.Fprintf(w, "  %-12s %d\n", st, sum.Statuses[st])
+	}
+	fmt.Fprintf(w, "elapsed: %.1fs, throughput: %.2f checks/s\n", sum.Elapsed, sum.Throughput)
+	fmt.Fprintf(w, "%-30s %6s %9s %9s %9s %9s\n", "latency (s)", "count", "p50", "p90", "p99", "max")
+	writeLatencies(w, "queue", sum.QueueLatency)
+	writeLatencies(w, "end to end", sum.Latency)
+	images := make([]string, 0, len(sum.Images))
+	for image := range sum.Images {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	for _, image := range images {
+		writeLatencies(w, "  "+image, sum.Images[image])
+	}
+}
+
+func writeLatencies(w io.Writer, name string, l Latencies) {
+	fmt.Fprintf(w, "%-30s %6d %9.3f %9.3f %9.3f %9.3f\n", name, l.Count, l.P50, l.P90, l.P99, l.Max)
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package log
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/sirupsen/logrus"
+)
+
+// checkLogsPruneInterval is how often the files of the checks older than the
+// max age are removed.
+const checkLogsPruneInterval = time.Hour
+
+// reCheckLogName matches the check IDs that can be used as the name of a
+// file, so an ID can't be used to write outside the dir of the files.
+var reCheckLogName = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// CheckLogs writes the logs of the agent about each check, apart from to the
+// main log, to a file named as the ID of the check, so the trace of a check
+// can be handed to the developers of its checktype. The files are rotated
+// when they reach the max size and removed once they are older than the max
+// age.
+type CheckLogs struct {
+	log        Logger
+	dir        string
+	level      logrus.Level
+	maxSize    int64
+	maxBackups int
+	maxAge     time.Duration
+
+	// mu serializes the writes to the files and their rotations.
+	mu        sync.Mutex
+	lastPrune time.Time
+}
+
+// NewCheckLogs returns a CheckLogs that writes the logs of the checks to the
+// files in the configured dir, creating it if needed, and to the given log.
+func NewCheckLogs(l Logger, cfg config.CheckLogsConfig) (*CheckLogs, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("error creating check logs dir: %w", err)
+	}
+	return &CheckLogs{
+		log:        l,
+		dir:        cfg.Dir,
+		level:      ParseLogLevel(cfg.Level),
+		maxSize:    int64(cfg.MaxSizeMB) * 1024 * 1024,
+		maxBackups: cfg.MaxBackups,
+		maxAge:     time.Duration(cfg.MaxAge) * time.Hour,
+	}, nil
+}
+
+// For returns the log for the messages about the given check. If the ID of
+// the check can't be used as a file name only the main log is returned.
+func (c *CheckLogs) For(checkID string) Logger {
+	if !reCheckLogName.MatchString(checkID) {
+		return c.log
+	}
+	c.prune()
+	logger := logrus.New()
+	logger.Level = c.level
+	logger.Out = &checkLogFile{logs: c, path: filepath.Join(c.dir, checkID+".log")}
+	logger.Formatter = &logrus.TextFormatter{
+		FullTimestamp:   true,
+		TimestampFormat: time.RFC3339Nano,
+		DisableColors:   true,
+	}
+	return &teeLog{main: c.log, check: logger.WithField("check_id", checkID)}
+}
+
+// prune removes the files of the checks that were not written since the max
+// age, at most once every checkLogsPruneInterval.
+func (c *CheckLogs) prune() {
+	if c.maxAge <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if now.Sub(c.lastPrune) < checkLogsPruneInterval {
+		return
+	}
+	c.lastPrune = now
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		c.log.Errorf("error reading check logs dir: %+v", err)
+		return
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.Contains(e.Name(), ".log") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || now.Sub(info.ModTime()) < c.maxAge {
+			continue
+		}
+		if err := os.Remove(filepath.Join(c.dir, e.Name())); err != nil {
+			c.log.Errorf("error removing check log %s: %+v", e.Name(), err)
+		}
+	}
+}
+
+// checkLogFile writes to the file of a check. The file is opened for each
+// write, because the checks log a few lines, so the files of the checks that
+// already finished are never left open.
+type checkLogFile struct {
+	logs *CheckLogs
+	path string
+}
+
+func (f *checkLogFile) Write(p []byte) (int, error) {
+	f.logs.mu.Lock()
+	defer f.logs.mu.Unlock()
+	if err := f.rotate(len(p)); err != nil {
+		return 0, err
+	}
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return 0, err
+	}
+	n, err := file.Write(p)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// rotate moves the file to the first backup, and each backup to the next
+// one, if writing n bytes would make it bigger than the max size. Without
+// backups the file is just removed.
+func (f *checkLogFile) rotate(n int) error {
+	maxSize := f.logs.maxSize
+	if maxSize <= 0 {
+		return nil
+	}
+	info, err := os.Stat(f.path)
+	if err != nil || info.Size() == 0 || info.Size()+int64(n) <= maxSize {
+		return nil
+	}
+	if f.logs.maxBackups < 1 {
+		return os.Remove(f.path)
+	}
+	for i := f.logs.maxBackups - 1; i > 0; i-- {
+		// The backups that don't exist yet are ignored.
+		os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+	}
+	return os.Rename(f.path, f.path+".1")
+}
+
+// teeLog writes the messages to the main log and to the log of a check.
+type teeLog struct {
+	main  Logger
+	check *logrus.Entry
+}
+
+func (t *teeLog) Debugf(format string, args ...interface{}) {
+	t.main.Debugf(format, args...)
+	t.check.Debugf(format, args...)
+}
+
+func (t *teeLog) Infof(format string, args ...interface{}) {
+	t.main.Infof(format, args...)
+	t.check.Infof(format, args...)
+}
+
+func (t *teeLog) Errorf(format string, args ...interface{}) {
+	t.main.Errorf(format, args...)
+	t.check.Errorf(format, args...)
+}
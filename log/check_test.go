@@ -0,0 +1,99 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+)
+
+func TestCheckLogs(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewCheckLogs(&NullLog{}, config.CheckLogsConfig{
+		Dir:        dir,
+		Level:      "debug",
+		MaxBackups: 2,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The size is set in bytes so the files are rotated after a few lines.
+	c.maxSize = 200
+
+	l := c.For("1234")
+	l.Debugf("pulling image %s", "vulcan-nessus:1")
+	l.Infof("running check %s", "1234")
+	c.For("5678").Infof("running check %s", "5678")
+
+	data, err := os.ReadFile(filepath.Join(dir, "1234.log"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{"pulling image vulcan-nessus:1", "running check 1234", "check_id=1234"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("log of check 1234 doesn't contain %q:\n%s", want, data)
+		}
+	}
+	if strings.Contains(string(data), "5678") {
+		t.Errorf("log of check 1234 contains the logs of check 5678:\n%s", data)
+	}
+
+	for i := 0; i < 10; i++ {
+		l.Infof("line %d", i)
+	}
+	for _, name := range []string{"1234.log", "1234.log.1", "1234.log.2"} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if info.Size() > c.maxSize {
+			t.Errorf("file %s not rotated, size %d", name, info.Size())
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "1234.log.3")); !os.IsNotExist(err) {
+		t.Errorf("more backups than the max kept: %v", err)
+	}
+
+	if _, ok := c.For("../1234").(*NullLog); !ok {
+		t.Errorf("got a check log for an invalid check ID")
+	}
+}
+
+func TestCheckLogs_prune(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewCheckLogs(&NullLog{}, config.CheckLogsConfig{Dir: dir, MaxAge: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	for _, name := range []string{"old.log", "old.log.1", "recent.log", "other"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("log"), 0o600); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if name != "recent.log" {
+			if err := os.Chtimes(path, old, old); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+	}
+	c.For("1234")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+	if strings.Join(got, ",") != "other,recent.log" {
+		t.Errorf("got files %v, want [other recent.log]", got)
+	}
+}
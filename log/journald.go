@@ -0,0 +1,124 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package log
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// journalSocket is the socket where the systemd journal receives the
+// entries in its native protocol.
+var journalSocket = "/run/systemd/journal/socket"
+
+// journaldHook sends the entries of a log to the systemd journal. The fields
+// of the entries are sent as fields of the journal, with their names in upper
+// case, e.g.: CHECK_ID.
+type journaldHook struct {
+	tag string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newJournaldHook(tag string) (*journaldHook, error) {
+	if tag == "" {
+		tag = DefaultSyslogTag
+	}
+	h := &journaldHook{tag: tag}
+	if err := h.connect(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *journaldHook) connect() error {
+	conn, err := net.Dial("unixgram", journalSocket)
+	if err != nil {
+		return fmt.Errorf("error connecting to the systemd journal: %w", err)
+	}
+	h.conn = conn
+	return nil
+}
+
+// Levels returns the levels of the entries sent to the journal, that are all
+// of them, because the level of the log already filters them.
+func (h *journaldHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire sends an entry to the journal.
+func (h *journaldHook) Fire(e *logrus.Entry) error {
+	var b bytes.Buffer
+	writeJournalField(&b, "MESSAGE", e.Message)
+	writeJournalField(&b, "PRIORITY", fmt.Sprint(syslogSeverity(e.Level)))
+	writeJournalField(&b, "SYSLOG_IDENTIFIER", h.tag)
+	for k, v := range e.Data {
+		// The journal already records the hostname.
+		if k == "hostname" {
+			continue
+		}
+		name := journalFieldName(k)
+		if name == "" {
+			continue
+		}
+		writeJournalField(&b, name, fmt.Sprint(v))
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.conn != nil {
+		if _, err := h.conn.Write(b.Bytes()); err == nil {
+			return nil
+		}
+		h.conn.Close()
+		h.conn = nil
+	}
+	if err := h.connect(); err != nil {
+		return err
+	}
+	_, err := h.conn.Write(b.Bytes())
+	return err
+}
+
+// writeJournalField writes a field in the format of the native protocol of
+// the journal. The values with new lines are written with their size.
+func writeJournalField(b *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(b, "%s=%s\n", name, value)
+		return
+	}
+	b.WriteString(name)
+	b.WriteByte('\n')
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
+
+// journalFieldName returns the name of a field of the log as a valid name of
+// a field of the journal: upper case letters, digits and underscores, not
+// starting with an underscore, because those are reserved for the journal.
+func journalFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+	name = strings.TrimLeft(name, "_0123456789")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
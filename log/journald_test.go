@@ -0,0 +1,71 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package log
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestJournaldHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the systemd journal is not available on windows")
+	}
+	socket := filepath.Join(t.TempDir(), "journal.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+	defer func(s string) { journalSocket = s }(journalSocket)
+	journalSocket = socket
+
+	h, err := newJournaldHook("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logger := logrus.New()
+	logger.AddHook(h)
+	logger.Out = ioutil.Discard
+	logger.WithFields(logrus.Fields{"hostname": "host", "check_id": "1234"}).Errorf("error running check:\nexit 1")
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	msg := "error running check:\nexit 1"
+	var want bytes.Buffer
+	want.WriteString("MESSAGE\n")
+	binary.Write(&want, binary.LittleEndian, uint64(len(msg)))
+	want.WriteString(msg + "\n")
+	want.WriteString("PRIORITY=3\nSYSLOG_IDENTIFIER=vulcan-agent\nCHECK_ID=1234\n")
+	if got := buf[:n]; !bytes.Equal(got, want.Bytes()) {
+		t.Errorf("got entry %q, want %q", got, want.Bytes())
+	}
+}
+
+func TestJournalFieldName(t *testing.T) {
+	tests := map[string]string{
+		"check_id":  "CHECK_ID",
+		"Scan-ID":   "SCAN_ID",
+		"_internal": "INTERNAL",
+		"1st":       "ST",
+	}
+	for key, want := range tests {
+		if got := journalFieldName(key); got != want {
+			t.Errorf("journalFieldName(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package log
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Levels contains the levels of the log that can be set at runtime.
+var Levels = []string{"debug", "info", "warn", "error"}
+
+// ErrInvalidLevel is returned when a LevelSwitch is asked to set a level
+// that is not one of the Levels.
+var ErrInvalidLevel = errors.New("invalid log level")
+
+// LevelSwitch changes the level of a log at runtime. A level can be set only
+// for a period of time, after which the base level, the one in the config,
+// is restored, so the log doesn't stay in debug once an incident is over.
+type LevelSwitch struct {
+	log    Logger
+	setter LevelSetter
+
+	mu       sync.Mutex
+	base     string
+	level    string
+	revertAt *time.Time
+	timer    *time.Timer
+}
+
+// NewLevelSwitch returns a LevelSwitch that changes the level of the given
+// log, that is also used to log the changes, with the given base level.
+func NewLevelSwitch(l Logger, s LevelSetter, base string) *LevelSwitch {
+	return &LevelSwitch{log: l, setter: s, base: base, level: base}
+}
+
+// Level returns the current level and, if it's temporary, the time when the
+// base level will be restored.
+func (s *LevelSwitch) Level() (string, *time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.level, s.revertAt
+}
+
+// Set changes the level of the log. If d is greater than 0 the base level is
+// restored after d, otherwise the level is kept until it's changed again.
+func (s *LevelSwitch) Set(level string, d time.Duration) error {
+	if !validLevel(level) {
+		return fmt.Errorf("%w %q, must be one of %v", ErrInvalidLevel, level, Levels)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopTimer()
+	s.apply(level)
+	if d <= 0 {
+		s.log.Infof("log level set to %s", level)
+		return nil
+	}
+	revertAt := time.Now().Add(d)
+	s.revertAt = &revertAt
+	var timer *time.Timer
+	timer = time.AfterFunc(d, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		// The timer may have fired while it was being replaced.
+		if s.timer != timer {
+			return
+		}
+		s.timer, s.revertAt = nil, nil
+		s.apply(s.base)
+		s.log.Infof("log level reverted to %s", s.base)
+	})
+	s.timer = timer
+	s.log.Infof("log level set to %s until %s", level, revertAt.Format(time.RFC3339))
+	return nil
+}
+
+// SetBase changes the base level, e.g. when the config is reloaded. It's
+// applied immediately unless there is a temporary level, that is kept until
+// it expires.
+func (s *LevelSwitch) SetBase(level string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.base = level
+	if s.timer == nil {
+		s.apply(level)
+	}
+}
+
+func (s *LevelSwitch) apply(level string) {
+	s.level = level
+	s.setter.SetLevel(level)
+}
+
+func (s *LevelSwitch) stopTimer() {
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.timer, s.revertAt = nil, nil
+}
+
+func validLevel(level string) bool {
+	for _, l := range Levels {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
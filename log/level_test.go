@@ -0,0 +1,80 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package log
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type levelSetterMock struct {
+	mu    sync.Mutex
+	level string
+}
+
+func (m *levelSetterMock) SetLevel(level string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.level = level
+}
+
+func (m *levelSetterMock) Level() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.level
+}
+
+func TestLevelSwitch(t *testing.T) {
+	m := &levelSetterMock{level: "info"}
+	s := NewLevelSwitch(&NullLog{}, m, "info")
+
+	if err := s.Set("trace", 0); !errors.Is(err, ErrInvalidLevel) {
+		t.Fatalf("got error %v, want %v", err, ErrInvalidLevel)
+	}
+
+	if err := s.Set("error", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if level, revertAt := s.Level(); level != "error" || revertAt != nil || m.Level() != "error" {
+		t.Fatalf("got level %s, revert at %v, log level %s, want permanent error", level, revertAt, m.Level())
+	}
+
+	// A temporary level is kept when the base level changes and, once it
+	// expires, the new base level is restored.
+	if err := s.Set("debug", 50*time.Millisecond); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if level, revertAt := s.Level(); level != "debug" || revertAt == nil {
+		t.Fatalf("got level %s, revert at %v, want temporary debug", level, revertAt)
+	}
+	s.SetBase("warn")
+	if m.Level() != "debug" {
+		t.Fatalf("got log level %s, want debug", m.Level())
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for m.Level() != "warn" {
+		if time.Now().After(deadline) {
+			t.Fatalf("log level not reverted, got %s", m.Level())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if level, revertAt := s.Level(); level != "warn" || revertAt != nil {
+		t.Errorf("got level %s, revert at %v, want permanent warn", level, revertAt)
+	}
+
+	// Setting a level cancels the pending revert.
+	if err := s.Set("debug", 50*time.Millisecond); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.Set("info", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if m.Level() != "info" {
+		t.Errorf("got log level %s, want info", m.Level())
+	}
+}
@@ -5,6 +5,8 @@ Copyright 2021 Adevinta
 package log
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
 	"time"
 
@@ -12,12 +14,22 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// StderrLogFile is the value of the log_file config param that makes the log
+// to be written to the standard error.
+const StderrLogFile = "stderr"
+
 type Logger interface {
 	Debugf(format string, args ...interface{})
 	Infof(format string, args ...interface{})
 	Errorf(format string, args ...interface{})
 }
 
+// LevelSetter is implemented by the loggers whose level can be changed while
+// they are in use.
+type LevelSetter interface {
+	SetLevel(level string)
+}
+
 type NullLog struct{}
 
 func (n *NullLog) Debugf(format string, args ...interface{}) {
@@ -48,22 +60,50 @@ func New(cfg config.AgentConfig) (Logger, error) {
 		FullTimestamp:   true,
 		TimestampFormat: time.RFC3339Nano,
 	}
+	logger.Formatter = formatter
 	logger.Out = os.Stdout
-	if cfg.LogFile != "" {
-		logFile, err := os.OpenFile(cfg.LogFile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	// The entries sent to syslog or to the journal are not written to
+	// the standard output or to any file.
+	switch cfg.LogOutput {
+	case "", config.LogOutputFile:
+		if cfg.LogFile == StderrLogFile {
+			logger.Out = os.Stderr
+		} else if cfg.LogFile != "" {
+			logFile, err := os.OpenFile(cfg.LogFile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+			if err != nil {
+				logger.Errorf("error opening log file: %v", err)
+				return nil, err
+			}
+			logger.Out = logFile
+			formatter.DisableColors = true
+		}
+	case config.LogOutputSyslog:
+		h, err := newSyslogHook(cfg.Syslog, hostname)
+		if err != nil {
+			return nil, err
+		}
+		logger.AddHook(h)
+		logger.Out = ioutil.Discard
+	case config.LogOutputJournald:
+		h, err := newJournaldHook(cfg.Syslog.Tag)
 		if err != nil {
-			logger.Errorf("error opening log file: %v", err)
 			return nil, err
 		}
-		logger.Out = logFile
-		formatter.DisableColors = true
+		logger.AddHook(h)
+		logger.Out = ioutil.Discard
+	default:
+		return nil, fmt.Errorf("invalid log output %q", cfg.LogOutput)
 	}
-	logger.Formatter = formatter
 	// Add hostname to all log entries.
 	l := logrus.NewEntry(logger).WithFields(logrus.Fields{"hostname": hostname})
 	return &Log{l}, nil
 }
 
+// SetLevel changes the level of the log.
+func (l *Log) SetLevel(level string) {
+	l.Logger.SetLevel(ParseLogLevel(level))
+}
+
 func ParseLogLevel(logLevel string) logrus.Level {
 	switch logLevel {
 	case "panic":
@@ -0,0 +1,249 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package log
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultSyslogTag is the app name of the messages sent to syslog when no
+// tag is configured.
+const DefaultSyslogTag = config.DefaultSyslogTag
+
+// syslogTimeout is the max time to connect to the syslog server and to write
+// a message to it.
+const syslogTimeout = 5 * time.Second
+
+var syslogFacilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+// syslogSeverity returns the syslog severity of a log level.
+func syslogSeverity(level logrus.Level) int {
+	switch level {
+	case logrus.PanicLevel:
+		return 0 // emerg
+	case logrus.FatalLevel:
+		return 2 // crit
+	case logrus.ErrorLevel:
+		return 3 // err
+	case logrus.WarnLevel:
+		return 4 // warning
+	case logrus.InfoLevel:
+		return 6 // info
+	default:
+		return 7 // debug
+	}
+}
+
+// syslogHook sends the entries of a log to a syslog server in the RFC 5424
+// format. The messages sent over TCP and TLS are framed using octet counting,
+// as defined in RFC 6587.
+type syslogHook struct {
+	network  string
+	address  string
+	tlsCfg   *tls.Config
+	facility int
+	tag      string
+	hostname string
+	msg      logrus.Formatter
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newSyslogHook(cfg config.SyslogConfig, hostname string) (*syslogHook, error) {
+	if cfg.Address == "" {
+		return nil, fmt.Errorf("the syslog address is required")
+	}
+	h := &syslogHook{
+		network:  cfg.Network,
+		address:  cfg.Address,
+		facility: syslogFacilities["daemon"],
+		tag:      cfg.Tag,
+		hostname: hostname,
+		msg:      &logrus.TextFormatter{DisableTimestamp: true, DisableColors: true},
+	}
+	if h.network == "" {
+		h.network = "udp"
+	}
+	if h.tag == "" {
+		h.tag = DefaultSyslogTag
+	}
+	if cfg.Facility != "" {
+		f, ok := syslogFacilities[cfg.Facility]
+		if !ok {
+			return nil, fmt.Errorf("invalid syslog facility %q", cfg.Facility)
+		}
+		h.facility = f
+	}
+	switch h.network {
+	case "udp", "tcp":
+	case "tls":
+		tlsCfg, err := syslogTLSConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		h.tlsCfg = tlsCfg
+	default:
+		return nil, fmt.Errorf("invalid syslog network %q, must be udp, tcp or tls", h.network)
+	}
+	// The server is reached before using the log, so a wrong address is
+	// detected when the agent starts.
+	if err := h.connect(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func syslogTLSConfig(cfg config.SyslogConfig) (*tls.Config, error) {
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CACert != "" {
+		pem, err := ioutil.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("error reading syslog CA cert: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("invalid syslog CA cert %s", cfg.CACert)
+		}
+		tlsCfg.RootCAs = pool
+	}
+	if cfg.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("error loading syslog client cert: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	return tlsCfg, nil
+}
+
+func (h *syslogHook) connect() error {
+	dialer := &net.Dialer{Timeout: syslogTimeout}
+	var (
+		conn net.Conn
+		err  error
+	)
+	if h.network == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", h.address, h.tlsCfg)
+	} else {
+		conn, err = dialer.Dial(h.network, h.address)
+	}
+	if err != nil {
+		return fmt.Errorf("error connecting to syslog %s: %w", h.address, err)
+	}
+	h.conn = conn
+	return nil
+}
+
+// Levels returns the levels of the entries sent to syslog, that are all of
+// them, because the level of the log already filters them.
+func (h *syslogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire sends an entry to syslog. If sending it fails the connection is
+// established again and the entry sent once more.
+func (h *syslogHook) Fire(e *logrus.Entry) error {
+	msg, err := h.format(e)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.conn != nil {
+		if err = h.write(msg); err == nil {
+			return nil
+		}
+		h.conn.Close()
+		h.conn = nil
+	}
+	if err := h.connect(); err != nil {
+		return err
+	}
+	return h.write(msg)
+}
+
+func (h *syslogHook) write(msg []byte) error {
+	if h.network != "udp" {
+		msg = append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+	}
+	h.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	_, err := h.conn.Write(msg)
+	return err
+}
+
+// format returns an entry as a RFC 5424 message. The fields of the entry,
+// apart from the hostname, that is in the header, are added to the message
+// as key=value pairs.
+func (h *syslogHook) format(e *logrus.Entry) ([]byte, error) {
+	data := make(logrus.Fields, len(e.Data))
+	for k, v := range e.Data {
+		if k != "hostname" {
+			data[k] = v
+		}
+	}
+	msg, err := h.msg.Format(&logrus.Entry{Logger: e.Logger, Data: data, Level: e.Level, Message: e.Message})
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %d - - ",
+		h.facility*8+syslogSeverity(e.Level),
+		e.Time.UTC().Format(time.RFC3339Nano),
+		syslogHeaderField(h.hostname),
+		syslogHeaderField(h.tag),
+		os.Getpid(),
+	)
+	b.Write(bytes.TrimRight(msg, "\n"))
+	return b.Bytes(), nil
+}
+
+// syslogHeaderField returns a value valid as a field of the header of a RFC
+// 5424 message: printable ASCII without spaces, or "-" if it's empty.
+func syslogHeaderField(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, s)
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package log
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/sirupsen/logrus"
+)
+
+var reSyslogMessage = regexp.MustCompile(`^<(\d+)>1 (\S+) host vulcan-agent (\d+) - - (.*)$`)
+
+func TestSyslogHook_UDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+	h, err := newSyslogHook(config.SyslogConfig{Address: conn.LocalAddr().String(), Facility: "local0"}, "host")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logger := logrus.New()
+	logger.AddHook(h)
+	logger.Out = ioutil.Discard
+	logger.WithFields(logrus.Fields{"hostname": "host", "check_id": "1234"}).Errorf("error running check")
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m := reSyslogMessage.FindStringSubmatch(string(buf[:n]))
+	if m == nil {
+		t.Fatalf("invalid message %q", buf[:n])
+	}
+	// local0 (16) * 8 + err (3).
+	if m[1] != "131" {
+		t.Errorf("got priority %s, want 131", m[1])
+	}
+	if _, err := time.Parse(time.RFC3339Nano, m[2]); err != nil {
+		t.Errorf("invalid timestamp %s: %v", m[2], err)
+	}
+	if m[3] != strconv.Itoa(os.Getpid()) {
+		t.Errorf("got proc ID %s, want %d", m[3], os.Getpid())
+	}
+	want := `level=error msg="error running check" check_id=1234`
+	if m[4] != want {
+		t.Errorf("got message %q, want %q", m[4], want)
+	}
+}
+
+func TestSyslogHook_TCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer ln.Close()
+	frames := make(chan string, 2)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(conn)
+			for {
+				size, err := r.ReadString(' ')
+				if err != nil {
+					break
+				}
+				n, err := strconv.Atoi(strings.TrimSpace(size))
+				if err != nil {
+					break
+				}
+				msg := make([]byte, n)
+				if _, err := io.ReadFull(r, msg); err != nil {
+					break
+				}
+				frames <- string(msg)
+			}
+			conn.Close()
+		}
+	}()
+	h, err := newSyslogHook(config.SyslogConfig{Network: "tcp", Address: ln.Addr().String()}, "host")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logger := logrus.New()
+	logger.AddHook(h)
+	logger.Out = ioutil.Discard
+	logger.Infof("first")
+	logger.Debugf("not sent")
+	logger.Warnf("second")
+	for _, want := range []string{`<30>1 .* level=info msg=first$`, `<28>1 .* level=warning msg=second$`} {
+		select {
+		case got := <-frames:
+			if !regexp.MustCompile(want).MatchString(got) {
+				t.Errorf("got message %q, want %s", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("message %s not received", want)
+		}
+	}
+}
+
+func TestNewSyslogHook_Invalid(t *testing.T) {
+	for _, cfg := range []config.SyslogConfig{
+		{},
+		{Address: "127.0.0.1:514", Network: "http"},
+		{Address: "127.0.0.1:514", Facility: "other"},
+	} {
+		if _, err := newSyslogHook(cfg, "host"); err == nil {
+			t.Errorf("no error creating hook with config %+v", cfg)
+		}
+	}
+}
@@ -53,7 +53,7 @@ func NewMetrics(l log.Logger, cfg config.DatadogConfig, aborter Agent) *Metrics
 	}
 	if !cfg.Enabled {
 		l.Infof("metrics disabled in agent: %s", agentID)
-		return &Metrics{Enabled: false}
+		return &Metrics{Enabled: false, Aborter: aborter}
 	}
 	l.Infof("metrics enabled in agent: %s", agentID)
 	// Parse DataDog config.
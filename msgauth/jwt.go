@@ -0,0 +1,201 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package msgauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+)
+
+// Algorithms of the JWT signatures supported.
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+)
+
+// jwtLeeway is the clock skew allowed when checking the times of the claims
+// of a token.
+const jwtLeeway = time.Minute
+
+// JWTVerifier authenticates the messages that are JWTs signed with the
+// configured key, HS256 when it's a secret, or RS256 or ES256 when it's a
+// public key. The claims of the token are the fields of the job plus the
+// registered claims "exp" and "nbf", that are checked when present, and "iss"
+// and "aud", that are required to match the configured issuer and audience,
+// if any.
+type JWTVerifier struct {
+	alg      string
+	secret   []byte
+	key      crypto.PublicKey
+	issuer   string
+	audience string
+	now      func() time.Time
+}
+
+// NewJWTVerifier creates a JWTVerifier from the config. The public key, in
+// PEM format, takes precedence over the key.
+func NewJWTVerifier(cfg config.MessageAuthConfig) (*JWTVerifier, error) {
+	v := &JWTVerifier{issuer: cfg.Issuer, audience: cfg.Audience, now: time.Now}
+	if cfg.PublicKey == "" {
+		if cfg.Key == "" {
+			return nil, errors.New("the jwt message authentication requires a key or a public key")
+		}
+		v.alg, v.secret = AlgHS256, []byte(cfg.Key)
+		return v, nil
+	}
+	block, _ := pem.Decode([]byte(cfg.PublicKey))
+	if block == nil {
+		return nil, errors.New("invalid public key, it must be in PEM format")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		v.alg = AlgRS256
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return nil, errors.New("invalid public key, only the P-256 curve is supported")
+		}
+		v.alg = AlgES256
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+	v.key = key
+	return v, nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+}
+
+type jwtClaims struct {
+	Issuer    string      `json:"iss"`
+	Audience  jwtAudience `json:"aud"`
+	ExpiresAt *int64      `json:"exp"`
+	NotBefore *int64      `json:"nbf"`
+}
+
+// jwtAudience is the "aud" claim, that can be a string or an array of
+// strings.
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*a = jwtAudience{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(data, &ss); err != nil {
+		return errors.New("invalid aud claim")
+	}
+	*a = ss
+	return nil
+}
+
+// Verify returns the claims of a token if it's valid. The algorithm of the
+// token must be the one of the configured key, so a token signed with HS256
+// using a public key as the secret is rejected.
+func (v *JWTVerifier) Verify(body string) (string, error) {
+	parts := strings.Split(strings.TrimSpace(body), ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("%w: invalid jwt", ErrUnauthenticated)
+	}
+	var h jwtHeader
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return "", fmt.Errorf("%w: invalid jwt header: %v", ErrUnauthenticated, err)
+	}
+	if h.Alg != v.alg {
+		return "", fmt.Errorf("%w: unexpected jwt algorithm %q", ErrUnauthenticated, h.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid jwt signature: %v", ErrUnauthenticated, err)
+	}
+	if !v.validSignature([]byte(parts[0]+"."+parts[1]), sig) {
+		return "", fmt.Errorf("%w: invalid jwt signature", ErrUnauthenticated)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid jwt claims: %v", ErrUnauthenticated, err)
+	}
+	var c jwtClaims
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return "", fmt.Errorf("%w: invalid jwt claims: %v", ErrUnauthenticated, err)
+	}
+	if err := v.checkClaims(c); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	return string(payload), nil
+}
+
+func (v *JWTVerifier) validSignature(signed, sig []byte) bool {
+	digest := sha256.Sum256(signed)
+	switch v.alg {
+	case AlgHS256:
+		mac := hmac.New(sha256.New, v.secret)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), sig)
+	case AlgRS256:
+		return rsa.VerifyPKCS1v15(v.key.(*rsa.PublicKey), crypto.SHA256, digest[:], sig) == nil
+	case AlgES256:
+		// The ECDSA signatures of the JWTs are the concatenation of R and S.
+		if len(sig) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(v.key.(*ecdsa.PublicKey), digest[:], r, s)
+	}
+	return false
+}
+
+func (v *JWTVerifier) checkClaims(c jwtClaims) error {
+	now := v.now()
+	if c.ExpiresAt != nil && now.After(time.Unix(*c.ExpiresAt, 0).Add(jwtLeeway)) {
+		return errors.New("expired jwt")
+	}
+	if c.NotBefore != nil && now.Add(jwtLeeway).Before(time.Unix(*c.NotBefore, 0)) {
+		return errors.New("jwt not valid yet")
+	}
+	if v.issuer != "" && c.Issuer != v.issuer {
+		return fmt.Errorf("unexpected jwt issuer %q", c.Issuer)
+	}
+	if v.audience != "" {
+		for _, aud := range c.Audience {
+			if aud == v.audience {
+				return nil
+			}
+		}
+		return fmt.Errorf("jwt not intended for audience %q", v.audience)
+	}
+	return nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
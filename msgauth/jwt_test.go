@@ -0,0 +1,200 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package msgauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+)
+
+func encodeJWT(t *testing.T, alg string, claims map[string]interface{}, sign func(signed []byte) []byte) string {
+	t.Helper()
+	h, err := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signed)))
+}
+
+func hs256(key []byte) func([]byte) []byte {
+	return func(signed []byte) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(signed)
+		return mac.Sum(nil)
+	}
+}
+
+func publicKeyPEM(t *testing.T, key crypto.PublicKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func TestJWTVerifier_Verify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rs256 := func(signed []byte) []byte {
+		digest := sha256.Sum256(signed)
+		sig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return sig
+	}
+	es256 := func(signed []byte) []byte {
+		digest := sha256.Sum256(signed)
+		r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig
+	}
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	job := func(extra map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{"check_id": "1234", "image": "vulcan-nessus:1"}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+	rsaPEM := publicKeyPEM(t, &rsaKey.PublicKey)
+	tests := []struct {
+		name    string
+		cfg     config.MessageAuthConfig
+		token   string
+		wantErr bool
+	}{
+		{
+			name:  "HS256",
+			cfg:   config.MessageAuthConfig{Key: "key"},
+			token: encodeJWT(t, AlgHS256, job(nil), hs256([]byte("key"))),
+		},
+		{
+			name:    "HS256InvalidKey",
+			cfg:     config.MessageAuthConfig{Key: "key"},
+			token:   encodeJWT(t, AlgHS256, job(nil), hs256([]byte("other"))),
+			wantErr: true,
+		},
+		{
+			name:  "RS256",
+			cfg:   config.MessageAuthConfig{PublicKey: rsaPEM},
+			token: encodeJWT(t, AlgRS256, job(nil), rs256),
+		},
+		{
+			name:  "ES256",
+			cfg:   config.MessageAuthConfig{PublicKey: publicKeyPEM(t, &ecKey.PublicKey)},
+			token: encodeJWT(t, AlgES256, job(nil), es256),
+		},
+		{
+			name:    "PublicKeyAsHMACSecret",
+			cfg:     config.MessageAuthConfig{PublicKey: rsaPEM},
+			token:   encodeJWT(t, AlgHS256, job(nil), hs256([]byte(rsaPEM))),
+			wantErr: true,
+		},
+		{
+			name:    "AlgNone",
+			cfg:     config.MessageAuthConfig{Key: "key"},
+			token:   encodeJWT(t, "none", job(nil), func([]byte) []byte { return nil }),
+			wantErr: true,
+		},
+		{
+			name: "ValidClaims",
+			cfg:  config.MessageAuthConfig{Key: "key", Issuer: "scheduler", Audience: "agents"},
+			token: encodeJWT(t, AlgHS256, job(map[string]interface{}{
+				"iss": "scheduler",
+				"aud": []string{"other", "agents"},
+				"exp": now.Add(time.Hour).Unix(),
+				"nbf": now.Add(-time.Hour).Unix(),
+			}), hs256([]byte("key"))),
+		},
+		{
+			name:    "Expired",
+			cfg:     config.MessageAuthConfig{Key: "key"},
+			token:   encodeJWT(t, AlgHS256, job(map[string]interface{}{"exp": now.Add(-time.Hour).Unix()}), hs256([]byte("key"))),
+			wantErr: true,
+		},
+		{
+			name:    "NotValidYet",
+			cfg:     config.MessageAuthConfig{Key: "key"},
+			token:   encodeJWT(t, AlgHS256, job(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()}), hs256([]byte("key"))),
+			wantErr: true,
+		},
+		{
+			name:    "InvalidIssuer",
+			cfg:     config.MessageAuthConfig{Key: "key", Issuer: "scheduler"},
+			token:   encodeJWT(t, AlgHS256, job(map[string]interface{}{"iss": "attacker"}), hs256([]byte("key"))),
+			wantErr: true,
+		},
+		{
+			name:    "InvalidAudience",
+			cfg:     config.MessageAuthConfig{Key: "key", Audience: "agents"},
+			token:   encodeJWT(t, AlgHS256, job(map[string]interface{}{"aud": "other"}), hs256([]byte("key"))),
+			wantErr: true,
+		},
+		{
+			name:    "NotAJWT",
+			cfg:     config.MessageAuthConfig{Key: "key"},
+			token:   `{"check_id":"1234","image":"vulcan-nessus:1"}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := NewJWTVerifier(tt.cfg)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			v.now = func() time.Time { return now }
+			got, err := v.Verify(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if !errors.Is(err, ErrUnauthenticated) {
+					t.Errorf("error %v doesn't wrap ErrUnauthenticated", err)
+				}
+				return
+			}
+			var claims map[string]interface{}
+			if err := json.Unmarshal([]byte(got), &claims); err != nil {
+				t.Fatalf("invalid claims %s: %v", got, err)
+			}
+			if claims["check_id"] != "1234" || claims["image"] != "vulcan-nessus:1" {
+				t.Errorf("unexpected claims %s", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+// Package msgauth authenticates the messages of the jobs received by the
+// agent and signs the state updates it sends, so a compromised queue can't be
+// used to run arbitrary images in the agents nor to forge the results of the
+// checks.
+//
+// The messages signed with HMAC are JSON objects whose last field is "sig",
+// containing the hex HMAC-SHA256 of the object without that field, e.g.:
+//
+//	{"check_id":"1234","image":"vulcansec/vulcan-nessus:1","sig":"8a3f..."}
+//
+// is signed by computing the HMAC of:
+//
+//	{"check_id":"1234","image":"vulcansec/vulcan-nessus:1"}
+//
+// The messages signed with JWT are tokens in the compact serialization whose
+// claims are the fields of the job, see JWTVerifier.
+package msgauth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/adevinta/vulcan-agent/config"
+)
+
+// Types of message authentication.
+const (
+	TypeHMAC = "hmac"
+	TypeJWT  = "jwt"
+)
+
+var (
+	// ErrUnauthenticated is returned when a message is not signed or its
+	// signature is not valid.
+	ErrUnauthenticated = errors.New("unauthenticated message")
+
+	// ErrNoSignature is returned when a message doesn't contain a signature.
+	ErrNoSignature = errors.New("signature not found")
+)
+
+// sigPrefix is the prefix of the signature field, that is always the last
+// field of a signed JSON object.
+const sigPrefix = `,"sig":"`
+
+// Verifier is implemented by the components that authenticate the messages
+// of the jobs. Verify returns the job contained in an authenticated message
+// or an error that wraps ErrUnauthenticated.
+type Verifier interface {
+	Verify(body string) (string, error)
+}
+
+// NewVerifier returns the Verifier defined in the config, or nil if the
+// messages must not be authenticated.
+func NewVerifier(cfg config.MessageAuthConfig) (Verifier, error) {
+	switch cfg.Type {
+	case "":
+		return nil, nil
+	case TypeHMAC:
+		if cfg.Key == "" {
+			return nil, errors.New("the hmac message authentication requires a key")
+		}
+		return &HMACVerifier{Key: []byte(cfg.Key)}, nil
+	case TypeJWT:
+		return NewJWTVerifier(cfg)
+	default:
+		return nil, fmt.Errorf("invalid message authentication type %q", cfg.Type)
+	}
+}
+
+// Sign appends to a JSON object the signature field with the HMAC-SHA256 of
+// the object. It returns the signed object and the signature.
+func Sign(key, data []byte) ([]byte, string, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) < 3 || data[0] != '{' || data[len(data)-1] != '}' {
+		return nil, "", errors.New("only non empty JSON objects can be signed")
+	}
+	sig := hmacHex(key, data)
+	signed := make([]byte, 0, len(data)+len(sigPrefix)+len(sig)+2)
+	signed = append(signed, data[:len(data)-1]...)
+	signed = append(signed, sigPrefix+sig+`"}`...)
+	return signed, sig, nil
+}
+
+// Split returns the JSON object, without the signature field, and the
+// signature of a signed object.
+func Split(signed []byte) ([]byte, string, error) {
+	signed = bytes.TrimSpace(signed)
+	if !bytes.HasSuffix(signed, []byte(`"}`)) {
+		return nil, "", ErrNoSignature
+	}
+	i := bytes.LastIndex(signed, []byte(sigPrefix))
+	if i < 0 {
+		return nil, "", ErrNoSignature
+	}
+	sig := signed[i+len(sigPrefix) : len(signed)-2]
+	if bytes.ContainsAny(sig, `"\`) {
+		return nil, "", ErrNoSignature
+	}
+	data := make([]byte, 0, i+1)
+	data = append(data, signed[:i]...)
+	data = append(data, '}')
+	return data, string(sig), nil
+}
+
+// Check returns the JSON object, without the signature field, of a signed
+// object if its signature is valid. Otherwise it returns an error wrapping
+// ErrUnauthenticated.
+func Check(key, signed []byte) ([]byte, error) {
+	data, sig, err := Split(signed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	if !hmac.Equal([]byte(hmacHex(key, data)), []byte(sig)) {
+		return nil, fmt.Errorf("%w: invalid signature", ErrUnauthenticated)
+	}
+	return data, nil
+}
+
+func hmacHex(key, data []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// HMACVerifier authenticates the messages signed with HMAC-SHA256.
+type HMACVerifier struct {
+	Key []byte
+}
+
+// Verify returns the job of a message, without the signature, if the
+// signature is valid.
+func (v *HMACVerifier) Verify(body string) (string, error) {
+	data, err := Check(v.Key, []byte(body))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
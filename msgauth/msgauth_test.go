@@ -0,0 +1,172 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package msgauth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSign(t *testing.T) {
+	signed, sig, err := Sign([]byte("key"), []byte(`{"check_id":"1234"}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := `{"check_id":"1234","sig":"` + sig + `"}`
+	if string(signed) != want {
+		t.Errorf("got %s, want %s", signed, want)
+	}
+	data, err := Check([]byte("key"), signed)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(data) != `{"check_id":"1234"}` {
+		t.Errorf("got %s, want the original object", data)
+	}
+	for _, invalid := range []string{"", "{}", "[1]", "null"} {
+		if _, _, err := Sign([]byte("key"), []byte(invalid)); err == nil {
+			t.Errorf("%q signed", invalid)
+		}
+	}
+}
+
+func TestHMACVerifier_Verify(t *testing.T) {
+	signed, _, err := Sign([]byte("key"), []byte(`{"check_id":"1234","image":"vulcan-nessus:1"}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tests := []struct {
+		name    string
+		body    string
+		want    string
+		wantErr error
+	}{
+		{
+			name: "Valid",
+			body: string(signed),
+			want: `{"check_id":"1234","image":"vulcan-nessus:1"}`,
+		},
+		{
+			name:    "Unsigned",
+			body:    `{"check_id":"1234","image":"vulcan-nessus:1"}`,
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name:    "Modified",
+			body:    string(signed[:30]) + "evil" + string(signed[34:]),
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name:    "InvalidSignature",
+			body:    `{"check_id":"1234","image":"vulcan-nessus:1","sig":"1234"}`,
+			wantErr: ErrUnauthenticated,
+		},
+	}
+	v := &HMACVerifier{Key: []byte("key")}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := v.Verify(tt.body)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewVerifier(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.MessageAuthConfig
+		wantNil bool
+		wantErr bool
+	}{
+		{
+			name:    "Disabled",
+			wantNil: true,
+		},
+		{
+			name: "HMAC",
+			cfg:  config.MessageAuthConfig{Type: TypeHMAC, Key: "key"},
+		},
+		{
+			name:    "HMACWithoutKey",
+			cfg:     config.MessageAuthConfig{Type: TypeHMAC},
+			wantErr: true,
+		},
+		{
+			name: "JWT",
+			cfg:  config.MessageAuthConfig{Type: TypeJWT, Key: "key"},
+		},
+		{
+			name:    "JWTWithoutKey",
+			cfg:     config.MessageAuthConfig{Type: TypeJWT},
+			wantErr: true,
+		},
+		{
+			name:    "InvalidType",
+			cfg:     config.MessageAuthConfig{Type: "basic", Key: "key"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := NewVerifier(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && (v == nil) != tt.wantNil {
+				t.Errorf("got verifier %v, want nil %v", v, tt.wantNil)
+			}
+		})
+	}
+}
+
+type groupWriterMock struct {
+	groups []string
+	bodies []string
+}
+
+func (w *groupWriterMock) Write(body string) error {
+	return w.WriteGroup("", body)
+}
+
+func (w *groupWriterMock) WriteGroup(group, body string) error {
+	w.groups = append(w.groups, group)
+	w.bodies = append(w.bodies, body)
+	return nil
+}
+
+func TestSigningWriter(t *testing.T) {
+	w := &groupWriterMock{}
+	sw := NewSigningWriter(w, "key")
+	if err := sw.Write(`{"id":"1","status":"RUNNING"}`); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := sw.WriteGroup("1", `{"id":"1","status":"FINISHED"}`); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"", "1"}, w.groups); diff != "" {
+		t.Errorf("groups mismatch (-want +got):\n%v", diff)
+	}
+	v := &HMACVerifier{Key: []byte("key")}
+	var got []string
+	for _, b := range w.bodies {
+		body, err := v.Verify(b)
+		if err != nil {
+			t.Fatalf("unexpected error verifying %s: %v", b, err)
+		}
+		got = append(got, body)
+	}
+	want := []string{`{"id":"1","status":"RUNNING"}`, `{"id":"1","status":"FINISHED"}`}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("bodies mismatch (-want +got):\n%v", diff)
+	}
+}
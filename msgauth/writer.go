@@ -0,0 +1,46 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package msgauth
+
+import (
+	"github.com/adevinta/vulcan-agent/queue"
+	"github.com/adevinta/vulcan-agent/stateupdater"
+)
+
+// SigningWriter decorates a queue writer signing with HMAC-SHA256 the
+// messages written to it, e.g. the state updates of the checks, so their
+// consumers can verify they were sent by an agent that knows the key.
+type SigningWriter struct {
+	w   queue.Writer
+	key []byte
+}
+
+// NewSigningWriter creates a SigningWriter that signs the messages with the
+// given key before writing them to w.
+func NewSigningWriter(w queue.Writer, key string) *SigningWriter {
+	return &SigningWriter{w: w, key: []byte(key)}
+}
+
+// Write signs a message and writes it.
+func (s *SigningWriter) Write(body string) error {
+	signed, _, err := Sign(s.key, []byte(body))
+	if err != nil {
+		return err
+	}
+	return s.w.Write(string(signed))
+}
+
+// WriteGroup signs a message and writes it in the given group, if the
+// decorated writer supports groups.
+func (s *SigningWriter) WriteGroup(group, body string) error {
+	signed, _, err := Sign(s.key, []byte(body))
+	if err != nil {
+		return err
+	}
+	if gw, ok := s.w.(stateupdater.GroupWriter); ok {
+		return gw.WriteGroup(group, string(signed))
+	}
+	return s.w.Write(string(signed))
+}
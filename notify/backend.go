@@ -0,0 +1,71 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/adevinta/vulcan-agent/config"
+)
+
+// DefaultDegradedThreshold is the number of consecutive errors starting
+// checks after which the agent is considered degraded when it's not
+// configured.
+const DefaultDegradedThreshold = config.DefaultDegradedThreshold
+
+// Backend decorates a backend.Backend notifying when the agent becomes
+// degraded, that is, when the backend fails to start a given number of
+// consecutive checks, for instance, because the images can't be pulled. When
+// the backend starts a check again the agent.recovered event is notified.
+type Backend struct {
+	backend.Backend
+	notifier  *Notifier
+	threshold int
+
+	mu       sync.Mutex
+	errors   int
+	degraded bool
+}
+
+// NewBackend returns a Backend that sends the events to the given notifier.
+// If threshold is less than 1 the DefaultDegradedThreshold is used.
+func NewBackend(b backend.Backend, n *Notifier, threshold int) *Backend {
+	if threshold < 1 {
+		threshold = DefaultDegradedThreshold
+	}
+	return &Backend{Backend: b, notifier: n, threshold: threshold}
+}
+
+// Run runs the check using the decorated backend.
+func (b *Backend) Run(ctx context.Context, params backend.RunParams) (<-chan backend.RunResult, error) {
+	res, err := b.Backend.Run(ctx, params)
+	// The checks that miss required vars don't mean the backend is degraded.
+	if ctx.Err() != nil || errors.Is(err, backend.ErrMissingVars) {
+		return res, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		b.errors = 0
+		if b.degraded {
+			b.degraded = false
+			b.notifier.Notify(Event{Type: EventAgentRecovered})
+		}
+		return res, err
+	}
+	b.errors++
+	if b.errors >= b.threshold && !b.degraded {
+		b.degraded = true
+		b.notifier.Notify(Event{
+			Type:  EventAgentDegraded,
+			Error: fmt.Sprintf("%d consecutive errors starting checks, last: %v", b.errors, err),
+		})
+	}
+	return res, err
+}
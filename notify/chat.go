@@ -0,0 +1,121 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/retryer"
+)
+
+// Types of the chats supported.
+const (
+	ChatSlack = "slack"
+	ChatTeams = "teams"
+)
+
+// DefaultChatSeverity is the minimum severity of the events sent to a chat
+// when it's not configured.
+const DefaultChatSeverity = config.DefaultChatSeverity
+
+var severityColors = map[int]string{
+	SeverityInfo:     "2EB67D",
+	SeverityWarning:  "ECB22E",
+	SeverityError:    "E01E5A",
+	SeverityCritical: "8B0000",
+}
+
+// Chat posts a human readable summary of the events to a Slack or MS Teams
+// incoming webhook. Only the events with a severity equal or higher than the
+// configured one are sent.
+type Chat struct {
+	typ         string
+	url         string
+	minSeverity int
+	client      *http.Client
+	retryer     retryer.Retryer
+}
+
+// NewChat creates a Chat from the given config.
+func NewChat(l log.Logger, cfg config.ChatConfig) (*Chat, error) {
+	typ := strings.ToLower(cfg.Type)
+	if typ != ChatSlack && typ != ChatTeams {
+		return nil, fmt.Errorf("invalid chat type %q", cfg.Type)
+	}
+	severity := cfg.MinSeverity
+	if severity == "" {
+		severity = DefaultChatSeverity
+	}
+	min, err := ParseSeverity(severity)
+	if err != nil {
+		return nil, err
+	}
+	return &Chat{
+		typ:         typ,
+		url:         cfg.URL,
+		minSeverity: min,
+		client:      &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second},
+		retryer:     retryer.NewRetryer(cfg.Retries, cfg.RetryInterval, l),
+	}, nil
+}
+
+// Accepts returns true if the severity of the events of the given type is
+// equal or higher than the minimum severity of the chat.
+func (c *Chat) Accepts(eventType string) bool {
+	return Severity(eventType) >= c.minSeverity
+}
+
+// Send posts the summary of the event to the chat.
+func (c *Chat) Send(e Event) error {
+	var msg interface{}
+	text := Summary(e)
+	switch c.typ {
+	case ChatTeams:
+		msg = map[string]string{
+			"@type":      "MessageCard",
+			"@context":   "http://schema.org/extensions",
+			"themeColor": severityColors[Severity(e.Type)],
+			"summary":    e.Type,
+			"text":       text,
+		}
+	default:
+		msg = map[string]string{"text": text}
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.retryer.WithRetries("Chat.Send", func() error {
+		return post(c.client, c.url, body, nil)
+	})
+}
+
+// Summary returns a one line description of the event.
+func Summary(e Event) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s] ", strings.ToUpper(severityNames[Severity(e.Type)]))
+	switch {
+	case e.CheckID != "":
+		fmt.Fprintf(&b, "check %s: %s", e.CheckID, e.Type)
+		if e.Status != "" {
+			fmt.Fprintf(&b, " (status %s)", e.Status)
+		}
+	default:
+		b.WriteString(e.Type)
+	}
+	if e.Agent != "" {
+		fmt.Fprintf(&b, " on agent %s", e.Agent)
+	}
+	if e.Error != "" {
+		fmt.Fprintf(&b, ": %s", e.Error)
+	}
+	return b.String()
+}
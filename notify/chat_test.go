@@ -0,0 +1,137 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/google/go-cmp/cmp"
+)
+
+type chatReceiver struct {
+	sync.Mutex
+	msgs []map[string]string
+}
+
+func (rc *chatReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var msg map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	rc.Lock()
+	rc.msgs = append(rc.msgs, msg)
+	rc.Unlock()
+}
+
+func TestChat(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    config.ChatConfig
+		events []Event
+		want   []map[string]string
+	}{
+		{
+			name: "SlackDefaultSeverity",
+			cfg:  config.ChatConfig{Type: "slack"},
+			events: []Event{
+				{Type: EventCheckFinished, CheckID: "check1", Status: "FINISHED"},
+				{Type: EventCheckFailed, CheckID: "check2", Status: "FAILED"},
+			},
+			want: []map[string]string{
+				{"text": "[ERROR] check check2: check.failed (status FAILED) on agent host"},
+			},
+		},
+		{
+			name: "TeamsCritical",
+			cfg:  config.ChatConfig{Type: "teams", MinSeverity: "critical"},
+			events: []Event{
+				{Type: EventCheckFailed, CheckID: "check1", Status: "FAILED"},
+				{Type: EventAgentDegraded, Error: "pull error"},
+			},
+			want: []map[string]string{
+				{
+					"@type":      "MessageCard",
+					"@context":   "http://schema.org/extensions",
+					"themeColor": "8B0000",
+					"summary":    EventAgentDegraded,
+					"text":       "[CRITICAL] agent.degraded on agent host: pull error",
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := &chatReceiver{}
+			srv := httptest.NewServer(rc)
+			defer srv.Close()
+			tt.cfg.URL = srv.URL
+			c, err := NewChat(&log.NullLog{}, tt.cfg)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, e := range tt.events {
+				if !c.Accepts(e.Type) {
+					continue
+				}
+				e.Agent = "host"
+				if err := c.Send(e); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			if diff := cmp.Diff(tt.want, rc.msgs); diff != "" {
+				t.Errorf("want messages != got messages, diff: %s", diff)
+			}
+		})
+	}
+}
+
+type errBackend struct {
+	errs []error
+}
+
+func (b *errBackend) Run(ctx context.Context, params backend.RunParams) (<-chan backend.RunResult, error) {
+	err := b.errs[0]
+	b.errs = b.errs[1:]
+	return nil, err
+}
+
+type inMemSender struct {
+	events []string
+}
+
+func (s *inMemSender) Accepts(eventType string) bool {
+	return eventType != EventAgentShutdown
+}
+
+func (s *inMemSender) Send(e Event) error {
+	s.events = append(s.events, e.Type)
+	return nil
+}
+
+func TestBackendDegraded(t *testing.T) {
+	errPull := errors.New("pull error")
+	b := &errBackend{errs: []error{errPull, errPull, nil, errPull, errPull, errPull, errPull, nil}}
+	s := &inMemSender{}
+	n := NewNotifier(&log.NullLog{}, s)
+	nb := NewBackend(b, n, 2)
+	for range b.errs {
+		nb.Run(context.Background(), backend.RunParams{})
+	}
+	n.Close()
+	want := []string{EventAgentDegraded, EventAgentRecovered, EventAgentDegraded, EventAgentRecovered}
+	if diff := cmp.Diff(want, s.events); diff != "" {
+		t.Errorf("want events != got events, diff: %s", diff)
+	}
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+// Package notify sends notifications about the lifecycle of the checks and the
+// agent to external systems, so they can react to them without polling the
+// check state queues.
+package notify
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/log"
+)
+
+// Types of the events.
+const (
+	EventCheckStarted   = "check.started"
+	EventCheckFinished  = "check.finished"
+	EventCheckFailed    = "check.failed"
+	EventCheckTimeout   = "check.timeout"
+	EventCheckAborted   = "check.aborted"
+	EventAgentShutdown  = "agent.shutdown"
+	EventAgentDegraded  = "agent.degraded"
+	EventAgentRecovered = "agent.recovered"
+)
+
+// Severities of the events, from the lowest to the highest.
+const (
+	SeverityInfo = iota
+	SeverityWarning
+	SeverityError
+	SeverityCritical
+)
+
+// severityNames contains the names of the severities indexed by their value.
+var severityNames = []string{"info", "warning", "error", "critical"}
+
+// Severity returns the severity of the given type of event.
+func Severity(eventType string) int {
+	switch eventType {
+	case EventCheckFailed:
+		return SeverityError
+	case EventCheckTimeout, EventCheckAborted, EventAgentShutdown:
+		return SeverityWarning
+	case EventAgentDegraded:
+		return SeverityCritical
+	default:
+		return SeverityInfo
+	}
+}
+
+// ParseSeverity returns the severity with the given name: info, warning,
+// error or critical.
+func ParseSeverity(name string) (int, error) {
+	for s, n := range severityNames {
+		if strings.EqualFold(n, name) {
+			return s, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid severity %q", name)
+}
+
+const (
+	queueSize    = 256
+	closeTimeout = 30 * time.Second
+)
+
+// Event describes something that happened to a check or to the agent.
+type Event struct {
+	Type    string    `json:"type"`
+	Time    time.Time `json:"time"`
+	Agent   string    `json:"agent"`
+	CheckID string    `json:"check_id,omitempty"`
+	Status  string    `json:"status,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Sender delivers events to an external system.
+type Sender interface {
+	// Accepts returns true if the Sender is interested in the events of the
+	// given type.
+	Accepts(eventType string) bool
+	Send(e Event) error
+}
+
+// Notifier delivers the events to the registered senders asynchronously, so
+// the checks are not delayed by slow or unavailable receivers. When the queue
+// of pending events is full the new events are discarded.
+type Notifier struct {
+	senders []Sender
+	events  chan Event
+	agent   string
+	log     log.Logger
+	done    chan struct{}
+	once    sync.Once
+}
+
+// NewNotifier creates a Notifier that delivers the events to the given
+// senders.
+func NewNotifier(l log.Logger, senders ...Sender) *Notifier {
+	agent, _ := os.Hostname()
+	n := &Notifier{
+		senders: senders,
+		events:  make(chan Event, queueSize),
+		agent:   agent,
+		log:     l,
+		done:    make(chan struct{}),
+	}
+	go n.deliver()
+	return n
+}
+
+// Notify queues an event to be delivered. The time and the agent of the event
+// are set by the Notifier.
+func (n *Notifier) Notify(e Event) {
+	e.Time = time.Now()
+	e.Agent = n.agent
+	select {
+	case n.events <- e:
+	default:
+		n.log.Errorf("notifications queue full, discarding event %s for check %s", e.Type, e.CheckID)
+	}
+}
+
+// Close notifies the agent shutdown and waits, for a limited time, for the
+// pending events to be delivered. The Notifier can't be used after calling
+// it.
+func (n *Notifier) Close() {
+	n.once.Do(func() {
+		n.Notify(Event{Type: EventAgentShutdown})
+		close(n.events)
+		select {
+		case <-n.done:
+		case <-time.After(closeTimeout):
+			n.log.Errorf("timeout delivering the pending notifications")
+		}
+	})
+}
+
+func (n *Notifier) deliver() {
+	defer close(n.done)
+	for e := range n.events {
+		for _, s := range n.senders {
+			if !s.Accepts(e.Type) {
+				continue
+			}
+			if err := s.Send(e); err != nil {
+				n.log.Errorf("error sending notification %s for check %s: %+v", e.Type, e.CheckID, err)
+			}
+		}
+	}
+}
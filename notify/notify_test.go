@@ -0,0 +1,136 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package notify
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/stateupdater"
+	"github.com/google/go-cmp/cmp"
+)
+
+type inMemStateUpdater struct {
+	states []stateupdater.CheckState
+}
+
+func (u *inMemStateUpdater) UpdateState(s stateupdater.CheckState) error {
+	u.states = append(u.states, s)
+	return nil
+}
+
+func (u *inMemStateUpdater) CheckStatusTerminal(ID string) bool {
+	return false
+}
+
+func (u *inMemStateUpdater) DeleteCheckStatusTerminal(ID string) {}
+
+type received struct {
+	Type    string
+	CheckID string
+	Status  string
+	Signed  bool
+}
+
+type receiver struct {
+	sync.Mutex
+	secret []byte
+	events []received
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var e Event
+	if err := json.Unmarshal(body, &e); err != nil || r.Header.Get(EventHeader) != e.Type {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	rc.Lock()
+	defer rc.Unlock()
+	rc.events = append(rc.events, received{
+		Type:    e.Type,
+		CheckID: e.CheckID,
+		Status:  e.Status,
+		Signed:  r.Header.Get(SignatureHeader) == "sha256="+Sign(rc.secret, body),
+	})
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestUpdaterNotifications(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    config.WebhookConfig
+		states []stateupdater.CheckState
+		want   []received
+	}{
+		{
+			name: "SendsAllEventsSigned",
+			cfg:  config.WebhookConfig{Secret: "secret"},
+			states: []stateupdater.CheckState{
+				{ID: "check1", Status: strPtr(stateupdater.StatusRunning)},
+				{ID: "check1", Status: strPtr(stateupdater.StatusRunning)},
+				{ID: "check1", Status: strPtr(stateupdater.StatusFailed)},
+				{ID: "check2", Status: strPtr(stateupdater.StatusTimeout)},
+			},
+			want: []received{
+				{Type: EventCheckStarted, CheckID: "check1", Status: stateupdater.StatusRunning, Signed: true},
+				{Type: EventCheckFailed, CheckID: "check1", Status: stateupdater.StatusFailed, Signed: true},
+				{Type: EventCheckTimeout, CheckID: "check2", Status: stateupdater.StatusTimeout, Signed: true},
+				{Type: EventAgentShutdown, Signed: true},
+			},
+		},
+		{
+			name: "FiltersEvents",
+			cfg:  config.WebhookConfig{Events: []string{EventCheckFinished, EventCheckAborted}},
+			states: []stateupdater.CheckState{
+				{ID: "check1", Status: strPtr(stateupdater.StatusRunning)},
+				{ID: "check1", Status: strPtr(stateupdater.StatusFinished)},
+				{ID: "check2", Status: strPtr(stateupdater.StatusAborted)},
+			},
+			want: []received{
+				{Type: EventCheckFinished, CheckID: "check1", Status: stateupdater.StatusFinished},
+				{Type: EventCheckAborted, CheckID: "check2", Status: stateupdater.StatusAborted},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := &receiver{secret: []byte(tt.cfg.Secret)}
+			srv := httptest.NewServer(rc)
+			defer srv.Close()
+			tt.cfg.URL = srv.URL
+			n := NewNotifier(&log.NullLog{}, NewWebhook(&log.NullLog{}, tt.cfg))
+			inner := &inMemStateUpdater{}
+			u := NewUpdater(inner, n)
+			for _, s := range tt.states {
+				if err := u.UpdateState(s); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			n.Close()
+			if diff := cmp.Diff(tt.states, inner.states); diff != "" {
+				t.Errorf("states not passed to the decorated updater, diff: %s", diff)
+			}
+			rc.Lock()
+			defer rc.Unlock()
+			if diff := cmp.Diff(tt.want, rc.events); diff != "" {
+				t.Errorf("want events != got events, diff: %s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package notify
+
+import (
+	"sync"
+
+	"github.com/adevinta/vulcan-agent/stateupdater"
+)
+
+// StateUpdater defines the state updater decorated by an Updater.
+type StateUpdater interface {
+	UpdateState(stateupdater.CheckState) error
+	CheckStatusTerminal(ID string) bool
+	DeleteCheckStatusTerminal(ID string)
+}
+
+// Updater decorates a StateUpdater notifying the events corresponding to the
+// status updates of the checks: the first RUNNING status of a check
+// corresponds to the check.started event and each final status to the
+// check.finished, check.failed, check.timeout or check.aborted event.
+type Updater struct {
+	StateUpdater
+	notifier *Notifier
+	started  sync.Map
+}
+
+// NewUpdater returns an Updater that sends the events to the given notifier.
+func NewUpdater(u StateUpdater, n *Notifier) *Updater {
+	return &Updater{StateUpdater: u, notifier: n}
+}
+
+// UpdateState updates the state of the check using the decorated updater
+// and, if that succeeds, notifies the corresponding event, if any.
+func (u *Updater) UpdateState(s stateupdater.CheckState) error {
+	if err := u.StateUpdater.UpdateState(s); err != nil {
+		return err
+	}
+	if s.Status == nil {
+		return nil
+	}
+	status := *s.Status
+	if status == stateupdater.StatusRunning {
+		if _, loaded := u.started.LoadOrStore(s.ID, struct{}{}); !loaded {
+			u.notifier.Notify(Event{Type: EventCheckStarted, CheckID: s.ID, Status: status})
+		}
+		return nil
+	}
+	if e, ok := statusEvent(status); ok {
+		u.started.Delete(s.ID)
+		u.notifier.Notify(Event{Type: e, CheckID: s.ID, Status: status})
+	}
+	return nil
+}
+
+func statusEvent(status string) (string, bool) {
+	switch status {
+	case stateupdater.StatusFinished, stateupdater.StatusInconclusive:
+		return EventCheckFinished, true
+	case stateupdater.StatusFailed, stateupdater.StatusMalformed, stateupdater.StatusMissingVars:
+		return EventCheckFailed, true
+	case stateupdater.StatusTimeout:
+		return EventCheckTimeout, true
+	case stateupdater.StatusAborted, stateupdater.StatusKilled:
+		return EventCheckAborted, true
+	}
+	return "", false
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/retryer"
+)
+
+// Headers of the requests sent by a Webhook.
+const (
+	EventHeader     = "X-Vulcan-Event"
+	SignatureHeader = "X-Vulcan-Signature"
+)
+
+// Webhook posts the events, encoded in JSON, to a URL. When a secret is
+// configured the requests are signed with the HMAC-SHA256 of the body using
+// the secret as key, sent in the SignatureHeader as "sha256=<hex digest>".
+type Webhook struct {
+	url     string
+	secret  []byte
+	events  map[string]bool
+	client  *http.Client
+	retryer retryer.Retryer
+}
+
+// NewWebhook creates a Webhook from the given config.
+func NewWebhook(l log.Logger, cfg config.WebhookConfig) *Webhook {
+	var events map[string]bool
+	if len(cfg.Events) > 0 {
+		events = map[string]bool{}
+		for _, e := range cfg.Events {
+			events[e] = true
+		}
+	}
+	return &Webhook{
+		url:     cfg.URL,
+		secret:  []byte(cfg.Secret),
+		events:  events,
+		client:  &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second},
+		retryer: retryer.NewRetryer(cfg.Retries, cfg.RetryInterval, l),
+	}
+}
+
+// Accepts returns true if the event is one of the configured events or if no
+// events are configured.
+func (w *Webhook) Accepts(eventType string) bool {
+	return w.events == nil || w.events[eventType]
+}
+
+// Send posts the event to the URL of the webhook.
+func (w *Webhook) Send(e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{EventHeader: e.Type}
+	if len(w.secret) > 0 {
+		headers[SignatureHeader] = "sha256=" + Sign(w.secret, body)
+	}
+	return w.retryer.WithRetries("Webhook.Send", func() error {
+		return post(w.client, w.url, body, headers)
+	})
+}
+
+// post sends the body, encoded in JSON, to the given URL. The errors that
+// should not be retried are wrapped with retryer.ErrPermanent.
+func post(client *http.Client, url string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%v, %w", err, retryer.ErrPermanent)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return nil
+	case res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("invalid response status: %s", res.Status)
+	default:
+		return fmt.Errorf("invalid response status: %s, %w", res.Status, retryer.ErrPermanent)
+	}
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of the body using the given
+// secret.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,266 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package oneshot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/api"
+	httpapi "github.com/adevinta/vulcan-agent/api/http"
+	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/results/sarif"
+	"github.com/adevinta/vulcan-agent/stateupdater"
+	report "github.com/adevinta/vulcan-report"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Exit codes returned by Run depending on the final status of the check.
+const (
+	ExitFinished     = 0
+	ExitError        = 1
+	ExitFailed       = 2
+	ExitTimeout      = 3
+	ExitAborted      = 4
+	ExitInconclusive = 5
+	ExitMalformed    = 6
+	ExitMissingVars  = 8
+)
+
+// DefaultTimeout is the timeout, in seconds, used when no timeout is
+// specified in the Params.
+const DefaultTimeout = 600
+
+// Params defines the check to run.
+type Params struct {
+	Image        string
+	Target       string
+	AssetType    string
+	Options      string
+	RequiredVars []string
+	Timeout      int
+	// FailOn, if not empty, is the min severity of the vulnerabilities that
+	// makes Run return FindingsExitCode, or ExitFindings if it's 0, when the
+	// check finishes. See ParseSeverity.
+	FailOn           string
+	FindingsExitCode int
+	// Summary, if not nil, is where the table with the vulnerabilities found
+	// by the check is written.
+	Summary io.Writer
+	// SARIF, if not nil, is where the report of the check is written in
+	// SARIF format.
+	SARIF io.Writer
+}
+
+// Result contains the outcome of running a check.
+type Result struct {
+	CheckID string         `json:"check_id"`
+	Status  string         `json:"status"`
+	Report  *report.Report `json:"report,omitempty"`
+}
+
+// collector implements the services needed by the agent API storing in memory
+// the state and the report sent by the check.
+type collector struct {
+	sync.Mutex
+	status string
+	report *report.Report
+}
+
+func (c *collector) UpdateState(s stateupdater.CheckState) error {
+	c.Lock()
+	defer c.Unlock()
+	if s.Status != nil {
+		c.status = *s.Status
+	}
+	return nil
+}
+
+func (c *collector) UpdateCheckReport(checkID string, startTime time.Time, r report.Report) (string, error) {
+	c.Lock()
+	defer c.Unlock()
+	c.report = &r
+	return "", nil
+}
+
+func (c *collector) ChecksRunning() int {
+	return 1
+}
+
+func (c *collector) LastMessageReceived() *time.Time {
+	return nil
+}
+
+// Run executes a single check using the given backend, writes the result to
+// the given writer and returns an exit code reflecting the status of the
+// check. The agent API is served, while the check is running, in the address
+// defined in the config so the check can send its state and report.
+func Run(ctx context.Context, cfg config.Config, b backend.Backend, l log.Logger, p Params, out io.Writer) int {
+	var failOn report.SeverityRank
+	if p.FailOn != "" {
+		var err error
+		if failOn, err = ParseSeverity(p.FailOn); err != nil {
+			l.Errorf("%+v", err)
+			return ExitError
+		}
+	}
+	res, err := run(ctx, cfg, b, l, p)
+	if err != nil {
+		l.Errorf("error running check: %+v", err)
+		return ExitError
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(res); err != nil {
+		l.Errorf("error writing check result: %+v", err)
+		return ExitError
+	}
+	if p.Summary != nil && res.Report != nil {
+		if err := WriteSummary(p.Summary, res.Report); err != nil {
+			l.Errorf("error writing findings summary: %+v", err)
+		}
+	}
+	if p.SARIF != nil && res.Report != nil {
+		if err := writeSARIF(p.SARIF, *res.Report); err != nil {
+			l.Errorf("error writing SARIF report: %+v", err)
+			return ExitError
+		}
+	}
+	code := ExitCode(res.Status)
+	if code != ExitFinished || p.FailOn == "" {
+		return code
+	}
+	if max, ok := maxSeverity(res.Report); ok && max >= failOn {
+		if p.FindingsExitCode != 0 {
+			return p.FindingsExitCode
+		}
+		return ExitFindings
+	}
+	return code
+}
+
+func run(ctx context.Context, cfg config.Config, b backend.Backend, l log.Logger, p Params) (Result, error) {
+	ctName, ctVersion, err := backend.ChecktypeInfo(p.Image)
+	if err != nil {
+		return Result{}, err
+	}
+	c := &collector{}
+	router := httprouter.New()
+	httpapi.NewREST(l, api.New(l, c, c), router)
+	srv := http.Server{
+		Addr:    cfg.API.Port,
+		Handler: router,
+	}
+	// Listen before running the check, so the API is ready when the check
+	// sends its state.
+	ln, err := net.Listen("tcp", cfg.API.Port)
+	if err != nil {
+		return Result{}, fmt.Errorf("error starting the agent api: %w", err)
+	}
+	httpDone := make(chan error, 1)
+	go func() {
+		httpDone <- srv.Serve(ln)
+	}()
+	defer func() {
+		srv.Shutdown(context.Background())
+		<-httpDone
+	}()
+
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+	checkID := uuid.NewString()
+	finished, err := b.Run(ctx, backend.RunParams{
+		CheckID:          checkID,
+		CheckTypeName:    ctName,
+		ChecktypeVersion: ctVersion,
+		Image:            p.Image,
+		Target:           p.Target,
+		AssetType:        p.AssetType,
+		Options:          p.Options,
+		RequiredVars:     p.RequiredVars,
+	})
+	if errors.Is(err, backend.ErrMissingVars) {
+		l.Errorf("check not run: %v", err)
+		return Result{CheckID: checkID, Status: stateupdater.StatusMissingVars}, nil
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	var runRes backend.RunResult
+	select {
+	case runRes = <-finished:
+	case err := <-httpDone:
+		cancel()
+		<-finished
+		return Result{}, fmt.Errorf("agent api stopped: %w", err)
+	}
+	if len(runRes.Output) > 0 {
+		l.Debugf("check output:\n%s", runRes.Output)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	status := c.status
+	execErr := runRes.Error
+	switch {
+	case errors.Is(execErr, context.DeadlineExceeded):
+		status = stateupdater.StatusTimeout
+	case errors.Is(execErr, context.Canceled):
+		status = stateupdater.StatusAborted
+	case errors.Is(execErr, backend.ErrNonZeroExitCode):
+		status = stateupdater.StatusFailed
+	case execErr != nil:
+		return Result{}, execErr
+	}
+	if _, ok := stateupdater.TerminalStatuses[status]; !ok && status != stateupdater.StatusAborted {
+		status = stateupdater.StatusFailed
+	}
+	return Result{CheckID: checkID, Status: status, Report: c.report}, nil
+}
+
+func writeSARIF(w io.Writer, r report.Report) error {
+	data, err := sarif.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// ExitCode returns the exit code corresponding to the given check status.
+func ExitCode(status string) int {
+	switch status {
+	case stateupdater.StatusFinished:
+		return ExitFinished
+	case stateupdater.StatusFailed:
+		return ExitFailed
+	case stateupdater.StatusTimeout:
+		return ExitTimeout
+	case stateupdater.StatusAborted, stateupdater.StatusKilled:
+		return ExitAborted
+	case stateupdater.StatusInconclusive:
+		return ExitInconclusive
+	case stateupdater.StatusMalformed:
+		return ExitMalformed
+	case stateupdater.StatusMissingVars:
+		return ExitMissingVars
+	default:
+		return ExitError
+	}
+}
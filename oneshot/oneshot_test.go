@@ -0,0 +1,186 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package oneshot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/stateupdater"
+	"github.com/adevinta/vulcan-agent/testutil"
+	report "github.com/adevinta/vulcan-report"
+)
+
+// backendFunc is a backend that runs the checks calling a function.
+type backendFunc func(ctx context.Context, params backend.RunParams) (<-chan backend.RunResult, error)
+
+func (f backendFunc) Run(ctx context.Context, params backend.RunParams) (<-chan backend.RunResult, error) {
+	return f(ctx, params)
+}
+
+// freeAddr returns a local address with a port that is not in use.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name string
+		// check is run by a testutil.Backend, unless backend is defined.
+		check      testutil.CheckFunc
+		backend    backend.Backend
+		params     Params
+		wantCode   int
+		wantStatus string
+	}{
+		{
+			name:       "Finished",
+			wantCode:   ExitFinished,
+			wantStatus: stateupdater.StatusFinished,
+		},
+		{
+			name: "Inconclusive",
+			check: func(ctx context.Context, params backend.RunParams) (report.Report, error) {
+				var r report.Report
+				r.Status = stateupdater.StatusInconclusive
+				return r, nil
+			},
+			wantCode:   ExitInconclusive,
+			wantStatus: stateupdater.StatusInconclusive,
+		},
+		{
+			name: "Failed",
+			check: func(ctx context.Context, params backend.RunParams) (report.Report, error) {
+				return report.Report{}, errors.New("scanner crashed")
+			},
+			wantCode:   ExitFailed,
+			wantStatus: stateupdater.StatusFailed,
+		},
+		{
+			name: "Findings",
+			check: func(ctx context.Context, params backend.RunParams) (report.Report, error) {
+				var r report.Report
+				r.Vulnerabilities = []report.Vulnerability{{Summary: "Open port", Score: 7.5}}
+				return r, nil
+			},
+			params:     Params{FailOn: "high"},
+			wantCode:   ExitFindings,
+			wantStatus: stateupdater.StatusFinished,
+		},
+		{
+			name: "MissingVars",
+			backend: backendFunc(func(ctx context.Context, params backend.RunParams) (<-chan backend.RunResult, error) {
+				return nil, fmt.Errorf("%w: GITHUB_TOKEN", backend.ErrMissingVars)
+			}),
+			params:     Params{RequiredVars: []string{"GITHUB_TOKEN"}},
+			wantCode:   ExitMissingVars,
+			wantStatus: stateupdater.StatusMissingVars,
+		},
+		{
+			name:     "InvalidImage",
+			params:   Params{Image: "Invalid Image:1"},
+			wantCode: ExitError,
+		},
+		{
+			name: "UnknownCheck",
+			backend: backendFunc(func(ctx context.Context, params backend.RunParams) (<-chan backend.RunResult, error) {
+				return nil, errors.New("image not found")
+			}),
+			wantCode: ExitError,
+		},
+		{
+			name:     "InvalidFailOn",
+			params:   Params{FailOn: "severe"},
+			wantCode: ExitError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := freeAddr(t)
+			var b backend.Backend = testutil.NewBackend("http://"+addr, tt.check)
+			if tt.backend != nil {
+				b = tt.backend
+			}
+			cfg := config.Config{API: config.APIConfig{Port: addr}}
+			p := tt.params
+			if p.Image == "" {
+				p.Image = "vulcan-nmap:1"
+			}
+			p.Target = "example.com"
+			var out bytes.Buffer
+			code := Run(context.Background(), cfg, b, &log.NullLog{}, p, &out)
+			if code != tt.wantCode {
+				t.Errorf("want exit code %d, got %d", tt.wantCode, code)
+			}
+			if tt.wantStatus == "" {
+				if out.Len() > 0 {
+					t.Errorf("unexpected result written: %s", out.String())
+				}
+				return
+			}
+			var res Result
+			if err := json.Unmarshal(out.Bytes(), &res); err != nil {
+				t.Fatalf("invalid result %q: %v", out.String(), err)
+			}
+			if res.Status != tt.wantStatus || res.CheckID == "" {
+				t.Errorf("want result with status %s, got %+v", tt.wantStatus, res)
+			}
+		})
+	}
+}
+
+func TestRun_Timeout(t *testing.T) {
+	addr := freeAddr(t)
+	stopped := make(chan struct{})
+	b := testutil.NewBackend("http://"+addr, func(ctx context.Context, params backend.RunParams) (report.Report, error) {
+		<-ctx.Done()
+		close(stopped)
+		return report.Report{}, ctx.Err()
+	})
+	cfg := config.Config{API: config.APIConfig{Port: addr}}
+	p := Params{Image: "vulcan-nmap:1", Target: "example.com", Timeout: 1}
+	var out bytes.Buffer
+	start := time.Now()
+	code := Run(context.Background(), cfg, b, &log.NullLog{}, p, &out)
+	if code != ExitTimeout {
+		t.Errorf("want exit code %d, got %d", ExitTimeout, code)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("check not stopped at its timeout, run %s", d)
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Errorf("check not stopped")
+	}
+	// The API is stopped, so its address can be used again.
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("agent API not stopped: %v", err)
+	}
+	l.Close()
+	var res Result
+	if err := json.Unmarshal(out.Bytes(), &res); err != nil {
+		t.Fatalf("invalid result %q: %v", out.String(), err)
+	}
+	if res.Status != stateupdater.StatusTimeout {
+		t.Errorf("want status %s, got %s", stateupdater.StatusTimeout, res.Status)
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package oneshot
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	report "github.com/adevinta/vulcan-report"
+)
+
+// ExitFindings is the exit code returned by Run, when no other is specified
+// in the Params, if the check finished and found vulnerabilities with a
+// severity equal or higher than Params.FailOn.
+const ExitFindings = 7
+
+// severities contains the names of the severities, in increasing order.
+var severities = []string{"none", "low", "medium", "high", "critical"}
+
+// ParseSeverity returns the severity with the given name: none, low, medium,
+// high or critical.
+func ParseSeverity(name string) (report.SeverityRank, error) {
+	for i, s := range severities {
+		if strings.EqualFold(name, s) {
+			return report.SeverityRank(i), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid severity %q, must be one of: %s", name, strings.Join(severities, ", "))
+}
+
+func severityName(s report.SeverityRank) string {
+	if int(s) < 0 || int(s) >= len(severities) {
+		return "unknown"
+	}
+	return strings.ToUpper(severities[s])
+}
+
+// findings returns the vulnerabilities of the report, including the children
+// of the vulnerabilities, sorted by score in decreasing order.
+func findings(r *report.Report) []report.Vulnerability {
+	var vulns []report.Vulnerability
+	var add func(vs []report.Vulnerability)
+	add = func(vs []report.Vulnerability) {
+		for _, v := range vs {
+			vulns = append(vulns, v)
+			add(v.Vulnerabilities)
+		}
+	}
+	if r != nil {
+		add(r.Vulnerabilities)
+	}
+	sort.SliceStable(vulns, func(i, j int) bool {
+		return vulns[i].Score > vulns[j].Score
+	})
+	return vulns
+}
+
+// maxSeverity returns the max severity of the vulnerabilities of the report
+// and false if the report has no vulnerabilities.
+func maxSeverity(r *report.Report) (report.SeverityRank, bool) {
+	vulns := findings(r)
+	if len(vulns) == 0 {
+		return 0, false
+	}
+	return vulns[0].Severity(), true
+}
+
+// WriteSummary writes to w a table with the vulnerabilities found by a check,
+// sorted by score, followed by the number of vulnerabilities and the max
+// severity found.
+func WriteSummary(w io.Writer, r *report.Report) error {
+	vulns := findings(r)
+	if len(vulns) == 0 {
+		_, err := fmt.Fprintln(w, "No findings")
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SEVERITY\tSCORE\tSUMMARY\tAFFECTED RESOURCE")
+	for _, v := range vulns {
+		fmt.Fprintf(tw, "%s\t%.1f\t%s\t%s\n", severityName(v.Severity()), v.Score, v.Summary, v.AffectedResource)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\n%d findings, max severity %s\n", len(vulns), severityName(vulns[0].Severity()))
+	return err
+}
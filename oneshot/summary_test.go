@@ -0,0 +1,91 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package oneshot
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	report "github.com/adevinta/vulcan-report"
+)
+
+func TestParseSeverity(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    report.SeverityRank
+		wantErr bool
+	}{
+		{name: "none", want: report.SeverityNone},
+		{name: "HIGH", want: report.SeverityHigh},
+		{name: "critical", want: report.SeverityCritical},
+		{name: "severe", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSeverity(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxSeverity(t *testing.T) {
+	tests := []struct {
+		name   string
+		report *report.Report
+		want   report.SeverityRank
+		wantOK bool
+	}{
+		{
+			name:   "NoFindings",
+			report: &report.Report{},
+		},
+		{
+			name: "IncludesChildren",
+			report: &report.Report{ResultData: report.ResultData{Vulnerabilities: []report.Vulnerability{
+				{Summary: "low", Score: 2, Vulnerabilities: []report.Vulnerability{
+					{Summary: "critical", Score: 9.5},
+				}},
+				{Summary: "medium", Score: 5},
+			}}},
+			want:   report.SeverityCritical,
+			wantOK: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := maxSeverity(tt.report)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("got (%v, %v), want (%v, %v)", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestWriteSummary(t *testing.T) {
+	r := &report.Report{ResultData: report.ResultData{Vulnerabilities: []report.Vulnerability{
+		{Summary: "Outdated TLS", Score: 3.9, AffectedResource: "example.com:443"},
+		{Summary: "Exposed admin", Score: 8.9, AffectedResource: "http://example.com/admin"},
+	}}}
+	var buf bytes.Buffer
+	if err := WriteSummary(&buf, r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("unexpected summary:\n%s", buf.String())
+	}
+	if !strings.HasPrefix(lines[1], "HIGH") || !strings.HasPrefix(lines[2], "LOW") {
+		t.Errorf("findings not sorted by score:\n%s", buf.String())
+	}
+	if want := "2 findings, max severity HIGH"; lines[4] != want {
+		t.Errorf("got footer %q, want %q", lines[4], want)
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+// Package postprocess implements the pipeline of processors applied to the
+// reports of the checks before uploading them.
+package postprocess
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/jobrunner"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/results"
+	report "github.com/adevinta/vulcan-report"
+)
+
+// Names of the processors built into the agent.
+const (
+	SeverityOverrides = "severity_overrides"
+	Dedup             = "dedup"
+	NormalizeTarget   = "normalize_target"
+	MetadataTags      = "metadata_tags"
+)
+
+// Check contains the information of the check that generated a report.
+type Check struct {
+	ID       string
+	Metadata map[string]string
+}
+
+// Processor modifies the report of a check before uploading it.
+type Processor interface {
+	Process(r *report.Report, c Check) error
+}
+
+// ProcessorFunc allows to use an ordinary function as a Processor.
+type ProcessorFunc func(r *report.Report, c Check) error
+
+// Process calls f(r, c).
+func (f ProcessorFunc) Process(r *report.Report, c Check) error {
+	return f(r, c)
+}
+
+// CheckLister returns the information of the checks running in the agent.
+type CheckLister interface {
+	RunningCheck(ID string) (jobrunner.RunningCheck, bool)
+}
+
+var (
+	registeredMu sync.RWMutex
+	registered   = make(map[string]Processor)
+)
+
+// Register makes a processor implemented by a program embedding the agent
+// available by the provided name, so it can be used in the
+// postprocessing.processors config param. If Register is called twice with
+// the same name, or with the name of a built-in processor, or if the
+// processor is nil, it panics.
+func Register(name string, p Processor) {
+	registeredMu.Lock()
+	defer registeredMu.Unlock()
+	if p == nil {
+		panic("postprocess: register processor is nil")
+	}
+	switch name {
+	case SeverityOverrides, Dedup, NormalizeTarget, MetadataTags:
+		panic("postprocess: register called for built-in processor " + name)
+	}
+	if _, dup := registered[name]; dup {
+		panic("postprocess: register called twice for processor " + name)
+	}
+	registered[name] = p
+}
+
+// FromConfig returns the processors defined in the given config, in order. It
+// returns an error if any of the processors is unknown.
+func FromConfig(cfg config.PostProcessingConfig) ([]Processor, error) {
+	var procs []Processor
+	for _, name := range cfg.Processors {
+		switch name {
+		case SeverityOverrides:
+			procs = append(procs, overrideSeverities(cfg.SeverityOverrides))
+		case Dedup:
+			procs = append(procs, ProcessorFunc(dedup))
+		case NormalizeTarget:
+			procs = append(procs, ProcessorFunc(normalizeTarget))
+		case MetadataTags:
+			procs = append(procs, metadataTags(cfg.MetadataTags))
+		default:
+			registeredMu.RLock()
+			p, ok := registered[name]
+			registeredMu.RUnlock()
+			if !ok {
+				return nil, fmt.Errorf("unknown report processor %q", name)
+			}
+			procs = append(procs, p)
+		}
+	}
+	return procs, nil
+}
+
+// Sink decorates a results.Sink applying the processors to the reports
+// before storing them. The reports are stored unmodified if a processor
+// fails.
+type Sink struct {
+	results.Sink
+	processors []Processor
+	log        log.Logger
+	// Checks, if not nil, is used to get the metadata of the checks passed
+	// to the processors.
+	Checks CheckLister
+}
+
+// NewSink returns a Sink that applies the given processors, in order.
+func NewSink(l log.Logger, s results.Sink, processors ...Processor) *Sink {
+	return &Sink{Sink: s, processors: processors, log: l}
+}
+
+// UpdateCheckReport applies the processors to the report and stores it in
+// the decorated sink.
+func (s *Sink) UpdateCheckReport(checkID string, scanStartTime time.Time, r report.Report) (string, error) {
+	c := Check{ID: checkID}
+	if s.Checks != nil {
+		if rc, ok := s.Checks.RunningCheck(checkID); ok {
+			c.Metadata = rc.Metadata
+		}
+	}
+	processed := copyReport(r)
+	for _, p := range s.processors {
+		if err := p.Process(&processed, c); err != nil {
+			s.log.Errorf("error processing report of check %s, storing it unmodified: %+v", checkID, err)
+			return s.Sink.UpdateCheckReport(checkID, scanStartTime, r)
+		}
+	}
+	return s.Sink.UpdateCheckReport(checkID, scanStartTime, processed)
+}
+
+// copyReport returns a copy of the report whose vulnerabilities can be
+// modified without modifying the original ones.
+func copyReport(r report.Report) report.Report {
+	r.Vulnerabilities = copyVulns(r.Vulnerabilities)
+	return r
+}
+
+func copyVulns(vulns []report.Vulnerability) []report.Vulnerability {
+	if vulns == nil {
+		return nil
+	}
+	c := make([]report.Vulnerability, len(vulns))
+	for i, v := range vulns {
+		v.Labels = append([]string(nil), v.Labels...)
+		v.Vulnerabilities = copyVulns(v.Vulnerabilities)
+		c[i] = v
+	}
+	return c
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package postprocess
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/jobrunner"
+	"github.com/adevinta/vulcan-agent/log"
+	report "github.com/adevinta/vulcan-report"
+	"github.com/google/go-cmp/cmp"
+)
+
+type sinkMock struct {
+	reports []report.Report
+}
+
+func (s *sinkMock) UpdateCheckReport(checkID string, scanStartTime time.Time, r report.Report) (string, error) {
+	s.reports = append(s.reports, r)
+	return "link", nil
+}
+
+func (s *sinkMock) UpdateCheckRaw(checkID string, scanStartTime time.Time, raw []byte) (string, error) {
+	return "link", nil
+}
+
+type listerMock map[string]jobrunner.RunningCheck
+
+func (l listerMock) RunningCheck(ID string) (jobrunner.RunningCheck, bool) {
+	c, ok := l[ID]
+	return c, ok
+}
+
+func TestSinkUpdateCheckReport(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.PostProcessingConfig
+		report  report.Report
+		want    report.Report
+		wantErr bool
+	}{
+		{
+			name: "AppliesTheProcessorsInOrder",
+			cfg: config.PostProcessingConfig{
+				Processors:        []string{"normalize_target", "dedup", "severity_overrides", "metadata_tags"},
+				SeverityOverrides: map[string]float32{"Weak TLS": 0, "Child": 9},
+				MetadataTags:      []string{"team", "missing"},
+			},
+			report: report.Report{
+				CheckData: report.CheckData{CheckID: "check1", Target: " Example.COM. "},
+				ResultData: report.ResultData{Vulnerabilities: []report.Vulnerability{
+					{Summary: "Weak TLS", AffectedResource: "443/tcp", Score: 5},
+					{Summary: "Weak TLS", AffectedResource: "443/tcp", Score: 5},
+					{Summary: "Parent", Labels: []string{"team:security"}, Vulnerabilities: []report.Vulnerability{
+						{Summary: "Child", Score: 1},
+					}},
+				}},
+			},
+			want: report.Report{
+				CheckData: report.CheckData{CheckID: "check1", Target: "example.com"},
+				ResultData: report.ResultData{Vulnerabilities: []report.Vulnerability{
+					{Summary: "Weak TLS", AffectedResource: "443/tcp", Score: 0, Labels: []string{"team:security"}},
+					{Summary: "Parent", Score: 9, Labels: []string{"team:security"}, Vulnerabilities: []report.Vulnerability{
+						{Summary: "Child", Score: 9},
+					}},
+				}},
+			},
+		},
+		{
+			name: "NormalizesURLs",
+			cfg:  config.PostProcessingConfig{Processors: []string{"normalize_target"}},
+			report: report.Report{
+				CheckData: report.CheckData{CheckID: "check1", Target: "HTTPS://Example.com/Path"},
+			},
+			want: report.Report{
+				CheckData: report.CheckData{CheckID: "check1", Target: "https://example.com/Path"},
+			},
+		},
+		{
+			name: "UsesTheRegisteredProcessors",
+			cfg:  config.PostProcessingConfig{Processors: []string{"uppercase_target"}},
+			report: report.Report{
+				CheckData: report.CheckData{CheckID: "check1", Target: "example.com"},
+			},
+			want: report.Report{
+				CheckData: report.CheckData{CheckID: "check1", Target: "EXAMPLE.COM"},
+			},
+		},
+		{
+			name:    "UnknownProcessor",
+			cfg:     config.PostProcessingConfig{Processors: []string{"unknown"}},
+			wantErr: true,
+		},
+	}
+	Register("uppercase_target", ProcessorFunc(func(r *report.Report, c Check) error {
+		r.Target = strings.ToUpper(r.Target)
+		return nil
+	}))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			procs, err := FromConfig(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("want error %v, got %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			sink := &sinkMock{}
+			s := NewSink(&log.NullLog{}, sink, procs...)
+			s.Checks = listerMock{"check1": {CheckID: "check1", Metadata: map[string]string{"team": "security"}}}
+			if _, err := s.UpdateCheckReport("check1", time.Time{}, tt.report); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff([]report.Report{tt.want}, sink.reports); diff != "" {
+				t.Errorf("report mismatch (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestSinkUpdateCheckReportError(t *testing.T) {
+	sink := &sinkMock{}
+	fail := ProcessorFunc(func(r *report.Report, c Check) error {
+		r.Target = "modified"
+		return errors.New("processor error")
+	})
+	s := NewSink(&log.NullLog{}, sink, fail)
+	r := report.Report{CheckData: report.CheckData{CheckID: "check1", Target: "example.com"}}
+	if _, err := s.UpdateCheckReport("check1", time.Time{}, r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]report.Report{r}, sink.reports); diff != "" {
+		t.Errorf("report not stored unmodified (-want +got):\n%v", diff)
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package postprocess
+
+import (
+	"net/url"
+	"strings"
+
+	report "github.com/adevinta/vulcan-report"
+)
+
+// overrideSeverities returns a processor that sets the score of the
+// vulnerabilities with the given summaries. The score of the parent
+// vulnerabilities is recalculated from their children.
+func overrideSeverities(scores map[string]float32) Processor {
+	return ProcessorFunc(func(r *report.Report, c Check) error {
+		r.Vulnerabilities = overrideVulns(r.Vulnerabilities, scores)
+		return nil
+	})
+}
+
+func overrideVulns(vulns []report.Vulnerability, scores map[string]float32) []report.Vulnerability {
+	for i := range vulns {
+		v := &vulns[i]
+		if len(v.Vulnerabilities) > 0 {
+			v.Vulnerabilities = overrideVulns(v.Vulnerabilities, scores)
+			v.AggregateScore()
+		}
+		if score, ok := scores[v.Summary]; ok {
+			v.Score = score
+		}
+	}
+	return vulns
+}
+
+// dedup removes the vulnerabilities with the same summary, affected resource
+// and fingerprint than a previous one.
+func dedup(r *report.Report, c Check) error {
+	type key struct {
+		summary, resource, fingerprint string
+	}
+	seen := make(map[key]bool)
+	vulns := r.Vulnerabilities[:0]
+	for _, v := range r.Vulnerabilities {
+		k := key{v.Summary, v.AffectedResource, v.Fingerprint}
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		vulns = append(vulns, v)
+	}
+	r.Vulnerabilities = vulns
+	return nil
+}
+
+// normalizeTarget lowercases the target of the report, and removes the
+// surrounding spaces and the trailing dot of the hostnames. Only the scheme
+// and the host of the URLs are lowercased.
+func normalizeTarget(r *report.Report, c Check) error {
+	target := strings.TrimSpace(r.Target)
+	if u, err := url.Parse(target); err == nil && u.Scheme != "" && u.Host != "" {
+		u.Scheme = strings.ToLower(u.Scheme)
+		u.Host = strings.TrimSuffix(strings.ToLower(u.Host), ".")
+		r.Target = u.String()
+		return nil
+	}
+	r.Target = strings.TrimSuffix(strings.ToLower(target), ".")
+	return nil
+}
+
+// metadataTags returns a processor that adds to the vulnerabilities the given
+// metadata keys of the check as labels in the format key:value.
+func metadataTags(keys []string) Processor {
+	return ProcessorFunc(func(r *report.Report, c Check) error {
+		var tags []string
+		for _, k := range keys {
+			if v, ok := c.Metadata[k]; ok {
+				tags = append(tags, k+":"+v)
+			}
+		}
+		for i := range r.Vulnerabilities {
+			r.Vulnerabilities[i].Labels = addLabels(r.Vulnerabilities[i].Labels, tags)
+		}
+		return nil
+	})
+}
+
+// addLabels adds the labels that are not already present.
+func addLabels(labels, add []string) []string {
+	for _, a := range add {
+		found := false
+		for _, l := range labels {
+			if l == a {
+				found = true
+				break
+			}
+		}
+		if !found {
+			labels = append(labels, a)
+		}
+	}
+	return labels
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package eventbridge
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/adevinta/vulcan-agent/awscreds"
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/eventbridge/eventbridgeiface"
+)
+
+// Default values of the events sent by a Writer.
+const (
+	DefaultSource     = config.DefaultEventBridgeSource
+	DefaultDetailType = config.DefaultEventBridgeDetailType
+)
+
+// Writer puts messages as events in an AWS EventBridge event bus, the body of
+// the messages is the detail of the events.
+type Writer struct {
+	eb         eventbridgeiface.EventBridgeAPI
+	bus        string
+	source     string
+	detailType string
+}
+
+// NewWriter creates a new EventBridge writer from the given config.
+func NewWriter(cfg config.EventBridgeWriter) (*Writer, error) {
+	sess, err := awscreds.NewSession(config.AWSCredentialsConfig{})
+	if err != nil {
+		return nil, err
+	}
+	awsCfg := aws.NewConfig()
+	region := cfg.Region
+	if strings.HasPrefix(cfg.EventBus, "arn:") {
+		arn, err := arn.Parse(cfg.EventBus)
+		if err != nil {
+			err = fmt.Errorf("error parsing event bus ARN: %w", err)
+			return nil, err
+		}
+		if region == "" {
+			region = arn.Region
+		}
+	}
+	if region != "" {
+		awsCfg = awsCfg.WithRegion(region)
+	}
+	if cfg.Endpoint != "" {
+		awsCfg = awsCfg.WithEndpoint(cfg.Endpoint)
+	}
+	w := &Writer{
+		eb:         eventbridge.New(sess, awsCfg),
+		bus:        cfg.EventBus,
+		source:     cfg.Source,
+		detailType: cfg.DetailType,
+	}
+	if w.source == "" {
+		w.source = DefaultSource
+	}
+	if w.detailType == "" {
+		w.detailType = DefaultDetailType
+	}
+	return w, nil
+}
+
+// Write puts an event with the given body as detail in the event bus.
+func (w *Writer) Write(body string) error {
+	entry := &eventbridge.PutEventsRequestEntry{
+		Source:     &w.source,
+		DetailType: &w.detailType,
+		Detail:     &body,
+	}
+	if w.bus != "" {
+		entry.EventBusName = &w.bus
+	}
+	out, err := w.eb.PutEvents(&eventbridge.PutEventsInput{
+		Entries: []*eventbridge.PutEventsRequestEntry{entry},
+	})
+	if err != nil {
+		return err
+	}
+	if aws.Int64Value(out.FailedEntryCount) > 0 && len(out.Entries) > 0 {
+		e := out.Entries[0]
+		return fmt.Errorf("error putting event: %s %s", aws.StringValue(e.ErrorCode), aws.StringValue(e.ErrorMessage))
+	}
+	return nil
+}
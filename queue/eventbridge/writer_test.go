@@ -0,0 +1,84 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package eventbridge
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/eventbridge/eventbridgeiface"
+	"github.com/google/go-cmp/cmp"
+)
+
+type inMemEventBridge struct {
+	eventbridgeiface.EventBridgeAPI
+	fail    bool
+	entries []*eventbridge.PutEventsRequestEntry
+}
+
+func (m *inMemEventBridge) PutEvents(in *eventbridge.PutEventsInput) (*eventbridge.PutEventsOutput, error) {
+	if m.fail {
+		return &eventbridge.PutEventsOutput{
+			FailedEntryCount: aws.Int64(1),
+			Entries: []*eventbridge.PutEventsResultEntry{
+				{ErrorCode: aws.String("InternalFailure"), ErrorMessage: aws.String("failure")},
+			},
+		}, nil
+	}
+	m.entries = append(m.entries, in.Entries...)
+	return &eventbridge.PutEventsOutput{FailedEntryCount: aws.Int64(0)}, nil
+}
+
+func TestWriter_Write(t *testing.T) {
+	tests := []struct {
+		name    string
+		bus     string
+		fail    bool
+		want    []*eventbridge.PutEventsRequestEntry
+		wantErr bool
+	}{
+		{
+			name: "PutsEventInBus",
+			bus:  "vulcan",
+			want: []*eventbridge.PutEventsRequestEntry{
+				{
+					EventBusName: aws.String("vulcan"),
+					Source:       aws.String(DefaultSource),
+					DetailType:   aws.String(DefaultDetailType),
+					Detail:       aws.String(`{"id":"check1"}`),
+				},
+			},
+		},
+		{
+			name: "PutsEventInDefaultBus",
+			want: []*eventbridge.PutEventsRequestEntry{
+				{
+					Source:     aws.String(DefaultSource),
+					DetailType: aws.String(DefaultDetailType),
+					Detail:     aws.String(`{"id":"check1"}`),
+				},
+			},
+		},
+		{
+			name:    "ReturnsFailedEntries",
+			fail:    true,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eb := &inMemEventBridge{fail: tt.fail}
+			w := &Writer{eb: eb, bus: tt.bus, source: DefaultSource, detailType: DefaultDetailType}
+			err := w.Write(`{"id":"check1"}`)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("want error %v, got %v", tt.wantErr, err)
+			}
+			if diff := cmp.Diff(tt.want, eb.entries); diff != "" {
+				t.Errorf("want entries != got entries, diff: %s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package queue
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// IdleLimit defines when a queue reader must stop reading because no
+// messages were read for too long. The max time without reading messages can
+// be changed while the readers are running. A nil *IdleLimit never stops the
+// readers.
+type IdleLimit struct {
+	max       int64
+	notBefore time.Time
+}
+
+// NewIdleLimit returns an IdleLimit with the given max time without reading
+// messages, 0 meaning no limit, that is not exceeded until minLifetime has
+// passed since it was created.
+func NewIdleLimit(max, minLifetime time.Duration) *IdleLimit {
+	return &IdleLimit{
+		max:       int64(max),
+		notBefore: time.Now().Add(minLifetime),
+	}
+}
+
+// Max returns the current max time without reading messages.
+func (l *IdleLimit) Max() time.Duration {
+	if l == nil {
+		return 0
+	}
+	return time.Duration(atomic.LoadInt64(&l.max))
+}
+
+// SetMax changes the max time without reading messages. A value of 0
+// disables the limit.
+func (l *IdleLimit) SetMax(max time.Duration) {
+	atomic.StoreInt64(&l.max, int64(max))
+}
+
+// Exceeded returns true if a reader that has not read messages for the given
+// time must stop.
+func (l *IdleLimit) Exceeded(idle time.Duration) bool {
+	max := l.Max()
+	if max <= 0 {
+		return false
+	}
+	return idle > max && !time.Now().Before(l.notBefore)
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIdleLimit_Exceeded(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit *IdleLimit
+		max   *time.Duration
+		idle  time.Duration
+		want  bool
+	}{
+		{
+			name: "Nil",
+			idle: time.Hour,
+			want: false,
+		},
+		{
+			name:  "Disabled",
+			limit: NewIdleLimit(0, 0),
+			idle:  time.Hour,
+			want:  false,
+		},
+		{
+			name:  "NotExceeded",
+			limit: NewIdleLimit(time.Minute, 0),
+			idle:  time.Second,
+			want:  false,
+		},
+		{
+			name:  "Exceeded",
+			limit: NewIdleLimit(time.Minute, 0),
+			idle:  2 * time.Minute,
+			want:  true,
+		},
+		{
+			name:  "MinLifetime",
+			limit: NewIdleLimit(time.Minute, time.Hour),
+			idle:  2 * time.Minute,
+			want:  false,
+		},
+		{
+			name:  "Changed",
+			limit: NewIdleLimit(time.Hour, 0),
+			max:   durationPtr(time.Minute),
+			idle:  2 * time.Minute,
+			want:  true,
+		},
+		{
+			name:  "ChangedToDisabled",
+			limit: NewIdleLimit(time.Minute, 0),
+			max:   durationPtr(0),
+			idle:  2 * time.Minute,
+			want:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.max != nil {
+				tt.limit.SetMax(*tt.max)
+			}
+			if got := tt.limit.Exceeded(tt.idle); got != tt.want {
+				t.Errorf("want exceeded %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func durationPtr(d time.Duration) *time.Duration {
+	return &d
+}
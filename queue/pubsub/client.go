@@ -0,0 +1,234 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+// Package pubsub implements a queue reader and a queue writer on top of
+// Google Cloud Pub/Sub using its REST API.
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+)
+
+// DefaultEndpoint is the endpoint of the Google Cloud Pub/Sub API.
+const DefaultEndpoint = config.DefaultPubSubEndpoint
+
+// metadataTokenURL is the URL of the GCE metadata server that returns the
+// access tokens of the default service account of the instance.
+var metadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+const (
+	requestTimeout = 10 * time.Second
+	// pullTimeout is the max time a pull request waits for messages.
+	pullTimeout = 60 * time.Second
+	// tokenExpiryDelta is the time before the expiration of an access token
+	// when it's renewed.
+	tokenExpiryDelta = time.Minute
+)
+
+// receivedMessage is a message returned by a pull request.
+type receivedMessage struct {
+	AckID           string        `json:"ackId"`
+	Message         pubsubMessage `json:"message"`
+	DeliveryAttempt int           `json:"deliveryAttempt"`
+}
+
+type pubsubMessage struct {
+	// Data is encoded in base64 by the encoding/json package.
+	Data       []byte            `json:"data"`
+	MessageID  string            `json:"messageId,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// client calls the Pub/Sub REST API.
+type client struct {
+	endpoint string
+	project  string
+	http     *http.Client
+	tokens   tokenSource
+}
+
+// newClient returns a client for the given project. If the token is empty
+// and emulator is false, the access tokens are requested to the GCE metadata
+// server.
+func newClient(endpoint, project, token string, emulator bool) *client {
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	hc := &http.Client{}
+	var tokens tokenSource
+	switch {
+	case emulator:
+		tokens = staticToken("")
+	case token != "":
+		tokens = staticToken(token)
+	default:
+		tokens = &metadataToken{client: &http.Client{Timeout: requestTimeout}}
+	}
+	return &client{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		project:  project,
+		http:     hc,
+		tokens:   tokens,
+	}
+}
+
+func (c *client) subscription(name string) string {
+	return fmt.Sprintf("projects/%s/subscriptions/%s", c.project, name)
+}
+
+func (c *client) topic(name string) string {
+	return fmt.Sprintf("projects/%s/topics/%s", c.project, name)
+}
+
+// pull returns up to max messages of the subscription. It can return no
+// messages.
+func (c *client) pull(ctx context.Context, sub string, max int) ([]receivedMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, pullTimeout)
+	defer cancel()
+	req := struct {
+		MaxMessages int `json:"maxMessages"`
+	}{max}
+	var resp struct {
+		ReceivedMessages []receivedMessage `json:"receivedMessages"`
+	}
+	err := c.do(ctx, http.MethodPost, "/v1/"+c.subscription(sub)+":pull", req, &resp)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		// The pull request waited for messages as much as allowed.
+		return nil, nil
+	}
+	return resp.ReceivedMessages, err
+}
+
+func (c *client) acknowledge(ctx context.Context, sub string, ackIDs ...string) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	req := struct {
+		AckIDs []string `json:"ackIds"`
+	}{ackIDs}
+	return c.do(ctx, http.MethodPost, "/v1/"+c.subscription(sub)+":acknowledge", req, nil)
+}
+
+func (c *client) modifyAckDeadline(ctx context.Context, sub string, seconds int, ackIDs ...string) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	req := struct {
+		AckIDs             []string `json:"ackIds"`
+		AckDeadlineSeconds int      `json:"ackDeadlineSeconds"`
+	}{ackIDs, seconds}
+	return c.do(ctx, http.MethodPost, "/v1/"+c.subscription(sub)+":modifyAckDeadline", req, nil)
+}
+
+func (c *client) getSubscription(ctx context.Context, sub string) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	return c.do(ctx, http.MethodGet, "/v1/"+c.subscription(sub), nil, nil)
+}
+
+func (c *client) publish(ctx context.Context, topic string, msgs ...pubsubMessage) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	req := struct {
+		Messages []pubsubMessage `json:"messages"`
+	}{msgs}
+	return c.do(ctx, http.MethodPost, "/v1/"+c.topic(topic)+":publish", req, nil)
+}
+
+func (c *client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.endpoint+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	token, err := c.tokens.token(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting pubsub access token: %w", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status code from pubsub: %d, %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// tokenSource returns the access tokens used to call the API.
+type tokenSource interface {
+	token(ctx context.Context) (string, error)
+}
+
+type staticToken string
+
+func (t staticToken) token(ctx context.Context) (string, error) {
+	return string(t), nil
+}
+
+// metadataToken gets the access tokens of the default service account of
+// the GCE instance from the metadata server, caching them until they are
+// about to expire.
+type metadataToken struct {
+	client *http.Client
+
+	mu     sync.Mutex
+	value  string
+	expiry time.Time
+}
+
+func (t *metadataToken) token(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.value != "" && time.Now().Before(t.expiry) {
+		return t.value, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code from the metadata server: %d", resp.StatusCode)
+	}
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return "", err
+	}
+	t.value = tok.AccessToken
+	t.expiry = time.Now().Add(time.Duration(tok.ExpiresIn)*time.Second - tokenExpiryDelta)
+	return t.value, nil
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/queue"
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakePubSub implements the subset of the Pub/Sub REST API used by the
+// Reader and the Writer.
+type fakePubSub struct {
+	mu        sync.Mutex
+	pending   []receivedMessage
+	acked     []string
+	nacked    []string
+	published []string
+	auth      []string
+}
+
+func (f *fakePubSub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.auth = append(f.auth, r.Header.Get("Authorization"))
+	var req struct {
+		MaxMessages        int             `json:"maxMessages"`
+		AckIDs             []string        `json:"ackIds"`
+		AckDeadlineSeconds int             `json:"ackDeadlineSeconds"`
+		Messages           []pubsubMessage `json:"messages"`
+	}
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	var resp interface{} = struct{}{}
+	switch {
+	case strings.HasSuffix(r.URL.Path, ":pull"):
+		n := req.MaxMessages
+		if n > len(f.pending) {
+			n = len(f.pending)
+		}
+		resp = map[string]interface{}{"receivedMessages": f.pending[:n]}
+		f.pending = f.pending[n:]
+	case strings.HasSuffix(r.URL.Path, ":acknowledge"):
+		f.acked = append(f.acked, req.AckIDs...)
+	case strings.HasSuffix(r.URL.Path, ":modifyAckDeadline"):
+		if req.AckDeadlineSeconds == 0 {
+			f.nacked = append(f.nacked, req.AckIDs...)
+		}
+	case strings.HasSuffix(r.URL.Path, ":publish"):
+		for _, m := range req.Messages {
+			f.published = append(f.published, string(m.Data))
+		}
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/projects/project/subscriptions/checks":
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+type processorMock struct {
+	mu       sync.Mutex
+	tokens   chan interface{}
+	messages []string
+}
+
+func (p *processorMock) FreeTokens() chan interface{} {
+	return p.tokens
+}
+
+func (p *processorMock) ProcessMessage(m queue.Message, token interface{}) <-chan bool {
+	p.mu.Lock()
+	p.messages = append(p.messages, m.Body)
+	p.mu.Unlock()
+	processed := make(chan bool, 1)
+	processed <- m.Body != "fail"
+	p.tokens <- token
+	return processed
+}
+
+func TestReader(t *testing.T) {
+	f := &fakePubSub{
+		pending: []receivedMessage{
+			{AckID: "1", Message: pubsubMessage{Data: []byte("check1"), MessageID: "m1"}},
+			{AckID: "2", Message: pubsubMessage{Data: []byte("fail"), MessageID: "m2"}},
+			{AckID: "3", Message: pubsubMessage{Data: []byte("check3"), MessageID: "m3"}},
+		},
+	}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	p := &processorMock{tokens: make(chan interface{}, 2)}
+	p.tokens <- 1
+	p.tokens <- 2
+	maxTimeNoRead := queue.NewIdleLimit(100*time.Millisecond, 0)
+	r, err := NewReader(&log.NullLog{}, config.PubSubReader{
+		Project:      "project",
+		Subscription: "checks",
+		Endpoint:     srv.URL,
+		Emulator:     true,
+	}, maxTimeNoRead, p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := r.Ping(context.Background()); err != nil {
+		t.Fatalf("unexpected ping error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := <-r.StartReading(ctx); err != queue.ErrMaxTimeNoRead {
+		t.Fatalf("want error %v, got %v", queue.ErrMaxTimeNoRead, err)
+	}
+	sort.Strings(p.messages)
+	if diff := cmp.Diff([]string{"check1", "check3", "fail"}, p.messages); diff != "" {
+		t.Errorf("want processed messages != got processed messages, diff: %s", diff)
+	}
+	sort.Strings(f.acked)
+	if diff := cmp.Diff([]string{"1", "3"}, f.acked); diff != "" {
+		t.Errorf("want acked messages != got acked messages, diff: %s", diff)
+	}
+	if len(f.nacked) != 0 {
+		t.Errorf("unexpected nacked messages: %v", f.nacked)
+	}
+	if len(p.tokens) != 2 {
+		t.Errorf("want 2 free tokens, got %d", len(p.tokens))
+	}
+}
+
+func TestWriter(t *testing.T) {
+	f := &fakePubSub{}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	w, err := NewWriter(config.PubSubWriter{
+		Project:  "project",
+		Topic:    "states",
+		Endpoint: srv.URL,
+		Token:    "token",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := w.Write(`{"id":"check1"}`); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{`{"id":"check1"}`}, f.published); diff != "" {
+		t.Errorf("want published messages != got published messages, diff: %s", diff)
+	}
+	if diff := cmp.Diff([]string{"Bearer token"}, f.auth); diff != "" {
+		t.Errorf("want authorization != got authorization, diff: %s", diff)
+	}
+}
+
+func TestMetadataToken(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			http.Error(w, "missing metadata flavor", http.StatusForbidden)
+			return
+		}
+		calls++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "token",
+			"expires_in":   3600,
+		})
+	}))
+	defer srv.Close()
+	defaultURL := metadataTokenURL
+	metadataTokenURL = srv.URL
+	defer func() { metadataTokenURL = defaultURL }()
+	ts := &metadataToken{client: srv.Client()}
+	for i := 0; i < 2; i++ {
+		got, err := ts.token(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != "token" {
+			t.Errorf("want token %q, got %q", "token", got)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("want 1 call to the metadata server, got %d", calls)
+	}
+}
@@ -0,0 +1,311 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/queue"
+)
+
+const (
+	// MaxAckDeadline is the max ack deadline, in seconds, allowed by Pub/Sub.
+	MaxAckDeadline = 600
+	// MinQuantumDelta is the min difference, in seconds, between the ack
+	// deadline and the process quantum.
+	MinQuantumDelta = 3
+)
+
+// Default values of the config of the Reader.
+const (
+	DefaultAckDeadline    = config.DefaultPubSubAckDeadline
+	DefaultProcessQuantum = config.DefaultPubSubProcessQuantum
+)
+
+// Reader reads messages from a Pub/Sub subscription. It never has more
+// messages outstanding than free tokens the processor has, and it keeps
+// extending the ack deadline of the messages while they are processed.
+type Reader struct {
+	*sync.RWMutex
+	c                   *client
+	sub                 string
+	ackDeadline         int
+	quantum             int
+	pollingInterval     int
+	wg                  *sync.WaitGroup
+	lastMessageReceived *time.Time
+	log                 log.Logger
+	maxTimeNoRead       *queue.IdleLimit
+	Processor           queue.MessageProcessor
+	nProcessingMessages uint32
+}
+
+func init() {
+	queue.Register(config.QueueTypePubSub, func(l log.Logger, cfg config.Config, maxTimeNoRead *queue.IdleLimit, processor queue.MessageProcessor) (queue.Reader, error) {
+		r, err := NewReader(l, cfg.PubSubReader, maxTimeNoRead, processor)
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
+	})
+}
+
+// NewReader creates a new Reader with the given processor and config.
+func NewReader(log log.Logger, cfg config.PubSubReader, maxTimeNoRead *queue.IdleLimit, processor queue.MessageProcessor) (*Reader, error) {
+	if cfg.Project == "" || cfg.Subscription == "" {
+		return nil, errors.New("the pubsub project and subscription are mandatory")
+	}
+	ackDeadline := cfg.AckDeadline
+	if ackDeadline == 0 {
+		ackDeadline = DefaultAckDeadline
+	}
+	quantum := cfg.ProcessQuantum
+	if quantum == 0 {
+		quantum = DefaultProcessQuantum
+	}
+	if ackDeadline > MaxAckDeadline {
+		return nil, fmt.Errorf("ack deadline must be at most %d seconds, got %d", MaxAckDeadline, ackDeadline)
+	}
+	if quantum < 1 || ackDeadline-quantum < MinQuantumDelta {
+		return nil, errors.New("difference between ack deadline and quantum is too short")
+	}
+	return &Reader{
+		RWMutex:         &sync.RWMutex{},
+		c:               newClient(cfg.Endpoint, cfg.Project, cfg.Token, cfg.Emulator),
+		sub:             cfg.Subscription,
+		ackDeadline:     ackDeadline,
+		quantum:         quantum,
+		pollingInterval: cfg.PollingInterval,
+		wg:              &sync.WaitGroup{},
+		log:             log,
+		maxTimeNoRead:   maxTimeNoRead,
+		Processor:       processor,
+	}, nil
+}
+
+// Ping checks that the subscription the Reader reads from is accessible.
+func (r *Reader) Ping(ctx context.Context) error {
+	if err := r.c.getSubscription(ctx, r.sub); err != nil {
+		return fmt.Errorf("error accessing subscription %s: %w", r.sub, err)
+	}
+	return nil
+}
+
+// StartReading starts reading messages from the subscription. It reads
+// messages only when there are free tokens in the message processor. It will
+// stop reading when the passed in context is canceled. The caller can use the
+// returned channel to track when the reader stopped reading and all the
+// messages it is tracking are finished processing.
+func (r *Reader) StartReading(ctx context.Context) <-chan error {
+	done := make(chan error, 1)
+	go r.read(ctx, done)
+	finished := make(chan error, 1)
+	go func() {
+		err := <-done
+		r.wg.Wait()
+		finished <- err
+		close(finished)
+	}()
+	return finished
+}
+
+func (r *Reader) read(ctx context.Context, done chan<- error) {
+	var (
+		err  error
+		msgs []receivedMessage
+	)
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			break loop
+		case token := <-r.Processor.FreeTokens():
+			// Pull as many messages as free tokens. The extra tokens are
+			// taken after pulling the messages, so the reader only holds one
+			// token while it waits.
+			msgs, err = r.readMessages(ctx, 1+len(r.Processor.FreeTokens()))
+			if err != nil {
+				r.releaseToken(token)
+			}
+			if err == queue.ErrMaxTimeNoRead {
+				r.log.Infof("reader stopped because max time without reading messages elapsed")
+				break loop
+			}
+			if err != nil {
+				break loop
+			}
+			tokens := append([]interface{}{token}, r.extraTokens(len(msgs)-1)...)
+			for i, msg := range msgs {
+				if i >= len(tokens) {
+					r.nack(msg)
+					continue
+				}
+				r.wg.Add(1)
+				atomic.AddUint32(&r.nProcessingMessages, 1)
+				go r.processAndTrack(ctx, msg, tokens[i])
+			}
+		}
+	}
+	done <- err
+	close(done)
+}
+
+// readMessages pulls the subscription until it gets at least one message,
+// and at most max.
+func (r *Reader) readMessages(ctx context.Context, max int) ([]receivedMessage, error) {
+	start := time.Now()
+	for {
+		msgs, err := r.c.pull(ctx, r.sub, max)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(msgs) > 0 {
+			now := time.Now()
+			r.setLastMessageReceived(&now)
+			return msgs, nil
+		}
+		n := atomic.LoadUint32(&r.nProcessingMessages)
+		if r.maxTimeNoRead.Exceeded(time.Since(start)) && n == 0 {
+			return nil, queue.ErrMaxTimeNoRead
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Duration(r.pollingInterval) * time.Second):
+		}
+	}
+}
+
+// extraTokens takes, without blocking, up to n free tokens.
+func (r *Reader) extraTokens(n int) []interface{} {
+	var tokens []interface{}
+	for len(tokens) < n {
+		select {
+		case t := <-r.Processor.FreeTokens():
+			tokens = append(tokens, t)
+		default:
+			return tokens
+		}
+	}
+	return tokens
+}
+
+// releaseToken gives back to the processor a token that is not going to be
+// used to process a message.
+func (r *Reader) releaseToken(token interface{}) {
+	if tr, ok := r.Processor.(queue.TokenReleaser); ok {
+		tr.ReleaseToken(token)
+		return
+	}
+	select {
+	case r.Processor.FreeTokens() <- token:
+	default:
+		r.log.Errorf("error, unexpected lock when giving back a token")
+	}
+}
+
+// processAndTrack processes a message, extending its ack deadline while it's
+// being processed, and acknowledges it when the processor signals it. The
+// messages that are not acknowledged while the reader is stopping are made
+// available again immediately so other agents can process them.
+func (r *Reader) processAndTrack(ctx context.Context, msg receivedMessage, token interface{}) {
+	defer func() {
+		atomic.AddUint32(&r.nProcessingMessages, ^uint32(0))
+		r.wg.Done()
+	}()
+	m := queue.Message{
+		Body:      string(msg.Message.Data),
+		TimesRead: msg.DeliveryAttempt,
+	}
+	processed := r.Processor.ProcessMessage(m, token)
+	l := r.lease(msg)
+	ack := <-processed
+	l.release()
+	if !ack && ctx.Err() != nil {
+		r.nack(msg)
+		return
+	}
+	if !ack {
+		r.log.Errorf("unexpected error processing message with id: %s, message not acknowledged", msg.Message.MessageID)
+		return
+	}
+	r.log.Infof("acknowledging message with id %s", msg.Message.MessageID)
+	if err := r.c.acknowledge(context.Background(), r.sub, msg.AckID); err != nil {
+		r.log.Errorf("acknowledging message with id: %s, error: %+v", msg.Message.MessageID, err)
+	}
+}
+
+// nack makes the message available again in the subscription.
+func (r *Reader) nack(msg receivedMessage) {
+	if err := r.c.modifyAckDeadline(context.Background(), r.sub, 0, msg.AckID); err != nil {
+		r.log.Errorf("returning message with id %s to the subscription, error: %+v", msg.Message.MessageID, err)
+		return
+	}
+	r.log.Infof("message with id %s returned to the subscription", msg.Message.MessageID)
+}
+
+// lease extends the ack deadline of a message every quantum until it's
+// released.
+type lease struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+func (r *Reader) lease(msg receivedMessage) *lease {
+	l := &lease{stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(l.done)
+		ticker := time.NewTicker(time.Duration(r.quantum) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-l.stop:
+				return
+			case <-ticker.C:
+				err := r.c.modifyAckDeadline(context.Background(), r.sub, r.ackDeadline, msg.AckID)
+				if err != nil {
+					r.log.Errorf("extending ack deadline of message with id %s, error: %+v", msg.Message.MessageID, err)
+				}
+			}
+		}
+	}()
+	return l
+}
+
+func (l *lease) release() {
+	close(l.stop)
+	<-l.done
+}
+
+func (r *Reader) setLastMessageReceived(t *time.Time) {
+	r.Lock()
+	r.lastMessageReceived = t
+	r.Unlock()
+}
+
+// ProcessingMessages returns the number of messages read by the Reader that
+// are being processed.
+func (r *Reader) ProcessingMessages() int {
+	return int(atomic.LoadUint32(&r.nProcessingMessages))
+}
+
+// LastMessageReceived returns the time where the last message was received by
+// the Reader. If no message was received so far it returns nil.
+func (r *Reader) LastMessageReceived() *time.Time {
+	r.RLock()
+	defer r.RUnlock()
+	return r.lastMessageReceived
+}
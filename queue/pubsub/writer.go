@@ -0,0 +1,34 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package pubsub
+
+import (
+	"context"
+	"errors"
+
+	"github.com/adevinta/vulcan-agent/config"
+)
+
+// Writer publishes messages to a Pub/Sub topic.
+type Writer struct {
+	c     *client
+	topic string
+}
+
+// NewWriter creates a new Pub/Sub writer from the given config.
+func NewWriter(cfg config.PubSubWriter) (*Writer, error) {
+	if cfg.Project == "" || cfg.Topic == "" {
+		return nil, errors.New("the pubsub project and topic are mandatory")
+	}
+	return &Writer{
+		c:     newClient(cfg.Endpoint, cfg.Project, cfg.Token, cfg.Emulator),
+		topic: cfg.Topic,
+	}, nil
+}
+
+// Write publishes a message with the given body to the topic.
+func (w *Writer) Write(body string) error {
+	return w.c.publish(context.Background(), w.topic, pubsubMessage{Data: []byte(body)})
+}
@@ -23,6 +23,9 @@ type Message struct {
 	// TimesRead contains the number of times this concrete message has been
 	// read so far.
 	TimesRead int
+	// Local is true for the messages generated by the agent itself, e.g. by
+	// the scheduler, that don't need to be authenticated.
+	Local bool
 }
 
 // MessageProcessor defines the methods needed by a queue reader implementation
@@ -32,6 +35,43 @@ type MessageProcessor interface {
 	ProcessMessage(msg Message, token interface{}) <-chan bool
 }
 
+// MessageChecker is implemented by the processors that can tell if a message
+// is well formed without processing it.
+type MessageChecker interface {
+	CheckMessage(msg Message) error
+}
+
+// TokenReleaser is implemented by the processors that allow a queue reader to
+// give back a token, obtained from FreeTokens, without processing a message.
+type TokenReleaser interface {
+	ReleaseToken(token interface{})
+}
+
+// TokenTaker is implemented by the processors that allow a queue reader to
+// take several free tokens at once without blocking, so it can receive as
+// many messages as tokens it holds.
+type TokenTaker interface {
+	TakeTokens(n int) []interface{}
+}
+
+// TakeTokens takes, without blocking, up to n free tokens of the given
+// processor.
+func TakeTokens(p MessageProcessor, n int) []interface{} {
+	if tt, ok := p.(TokenTaker); ok {
+		return tt.TakeTokens(n)
+	}
+	var tokens []interface{}
+	for len(tokens) < n {
+		select {
+		case t := <-p.FreeTokens():
+			tokens = append(tokens, t)
+		default:
+			return tokens
+		}
+	}
+	return tokens
+}
+
 // Reader defines the functions that all the concrete queue reader
 // implementations must fullfil.
 type Reader interface {
@@ -0,0 +1,65 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package queue
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+)
+
+// ReaderFactory creates a queue reader from the config of the agent. The
+// reader must stop reading when maxTimeNoRead is exceeded and no messages are
+// being processed.
+type ReaderFactory func(l log.Logger, cfg config.Config, maxTimeNoRead *IdleLimit, processor MessageProcessor) (Reader, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]ReaderFactory)
+)
+
+// Register makes a queue reader available by the provided type, so it can be
+// selected with the queue.type config param. It's intended to be called from
+// the init function of the packages implementing the readers. If Register is
+// called twice with the same type or if the factory is nil, it panics.
+func Register(typ string, f ReaderFactory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if f == nil {
+		panic("queue: register reader factory is nil")
+	}
+	if _, dup := factories[typ]; dup {
+		panic("queue: register called twice for type " + typ)
+	}
+	factories[typ] = f
+}
+
+// Types returns the sorted list of the registered queue reader types.
+func Types() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	types := make([]string, 0, len(factories))
+	for typ := range factories {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// NewReader creates a queue reader of the given type using the factory
+// registered for it.
+func NewReader(typ string, l log.Logger, cfg config.Config, maxTimeNoRead *IdleLimit, processor MessageProcessor) (Reader, error) {
+	factoriesMu.RLock()
+	f, ok := factories[typ]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown queue type %q, available types: %s", typ, strings.Join(Types(), ", "))
+	}
+	return f(l, cfg, maxTimeNoRead, processor)
+}
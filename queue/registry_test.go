@@ -0,0 +1,59 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+)
+
+type readerMock struct {
+	cfg config.Config
+}
+
+func (r *readerMock) StartReading(ctx context.Context) <-chan error {
+	return nil
+}
+
+func (r *readerMock) LastMessageReceived() *time.Time {
+	return nil
+}
+
+func TestNewReader(t *testing.T) {
+	errFactory := errors.New("factory error")
+	Register("test", func(l log.Logger, cfg config.Config, maxTimeNoRead *IdleLimit, processor MessageProcessor) (Reader, error) {
+		return &readerMock{cfg: cfg}, nil
+	})
+	Register("test-error", func(l log.Logger, cfg config.Config, maxTimeNoRead *IdleLimit, processor MessageProcessor) (Reader, error) {
+		return nil, errFactory
+	})
+	cfg := config.Config{Queue: config.QueueConfig{Type: "test"}}
+	r, err := NewReader("test", &log.NullLog{}, cfg, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m, ok := r.(*readerMock); !ok || m.cfg.Queue.Type != "test" {
+		t.Errorf("reader not created by the registered factory: %#v", r)
+	}
+	if _, err := NewReader("test-error", &log.NullLog{}, cfg, nil, nil); !errors.Is(err, errFactory) {
+		t.Errorf("want error %v, got %v", errFactory, err)
+	}
+	if _, err := NewReader("unknown", &log.NullLog{}, cfg, nil, nil); err == nil {
+		t.Errorf("want error creating a reader of an unknown type")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("registering a type twice must panic")
+		}
+	}()
+	Register("test", func(l log.Logger, cfg config.Config, maxTimeNoRead *IdleLimit, processor MessageProcessor) (Reader, error) {
+		return nil, nil
+	})
+}
@@ -0,0 +1,232 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+// Package servicebus implements a queue reader and a queue writer on top of
+// Azure Service Bus queues using its REST API.
+package servicebus
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	requestTimeout = 10 * time.Second
+	// sasTokenTTL is the validity of the SAS tokens generated to call the
+	// API.
+	sasTokenTTL = time.Hour
+)
+
+// errNoMessages is returned by receive when no message was available.
+var errNoMessages = errors.New("no messages available")
+
+// connString contains the params of an Azure Service Bus connection string.
+type connString struct {
+	endpoint string
+	keyName  string
+	key      string
+	entity   string
+}
+
+// parseConnString parses a connection string like the ones shown by the
+// Azure portal, e.g.:
+// Endpoint=sb://ns.servicebus.windows.net/;SharedAccessKeyName=name;SharedAccessKey=key
+func parseConnString(s string) (connString, error) {
+	var cs connString
+	for _, part := range strings.Split(s, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return connString{}, fmt.Errorf("invalid connection string param %q", kv[0])
+		}
+		switch strings.ToLower(kv[0]) {
+		case "endpoint":
+			u, err := url.Parse(kv[1])
+			if err != nil {
+				return connString{}, fmt.Errorf("invalid connection string endpoint: %w", err)
+			}
+			if u.Scheme == "sb" {
+				u.Scheme = "https"
+			}
+			cs.endpoint = strings.TrimSuffix(u.String(), "/")
+		case "sharedaccesskeyname":
+			cs.keyName = kv[1]
+		case "sharedaccesskey":
+			cs.key = kv[1]
+		case "entitypath":
+			cs.entity = kv[1]
+		}
+	}
+	if cs.endpoint == "" || cs.keyName == "" || cs.key == "" {
+		return connString{}, errors.New("the connection string must contain the Endpoint, SharedAccessKeyName and SharedAccessKey")
+	}
+	return cs, nil
+}
+
+// message is a message received from a queue with a peek lock.
+type message struct {
+	ID            string
+	Body          []byte
+	DeliveryCount int
+	// location is the URL used to complete, abandon or renew the lock of
+	// the message.
+	location string
+}
+
+// client calls the Service Bus REST API of a queue.
+type client struct {
+	endpoint string
+	queue    string
+	keyName  string
+	key      string
+	http     *http.Client
+	now      func() time.Time
+}
+
+// newClient returns a client for the given queue. If the queue is empty the
+// EntityPath of the connection string is used.
+func newClient(connectionString, queue string) (*client, error) {
+	cs, err := parseConnString(connectionString)
+	if err != nil {
+		return nil, err
+	}
+	if queue == "" {
+		queue = cs.entity
+	}
+	if queue == "" {
+		return nil, errors.New("the service bus queue is mandatory")
+	}
+	return &client{
+		endpoint: cs.endpoint,
+		queue:    queue,
+		keyName:  cs.keyName,
+		key:      cs.key,
+		http:     &http.Client{},
+		now:      time.Now,
+	}, nil
+}
+
+// receive peeks and locks the next message of the queue, waiting up to the
+// given time for a message to be available. It returns errNoMessages if
+// there are no messages.
+func (c *client) receive(ctx context.Context, wait time.Duration) (message, error) {
+	secs := int(wait.Seconds())
+	ctx, cancel := context.WithTimeout(ctx, wait+requestTimeout)
+	defer cancel()
+	u := fmt.Sprintf("%s/%s/messages/head?timeout=%d", c.endpoint, c.queue, secs)
+	resp, err := c.do(ctx, http.MethodPost, u, nil)
+	if err != nil {
+		return message{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return message{}, errNoMessages
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return message{}, statusError(resp)
+	}
+	var props struct {
+		MessageID     string `json:"MessageId"`
+		DeliveryCount int    `json:"DeliveryCount"`
+	}
+	if err := json.Unmarshal([]byte(resp.Header.Get("BrokerProperties")), &props); err != nil {
+		return message{}, fmt.Errorf("invalid broker properties: %w", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return message{}, err
+	}
+	loc := resp.Header.Get("Location")
+	if loc == "" {
+		return message{}, errors.New("received message without location")
+	}
+	return message{
+		ID:            props.MessageID,
+		Body:          body,
+		DeliveryCount: props.DeliveryCount,
+		location:      loc,
+	}, nil
+}
+
+// complete deletes the message from the queue.
+func (c *client) complete(ctx context.Context, msg message) error {
+	return c.call(ctx, http.MethodDelete, msg.location, nil)
+}
+
+// abandon unlocks the message so it's available again in the queue.
+func (c *client) abandon(ctx context.Context, msg message) error {
+	return c.call(ctx, http.MethodPut, msg.location, nil)
+}
+
+// renewLock extends the lock of the message for the lock duration of the
+// queue.
+func (c *client) renewLock(ctx context.Context, msg message) error {
+	return c.call(ctx, http.MethodPost, msg.location, nil)
+}
+
+// send writes a message to the queue.
+func (c *client) send(ctx context.Context, body []byte) error {
+	return c.call(ctx, http.MethodPost, fmt.Sprintf("%s/%s/messages", c.endpoint, c.queue), body)
+}
+
+// ping checks that the queue is accessible.
+func (c *client) ping(ctx context.Context) error {
+	return c.call(ctx, http.MethodGet, fmt.Sprintf("%s/%s", c.endpoint, c.queue), nil)
+}
+
+func (c *client) call(ctx context.Context, method, u string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	resp, err := c.do(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return statusError(resp)
+	}
+	return nil
+}
+
+func (c *client) do(ctx context.Context, method, u string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", c.sasToken(c.endpoint+"/"+c.queue))
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.http.Do(req)
+}
+
+// sasToken returns a shared access signature token for the given resource.
+func (c *client) sasToken(resource string) string {
+	uri := url.QueryEscape(strings.ToLower(resource))
+	expiry := strconv.FormatInt(c.now().Add(sasTokenTTL).Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(c.key))
+	mac.Write([]byte(uri + "\n" + expiry))
+	sig := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return fmt.Sprintf("SharedAccessSignature sr=%s&sig=%s&se=%s&skn=%s", uri, url.QueryEscape(sig), expiry, c.keyName)
+}
+
+func statusError(resp *http.Response) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("unexpected status code from service bus: %d, %s", resp.StatusCode, bytes.TrimSpace(msg))
+}
@@ -0,0 +1,238 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package servicebus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/queue"
+)
+
+// Default values of the config of the Reader.
+const (
+	DefaultWaitTime    = config.DefaultServiceBusWaitTime
+	DefaultLockRenewal = config.DefaultServiceBusLockRenewal
+)
+
+// Reader reads messages from an Azure Service Bus queue using peek locks. It
+// keeps renewing the lock of the messages while they are processed.
+type Reader struct {
+	*sync.RWMutex
+	c                   *client
+	waitTime            time.Duration
+	lockRenewal         time.Duration
+	wg                  *sync.WaitGroup
+	lastMessageReceived *time.Time
+	log                 log.Logger
+	maxTimeNoRead       *queue.IdleLimit
+	Processor           queue.MessageProcessor
+	nProcessingMessages uint32
+}
+
+func init() {
+	queue.Register(config.QueueTypeServiceBus, func(l log.Logger, cfg config.Config, maxTimeNoRead *queue.IdleLimit, processor queue.MessageProcessor) (queue.Reader, error) {
+		r, err := NewReader(l, cfg.ServiceBusReader, maxTimeNoRead, processor)
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
+	})
+}
+
+// NewReader creates a new Reader with the given processor and config.
+func NewReader(log log.Logger, cfg config.ServiceBusReader, maxTimeNoRead *queue.IdleLimit, processor queue.MessageProcessor) (*Reader, error) {
+	c, err := newClient(cfg.ConnectionString, cfg.Queue)
+	if err != nil {
+		return nil, err
+	}
+	waitTime := cfg.WaitTime
+	if waitTime <= 0 {
+		waitTime = DefaultWaitTime
+	}
+	lockRenewal := cfg.LockRenewal
+	if lockRenewal <= 0 {
+		lockRenewal = DefaultLockRenewal
+	}
+	return &Reader{
+		RWMutex:       &sync.RWMutex{},
+		c:             c,
+		waitTime:      time.Duration(waitTime) * time.Second,
+		lockRenewal:   time.Duration(lockRenewal) * time.Second,
+		wg:            &sync.WaitGroup{},
+		log:           log,
+		maxTimeNoRead: maxTimeNoRead,
+		Processor:     processor,
+	}, nil
+}
+
+// Ping checks that the queue the Reader reads from is accessible.
+func (r *Reader) Ping(ctx context.Context) error {
+	if err := r.c.ping(ctx); err != nil {
+		return fmt.Errorf("error accessing queue %s: %w", r.c.queue, err)
+	}
+	return nil
+}
+
+// StartReading starts reading messages from the queue. It reads messages only
+// when there are free tokens in the message processor. It will stop reading
+// when the passed in context is canceled. The caller can use the returned
+// channel to track when the reader stopped reading and all the messages it is
+// tracking are finished processing.
+func (r *Reader) StartReading(ctx context.Context) <-chan error {
+	done := make(chan error, 1)
+	go r.read(ctx, done)
+	finished := make(chan error, 1)
+	go func() {
+		err := <-done
+		r.wg.Wait()
+		finished <- err
+		close(finished)
+	}()
+	return finished
+}
+
+func (r *Reader) read(ctx context.Context, done chan<- error) {
+	var (
+		err error
+		msg message
+	)
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			break loop
+		case token := <-r.Processor.FreeTokens():
+			msg, err = r.readMessage(ctx)
+			if err == queue.ErrMaxTimeNoRead {
+				r.log.Infof("reader stopped because max time without reading messages elapsed")
+				break loop
+			}
+			if err != nil {
+				break loop
+			}
+			r.wg.Add(1)
+			atomic.AddUint32(&r.nProcessingMessages, 1)
+			go r.processAndTrack(ctx, msg, token)
+		}
+	}
+	done <- err
+	close(done)
+}
+
+func (r *Reader) readMessage(ctx context.Context) (message, error) {
+	start := time.Now()
+	for {
+		msg, err := r.c.receive(ctx, r.waitTime)
+		if ctx.Err() != nil {
+			return message{}, ctx.Err()
+		}
+		if err == nil {
+			now := time.Now()
+			r.setLastMessageReceived(&now)
+			return msg, nil
+		}
+		if !errors.Is(err, errNoMessages) {
+			return message{}, err
+		}
+		n := atomic.LoadUint32(&r.nProcessingMessages)
+		if r.maxTimeNoRead.Exceeded(time.Since(start)) && n == 0 {
+			return message{}, queue.ErrMaxTimeNoRead
+		}
+	}
+}
+
+// processAndTrack processes a message, renewing its lock while it's being
+// processed, and completes it when the processor signals it. The messages
+// that are not completed while the reader is stopping are abandoned so other
+// agents can process them immediately.
+func (r *Reader) processAndTrack(ctx context.Context, msg message, token interface{}) {
+	defer func() {
+		atomic.AddUint32(&r.nProcessingMessages, ^uint32(0))
+		r.wg.Done()
+	}()
+	m := queue.Message{
+		Body:      string(msg.Body),
+		TimesRead: msg.DeliveryCount,
+	}
+	processed := r.Processor.ProcessMessage(m, token)
+	l := r.lease(msg)
+	complete := <-processed
+	l.release()
+	if !complete && ctx.Err() != nil {
+		if err := r.c.abandon(context.Background(), msg); err != nil {
+			r.log.Errorf("abandoning message with id %s, error: %+v", msg.ID, err)
+			return
+		}
+		r.log.Infof("message with id %s returned to the queue", msg.ID)
+		return
+	}
+	if !complete {
+		r.log.Errorf("unexpected error processing message with id: %s, message not completed", msg.ID)
+		return
+	}
+	r.log.Infof("completing message with id %s", msg.ID)
+	if err := r.c.complete(context.Background(), msg); err != nil {
+		r.log.Errorf("completing message with id: %s, error: %+v", msg.ID, err)
+	}
+}
+
+// lease renews the lock of a message periodically until it's released.
+type lease struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+func (r *Reader) lease(msg message) *lease {
+	l := &lease{stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(l.done)
+		ticker := time.NewTicker(r.lockRenewal)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-l.stop:
+				return
+			case <-ticker.C:
+				if err := r.c.renewLock(context.Background(), msg); err != nil {
+					r.log.Errorf("renewing lock of message with id %s, error: %+v", msg.ID, err)
+				}
+			}
+		}
+	}()
+	return l
+}
+
+func (l *lease) release() {
+	close(l.stop)
+	<-l.done
+}
+
+func (r *Reader) setLastMessageReceived(t *time.Time) {
+	r.Lock()
+	r.lastMessageReceived = t
+	r.Unlock()
+}
+
+// ProcessingMessages returns the number of messages read by the Reader that
+// are being processed.
+func (r *Reader) ProcessingMessages() int {
+	return int(atomic.LoadUint32(&r.nProcessingMessages))
+}
+
+// LastMessageReceived returns the time where the last message was received by
+// the Reader. If no message was received so far it returns nil.
+func (r *Reader) LastMessageReceived() *time.Time {
+	r.RLock()
+	defer r.RUnlock()
+	return r.lastMessageReceived
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package servicebus
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/queue"
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeServiceBus implements the subset of the Service Bus REST API used by
+// the Reader and the Writer.
+type fakeServiceBus struct {
+	mu      sync.Mutex
+	url     string
+	pending []string
+	// received is the number of messages received so far, used to generate
+	// the ids of the messages.
+	received int
+	calls    []string
+	sent     []string
+	noAuth   bool
+}
+
+func (f *fakeServiceBus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "SharedAccessSignature sr=") {
+		f.noAuth = true
+	}
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/checks/messages/head":
+		if len(f.pending) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		id := fmt.Sprintf("m%d", f.received)
+		f.received++
+		w.Header().Set("BrokerProperties", fmt.Sprintf(`{"MessageId":%q,"DeliveryCount":1,"LockToken":"lock"}`, id))
+		w.Header().Set("Location", fmt.Sprintf("%s/checks/messages/%s/lock", f.url, id))
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, f.pending[0])
+		f.pending = f.pending[1:]
+	case r.Method == http.MethodPost && r.URL.Path == "/checks/messages":
+		body, _ := io.ReadAll(r.Body)
+		f.sent = append(f.sent, string(body))
+		w.WriteHeader(http.StatusCreated)
+	case strings.HasPrefix(r.URL.Path, "/checks/messages/"):
+		f.calls = append(f.calls, r.Method+" "+r.URL.Path)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+type processorMock struct {
+	mu       sync.Mutex
+	tokens   chan interface{}
+	messages []queue.Message
+	delay    time.Duration
+}
+
+func (p *processorMock) FreeTokens() chan interface{} {
+	return p.tokens
+}
+
+func (p *processorMock) ProcessMessage(m queue.Message, token interface{}) <-chan bool {
+	p.mu.Lock()
+	p.messages = append(p.messages, m)
+	p.mu.Unlock()
+	processed := make(chan bool, 1)
+	go func() {
+		time.Sleep(p.delay)
+		p.tokens <- token
+		processed <- m.Body != "fail"
+	}()
+	return processed
+}
+
+func TestReader(t *testing.T) {
+	f := &fakeServiceBus{pending: []string{"check1", "fail"}}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	f.url = srv.URL
+	p := &processorMock{tokens: make(chan interface{}, 1), delay: 1500 * time.Millisecond}
+	p.tokens <- 1
+	maxTimeNoRead := queue.NewIdleLimit(100*time.Millisecond, 0)
+	r, err := NewReader(&log.NullLog{}, config.ServiceBusReader{
+		ConnectionString: fmt.Sprintf("Endpoint=%s/;SharedAccessKeyName=agent;SharedAccessKey=key;EntityPath=checks", srv.URL),
+		WaitTime:         1,
+		LockRenewal:      1,
+	}, maxTimeNoRead, p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := <-r.StartReading(ctx); err != queue.ErrMaxTimeNoRead {
+		t.Fatalf("want error %v, got %v", queue.ErrMaxTimeNoRead, err)
+	}
+	want := []queue.Message{{Body: "check1", TimesRead: 1}, {Body: "fail", TimesRead: 1}}
+	if diff := cmp.Diff(want, p.messages); diff != "" {
+		t.Errorf("want processed messages != got processed messages, diff: %s", diff)
+	}
+	// The lock of each message is renewed once while it's processed and
+	// only the successfully processed message is completed.
+	wantCalls := []string{
+		"POST /checks/messages/m0/lock",
+		"DELETE /checks/messages/m0/lock",
+		"POST /checks/messages/m1/lock",
+	}
+	if diff := cmp.Diff(wantCalls, f.calls); diff != "" {
+		t.Errorf("want calls != got calls, diff: %s", diff)
+	}
+	if f.noAuth {
+		t.Errorf("requests without SAS token")
+	}
+}
+
+func TestWriter(t *testing.T) {
+	f := &fakeServiceBus{}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	w, err := NewWriter(config.ServiceBusWriter{
+		ConnectionString: fmt.Sprintf("Endpoint=%s;SharedAccessKeyName=agent;SharedAccessKey=key", srv.URL),
+		Queue:            "checks",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := w.Write(`{"id":"check1"}`); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{`{"id":"check1"}`}, f.sent); diff != "" {
+		t.Errorf("want sent messages != got sent messages, diff: %s", diff)
+	}
+}
+
+func TestParseConnString(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    connString
+		wantErr bool
+	}{
+		{
+			name: "Valid",
+			s:    "Endpoint=sb://ns.servicebus.windows.net/;SharedAccessKeyName=agent;SharedAccessKey=a2V5=;EntityPath=checks",
+			want: connString{
+				endpoint: "https://ns.servicebus.windows.net",
+				keyName:  "agent",
+				key:      "a2V5=",
+				entity:   "checks",
+			},
+		},
+		{
+			name:    "MissingKey",
+			s:       "Endpoint=sb://ns.servicebus.windows.net/;SharedAccessKeyName=agent",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseConnString(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("want error %v, got %v", tt.wantErr, err)
+			}
+			if diff := cmp.Diff(tt.want, got, cmp.AllowUnexported(connString{})); diff != "" {
+				t.Errorf("want conn string != got conn string, diff: %s", diff)
+			}
+		})
+	}
+}
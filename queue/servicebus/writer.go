@@ -0,0 +1,30 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package servicebus
+
+import (
+	"context"
+
+	"github.com/adevinta/vulcan-agent/config"
+)
+
+// Writer sends messages to an Azure Service Bus queue.
+type Writer struct {
+	c *client
+}
+
+// NewWriter creates a new Service Bus writer from the given config.
+func NewWriter(cfg config.ServiceBusWriter) (*Writer, error) {
+	c, err := newClient(cfg.ConnectionString, cfg.Queue)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{c: c}, nil
+}
+
+// Write sends a message with the given body to the queue.
+func (w *Writer) Write(body string) error {
+	return w.c.send(context.Background(), []byte(body))
+}
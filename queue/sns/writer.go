@@ -0,0 +1,64 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package sns
+
+import (
+	"fmt"
+
+	"github.com/adevinta/vulcan-agent/awscreds"
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+)
+
+// Writer publishes messages to an AWS SNS topic, so they can be delivered to
+// several subscribers.
+type Writer struct {
+	sns      snsiface.SNSAPI
+	topicARN string
+	log      log.Logger
+}
+
+// NewWriter creates a new SNS writer that publishes to the given topic ARN
+// using the passed in endpoint, or the default one if it is empty.
+func NewWriter(topicARN string, endpoint string, l log.Logger) (*Writer, error) {
+	sess, err := awscreds.NewSession(config.AWSCredentialsConfig{})
+	if err != nil {
+		return nil, err
+	}
+	arn, err := arn.Parse(topicARN)
+	if err != nil {
+		err = fmt.Errorf("error parsing SNS topic ARN: %w", err)
+		return nil, err
+	}
+	awsCfg := aws.NewConfig()
+	if arn.Region != "" {
+		awsCfg = awsCfg.WithRegion(arn.Region)
+	}
+	if endpoint != "" {
+		awsCfg = awsCfg.WithEndpoint(endpoint)
+	}
+	return &Writer{
+		sns:      sns.New(sess, awsCfg),
+		topicARN: topicARN,
+		log:      l,
+	}, nil
+}
+
+// Write publishes the body as a message in the topic.
+func (w *Writer) Write(body string) error {
+	out, err := w.sns.Publish(&sns.PublishInput{
+		TopicArn: &w.topicARN,
+		Message:  &body,
+	})
+	if err != nil {
+		return err
+	}
+	w.log.Debugf("message published to SNS with id %s", aws.StringValue(out.MessageId))
+	return nil
+}
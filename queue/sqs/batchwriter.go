@@ -0,0 +1,189 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package sqs
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// MaxBatchSize is the maximum number of messages that can be sent to SQS in a
+// single batch.
+const MaxBatchSize = 10
+
+const (
+	defaultFlushInterval   = 200 * time.Millisecond
+	defaultBatchBufferSize = 1000
+	maxBatchSendAttempts   = 3
+)
+
+// ErrWriterClosed is returned when writing to a closed BatchWriter.
+var ErrWriterClosed = errors.New("writer closed")
+
+type batchEntry struct {
+	body     string
+	group    *string
+	dedupID  *string
+	attempts int
+}
+
+// BatchWriter writes messages to an AWS SQS queue asynchronously, grouping
+// them in batches. A batch is sent when it's full or, at the latest, when
+// the flush interval elapses. The messages that can't be sent are retried in
+// the following batches a limited number of times before being discarded.
+type BatchWriter struct {
+	w        *Writer
+	size     int
+	interval time.Duration
+	log      log.Logger
+
+	mu     sync.RWMutex
+	closed bool
+	msgs   chan batchEntry
+	done   chan struct{}
+}
+
+// NewBatchWriter creates a BatchWriter that sends the messages using the
+// given writer. The size of the batches is limited to MaxBatchSize.
+// The bufferSize is the number of messages that can be pending to be sent
+// before the calls to Write block. A 0 flushInterval or bufferSize means
+// using the default value.
+func NewBatchWriter(l log.Logger, w *Writer, size int, flushInterval time.Duration, bufferSize int) *BatchWriter {
+	if size < 1 || size > MaxBatchSize {
+		size = MaxBatchSize
+	}
+	if flushInterval <= 0 {
+		flushInterval = defaultFlushInterval
+	}
+	if bufferSize <= 0 {
+		bufferSize = defaultBatchBufferSize
+	}
+	bw := &BatchWriter{
+		w:        w,
+		size:     size,
+		interval: flushInterval,
+		log:      l,
+		msgs:     make(chan batchEntry, bufferSize),
+		done:     make(chan struct{}),
+	}
+	go bw.run()
+	return bw
+}
+
+// Write queues the message to be sent in the next batch. If the queue is a
+// FIFO queue the message is written to the DefaultMessageGroup.
+func (bw *BatchWriter) Write(body string) error {
+	return bw.WriteGroup(DefaultMessageGroup, body)
+}
+
+// WriteGroup queues the message to be sent in the next batch. If the queue is
+// a FIFO queue the message is written to the given message group.
+func (bw *BatchWriter) WriteGroup(group, body string) error {
+	bw.mu.RLock()
+	defer bw.mu.RUnlock()
+	if bw.closed {
+		return ErrWriterClosed
+	}
+	e := batchEntry{body: body}
+	e.group, e.dedupID = bw.w.fifoIDs(group)
+	bw.msgs <- e
+	return nil
+}
+
+// Close sends the pending messages and stops the BatchWriter.
+func (bw *BatchWriter) Close() error {
+	bw.mu.Lock()
+	if !bw.closed {
+		bw.closed = true
+		close(bw.msgs)
+	}
+	bw.mu.Unlock()
+	<-bw.done
+	return nil
+}
+
+func (bw *BatchWriter) run() {
+	defer close(bw.done)
+	ticker := time.NewTicker(bw.interval)
+	defer ticker.Stop()
+	var pending []batchEntry
+	for {
+		select {
+		case e, ok := <-bw.msgs:
+			if !ok {
+				for len(pending) > 0 {
+					pending = bw.flush(pending)
+				}
+				return
+			}
+			pending = append(pending, e)
+			if len(pending) >= bw.size {
+				pending = bw.flush(pending)
+			}
+		case <-ticker.C:
+			if len(pending) > 0 {
+				pending = bw.flush(pending)
+			}
+		}
+	}
+}
+
+// flush sends the entries in batches and returns the ones that must be
+// retried.
+func (bw *BatchWriter) flush(entries []batchEntry) []batchEntry {
+	var retry []batchEntry
+	for len(entries) > 0 {
+		n := bw.size
+		if n > len(entries) {
+			n = len(entries)
+		}
+		retry = append(retry, bw.send(entries[:n])...)
+		entries = entries[n:]
+	}
+	return retry
+}
+
+func (bw *BatchWriter) send(batch []batchEntry) []batchEntry {
+	input := &sqs.SendMessageBatchInput{QueueUrl: &bw.w.queueURL}
+	for i := range batch {
+		batch[i].attempts++
+		input.Entries = append(input.Entries, &sqs.SendMessageBatchRequestEntry{
+			Id:                     aws.String(strconv.Itoa(i)),
+			MessageBody:            aws.String(batch[i].body),
+			MessageGroupId:         batch[i].group,
+			MessageDeduplicationId: batch[i].dedupID,
+		})
+	}
+	var failed []batchEntry
+	out, err := bw.w.sqs.SendMessageBatch(input)
+	if err != nil {
+		bw.log.Errorf("error sending batch of %d messages to SQS: %+v", len(batch), err)
+		failed = batch
+	} else {
+		for _, f := range out.Failed {
+			i, err := strconv.Atoi(aws.StringValue(f.Id))
+			if err != nil || i < 0 || i >= len(batch) {
+				continue
+			}
+			bw.log.Errorf("error sending message to SQS: %s %s", aws.StringValue(f.Code), aws.StringValue(f.Message))
+			failed = append(failed, batch[i])
+		}
+	}
+	var retry []batchEntry
+	for _, e := range failed {
+		if e.attempts >= maxBatchSendAttempts {
+			bw.log.Errorf("discarding message after %d attempts: %s", e.attempts, e.body)
+			continue
+		}
+		retry = append(retry, e)
+	}
+	return retry
+}
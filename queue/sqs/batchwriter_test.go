@@ -0,0 +1,94 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package sqs
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/google/go-cmp/cmp"
+)
+
+type batchSQS struct {
+	sqsiface.SQSAPI
+	sync.Mutex
+	// failOnce contains the bodies of the messages that fail the first time
+	// they are sent.
+	failOnce map[string]bool
+	batches  [][]string
+}
+
+func (b *batchSQS) SendMessageBatch(in *sqs.SendMessageBatchInput) (*sqs.SendMessageBatchOutput, error) {
+	b.Lock()
+	defer b.Unlock()
+	out := &sqs.SendMessageBatchOutput{}
+	var batch []string
+	for _, e := range in.Entries {
+		body := aws.StringValue(e.MessageBody)
+		if b.failOnce[body] {
+			delete(b.failOnce, body)
+			out.Failed = append(out.Failed, &sqs.BatchResultErrorEntry{Id: e.Id, Code: aws.String("InternalError")})
+			continue
+		}
+		batch = append(batch, body)
+	}
+	b.batches = append(b.batches, batch)
+	return out, nil
+}
+
+func TestBatchWriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     int
+		msgs     int
+		failOnce map[string]bool
+		want     [][]string
+	}{
+		{
+			name: "SendsFullBatches",
+			size: 10,
+			msgs: 12,
+			want: [][]string{
+				{"m0", "m1", "m2", "m3", "m4", "m5", "m6", "m7", "m8", "m9"},
+				{"m10", "m11"},
+			},
+		},
+		{
+			name:     "RetriesFailedMessages",
+			size:     3,
+			msgs:     3,
+			failOnce: map[string]bool{"m1": true},
+			want: [][]string{
+				{"m0", "m2"},
+				{"m1"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := &batchSQS{failOnce: tt.failOnce}
+			w := &Writer{sqs: mock, queueURL: "queue"}
+			bw := NewBatchWriter(&log.NullLog{}, w, tt.size, time.Hour, 0)
+			for i := 0; i < tt.msgs; i++ {
+				if err := bw.Write(fmt.Sprintf("m%d", i)); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			bw.Close()
+			if err := bw.Write("closed"); err != ErrWriterClosed {
+				t.Errorf("want error %v writing to a closed writer, got %v", ErrWriterClosed, err)
+			}
+			if diff := cmp.Diff(tt.want, mock.batches); diff != "" {
+				t.Errorf("want batches != got batches, diff: %s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package sqs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/queue"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// idleCheckInterval is how often the CompositeReader checks if the max time
+// without reading messages elapsed.
+var idleCheckInterval = time.Second
+
+// CompositeReader reads messages from several SQS queues, that can be in
+// different accounts and regions, concurrently. Each queue is read by its
+// own loop, that takes a free token of the processor before polling the
+// queue, so every queue is always being polled while there are free tokens.
+// The share of a queue limits the number of checks read from it that can be
+// running at the same time, so a busy queue can't starve the rest.
+type CompositeReader struct {
+	readers       []*Reader
+	slots         []chan struct{}
+	maxTimeNoRead *queue.IdleLimit
+	log           log.Logger
+	Processor     queue.MessageProcessor
+}
+
+func init() {
+	queue.Register(config.QueueTypeSQSMulti, func(l log.Logger, cfg config.Config, maxTimeNoRead *queue.IdleLimit, processor queue.MessageProcessor) (queue.Reader, error) {
+		r, err := NewCompositeReader(l, cfg.SQSReaders, maxTimeNoRead, processor)
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
+	})
+}
+
+// NewCompositeReader creates a new CompositeReader that reads from the given
+// queues.
+func NewCompositeReader(log log.Logger, queues []config.SQSReader, maxTimeNoRead *queue.IdleLimit, processor queue.MessageProcessor) (*CompositeReader, error) {
+	if len(queues) == 0 {
+		return nil, errors.New("no sqs readers defined")
+	}
+	c := &CompositeReader{
+		maxTimeNoRead: maxTimeNoRead,
+		log:           log,
+		Processor:     processor,
+	}
+	for _, q := range queues {
+		if q.Share < 0 {
+			return nil, fmt.Errorf("invalid share %d of queue %s", q.Share, q.ARN)
+		}
+		// The max time without reading messages is tracked for all the
+		// queues together.
+		r, err := NewReader(log, q, nil, processor)
+		if err != nil {
+			return nil, fmt.Errorf("error creating reader for queue %s: %w", q.ARN, err)
+		}
+		c.readers = append(c.readers, r)
+		var slots chan struct{}
+		if q.Share > 0 {
+			slots = make(chan struct{}, q.Share)
+		}
+		c.slots = append(c.slots, slots)
+	}
+	return c, nil
+}
+
+// Ping checks that all the queues the CompositeReader reads from are
+// accessible.
+func (c *CompositeReader) Ping(ctx context.Context) error {
+	for _, r := range c.readers {
+		if err := r.Ping(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartReading starts reading messages from all the queues. It reads messages
+// only when there are free tokens in the message processor. It stops reading
+// from all the queues when the passed in context is canceled or when reading
+// from any of them fails. The caller can use the returned channel to track
+// when the reader stopped reading and all the messages it is tracking are
+// finished processing.
+func (c *CompositeReader) StartReading(ctx context.Context) <-chan error {
+	readCtx, cancel := context.WithCancel(ctx)
+	n := len(c.readers)
+	errs := make(chan error, n+1)
+	for i := range c.readers {
+		go func(i int) {
+			errs <- c.readQueue(readCtx, ctx, i)
+		}(i)
+	}
+	if c.maxTimeNoRead != nil {
+		n++
+		go func() {
+			errs <- c.watchIdle(readCtx)
+		}()
+	}
+	finished := make(chan error, 1)
+	go func() {
+		err := <-errs
+		cancel()
+		for i := 1; i < n; i++ {
+			<-errs
+		}
+		for _, r := range c.readers {
+			r.wg.Wait()
+		}
+		finished <- err
+		close(finished)
+	}()
+	return finished
+}
+
+// readQueue reads the messages of a queue until the readCtx is done. The
+// messages are processed with the ctx, so they are not stopped when the
+// reader stops because reading from another queue failed.
+func (c *CompositeReader) readQueue(readCtx, ctx context.Context, i int) error {
+	r := c.readers[i]
+	for {
+		if !c.takeSlot(readCtx, i) {
+			return readCtx.Err()
+		}
+		var token interface{}
+		select {
+		case <-readCtx.Done():
+			c.freeSlot(i)
+			return readCtx.Err()
+		case token = <-c.Processor.FreeTokens():
+		}
+		msgs, _, err := r.readMessages(readCtx, token, 1)
+		if err != nil {
+			r.releaseToken(token)
+			c.freeSlot(i)
+			if errors.Is(err, context.Canceled) {
+				return err
+			}
+			return fmt.Errorf("error reading queue %s: %w", aws.StringValue(r.receiveParams.QueueUrl), err)
+		}
+		msg := msgs[0]
+		r.wg.Add(1)
+		atomic.AddUint32(&r.nProcessingMessages, 1)
+		go func() {
+			defer c.freeSlot(i)
+			r.processAndTrack(ctx, msg, token, r.groups.join(msg))
+		}()
+	}
+}
+
+func (c *CompositeReader) takeSlot(ctx context.Context, i int) bool {
+	if c.slots[i] == nil {
+		return true
+	}
+	select {
+	case c.slots[i] <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (c *CompositeReader) freeSlot(i int) {
+	if c.slots[i] != nil {
+		<-c.slots[i]
+	}
+}
+
+// watchIdle returns queue.ErrMaxTimeNoRead when no messages were read from
+// any of the queues for the max time without reading messages and no
+// messages are being processed.
+func (c *CompositeReader) watchIdle(ctx context.Context) error {
+	start := time.Now()
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		last := start
+		if t := c.LastMessageReceived(); t != nil && t.After(last) {
+			last = *t
+		}
+		if c.maxTimeNoRead.Exceeded(time.Since(last)) && c.ProcessingMessages() == 0 {
+			c.log.Infof("reader stopped because max time without reading messages elapsed")
+			return queue.ErrMaxTimeNoRead
+		}
+	}
+}
+
+// ProcessingMessages returns the number of messages read by the
+// CompositeReader that are being processed.
+func (c *CompositeReader) ProcessingMessages() int {
+	var n int
+	for _, r := range c.readers {
+		n += r.ProcessingMessages()
+	}
+	return n
+}
+
+// LastMessageReceived returns the time where the last message was received
+// from any of the queues. If no message was received so far it returns nil.
+func (c *CompositeReader) LastMessageReceived() *time.Time {
+	var last *time.Time
+	for _, r := range c.readers {
+		if t := r.LastMessageReceived(); t != nil && (last == nil || t.After(*last)) {
+			last = t
+		}
+	}
+	return last
+}
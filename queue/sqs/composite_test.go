@@ -0,0 +1,112 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package sqs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/queue"
+)
+
+func TestCompositeReader_Shares(t *testing.T) {
+	tokens := make(chan interface{}, 4)
+	for i := 0; i < 4; i++ {
+		tokens <- struct{}{}
+	}
+	var (
+		mu      sync.Mutex
+		started = map[string]int{}
+	)
+	release := make(chan struct{})
+	processor := &messageProcessorMock{
+		tokens: tokens,
+		processMessage: func(m queue.Message, token interface{}) <-chan bool {
+			mu.Lock()
+			started[m.Body[:1]]++
+			mu.Unlock()
+			processed := make(chan bool, 1)
+			go func() {
+				<-release
+				tokens <- token
+				processed <- true
+			}()
+			return processed
+		},
+	}
+	a := newInMemReader("a1", "a2")
+	b := newInMemReader("b1", "b2")
+	a.Processor, b.Processor = processor, processor
+	c := &CompositeReader{
+		readers:   []*Reader{a, b},
+		slots:     []chan struct{}{make(chan struct{}, 1), nil},
+		log:       &log.NullLog{},
+		Processor: processor,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := c.StartReading(ctx)
+
+	waitFor := func(want map[string]int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			mu.Lock()
+			got := started["a"] == want["a"] && started["b"] == want["b"]
+			mu.Unlock()
+			if got {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("want started messages %v, got %v", want, started)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	// The second message of the queue a must wait for the first one to
+	// finish, even if there are free tokens.
+	waitFor(map[string]int{"a": 1, "b": 2})
+	time.Sleep(50 * time.Millisecond)
+	waitFor(map[string]int{"a": 1, "b": 2})
+	close(release)
+	waitFor(map[string]int{"a": 2, "b": 2})
+	if c.LastMessageReceived() == nil {
+		t.Errorf("last message received not set")
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("want error %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestCompositeReader_MaxTimeNoRead(t *testing.T) {
+	prev := idleCheckInterval
+	idleCheckInterval = 10 * time.Millisecond
+	defer func() { idleCheckInterval = prev }()
+
+	tokens := make(chan interface{}, 1)
+	tokens <- struct{}{}
+	processor := &messageProcessorMock{tokens: tokens}
+	a, b := newInMemReader(), newInMemReader()
+	a.Processor, b.Processor = processor, processor
+	c := &CompositeReader{
+		readers:       []*Reader{a, b},
+		slots:         []chan struct{}{nil, nil},
+		maxTimeNoRead: queue.NewIdleLimit(50*time.Millisecond, 0),
+		log:           &log.NullLog{},
+		Processor:     processor,
+	}
+	select {
+	case err := <-c.StartReading(context.Background()):
+		if !errors.Is(err, queue.ErrMaxTimeNoRead) {
+			t.Errorf("want error %v, got %v", queue.ErrMaxTimeNoRead, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reader not stopped")
+	}
+}
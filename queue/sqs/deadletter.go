@@ -0,0 +1,151 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package sqs
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/queue"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// DefaultMaxReceiveCount is the number of times a message can be received
+// before it's moved to the dead letter queue, when a dead letter queue is
+// configured.
+const DefaultMaxReceiveCount = config.DefaultSQSMaxReceiveCount
+
+// Attributes added to the messages moved to the dead letter queue.
+const (
+	AttrSourceQueue     = "SourceQueue"
+	AttrSourceMessageID = "SourceMessageId"
+	AttrReceiveCount    = "ReceiveCount"
+	AttrReason          = "Reason"
+	AttrQuarantinedAt   = "QuarantinedAt"
+)
+
+// deadLetter is the queue where the poison messages read by a Reader are
+// moved to.
+type deadLetter struct {
+	sqs             sqsiface.SQSAPI
+	queueURL        string
+	maxReceiveCount int
+}
+
+func newDeadLetter(sess *session.Session, queueARN, endpoint string, maxReceiveCount int) (*deadLetter, error) {
+	arn, err := arn.Parse(queueARN)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing SQS dead letter queue ARN: %w", err)
+	}
+	awsCfg := aws.NewConfig()
+	if arn.Region != "" {
+		awsCfg = awsCfg.WithRegion(arn.Region)
+	}
+	if endpoint != "" {
+		awsCfg = awsCfg.WithEndpoint(endpoint)
+	}
+	params := &sqs.GetQueueUrlInput{
+		QueueName: aws.String(arn.Resource),
+	}
+	if arn.AccountID != "" {
+		params.SetQueueOwnerAWSAccountId(arn.AccountID)
+	}
+	srv := sqs.New(sess, awsCfg)
+	resp, err := srv.GetQueueUrl(params)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving SQS dead letter queue URL: %w", err)
+	}
+	if maxReceiveCount < 1 {
+		maxReceiveCount = DefaultMaxReceiveCount
+	}
+	return &deadLetter{
+		sqs:             srv,
+		queueURL:        aws.StringValue(resp.QueueUrl),
+		maxReceiveCount: maxReceiveCount,
+	}, nil
+}
+
+// poisoned returns the reason why a message must be moved to the dead letter
+// queue, or an empty string if it can be processed.
+func (r *Reader) poisoned(m queue.Message) string {
+	if r.dlq == nil {
+		return ""
+	}
+	if checker, ok := r.Processor.(queue.MessageChecker); ok {
+		if err := checker.CheckMessage(m); err != nil {
+			return err.Error()
+		}
+	}
+	if m.TimesRead > r.dlq.maxReceiveCount {
+		return fmt.Sprintf("max receive count %d exceeded", r.dlq.maxReceiveCount)
+	}
+	return ""
+}
+
+// quarantine moves a message to the dead letter queue, adding to it the
+// information needed to diagnose why it was moved. It returns false if the
+// message could not be written to the dead letter queue, in that case the
+// message is left in the queue.
+func (r *Reader) quarantine(msg *sqs.Message, receiveCount int, reason string) bool {
+	input := &sqs.SendMessageInput{
+		QueueUrl:    aws.String(r.dlq.queueURL),
+		MessageBody: msg.Body,
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			AttrSourceQueue:     stringAttr(aws.StringValue(r.receiveParams.QueueUrl)),
+			AttrSourceMessageID: stringAttr(aws.StringValue(msg.MessageId)),
+			AttrReceiveCount: {
+				DataType:    aws.String("Number"),
+				StringValue: aws.String(strconv.Itoa(receiveCount)),
+			},
+			AttrReason:        stringAttr(reason),
+			AttrQuarantinedAt: stringAttr(time.Now().UTC().Format(time.RFC3339)),
+		},
+	}
+	if _, err := r.dlq.sqs.SendMessage(input); err != nil {
+		r.log.Errorf("moving message with id %s to the dead letter queue, error: %+v", *msg.MessageId, err)
+		return false
+	}
+	r.log.Errorf("message with id %s moved to the dead letter queue: %s", *msg.MessageId, reason)
+	_, err := r.sqs.DeleteMessage(&sqs.DeleteMessageInput{
+		QueueUrl:      r.receiveParams.QueueUrl,
+		ReceiptHandle: msg.ReceiptHandle,
+	})
+	if err != nil {
+		r.log.Errorf("deleting message with id: %s, error: %+v", *msg.MessageId, err)
+	}
+	return true
+}
+
+// releaseToken gives back to the processor a token that is not going to be
+// used to process a message.
+func (r *Reader) releaseToken(token interface{}) {
+	releaseToken(r.Processor, r.log, token)
+}
+
+func releaseToken(p queue.MessageProcessor, l log.Logger, token interface{}) {
+	if tr, ok := p.(queue.TokenReleaser); ok {
+		tr.ReleaseToken(token)
+		return
+	}
+	select {
+	case p.FreeTokens() <- token:
+	default:
+		l.Errorf("error, unexpected lock when giving back a token")
+	}
+}
+
+func stringAttr(v string) *sqs.MessageAttributeValue {
+	return &sqs.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(v),
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package sqs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/queue"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/google/go-cmp/cmp"
+)
+
+type checkerProcessorMock struct {
+	messageProcessorMock
+	checkMessage func(m queue.Message) error
+}
+
+func (mp *checkerProcessorMock) CheckMessage(m queue.Message) error {
+	return mp.checkMessage(m)
+}
+
+func TestReader_processAndTrackDeadLetter(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		receiveCount  string
+		sendErr       error
+		wantReason    string
+		wantProcessed bool
+		wantDeleted   bool
+	}{
+		{
+			name:          "ValidMessage",
+			body:          "{}",
+			receiveCount:  "2",
+			wantProcessed: true,
+			wantDeleted:   true,
+		},
+		{
+			name:         "InvalidMessage",
+			body:         "invalid",
+			receiveCount: "1",
+			wantReason:   "invalid job",
+			wantDeleted:  true,
+		},
+		{
+			name:         "MaxReceiveCountExceeded",
+			body:         "{}",
+			receiveCount: "4",
+			wantReason:   "max receive count 3 exceeded",
+			wantDeleted:  true,
+		},
+		{
+			name:          "DeadLetterQueueUnavailable",
+			body:          "{}",
+			receiveCount:  "4",
+			sendErr:       errors.New("unavailable"),
+			wantProcessed: true,
+			wantDeleted:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				sent    []*sqs.SendMessageInput
+				deleted bool
+			)
+			dlq := &SqsMock{
+				MessageSender: func(input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+					if tt.sendErr != nil {
+						return nil, tt.sendErr
+					}
+					sent = append(sent, input)
+					return &sqs.SendMessageOutput{}, nil
+				},
+			}
+			p := &checkerProcessorMock{
+				messageProcessorMock: messageProcessorMock{
+					tokens: make(chan interface{}, 1),
+					processMessage: func(m queue.Message, token interface{}) <-chan bool {
+						processed := make(chan bool, 1)
+						processed <- true
+						return processed
+					},
+				},
+				checkMessage: func(m queue.Message) error {
+					if m.Body != "{}" {
+						return errors.New("invalid job")
+					}
+					return nil
+				},
+			}
+			r := &Reader{
+				RWMutex: &sync.RWMutex{},
+				sqs: &SqsMock{
+					MessageVisibilityChanger: func(input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+						return &sqs.ChangeMessageVisibilityOutput{}, nil
+					},
+					MessageDeleter: func(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+						deleted = true
+						return &sqs.DeleteMessageOutput{}, nil
+					},
+				},
+				visibilityTimeout:     30,
+				processMessageQuantum: 20,
+				receiveParams:         sqs.ReceiveMessageInput{QueueUrl: aws.String("queue")},
+				wg:                    &sync.WaitGroup{},
+				log:                   &log.NullLog{},
+				Processor:             p,
+				dlq:                   &deadLetter{sqs: dlq, queueURL: "dlq", maxReceiveCount: 3},
+			}
+			r.wg.Add(1)
+			r.processAndTrack(context.Background(), &sqs.Message{
+				Body:          aws.String(tt.body),
+				MessageId:     aws.String("id"),
+				ReceiptHandle: aws.String("handle"),
+				Attributes:    map[string]*string{"ApproximateReceiveCount": aws.String(tt.receiveCount)},
+			}, "token", nil)
+			if got := len(p.Messages) > 0; got != tt.wantProcessed {
+				t.Errorf("want processed %v, got %v", tt.wantProcessed, got)
+			}
+			if deleted != tt.wantDeleted {
+				t.Errorf("want deleted %v, got %v", tt.wantDeleted, deleted)
+			}
+			if tt.wantReason == "" {
+				if len(sent) > 0 {
+					t.Errorf("message moved to the dead letter queue")
+				}
+				return
+			}
+			if len(sent) != 1 {
+				t.Fatalf("want 1 message moved to the dead letter queue, got %d", len(sent))
+			}
+			got := map[string]string{"Body": aws.StringValue(sent[0].MessageBody)}
+			for _, k := range []string{AttrSourceQueue, AttrSourceMessageID, AttrReceiveCount, AttrReason} {
+				got[k] = aws.StringValue(sent[0].MessageAttributes[k].StringValue)
+			}
+			want := map[string]string{
+				"Body":              tt.body,
+				AttrSourceQueue:     "queue",
+				AttrSourceMessageID: "id",
+				AttrReceiveCount:    tt.receiveCount,
+				AttrReason:          tt.wantReason,
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("want dead letter message != got dead letter message, diff: %s", diff)
+			}
+			if len(p.tokens) != 1 {
+				t.Errorf("token not given back to the processor")
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package sqs
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// fifoSuffix is the suffix of the names of the SQS FIFO queues.
+const fifoSuffix = ".fifo"
+
+// isFIFO returns true if the queue with the given name is a FIFO queue.
+func isFIFO(queueName string) bool {
+	return strings.HasSuffix(queueName, fifoSuffix)
+}
+
+// groups makes the messages of a FIFO queue that belong to the same message
+// group, e.g. the checks of the same target, to be processed one after
+// another, in the order they were received.
+type groups struct {
+	mu   sync.Mutex
+	last map[string]chan struct{}
+}
+
+func newGroups() *groups {
+	return &groups{last: make(map[string]chan struct{})}
+}
+
+// turn represents the position of a message in the processing order of its
+// group. A nil turn never waits.
+type turn struct {
+	g     *groups
+	group string
+	prev  <-chan struct{}
+	next  chan struct{}
+}
+
+// join returns the turn of the given message in its group. It must be called
+// in the order the messages were received.
+func (g *groups) join(msg *sqs.Message) *turn {
+	if g == nil {
+		return nil
+	}
+	group := aws.StringValue(msg.Attributes[sqs.MessageSystemAttributeNameMessageGroupId])
+	if group == "" {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	t := &turn{
+		g:     g,
+		group: group,
+		prev:  g.last[group],
+		next:  make(chan struct{}),
+	}
+	g.last[group] = t.next
+	return t
+}
+
+// wait blocks until the previous messages of the group are processed or the
+// context is done.
+func (t *turn) wait(ctx context.Context) error {
+	if t == nil || t.prev == nil {
+		return nil
+	}
+	select {
+	case <-t.prev:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// done lets the next message of the group to be processed.
+func (t *turn) done() {
+	if t == nil {
+		return
+	}
+	t.g.mu.Lock()
+	defer t.g.mu.Unlock()
+	close(t.next)
+	if t.g.last[t.group] == t.next {
+		delete(t.g.last, t.group)
+	}
+}
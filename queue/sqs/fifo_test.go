@@ -0,0 +1,124 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package sqs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/google/go-cmp/cmp"
+)
+
+func groupMsg(group string) *sqs.Message {
+	msg := &sqs.Message{}
+	if group != "" {
+		msg.Attributes = map[string]*string{
+			sqs.MessageSystemAttributeNameMessageGroupId: aws.String(group),
+		}
+	}
+	return msg
+}
+
+func waits(t *turn) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	return t.wait(ctx) != nil
+}
+
+func TestGroups(t *testing.T) {
+	g := newGroups()
+	a1 := g.join(groupMsg("a"))
+	a2 := g.join(groupMsg("a"))
+	a3 := g.join(groupMsg("a"))
+	b1 := g.join(groupMsg("b"))
+	none := g.join(groupMsg(""))
+	if waits(a1) || waits(b1) || waits(none) {
+		t.Fatalf("first messages of the groups must not wait")
+	}
+	if !waits(a2) || !waits(a3) {
+		t.Fatalf("next messages of a group must wait for the previous ones")
+	}
+	a1.done()
+	if waits(a2) {
+		t.Errorf("message not processed after the previous one of its group")
+	}
+	if !waits(a3) {
+		t.Errorf("message processed before the previous one of its group")
+	}
+	a2.done()
+	a3.done()
+	b1.done()
+	none.done()
+	if len(g.last) != 0 {
+		t.Errorf("groups not released: %v", g.last)
+	}
+	if (*groups)(nil).join(groupMsg("a")) != nil {
+		t.Errorf("messages of non FIFO queues must not wait")
+	}
+}
+
+func TestWriter_WriteGroup(t *testing.T) {
+	tests := []struct {
+		name         string
+		fifo         bool
+		contentDedup bool
+		group        string
+		wantGroup    *string
+		wantDedup    bool
+	}{
+		{
+			name:  "Standard",
+			group: "check1",
+		},
+		{
+			name:      "FIFO",
+			fifo:      true,
+			group:     "check1",
+			wantGroup: aws.String("check1"),
+			wantDedup: true,
+		},
+		{
+			name:         "FIFOContentBasedDedup",
+			fifo:         true,
+			contentDedup: true,
+			group:        "check1",
+			wantGroup:    aws.String("check1"),
+		},
+		{
+			name:      "FIFODefaultGroup",
+			fifo:      true,
+			wantGroup: aws.String(DefaultMessageGroup),
+			wantDedup: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *sqs.SendMessageInput
+			w := &Writer{
+				sqs: &SqsMock{
+					MessageSender: func(input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+						got = input
+						return &sqs.SendMessageOutput{}, nil
+					},
+				},
+				queueURL:          "queue",
+				fifo:              tt.fifo,
+				ContentBasedDedup: tt.contentDedup,
+			}
+			if err := w.WriteGroup(tt.group, "body"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.wantGroup, got.MessageGroupId); diff != "" {
+				t.Errorf("want group != got group, diff: %s", diff)
+			}
+			if gotDedup := got.MessageDeduplicationId != nil; gotDedup != tt.wantDedup {
+				t.Errorf("want deduplication id %v, got %v", tt.wantDedup, gotDedup)
+			}
+		})
+	}
+}
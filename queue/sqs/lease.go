@@ -0,0 +1,100 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package sqs
+
+import (
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// leaseRetryInterval is the time to wait before trying again to extend the
+// visibility timeout of a message after a failed attempt.
+var leaseRetryInterval = time.Second
+
+// lease keeps a message invisible to the other consumers of the queue while
+// it's being processed, by extending its visibility timeout every process
+// quantum, so it is not delivered to other agents while its check runs.
+type lease struct {
+	r    *Reader
+	msg  *sqs.Message
+	stop chan struct{}
+	done chan struct{}
+}
+
+// lease starts extending the visibility timeout of the given message until
+// the returned lease is released.
+func (r *Reader) lease(msg *sqs.Message) *lease {
+	l := &lease{
+		r:    r,
+		msg:  msg,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go l.keep()
+	return l
+}
+
+// release stops extending the visibility timeout of the message. It must be
+// called once the processing of the message finished.
+func (l *lease) release() {
+	close(l.stop)
+	<-l.done
+}
+
+func (l *lease) keep() {
+	defer close(l.done)
+	quantum := time.Duration(l.r.processMessageQuantum) * time.Second
+	timer := time.NewTimer(quantum)
+	defer timer.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-timer.C:
+		}
+		next := quantum
+		err := l.extend()
+		if err != nil && permanentLeaseError(err) {
+			l.r.log.Errorf("the visibility timeout of the message with id %s can not be extended anymore: %+v", *l.msg.MessageId, err)
+			return
+		}
+		if err != nil {
+			l.r.log.Errorf("extending message visibility time for message with id: %s, error: %+v", *l.msg.MessageId, err)
+			if leaseRetryInterval < next {
+				next = leaseRetryInterval
+			}
+		}
+		timer.Reset(next)
+	}
+}
+
+func (l *lease) extend() error {
+	input := &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          l.r.receiveParams.QueueUrl,
+		ReceiptHandle:     l.msg.ReceiptHandle,
+		VisibilityTimeout: aws.Int64(int64(l.r.visibilityTimeout)),
+	}
+	_, err := l.r.sqs.ChangeMessageVisibility(input)
+	return err
+}
+
+// permanentLeaseError returns true if the error returned when extending the
+// visibility timeout of a message means that it can't be extended anymore,
+// e.g. because the message is not in flight.
+func permanentLeaseError(err error) bool {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return false
+	}
+	switch aerr.Code() {
+	case sqs.ErrCodeReceiptHandleIsInvalid, sqs.ErrCodeMessageNotInflight:
+		return true
+	}
+	return false
+}
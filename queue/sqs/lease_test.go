@@ -0,0 +1,82 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package sqs
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+func TestReader_lease(t *testing.T) {
+	leaseRetryInterval = 10 * time.Millisecond
+	defer func() { leaseRetryInterval = time.Second }()
+	tests := []struct {
+		name     string
+		errs     []error
+		wantMin  int
+		wantMax  int
+		extended bool
+	}{
+		{
+			name:    "ExtendsUntilReleased",
+			wantMin: 1,
+			wantMax: 1,
+		},
+		{
+			name:    "RetriesAfterTransientErrors",
+			errs:    []error{errors.New("transient"), errors.New("transient")},
+			wantMin: 3,
+			wantMax: 3,
+		},
+		{
+			name:    "StopsAfterPermanentErrors",
+			errs:    []error{awserr.New(sqs.ErrCodeMessageNotInflight, "not in flight", nil)},
+			wantMin: 1,
+			wantMax: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu    sync.Mutex
+				calls int
+			)
+			r := &Reader{
+				RWMutex: &sync.RWMutex{},
+				sqs: &SqsMock{
+					MessageVisibilityChanger: func(input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+						mu.Lock()
+						defer mu.Unlock()
+						calls++
+						if calls <= len(tt.errs) {
+							return nil, tt.errs[calls-1]
+						}
+						return &sqs.ChangeMessageVisibilityOutput{}, nil
+					},
+				},
+				visibilityTimeout:     30,
+				processMessageQuantum: 1,
+				receiveParams:         sqs.ReceiveMessageInput{QueueUrl: aws.String("queue")},
+				log:                   &log.NullLog{},
+			}
+			l := r.lease(&sqs.Message{
+				MessageId:     aws.String("id"),
+				ReceiptHandle: aws.String("handle"),
+			})
+			time.Sleep(1500 * time.Millisecond)
+			l.release()
+			if calls < tt.wantMin || calls > tt.wantMax {
+				t.Errorf("want between %d and %d visibility changes, got %d", tt.wantMin, tt.wantMax, calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,332 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package sqs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/queue"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// Polling strategies supported by the MultiReader.
+const (
+	StrategyStrict   = "strict"
+	StrategyWeighted = "weighted"
+)
+
+// MultiReader reads messages from several SQS queues with different
+// priorities. Every time the processor has a free token the MultiReader polls
+// the queues, in the order defined by its strategy, and passes to the processor
+// the first message it finds.
+type MultiReader struct {
+	*sync.RWMutex
+	readers             []*Reader
+	weights             []int
+	strategy            string
+	poolingInterval     int
+	lastMessageReceived *time.Time
+	maxTimeNoRead       *queue.IdleLimit
+	log                 log.Logger
+	rand                *rand.Rand
+	// longPolls is the number of times the MultiReader waited for messages
+	// because all the queues were empty.
+	longPolls int
+	Processor queue.MessageProcessor
+}
+
+func init() {
+	queue.Register(config.QueueTypeSQSPriority, func(l log.Logger, cfg config.Config, maxTimeNoRead *queue.IdleLimit, processor queue.MessageProcessor) (queue.Reader, error) {
+		r, err := NewMultiReader(l, cfg.SQSPriorityReader, maxTimeNoRead, processor)
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
+	})
+}
+
+// NewMultiReader creates a new MultiReader that reads from the queues defined
+// in the given config.
+func NewMultiReader(log log.Logger, cfg config.SQSPriorityReader, maxTimeNoRead *queue.IdleLimit, processor queue.MessageProcessor) (*MultiReader, error) {
+	if len(cfg.Queues) == 0 {
+		return nil, errors.New("no queues defined in the sqs priority reader")
+	}
+	strategy := cfg.Strategy
+	if strategy == "" {
+		strategy = StrategyStrict
+	}
+	if strategy != StrategyStrict && strategy != StrategyWeighted {
+		return nil, fmt.Errorf("invalid sqs priority reader strategy: %s", strategy)
+	}
+	queues := make([]config.SQSReader, len(cfg.Queues))
+	copy(queues, cfg.Queues)
+	sort.SliceStable(queues, func(i, j int) bool {
+		return queues[i].Priority > queues[j].Priority
+	})
+	var (
+		readers []*Reader
+		weights []int
+	)
+	for _, q := range queues {
+		r, err := NewReader(log, q, nil, processor)
+		if err != nil {
+			return nil, fmt.Errorf("error creating reader for queue %s: %w", q.ARN, err)
+		}
+		readers = append(readers, r)
+		w := q.Weight
+		if w < 1 {
+			w = 1
+		}
+		weights = append(weights, w)
+	}
+	return &MultiReader{
+		RWMutex:         &sync.RWMutex{},
+		readers:         readers,
+		weights:         weights,
+		strategy:        strategy,
+		poolingInterval: queues[0].PollingInterval,
+		maxTimeNoRead:   maxTimeNoRead,
+		log:             log,
+		rand:            rand.New(rand.NewSource(time.Now().UnixNano())),
+		Processor:       processor,
+	}, nil
+}
+
+// Ping checks that all the queues the MultiReader reads from are accessible.
+func (m *MultiReader) Ping(ctx context.Context) error {
+	for _, r := range m.readers {
+		if err := r.Ping(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartReading starts reading messages from the sqs queues. It reads messages
+// only when there are free tokens in the message processor. It will stop
+// reading from the queues when the passed in context is canceled. The caller
+// can use the returned channel to track when the reader stopped reading and
+// all the messages it is tracking are finished processing.
+func (m *MultiReader) StartReading(ctx context.Context) <-chan error {
+	done := make(chan error, 1)
+	go m.read(ctx, done)
+	finished := make(chan error, 1)
+	go func() {
+		err := <-done
+		for _, r := range m.readers {
+			r.wg.Wait()
+		}
+		finished <- err
+		close(finished)
+	}()
+	return finished
+}
+
+func (m *MultiReader) read(ctx context.Context, done chan<- error) {
+	var err error
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			break loop
+		case token := <-m.Processor.FreeTokens():
+			var (
+				r      *Reader
+				msgs   []*sqs.Message
+				tokens []interface{}
+			)
+			r, msgs, tokens, err = m.readMessages(ctx, token)
+			// Give back the tokens not used to process a message.
+			for _, t := range tokens[len(msgs):] {
+				m.releaseToken(t)
+			}
+			if err == queue.ErrMaxTimeNoRead {
+				m.log.Infof("reader stopped because max time without reading messages elapsed")
+				break loop
+			}
+			if err != nil {
+				break loop
+			}
+			for i, msg := range msgs {
+				r.wg.Add(1)
+				atomic.AddUint32(&r.nProcessingMessages, 1)
+				go r.processAndTrack(ctx, msg, tokens[i], r.groups.join(msg))
+			}
+		}
+	}
+	done <- err
+	close(done)
+}
+
+// readMessages polls the queues, holding the given token, until it gets at
+// least one message. It returns the messages together with the reader of the
+// queue they were read from and the tokens held, that are at least as many as
+// messages. As the Reader does, every receive asks for as many messages as
+// tokens the MultiReader can take, up to the max messages configured for the
+// queue. The queues are swept without waiting for messages, so an empty queue
+// doesn't delay reading from the ones after it in the polling order. Only when
+// all of them are empty the MultiReader waits for messages in one of them, see
+// longPolled, using the wait time configured for it or, if it's 0, the polling
+// interval. After each wait without messages it waits the receive backoff of
+// the queue with the highest priority, as the Reader does.
+func (m *MultiReader) readMessages(ctx context.Context, token interface{}) (*Reader, []*sqs.Message, []interface{}, error) {
+	start := time.Now()
+	tokens := []interface{}{token}
+	var backoff time.Duration
+	for {
+		order := m.order()
+		for _, r := range order {
+			var (
+				msgs []*sqs.Message
+				err  error
+			)
+			msgs, tokens, err = m.receive(ctx, r, tokens, 0)
+			if err != nil || len(msgs) > 0 {
+				return r, msgs, tokens, err
+			}
+		}
+		if m.maxTimeNoRead.Exceeded(time.Since(start)) && m.processing() == 0 {
+			return nil, nil, tokens, queue.ErrMaxTimeNoRead
+		}
+		// No queue has messages, wait for messages in one of them so they
+		// can be processed as soon as they arrive.
+		lp := m.longPolled(order)
+		waitTime := lp.waitTime
+		if waitTime == 0 {
+			waitTime = m.poolingInterval
+		}
+		msgs, tokens, err := m.receive(ctx, lp, tokens, int64(waitTime))
+		if err != nil || len(msgs) > 0 {
+			return lp, msgs, tokens, err
+		}
+		held := len(tokens)
+		for _, t := range tokens[1:] {
+			m.releaseToken(t)
+		}
+		tokens = tokens[:1]
+		backoff = m.readers[0].nextBackoff(backoff)
+		if backoff == 0 {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil, nil, tokens, ctx.Err()
+		case <-time.After(backoff / time.Duration(held)):
+		}
+	}
+}
+
+// longPolled returns the reader of the queue where the MultiReader waits for
+// messages when all the queues are empty. With the weighted strategy it's the
+// first queue of the given polling order, that is already chosen by weight.
+// With the strict strategy the queues take turns, so the messages arriving to
+// the queues with lower priority are also received as soon as they arrive
+// instead of waiting for the next sweep.
+func (m *MultiReader) longPolled(order []*Reader) *Reader {
+	if m.strategy == StrategyWeighted {
+		return order[0]
+	}
+	r := m.readers[m.longPolls%len(m.readers)]
+	m.longPolls++
+	return r
+}
+
+// receive takes, without blocking, the free tokens needed to read the max
+// messages configured for the queue of the given reader and executes one
+// receive message call asking for as many messages as tokens held, up to that
+// max. It returns the messages received and the tokens held.
+func (m *MultiReader) receive(ctx context.Context, r *Reader, tokens []interface{}, waitTime int64) ([]*sqs.Message, []interface{}, error) {
+	max := r.maxMessages
+	if max < 1 {
+		max = 1
+	}
+	if n := max - len(tokens); n > 0 {
+		tokens = append(tokens, queue.TakeTokens(m.Processor, n)...)
+	}
+	n := len(tokens)
+	if n > max {
+		n = max
+	}
+	msgs, err := r.receiveMessages(ctx, waitTime, int64(n))
+	if err != nil {
+		return nil, tokens, err
+	}
+	if len(msgs) > 0 {
+		now := time.Now()
+		m.setLastMessageReceived(&now)
+	}
+	return msgs, tokens, nil
+}
+
+// releaseToken gives back to the processor a token that is not going to be
+// used to process a message.
+func (m *MultiReader) releaseToken(token interface{}) {
+	releaseToken(m.Processor, m.log, token)
+}
+
+// order returns the readers in the order they must be polled according to the
+// strategy of the MultiReader.
+func (m *MultiReader) order() []*Reader {
+	if m.strategy != StrategyWeighted {
+		return m.readers
+	}
+	readers := append([]*Reader{}, m.readers...)
+	weights := append([]int{}, m.weights...)
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+	var order []*Reader
+	for len(readers) > 0 {
+		n := m.rand.Intn(total)
+		i := 0
+		for ; n >= weights[i]; i++ {
+			n -= weights[i]
+		}
+		order = append(order, readers[i])
+		total -= weights[i]
+		readers = append(readers[:i], readers[i+1:]...)
+		weights = append(weights[:i], weights[i+1:]...)
+	}
+	return order
+}
+
+func (m *MultiReader) processing() uint32 {
+	var n uint32
+	for _, r := range m.readers {
+		n += atomic.LoadUint32(&r.nProcessingMessages)
+	}
+	return n
+}
+
+// ProcessingMessages returns the number of messages read by the MultiReader
+// that are being processed.
+func (m *MultiReader) ProcessingMessages() int {
+	return int(m.processing())
+}
+
+func (m *MultiReader) setLastMessageReceived(t *time.Time) {
+	m.Lock()
+	m.lastMessageReceived = t
+	m.Unlock()
+}
+
+// LastMessageReceived returns the time where the last message was received by
+// the MultiReader. If no message was received so far it returns nil.
+func (m *MultiReader) LastMessageReceived() *time.Time {
+	m.RLock()
+	defer m.RUnlock()
+	return m.lastMessageReceived
+}
@@ -0,0 +1,316 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package sqs
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/queue"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/google/go-cmp/cmp"
+)
+
+func newInMemReader(msgs ...string) *Reader {
+	mem := &InMemSQS{Mutex: &sync.Mutex{}}
+	for _, m := range msgs {
+		mem.Msgs = append(mem.Msgs, sqs.ReceiveMessageOutput{
+			Messages: []*sqs.Message{
+				{
+					Body:          strToPtr(m),
+					MessageId:     strToPtr(m),
+					ReceiptHandle: strToPtr(m),
+				},
+			},
+		})
+	}
+	return &Reader{
+		RWMutex:       &sync.RWMutex{},
+		sqs:           mem,
+		receiveParams: sqs.ReceiveMessageInput{},
+		log:           &log.NullLog{},
+		wg:            &sync.WaitGroup{},
+	}
+}
+
+func TestMultiReader_readMessagesStrict(t *testing.T) {
+	high := newInMemReader("high")
+	low := newInMemReader("low")
+	m := &MultiReader{
+		RWMutex:  &sync.RWMutex{},
+		readers:  []*Reader{high, low},
+		weights:  []int{1, 1},
+		strategy: StrategyStrict,
+		log:      &log.NullLog{},
+	}
+	for _, want := range []string{"high", "low"} {
+		_, msgs, _, err := m.readMessages(context.Background(), 0)
+		if err != nil {
+			t.Fatalf("unexpected error %+v", err)
+		}
+		if len(msgs) != 1 || *msgs[0].Body != want {
+			t.Fatalf("want message %s, got %v", want, msgs)
+		}
+	}
+	if m.LastMessageReceived() == nil {
+		t.Fatalf("last message received not set")
+	}
+}
+
+// newEmptyReader returns a reader of a queue that is empty for the given
+// number of receives of all the queues, counted in receives, and has a
+// message afterwards. The name of the queue is added to longPolls on every
+// receive that waits for messages.
+func newEmptyReader(name string, receives *int, empty int, longPolls *[]string) *Reader {
+	return &Reader{
+		RWMutex: &sync.RWMutex{},
+		sqs: &SqsMock{
+			MessageReceiver: func(ctx context.Context, input *sqs.ReceiveMessageInput, options ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+				*receives++
+				if *input.WaitTimeSeconds > 0 {
+					*longPolls = append(*longPolls, name)
+				}
+				if *receives <= empty {
+					return &sqs.ReceiveMessageOutput{}, nil
+				}
+				return &sqs.ReceiveMessageOutput{
+					Messages: []*sqs.Message{
+						{Body: aws.String(name), MessageId: aws.String(name), ReceiptHandle: aws.String(name)},
+					},
+				}, nil
+			},
+		},
+		receiveParams: sqs.ReceiveMessageInput{QueueUrl: aws.String(name)},
+		log:           &log.NullLog{},
+	}
+}
+
+func TestMultiReader_readMessagesLongPoll(t *testing.T) {
+	var (
+		receives  int
+		longPolls []string
+	)
+	// Every round without messages sweeps the two queues and then waits
+	// for messages in one of them, so there are 3 rounds without messages.
+	high := newEmptyReader("high", &receives, 9, &longPolls)
+	low := newEmptyReader("low", &receives, 9, &longPolls)
+	m := &MultiReader{
+		RWMutex:         &sync.RWMutex{},
+		readers:         []*Reader{high, low},
+		weights:         []int{1, 1},
+		strategy:        StrategyStrict,
+		poolingInterval: 1,
+		log:             &log.NullLog{},
+	}
+	_, msgs, _, err := m.readMessages(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if len(msgs) != 1 || *msgs[0].Body != "high" {
+		t.Fatalf("want message high, got %v", msgs)
+	}
+	if diff := cmp.Diff([]string{"high", "low", "high"}, longPolls); diff != "" {
+		t.Errorf("want long polled queues != got long polled queues, diff: %s", diff)
+	}
+}
+
+func TestMultiReader_readMessagesBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		backoff time.Duration
+		minWait time.Duration
+		maxWait time.Duration
+	}{
+		{
+			name:    "Disabled",
+			maxWait: 100 * time.Millisecond,
+		},
+		{
+			name:    "Enabled",
+			backoff: 300 * time.Millisecond,
+			minWait: 300 * time.Millisecond,
+			maxWait: time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				receives  int
+				longPolls []string
+			)
+			// The first round, two sweeps and a long poll, is empty.
+			high := newEmptyReader("high", &receives, 3, &longPolls)
+			high.backoffMin = tt.backoff
+			high.backoffMax = tt.backoff
+			low := newEmptyReader("low", &receives, 3, &longPolls)
+			m := &MultiReader{
+				RWMutex:         &sync.RWMutex{},
+				readers:         []*Reader{high, low},
+				weights:         []int{1, 1},
+				strategy:        StrategyStrict,
+				poolingInterval: 1,
+				log:             &log.NullLog{},
+			}
+			start := time.Now()
+			_, msgs, _, err := m.readMessages(context.Background(), 0)
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			if len(msgs) != 1 {
+				t.Fatalf("want 1 message, got %v", msgs)
+			}
+			if d := time.Since(start); d < tt.minWait || d > tt.maxWait {
+				t.Errorf("want a wait between %s and %s, got %s", tt.minWait, tt.maxWait, d)
+			}
+		})
+	}
+}
+
+func TestMultiReader_orderWeighted(t *testing.T) {
+	a, b, c := newInMemReader(), newInMemReader(), newInMemReader()
+	m := &MultiReader{
+		readers:  []*Reader{a, b, c},
+		weights:  []int{8, 1, 1},
+		strategy: StrategyWeighted,
+		rand:     rand.New(rand.NewSource(1)),
+	}
+	first := map[*Reader]int{}
+	for i := 0; i < 1000; i++ {
+		order := m.order()
+		if len(order) != 3 {
+			t.Fatalf("want 3 readers in the order, got %d", len(order))
+		}
+		seen := map[*Reader]bool{}
+		for _, r := range order {
+			seen[r] = true
+		}
+		if len(seen) != 3 {
+			t.Fatalf("order contains duplicated readers")
+		}
+		first[order[0]]++
+	}
+	if first[a] < first[b] || first[a] < first[c] {
+		t.Fatalf("reader with higher weight is not polled first more often: %v", first)
+	}
+}
+
+func TestMultiReader_read(t *testing.T) {
+	tests := []struct {
+		name            string
+		receive         func(n int, cancel func()) (*sqs.ReceiveMessageOutput, error)
+		maxTimeNoRead   *queue.IdleLimit
+		wantErr         error
+		wantMaxMessages []int64
+		wantProcessed   int
+	}{
+		{
+			name: "ReadsBatches",
+			receive: func(n int, cancel func()) (*sqs.ReceiveMessageOutput, error) {
+				if n > 1 {
+					cancel()
+					return nil, context.Canceled
+				}
+				return &sqs.ReceiveMessageOutput{
+					Messages: []*sqs.Message{
+						{Body: aws.String("1"), MessageId: aws.String("1"), ReceiptHandle: aws.String("1")},
+						{Body: aws.String("2"), MessageId: aws.String("2"), ReceiptHandle: aws.String("2")},
+					},
+				}, nil
+			},
+			wantErr:         context.Canceled,
+			wantMaxMessages: []int64{3, 2},
+			wantProcessed:   2,
+		},
+		{
+			name: "GivesBackTokensOnError",
+			receive: func(n int, cancel func()) (*sqs.ReceiveMessageOutput, error) {
+				return nil, ErrMockError
+			},
+			wantErr:         ErrMockError,
+			wantMaxMessages: []int64{3},
+		},
+		{
+			name: "GivesBackTokensOnMaxTimeNoRead",
+			receive: func(n int, cancel func()) (*sqs.ReceiveMessageOutput, error) {
+				return &sqs.ReceiveMessageOutput{}, nil
+			},
+			maxTimeNoRead:   queue.NewIdleLimit(time.Nanosecond, 0),
+			wantErr:         queue.ErrMaxTimeNoRead,
+			wantMaxMessages: []int64{3},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			tokens := make(chan interface{}, 4)
+			for i := 0; i < 4; i++ {
+				tokens <- i
+			}
+			p := &messageProcessorMock{
+				tokens: tokens,
+				processMessage: func(m queue.Message, token interface{}) <-chan bool {
+					processed := make(chan bool, 1)
+					processed <- true
+					return processed
+				},
+			}
+			var maxMessages []int64
+			r := &Reader{
+				RWMutex: &sync.RWMutex{},
+				sqs: &SqsMock{
+					MessageReceiver: func(ctx context.Context, input *sqs.ReceiveMessageInput, options ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+						maxMessages = append(maxMessages, *input.MaxNumberOfMessages)
+						return tt.receive(len(maxMessages), cancel)
+					},
+					MessageVisibilityChanger: func(input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+						return &sqs.ChangeMessageVisibilityOutput{}, nil
+					},
+					MessageDeleter: func(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+						return &sqs.DeleteMessageOutput{}, nil
+					},
+				},
+				visibilityTimeout:     30,
+				processMessageQuantum: 20,
+				receiveParams:         sqs.ReceiveMessageInput{QueueUrl: aws.String("queue")},
+				wg:                    &sync.WaitGroup{},
+				log:                   &log.NullLog{},
+				Processor:             p,
+				maxMessages:           3,
+			}
+			m := &MultiReader{
+				RWMutex:       &sync.RWMutex{},
+				readers:       []*Reader{r},
+				weights:       []int{1},
+				strategy:      StrategyStrict,
+				maxTimeNoRead: tt.maxTimeNoRead,
+				log:           &log.NullLog{},
+				Processor:     p,
+			}
+			if err := <-m.StartReading(ctx); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("want error %v, got %v", tt.wantErr, err)
+			}
+			if diff := cmp.Diff(tt.wantMaxMessages, maxMessages); diff != "" {
+				t.Errorf("want max number of messages != got max number of messages, diff: %s", diff)
+			}
+			if len(p.Messages) != tt.wantProcessed {
+				t.Errorf("want %d messages processed, got %d", tt.wantProcessed, len(p.Messages))
+			}
+			// The mock processor doesn't give back the tokens of the
+			// messages it processes.
+			if want := 4 - tt.wantProcessed; len(tokens) != want {
+				t.Errorf("want %d free tokens, got %d", want, len(tokens))
+			}
+		})
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/adevinta/vulcan-agent/awscreds"
 	"github.com/adevinta/vulcan-agent/config"
 	"github.com/adevinta/vulcan-agent/log"
 	"github.com/adevinta/vulcan-agent/queue"
@@ -20,13 +21,20 @@ import (
 	"github.com/aws/aws-sdk-go/aws/arn"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/request"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 )
 
 const (
 	MaxQuantumDelta = 3 // in seconds
+	// MaxWaitTime is the max time, in seconds, a receive call can wait for
+	// messages.
+	MaxWaitTime = 20
+	// MaxMessages is the max number of messages a receive call can return.
+	MaxMessages = 10
+	// DefaultBackoffMin is the initial wait between empty receives when only
+	// the max backoff is configured.
+	DefaultBackoffMin = config.DefaultSQSBackoffMinMs * time.Millisecond
 )
 
 type Reader struct {
@@ -39,20 +47,48 @@ type Reader struct {
 	wg                    *sync.WaitGroup
 	lastMessageReceived   *time.Time
 	log                   log.Logger
-	maxTimeNoRead         *time.Duration
+	maxTimeNoRead         *queue.IdleLimit
 	Processor             queue.MessageProcessor
 	nProcessingMessages   uint32
+	dlq                   *deadLetter
+	// waitTime, if greater than 0, is the time, in seconds, that every
+	// receive call waits for messages.
+	waitTime    int
+	maxMessages int
+	backoffMin  time.Duration
+	backoffMax  time.Duration
+	// groups is only set when reading from a FIFO queue.
+	groups *groups
+}
+
+func init() {
+	queue.Register(config.QueueTypeSQS, func(l log.Logger, cfg config.Config, maxTimeNoRead *queue.IdleLimit, processor queue.MessageProcessor) (queue.Reader, error) {
+		r, err := NewReader(l, cfg.SQSReader, maxTimeNoRead, processor)
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
+	})
 }
 
 // NewReader creates a new Reader with the given processor, queueARN and config.
-func NewReader(log log.Logger, cfg config.SQSReader, maxTimeNoRead *time.Duration, processor queue.MessageProcessor) (*Reader, error) {
+func NewReader(log log.Logger, cfg config.SQSReader, maxTimeNoRead *queue.IdleLimit, processor queue.MessageProcessor) (*Reader, error) {
 	delta := cfg.VisibilityTimeout - cfg.ProcessQuantum
 	if delta < MaxQuantumDelta {
 		err := errors.New("difference between visibility timeout and quantum is too short")
 		return nil, err
 	}
+	if cfg.WaitTimeSeconds < 0 || cfg.WaitTimeSeconds > MaxWaitTime {
+		return nil, fmt.Errorf("wait time must be between 0 and %d seconds, got %d", MaxWaitTime, cfg.WaitTimeSeconds)
+	}
+	if cfg.MaxMessages < 0 || cfg.MaxMessages > MaxMessages {
+		return nil, fmt.Errorf("max messages must be between 1 and %d, got %d", MaxMessages, cfg.MaxMessages)
+	}
+	if cfg.BackoffMinMs < 0 || cfg.BackoffMaxMs < 0 || (cfg.BackoffMaxMs > 0 && cfg.BackoffMinMs > cfg.BackoffMaxMs) {
+		return nil, fmt.Errorf("invalid receive backoff, min %dms, max %dms", cfg.BackoffMinMs, cfg.BackoffMaxMs)
+	}
 	var consumer *Reader
-	sess, err := session.NewSession()
+	sess, err := awscreds.NewSession(cfg.Credentials)
 	if err != nil {
 		err = fmt.Errorf("error creating AWSSSession, %w", err)
 		return consumer, err
@@ -90,6 +126,18 @@ func NewReader(log log.Logger, cfg config.SQSReader, maxTimeNoRead *time.Duratio
 		VisibilityTimeout:   aws.Int64(int64(cfg.VisibilityTimeout)),
 		AttributeNames:      []*string{aws.String("ApproximateReceiveCount")},
 	}
+	var groups *groups
+	if isFIFO(arn.Resource) {
+		receiveParams.AttributeNames = append(receiveParams.AttributeNames, aws.String(sqs.MessageSystemAttributeNameMessageGroupId))
+		groups = newGroups()
+	}
+	var dlq *deadLetter
+	if cfg.DLQARN != "" {
+		dlq, err = newDeadLetter(sess, cfg.DLQARN, cfg.Endpoint, cfg.MaxReceiveCount)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return &Reader{
 		RWMutex:               &sync.RWMutex{},
 		Processor:             processor,
@@ -103,9 +151,27 @@ func NewReader(log log.Logger, cfg config.SQSReader, maxTimeNoRead *time.Duratio
 		maxTimeNoRead:         maxTimeNoRead,
 		lastMessageReceived:   nil,
 		nProcessingMessages:   0,
+		dlq:                   dlq,
+		waitTime:              cfg.WaitTimeSeconds,
+		maxMessages:           cfg.MaxMessages,
+		backoffMin:            time.Duration(cfg.BackoffMinMs) * time.Millisecond,
+		backoffMax:            time.Duration(cfg.BackoffMaxMs) * time.Millisecond,
+		groups:                groups,
 	}, nil
 }
 
+// Ping checks that the queue the Reader reads from is accessible.
+func (r *Reader) Ping(ctx context.Context) error {
+	_, err := r.sqs.GetQueueAttributesWithContext(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       r.receiveParams.QueueUrl,
+		AttributeNames: []*string{aws.String(sqs.QueueAttributeNameApproximateNumberOfMessages)},
+	})
+	if err != nil {
+		return fmt.Errorf("error accessing queue %s: %w", aws.StringValue(r.receiveParams.QueueUrl), err)
+	}
+	return nil
+}
+
 // StartReading starts reading messages from the sqs queue. It reads messages
 // only when there are free tokens in the message processor. It will stop
 // reading from the queue when the passed in context is canceled. The caller can
@@ -126,8 +192,8 @@ func (r *Reader) StartReading(ctx context.Context) <-chan error {
 
 func (r *Reader) read(ctx context.Context, done chan<- error) {
 	var (
-		err error
-		msg *sqs.Message
+		err  error
+		msgs []*sqs.Message
 	)
 loop:
 	for {
@@ -136,7 +202,12 @@ loop:
 			err = ctx.Err()
 			break loop
 		case token := <-r.Processor.FreeTokens():
-			msg, err = r.readMessage(ctx)
+			var tokens []interface{}
+			msgs, tokens, err = r.readMessages(ctx, token, r.maxMessages)
+			// Give back the tokens not used to process a message.
+			for _, t := range tokens[len(msgs):] {
+				r.releaseToken(t)
+			}
 			if err == queue.ErrMaxTimeNoRead {
 				r.log.Infof("reader stopped because max time without reading messages elapsed")
 				break loop
@@ -144,48 +215,108 @@ loop:
 			if err != nil {
 				break loop
 			}
-			r.wg.Add(1)
-			atomic.AddUint32(&r.nProcessingMessages, 1)
-			go r.processAndTrack(msg, token)
+			for i, msg := range msgs {
+				r.wg.Add(1)
+				atomic.AddUint32(&r.nProcessingMessages, 1)
+				go r.processAndTrack(ctx, msg, tokens[i], r.groups.join(msg))
+			}
 		}
 	}
 	done <- err
 	close(done)
 }
 
-func (r *Reader) readMessage(ctx context.Context) (*sqs.Message, error) {
-	var msg *sqs.Message
-	waitTime := int64(0)
+// readMessages polls the queue, holding the given token, until it gets at
+// least one message. Every receive asks for as many messages as tokens the
+// reader holds, up to max, so no message is received that can't be processed
+// right away: before each receive the reader takes, without blocking, the
+// free tokens it can, and after an empty one it gives back all of them but
+// the first. It returns the messages received and the tokens held, that are
+// at least as many as messages. After each empty receive it waits the
+// receive backoff, that grows while the queue is empty, divided by the number
+// of tokens held in that receive, so the reader polls more often the more
+// messages it can read.
+func (r *Reader) readMessages(ctx context.Context, token interface{}, max int) ([]*sqs.Message, []interface{}, error) {
+	waitTime := int64(r.waitTime)
 	start := time.Now()
+	tokens := []interface{}{token}
+	if max < 1 {
+		max = 1
+	}
+	var backoff time.Duration
 	for {
-		r.receiveParams.WaitTimeSeconds = &waitTime
-
-		resp, err := r.sqs.ReceiveMessageWithContext(ctx, &r.receiveParams)
+		tokens = append(tokens, queue.TakeTokens(r.Processor, max-len(tokens))...)
+		msgs, err := r.receiveMessages(ctx, waitTime, int64(len(tokens)))
 		if err != nil {
-			if errors.Is(err, context.Canceled) {
-				return nil, err
-			}
-			if aerr, ok := err.(awserr.Error); ok && aerr.Code() == request.CanceledErrorCode {
-				return nil, context.Canceled
-			}
-			return nil, err
+			return nil, tokens, err
 		}
-		if len(resp.Messages) > 0 {
-			msg = resp.Messages[0]
-			break
+		if len(msgs) > 0 {
+			now := time.Now()
+			r.setLastMessageReceived(&now)
+			return msgs, tokens, nil
 		}
+		held := len(tokens)
+		for _, t := range tokens[1:] {
+			r.releaseToken(t)
+		}
+		tokens = tokens[:1]
 		// Check if we need to stop the reader because more than expected time has passed
 		// and no more checks are running.
 		now := time.Now()
 		n := atomic.LoadUint32(&r.nProcessingMessages)
-		if r.maxTimeNoRead != nil && now.Sub(start) > *r.maxTimeNoRead && n == 0 {
-			return nil, queue.ErrMaxTimeNoRead
+		if r.maxTimeNoRead.Exceeded(now.Sub(start)) && n == 0 {
+			return nil, tokens, queue.ErrMaxTimeNoRead
+		}
+		if r.waitTime == 0 {
+			waitTime = int64(r.poolingInterval)
+		}
+		backoff = r.nextBackoff(backoff)
+		if backoff == 0 {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil, tokens, ctx.Err()
+		case <-time.After(backoff / time.Duration(held)):
 		}
-		waitTime = int64(r.poolingInterval)
 	}
-	now := time.Now()
-	r.setLastMessageReceived(&now)
-	return msg, nil
+}
+
+// nextBackoff returns the wait after an empty receive given the previous one.
+func (r *Reader) nextBackoff(prev time.Duration) time.Duration {
+	if r.backoffMax <= 0 {
+		return 0
+	}
+	next := 2 * prev
+	if prev == 0 {
+		next = r.backoffMin
+		if next <= 0 {
+			next = DefaultBackoffMin
+		}
+	}
+	if next > r.backoffMax {
+		next = r.backoffMax
+	}
+	return next
+}
+
+// receiveMessages executes one receive message call against the queue,
+// waiting the given number of seconds for messages to be available, and
+// returns up to max messages.
+func (r *Reader) receiveMessages(ctx context.Context, waitTime, max int64) ([]*sqs.Message, error) {
+	r.receiveParams.WaitTimeSeconds = &waitTime
+	r.receiveParams.MaxNumberOfMessages = &max
+	resp, err := r.sqs.ReceiveMessageWithContext(ctx, &r.receiveParams)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return nil, err
+		}
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == request.CanceledErrorCode {
+			return nil, context.Canceled
+		}
+		return nil, err
+	}
+	return resp.Messages, nil
 }
 
 func (r *Reader) setLastMessageReceived(t *time.Time) {
@@ -194,7 +325,17 @@ func (r *Reader) setLastMessageReceived(t *time.Time) {
 	r.Unlock()
 }
 
-func (r *Reader) processAndTrack(msg *sqs.Message, token interface{}) {
+// processAndTrack processes a message, keeping it invisible in the queue
+// while it's being processed, and deletes it from the queue when the
+// processor signals it. The messages that are not deleted while the reader is
+// stopping, e.g. the ones of the checks stopped because the agent is
+// shutting down, are made visible again immediately so other agents can
+// process them. When a dead letter queue is configured, the messages that
+// can't be parsed or that were received too many times are moved to it
+// without being processed. The messages of FIFO queues are not processed
+// until their turn in their message group arrives.
+func (r *Reader) processAndTrack(ctx context.Context, msg *sqs.Message, token interface{}, t *turn) {
+	defer t.done()
 	defer func() {
 		// Decrement the number of messages being processed, see:
 		// https://golang.org/src/sync/atomic/doc.go?s=3841:3896#L87
@@ -203,11 +344,13 @@ func (r *Reader) processAndTrack(msg *sqs.Message, token interface{}) {
 	}()
 	if msg == nil {
 		r.log.Errorf("cannot process nil message")
+		r.releaseToken(token)
 		return
 	}
 	err := validateSQSMessage(msg)
 	if err != nil {
 		r.log.Errorf("error %+v", err)
+		r.releaseToken(token)
 		if msg.ReceiptHandle == nil {
 			r.log.Errorf("cannot delete invalid message, receipt handle is empty")
 			return
@@ -231,43 +374,57 @@ func (r *Reader) processAndTrack(msg *sqs.Message, token interface{}) {
 		}
 	}
 	m.TimesRead = n
+	if reason := r.poisoned(m); reason != "" && r.quarantine(msg, n, reason) {
+		r.releaseToken(token)
+		return
+	}
+	l := r.lease(msg)
+	if err := t.wait(ctx); err != nil {
+		l.release()
+		r.releaseToken(token)
+		r.release(msg)
+		return
+	}
 	processed := r.Processor.ProcessMessage(m, token)
-	timer := time.NewTimer(time.Duration(r.processMessageQuantum) * time.Second)
-loop:
-	for {
-		select {
-		case <-timer.C:
-			extime := int64(r.visibilityTimeout)
-			input := &sqs.ChangeMessageVisibilityInput{
-				QueueUrl:          r.receiveParams.QueueUrl,
-				ReceiptHandle:     msg.ReceiptHandle,
-				VisibilityTimeout: &extime,
-			}
-			_, err := r.sqs.ChangeMessageVisibility(input)
-			if err != nil {
-				r.log.Errorf("extending message visibility time for message with id: %s, error: %+v", *msg.MessageId, err)
-				break loop
-			}
-			timer.Reset(time.Duration(r.processMessageQuantum) * time.Second)
-		case delete := <-processed:
-			timer.Stop()
-			if !delete {
-				r.log.Errorf("unexpected error processing message with id: %s, message not deleted", *msg.MessageId)
-				break loop
-			}
-			r.log.Infof("deleting message with id %s", *msg.MessageId)
-			input := &sqs.DeleteMessageInput{
-				QueueUrl:      r.receiveParams.QueueUrl,
-				ReceiptHandle: msg.ReceiptHandle,
-			}
-			_, err := r.sqs.DeleteMessage(input)
-			if err != nil {
-				r.log.Errorf("deleting message with id: %s, error: %+v", *msg.MessageId, err)
-				break loop
-			}
-			break loop
-		}
+	delete := <-processed
+	l.release()
+	if !delete && ctx.Err() != nil {
+		r.release(msg)
+		return
+	}
+	if !delete {
+		r.log.Errorf("unexpected error processing message with id: %s, message not deleted", *msg.MessageId)
+		return
+	}
+	r.log.Infof("deleting message with id %s", *msg.MessageId)
+	input := &sqs.DeleteMessageInput{
+		QueueUrl:      r.receiveParams.QueueUrl,
+		ReceiptHandle: msg.ReceiptHandle,
 	}
+	_, err = r.sqs.DeleteMessage(input)
+	if err != nil {
+		r.log.Errorf("deleting message with id: %s, error: %+v", *msg.MessageId, err)
+	}
+}
+
+// release makes the message visible again in the queue.
+func (r *Reader) release(msg *sqs.Message) {
+	input := &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          r.receiveParams.QueueUrl,
+		ReceiptHandle:     msg.ReceiptHandle,
+		VisibilityTimeout: aws.Int64(0),
+	}
+	if _, err := r.sqs.ChangeMessageVisibility(input); err != nil {
+		r.log.Errorf("returning message with id %s to the queue, error: %+v", *msg.MessageId, err)
+		return
+	}
+	r.log.Infof("message with id %s returned to the queue", *msg.MessageId)
+}
+
+// ProcessingMessages returns the number of messages read by the Reader that
+// are being processed.
+func (r *Reader) ProcessingMessages() int {
+	return int(atomic.LoadUint32(&r.nProcessingMessages))
 }
 
 // LastMessageReceived returns the time where the last message was received by
@@ -14,6 +14,7 @@ import (
 
 	"github.com/adevinta/vulcan-agent/log"
 	"github.com/adevinta/vulcan-agent/queue"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
@@ -28,6 +29,7 @@ type SqsMock struct {
 	MessageVisibilityChanger func(input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error)
 	MessageReceiver          func(ctx context.Context, input *sqs.ReceiveMessageInput, options ...request.Option) (*sqs.ReceiveMessageOutput, error)
 	MessageDeleter           func(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error)
+	MessageSender            func(input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error)
 }
 
 func (sq *SqsMock) ChangeMessageVisibility(input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
@@ -42,6 +44,10 @@ func (sq *SqsMock) DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMess
 	return sq.MessageDeleter(input)
 }
 
+func (sq *SqsMock) SendMessage(input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+	return sq.MessageSender(input)
+}
+
 type InMemSQS struct {
 	*sync.Mutex
 	sqsiface.SQSAPI
@@ -101,6 +107,7 @@ func (sq *InMemSQS) DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMes
 }
 
 type messageProcessorMock struct {
+	mu             sync.Mutex
 	tokens         chan interface{}
 	freeTokens     func() chan interface{}
 	Messages       []queue.Message
@@ -115,7 +122,9 @@ func (mp *messageProcessorMock) FreeTokens() chan interface{} {
 }
 
 func (mp *messageProcessorMock) ProcessMessage(m queue.Message, token interface{}) <-chan bool {
+	mp.mu.Lock()
 	mp.Messages = append(mp.Messages, m)
+	mp.mu.Unlock()
 	return mp.processMessage(m, token)
 }
 
@@ -132,7 +141,7 @@ func TestReader_StartReading(t *testing.T) {
 		lastMessageReceived   *time.Time
 		log                   log.Logger
 		Processor             queue.MessageProcessor
-		maxTimeNoRead         *time.Duration
+		maxTimeNoRead         *queue.IdleLimit
 	}
 
 	tests := []struct {
@@ -397,7 +406,7 @@ func TestReader_StartReading(t *testing.T) {
 						return res
 					},
 				},
-				maxTimeNoRead: durationToPtr(time.Duration(2) * time.Second),
+				maxTimeNoRead: queue.NewIdleLimit(2*time.Second, 0),
 			},
 			runCtxProvider: func() context.Context {
 				return context.Background()
@@ -445,6 +454,237 @@ func intToPtr(in int64) *int64 {
 	return &in
 }
 
-func durationToPtr(t time.Duration) *time.Duration {
-	return &t
+func TestReader_processAndTrackReleasesMessagesWhenStopping(t *testing.T) {
+	tests := []struct {
+		name        string
+		stopped     bool
+		wantChanges []sqs.ChangeMessageVisibilityInput
+	}{
+		{
+			name:    "Stopping",
+			stopped: true,
+			wantChanges: []sqs.ChangeMessageVisibilityInput{
+				{
+					QueueUrl:          aws.String("queue"),
+					ReceiptHandle:     aws.String("handle"),
+					VisibilityTimeout: aws.Int64(0),
+				},
+			},
+		},
+		{
+			name: "Running",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var changes []sqs.ChangeMessageVisibilityInput
+			r := &Reader{
+				RWMutex: &sync.RWMutex{},
+				sqs: &SqsMock{
+					MessageVisibilityChanger: func(input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+						changes = append(changes, *input)
+						return &sqs.ChangeMessageVisibilityOutput{}, nil
+					},
+				},
+				processMessageQuantum: 60,
+				receiveParams:         sqs.ReceiveMessageInput{QueueUrl: aws.String("queue")},
+				wg:                    &sync.WaitGroup{},
+				log:                   &log.NullLog{},
+				Processor: &messageProcessorMock{
+					processMessage: func(m queue.Message, token interface{}) <-chan bool {
+						processed := make(chan bool, 1)
+						processed <- false
+						return processed
+					},
+				},
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			if tt.stopped {
+				cancel()
+			}
+			defer cancel()
+			r.wg.Add(1)
+			r.processAndTrack(ctx, &sqs.Message{
+				Body:          aws.String("{}"),
+				MessageId:     aws.String("id"),
+				ReceiptHandle: aws.String("handle"),
+			}, nil, nil)
+			if diff := cmp.Diff(tt.wantChanges, changes); diff != "" {
+				t.Errorf("want visibility changes != got visibility changes, diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestReader_readBatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var maxMessages []int64
+	tokens := make(chan interface{}, 4)
+	for i := 0; i < 4; i++ {
+		tokens <- i
+	}
+	p := &messageProcessorMock{
+		tokens: tokens,
+		processMessage: func(m queue.Message, token interface{}) <-chan bool {
+			processed := make(chan bool, 1)
+			processed <- true
+			return processed
+		},
+	}
+	r := &Reader{
+		RWMutex: &sync.RWMutex{},
+		sqs: &SqsMock{
+			MessageReceiver: func(ctx context.Context, input *sqs.ReceiveMessageInput, options ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+				maxMessages = append(maxMessages, *input.MaxNumberOfMessages)
+				if len(maxMessages) > 1 {
+					cancel()
+					return nil, context.Canceled
+				}
+				return &sqs.ReceiveMessageOutput{
+					Messages: []*sqs.Message{
+						{Body: aws.String("1"), MessageId: aws.String("1"), ReceiptHandle: aws.String("1")},
+						{Body: aws.String("2"), MessageId: aws.String("2"), ReceiptHandle: aws.String("2")},
+					},
+				}, nil
+			},
+			MessageVisibilityChanger: func(input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+				return &sqs.ChangeMessageVisibilityOutput{}, nil
+			},
+			MessageDeleter: func(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+				return &sqs.DeleteMessageOutput{}, nil
+			},
+		},
+		visibilityTimeout:     30,
+		processMessageQuantum: 20,
+		receiveParams:         sqs.ReceiveMessageInput{QueueUrl: aws.String("queue")},
+		wg:                    &sync.WaitGroup{},
+		log:                   &log.NullLog{},
+		Processor:             p,
+		maxMessages:           3,
+	}
+	if err := <-r.StartReading(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("want error %v, got %v", context.Canceled, err)
+	}
+	if diff := cmp.Diff([]int64{3, 2}, maxMessages); diff != "" {
+		t.Errorf("want max number of messages != got max number of messages, diff: %s", diff)
+	}
+	if len(p.Messages) != 2 {
+		t.Errorf("want 2 messages processed, got %d", len(p.Messages))
+	}
+	if len(tokens) != 2 {
+		t.Errorf("want 2 free tokens, got %d", len(tokens))
+	}
+}
+
+func TestReader_readMessagesBackoff(t *testing.T) {
+	tests := []struct {
+		name       string
+		freeTokens int
+		minWait    time.Duration
+		maxWait    time.Duration
+	}{
+		{
+			name:       "OneToken",
+			freeTokens: 0,
+			minWait:    400 * time.Millisecond,
+			maxWait:    time.Second,
+		},
+		{
+			name:       "FourTokens",
+			freeTokens: 3,
+			minWait:    100 * time.Millisecond,
+			maxWait:    300 * time.Millisecond,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tokens := make(chan interface{}, 4)
+			for i := 0; i < tt.freeTokens; i++ {
+				tokens <- i
+			}
+			var (
+				receives []time.Time
+				held     []int64
+			)
+			r := &Reader{
+				RWMutex: &sync.RWMutex{},
+				sqs: &SqsMock{
+					MessageReceiver: func(ctx context.Context, input *sqs.ReceiveMessageInput, options ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+						receives = append(receives, time.Now())
+						held = append(held, *input.MaxNumberOfMessages)
+						if len(receives) == 1 {
+							return &sqs.ReceiveMessageOutput{}, nil
+						}
+						return &sqs.ReceiveMessageOutput{
+							Messages: []*sqs.Message{
+								{Body: aws.String("1"), MessageId: aws.String("1"), ReceiptHandle: aws.String("1")},
+							},
+						}, nil
+					},
+				},
+				receiveParams: sqs.ReceiveMessageInput{QueueUrl: aws.String("queue")},
+				log:           &log.NullLog{},
+				Processor:     &messageProcessorMock{tokens: tokens},
+				backoffMin:    400 * time.Millisecond,
+				backoffMax:    400 * time.Millisecond,
+			}
+			_, _, err := r.readMessages(context.Background(), "token", 4)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(receives) != 2 {
+				t.Fatalf("want 2 receives, got %d", len(receives))
+			}
+			if want := int64(tt.freeTokens + 1); held[0] != want {
+				t.Errorf("want %d messages in the first receive, got %d", want, held[0])
+			}
+			wait := receives[1].Sub(receives[0])
+			if wait < tt.minWait || wait > tt.maxWait {
+				t.Errorf("want a backoff between %v and %v, got %v", tt.minWait, tt.maxWait, wait)
+			}
+		})
+	}
+}
+
+func TestReader_nextBackoff(t *testing.T) {
+	tests := []struct {
+		name       string
+		backoffMin time.Duration
+		backoffMax time.Duration
+		want       []time.Duration
+	}{
+		{
+			name: "Disabled",
+			want: []time.Duration{0, 0, 0},
+		},
+		{
+			name:       "Grows",
+			backoffMin: 100 * time.Millisecond,
+			backoffMax: time.Second,
+			want:       []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second},
+		},
+		{
+			name:       "DefaultMin",
+			backoffMax: 3 * time.Second,
+			want:       []time.Duration{time.Second, 2 * time.Second, 3 * time.Second},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Reader{backoffMin: tt.backoffMin, backoffMax: tt.backoffMax}
+			var (
+				got     []time.Duration
+				backoff time.Duration
+			)
+			for range tt.want {
+				backoff = r.nextBackoff(backoff)
+				got = append(got, backoff)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("want backoffs != got backoffs, diff: %s", diff)
+			}
+		})
+	}
 }
@@ -9,25 +9,36 @@ import (
 	"fmt"
 	"sync"
 
+	"github.com/adevinta/vulcan-agent/awscreds"
+	"github.com/adevinta/vulcan-agent/config"
 	"github.com/adevinta/vulcan-agent/log"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/arn"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/google/uuid"
 )
 
+// DefaultMessageGroup is the message group of the messages written to a FIFO
+// queue without a group.
+const DefaultMessageGroup = "vulcan-agent"
+
 // Writer writes messages to and AWS SQS queue.
 type Writer struct {
 	*sync.RWMutex
 	sqs      sqsiface.SQSAPI
 	queueURL string
+	fifo     bool
+	// ContentBasedDedup must be set when the queue is a FIFO queue with
+	// content based deduplication enabled, so the Writer doesn't generate a
+	// deduplication ID for each message.
+	ContentBasedDedup bool
 }
 
 // NewWriter creates a new SQS writer to writer to given queue ARN using the
-// passed in endpoint, or the default one if the it is empty.
-func NewWriter(queueARN string, endpoint string, log log.Logger) (*Writer, error) {
-	sess, err := session.NewSession()
+// passed in endpoint, or the default one if the it is empty, and credentials.
+func NewWriter(queueARN string, endpoint string, creds config.AWSCredentialsConfig, log log.Logger) (*Writer, error) {
+	sess, err := awscreds.NewSession(creds)
 	if err != nil {
 		err = fmt.Errorf("creating AWS session %w", err)
 		return nil, err
@@ -65,14 +76,42 @@ func NewWriter(queueARN string, endpoint string, log log.Logger) (*Writer, error
 	return &Writer{
 		queueURL: *resp.QueueUrl,
 		sqs:      sqsSrv,
+		fifo:     isFIFO(arn.Resource),
 	}, nil
 }
 
+// Write writes a message to the queue. If the queue is a FIFO queue the
+// message is written to the DefaultMessageGroup.
 func (w *Writer) Write(body string) error {
+	return w.WriteGroup(DefaultMessageGroup, body)
+}
+
+// WriteGroup writes a message to the queue. If the queue is a FIFO queue the
+// message is written to the given message group, so it's delivered after the
+// messages written before to the same group.
+func (w *Writer) WriteGroup(group, body string) error {
 	msg := &sqs.SendMessageInput{
 		QueueUrl:    &w.queueURL,
 		MessageBody: &body,
 	}
+	msg.MessageGroupId, msg.MessageDeduplicationId = w.fifoIDs(group)
 	_, err := w.sqs.SendMessage(msg)
 	return err
 }
+
+// fifoIDs returns the message group ID and the deduplication ID of a message
+// written to the given group. Both are nil if the queue is not a FIFO queue.
+// The deduplication ID is generated for each message, so only the retries of
+// the same message are discarded by SQS.
+func (w *Writer) fifoIDs(group string) (groupID, dedupID *string) {
+	if !w.fifo {
+		return nil, nil
+	}
+	if group == "" {
+		group = DefaultMessageGroup
+	}
+	if !w.ContentBasedDedup {
+		dedupID = aws.String(uuid.NewString())
+	}
+	return aws.String(group), dedupID
+}
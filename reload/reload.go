@@ -0,0 +1,165 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+// Package reload implements the reloading of the config of the agent while it
+// is running.
+package reload
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+)
+
+// Reloadable contains the keys of the config params that can be changed
+// without restarting the agent.
+var Reloadable = map[string]bool{
+	"agent.log_level":               true,
+	"agent.concurrent_jobs":         true,
+	"agent.timeout":                 true,
+	"agent.max_no_msgs_interval":    true,
+	"check.vars":                    true,
+	"check.checktype_vars":          true,
+	"runtime.docker.registry.pass":  true,
+	"runtime.docker.registry.auths": true,
+}
+
+// ApplyFunc applies the reloadable params of the given config to the running
+// components of the agent.
+type ApplyFunc func(cfg config.Config) error
+
+// Watcher reloads the config file of the agent when it receives a SIGHUP or,
+// optionally, when the file changes.
+type Watcher struct {
+	// Resolve, if not nil, is applied to the configs read before comparing
+	// them with the current one, e.g. to resolve the references to secrets.
+	Resolve func(cfg config.Config) (config.Config, error)
+	// RefreshInterval, if greater than 0, makes the Watcher reload the config
+	// periodically even if the file didn't change, so the values returned by
+	// Resolve are refreshed.
+	RefreshInterval time.Duration
+
+	file     string
+	interval time.Duration
+	current  config.Config
+	apply    ApplyFunc
+	log      log.Logger
+	modTime  time.Time
+}
+
+// NewWatcher creates a Watcher for the given config file. The cfg is the
+// config the agent is currently running with. If interval is greater than 0,
+// the modification time of the file is checked with that period. If the file
+// is empty the config is read only from the environment.
+func NewWatcher(l log.Logger, file string, cfg config.Config, interval time.Duration, apply ApplyFunc) *Watcher {
+	w := &Watcher{
+		file:     file,
+		interval: interval,
+		current:  cfg,
+		apply:    apply,
+		log:      l,
+	}
+	if fi, err := os.Stat(file); err == nil {
+		w.modTime = fi.ModTime()
+	}
+	return w
+}
+
+// Run reloads the config until the given context is canceled.
+func (w *Watcher) Run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	var tick, refresh <-chan time.Time
+	if w.interval > 0 && w.file != "" {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	if w.RefreshInterval > 0 {
+		ticker := time.NewTicker(w.RefreshInterval)
+		defer ticker.Stop()
+		refresh = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			w.log.Infof("SIGHUP received, reloading config file %s", w.file)
+		case <-refresh:
+			w.log.Debugf("refreshing config")
+		case <-tick:
+			fi, err := os.Stat(w.file)
+			if err != nil {
+				w.log.Errorf("error checking config file %s: %+v", w.file, err)
+				continue
+			}
+			if fi.ModTime().Equal(w.modTime) {
+				continue
+			}
+			w.modTime = fi.ModTime()
+			w.log.Infof("config file %s changed, reloading it", w.file)
+		}
+		if err := w.Reload(); err != nil {
+			w.log.Errorf("error reloading config: %+v", err)
+		}
+	}
+}
+
+// Reload reads the config file and applies the changes to the reloadable
+// params. The changes to the rest of the params are ignored and logged, as
+// they require restarting the agent.
+func (w *Watcher) Reload() error {
+	cfg, err := config.ReadConfig(w.file)
+	if err != nil {
+		return err
+	}
+	// The config of the agent has the defaults of the components applied,
+	// so the ones of the new config must be applied too to compare them.
+	cfg = config.Resolve(cfg)
+	if w.Resolve != nil {
+		cfg, err = w.Resolve(cfg)
+		if err != nil {
+			return err
+		}
+	}
+	var immutable []string
+	for _, k := range config.Diff(w.current, cfg) {
+		if !Reloadable[k] {
+			immutable = append(immutable, k)
+		}
+	}
+	if len(immutable) > 0 {
+		w.log.Errorf("config params %s can not be changed without restarting the agent, ignoring their new values",
+			strings.Join(immutable, ", "))
+	}
+	next := w.current
+	next.Agent.LogLevel = cfg.Agent.LogLevel
+	next.Agent.ConcurrentJobs = cfg.Agent.ConcurrentJobs
+	next.Agent.Timeout = cfg.Agent.Timeout
+	next.Agent.MaxNoMsgsInterval = cfg.Agent.MaxNoMsgsInterval
+	next.Check.Vars = cfg.Check.Vars
+	next.Check.ChecktypeVars = cfg.Check.ChecktypeVars
+	next.Runtime.Docker.Registry.Pass = cfg.Runtime.Docker.Registry.Pass
+	next.Runtime.Docker.Registry.Auths = cfg.Runtime.Docker.Registry.Auths
+	changed := config.Diff(w.current, next)
+	if len(changed) == 0 {
+		w.log.Debugf("no reloadable config params changed")
+		return nil
+	}
+	if err := w.apply(next); err != nil {
+		return fmt.Errorf("applying config: %w", err)
+	}
+	w.current = next
+	w.log.Infof("config reloaded, changed params: %s", strings.Join(changed, ", "))
+	return nil
+}
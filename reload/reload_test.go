@@ -0,0 +1,115 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package reload
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/google/go-cmp/cmp"
+)
+
+const initialConfig = `
+[agent]
+log_level = "info"
+concurrent_jobs = 5
+
+[sqs_reader]
+arn = "arn:aws:sqs:eu-west-1:123456789012:checks"
+`
+
+func TestWatcher_Reload(t *testing.T) {
+	tests := []struct {
+		name      string
+		newConfig string
+		want      func(c *config.Config)
+		// wantApply is false when the apply func must not be called.
+		wantApply bool
+	}{
+		{
+			name: "ReloadableParams",
+			newConfig: `
+[agent]
+log_level = "debug"
+concurrent_jobs = 2
+timeout = 10
+
+[sqs_reader]
+arn = "arn:aws:sqs:eu-west-1:123456789012:checks"
+
+[check.vars]
+TOKEN = "token"
+`,
+			want: func(c *config.Config) {
+				c.Agent.LogLevel = "debug"
+				c.Agent.ConcurrentJobs = 2
+				c.Agent.Timeout = 10
+				c.Check.Vars = map[string]string{"TOKEN": "token"}
+			},
+			wantApply: true,
+		},
+		{
+			name: "ImmutableParamsIgnored",
+			newConfig: `
+[agent]
+log_level = "debug"
+concurrent_jobs = 5
+
+[sqs_reader]
+arn = "arn:aws:sqs:eu-west-1:123456789012:other"
+`,
+			want: func(c *config.Config) {
+				c.Agent.LogLevel = "debug"
+			},
+			wantApply: true,
+		},
+		{
+			name: "NoReloadableChanges",
+			newConfig: `
+[agent]
+log_level = "info"
+concurrent_jobs = 5
+
+[sqs_reader]
+arn = "arn:aws:sqs:eu-west-1:123456789012:other"
+`,
+			want: func(c *config.Config) {},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "config.toml")
+			if err := os.WriteFile(file, []byte(initialConfig), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			initial, err := config.ReadConfig(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var applied *config.Config
+			w := NewWatcher(&log.NullLog{}, file, initial, 0, func(cfg config.Config) error {
+				applied = &cfg
+				return nil
+			})
+			if err := os.WriteFile(file, []byte(tt.newConfig), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Reload(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if (applied != nil) != tt.wantApply {
+				t.Fatalf("got applied %v, want applied %v", applied != nil, tt.wantApply)
+			}
+			want := initial
+			tt.want(&want)
+			if diff := cmp.Diff(want, w.current); diff != "" {
+				t.Errorf("want config != got config, diff: %s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package resultcache
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/stateupdater"
+	report "github.com/adevinta/vulcan-report"
+)
+
+// Store defines the shape of the storage used by the Cache to persist the
+// reports.
+type Store interface {
+	Get(key string) ([]byte, bool, error)
+	Set(key string, value []byte, ttl time.Duration) error
+}
+
+// Updater defines the services the Cache uses to publish the results of the
+// checks.
+type Updater interface {
+	UpdateState(stateupdater.CheckState) error
+	UpdateCheckReport(checkID string, startTime time.Time, report report.Report) (string, error)
+}
+
+// Cache stores the reports of the checks that finished successfully so they
+// can be published again as the result of an identical check executed within
+// the configured TTL. It wraps the Updater used by the agent API so it can
+// capture the reports sent by the checks being tracked.
+type Cache struct {
+	updater Updater
+	store   Store
+	ttl     time.Duration
+	log     log.Logger
+	tracked sync.Map
+}
+
+type trackedCheck struct {
+	sync.Mutex
+	key    string
+	report *report.Report
+}
+
+// New returns a Cache that stores the reports in the given store for the
+// given ttl and publishes the results using the given updater.
+func New(l log.Logger, updater Updater, store Store, ttl time.Duration) *Cache {
+	return &Cache{
+		updater: updater,
+		store:   store,
+		ttl:     ttl,
+		log:     l,
+	}
+}
+
+// Track makes the cache store the report of the check with the given ID under
+// the given key if the check finishes successfully.
+func (c *Cache) Track(checkID, key string) {
+	c.tracked.Store(checkID, &trackedCheck{key: key})
+}
+
+// Untrack stops tracking the check with the given ID.
+func (c *Cache) Untrack(checkID string) {
+	c.tracked.Delete(checkID)
+}
+
+// PublishCached publishes, as the result of the check with the given ID, the
+// report stored under the given key, if any. It returns true if a cached
+// report was found and published.
+func (c *Cache) PublishCached(key, checkID string, startTime time.Time) (bool, error) {
+	content, ok, err := c.store.Get(key)
+	if err != nil {
+		return false, fmt.Errorf("error reading result cache: %w", err)
+	}
+	if !ok {
+		return false, nil
+	}
+	var r report.Report
+	if err := json.Unmarshal(content, &r); err != nil {
+		return false, fmt.Errorf("error decoding cached report: %w", err)
+	}
+	r.CheckID = checkID
+	r.StartTime = startTime
+	r.EndTime = time.Now()
+	link, err := c.updater.UpdateCheckReport(checkID, startTime, r)
+	if err != nil {
+		return false, fmt.Errorf("error uploading cached report: %w", err)
+	}
+	status := stateupdater.StatusFinished
+	progress := float32(1)
+	err = c.updater.UpdateState(stateupdater.CheckState{
+		ID:       checkID,
+		Status:   &status,
+		Report:   &link,
+		Progress: &progress,
+	})
+	if err != nil {
+		return false, fmt.Errorf("error updating state with cached report: %w", err)
+	}
+	c.log.Infof("published cached report for check %s", checkID)
+	return true, nil
+}
+
+// UpdateCheckReport uploads the report using the wrapped updater and, if the
+// check is being tracked, it remembers the report until the check finishes.
+func (c *Cache) UpdateCheckReport(checkID string, startTime time.Time, r report.Report) (string, error) {
+	link, err := c.updater.UpdateCheckReport(checkID, startTime, r)
+	if err != nil {
+		return "", err
+	}
+	if v, ok := c.tracked.Load(checkID); ok {
+		t := v.(*trackedCheck)
+		t.Lock()
+		t.report = &r
+		t.Unlock()
+	}
+	return link, nil
+}
+
+// UpdateState updates the state of the check using the wrapped updater. If the
+// check is being tracked and the state is FINISHED, the last report sent by
+// the check is stored in the cache.
+func (c *Cache) UpdateState(s stateupdater.CheckState) error {
+	if err := c.updater.UpdateState(s); err != nil {
+		return err
+	}
+	if s.Status == nil || *s.Status != stateupdater.StatusFinished {
+		return nil
+	}
+	v, ok := c.tracked.Load(s.ID)
+	if !ok {
+		return nil
+	}
+	t := v.(*trackedCheck)
+	t.Lock()
+	r := t.report
+	t.Unlock()
+	if r == nil || r.Status != stateupdater.StatusFinished {
+		return nil
+	}
+	content, err := json.Marshal(r)
+	if err != nil {
+		c.log.Errorf("error encoding report of check %s for the cache: %+v", s.ID, err)
+		return nil
+	}
+	if err := c.store.Set(t.key, content, c.ttl); err != nil {
+		c.log.Errorf("error storing report of check %s in the cache: %+v", s.ID, err)
+	}
+	return nil
+}
+
+// Memory implements an in-memory Store.
+type Memory struct {
+	sync.Mutex
+	entries map[string]memoryEntry
+	now     func() time.Time
+}
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// NewMemory returns an empty in-memory Store.
+func NewMemory() *Memory {
+	return &Memory{
+		entries: make(map[string]memoryEntry),
+		now:     time.Now,
+	}
+}
+
+// Get returns the value stored under the given key if it has not expired.
+func (m *Memory) Get(key string) ([]byte, bool, error) {
+	m.Lock()
+	defer m.Unlock()
+	e, ok := m.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if !m.now().Before(e.expires) {
+		delete(m.entries, key)
+		return nil, false, nil
+	}
+	return e.value, true, nil
+}
+
+// Set stores the value under the given key for the given ttl.
+func (m *Memory) Set(key string, value []byte, ttl time.Duration) error {
+	m.Lock()
+	defer m.Unlock()
+	now := m.now()
+	// Remove the expired entries to avoid growing forever.
+	for k, e := range m.entries {
+		if !now.Before(e.expires) {
+			delete(m.entries, k)
+		}
+	}
+	m.entries[key] = memoryEntry{value: value, expires: now.Add(ttl)}
+	return nil
+}
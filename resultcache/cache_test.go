@@ -0,0 +1,101 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package resultcache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/stateupdater"
+	report "github.com/adevinta/vulcan-report"
+)
+
+type inMemUpdater struct {
+	states  []stateupdater.CheckState
+	reports []report.Report
+}
+
+func (u *inMemUpdater) UpdateState(s stateupdater.CheckState) error {
+	u.states = append(u.states, s)
+	return nil
+}
+
+func (u *inMemUpdater) UpdateCheckReport(checkID string, startTime time.Time, r report.Report) (string, error) {
+	u.reports = append(u.reports, r)
+	return fmt.Sprintf("%s/report", checkID), nil
+}
+
+func TestCache_StoresAndPublishesReports(t *testing.T) {
+	u := &inMemUpdater{}
+	c := New(&log.NullLog{}, u, NewMemory(), time.Hour)
+
+	published, err := c.PublishCached("key", "check1", time.Now())
+	if err != nil || published {
+		t.Fatalf("want no cached result, got published %v, err %v", published, err)
+	}
+
+	c.Track("check1", "key")
+	r := report.Report{}
+	r.CheckID = "check1"
+	r.Status = stateupdater.StatusFinished
+	r.Target = "example.com"
+	if _, err := c.UpdateCheckReport("check1", time.Now(), r); err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	status := stateupdater.StatusFinished
+	if err := c.UpdateState(stateupdater.CheckState{ID: "check1", Status: &status}); err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	c.Untrack("check1")
+
+	published, err = c.PublishCached("key", "check2", time.Now())
+	if err != nil || !published {
+		t.Fatalf("want cached result published, got published %v, err %v", published, err)
+	}
+	last := u.reports[len(u.reports)-1]
+	if last.CheckID != "check2" || last.Target != "example.com" {
+		t.Fatalf("unexpected published report %+v", last.CheckData)
+	}
+	state := u.states[len(u.states)-1]
+	if state.ID != "check2" || *state.Status != stateupdater.StatusFinished || *state.Report != "check2/report" {
+		t.Fatalf("unexpected published state %+v", state)
+	}
+}
+
+func TestCache_DoesNotStoreFailedChecks(t *testing.T) {
+	c := New(&log.NullLog{}, &inMemUpdater{}, NewMemory(), time.Hour)
+	c.Track("check1", "key")
+	r := report.Report{}
+	r.Status = stateupdater.StatusFailed
+	if _, err := c.UpdateCheckReport("check1", time.Now(), r); err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	status := stateupdater.StatusFailed
+	if err := c.UpdateState(stateupdater.CheckState{ID: "check1", Status: &status}); err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	published, err := c.PublishCached("key", "check2", time.Now())
+	if err != nil || published {
+		t.Fatalf("want no cached result, got published %v, err %v", published, err)
+	}
+}
+
+func TestMemory_Expires(t *testing.T) {
+	now := time.Now()
+	m := NewMemory()
+	m.now = func() time.Time { return now }
+	if err := m.Set("key", []byte("value"), time.Minute); err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if v, ok, _ := m.Get("key"); !ok || string(v) != "value" {
+		t.Fatalf("want value, got %s, %v", v, ok)
+	}
+	now = now.Add(time.Minute)
+	if _, ok, _ := m.Get("key"); ok {
+		t.Fatalf("want entry expired")
+	}
+}
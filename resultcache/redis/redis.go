@@ -0,0 +1,149 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultTimeout = 5 * time.Second
+
+// ErrUnexpectedReply is returned when the Redis server returns a reply that
+// the Store does not understand.
+var ErrUnexpectedReply = errors.New("unexpected reply from redis")
+
+// Store implements a resultcache.Store using a Redis server. It only uses the
+// AUTH, SELECT, GET and SET commands, so it speaks the Redis protocol directly
+// instead of depending on a full featured client.
+type Store struct {
+	addr     string
+	password string
+	db       int
+	timeout  time.Duration
+}
+
+// New returns a Store that uses the Redis server listening at the given
+// address.
+func New(addr, password string, db int) *Store {
+	return &Store{
+		addr:     addr,
+		password: password,
+		db:       db,
+		timeout:  defaultTimeout,
+	}
+}
+
+// Get returns the value stored under the given key, if any.
+func (s *Store) Get(key string) ([]byte, bool, error) {
+	var (
+		value []byte
+		found bool
+	)
+	err := s.do(func(c *conn) error {
+		v, err := c.cmd("GET", key)
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			return nil
+		}
+		value, found = v, true
+		return nil
+	})
+	return value, found, err
+}
+
+// Set stores the value under the given key for the given ttl.
+func (s *Store) Set(key string, value []byte, ttl time.Duration) error {
+	secs := int(ttl.Seconds())
+	if secs < 1 {
+		secs = 1
+	}
+	return s.do(func(c *conn) error {
+		_, err := c.cmd("SET", key, string(value), "EX", strconv.Itoa(secs))
+		return err
+	})
+}
+
+func (s *Store) do(f func(c *conn) error) error {
+	nc, err := net.DialTimeout("tcp", s.addr, s.timeout)
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+	if err := nc.SetDeadline(time.Now().Add(s.timeout)); err != nil {
+		return err
+	}
+	c := &conn{nc: nc, r: bufio.NewReader(nc)}
+	if s.password != "" {
+		if _, err := c.cmd("AUTH", s.password); err != nil {
+			return err
+		}
+	}
+	if s.db != 0 {
+		if _, err := c.cmd("SELECT", strconv.Itoa(s.db)); err != nil {
+			return err
+		}
+	}
+	return f(c)
+}
+
+type conn struct {
+	nc net.Conn
+	r  *bufio.Reader
+}
+
+// cmd sends a command and reads its reply. Nil bulk replies are returned as a
+// nil slice.
+func (c *conn) cmd(args ...string) ([]byte, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(c.nc, b.String()); err != nil {
+		return nil, err
+	}
+	return c.reply()
+}
+
+func (c *conn) reply() ([]byte, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, ErrUnexpectedReply
+	}
+	switch line[0] {
+	case '+', ':':
+		return []byte(line[1:]), nil
+	case '-':
+		return nil, fmt.Errorf("redis error: %s", line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrUnexpectedReply, line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnexpectedReply, line)
+	}
+}
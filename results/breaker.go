@@ -0,0 +1,156 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package results
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/log"
+	report "github.com/adevinta/vulcan-report"
+)
+
+// BreakerPauseReason is the reason passed to the Intake when the Breaker
+// pauses it.
+const BreakerPauseReason = "results sink unavailable"
+
+// Intake is implemented by the components that read new checks and can stop
+// doing it temporarily, e.g. the jobrunner.
+type Intake interface {
+	Pause(reason string)
+	Resume(reason string)
+}
+
+// Breaker decorates a Sink with a circuit breaker. When storing the results
+// fails, after the retries of the sink, threshold consecutive times the
+// breaker opens and pauses the Intake, so no new checks are run only to lose
+// their results. While it's open the sink is probed every probe interval,
+// using its Ping method if it has one, and the breaker closes and resumes
+// the Intake as soon as the sink works again. If the sink can't be pinged
+// the breaker closes after a probe interval, but it opens again with the
+// first error. The results of the checks already running are still sent to
+// the sink while the breaker is open.
+type Breaker struct {
+	Sink
+	// Intake is paused while the breaker is open.
+	Intake        Intake
+	threshold     int
+	probeInterval time.Duration
+	log           log.Logger
+
+	mu       sync.Mutex
+	failures int
+	open     bool
+}
+
+// NewBreaker returns a Breaker that opens after threshold consecutive errors
+// of the given sink.
+func NewBreaker(l log.Logger, s Sink, threshold int, probeInterval time.Duration) *Breaker {
+	return &Breaker{
+		Sink:          s,
+		threshold:     threshold,
+		probeInterval: probeInterval,
+		log:           l,
+	}
+}
+
+// UpdateCheckReport stores the report of a check in the decorated sink.
+func (b *Breaker) UpdateCheckReport(checkID string, scanStartTime time.Time, r report.Report) (string, error) {
+	link, err := b.Sink.UpdateCheckReport(checkID, scanStartTime, r)
+	b.record(err)
+	return link, err
+}
+
+// UpdateCheckRaw stores the logs of a check in the decorated sink.
+func (b *Breaker) UpdateCheckRaw(checkID string, scanStartTime time.Time, raw []byte) (string, error) {
+	link, err := b.Sink.UpdateCheckRaw(checkID, scanStartTime, raw)
+	b.record(err)
+	return link, err
+}
+
+// UpdateCheckArtifact stores an artifact of a check in the decorated sink.
+// The errors storing the artifacts don't open the breaker because they don't
+// make the checks fail.
+func (b *Breaker) UpdateCheckArtifact(checkID string, scanStartTime time.Time, name string, data []byte) (string, error) {
+	as, ok := b.Sink.(ArtifactSink)
+	if !ok {
+		return "", fmt.Errorf("storing the artifact %s of the check %s: %w", name, checkID, ErrArtifactsNotSupported)
+	}
+	return as.UpdateCheckArtifact(checkID, scanStartTime, name, data)
+}
+
+// Ping checks that the decorated sink is reachable, if it can be checked.
+func (b *Breaker) Ping(ctx context.Context) error {
+	if p, ok := b.Sink.(interface{ Ping(context.Context) error }); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+// Open returns true if the breaker is open.
+func (b *Breaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.open
+}
+
+func (b *Breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		b.failures = 0
+		if b.open {
+			b.close()
+		}
+		return
+	}
+	b.failures++
+	if b.open || b.failures < b.threshold {
+		return
+	}
+	b.open = true
+	b.log.Errorf("results sink failed %d consecutive times, pausing the intake of checks: %+v", b.failures, err)
+	if b.Intake != nil {
+		b.Intake.Pause(BreakerPauseReason)
+	}
+	go b.probe()
+}
+
+// close closes the breaker. It must be called with the mu held.
+func (b *Breaker) close() {
+	b.open = false
+	b.log.Infof("results sink available again, resuming the intake of checks")
+	if b.Intake != nil {
+		b.Intake.Resume(BreakerPauseReason)
+	}
+}
+
+// probe checks the sink every probe interval until the breaker closes.
+func (b *Breaker) probe() {
+	ticker := time.NewTicker(b.probeInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), b.probeInterval)
+		err := b.Ping(ctx)
+		cancel()
+		b.mu.Lock()
+		if !b.open {
+			b.mu.Unlock()
+			return
+		}
+		if err != nil {
+			b.mu.Unlock()
+			b.log.Errorf("results sink still unavailable: %+v", err)
+			continue
+		}
+		// One more error opens the breaker again.
+		b.failures = b.threshold - 1
+		b.close()
+		b.mu.Unlock()
+		return
+	}
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package results
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/log"
+	report "github.com/adevinta/vulcan-report"
+	"github.com/google/go-cmp/cmp"
+)
+
+type fakeIntake struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (i *fakeIntake) Pause(reason string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.events = append(i.events, "pause")
+}
+
+func (i *fakeIntake) Resume(reason string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.events = append(i.events, "resume")
+}
+
+func (i *fakeIntake) Events() []string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return append([]string(nil), i.events...)
+}
+
+type flakySink struct {
+	mu   sync.Mutex
+	err  error
+	ping error
+}
+
+func (s *flakySink) UpdateCheckReport(checkID string, scanStartTime time.Time, r report.Report) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return "", s.err
+}
+
+func (s *flakySink) UpdateCheckRaw(checkID string, scanStartTime time.Time, raw []byte) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return "", s.err
+}
+
+func (s *flakySink) Ping(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ping
+}
+
+func (s *flakySink) set(err, ping error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err, s.ping = err, ping
+}
+
+func TestBreaker(t *testing.T) {
+	errDown := errors.New("down")
+	sink := &flakySink{}
+	sink.set(errDown, errDown)
+	intake := &fakeIntake{}
+	b := NewBreaker(&log.NullLog{}, sink, 2, 10*time.Millisecond)
+	b.Intake = intake
+
+	b.UpdateCheckReport("check1", time.Now(), report.Report{})
+	if b.Open() {
+		t.Fatal("breaker open before reaching the threshold")
+	}
+	b.UpdateCheckRaw("check1", time.Now(), nil)
+	if !b.Open() {
+		t.Fatal("breaker not open after reaching the threshold")
+	}
+	b.UpdateCheckRaw("check2", time.Now(), nil)
+	if diff := cmp.Diff([]string{"pause"}, intake.Events()); diff != "" {
+		t.Fatalf("intake events mismatch (-want +got):\n%v", diff)
+	}
+
+	// The sink recovers, the probe closes the breaker.
+	sink.set(nil, nil)
+	deadline := time.Now().Add(5 * time.Second)
+	for b.Open() {
+		if time.Now().After(deadline) {
+			t.Fatal("breaker not closed after the sink recovered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if diff := cmp.Diff([]string{"pause", "resume"}, intake.Events()); diff != "" {
+		t.Fatalf("intake events mismatch (-want +got):\n%v", diff)
+	}
+
+	// A single error opens the breaker again after the probe closed it.
+	sink.set(errDown, errDown)
+	b.UpdateCheckReport("check3", time.Now(), report.Report{})
+	if !b.Open() {
+		t.Fatal("breaker not open again after the first error")
+	}
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+// Package localdir implements a results sink that writes the reports and the
+// logs of the checks to a local directory.
+package localdir
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	report "github.com/adevinta/vulcan-report"
+)
+
+// pruneInterval is the minimum time between two prunings of the directory.
+const pruneInterval = time.Minute
+
+// Sink writes the reports and the logs of the checks to a directory with the
+// layout:
+//
+//	<dir>/<date>/<check id>.json
+//	<dir>/<date>/<check id>.log
+//
+// where the date is the one of the start time of the scan. The files older
+// than the max age, and the oldest files when the directory exceeds the max
+// size, are removed after writing new results.
+type Sink struct {
+	dir     string
+	maxAge  time.Duration
+	maxSize int64
+	log     log.Logger
+	now     func() time.Time
+
+	mu        sync.Mutex
+	lastPrune time.Time
+}
+
+// New returns a Sink that writes the results to the directory defined in the
+// given config, creating it if it doesn't exist.
+func New(l log.Logger, cfg config.LocalDirConfig) (*Sink, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("local dir uploader path is empty")
+	}
+	dir, err := filepath.Abs(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Sink{
+		dir:     dir,
+		maxAge:  time.Duration(cfg.MaxAgeDays) * 24 * time.Hour,
+		maxSize: int64(cfg.MaxSizeMB) * 1024 * 1024,
+		log:     l,
+		now:     time.Now,
+	}, nil
+}
+
+// UpdateCheckReport writes the report of a check and returns a link to the
+// file.
+func (s *Sink) UpdateCheckReport(checkID string, scanStartTime time.Time, r report.Report) (string, error) {
+	content, err := json.MarshalIndent(&r, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return s.write(checkID, scanStartTime, "json", content)
+}
+
+// UpdateCheckRaw writes the logs of a check and returns a link to the file.
+func (s *Sink) UpdateCheckRaw(checkID string, scanStartTime time.Time, raw []byte) (string, error) {
+	return s.write(checkID, scanStartTime, "log", raw)
+}
+
+// UpdateCheckArtifact writes a file produced by a check and returns a link to
+// it. The artifacts are stored next to the report and the logs of the check,
+// with the path of the artifact flattened into the name of the file.
+func (s *Sink) UpdateCheckArtifact(checkID string, scanStartTime time.Time, name string, data []byte) (string, error) {
+	// Cleaning the path as an absolute one prevents it from escaping the dir.
+	name = filepath.ToSlash(filepath.Clean(string(filepath.Separator) + name))
+	name = strings.ReplaceAll(strings.TrimPrefix(name, "/"), "/", "_")
+	return s.write(checkID, scanStartTime, "artifact."+name, data)
+}
+
+func (s *Sink) write(checkID string, scanStartTime time.Time, ext string, content []byte) (string, error) {
+	dir := filepath.Join(s.dir, scanStartTime.UTC().Format("2006-01-02"))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	file := filepath.Join(dir, checkID+"."+ext)
+	// Write to a temporary file first so a partial result is never read.
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		return "", err
+	}
+	s.maybePrune()
+	return "file://" + filepath.ToSlash(file), nil
+}
+
+func (s *Sink) maybePrune() {
+	if s.maxAge <= 0 && s.maxSize <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if now.Sub(s.lastPrune) < pruneInterval {
+		return
+	}
+	s.lastPrune = now
+	if err := s.prune(now); err != nil {
+		s.log.Errorf("error pruning results dir %s: %+v", s.dir, err)
+	}
+}
+
+type entry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// prune removes the files older than the max age and, if the size of the
+// remaining files exceeds the max size, the oldest ones until it doesn't.
+func (s *Sink) prune(now time.Time) error {
+	var (
+		entries []entry
+		total   int64
+	)
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if s.maxAge > 0 && now.Sub(info.ModTime()) > s.maxAge {
+			return os.Remove(path)
+		}
+		entries = append(entries, entry{path, info.Size(), info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if s.maxSize > 0 && total > s.maxSize {
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].modTime.Before(entries[j].modTime)
+		})
+		for _, e := range entries {
+			if total <= s.maxSize {
+				break
+			}
+			if err := os.Remove(e.path); err != nil {
+				return fmt.Errorf("removing %s: %w", e.path, err)
+			}
+			total -= e.size
+		}
+	}
+	return s.removeEmptyDirs()
+}
+
+func (s *Sink) removeEmptyDirs() error {
+	dirs, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		path := filepath.Join(s.dir, d.Name())
+		files, err := os.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
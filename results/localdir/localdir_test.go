@@ -0,0 +1,123 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package localdir
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	report "github.com/adevinta/vulcan-report"
+	"github.com/google/go-cmp/cmp"
+)
+
+func listFiles(t *testing.T, dir string) []string {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		files = append(files, filepath.ToSlash(rel))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func TestSink_Write(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(&log.NullLog{}, config.LocalDirConfig{Path: dir})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	start := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	link, err := s.UpdateCheckReport("check1", start, report.Report{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "file://" + filepath.ToSlash(filepath.Join(dir, "2022-03-01", "check1.json")); link != want {
+		t.Errorf("want link %s, got %s", want, link)
+	}
+	if _, err := s.UpdateCheckRaw("check1", start, []byte("logs")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := s.UpdateCheckArtifact("check1", start, "../out/capture.pcap", []byte("pcap")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{
+		"2022-03-01/check1.artifact.out_capture.pcap",
+		"2022-03-01/check1.json",
+		"2022-03-01/check1.log",
+	}
+	if diff := cmp.Diff(want, listFiles(t, dir)); diff != "" {
+		t.Errorf("want files != got files, diff: %s", diff)
+	}
+}
+
+func TestSink_prune(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name string
+		cfg  config.LocalDirConfig
+		want []string
+	}{
+		{
+			name: "MaxAge",
+			cfg:  config.LocalDirConfig{MaxAgeDays: 2},
+			want: []string{"new/a.log", "new/b.log"},
+		},
+		{
+			name: "MaxSize",
+			cfg:  config.LocalDirConfig{MaxSizeMB: 1},
+			want: []string{"new/b.log"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tt.cfg.Path = dir
+			s, err := New(&log.NullLog{}, tt.cfg)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			files := []struct {
+				path string
+				size int
+				age  time.Duration
+			}{
+				{"old/a.log", 10, 72 * time.Hour},
+				{"new/a.log", 600 * 1024, 2 * time.Hour},
+				{"new/b.log", 600 * 1024, time.Hour},
+			}
+			for _, f := range files {
+				path := filepath.Join(dir, f.path)
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, make([]byte, f.size), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				mt := now.Add(-f.age)
+				if err := os.Chtimes(path, mt, mt); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := s.prune(now); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, listFiles(t, dir)); diff != "" {
+				t.Errorf("want files != got files, diff: %s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+// Package s3 implements an uploader that stores the reports and the logs of
+// the checks directly in an AWS S3 bucket, for the deployments where the
+// vulcan-results service is not available.
+package s3
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/awscreds"
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/results"
+	report "github.com/adevinta/vulcan-report"
+	"github.com/aws/aws-sdk-go/aws"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// Uploader stores the reports and logs of the checks in a S3 bucket using
+// the same layout as vulcan-results:
+//
+//	<prefix>/reports/dt=<date>/scan=<scan id>/<check id>.json
+//	<prefix>/logs/dt=<date>/scan=<scan id>/<check id>.log
+//
+// The date is the one of the start time of the scan. As the agent doesn't
+// process scan ids, the id of the check is used as the scan id.
+type Uploader struct {
+	s3      s3iface.S3API
+	bucket  string
+	prefix  string
+	retryer results.Retryer
+}
+
+// New returns an Uploader that stores the results in the bucket defined in
+// the given config.
+func New(cfg config.S3UploaderConfig, retryer results.Retryer) (*Uploader, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("s3 uploader bucket is empty")
+	}
+	sess, err := awscreds.NewSession(cfg.Credentials)
+	if err != nil {
+		return nil, err
+	}
+	awsCfg := aws.NewConfig()
+	if cfg.Region != "" {
+		awsCfg = awsCfg.WithRegion(cfg.Region)
+	}
+	if cfg.Endpoint != "" {
+		awsCfg = awsCfg.WithEndpoint(cfg.Endpoint).WithS3ForcePathStyle(true)
+	}
+	return &Uploader{
+		s3:      awss3.New(sess, awsCfg),
+		bucket:  cfg.Bucket,
+		prefix:  cfg.Prefix,
+		retryer: retryer,
+	}, nil
+}
+
+// UpdateCheckReport stores the report of a check and returns its S3 URL.
+func (u *Uploader) UpdateCheckReport(checkID string, scanStartTime time.Time, r report.Report) (string, error) {
+	content, err := json.Marshal(&r)
+	if err != nil {
+		return "", err
+	}
+	key := u.key("reports", checkID, scanStartTime, "json")
+	return u.put(key, content, "application/json")
+}
+
+// UpdateCheckRaw stores the logs of a check and returns their S3 URL.
+func (u *Uploader) UpdateCheckRaw(checkID string, scanStartTime time.Time, raw []byte) (string, error) {
+	key := u.key("logs", checkID, scanStartTime, "log")
+	return u.put(key, raw, "text/plain")
+}
+
+func (u *Uploader) key(kind, checkID string, scanStartTime time.Time, ext string) string {
+	dt := "dt=" + scanStartTime.UTC().Format("2006-01-02")
+	return path.Join(u.prefix, kind, dt, "scan="+checkID, checkID+"."+ext)
+}
+
+func (u *Uploader) put(key string, content []byte, contentType string) (string, error) {
+	put := func() error {
+		_, err := u.s3.PutObject(&awss3.PutObjectInput{
+			Bucket:      aws.String(u.bucket),
+			Key:         aws.String(key),
+			Body:        bytes.NewReader(content),
+			ContentType: aws.String(contentType),
+		})
+		return err
+	}
+	var err error
+	if u.retryer != nil {
+		err = u.retryer.WithRetries("S3Uploader.PutObject", put)
+	} else {
+		err = put()
+	}
+	if err != nil {
+		return "", fmt.Errorf("storing s3://%s/%s: %w", u.bucket, key, err)
+	}
+	return fmt.Sprintf("s3://%s/%s", u.bucket, key), nil
+}
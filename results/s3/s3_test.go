@@ -0,0 +1,72 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package s3
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	report "github.com/adevinta/vulcan-report"
+	"github.com/aws/aws-sdk-go/aws"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/google/go-cmp/cmp"
+)
+
+type inMemS3 struct {
+	s3iface.S3API
+	objects map[string]string
+}
+
+func (m *inMemS3) PutObject(in *awss3.PutObjectInput) (*awss3.PutObjectOutput, error) {
+	content, err := ioutil.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	m.objects[aws.StringValue(in.Bucket)+"/"+aws.StringValue(in.Key)] = string(content)
+	return &awss3.PutObjectOutput{}, nil
+}
+
+func TestUploader(t *testing.T) {
+	mem := &inMemS3{objects: map[string]string{}}
+	u := &Uploader{s3: mem, bucket: "bucket", prefix: "vulcan"}
+	start := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	link, err := u.UpdateCheckReport("check1", start, report.Report{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantLink := "s3://bucket/vulcan/reports/dt=2022-03-01/scan=check1/check1.json"
+	if link != wantLink {
+		t.Errorf("want link %s, got %s", wantLink, link)
+	}
+
+	link, err = u.UpdateCheckRaw("check1", start, []byte("logs"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantLink = "s3://bucket/vulcan/logs/dt=2022-03-01/scan=check1/check1.log"
+	if link != wantLink {
+		t.Errorf("want link %s, got %s", wantLink, link)
+	}
+
+	want := map[string]string{
+		"bucket/vulcan/reports/dt=2022-03-01/scan=check1/check1.json": mustMarshal(t, report.Report{}),
+		"bucket/vulcan/logs/dt=2022-03-01/scan=check1/check1.log":     "logs",
+	}
+	if diff := cmp.Diff(want, mem.objects); diff != "" {
+		t.Errorf("want objects != got objects, diff: %s", diff)
+	}
+}
+
+func mustMarshal(t *testing.T, r report.Report) string {
+	content, err := json.Marshal(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
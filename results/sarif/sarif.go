@@ -0,0 +1,269 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+// Package sarif converts the reports of the checks to the SARIF format, so
+// they can be ingested by tools like GitHub code scanning, and implements a
+// results sink that stores the SARIF version of the reports.
+package sarif
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/results"
+	report "github.com/adevinta/vulcan-report"
+)
+
+// Version and Schema are the version of SARIF the reports are converted to
+// and the location of its JSON schema.
+const (
+	Version = "2.1.0"
+	Schema  = "https://json.schemastore.org/sarif-2.1.0.json"
+)
+
+// ArtifactName is the name of the artifact the Sink stores the SARIF version
+// of the reports in.
+const ArtifactName = "report.sarif"
+
+// Log is a SARIF log, it contains one run per converted report.
+type Log struct {
+	Version string `json:"version"`
+	Schema  string `json:"$schema"`
+	Runs    []Run  `json:"runs"`
+}
+
+// Run contains the results found by a check.
+type Run struct {
+	Tool              Tool              `json:"tool"`
+	AutomationDetails AutomationDetails `json:"automationDetails"`
+	Results           []Result          `json:"results"`
+	Properties        map[string]string `json:"properties,omitempty"`
+}
+
+// Tool describes the checktype that run the check.
+type Tool struct {
+	Driver Driver `json:"driver"`
+}
+
+// Driver contains the name and version of a checktype and the rules, one per
+// kind of vulnerability, it reported.
+type Driver struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	Rules   []Rule `json:"rules"`
+}
+
+// AutomationDetails identifies the check that produced a run.
+type AutomationDetails struct {
+	ID string `json:"id"`
+}
+
+// Rule describes a kind of vulnerability.
+type Rule struct {
+	ID               string          `json:"id"`
+	ShortDescription Message         `json:"shortDescription"`
+	FullDescription  *Message        `json:"fullDescription,omitempty"`
+	Help             *Message        `json:"help,omitempty"`
+	HelpURI          string          `json:"helpUri,omitempty"`
+	Properties       *RuleProperties `json:"properties,omitempty"`
+}
+
+// RuleProperties contains the severity of a rule, as a CVSS score in the
+// security-severity property used by GitHub, and its tags.
+type RuleProperties struct {
+	SecuritySeverity string   `json:"security-severity,omitempty"`
+	Tags             []string `json:"tags,omitempty"`
+}
+
+// Result is a vulnerability found by a check.
+type Result struct {
+	RuleID              string            `json:"ruleId"`
+	RuleIndex           int               `json:"ruleIndex"`
+	Level               string            `json:"level"`
+	Message             Message           `json:"message"`
+	Locations           []Location        `json:"locations,omitempty"`
+	PartialFingerprints map[string]string `json:"partialFingerprints,omitempty"`
+}
+
+// Message is a plain text message.
+type Message struct {
+	Text string `json:"text"`
+}
+
+// Location is the resource affected by a vulnerability.
+type Location struct {
+	PhysicalLocation PhysicalLocation `json:"physicalLocation"`
+}
+
+// PhysicalLocation contains the location of the resource affected by a
+// vulnerability.
+type PhysicalLocation struct {
+	ArtifactLocation ArtifactLocation `json:"artifactLocation"`
+}
+
+// ArtifactLocation contains the URI of the resource affected by a
+// vulnerability.
+type ArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+// fingerprintKey is the key of the fingerprint of the vulnerabilities in the
+// partial fingerprints of the results.
+const fingerprintKey = "vulcanFingerprint/v1"
+
+// Convert returns the SARIF log corresponding to the given report. The
+// vulnerabilities, including the children of the vulnerabilities, are
+// converted to results of the rule identified by their summary.
+func Convert(r report.Report) Log {
+	run := Run{
+		Tool: Tool{Driver: Driver{
+			Name:    r.ChecktypeName,
+			Version: r.ChecktypeVersion,
+			Rules:   []Rule{},
+		}},
+		AutomationDetails: AutomationDetails{ID: r.ChecktypeName + "/" + r.CheckID},
+		Results:           []Result{},
+		Properties: map[string]string{
+			"checkID": r.CheckID,
+			"target":  r.Target,
+			"status":  r.Status,
+		},
+	}
+	rules := make(map[string]int)
+	var add func(vs []report.Vulnerability)
+	add = func(vs []report.Vulnerability) {
+		for _, v := range vs {
+			idx, ok := rules[v.Summary]
+			if !ok {
+				idx = len(run.Tool.Driver.Rules)
+				rules[v.Summary] = idx
+				run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, rule(v))
+			}
+			run.Results = append(run.Results, result(r, v, idx))
+			add(v.Vulnerabilities)
+		}
+	}
+	add(r.Vulnerabilities)
+	return Log{Version: Version, Schema: Schema, Runs: []Run{run}}
+}
+
+func rule(v report.Vulnerability) Rule {
+	rl := Rule{
+		ID:               v.Summary,
+		ShortDescription: Message{Text: v.Summary},
+		Properties: &RuleProperties{
+			SecuritySeverity: strconv.FormatFloat(float64(v.Score), 'f', 1, 32),
+			Tags:             append([]string{"security"}, v.Labels...),
+		},
+	}
+	if v.CWEID != 0 {
+		rl.Properties.Tags = append(rl.Properties.Tags, fmt.Sprintf("external/cwe/cwe-%d", v.CWEID))
+	}
+	if v.Description != "" {
+		rl.FullDescription = &Message{Text: v.Description}
+	}
+	if len(v.Recommendations) > 0 {
+		rl.Help = &Message{Text: strings.Join(v.Recommendations, "\n")}
+	}
+	if len(v.References) > 0 {
+		rl.HelpURI = v.References[0]
+	}
+	return rl
+}
+
+func result(r report.Report, v report.Vulnerability, ruleIdx int) Result {
+	msg := v.Details
+	if msg == "" {
+		msg = v.Summary
+	}
+	res := Result{
+		RuleID:    v.Summary,
+		RuleIndex: ruleIdx,
+		Level:     Level(v.Severity()),
+		Message:   Message{Text: msg},
+	}
+	uri := v.AffectedResource
+	if uri == "" {
+		uri = r.Target
+	}
+	if uri != "" {
+		res.Locations = []Location{{PhysicalLocation{ArtifactLocation{URI: uri}}}}
+	}
+	if v.Fingerprint != "" {
+		res.PartialFingerprints = map[string]string{fingerprintKey: v.Fingerprint}
+	}
+	return res
+}
+
+// Level returns the SARIF level corresponding to a severity: "error" for
+// high and critical, "warning" for medium, "note" for low and "none" for
+// informational vulnerabilities.
+func Level(s report.SeverityRank) string {
+	switch {
+	case s >= report.SeverityHigh:
+		return "error"
+	case s == report.SeverityMedium:
+		return "warning"
+	case s == report.SeverityLow:
+		return "note"
+	default:
+		return "none"
+	}
+}
+
+// Marshal returns the SARIF log corresponding to the given report encoded as
+// JSON.
+func Marshal(r report.Report) ([]byte, error) {
+	return json.MarshalIndent(Convert(r), "", "  ")
+}
+
+// Sink decorates a results.Sink storing, in addition to the reports of the
+// checks, their SARIF version as the artifact ArtifactName. The SARIF
+// reports are discarded if the decorated sink doesn't support artifacts and
+// the errors storing them are only logged.
+type Sink struct {
+	results.Sink
+	log log.Logger
+}
+
+// NewSink returns a Sink that stores the SARIF version of the reports in the
+// given sink.
+func NewSink(l log.Logger, s results.Sink) *Sink {
+	return &Sink{Sink: s, log: l}
+}
+
+// UpdateCheckReport stores the report of a check and its SARIF version. The
+// link returned is the one of the original report.
+func (s *Sink) UpdateCheckReport(checkID string, scanStartTime time.Time, r report.Report) (string, error) {
+	link, err := s.Sink.UpdateCheckReport(checkID, scanStartTime, r)
+	if err != nil {
+		return "", err
+	}
+	as, ok := s.Sink.(results.ArtifactSink)
+	if !ok {
+		return link, nil
+	}
+	data, err := Marshal(r)
+	if err != nil {
+		s.log.Errorf("error converting the report of the check %s to SARIF: %+v", checkID, err)
+		return link, nil
+	}
+	if _, err := as.UpdateCheckArtifact(checkID, scanStartTime, ArtifactName, data); err != nil {
+		s.log.Errorf("error storing the SARIF report of the check %s: %+v", checkID, err)
+	}
+	return link, nil
+}
+
+// UpdateCheckArtifact stores an artifact of a check in the decorated sink.
+func (s *Sink) UpdateCheckArtifact(checkID string, scanStartTime time.Time, name string, data []byte) (string, error) {
+	as, ok := s.Sink.(results.ArtifactSink)
+	if !ok {
+		return "", fmt.Errorf("storing the artifact %s of the check %s: %w", name, checkID, results.ErrArtifactsNotSupported)
+	}
+	return as.UpdateCheckArtifact(checkID, scanStartTime, name, data)
+}
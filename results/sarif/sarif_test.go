@@ -0,0 +1,122 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package sarif
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/log"
+	report "github.com/adevinta/vulcan-report"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestConvert(t *testing.T) {
+	r := report.Report{
+		CheckData: report.CheckData{
+			CheckID:          "check1",
+			ChecktypeName:    "vulcan-exposed-http",
+			ChecktypeVersion: "1",
+			Status:           "FINISHED",
+			Target:           "example.com",
+		},
+		ResultData: report.ResultData{Vulnerabilities: []report.Vulnerability{
+			{
+				Summary:          "Exposed admin",
+				Score:            8.9,
+				AffectedResource: "http://example.com/admin",
+				Fingerprint:      "fp1",
+				CWEID:            200,
+				References:       []string{"https://example.com/doc"},
+				Vulnerabilities: []report.Vulnerability{
+					{Summary: "Exposed admin", Score: 5, Details: "port 8080"},
+				},
+			},
+		}},
+	}
+	got := Convert(r)
+	want := Log{
+		Version: Version,
+		Schema:  Schema,
+		Runs: []Run{{
+			Tool: Tool{Driver: Driver{
+				Name:    "vulcan-exposed-http",
+				Version: "1",
+				Rules: []Rule{{
+					ID:               "Exposed admin",
+					ShortDescription: Message{Text: "Exposed admin"},
+					HelpURI:          "https://example.com/doc",
+					Properties: &RuleProperties{
+						SecuritySeverity: "8.9",
+						Tags:             []string{"security", "external/cwe/cwe-200"},
+					},
+				}},
+			}},
+			AutomationDetails: AutomationDetails{ID: "vulcan-exposed-http/check1"},
+			Results: []Result{
+				{
+					RuleID:              "Exposed admin",
+					Level:               "error",
+					Message:             Message{Text: "Exposed admin"},
+					Locations:           []Location{{PhysicalLocation{ArtifactLocation{URI: "http://example.com/admin"}}}},
+					PartialFingerprints: map[string]string{fingerprintKey: "fp1"},
+				},
+				{
+					RuleID:    "Exposed admin",
+					Level:     "warning",
+					Message:   Message{Text: "port 8080"},
+					Locations: []Location{{PhysicalLocation{ArtifactLocation{URI: "example.com"}}}},
+				},
+			},
+			Properties: map[string]string{"checkID": "check1", "target": "example.com", "status": "FINISHED"},
+		}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("log mismatch (-want +got):\n%v", diff)
+	}
+}
+
+type artifactSink struct {
+	reports   []string
+	artifacts map[string][]byte
+}
+
+func (s *artifactSink) UpdateCheckReport(checkID string, scanStartTime time.Time, r report.Report) (string, error) {
+	s.reports = append(s.reports, checkID)
+	return "link-" + checkID, nil
+}
+
+func (s *artifactSink) UpdateCheckRaw(checkID string, scanStartTime time.Time, raw []byte) (string, error) {
+	return "", nil
+}
+
+func (s *artifactSink) UpdateCheckArtifact(checkID string, scanStartTime time.Time, name string, data []byte) (string, error) {
+	s.artifacts[checkID+"/"+name] = data
+	return "", nil
+}
+
+func TestSink(t *testing.T) {
+	inner := &artifactSink{artifacts: map[string][]byte{}}
+	s := NewSink(&log.NullLog{}, inner)
+	r := report.Report{CheckData: report.CheckData{CheckID: "check1", ChecktypeName: "vulcan-nessus"}}
+	link, err := s.UpdateCheckReport("check1", time.Now(), r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if link != "link-check1" {
+		t.Errorf("got link %q, want the one of the original report", link)
+	}
+	if diff := cmp.Diff([]string{"check1"}, inner.reports); diff != "" {
+		t.Errorf("reports mismatch (-want +got):\n%v", diff)
+	}
+	var l Log
+	if err := json.Unmarshal(inner.artifacts["check1/"+ArtifactName], &l); err != nil {
+		t.Fatalf("invalid SARIF artifact: %v", err)
+	}
+	if len(l.Runs) != 1 || l.Runs[0].Tool.Driver.Name != "vulcan-nessus" {
+		t.Errorf("unexpected SARIF log: %+v", l)
+	}
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package results
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/log"
+	report "github.com/adevinta/vulcan-report"
+)
+
+// Sink stores the reports and the logs of the checks and returns the links
+// that can be used to retrieve them.
+type Sink interface {
+	UpdateCheckReport(checkID string, scanStartTime time.Time, report report.Report) (string, error)
+	UpdateCheckRaw(checkID string, scanStartTime time.Time, raw []byte) (string, error)
+}
+
+// ErrArtifactsNotSupported is returned when the artifacts of a check are
+// stored in a sink that doesn't support them.
+var ErrArtifactsNotSupported = errors.New("the sink does not support artifacts")
+
+// ArtifactSink is implemented by the sinks that can store the artifacts
+// produced by the checks, e.g. pcap files or screenshots.
+type ArtifactSink interface {
+	UpdateCheckArtifact(checkID string, scanStartTime time.Time, name string, data []byte) (string, error)
+}
+
+// Multi sends the reports and logs of the checks to several sinks at once.
+// The first sink is the primary one: the links returned are the ones returned
+// by it and only its errors are returned to the caller. The errors of the
+// rest of the sinks are logged.
+type Multi struct {
+	sinks []Sink
+	log   log.Logger
+}
+
+// NewMulti returns a Multi sink that sends the results to the primary sink
+// and the given secondary ones.
+func NewMulti(l log.Logger, primary Sink, secondary ...Sink) *Multi {
+	return &Multi{
+		sinks: append([]Sink{primary}, secondary...),
+		log:   l,
+	}
+}
+
+// UpdateCheckReport stores the report of a check in all the sinks.
+func (m *Multi) UpdateCheckReport(checkID string, scanStartTime time.Time, r report.Report) (string, error) {
+	return m.fanOut(checkID, "report", func(s Sink) (string, error) {
+		return s.UpdateCheckReport(checkID, scanStartTime, r)
+	})
+}
+
+// UpdateCheckRaw stores the logs of a check in all the sinks.
+func (m *Multi) UpdateCheckRaw(checkID string, scanStartTime time.Time, raw []byte) (string, error) {
+	return m.fanOut(checkID, "logs", func(s Sink) (string, error) {
+		return s.UpdateCheckRaw(checkID, scanStartTime, raw)
+	})
+}
+
+// UpdateCheckArtifact stores an artifact of a check in all the sinks that
+// support storing artifacts. The primary sink must support it.
+func (m *Multi) UpdateCheckArtifact(checkID string, scanStartTime time.Time, name string, data []byte) (string, error) {
+	if _, ok := m.sinks[0].(ArtifactSink); !ok {
+		return "", fmt.Errorf("storing the artifact %s of the check %s: %w", name, checkID, ErrArtifactsNotSupported)
+	}
+	return m.fanOut(checkID, "artifact "+name, func(s Sink) (string, error) {
+		as, ok := s.(ArtifactSink)
+		if !ok {
+			return "", nil
+		}
+		return as.UpdateCheckArtifact(checkID, scanStartTime, name, data)
+	})
+}
+
+// Ping checks that the primary sink is reachable, if it can be checked.
+func (m *Multi) Ping(ctx context.Context) error {
+	if p, ok := m.sinks[0].(interface{ Ping(context.Context) error }); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (m *Multi) fanOut(checkID, kind string, update func(s Sink) (string, error)) (string, error) {
+	var wg sync.WaitGroup
+	for i, s := range m.sinks[1:] {
+		wg.Add(1)
+		go func(i int, s Sink) {
+			defer wg.Done()
+			if _, err := update(s); err != nil {
+				m.log.Errorf("error storing the %s of the check %s in the sink %d: %+v", kind, checkID, i+1, err)
+			}
+		}(i, s)
+	}
+	link, err := update(m.sinks[0])
+	wg.Wait()
+	if err != nil {
+		return "", fmt.Errorf("storing the %s of the check %s: %w", kind, checkID, err)
+	}
+	return link, nil
+}
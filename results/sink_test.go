@@ -0,0 +1,79 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package results
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/log"
+	report "github.com/adevinta/vulcan-report"
+	"github.com/google/go-cmp/cmp"
+)
+
+type memSink struct {
+	sync.Mutex
+	name    string
+	err     error
+	reports []string
+	raws    []string
+}
+
+func (m *memSink) UpdateCheckReport(checkID string, scanStartTime time.Time, r report.Report) (string, error) {
+	m.Lock()
+	defer m.Unlock()
+	if m.err != nil {
+		return "", m.err
+	}
+	m.reports = append(m.reports, checkID)
+	return m.name + "/report/" + checkID, nil
+}
+
+func (m *memSink) UpdateCheckRaw(checkID string, scanStartTime time.Time, raw []byte) (string, error) {
+	m.Lock()
+	defer m.Unlock()
+	if m.err != nil {
+		return "", m.err
+	}
+	m.raws = append(m.raws, checkID)
+	return m.name + "/raw/" + checkID, nil
+}
+
+func TestMulti(t *testing.T) {
+	primary := &memSink{name: "primary"}
+	secondary := &memSink{name: "secondary"}
+	failing := &memSink{name: "failing", err: errors.New("unavailable")}
+	m := NewMulti(&log.NullLog{}, primary, failing, secondary)
+
+	link, err := m.UpdateCheckReport("check1", time.Now(), report.Report{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if link != "primary/report/check1" {
+		t.Errorf("want the link of the primary sink, got %s", link)
+	}
+	link, err = m.UpdateCheckRaw("check1", time.Now(), []byte("logs"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if link != "primary/raw/check1" {
+		t.Errorf("want the link of the primary sink, got %s", link)
+	}
+	for _, s := range []*memSink{primary, secondary} {
+		if diff := cmp.Diff([]string{"check1"}, s.reports); diff != "" {
+			t.Errorf("%s: want reports != got reports, diff: %s", s.name, diff)
+		}
+		if diff := cmp.Diff([]string{"check1"}, s.raws); diff != "" {
+			t.Errorf("%s: want raws != got raws, diff: %s", s.name, diff)
+		}
+	}
+
+	m = NewMulti(&log.NullLog{}, failing, primary)
+	if _, err := m.UpdateCheckReport("check2", time.Now(), report.Report{}); err == nil {
+		t.Errorf("want the error of the primary sink")
+	}
+}
@@ -6,6 +6,7 @@ package results
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -47,6 +48,15 @@ type RawData struct {
 	ScanStartTime time.Time `json:"scan_start_time"`
 }
 
+// ArtifactData represents the payload for artifact upload requests.
+type ArtifactData struct {
+	Name          string    `json:"name"`
+	Data          []byte    `json:"data"`
+	CheckID       string    `json:"check_id"`
+	ScanID        string    `json:"scan_id"`
+	ScanStartTime time.Time `json:"scan_start_time"`
+}
+
 // Uploader is responsible for uploading reports and logs to vulcan-results.
 type Uploader struct {
 	retryer  Retryer
@@ -129,6 +139,54 @@ func (u *Uploader) UpdateCheckRaw(checkID string, scanStartTime time.Time, raw [
 	return logLocation, err
 }
 
+// UpdateCheckArtifact stores a file produced by a check in the results
+// service and returns a link that can be used to retrieve it.
+func (u *Uploader) UpdateCheckArtifact(checkID string, scanStartTime time.Time, name string, data []byte) (string, error) {
+	if len(data) > MaxEntitySize {
+		return "", fmt.Errorf("artifact %s of check %s is bigger than %d bytes, %w", name, checkID, MaxEntitySize, retryer.ErrPermanent)
+	}
+	artifactData := ArtifactData{
+		Name:          name,
+		Data:          data,
+		CheckID:       checkID,
+		ScanID:        checkID,
+		ScanStartTime: scanStartTime,
+	}
+	artifactDataBytes, err := json.Marshal(artifactData)
+	if err != nil {
+		return "", err
+	}
+	var location string
+	if u.retryer != nil {
+		err = u.retryer.WithRetries("Uploader.UploadArtifact", func() error {
+			location, err = u.jsonRequest("artifact", artifactDataBytes)
+			return err
+		})
+	} else {
+		location, err = u.jsonRequest("artifact", artifactDataBytes)
+	}
+	return location, err
+}
+
+// Ping checks that the results service is reachable. Any response with a
+// status code lower than 500 is considered valid.
+func (u *Uploader) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u.endpoint, nil)
+	if err != nil {
+		return err
+	}
+	c := http.Client{Timeout: u.timeout}
+	res, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("invalid response status: %s", res.Status)
+	}
+	return nil
+}
+
 func (u *Uploader) jsonRequest(route string, reqBody []byte) (string, error) {
 	var err error
 	url, err := url.Parse(u.endpoint)
@@ -150,6 +208,12 @@ func (u *Uploader) jsonRequest(route string, reqBody []byte) (string, error) {
 		return "", err
 	}
 
+	if res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusServiceUnavailable {
+		body := u.tryReadBody(res)
+		err := fmt.Errorf("invalid response, status: %s, body: %s", res.Status, body)
+		return "", retryer.WithRetryAfter(err, res)
+	}
+
 	location, exists := res.Header["Location"]
 	if !exists || len(location) <= 0 {
 		body := u.tryReadBody(res)
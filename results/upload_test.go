@@ -9,9 +9,12 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/retryer"
 	report "github.com/adevinta/vulcan-report"
 	"github.com/sirupsen/logrus"
 )
@@ -43,6 +46,13 @@ func buildMockReportServer() (*httptest.Server, *[]ReportData, *[]RawData) {
 				*reports = append(*reports, *msg)
 				w.Header().Add("Location", fmt.Sprintf("ref/%s", msg.CheckID))
 			}
+		case "/artifact":
+			msg := &ArtifactData{}
+			if err := json.NewDecoder(r.Body).Decode(msg); err != nil || string(msg.Data) != "pcap" {
+				status = http.StatusInternalServerError
+			} else {
+				w.Header().Add("Location", fmt.Sprintf("ref/%s/%s", msg.CheckID, msg.Name))
+			}
 		default:
 			fmt.Printf("enter default: %s", r.URL.Path)
 			status = http.StatusInternalServerError
@@ -133,3 +143,50 @@ func TestUploader_UpdateCheckReport(t *testing.T) {
 		})
 	}
 }
+
+func TestUploader_UpdateCheckArtifact(t *testing.T) {
+	srv, _, _ := buildMockReportServer()
+	defer srv.Close()
+	u := Uploader{
+		endpoint: srv.URL,
+		log:      logrus.New().WithField("test", "UpdateCheckArtifact"),
+		timeout:  time.Second,
+	}
+	got, err := u.UpdateCheckArtifact("id1", time.Time{}, "capture.pcap", []byte("pcap"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "ref/id1/capture.pcap"; got != want {
+		t.Errorf("want link %s, got %s", want, got)
+	}
+	if _, err := u.UpdateCheckArtifact("id1", time.Time{}, "big", make([]byte, MaxEntitySize+1)); err == nil {
+		t.Errorf("want error uploading an artifact bigger than the max size")
+	}
+}
+
+func TestUploader_RetryAfter(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Add("Location", "ref/id1")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+	// The interval of the backoff is long enough to make the test time out
+	// if the Retry-After header is not honored.
+	u := New(srv.URL, retryer.NewRetryer(2, 600, &log.NullLog{}), time.Second)
+	got, err := u.UpdateCheckRaw("id1", time.Time{}, []byte("log"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "ref/id1"; got != want {
+		t.Errorf("want link %s, got %s", want, got)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("want 2 requests, got %d", n)
+	}
+}
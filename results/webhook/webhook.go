@@ -0,0 +1,93 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+// Package webhook implements a results sink that sends the reports and the
+// logs of the checks to an http endpoint.
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/results"
+	"github.com/adevinta/vulcan-agent/retryer"
+	report "github.com/adevinta/vulcan-report"
+)
+
+// Payload is the body of the requests sent by the Sink. Only one of Report
+// and Raw is set in each request. Raw contains the logs of the check or, if
+// Artifact is set, the content of the artifact with that name.
+type Payload struct {
+	CheckID       string         `json:"check_id"`
+	ScanStartTime time.Time      `json:"scan_start_time"`
+	Report        *report.Report `json:"report,omitempty"`
+	Raw           []byte         `json:"raw,omitempty"`
+	Artifact      string         `json:"artifact,omitempty"`
+}
+
+// Sink posts the reports and the logs of the checks, as a JSON Payload, to
+// an endpoint. The link returned is the Location header of the response, if
+// any.
+type Sink struct {
+	endpoint string
+	retryer  results.Retryer
+	client   *http.Client
+}
+
+// New returns a Sink that posts the results to the given endpoint.
+func New(endpoint string, retryer results.Retryer, timeout time.Duration) *Sink {
+	return &Sink{
+		endpoint: endpoint,
+		retryer:  retryer,
+		client:   &http.Client{Timeout: timeout},
+	}
+}
+
+// UpdateCheckReport sends the report of a check to the endpoint.
+func (s *Sink) UpdateCheckReport(checkID string, scanStartTime time.Time, r report.Report) (string, error) {
+	return s.post(Payload{CheckID: checkID, ScanStartTime: scanStartTime, Report: &r})
+}
+
+// UpdateCheckRaw sends the logs of a check to the endpoint.
+func (s *Sink) UpdateCheckRaw(checkID string, scanStartTime time.Time, raw []byte) (string, error) {
+	return s.post(Payload{CheckID: checkID, ScanStartTime: scanStartTime, Raw: raw})
+}
+
+// UpdateCheckArtifact sends an artifact of a check to the endpoint.
+func (s *Sink) UpdateCheckArtifact(checkID string, scanStartTime time.Time, name string, data []byte) (string, error) {
+	return s.post(Payload{CheckID: checkID, ScanStartTime: scanStartTime, Raw: data, Artifact: name})
+}
+
+func (s *Sink) post(p Payload) (string, error) {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	var link string
+	post := func() error {
+		res, err := s.client.Post(s.endpoint, "application/json; charset=utf-8", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		switch {
+		case res.StatusCode >= 200 && res.StatusCode < 300:
+			link = res.Header.Get("Location")
+			return nil
+		case res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests:
+			return fmt.Errorf("invalid response status: %s", res.Status)
+		default:
+			return fmt.Errorf("invalid response status: %s, %w", res.Status, retryer.ErrPermanent)
+		}
+	}
+	if s.retryer != nil {
+		err = s.retryer.WithRetries("WebhookSink.Post", post)
+	} else {
+		err = post()
+	}
+	return link, err
+}
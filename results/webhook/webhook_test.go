@@ -0,0 +1,44 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSink(t *testing.T) {
+	var got []Payload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p Payload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		got = append(got, p)
+		w.Header().Set("Location", "http://results.example.com/"+p.CheckID)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	start := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	s := New(srv.URL, nil, time.Second)
+	link, err := s.UpdateCheckRaw("check1", start, []byte("logs"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if link != "http://results.example.com/check1" {
+		t.Errorf("unexpected link %s", link)
+	}
+	want := []Payload{{CheckID: "check1", ScanStartTime: start, Raw: []byte("logs")}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("want payloads != got payloads, diff: %s", diff)
+	}
+}
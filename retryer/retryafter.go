@@ -0,0 +1,83 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package retryer
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxRetryAfter is the maximum time the retryer waits for an operation that
+// returned a RetryAfterError, regardless of the time requested by the server.
+var MaxRetryAfter = 5 * time.Minute
+
+// RetryAfterError is an error returned by an operation that must be retried
+// after the given time instead of the time defined by the backoff policy.
+type RetryAfterError struct {
+	Err   error
+	After time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryAfterError) Unwrap() error {
+	return e.Err
+}
+
+// WithRetryAfter wraps the error of a failed HTTP request in a
+// RetryAfterError if the response contains a valid Retry-After header.
+// Otherwise it returns the error unchanged.
+func WithRetryAfter(err error, res *http.Response) error {
+	if res == nil {
+		return err
+	}
+	after, ok := ParseRetryAfter(res.Header.Get("Retry-After"), time.Now())
+	if !ok {
+		return err
+	}
+	return &RetryAfterError{Err: err, After: after}
+}
+
+// ParseRetryAfter parses the value of a Retry-After header, that can be a
+// number of seconds or an HTTP date, and returns the time to wait from now.
+func ParseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	after := t.Sub(now)
+	if after < 0 {
+		after = 0
+	}
+	return after, true
+}
+
+// retryAfter returns the time to wait before retrying an operation that
+// returned the given error, if the error wraps a RetryAfterError.
+func retryAfter(err error) (time.Duration, bool) {
+	var rerr *RetryAfterError
+	if !errors.As(err, &rerr) {
+		return 0, false
+	}
+	if rerr.After > MaxRetryAfter {
+		return MaxRetryAfter, true
+	}
+	return rerr.After, true
+}
@@ -7,6 +7,7 @@ package retryer
 import (
 	"context"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/adevinta/vulcan-agent/log"
@@ -17,12 +18,32 @@ import (
 // shortcircuits the retries process.
 var ErrPermanent = errors.New("permanent error")
 
+// Policy defines how an operation that returned an error is retried.
+type Policy int
+
+const (
+	// PolicyBackoff retries the operation after waiting the time defined by
+	// the exponential backoff, or the time requested by the server if the
+	// error is a RetryAfterError.
+	PolicyBackoff Policy = iota
+	// PolicyImmediate retries the operation without waiting.
+	PolicyImmediate
+	// PolicyPermanent does not retry the operation.
+	PolicyPermanent
+)
+
+// Classifier returns the policy to apply to an error returned by an
+// operation.
+type Classifier func(err error) Policy
+
 // Retryer allows to execute operations using a retries with exponential backoff
 // and optionally a shortcircuit function.
 type Retryer struct {
 	interval int
 	retries  int
 	log      log.Logger
+
+	classifier Classifier
 }
 
 // NewRetryer allows to execute operations with retries and shortcircuit.
@@ -34,22 +55,41 @@ func NewRetryer(retries, interval int, l log.Logger) Retryer {
 	}
 }
 
+// WithClassifier returns a copy of the retryer that uses the given function
+// to decide how to retry the errors returned by the operations. The errors
+// wrapping ErrPermanent are never retried, regardless of the classifier.
+func (b Retryer) WithClassifier(c Classifier) Retryer {
+	b.classifier = c
+	return b
+}
+
 // WithRetries executes the openation named "op", specified in the "exec"
 // function using the exponential retries with backoff policy defined in the
 // receiver.
 func (b Retryer) WithRetries(op string, exec func() error) error {
+	return b.WithRetriesCtx(context.Background(), op, exec)
+}
+
+// WithRetriesCtx works like WithRetries but stops retrying as soon as the
+// given context is done, even if it is waiting between retries, so an
+// operation never runs past the deadline of the context. In that case it
+// returns an error that wraps the error of the context.
+func (b Retryer) WithRetriesCtx(ctx context.Context, op string, exec func() error) error {
 	var err error
 	policy := backoff.NewExponential(
 		backoff.WithInterval(time.Duration(b.interval)*time.Second),
 		backoff.WithJitterFactor(0.05),
 		backoff.WithMaxRetries(b.retries),
 	)
-	retry, cancel := policy.Start(context.Background())
+	retry, cancel := policy.Start(ctx)
 	defer cancel()
 	// In order to avoid counting the first call to the function as a retry we
 	// initialize the retries counter to -1.
 	retries := -1
 	for {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return b.aborted(op, ctxErr, err)
+		}
 		err = exec()
 		retries++
 		if err == nil {
@@ -57,7 +97,8 @@ func (b Retryer) WithRetries(op string, exec func() error) error {
 		}
 		// Here we check if the error thar we are getting is a controlled one or not, that is,
 		// if makes sense to continue retrying or not.
-		if errors.Is(err, ErrPermanent) {
+		policy := b.classify(err)
+		if policy == PolicyPermanent {
 			b.log.Errorf(" ErrPersistent returned backoff finished, operation %+v, err %+v", op, err)
 			return err
 		}
@@ -65,7 +106,55 @@ func (b Retryer) WithRetries(op string, exec func() error) error {
 			b.log.Errorf("backoff finished at retry %d, unable to to finish operation %s, err %+v", retries, op, err)
 			return err
 		}
-		<-retry.Next()
+		if !b.wait(ctx, retry, policy, err) {
+			return b.aborted(op, ctx.Err(), err)
+		}
 		b.log.Errorf("retrying operation, retry: %d, operation  %s, err %+v", retries, op, err)
 	}
 }
+
+// classify returns the policy to apply to the given error.
+func (b Retryer) classify(err error) Policy {
+	if errors.Is(err, ErrPermanent) {
+		return PolicyPermanent
+	}
+	if b.classifier == nil {
+		return PolicyBackoff
+	}
+	return b.classifier(err)
+}
+
+// wait waits before retrying an operation that returned the given error,
+// according to the policy. It returns false if the context is done before
+// the wait finishes.
+func (b Retryer) wait(ctx context.Context, retry backoff.Backoff, policy Policy, err error) bool {
+	if policy == PolicyImmediate {
+		return ctx.Err() == nil
+	}
+	if after, ok := retryAfter(err); ok {
+		t := time.NewTimer(after)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return false
+		case <-t.C:
+			return true
+		}
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-retry.Next():
+		return true
+	}
+}
+
+// aborted logs and returns the error of an operation that was stopped
+// because its context is done.
+func (b Retryer) aborted(op string, ctxErr, last error) error {
+	b.log.Errorf("retries aborted, operation %s, last err %+v: %+v", op, last, ctxErr)
+	if last == nil {
+		return fmt.Errorf("operation %s aborted: %w", op, ctxErr)
+	}
+	return fmt.Errorf("operation %s aborted, last error: %v: %w", op, last, ctxErr)
+}
@@ -5,9 +5,11 @@ Copyright 2021 Adevinta
 package retryer
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/adevinta/vulcan-agent/log"
 )
@@ -141,3 +143,173 @@ func TestRetryer_WithRetries(t *testing.T) {
 		})
 	}
 }
+
+func TestRetryer_WithRetriesCtx(t *testing.T) {
+	tests := []struct {
+		name        string
+		ctx         func() (context.Context, context.CancelFunc)
+		op          func(ctx context.Context, cancel context.CancelFunc, calls int) error
+		wantErr     error
+		wantOpCalls int
+	}{
+		{
+			name: "DoesNotExecIfCanceled",
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx, cancel
+			},
+			op: func(ctx context.Context, cancel context.CancelFunc, calls int) error {
+				return nil
+			},
+			wantErr:     context.Canceled,
+			wantOpCalls: 0,
+		},
+		{
+			name: "StopsRetryingWhenCanceled",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithCancel(context.Background())
+			},
+			op: func(ctx context.Context, cancel context.CancelFunc, calls int) error {
+				if calls == 2 {
+					cancel()
+				}
+				return errTest
+			},
+			wantErr:     context.Canceled,
+			wantOpCalls: 2,
+		},
+		{
+			name: "HonorsDeadline",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 10*time.Millisecond)
+			},
+			op: func(ctx context.Context, cancel context.CancelFunc, calls int) error {
+				<-ctx.Done()
+				return errTest
+			},
+			wantErr:     context.DeadlineExceeded,
+			wantOpCalls: 1,
+		},
+		{
+			name: "ReturnsNilIfNoError",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithCancel(context.Background())
+			},
+			op: func(ctx context.Context, cancel context.CancelFunc, calls int) error {
+				return nil
+			},
+			wantErr:     nil,
+			wantOpCalls: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewRetryer(100, 60, &log.NullLog{})
+			ctx, cancel := tt.ctx()
+			defer cancel()
+			calls := 0
+			err := b.WithRetriesCtx(ctx, tt.name, func() error {
+				calls++
+				return tt.op(ctx, cancel, calls)
+			})
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("wantErr != err, err %+v", err)
+			}
+			if tt.wantOpCalls != calls {
+				t.Fatalf("wantCalls != gotCalls, %d!=%d", tt.wantOpCalls, calls)
+			}
+		})
+	}
+}
+
+func TestRetryer_WithClassifier(t *testing.T) {
+	errImmediate := errors.New("immediate")
+	errFatal := errors.New("fatal")
+	classifier := func(err error) Policy {
+		switch {
+		case errors.Is(err, errImmediate):
+			return PolicyImmediate
+		case errors.Is(err, errFatal):
+			return PolicyPermanent
+		}
+		return PolicyBackoff
+	}
+	tests := []struct {
+		name        string
+		op          func(calls int) error
+		wantErr     error
+		wantOpCalls int
+	}{
+		{
+			name: "RetriesImmediately",
+			op: func(calls int) error {
+				if calls == 3 {
+					return nil
+				}
+				return errImmediate
+			},
+			wantErr:     nil,
+			wantOpCalls: 3,
+		},
+		{
+			name: "StopsOnPermanentPolicy",
+			op: func(calls int) error {
+				return errFatal
+			},
+			wantErr:     errFatal,
+			wantOpCalls: 1,
+		},
+		{
+			name: "HonorsRetryAfter",
+			op: func(calls int) error {
+				if calls == 2 {
+					return nil
+				}
+				return &RetryAfterError{Err: errTest, After: 10 * time.Millisecond}
+			},
+			wantErr:     nil,
+			wantOpCalls: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The interval is long enough to make the test time out if
+			// the backoff is used.
+			b := NewRetryer(2, 600, &log.NullLog{}).WithClassifier(classifier)
+			e := &ExecTester{op: tt.op}
+			err := b.WithRetries(tt.name, e.exec)
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("wantErr != err, err %+v", err)
+			}
+			if tt.wantOpCalls != e.NOfCalls {
+				t.Fatalf("wantCalls != gotCalls, %d!=%d", tt.wantOpCalls, e.NOfCalls)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{name: "Seconds", value: "120", want: 2 * time.Minute, wantOK: true},
+		{name: "Date", value: "Sat, 01 Jan 2022 00:00:30 GMT", want: 30 * time.Second, wantOK: true},
+		{name: "PastDate", value: "Fri, 31 Dec 2021 00:00:00 GMT", want: 0, wantOK: true},
+		{name: "Empty", value: "", wantOK: false},
+		{name: "Negative", value: "-1", wantOK: false},
+		{name: "Invalid", value: "soon", wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseRetryAfter(tt.value, now)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("want %v %v, got %v %v", tt.want, tt.wantOK, got, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCron_Next(t *testing.T) {
+	// 2022-01-03 is a Monday.
+	base := time.Date(2022, 1, 3, 10, 17, 30, 0, time.UTC)
+	tests := []struct {
+		expr    string
+		want    time.Time
+		wantErr bool
+	}{
+		{expr: "* * * * *", want: time.Date(2022, 1, 3, 10, 18, 0, 0, time.UTC)},
+		{expr: "*/15 * * * *", want: time.Date(2022, 1, 3, 10, 30, 0, 0, time.UTC)},
+		{expr: "0 3 * * *", want: time.Date(2022, 1, 4, 3, 0, 0, 0, time.UTC)},
+		{expr: "@hourly", want: time.Date(2022, 1, 3, 11, 0, 0, 0, time.UTC)},
+		{expr: "0 0 1 * *", want: time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{expr: "30 9 * * 1-5", want: time.Date(2022, 1, 4, 9, 30, 0, 0, time.UTC)},
+		{expr: "0 0 * * 7", want: time.Date(2022, 1, 9, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 15 * 5", want: time.Date(2022, 1, 7, 0, 0, 0, 0, time.UTC)},
+		{expr: "5,10 10-12/2 * 3 *", want: time.Date(2022, 3, 1, 10, 5, 0, 0, time.UTC)},
+		{expr: "0 0 30 2 *", wantErr: true},
+		{expr: "60 * * * *", wantErr: true},
+		{expr: "* * *", wantErr: true},
+		{expr: "*/0 * * * *", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			c, err := ParseCron(tt.expr)
+			var got time.Time
+			if err == nil {
+				got, err = c.Next(base)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("want error %v, got %v", tt.wantErr, err)
+			}
+			if !got.Equal(tt.want) {
+				t.Fatalf("want next %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
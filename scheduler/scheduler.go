@@ -0,0 +1,189 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/jobrunner"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/queue"
+	"github.com/google/uuid"
+)
+
+type schedule struct {
+	cron *Cron
+	cfg  config.ScheduleConfig
+	next time.Time
+}
+
+// Reader generates the messages of the checks defined in the configured
+// schedules and passes them to a message processor when they are due. It
+// implements the queue.Reader interface so it can be used by the agent
+// instead of a queue when there is no upstream scheduler service.
+type Reader struct {
+	*sync.RWMutex
+	schedules           []*schedule
+	processor           queue.MessageProcessor
+	log                 log.Logger
+	wg                  *sync.WaitGroup
+	lastMessageReceived *time.Time
+	now                 func() time.Time
+}
+
+func init() {
+	queue.Register(config.QueueTypeSchedules, func(l log.Logger, cfg config.Config, _ *queue.IdleLimit, processor queue.MessageProcessor) (queue.Reader, error) {
+		r, err := NewReader(l, cfg.Schedules, processor)
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
+	})
+}
+
+// NewReader creates a Reader for the given schedules.
+func NewReader(l log.Logger, schedules []config.ScheduleConfig, processor queue.MessageProcessor) (*Reader, error) {
+	if len(schedules) == 0 {
+		return nil, errors.New("no schedules defined")
+	}
+	var scheds []*schedule
+	for _, s := range schedules {
+		c, err := ParseCron(s.Cron)
+		if err != nil {
+			return nil, err
+		}
+		if s.Image == "" || s.Target == "" {
+			return nil, fmt.Errorf("schedule %q must define an image and a target", s.Cron)
+		}
+		scheds = append(scheds, &schedule{cron: c, cfg: s})
+	}
+	return &Reader{
+		RWMutex:   &sync.RWMutex{},
+		schedules: scheds,
+		processor: processor,
+		log:       l,
+		wg:        &sync.WaitGroup{},
+		now:       time.Now,
+	}, nil
+}
+
+// StartReading starts generating the messages of the scheduled checks. It
+// stops when the passed in context is canceled. The returned channel is
+// written when the Reader stopped and all the checks it started finished.
+func (r *Reader) StartReading(ctx context.Context) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		err := r.run(ctx)
+		r.wg.Wait()
+		done <- err
+		close(done)
+	}()
+	return done
+}
+
+func (r *Reader) run(ctx context.Context) error {
+	now := r.now()
+	for _, s := range r.schedules {
+		if err := s.schedule(now); err != nil {
+			return err
+		}
+	}
+	for {
+		next := r.schedules[0].next
+		for _, s := range r.schedules[1:] {
+			if s.next.Before(next) {
+				next = s.next
+			}
+		}
+		timer := time.NewTimer(next.Sub(r.now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		for _, s := range r.schedules {
+			if s.next.After(next) {
+				continue
+			}
+			if err := r.launch(ctx, s.cfg); err != nil {
+				return err
+			}
+			// Skip the executions missed while waiting for a free token.
+			after := r.now()
+			if after.Before(next) {
+				after = next
+			}
+			if err := s.schedule(after); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *schedule) schedule(after time.Time) error {
+	next, err := s.cron.Next(after)
+	if err != nil {
+		return fmt.Errorf("scheduling %q: %w", s.cfg.Cron, err)
+	}
+	s.next = next
+	return nil
+}
+
+// launch waits for a free token and passes a message with a new check,
+// generated from the given schedule, to the processor.
+func (r *Reader) launch(ctx context.Context, cfg config.ScheduleConfig) error {
+	var token interface{}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case token = <-r.processor.FreeTokens():
+	}
+	now := r.now()
+	j := jobrunner.Job{
+		CheckID:      uuid.NewString(),
+		StartTime:    now,
+		Image:        cfg.Image,
+		Target:       cfg.Target,
+		Timeout:      cfg.Timeout,
+		AssetType:    cfg.AssetType,
+		Options:      cfg.Options,
+		RequiredVars: cfg.RequiredVars,
+		Metadata:     cfg.Metadata,
+	}
+	body, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	r.setLastMessageReceived(&now)
+	r.log.Infof("launching scheduled check %s, image %s, target %s", j.CheckID, j.Image, j.Target)
+	processed := r.processor.ProcessMessage(queue.Message{Body: string(body), TimesRead: 1, Local: true}, token)
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		<-processed
+	}()
+	return nil
+}
+
+func (r *Reader) setLastMessageReceived(t *time.Time) {
+	r.Lock()
+	r.lastMessageReceived = t
+	r.Unlock()
+}
+
+// LastMessageReceived returns the time where the last scheduled check was
+// launched. If no check was launched so far it returns nil.
+func (r *Reader) LastMessageReceived() *time.Time {
+	r.RLock()
+	defer r.RUnlock()
+	return r.lastMessageReceived
+}
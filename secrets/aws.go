@@ -0,0 +1,80 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package secrets
+
+import (
+	"context"
+	"errors"
+
+	"github.com/adevinta/vulcan-agent/awscreds"
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// SecretsManager reads secrets from AWS Secrets Manager. The path of a secret
+// is its name or ARN. When an ARN is used the region of the ARN is used to
+// query the secret.
+type SecretsManager struct {
+	// Endpoint overrides the default endpoint of the service if not empty.
+	Endpoint string
+}
+
+// Secret returns the string value of the current version of a secret.
+func (s *SecretsManager) Secret(ctx context.Context, path string) (string, error) {
+	sess, err := awscreds.NewSession(config.AWSCredentialsConfig{})
+	if err != nil {
+		return "", err
+	}
+	srv := secretsmanager.New(sess, awsConfig(path, s.Endpoint))
+	out, err := srv.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(path),
+	})
+	if err != nil {
+		return "", err
+	}
+	if out.SecretString == nil {
+		return "", errors.New("secret has no string value")
+	}
+	return *out.SecretString, nil
+}
+
+// ParameterStore reads secrets from AWS SSM Parameter Store. The path of a
+// secret is the name or the ARN of the parameter. SecureString parameters are
+// decrypted.
+type ParameterStore struct {
+	// Endpoint overrides the default endpoint of the service if not empty.
+	Endpoint string
+}
+
+// Secret returns the value of a parameter.
+func (p *ParameterStore) Secret(ctx context.Context, path string) (string, error) {
+	sess, err := awscreds.NewSession(config.AWSCredentialsConfig{})
+	if err != nil {
+		return "", err
+	}
+	srv := ssm.New(sess, awsConfig(path, p.Endpoint))
+	out, err := srv.GetParameterWithContext(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(path),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(out.Parameter.Value), nil
+}
+
+func awsConfig(path, endpoint string) *aws.Config {
+	awsCfg := aws.NewConfig()
+	if a, err := arn.Parse(path); err == nil && a.Region != "" {
+		awsCfg = awsCfg.WithRegion(a.Region)
+	}
+	if endpoint != "" {
+		awsCfg = awsCfg.WithEndpoint(endpoint)
+	}
+	return awsCfg
+}
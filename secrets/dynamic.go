@@ -0,0 +1,109 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+)
+
+const revokeTimeout = 30 * time.Second
+
+// Issuer issues dynamic credentials, using Vault, for the required vars of
+// the checks. Every check gets its own credentials, which are revoked when
+// the check finishes. Each dynamic var is defined by a reference to a Vault
+// path and the key of the credentials to use, e.g.:
+// "vault://database/creds/readonly#password". The vars of a check with the
+// same path share the same lease, so the "username" and the "password" of
+// a database role are consistent.
+type Issuer struct {
+	vault *Vault
+	vars  map[string]dynamicVar
+	log   log.Logger
+}
+
+type dynamicVar struct {
+	path, key string
+}
+
+// NewIssuer returns an Issuer for the given dynamic vars. It uses the Vault
+// server defined in the secrets config.
+func NewIssuer(l log.Logger, cfg config.SecretsConfig, vars map[string]string) (*Issuer, error) {
+	addr := cfg.VaultAddress
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	if addr == "" {
+		return nil, errors.New("a vault address is required to issue dynamic vars")
+	}
+	v, err := NewVault(addr, cfg.VaultToken)
+	if err != nil {
+		return nil, err
+	}
+	dvars := map[string]dynamicVar{}
+	for name, ref := range vars {
+		scheme, path, key, ok := parseReference(ref)
+		if !ok || scheme != SchemeVault || key == "" {
+			return nil, fmt.Errorf("invalid dynamic var %s: %q is not a vault reference with a key", name, ref)
+		}
+		dvars[name] = dynamicVar{path: path, key: key}
+	}
+	return &Issuer{vault: v, vars: dvars, log: l}, nil
+}
+
+// Issue generates the dynamic credentials for the given required vars of a
+// check. The required vars that are not dynamic are ignored. The returned
+// func revokes the credentials and must be called when the check finishes.
+func (i *Issuer) Issue(ctx context.Context, checkID string, requiredVars []string) (map[string]string, func(), error) {
+	var (
+		vars   = map[string]string{}
+		leases = map[string]Lease{}
+	)
+	for _, name := range requiredVars {
+		dv, ok := i.vars[name]
+		if !ok {
+			continue
+		}
+		lease, ok := leases[dv.path]
+		if !ok {
+			var err error
+			lease, err = i.vault.Lease(ctx, dv.path)
+			if err != nil {
+				i.revoke(checkID, leases)
+				return nil, nil, fmt.Errorf("issuing dynamic var %s: %w", name, err)
+			}
+			leases[dv.path] = lease
+		}
+		val, ok := lease.Data[dv.key]
+		if !ok {
+			i.revoke(checkID, leases)
+			return nil, nil, fmt.Errorf("issuing dynamic var %s: key %q not found in %s", name, dv.key, dv.path)
+		}
+		vars[name] = fmt.Sprint(val)
+	}
+	if len(leases) > 0 {
+		i.log.Infof("issued %d dynamic credentials for check %s", len(leases), checkID)
+	}
+	return vars, func() { i.revoke(checkID, leases) }, nil
+}
+
+func (i *Issuer) revoke(checkID string, leases map[string]Lease) {
+	ctx, cancel := context.WithTimeout(context.Background(), revokeTimeout)
+	defer cancel()
+	for path, l := range leases {
+		if l.ID == "" {
+			continue
+		}
+		if err := i.vault.Revoke(ctx, l.ID); err != nil {
+			i.log.Errorf("error revoking dynamic credentials %s of check %s: %+v", path, checkID, err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeVault issues a new lease for every read of the database/creds/check
+// path and records the revoked leases.
+type fakeVault struct {
+	sync.Mutex
+	issued  int
+	revoked []string
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/database/creds/check":
+		f.issued++
+		fmt.Fprintf(w, `{"lease_id": "lease%d", "lease_duration": 60, "data": {"username": "user%d", "password": "pass%d"}}`,
+			f.issued, f.issued, f.issued)
+	case r.Method == http.MethodPut && r.URL.Path == "/v1/sys/leases/revoke":
+		var body struct {
+			LeaseID string `json:"lease_id"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.revoked = append(f.revoked, body.LeaseID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestIssuer_Issue(t *testing.T) {
+	fv := &fakeVault{}
+	srv := httptest.NewServer(fv)
+	defer srv.Close()
+
+	i, err := NewIssuer(&log.NullLog{}, config.SecretsConfig{VaultAddress: srv.URL}, map[string]string{
+		"DB_USER":  "vault://database/creds/check#username",
+		"DB_PASS":  "vault://database/creds/check#password",
+		"DB_OTHER": "vault://database/creds/check#other",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	vars, release, err := i.Issue(context.Background(), "check1", []string{"DB_USER", "DB_PASS", "STATIC"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]string{"DB_USER": "user1", "DB_PASS": "pass1"}
+	if diff := cmp.Diff(want, vars); diff != "" {
+		t.Errorf("want vars != got vars, diff: %s", diff)
+	}
+	release()
+	if diff := cmp.Diff([]string{"lease1"}, fv.revoked); diff != "" {
+		t.Errorf("want revoked != got revoked, diff: %s", diff)
+	}
+
+	// A missing key revokes the leases already issued.
+	if _, _, err := i.Issue(context.Background(), "check2", []string{"DB_USER", "DB_OTHER"}); err == nil {
+		t.Fatalf("want error issuing a missing key")
+	}
+	if diff := cmp.Diff([]string{"lease1", "lease2"}, fv.revoked); diff != "" {
+		t.Errorf("want revoked != got revoked, diff: %s", diff)
+	}
+}
+
+func TestNewIssuer_InvalidVar(t *testing.T) {
+	_, err := NewIssuer(&log.NullLog{}, config.SecretsConfig{VaultAddress: "http://localhost:8200"}, map[string]string{
+		"DB_PASS": "ssm:///vulcan/pass",
+	})
+	if err == nil {
+		t.Fatalf("want error for a non vault dynamic var")
+	}
+}
@@ -0,0 +1,240 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+// Package secrets resolves the references to secrets stored in external
+// providers that can be used in the config of the agent instead of plain text
+// values. A reference has the form: scheme://path[#key], where the scheme
+// selects the provider:
+//
+//	awssm://<secret name or ARN>[#key] AWS Secrets Manager.
+//	ssm://<parameter name>[#key]       AWS SSM Parameter Store.
+//	vault://<path>#key                 HashiCorp Vault.
+//
+// When a key is specified the secret must be a JSON object and the value of
+// the key is returned.
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/adevinta/vulcan-agent/config"
+)
+
+// Schemes of the supported providers.
+const (
+	SchemeSecretsManager = "awssm"
+	SchemeParameterStore = "ssm"
+	SchemeVault          = "vault"
+)
+
+// ErrUnknownProvider is returned when a reference uses a scheme that doesn't
+// correspond to any configured provider.
+var ErrUnknownProvider = errors.New("unknown secrets provider")
+
+// Provider returns the value of the secret stored in the given path.
+type Provider interface {
+	Secret(ctx context.Context, path string) (string, error)
+}
+
+// Resolver resolves references to secrets using the providers registered
+// for their schemes.
+type Resolver struct {
+	providers map[string]Provider
+}
+
+// NewResolver returns a Resolver with the providers enabled by the given
+// config. The AWS providers are always enabled, the Vault provider only when
+// a Vault address is configured, or defined in the VAULT_ADDR environment
+// variable.
+func NewResolver(cfg config.SecretsConfig) (*Resolver, error) {
+	r := &Resolver{providers: map[string]Provider{
+		SchemeSecretsManager: &SecretsManager{Endpoint: cfg.SecretsManagerEndpoint},
+		SchemeParameterStore: &ParameterStore{Endpoint: cfg.SSMEndpoint},
+	}}
+	addr := cfg.VaultAddress
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	if addr != "" {
+		v, err := NewVault(addr, cfg.VaultToken)
+		if err != nil {
+			return nil, err
+		}
+		r.providers[SchemeVault] = v
+	}
+	return r, nil
+}
+
+// IsReference returns true if the given value is a reference to a secret of
+// a supported provider.
+func IsReference(v string) bool {
+	scheme, _, _, ok := parseReference(v)
+	if !ok {
+		return false
+	}
+	switch scheme {
+	case SchemeSecretsManager, SchemeParameterStore, SchemeVault:
+		return true
+	}
+	return false
+}
+
+// Resolve returns the value of the secret referenced by v. If v is not a
+// reference it's returned as is.
+func (r *Resolver) Resolve(ctx context.Context, v string) (string, error) {
+	if !IsReference(v) {
+		return v, nil
+	}
+	scheme, path, key, _ := parseReference(v)
+	p, ok := r.providers[scheme]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownProvider, scheme)
+	}
+	secret, err := p.Secret(ctx, path)
+	if err != nil {
+		return "", fmt.Errorf("resolving secret %s://%s: %w", scheme, path, err)
+	}
+	if key == "" {
+		return secret, nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(secret), &fields); err != nil {
+		return "", fmt.Errorf("secret %s://%s is not a JSON object: %w", scheme, path, err)
+	}
+	val, ok := fields[key]
+	if !ok {
+		return "", fmt.Errorf("key %q not found in secret %s://%s", key, scheme, path)
+	}
+	if s, ok := val.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(val), nil
+}
+
+// ResolveConfig returns a copy of the given config with the references in
+// the check vars, the registry passwords, the notification secrets, the API
+// tokens, the audit key and the message auth keys replaced by the values of
+// the secrets.
+func (r *Resolver) ResolveConfig(ctx context.Context, cfg config.Config) (config.Config, error) {
+	if cfg.Check.Vars != nil {
+		vars := make(map[string]string, len(cfg.Check.Vars))
+		for k, v := range cfg.Check.Vars {
+			val, err := r.Resolve(ctx, v)
+			if err != nil {
+				return config.Config{}, fmt.Errorf("check var %s: %w", k, err)
+			}
+			vars[k] = val
+		}
+		cfg.Check.Vars = vars
+	}
+	if cfg.Check.ChecktypeVars != nil {
+		scoped := make(map[string]map[string]string, len(cfg.Check.ChecktypeVars))
+		for ct, ctVars := range cfg.Check.ChecktypeVars {
+			vars := make(map[string]string, len(ctVars))
+			for k, v := range ctVars {
+				val, err := r.Resolve(ctx, v)
+				if err != nil {
+					return config.Config{}, fmt.Errorf("check var %s of %s: %w", k, ct, err)
+				}
+				vars[k] = val
+			}
+			scoped[ct] = vars
+		}
+		cfg.Check.ChecktypeVars = scoped
+	}
+	reg := &cfg.Runtime.Docker.Registry
+	pass, err := r.Resolve(ctx, reg.Pass)
+	if err != nil {
+		return config.Config{}, fmt.Errorf("registry pass: %w", err)
+	}
+	reg.Pass = pass
+	if reg.Auths != nil {
+		auths := make([]config.Auth, len(reg.Auths))
+		for i, a := range reg.Auths {
+			a.Pass, err = r.Resolve(ctx, a.Pass)
+			if err != nil {
+				return config.Config{}, fmt.Errorf("registry %s pass: %w", a.Server, err)
+			}
+			auths[i] = a
+		}
+		reg.Auths = auths
+	}
+	if cfg.Notifications.Webhooks != nil {
+		webhooks := make([]config.WebhookConfig, len(cfg.Notifications.Webhooks))
+		for i, w := range cfg.Notifications.Webhooks {
+			w.Secret, err = r.Resolve(ctx, w.Secret)
+			if err != nil {
+				return config.Config{}, fmt.Errorf("webhook %s secret: %w", w.URL, err)
+			}
+			webhooks[i] = w
+		}
+		cfg.Notifications.Webhooks = webhooks
+	}
+	cfg.API.Debug.Token, err = r.Resolve(ctx, cfg.API.Debug.Token)
+	if err != nil {
+		return config.Config{}, fmt.Errorf("api debug token: %w", err)
+	}
+	cfg.API.Auth.Token, err = r.Resolve(ctx, cfg.API.Auth.Token)
+	if err != nil {
+		return config.Config{}, fmt.Errorf("api auth token: %w", err)
+	}
+	cfg.ServiceBusReader.ConnectionString, err = r.Resolve(ctx, cfg.ServiceBusReader.ConnectionString)
+	if err != nil {
+		return config.Config{}, fmt.Errorf("service bus reader connection string: %w", err)
+	}
+	cfg.StateUpdater.ServiceBus.ConnectionString, err = r.Resolve(ctx, cfg.StateUpdater.ServiceBus.ConnectionString)
+	if err != nil {
+		return config.Config{}, fmt.Errorf("service bus writer connection string: %w", err)
+	}
+	cfg.Audit.Key, err = r.Resolve(ctx, cfg.Audit.Key)
+	if err != nil {
+		return config.Config{}, fmt.Errorf("audit key: %w", err)
+	}
+	auth := &cfg.MessageAuth
+	auth.Key, err = r.Resolve(ctx, auth.Key)
+	if err != nil {
+		return config.Config{}, fmt.Errorf("message auth key: %w", err)
+	}
+	auth.PublicKey, err = r.Resolve(ctx, auth.PublicKey)
+	if err != nil {
+		return config.Config{}, fmt.Errorf("message auth public key: %w", err)
+	}
+	auth.SigningKey, err = r.Resolve(ctx, auth.SigningKey)
+	if err != nil {
+		return config.Config{}, fmt.Errorf("message auth signing key: %w", err)
+	}
+	if cfg.Notifications.Chats != nil {
+		chats := make([]config.ChatConfig, len(cfg.Notifications.Chats))
+		for i, c := range cfg.Notifications.Chats {
+			c.URL, err = r.Resolve(ctx, c.URL)
+			if err != nil {
+				return config.Config{}, fmt.Errorf("%s chat url: %w", c.Type, err)
+			}
+			chats[i] = c
+		}
+		cfg.Notifications.Chats = chats
+	}
+	return cfg, nil
+}
+
+// parseReference splits a reference in its scheme, path and key.
+func parseReference(v string) (scheme, path, key string, ok bool) {
+	i := strings.Index(v, "://")
+	if i <= 0 {
+		return "", "", "", false
+	}
+	scheme, path = v[:i], v[i+3:]
+	if j := strings.LastIndex(path, "#"); j >= 0 {
+		path, key = path[:j], path[j+1:]
+	}
+	if path == "" {
+		return "", "", "", false
+	}
+	return scheme, path, key, true
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package secrets
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/google/go-cmp/cmp"
+)
+
+type mapProvider map[string]string
+
+func (m mapProvider) Secret(ctx context.Context, path string) (string, error) {
+	v, ok := m[path]
+	if !ok {
+		return "", errors.New("secret not found")
+	}
+	return v, nil
+}
+
+func TestResolver_Resolve(t *testing.T) {
+	r := &Resolver{providers: map[string]Provider{
+		SchemeParameterStore: mapProvider{
+			"/vulcan/token": "token",
+			"/vulcan/creds": `{"user": "vulcan", "pass": "secret", "port": 5432}`,
+		},
+	}}
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{name: "PlainValue", value: "plain", want: "plain"},
+		{name: "UnknownScheme", value: "https://example.com", want: "https://example.com"},
+		{name: "Reference", value: "ssm:///vulcan/token", want: "token"},
+		{name: "ReferenceWithKey", value: "ssm:///vulcan/creds#pass", want: "secret"},
+		{name: "NonStringKey", value: "ssm:///vulcan/creds#port", want: "5432"},
+		{name: "MissingKey", value: "ssm:///vulcan/creds#other", wantErr: true},
+		{name: "MissingSecret", value: "ssm:///vulcan/other", wantErr: true},
+		{name: "ProviderNotConfigured", value: "vault://secret/data/vulcan#pass", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.Resolve(context.Background(), tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestResolver_ResolveConfig(t *testing.T) {
+	r := &Resolver{providers: map[string]Provider{
+		SchemeSecretsManager: mapProvider{"vulcan": `{"token": "token", "pass": "pass"}`},
+	}}
+	var cfg config.Config
+	cfg.Check.Vars = map[string]string{"TOKEN": "awssm://vulcan#token", "PLAIN": "plain"}
+	cfg.Check.ChecktypeVars = map[string]map[string]string{"vulcan-nessus": {"PASS": "awssm://vulcan#pass"}}
+	cfg.Runtime.Docker.Registry.Pass = "awssm://vulcan#pass"
+	cfg.Runtime.Docker.Registry.Auths = []config.Auth{{Server: "registry", User: "user", Pass: "awssm://vulcan#pass"}}
+
+	got, err := r.ResolveConfig(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var want config.Config
+	want.Check.Vars = map[string]string{"TOKEN": "token", "PLAIN": "plain"}
+	want.Check.ChecktypeVars = map[string]map[string]string{"vulcan-nessus": {"PASS": "pass"}}
+	want.Runtime.Docker.Registry.Pass = "pass"
+	want.Runtime.Docker.Registry.Auths = []config.Auth{{Server: "registry", User: "user", Pass: "pass"}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("want config != got config, diff: %s", diff)
+	}
+	// The original config must not be modified.
+	if cfg.Check.Vars["TOKEN"] != "awssm://vulcan#token" || cfg.Runtime.Docker.Registry.Auths[0].Pass != "awssm://vulcan#pass" {
+		t.Errorf("original config modified: %+v", cfg)
+	}
+}
+
+func TestVault_Secret(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/kv/vulcan":
+			w.Write([]byte(`{"data": {"pass": "v1"}}`))
+		case "/v1/secret/data/vulcan":
+			w.Write([]byte(`{"data": {"data": {"pass": "v2"}, "metadata": {"version": 1}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	v, err := NewVault(srv.URL, "token")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r := &Resolver{providers: map[string]Provider{SchemeVault: v}}
+	for ref, want := range map[string]string{
+		"vault://kv/vulcan#pass":          "v1",
+		"vault://secret/data/vulcan#pass": "v2",
+	} {
+		got, err := r.Resolve(context.Background(), ref)
+		if err != nil {
+			t.Fatalf("unexpected error resolving %s: %v", ref, err)
+		}
+		if got != want {
+			t.Errorf("%s: want %q, got %q", ref, want, got)
+		}
+	}
+	if _, err := r.Resolve(context.Background(), "vault://secret/data/other#pass"); err == nil {
+		t.Errorf("want error resolving a missing secret")
+	}
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const vaultTimeout = 10 * time.Second
+
+// Vault reads secrets from the key/value secrets engines of a HashiCorp Vault
+// server. Both versions of the engine are supported: for the version 2 the
+// path must include the "data" segment, e.g.: secret/data/vulcan.
+type Vault struct {
+	addr   *url.URL
+	token  string
+	client *http.Client
+}
+
+// NewVault returns a Vault provider for the server in the given address. If
+// the token is empty the one in the VAULT_TOKEN environment variable is used.
+func NewVault(addr, token string) (*Vault, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vault address: %w", err)
+	}
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	return &Vault{
+		addr:   u,
+		token:  token,
+		client: &http.Client{Timeout: vaultTimeout},
+	}, nil
+}
+
+// Secret returns the data of the secret in the given path encoded as a JSON
+// object.
+func (v *Vault) Secret(ctx context.Context, path string) (string, error) {
+	var resp struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := v.get(ctx, path, &resp); err != nil {
+		return "", err
+	}
+	if resp.Data == nil {
+		return "", errors.New("vault secret has no data")
+	}
+	data := resp.Data
+	// The version 2 of the key/value engine nests the data of the secret
+	// along with its metadata.
+	if nested, ok := data["data"]; ok {
+		if _, ok := data["metadata"]; ok {
+			return string(nested), nil
+		}
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Lease contains the data of a secret with a lease, like the dynamic
+// credentials generated by the database or cloud secrets engines.
+type Lease struct {
+	ID       string
+	Duration time.Duration
+	Data     map[string]interface{}
+}
+
+// Lease reads the secret in the given path, generating a new lease.
+func (v *Vault) Lease(ctx context.Context, path string) (Lease, error) {
+	var resp struct {
+		LeaseID       string                 `json:"lease_id"`
+		LeaseDuration int                    `json:"lease_duration"`
+		Data          map[string]interface{} `json:"data"`
+	}
+	if err := v.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return Lease{}, err
+	}
+	return Lease{
+		ID:       resp.LeaseID,
+		Duration: time.Duration(resp.LeaseDuration) * time.Second,
+		Data:     resp.Data,
+	}, nil
+}
+
+// Revoke revokes the lease with the given ID, invalidating the credentials
+// associated to it.
+func (v *Vault) Revoke(ctx context.Context, leaseID string) error {
+	body, err := json.Marshal(map[string]string{"lease_id": leaseID})
+	if err != nil {
+		return err
+	}
+	return v.do(ctx, http.MethodPut, "sys/leases/revoke", body, nil)
+}
+
+func (v *Vault) get(ctx context.Context, path string, out interface{}) error {
+	return v.do(ctx, http.MethodGet, path, nil, out)
+}
+
+func (v *Vault) do(ctx context.Context, method, path string, body []byte, out interface{}) error {
+	u := *v.addr
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/v1/" + strings.TrimPrefix(path, "/")
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if v.token != "" {
+		req.Header.Set("X-Vault-Token", v.token)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("unexpected status code from vault: %d", resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+/*
+Copyright 2022 Adevinta
+*/
+
+package selfupdate
+
+import (
+	"os"
+	"syscall"
+)
+
+// execBinary replaces the current process with the given binary.
+func execBinary(path string) error {
+	return syscall.Exec(path, os.Args, os.Environ())
+}
@@ -0,0 +1,13 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package selfupdate
+
+import "errors"
+
+// execBinary is not supported on Windows, the new binary is used the next
+// time the agent is started.
+func execBinary(path string) error {
+	return errors.New("replacing the agent process not supported on windows")
+}
@@ -0,0 +1,332 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+// Package selfupdate updates the agent to the new versions published in a
+// release manifest, for the agents deployed on hosts without an orchestrator
+// that could replace them. The manifest is a JSON document, served over HTTP
+// or stored in S3, describing the latest release:
+//
+//	{
+//		"version": "1.4.0",
+//		"url": "https://releases.example.com/vulcan-agent-1.4.0-linux-amd64",
+//		"sha256": "<hex encoded SHA-256 of the binary>",
+//		"signature": "<base64 encoded ed25519 signature of the release>"
+//	}
+//
+// The signature covers the version, the OS and the architecture of the
+// release together with the SHA-256 of its binary, see SignedPayload, so a
+// signed binary can't be published as a different version or for a different
+// platform. The binaries of the releases newer than the running agent are
+// downloaded next to the running binary and only used if their signature is
+// valid for the configured public key.
+package selfupdate
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/awscreds"
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// MaxBinarySize is the max size, in bytes, of the binaries downloaded.
+const MaxBinarySize = 512 << 20
+
+// ErrInvalidSignature is returned when the binary of a release is not signed
+// with the configured key.
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// Release is the latest release described in the manifest.
+type Release struct {
+	Version   string `json:"version"`
+	URL       string `json:"url"`
+	SHA256    string `json:"sha256"`
+	Signature string `json:"signature"`
+}
+
+// SignedPayload returns the payload signed in the manifest for the release
+// with the given version, OS, architecture and SHA-256 of its binary:
+//
+//	vulcan-agent <version> <os>/<arch> <hex encoded SHA-256>
+func SignedPayload(version, goos, goarch string, digest []byte) []byte {
+	return []byte(fmt.Sprintf("vulcan-agent %s %s/%s %s", version, goos, goarch, hex.EncodeToString(digest)))
+}
+
+// Update is a new version of the agent downloaded and verified.
+type Update struct {
+	Version string
+	// Path is the file containing the binary of the new version.
+	Path string
+}
+
+// Updater checks periodically the manifest for new versions of the agent.
+type Updater struct {
+	manifest string
+	key      ed25519.PublicKey
+	version  string
+	exe      string
+	interval time.Duration
+	log      log.Logger
+	fetch    func(ctx context.Context, u string) (io.ReadCloser, error)
+}
+
+// New creates an Updater of the given version of the agent from the given
+// config. The placeholders {os} and {arch} in the URL of the manifest are
+// replaced by the OS and the architecture the agent runs on.
+func New(l log.Logger, cfg config.SelfUpdateConfig, version string) (*Updater, error) {
+	key, err := base64.StdEncoding.DecodeString(cfg.PublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("the public key must be a base64 encoded ed25519 key")
+	}
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting the path of the agent binary: %w", err)
+	}
+	u := &Updater{
+		manifest: strings.NewReplacer("{os}", runtime.GOOS, "{arch}", runtime.GOARCH).Replace(cfg.ManifestURL),
+		key:      ed25519.PublicKey(key),
+		version:  version,
+		exe:      exe,
+		interval: time.Duration(cfg.Interval) * time.Second,
+		log:      l,
+		fetch:    fetch,
+	}
+	if u.interval <= 0 {
+		u.interval = config.DefaultSelfUpdateInterval * time.Second
+	}
+	return u, nil
+}
+
+// Watch checks the manifest until a new version is downloaded or the context
+// is canceled. The update, if any, is written to the returned channel.
+func (u *Updater) Watch(ctx context.Context) <-chan Update {
+	updates := make(chan Update, 1)
+	go func() {
+		ticker := time.NewTicker(u.interval)
+		defer ticker.Stop()
+		for {
+			update, ok, err := u.Check(ctx)
+			if err != nil && ctx.Err() == nil {
+				u.log.Errorf("error checking agent updates: %+v", err)
+			}
+			if ok {
+				updates <- update
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return updates
+}
+
+// Check reads the manifest and, if it describes a version newer than the
+// running one, downloads and verifies its binary.
+func (u *Updater) Check(ctx context.Context) (Update, bool, error) {
+	r, err := u.fetch(ctx, u.manifest)
+	if err != nil {
+		return Update{}, false, fmt.Errorf("error reading manifest: %w", err)
+	}
+	defer r.Close()
+	var rel Release
+	if err := json.NewDecoder(r).Decode(&rel); err != nil {
+		return Update{}, false, fmt.Errorf("error decoding manifest: %w", err)
+	}
+	if !Newer(rel.Version, u.version) {
+		u.log.Debugf("no agent update, latest version %s, running version %s", rel.Version, u.version)
+		return Update{}, false, nil
+	}
+	u.log.Infof("downloading agent version %s from %s", rel.Version, rel.URL)
+	path, err := u.download(ctx, rel)
+	if err != nil {
+		return Update{}, false, fmt.Errorf("error downloading agent version %s: %w", rel.Version, err)
+	}
+	return Update{Version: rel.Version, Path: path}, true, nil
+}
+
+// download verifies the signature of the given release, for the OS and the
+// architecture the agent runs on, and writes its binary to a file in the
+// directory of the running binary, so it can be renamed to replace it. The
+// file is removed if its SHA-256 is not the signed one.
+func (u *Updater) download(ctx context.Context, rel Release) (path string, err error) {
+	digest, err := hex.DecodeString(rel.SHA256)
+	if err != nil || len(digest) != sha256.Size {
+		return "", errors.New("invalid sha256 in manifest")
+	}
+	sig, err := base64.StdEncoding.DecodeString(rel.Signature)
+	if err != nil {
+		return "", fmt.Errorf("invalid signature in manifest: %w", err)
+	}
+	if !ed25519.Verify(u.key, SignedPayload(rel.Version, runtime.GOOS, runtime.GOARCH, digest), sig) {
+		return "", ErrInvalidSignature
+	}
+	r, err := u.fetch(ctx, rel.URL)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	f, err := ioutil.TempFile(filepath.Dir(u.exe), filepath.Base(u.exe)+".update-")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		f.Close()
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), io.LimitReader(r, MaxBinarySize+1))
+	if err != nil {
+		return "", err
+	}
+	if n > MaxBinarySize {
+		return "", fmt.Errorf("binary bigger than %d bytes", MaxBinarySize)
+	}
+	if got := h.Sum(nil); !bytes.Equal(got, digest) {
+		return "", fmt.Errorf("sha256 of the binary %x doesn't match the manifest", got)
+	}
+	if err := f.Chmod(0755); err != nil {
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// Apply replaces the running binary with the one of the given update, keeping
+// the previous one with the .old suffix, and executes it with the same
+// arguments and environment. It only returns if the new binary can't be
+// executed.
+func Apply(update Update) error {
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		return fmt.Errorf("error getting the path of the agent binary: %w", err)
+	}
+	if err := os.Rename(exe, exe+".old"); err != nil {
+		return fmt.Errorf("error keeping the previous agent binary: %w", err)
+	}
+	if err := os.Rename(update.Path, exe); err != nil {
+		os.Rename(exe+".old", exe)
+		return fmt.Errorf("error replacing the agent binary: %w", err)
+	}
+	return execBinary(exe)
+}
+
+// Newer returns true if the version v is greater than the current one. The
+// versions are compared by their numeric components, e.g. v1.10.0 is newer
+// than 1.9.2, and the pre-release and build suffixes are ignored. The
+// versions that are not numeric, like dev, are never newer nor updated.
+func Newer(v, current string) bool {
+	a, ok := parseVersion(v)
+	if !ok {
+		return false
+	}
+	b, ok := parseVersion(current)
+	if !ok {
+		return false
+	}
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			return x > y
+		}
+	}
+	return false
+}
+
+func parseVersion(v string) ([]int, bool) {
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	if v == "" {
+		return nil, false
+	}
+	var nums []int
+	for _, p := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		nums = append(nums, n)
+	}
+	return nums, true
+}
+
+// fetch returns the contents of the given http, https or s3 URL. The objects
+// in S3 are read with the default credentials of the AWS clients.
+func fetch(ctx context.Context, u string) (io.ReadCloser, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	switch parsed.Scheme {
+	case "http", "https":
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("unexpected status %d getting %s", resp.StatusCode, u)
+		}
+		return resp.Body, nil
+	case "s3":
+		sess, err := awscreds.NewSession(config.AWSCredentialsConfig{})
+		if err != nil {
+			return nil, err
+		}
+		out, err := s3.New(sess).GetObjectWithContext(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(parsed.Host),
+			Key:    aws.String(strings.TrimPrefix(parsed.Path, "/")),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return out.Body, nil
+	default:
+		return nil, fmt.Errorf("unsupported URL %s", u)
+	}
+}
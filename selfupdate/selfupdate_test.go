@@ -0,0 +1,207 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package selfupdate
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/adevinta/vulcan-agent/log"
+)
+
+func TestNewer(t *testing.T) {
+	tests := []struct {
+		v, current string
+		want       bool
+	}{
+		{v: "1.4.0", current: "1.3.9", want: true},
+		{v: "v1.10.0", current: "1.9.2", want: true},
+		{v: "1.4", current: "1.3.9", want: true},
+		{v: "1.4.0", current: "1.4.0"},
+		{v: "1.4.0", current: "1.4"},
+		{v: "1.3.0", current: "1.4.0"},
+		{v: "1.5.0-rc1", current: "1.4.0", want: true},
+		{v: "1.5.0", current: "dev"},
+		{v: "latest", current: "1.4.0"},
+		{v: "", current: "1.4.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.v+"_"+tt.current, func(t *testing.T) {
+			if got := Newer(tt.v, tt.current); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdater_Check(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	binary := []byte("#!/bin/sh\necho new agent\n")
+	digest := sha256.Sum256(binary)
+	tests := []struct {
+		name       string
+		version    string
+		sha256     []byte
+		key        ed25519.PrivateKey
+		binary     []byte
+		signature  []byte
+		wantUpdate bool
+		wantErr    bool
+		wantErrIs  error
+	}{
+		{
+			name:       "Newer",
+			version:    "1.4.0",
+			sha256:     digest[:],
+			key:        priv,
+			binary:     binary,
+			wantUpdate: true,
+		},
+		{
+			name:    "SameVersion",
+			version: "1.3.0",
+			sha256:  digest[:],
+			key:     priv,
+			binary:  binary,
+		},
+		{
+			name:      "OtherKey",
+			version:   "1.4.0",
+			sha256:    digest[:],
+			key:       otherKey,
+			binary:    binary,
+			wantErr:   true,
+			wantErrIs: ErrInvalidSignature,
+		},
+		{
+			name:    "ModifiedBinary",
+			version: "1.4.0",
+			sha256:  digest[:],
+			key:     priv,
+			binary:  []byte("#!/bin/sh\necho modified agent\n"),
+			wantErr: true,
+		},
+		{
+			name:      "ReplayedVersion",
+			version:   "1.5.0",
+			sha256:    digest[:],
+			binary:    binary,
+			signature: ed25519.Sign(priv, SignedPayload("1.4.0", runtime.GOOS, runtime.GOARCH, digest[:])),
+			wantErr:   true,
+			wantErrIs: ErrInvalidSignature,
+		},
+		{
+			name:      "OtherPlatform",
+			version:   "1.4.0",
+			sha256:    digest[:],
+			binary:    binary,
+			signature: ed25519.Sign(priv, SignedPayload("1.4.0", "plan9", "mips", digest[:])),
+			wantErr:   true,
+			wantErrIs: ErrInvalidSignature,
+		},
+		{
+			name:      "DigestOnly",
+			version:   "1.4.0",
+			sha256:    digest[:],
+			binary:    binary,
+			signature: ed25519.Sign(priv, digest[:]),
+			wantErr:   true,
+			wantErrIs: ErrInvalidSignature,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			srv := httptest.NewServer(mux)
+			defer srv.Close()
+			sig := tt.signature
+			if sig == nil {
+				sig = ed25519.Sign(tt.key, SignedPayload(tt.version, runtime.GOOS, runtime.GOARCH, tt.sha256))
+			}
+			mux.HandleFunc("/manifest.json", func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(Release{
+					Version:   tt.version,
+					URL:       srv.URL + "/vulcan-agent",
+					SHA256:    hex.EncodeToString(tt.sha256),
+					Signature: base64.StdEncoding.EncodeToString(sig),
+				})
+			})
+			mux.HandleFunc("/vulcan-agent", func(w http.ResponseWriter, r *http.Request) {
+				w.Write(tt.binary)
+			})
+			dir := t.TempDir()
+			u := &Updater{
+				manifest: srv.URL + "/manifest.json",
+				key:      pub,
+				version:  "1.3.0",
+				exe:      filepath.Join(dir, "vulcan-agent"),
+				log:      &log.NullLog{},
+				fetch:    fetch,
+			}
+			update, ok, err := u.Check(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErrIs)
+			}
+			if ok != tt.wantUpdate {
+				t.Fatalf("got update %v, want update %v", ok, tt.wantUpdate)
+			}
+			files, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !ok {
+				if len(files) != 0 {
+					t.Errorf("unexpected files left: %v", files)
+				}
+				return
+			}
+			if update.Version != tt.version || filepath.Dir(update.Path) != dir {
+				t.Errorf("unexpected update %+v", update)
+			}
+			f, err := os.Open(update.Path)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer f.Close()
+			got, err := ioutil.ReadAll(f)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(got) != string(binary) {
+				t.Errorf("got binary %q, want %q", got, binary)
+			}
+			fi, err := f.Stat()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if fi.Mode().Perm()&0100 == 0 {
+				t.Errorf("binary not executable, mode %v", fi.Mode())
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package spool
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/results"
+	"github.com/adevinta/vulcan-agent/stateupdater"
+	report "github.com/adevinta/vulcan-report"
+)
+
+// Kinds of results stored in the spool.
+const (
+	kindReport = "report"
+	kindRaw    = "raw"
+)
+
+// result is a result stored in the spool.
+type result struct {
+	CheckID       string         `json:"check_id"`
+	ScanStartTime time.Time      `json:"scan_start_time"`
+	Kind          string         `json:"kind"`
+	Report        *report.Report `json:"report,omitempty"`
+	Raw           []byte         `json:"raw,omitempty"`
+}
+
+// Sink decorates a results.Sink storing the results in the spool directory
+// before sending them. The results are removed from the spool when they are
+// sent. If sending a result fails the error is returned, as the decorated
+// sink does, but the result stays in the spool. When the result is sent by a
+// Replay, the link to it is written as a state update of the check using the
+// given state writer.
+type Sink struct {
+	sink   results.Sink
+	states stateupdater.QueueWriter
+	dir    string
+	log    log.Logger
+	mu     sync.Mutex
+}
+
+// NewSink returns a Sink that stores the results in the "results"
+// subdirectory of the given dir.
+func NewSink(l log.Logger, dir string, sink results.Sink, states stateupdater.QueueWriter) (*Sink, error) {
+	dir = filepath.Join(dir, "results")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Sink{sink: sink, states: states, dir: dir, log: l}, nil
+}
+
+// UpdateCheckReport stores the report in the spool and sends it.
+func (s *Sink) UpdateCheckReport(checkID string, scanStartTime time.Time, r report.Report) (string, error) {
+	return s.send(result{CheckID: checkID, ScanStartTime: scanStartTime, Kind: kindReport, Report: &r})
+}
+
+// UpdateCheckRaw stores the logs in the spool and sends them.
+func (s *Sink) UpdateCheckRaw(checkID string, scanStartTime time.Time, raw []byte) (string, error) {
+	return s.send(result{CheckID: checkID, ScanStartTime: scanStartTime, Kind: kindRaw, Raw: raw})
+}
+
+// UpdateCheckArtifact sends an artifact of a check, if the spooled sink
+// supports them. The artifacts are not stored in the spool because they can
+// be big and, contrary to the logs, the state of the check doesn't link to
+// them.
+func (s *Sink) UpdateCheckArtifact(checkID string, scanStartTime time.Time, name string, data []byte) (string, error) {
+	as, ok := s.sink.(results.ArtifactSink)
+	if !ok {
+		return "", results.ErrArtifactsNotSupported
+	}
+	return as.UpdateCheckArtifact(checkID, scanStartTime, name, data)
+}
+
+func (s *Sink) send(r result) (string, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	// The results of a check are stored under the same name so a new attempt
+	// replaces the pending one.
+	path := filepath.Join(s.dir, fmt.Sprintf("%s.%s.json", r.CheckID, r.Kind))
+	s.mu.Lock()
+	err = writeFile(path, data)
+	s.mu.Unlock()
+	if err != nil {
+		return "", fmt.Errorf("error spooling %s: %w", r.Kind, err)
+	}
+	link, err := s.upload(r)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	return link, nil
+}
+
+func (s *Sink) upload(r result) (string, error) {
+	if r.Kind == kindReport {
+		return s.sink.UpdateCheckReport(r.CheckID, r.ScanStartTime, *r.Report)
+	}
+	return s.sink.UpdateCheckRaw(r.CheckID, r.ScanStartTime, r.Raw)
+}
+
+// Replay sends the results pending in the spool and writes the state updates
+// with the links to them. It stops at the first error.
+func (s *Sink) Replay() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pending, err := entries(s.dir)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		s.log.Infof("replaying %d spooled results", len(pending))
+	}
+	for _, name := range pending {
+		path := filepath.Join(s.dir, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var r result
+		if err := json.Unmarshal(data, &r); err != nil {
+			s.log.Errorf("discarding invalid spooled result %s: %+v", name, err)
+			os.Remove(path)
+			continue
+		}
+		link, err := s.upload(r)
+		if err != nil {
+			return err
+		}
+		state := stateupdater.CheckState{ID: r.CheckID}
+		if r.Kind == kindReport {
+			state.Report = &link
+		} else {
+			state.Raw = &link
+		}
+		body, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		if err := s.states.Write(string(body)); err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
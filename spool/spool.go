@@ -0,0 +1,89 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+// Package spool persists in a local directory the state updates and the
+// results of the checks before sending them, so the ones that can't be sent,
+// because the destination is down or the agent crashes, are sent again later,
+// even after restarting the agent.
+package spool
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/log"
+)
+
+// Replayer sends again the entries pending in a spool.
+type Replayer interface {
+	Replay() error
+}
+
+// Run replays the pending entries of the given replayers when it's called and
+// then every interval until the context is canceled.
+func Run(ctx context.Context, l log.Logger, interval time.Duration, replayers ...Replayer) {
+	replay := func() {
+		for _, r := range replayers {
+			if err := r.Replay(); err != nil {
+				l.Errorf("error replaying spooled entries: %+v", err)
+			}
+		}
+	}
+	replay()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			replay()
+		}
+	}
+}
+
+// writeFile writes the data to the file atomically, so a crash never leaves
+// a partially written entry.
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// entries returns the names of the entries in the directory sorted by name.
+func entries(dir string) ([]string, error) {
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, de := range des {
+		if de.IsDir() || strings.HasPrefix(de.Name(), ".") {
+			continue
+		}
+		names = append(names, de.Name())
+	}
+	sort.Strings(names)
+	return names, nil
+}
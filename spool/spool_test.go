@@ -0,0 +1,127 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package spool
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/stateupdater"
+	report "github.com/adevinta/vulcan-report"
+	"github.com/google/go-cmp/cmp"
+)
+
+var errDown = errors.New("service down")
+
+type flakyWriter struct {
+	down bool
+	msgs []string
+}
+
+func (w *flakyWriter) Write(body string) error {
+	if w.down {
+		return errDown
+	}
+	w.msgs = append(w.msgs, body)
+	return nil
+}
+
+type flakySink struct {
+	down bool
+	raws []string
+}
+
+func (s *flakySink) UpdateCheckReport(checkID string, scanStartTime time.Time, r report.Report) (string, error) {
+	if s.down {
+		return "", errDown
+	}
+	return "report/" + checkID, nil
+}
+
+func (s *flakySink) UpdateCheckRaw(checkID string, scanStartTime time.Time, raw []byte) (string, error) {
+	if s.down {
+		return "", errDown
+	}
+	s.raws = append(s.raws, string(raw))
+	return "raw/" + checkID, nil
+}
+
+func TestStateWriter(t *testing.T) {
+	dir := t.TempDir()
+	w := &flakyWriter{down: true}
+	sw, err := NewStateWriter(&log.NullLog{}, dir, w)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, m := range []string{"m1", "m2"} {
+		if err := sw.Write(m); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	w.down = false
+	// Pending messages must be sent before the new ones.
+	if err := sw.Write("m3"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(w.msgs) != 0 {
+		t.Fatalf("messages written while others were pending: %v", w.msgs)
+	}
+	// A new StateWriter, as after restarting the agent, replays the messages.
+	sw, err = NewStateWriter(&log.NullLog{}, dir, w)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := sw.Replay(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := sw.Write("m4"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"m1", "m2", "m3", "m4"}
+	if diff := cmp.Diff(want, w.msgs); diff != "" {
+		t.Errorf("want messages != got messages, diff: %s", diff)
+	}
+}
+
+func TestSinkReplay(t *testing.T) {
+	dir := t.TempDir()
+	w := &flakyWriter{}
+	sink := &flakySink{down: true}
+	s, err := NewSink(&log.NullLog{}, dir, sink, w)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	start := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	if _, err := s.UpdateCheckRaw("check1", start, []byte("logs")); !errors.Is(err, errDown) {
+		t.Fatalf("want error %v, got %v", errDown, err)
+	}
+	sink.down = false
+	if err := s.Replay(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Nothing must be pending after replaying.
+	if err := s.Replay(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var states []stateupdater.CheckState
+	for _, m := range w.msgs {
+		var st stateupdater.CheckState
+		if err := json.Unmarshal([]byte(m), &st); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		states = append(states, st)
+	}
+	link := "raw/check1"
+	want := []stateupdater.CheckState{{ID: "check1", Raw: &link}}
+	if diff := cmp.Diff(want, states); diff != "" {
+		t.Errorf("want states != got states, diff: %s", diff)
+	}
+	if diff := cmp.Diff([]string{"logs"}, sink.raws); diff != "" {
+		t.Errorf("want raws != got raws, diff: %s", diff)
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package spool
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/stateupdater"
+)
+
+// StateWriter decorates a stateupdater.QueueWriter storing each message in
+// the spool directory before writing it. The message is removed from the
+// spool when it's written. If the write fails the message stays in the spool
+// and Write returns no error, the message is written by the next Replay. The
+// order of the messages is preserved: while there are messages pending, the
+// new ones are only stored.
+type StateWriter struct {
+	w   stateupdater.QueueWriter
+	dir string
+	log log.Logger
+
+	mu  sync.Mutex
+	seq int64
+}
+
+// NewStateWriter returns a StateWriter that stores the messages in the
+// "states" subdirectory of the given dir.
+func NewStateWriter(l log.Logger, dir string, w stateupdater.QueueWriter) (*StateWriter, error) {
+	dir = filepath.Join(dir, "states")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &StateWriter{w: w, dir: dir, log: l}, nil
+}
+
+// Write stores the message in the spool and writes it.
+func (s *StateWriter) Write(body string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pending, err := entries(s.dir)
+	if err != nil {
+		return err
+	}
+	seq := time.Now().UnixNano()
+	if seq <= s.seq {
+		seq = s.seq + 1
+	}
+	s.seq = seq
+	path := filepath.Join(s.dir, fmt.Sprintf("%020d.json", seq))
+	if err := writeFile(path, []byte(body)); err != nil {
+		return fmt.Errorf("error spooling state: %w", err)
+	}
+	if len(pending) > 0 {
+		return nil
+	}
+	if err := s.w.Write(body); err != nil {
+		s.log.Errorf("error writing state, it will be replayed: %+v", err)
+		return nil
+	}
+	return os.Remove(path)
+}
+
+// Replay writes the messages pending in the spool, in the same order they
+// were stored. It stops at the first error.
+func (s *StateWriter) Replay() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pending, err := entries(s.dir)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		s.log.Infof("replaying %d spooled states", len(pending))
+	}
+	for _, name := range pending {
+		path := filepath.Join(s.dir, name)
+		body, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := s.w.Write(string(body)); err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
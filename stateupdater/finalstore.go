@@ -0,0 +1,179 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package stateupdater
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// finalStatus is a final status of a check stored in a finalStore.
+type finalStatus struct {
+	ID     string    `json:"id"`
+	Status string    `json:"status"`
+	At     time.Time `json:"at"`
+}
+
+// compactMinEntries is the minimum number of entries a finalStore file
+// must have to be compacted.
+var compactMinEntries = 1000
+
+// finalStore stores the final statuses of the checks in a file, one JSON
+// object per line, so they are known after the agent restarts. The file is
+// compacted, removing the statuses older than the Retention time, when its
+// number of entries doubles since it was last compacted, and it has at least
+// compactMinEntries.
+type finalStore struct {
+	mu      sync.Mutex
+	path    string
+	f       *os.File
+	entries int
+	limit   int
+}
+
+// openFinalStore opens the file in the given path, creating it if it doesn't
+// exist, and returns the statuses stored in it that are not older than the
+// Retention time. The file is rewritten with only those statuses.
+func openFinalStore(path string) (*finalStore, []finalStatus, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, nil, err
+	}
+	s := &finalStore{path: path}
+	statuses, err := s.compact()
+	if err != nil {
+		return nil, nil, err
+	}
+	return s, statuses, nil
+}
+
+// compact rewrites the file of the store with only the statuses not older
+// than the Retention time, returns them and reopens the file to append the
+// new ones. If it fails, the store keeps appending the statuses to the
+// current file. The store must be locked or not used yet.
+func (s *finalStore) compact() ([]finalStatus, error) {
+	statuses, err := readFinalStatuses(s.path)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFinalStatuses(s.path, statuses); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if s.f != nil {
+		// The previous file was already replaced, so the error closing it
+		// doesn't matter.
+		s.f.Close()
+	}
+	s.f = f
+	s.entries = len(statuses)
+	s.limit = 2 * len(statuses)
+	if s.limit < compactMinEntries {
+		s.limit = compactMinEntries
+	}
+	return statuses, nil
+}
+
+// writeFinalStatuses atomically replaces the file in the given path with one
+// containing the given statuses.
+func writeFinalStatuses(path string, statuses []finalStatus) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, s := range statuses {
+		if err := enc.Encode(s); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func readFinalStatuses(path string) ([]finalStatus, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var statuses []finalStatus
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var s finalStatus
+		// The lines that can't be decoded, e.g. the last one if the agent
+		// stopped while writing it, are ignored.
+		if err := json.Unmarshal(sc.Bytes(), &s); err != nil {
+			continue
+		}
+		if time.Since(s.At) > Retention {
+			continue
+		}
+		statuses = append(statuses, s)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("error reading final statuses: %w", err)
+	}
+	return statuses, nil
+}
+
+func (s *finalStore) save(st finalStatus) error {
+	line, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return errors.New("final statuses store closed")
+	}
+	if _, err := s.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	s.entries++
+	// The status is synced so it's not lost if the host crashes.
+	if err := s.f.Sync(); err != nil {
+		return err
+	}
+	if s.entries < s.limit {
+		return nil
+	}
+	if _, err := s.compact(); err != nil {
+		// Don't try to compact the file again until it doubles its size.
+		s.limit *= 2
+		return fmt.Errorf("error compacting final statuses: %w", err)
+	}
+	return nil
+}
+
+func (s *finalStore) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
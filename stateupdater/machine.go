@@ -0,0 +1,143 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package stateupdater
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrInvalidTransition is returned when the status of a check is updated to
+// a status that can't follow its current one.
+var ErrInvalidTransition = errors.New("invalid check status transition")
+
+// ErrDuplicateStatus is returned when the status of a check is updated to
+// the final status it already has.
+var ErrDuplicateStatus = errors.New("duplicate check final status")
+
+// Retention is the time the Machine remembers the status of a check since
+// its last update. Forgetting the status of a check never makes a valid
+// transition invalid, because the unknown checks are considered CREATED.
+var Retention = time.Hour
+
+// transitions contains the non final statuses each non final status can be
+// followed by. Any non final status can be followed by a final one, because
+// a check can fail or be aborted before it starts running.
+var transitions = map[string][]string{
+	StatusCreated:  {StatusQueued, StatusAssigned, StatusRunning},
+	StatusQueued:   {StatusAssigned, StatusRunning},
+	StatusAssigned: {StatusRunning},
+	StatusRunning:  {StatusRunning, StatusPurging},
+	StatusPurging:  {},
+}
+
+// IsFinal returns true if the given status is final, that is, a check in
+// that status can't change to any other status. ABORTED is final even if
+// it's not one of the TerminalStatuses, that contains the statuses that
+// mean the check finished by itself.
+func IsFinal(status string) bool {
+	_, ok := TerminalStatuses[status]
+	return ok || status == StatusAborted
+}
+
+// ValidTransition returns true if a check can change from the status "from"
+// to the status "to".
+func ValidTransition(from, to string) bool {
+	next, ok := transitions[from]
+	if !ok {
+		return false
+	}
+	if IsFinal(to) {
+		return true
+	}
+	for _, s := range next {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Machine tracks the current status of the checks and validates their
+// transitions. The checks not known by the Machine are considered CREATED.
+type Machine struct {
+	mu        sync.Mutex
+	states    map[string]checkStatus
+	lastPrune time.Time
+}
+
+type checkStatus struct {
+	status string
+	at     time.Time
+}
+
+// NewMachine returns an empty Machine.
+func NewMachine() *Machine {
+	return &Machine{states: make(map[string]checkStatus)}
+}
+
+// State returns the current status of the check with the given ID, if known.
+func (m *Machine) State(ID string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.states[ID]
+	return s.status, ok
+}
+
+// Validate returns an error wrapping ErrInvalidTransition if the check with
+// the given ID can't change to the given status, or wrapping
+// ErrDuplicateStatus if the check already has that final status.
+func (m *Machine) Validate(ID, status string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current := StatusCreated
+	if s, ok := m.states[ID]; ok {
+		current = s.status
+	}
+	if current == status && IsFinal(status) {
+		return fmt.Errorf("%w: check %s already %s", ErrDuplicateStatus, ID, status)
+	}
+	if !ValidTransition(current, status) {
+		return fmt.Errorf("%w: check %s from %s to %s", ErrInvalidTransition, ID, current, status)
+	}
+	return nil
+}
+
+// Set sets the current status of the check with the given ID, without
+// validating the transition.
+func (m *Machine) Set(ID, status string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.states[ID] = checkStatus{status: status, at: now}
+	m.prune(now)
+}
+
+// restore sets the status of the check with the given ID as it was at the
+// given time, unless it's older than the Retention time.
+func (m *Machine) restore(ID, status string, at time.Time) {
+	if time.Since(at) > Retention {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.states[ID] = checkStatus{status: status, at: at}
+}
+
+// prune removes, at most once per minute, the checks not updated in the
+// Retention time.
+func (m *Machine) prune(now time.Time) {
+	if now.Sub(m.lastPrune) < time.Minute {
+		return
+	}
+	m.lastPrune = now
+	for id, s := range m.states {
+		if now.Sub(s.at) > Retention {
+			delete(m.states, id)
+		}
+	}
+}
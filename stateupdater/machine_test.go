@@ -0,0 +1,221 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package stateupdater
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestValidTransition(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+		want bool
+	}{
+		{from: StatusCreated, to: StatusAssigned, want: true},
+		{from: StatusCreated, to: StatusRunning, want: true},
+		{from: StatusCreated, to: StatusMalformed, want: true},
+		{from: StatusAssigned, to: StatusRunning, want: true},
+		{from: StatusAssigned, to: StatusCreated, want: false},
+		{from: StatusRunning, to: StatusRunning, want: true},
+		{from: StatusRunning, to: StatusFinished, want: true},
+		{from: StatusRunning, to: StatusAborted, want: true},
+		{from: StatusRunning, to: StatusAssigned, want: false},
+		{from: StatusRunning, to: "UNKNOWN", want: false},
+		{from: StatusFinished, to: StatusFailed, want: false},
+		{from: StatusFinished, to: StatusRunning, want: false},
+		{from: StatusAborted, to: StatusFinished, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from+"->"+tt.to, func(t *testing.T) {
+			if got := ValidTransition(tt.from, tt.to); got != tt.want {
+				t.Errorf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+type queueWriterMock struct {
+	mu      sync.Mutex
+	written []string
+	delay   time.Duration
+}
+
+func (q *queueWriterMock) Write(body string) error {
+	time.Sleep(q.delay)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.written = append(q.written, body)
+	return nil
+}
+
+func TestUpdater_UpdateState(t *testing.T) {
+	qw := &queueWriterMock{}
+	u := New(qw)
+	running := StatusRunning
+	finished := StatusFinished
+	failed := StatusFailed
+	raw := "raw"
+	updates := []struct {
+		state   CheckState
+		wantErr error
+	}{
+		{state: CheckState{ID: "check1", Status: &running}},
+		{state: CheckState{ID: "check1", Status: &running}},
+		{state: CheckState{ID: "check1", Status: &finished}},
+		{state: CheckState{ID: "check1", Raw: &raw}},
+		{state: CheckState{ID: "check1", Status: &finished}, wantErr: ErrDuplicateStatus},
+		{state: CheckState{ID: "check1", Status: &failed}, wantErr: ErrInvalidTransition},
+		{state: CheckState{ID: "check1", Status: &running}, wantErr: ErrInvalidTransition},
+	}
+	for i, up := range updates {
+		err := u.UpdateState(up.state)
+		if !errors.Is(err, up.wantErr) || (up.wantErr == nil && err != nil) {
+			t.Fatalf("update %d: want error %v, got %v", i, up.wantErr, err)
+		}
+	}
+	if len(qw.written) != 4 {
+		t.Errorf("want 4 updates written, got %d", len(qw.written))
+	}
+	if got, _ := u.State("check1"); got != StatusFinished {
+		t.Errorf("want status %s, got %s", StatusFinished, got)
+	}
+	if _, ok := u.State("check2"); ok {
+		t.Errorf("want check2 unknown")
+	}
+	if !u.Completed("check1") {
+		t.Errorf("want check1 completed")
+	}
+}
+
+func TestNewPersistent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "final.json")
+	u, err := NewPersistent(&queueWriterMock{}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	running := StatusRunning
+	finished := StatusFinished
+	for _, s := range []CheckState{
+		{ID: "check1", Status: &running},
+		{ID: "check1", Status: &finished},
+		{ID: "check2", Status: &running},
+	} {
+		if err := u.UpdateState(s); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	// Simulate the restart of the agent.
+	qw := &queueWriterMock{}
+	u, err = NewPersistent(qw, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !u.Completed("check1") {
+		t.Errorf("want check1 completed after restart")
+	}
+	if u.Completed("check2") {
+		t.Errorf("want check2 not completed after restart")
+	}
+	if err := u.UpdateState(CheckState{ID: "check1", Status: &finished}); !errors.Is(err, ErrDuplicateStatus) {
+		t.Errorf("want ErrDuplicateStatus, got %v", err)
+	}
+	if len(qw.written) != 0 {
+		t.Errorf("want no updates written, got %d", len(qw.written))
+	}
+}
+
+func TestUpdater_UpdateStateConcurrent(t *testing.T) {
+	qw := &queueWriterMock{delay: 50 * time.Millisecond}
+	u := New(qw)
+	statuses := []string{StatusFinished, StatusTimeout, StatusAborted, StatusFailed}
+	errs := make(chan error, len(statuses))
+	var wg sync.WaitGroup
+	for _, status := range statuses {
+		status := status
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- u.UpdateState(CheckState{ID: "check1", Status: &status})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	var failed int
+	for err := range errs {
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, ErrInvalidTransition) {
+			t.Errorf("want ErrInvalidTransition, got %v", err)
+		}
+		failed++
+	}
+	if len(qw.written) != 1 || failed != len(statuses)-1 {
+		t.Errorf("want 1 final status written, got %d written and %d rejected", len(qw.written), failed)
+	}
+	if len(u.locks.locks) != 0 {
+		t.Errorf("want the locks of the checks removed, got %d", len(u.locks.locks))
+	}
+}
+
+func TestFinalStore_compact(t *testing.T) {
+	defer func(n int) { compactMinEntries = n }(compactMinEntries)
+	compactMinEntries = 4
+	path := filepath.Join(t.TempDir(), "final.json")
+	s, _, err := openFinalStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := time.Now().Add(-2 * Retention)
+	for _, st := range []finalStatus{
+		{ID: "check1", Status: StatusFinished, At: expired},
+		{ID: "check2", Status: StatusFinished, At: expired},
+		{ID: "check3", Status: StatusFinished, At: expired},
+		{ID: "check4", Status: StatusFinished, At: time.Now()},
+		{ID: "check5", Status: StatusFinished, At: time.Now()},
+	} {
+		if err := s.save(st); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	// The file is compacted after storing the fourth status.
+	if got := countLines(t, path); got != 2 {
+		t.Errorf("want 2 statuses stored, got %d", got)
+	}
+	if err := s.close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.save(finalStatus{ID: "check6", Status: StatusFinished, At: time.Now()}); err == nil {
+		t.Errorf("want error saving to a closed store")
+	}
+	_, statuses, err := openFinalStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 || statuses[0].ID != "check4" || statuses[1].ID != "check5" {
+		t.Errorf("want check4 and check5 stored, got %+v", statuses)
+	}
+}
+
+func countLines(t *testing.T, path string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	n := 0
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		n++
+	}
+	return n
+}
@@ -6,7 +6,9 @@ package stateupdater
 
 import (
 	"encoding/json"
+	"fmt"
 	"sync"
+	"time"
 )
 
 const (
@@ -22,6 +24,7 @@ const (
 	StatusFinished     = "FINISHED"
 	StatusMalformed    = "MALFORMED"
 	StatusInconclusive = "INCONCLUSIVE"
+	StatusMissingVars  = "MISSING_VARS"
 )
 
 // TerminalStatuses contains all the possible statuses of a check that are
@@ -32,6 +35,7 @@ var TerminalStatuses = map[string]struct{}{
 	StatusInconclusive: {},
 	StatusKilled:       {},
 	StatusMalformed:    {},
+	StatusMissingVars:  {},
 	StatusTimeout:      {},
 }
 
@@ -44,6 +48,9 @@ type CheckState struct {
 	Report   *string  `json:"report,omitempty"`
 	Raw      *string  `json:"raw,omitempty"`
 	Progress *float32 `json:"progress,omitempty"`
+	// Elapsed is the number of seconds the check was running. It's only
+	// reported when the check times out.
+	Elapsed *int64 `json:"elapsed,omitempty"`
 }
 
 // QueueWriter defines the queue services used by and
@@ -52,31 +59,125 @@ type QueueWriter interface {
 	Write(body string) error
 }
 
+// GroupWriter is implemented by the QueueWriters that can write the messages
+// in groups, e.g. to SQS FIFO queues, so the messages of the same group are
+// delivered in the order they were written.
+type GroupWriter interface {
+	WriteGroup(group, body string) error
+}
+
 // Updater takes a CheckState an send its to a queue using the defined queue
 // writer.
 type Updater struct {
 	qw             QueueWriter
 	terminalChecks sync.Map
+	machine        *Machine
+	store          *finalStore
+	locks          checkLocks
+}
+
+// checkLocks serializes the updates of each check, so the validation of a
+// status, the write of the update and the change of the current status of
+// the check are atomic.
+type checkLocks struct {
+	mu    sync.Mutex
+	locks map[string]*checkLock
+}
+
+type checkLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock locks the updates of the check with the given ID and returns the
+// function that unlocks them. The lock of a check is removed when no update
+// of the check holds or waits for it.
+func (c *checkLocks) lock(ID string) func() {
+	c.mu.Lock()
+	if c.locks == nil {
+		c.locks = make(map[string]*checkLock)
+	}
+	l, ok := c.locks[ID]
+	if !ok {
+		l = &checkLock{}
+		c.locks[ID] = l
+	}
+	l.refs++
+	c.mu.Unlock()
+	l.Lock()
+	return func() {
+		l.Unlock()
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		l.refs--
+		if l.refs == 0 {
+			delete(c.locks, ID)
+		}
+	}
 }
 
 // New creates a new updater using the provided queue writer.
 func New(qw QueueWriter) *Updater {
-	return &Updater{qw, sync.Map{}}
+	return &Updater{qw: qw, machine: NewMachine()}
 }
 
-// UpdateState updates the state of tha check into the underlaying queue.
+// NewPersistent creates a new updater that, apart from writing the updates
+// using the provided queue writer, stores the final statuses of the checks
+// in the file in the given path, so they are not sent again, and the checks
+// are known to be completed, after the agent restarts.
+func NewPersistent(qw QueueWriter, path string) (*Updater, error) {
+	store, statuses, err := openFinalStore(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening final statuses store: %w", err)
+	}
+	u := New(qw)
+	u.store = store
+	for _, st := range statuses {
+		u.machine.restore(st.ID, st.Status, st.At)
+	}
+	return u, nil
+}
+
+// UpdateState updates the state of tha check into the underlaying queue. It
+// returns an error wrapping ErrInvalidTransition, without updating it, if
+// the status of the check can't follow its current one, or wrapping
+// ErrDuplicateStatus if the check already has that final status, so the
+// final status of a check is sent only once. The updates without status are
+// always valid. The updates of the same check are serialized, so, when
+// several final statuses of a check are updated concurrently, e.g. the one
+// reported by the check and the TIMEOUT of the agent, only one is sent.
 func (u *Updater) UpdateState(s CheckState) error {
+	unlock := u.locks.lock(s.ID)
+	defer unlock()
+	if s.Status != nil {
+		if err := u.machine.Validate(s.ID, *s.Status); err != nil {
+			return err
+		}
+	}
 	body, err := json.Marshal(s)
 	if err != nil {
 		return err
 	}
-	err = u.qw.Write(string(body))
+	// The updates of the same check are written to the same group, if
+	// supported, so they are delivered in order.
+	if gw, ok := u.qw.(GroupWriter); ok {
+		err = gw.WriteGroup(s.ID, string(body))
+	} else {
+		err = u.qw.Write(string(body))
+	}
 	if err != nil {
 		return err
 	}
 	status := ""
 	if s.Status != nil {
 		status = *s.Status
+		u.machine.Set(s.ID, status)
+	}
+	if u.store != nil && IsFinal(status) {
+		err := u.store.save(finalStatus{ID: s.ID, Status: status, At: time.Now()})
+		if err != nil {
+			return fmt.Errorf("check %s updated, error storing its final status: %w", s.ID, err)
+		}
 	}
 	if _, ok := TerminalStatuses[status]; ok {
 		u.terminalChecks.Store(s.ID, struct{}{})
@@ -84,6 +185,26 @@ func (u *Updater) UpdateState(s CheckState) error {
 	return nil
 }
 
+// Close closes the file storing the final statuses of the checks, if any.
+func (u *Updater) Close() error {
+	if u.store == nil {
+		return nil
+	}
+	return u.store.close()
+}
+
+// State returns the current status of the check with the given ID, if it's
+// known.
+func (u *Updater) State(ID string) (string, bool) {
+	return u.machine.State(ID)
+}
+
+// Completed returns true if the check with the given ID has a final status.
+func (u *Updater) Completed(ID string) bool {
+	status, ok := u.machine.State(ID)
+	return ok && IsFinal(status)
+}
+
 // CheckStatusTerminal returns true if a check with the given ID has
 // sent so far a state update including a status in a terminal state.
 func (u *Updater) CheckStatusTerminal(ID string) bool {
@@ -27,6 +27,7 @@ type readMessageResult struct {
 // connecting to the stream.
 type Retryer interface {
 	WithRetries(op string, exec func() error) error
+	WithRetriesCtx(ctx context.Context, op string, exec func() error) error
 }
 
 // Message describes a stream message.
@@ -43,6 +44,12 @@ type MsgProcessor interface {
 	AbortCheck(ID string)
 }
 
+// ConnListener is implemented by the message processors that must know if
+// the stream is connected, that is, if they are receiving the messages.
+type ConnListener interface {
+	StreamConnected(connected bool)
+}
+
 // Stream reads messages from the stream server and process them using a given
 // message processor.
 type Stream struct {
@@ -70,6 +77,7 @@ func (s *Stream) ListenAndProcess(ctx context.Context) (<-chan error, error) {
 	if err != nil {
 		return nil, err
 	}
+	s.connected(true)
 	done := make(chan error, 1)
 	go s.listenAndProcess(ctx, conn, done)
 	return done, nil
@@ -86,13 +94,18 @@ LOOP:
 		case readRes := <-msgRead:
 			err = readRes.Error
 			if err != nil {
+				// The connection is re-established after every read
+				// timeout, so only the other errors mean that the messages
+				// may not be received for a while.
 				if !errIsTimeout(err) {
 					s.l.Errorf("error reading message from the stream: %s", err)
+					s.connected(false)
 				}
 				conn, err = s.reconnect(ctx)
 				if err != nil {
 					break LOOP
 				}
+				s.connected(true)
 				msgRead = s.readMessage(conn)
 				continue
 			}
@@ -103,8 +116,10 @@ LOOP:
 			break LOOP
 		}
 	}
-	// Ensure the read goroutine existed.
-	<-s.readMessage(conn)
+	s.connected(false)
+	// Ensure the pending read goroutine exited, the channel is closed after
+	// the result of the read is written.
+	<-msgRead
 	done <- err
 }
 
@@ -141,6 +156,14 @@ func (s *Stream) readMessage(conn *websocket.Conn) <-chan readMessageResult {
 	return read
 }
 
+// connected notifies the processor, if it's a ConnListener, about the state
+// of the connection.
+func (s *Stream) connected(c bool) {
+	if cl, ok := s.p.(ConnListener); ok {
+		cl.StreamConnected(c)
+	}
+}
+
 func (s *Stream) processMessage(msg Message) {
 	switch msg.Action {
 	case "ping":
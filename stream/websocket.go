@@ -32,7 +32,7 @@ func (ws *WSDialerWithRetries) Dial(ctx context.Context, urlStr string, requestH
 		conn *websocket.Conn
 		resp *http.Response
 	)
-	err := ws.retryer.WithRetries("WSDialer.Dial", func() error {
+	err := ws.retryer.WithRetriesCtx(ctx, "WSDialer.Dial", func() error {
 		var err error
 		conn, resp, err = websocket.DefaultDialer.DialContext(ctx, urlStr, requestHeader)
 		if err != nil {
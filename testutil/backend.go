@@ -0,0 +1,116 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package testutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/api"
+	"github.com/adevinta/vulcan-agent/backend"
+	report "github.com/adevinta/vulcan-report"
+)
+
+// CheckFunc runs a check in the fake Backend and returns its report. The
+// status of the report, FINISHED if empty, is the final status of the check.
+// The check fails with a non zero exit code if an error is returned.
+type CheckFunc func(ctx context.Context, params backend.RunParams) (report.Report, error)
+
+// Backend is a backend that runs the checks in-process, calling a
+// CheckFunc, instead of running them in containers. As the real checks, it
+// sends the report of each check to the API of the agent.
+type Backend struct {
+	agentAddr string
+	check     CheckFunc
+	client    http.Client
+}
+
+// NewBackend returns a Backend that runs the checks with the given function
+// and sends their reports to the API of the agent in the given address, e.g.
+// the one returned by Env.AgentAddr. A nil function finishes all the checks
+// without vulnerabilities.
+func NewBackend(agentAddr string, check CheckFunc) *Backend {
+	if check == nil {
+		check = func(ctx context.Context, params backend.RunParams) (report.Report, error) {
+			return report.Report{}, nil
+		}
+	}
+	return &Backend{
+		agentAddr: agentAddr,
+		check:     check,
+		client:    http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Run runs the check with the CheckFunc of the backend.
+func (b *Backend) Run(ctx context.Context, params backend.RunParams) (<-chan backend.RunResult, error) {
+	res := make(chan backend.RunResult, 1)
+	go func() {
+		res <- b.run(ctx, params)
+	}()
+	return res, nil
+}
+
+func (b *Backend) run(ctx context.Context, params backend.RunParams) backend.RunResult {
+	start := time.Now()
+	rep, err := b.check(ctx, params)
+	if ctx.Err() != nil {
+		return backend.RunResult{Error: ctx.Err()}
+	}
+	if err != nil {
+		output := fmt.Sprintf("check %s failed: %v\n", params.CheckID, err)
+		return backend.RunResult{
+			Output: []byte(output),
+			Error:  fmt.Errorf("%w exit: 1", backend.ErrNonZeroExitCode),
+		}
+	}
+	rep.CheckID = params.CheckID
+	rep.ChecktypeName = params.CheckTypeName
+	rep.ChecktypeVersion = params.ChecktypeVersion
+	rep.Target = params.Target
+	rep.Options = params.Options
+	if rep.Status == "" {
+		rep.Status = "FINISHED"
+	}
+	if rep.StartTime.IsZero() {
+		rep.StartTime = start
+	}
+	if rep.EndTime.IsZero() {
+		rep.EndTime = time.Now()
+	}
+	if err := b.sendReport(ctx, rep); err != nil {
+		return backend.RunResult{Error: fmt.Errorf("error sending the report of the check %s: %w", params.CheckID, err)}
+	}
+	output := fmt.Sprintf("check %s finished with status %s\n", params.CheckID, rep.Status)
+	return backend.RunResult{Output: []byte(output)}
+}
+
+// sendReport sends the given report, and its status, to the API of the agent.
+func (b *Backend) sendReport(ctx context.Context, rep report.Report) error {
+	body, err := json.Marshal(api.CheckState{Status: &rep.Status, Report: &rep})
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s/check/%s", b.agentAddr, rep.CheckID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package testutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/results"
+	report "github.com/adevinta/vulcan-report"
+)
+
+// Results is an in-process fake of the vulcan-results service that stores
+// the reports, logs and artifacts uploaded by the agent in memory. The links
+// it returns can be used to download the stored contents.
+type Results struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	reports   map[string]report.Report
+	raws      map[string][]byte
+	artifacts map[string][]byte
+	uploads   int
+	status    int
+	delay     time.Duration
+}
+
+// NewResults starts a fake results service without results.
+func NewResults() *Results {
+	r := &Results{
+		reports:   make(map[string]report.Report),
+		raws:      make(map[string][]byte),
+		artifacts: make(map[string][]byte),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/report", r.handleReport)
+	mux.HandleFunc("/raw", r.handleRaw)
+	mux.HandleFunc("/artifact", r.handleArtifact)
+	mux.HandleFunc("/", r.handleGet)
+	r.Server = httptest.NewServer(mux)
+	return r
+}
+
+// Report returns the last report uploaded for the given check.
+func (r *Results) Report(checkID string) (report.Report, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rep, ok := r.reports[checkID]
+	return rep, ok
+}
+
+// Raw returns the last logs uploaded for the given check.
+func (r *Results) Raw(checkID string) ([]byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	raw, ok := r.raws[checkID]
+	return raw, ok
+}
+
+// Artifact returns the artifact with the given name uploaded for the given
+// check.
+func (r *Results) Artifact(checkID, name string) ([]byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, ok := r.artifacts[checkID+"/"+name]
+	return data, ok
+}
+
+// Uploads returns the number of uploads stored.
+func (r *Results) Uploads() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.uploads
+}
+
+// SetStatus makes the service reply to the uploads with the given status
+// code without storing them, e.g. 503 to simulate that it's unavailable. A
+// status of 0 restores the normal behavior.
+func (r *Results) SetStatus(code int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = code
+}
+
+// SetDelay makes the service wait the given time before replying to each
+// upload, to simulate that it's slow.
+func (r *Results) SetDelay(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.delay = d
+}
+
+func (r *Results) handleReport(w http.ResponseWriter, req *http.Request) {
+	var data results.ReportData
+	r.upload(w, req, &data, func() string {
+		var rep report.Report
+		if err := json.Unmarshal([]byte(data.Report), &rep); err != nil {
+			return ""
+		}
+		r.reports[data.CheckID] = rep
+		return "/reports/" + data.CheckID
+	})
+}
+
+func (r *Results) handleRaw(w http.ResponseWriter, req *http.Request) {
+	var data results.RawData
+	r.upload(w, req, &data, func() string {
+		r.raws[data.CheckID] = data.Raw
+		return "/raws/" + data.CheckID
+	})
+}
+
+func (r *Results) handleArtifact(w http.ResponseWriter, req *http.Request) {
+	var data results.ArtifactData
+	r.upload(w, req, &data, func() string {
+		if data.Name == "" {
+			return ""
+		}
+		r.artifacts[data.CheckID+"/"+data.Name] = data.Data
+		return "/artifacts/" + data.CheckID + "/" + data.Name
+	})
+}
+
+// upload decodes the payload of an upload request in the given value and
+// calls store, holding the lock of the service, to store it. store returns
+// the path where the upload can be downloaded from, or an empty string if
+// the payload is not valid.
+func (r *Results) upload(w http.ResponseWriter, req *http.Request, v interface{}, store func() string) {
+	if req.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	r.mu.Lock()
+	delay, status := r.delay, r.status
+	r.mu.Unlock()
+	select {
+	case <-time.After(delay):
+	case <-req.Context().Done():
+		return
+	}
+	if status != 0 {
+		w.WriteHeader(status)
+		return
+	}
+	if err := json.NewDecoder(req.Body).Decode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.mu.Lock()
+	path := store()
+	if path != "" {
+		r.uploads++
+	}
+	r.mu.Unlock()
+	if path == "" {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Location", r.URL+path)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// handleGet replies to the pings of the agent and serves the contents stored.
+func (r *Results) handleGet(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodHead && req.URL.Path == "/" {
+		return
+	}
+	if req.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(req.URL.Path, "/"), "/", 2)
+	if len(parts) != 2 {
+		http.NotFound(w, req)
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch parts[0] {
+	case "reports":
+		rep, ok := r.reports[parts[1]]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rep)
+	case "raws":
+		raw, ok := r.raws[parts[1]]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write(raw)
+	case "artifacts":
+		data, ok := r.artifacts[parts[1]]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write(data)
+	default:
+		http.NotFound(w, req)
+	}
+}
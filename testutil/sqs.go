@@ -0,0 +1,514 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package testutil
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// Region and AccountID are the region and the account of the queues of
+	// the fake SQS.
+	Region    = "eu-west-1"
+	AccountID = "000000000000"
+
+	// DefaultVisibilityTimeout is the time, in seconds, the messages received
+	// without an explicit visibility timeout are hidden.
+	DefaultVisibilityTimeout = 30
+
+	// maxWaitTime is the max time, in seconds, a receive waits for messages.
+	maxWaitTime = 20
+	// receivePollInterval is how often a waiting receive checks again for
+	// messages whose visibility timeout has expired.
+	receivePollInterval = 50 * time.Millisecond
+)
+
+// SQS is an in-process fake of the SQS API that can be used as the endpoint
+// of the SQS readers and writers of the agent. It implements the actions used
+// by the agent, including long polling, visibility timeouts, receive counts
+// and the ordering of the messages of the same group in FIFO queues. The
+// requests are not authenticated, but the clients still need credentials,
+// any of them, to sign them.
+type SQS struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	queues map[string]*sqsQueue
+	// sent is closed, and replaced, every time a message is sent so the
+	// waiting receives are woken up.
+	sent chan struct{}
+}
+
+type sqsQueue struct {
+	fifo bool
+	msgs []*sqsMessage
+}
+
+type sqsMessage struct {
+	id        string
+	body      string
+	group     string
+	attrs     map[string]sqsMessageAttr
+	sent      time.Time
+	receipt   string
+	receives  int
+	visibleAt time.Time
+}
+
+type sqsMessageAttr struct {
+	dataType string
+	value    string
+}
+
+// NewSQS starts a fake SQS without queues.
+func NewSQS() *SQS {
+	s := &SQS{
+		queues: make(map[string]*sqsQueue),
+		sent:   make(chan struct{}),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// CreateQueue creates, if it doesn't exist, the queue with the given name and
+// returns its ARN. The queues whose name ends with .fifo are FIFO queues.
+func (s *SQS) CreateQueue(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.queues[name]; !ok {
+		s.queues[name] = &sqsQueue{fifo: strings.HasSuffix(name, ".fifo")}
+	}
+	return s.ARN(name)
+}
+
+// ARN returns the ARN of the queue with the given name.
+func (s *SQS) ARN(name string) string {
+	return fmt.Sprintf("arn:aws:sqs:%s:%s:%s", Region, AccountID, name)
+}
+
+// QueueURL returns the URL of the queue with the given name.
+func (s *SQS) QueueURL(name string) string {
+	return fmt.Sprintf("%s/%s/%s", s.URL, AccountID, name)
+}
+
+// Send writes a message with the given body to the queue with the given name.
+func (s *SQS) Send(name, body string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, ok := s.queues[name]
+	if !ok {
+		return fmt.Errorf("queue %s does not exist", name)
+	}
+	s.push(q, body, "", nil)
+	return nil
+}
+
+// Messages returns the bodies of the messages in the queue with the given
+// name, including the ones being processed, in the order they were sent.
+func (s *SQS) Messages(name string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, ok := s.queues[name]
+	if !ok {
+		return nil
+	}
+	var bodies []string
+	for _, m := range q.msgs {
+		bodies = append(bodies, m.body)
+	}
+	return bodies
+}
+
+// InFlight returns the number of messages of the queue with the given name
+// that have been received and are still hidden.
+func (s *SQS) InFlight(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, ok := s.queues[name]
+	if !ok {
+		return 0
+	}
+	now := time.Now()
+	var n int
+	for _, m := range q.msgs {
+		if m.visibleAt.After(now) {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *SQS) push(q *sqsQueue, body, group string, attrs map[string]sqsMessageAttr) *sqsMessage {
+	m := &sqsMessage{
+		id:    uuid.NewString(),
+		body:  body,
+		group: group,
+		attrs: attrs,
+		sent:  time.Now(),
+	}
+	q.msgs = append(q.msgs, m)
+	close(s.sent)
+	s.sent = make(chan struct{})
+	return m
+}
+
+func (s *SQS) handle(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeSQSError(w, "MalformedQueryString", err.Error())
+		return
+	}
+	action := r.Form.Get("Action")
+	if action == "CreateQueue" {
+		name := r.Form.Get("QueueName")
+		s.CreateQueue(name)
+		writeSQSResponse(w, action, createQueueResult{QueueURL: s.QueueURL(name)})
+		return
+	}
+	if action == "GetQueueUrl" {
+		name := r.Form.Get("QueueName")
+		if !s.exists(name) {
+			writeSQSError(w, "AWS.SimpleQueueService.NonExistentQueue", "The specified queue does not exist.")
+			return
+		}
+		writeSQSResponse(w, action, getQueueURLResult{QueueURL: s.QueueURL(name)})
+		return
+	}
+	u, err := url.Parse(r.Form.Get("QueueUrl"))
+	if err != nil || !s.exists(path.Base(u.Path)) {
+		writeSQSError(w, "AWS.SimpleQueueService.NonExistentQueue", "The specified queue does not exist.")
+		return
+	}
+	name := path.Base(u.Path)
+	switch action {
+	case "SendMessage":
+		s.sendMessage(w, r, name)
+	case "SendMessageBatch":
+		s.sendMessageBatch(w, r, name)
+	case "ReceiveMessage":
+		s.receiveMessage(w, r, name)
+	case "DeleteMessage":
+		s.deleteMessage(w, r, name)
+	case "ChangeMessageVisibility":
+		s.changeMessageVisibility(w, r, name)
+	case "GetQueueAttributes":
+		s.getQueueAttributes(w, name)
+	default:
+		writeSQSError(w, "InvalidAction", fmt.Sprintf("The action %s is not valid for this endpoint.", action))
+	}
+}
+
+func (s *SQS) exists(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.queues[name]
+	return ok
+}
+
+func (s *SQS) sendMessage(w http.ResponseWriter, r *http.Request, name string) {
+	body := r.Form.Get("MessageBody")
+	attrs := formMessageAttrs(r.Form, "MessageAttribute")
+	s.mu.Lock()
+	m := s.push(s.queues[name], body, r.Form.Get("MessageGroupId"), attrs)
+	s.mu.Unlock()
+	writeSQSResponse(w, "SendMessage", sendMessageResult{
+		MessageID:        m.id,
+		MD5OfMessageBody: md5Hex(body),
+	})
+}
+
+func (s *SQS) sendMessageBatch(w http.ResponseWriter, r *http.Request, name string) {
+	var res sendMessageBatchResult
+	s.mu.Lock()
+	for i := 1; ; i++ {
+		prefix := fmt.Sprintf("SendMessageBatchRequestEntry.%d.", i)
+		id := r.Form.Get(prefix + "Id")
+		if id == "" {
+			break
+		}
+		body := r.Form.Get(prefix + "MessageBody")
+		attrs := formMessageAttrs(r.Form, prefix+"MessageAttribute")
+		m := s.push(s.queues[name], body, r.Form.Get(prefix+"MessageGroupId"), attrs)
+		res.Entries = append(res.Entries, sendMessageBatchResultEntry{
+			ID:               id,
+			MessageID:        m.id,
+			MD5OfMessageBody: md5Hex(body),
+		})
+	}
+	s.mu.Unlock()
+	writeSQSResponse(w, "SendMessageBatch", res)
+}
+
+func (s *SQS) receiveMessage(w http.ResponseWriter, r *http.Request, name string) {
+	max := formInt(r.Form, "MaxNumberOfMessages", 1)
+	if max < 1 || max > 10 {
+		writeSQSError(w, "InvalidParameterValue", "MaxNumberOfMessages must be between 1 and 10.")
+		return
+	}
+	visibility := formInt(r.Form, "VisibilityTimeout", DefaultVisibilityTimeout)
+	wait := formInt(r.Form, "WaitTimeSeconds", 0)
+	if wait > maxWaitTime {
+		wait = maxWaitTime
+	}
+	deadline := time.Now().Add(time.Duration(wait) * time.Second)
+	for {
+		s.mu.Lock()
+		msgs := s.receive(s.queues[name], max, time.Duration(visibility)*time.Second)
+		sent := s.sent
+		s.mu.Unlock()
+		if len(msgs) > 0 || !time.Now().Before(deadline) {
+			writeSQSResponse(w, "ReceiveMessage", receiveMessageResult{Messages: msgs})
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sent:
+		case <-time.After(receivePollInterval):
+		}
+	}
+}
+
+// receive hides and returns up to max visible messages of the given queue.
+// In FIFO queues the messages of a group are not returned while a previous
+// message of the same group is being processed.
+func (s *SQS) receive(q *sqsQueue, max int, visibility time.Duration) []xmlMessage {
+	now := time.Now()
+	blocked := make(map[string]bool)
+	var msgs []xmlMessage
+	for _, m := range q.msgs {
+		if len(msgs) >= max {
+			break
+		}
+		if q.fifo && blocked[m.group] {
+			continue
+		}
+		if m.visibleAt.After(now) {
+			blocked[m.group] = true
+			continue
+		}
+		m.receives++
+		m.receipt = uuid.NewString()
+		m.visibleAt = now.Add(visibility)
+		blocked[m.group] = true
+		msgs = append(msgs, m.xml())
+	}
+	return msgs
+}
+
+func (s *SQS) deleteMessage(w http.ResponseWriter, r *http.Request, name string) {
+	receipt := r.Form.Get("ReceiptHandle")
+	s.mu.Lock()
+	q := s.queues[name]
+	for i, m := range q.msgs {
+		if m.receipt != "" && m.receipt == receipt {
+			q.msgs = append(q.msgs[:i], q.msgs[i+1:]...)
+			break
+		}
+	}
+	s.mu.Unlock()
+	writeSQSResponse(w, "DeleteMessage", nil)
+}
+
+func (s *SQS) changeMessageVisibility(w http.ResponseWriter, r *http.Request, name string) {
+	receipt := r.Form.Get("ReceiptHandle")
+	visibility := formInt(r.Form, "VisibilityTimeout", 0)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, m := range s.queues[name].msgs {
+		if m.receipt == "" || m.receipt != receipt {
+			continue
+		}
+		if !m.visibleAt.After(now) {
+			break
+		}
+		m.visibleAt = now.Add(time.Duration(visibility) * time.Second)
+		writeSQSResponse(w, "ChangeMessageVisibility", nil)
+		return
+	}
+	writeSQSError(w, "AWS.SimpleQueueService.MessageNotInflight", "The message referred to isn't in flight.")
+}
+
+func (s *SQS) getQueueAttributes(w http.ResponseWriter, name string) {
+	s.mu.Lock()
+	now := time.Now()
+	var visible, hidden int
+	for _, m := range s.queues[name].msgs {
+		if m.visibleAt.After(now) {
+			hidden++
+		} else {
+			visible++
+		}
+	}
+	s.mu.Unlock()
+	writeSQSResponse(w, "GetQueueAttributes", getQueueAttributesResult{
+		Attributes: []xmlAttribute{
+			{Name: "ApproximateNumberOfMessages", Value: strconv.Itoa(visible)},
+			{Name: "ApproximateNumberOfMessagesNotVisible", Value: strconv.Itoa(hidden)},
+			{Name: "QueueArn", Value: s.ARN(name)},
+		},
+	})
+}
+
+func (m *sqsMessage) xml() xmlMessage {
+	x := xmlMessage{
+		MessageID:     m.id,
+		ReceiptHandle: m.receipt,
+		MD5OfBody:     md5Hex(m.body),
+		Body:          m.body,
+		Attributes: []xmlAttribute{
+			{Name: "ApproximateReceiveCount", Value: strconv.Itoa(m.receives)},
+			{Name: "SentTimestamp", Value: strconv.FormatInt(m.sent.UnixNano()/int64(time.Millisecond), 10)},
+		},
+	}
+	if m.group != "" {
+		x.Attributes = append(x.Attributes, xmlAttribute{Name: "MessageGroupId", Value: m.group})
+	}
+	for name, a := range m.attrs {
+		attr := xmlMessageAttribute{Name: name}
+		attr.Value.DataType = a.dataType
+		attr.Value.StringValue = a.value
+		x.MessageAttributes = append(x.MessageAttributes, attr)
+	}
+	return x
+}
+
+// formMessageAttrs returns the string message attributes encoded in the form
+// with the given prefix.
+func formMessageAttrs(form url.Values, prefix string) map[string]sqsMessageAttr {
+	attrs := make(map[string]sqsMessageAttr)
+	for i := 1; ; i++ {
+		p := fmt.Sprintf("%s.%d.", prefix, i)
+		name := form.Get(p + "Name")
+		if name == "" {
+			break
+		}
+		attrs[name] = sqsMessageAttr{
+			dataType: form.Get(p + "Value.DataType"),
+			value:    form.Get(p + "Value.StringValue"),
+		}
+	}
+	return attrs
+}
+
+func formInt(form url.Values, key string, def int) int {
+	v, err := strconv.Atoi(form.Get(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+type sqsResponse struct {
+	XMLName   xml.Name
+	Result    interface{}
+	RequestID string `xml:"ResponseMetadata>RequestId"`
+}
+
+type sqsError struct {
+	XMLName   xml.Name `xml:"ErrorResponse"`
+	Type      string   `xml:"Error>Type"`
+	Code      string   `xml:"Error>Code"`
+	Message   string   `xml:"Error>Message"`
+	RequestID string   `xml:"RequestId"`
+}
+
+type createQueueResult struct {
+	XMLName  xml.Name `xml:"CreateQueueResult"`
+	QueueURL string   `xml:"QueueUrl"`
+}
+
+type getQueueURLResult struct {
+	XMLName  xml.Name `xml:"GetQueueUrlResult"`
+	QueueURL string   `xml:"QueueUrl"`
+}
+
+type sendMessageResult struct {
+	XMLName          xml.Name `xml:"SendMessageResult"`
+	MessageID        string   `xml:"MessageId"`
+	MD5OfMessageBody string
+}
+
+type sendMessageBatchResult struct {
+	XMLName xml.Name                      `xml:"SendMessageBatchResult"`
+	Entries []sendMessageBatchResultEntry `xml:"SendMessageBatchResultEntry"`
+}
+
+type sendMessageBatchResultEntry struct {
+	ID               string `xml:"Id"`
+	MessageID        string `xml:"MessageId"`
+	MD5OfMessageBody string
+}
+
+type receiveMessageResult struct {
+	XMLName  xml.Name     `xml:"ReceiveMessageResult"`
+	Messages []xmlMessage `xml:"Message"`
+}
+
+type getQueueAttributesResult struct {
+	XMLName    xml.Name       `xml:"GetQueueAttributesResult"`
+	Attributes []xmlAttribute `xml:"Attribute"`
+}
+
+type xmlMessage struct {
+	MessageID         string `xml:"MessageId"`
+	ReceiptHandle     string
+	MD5OfBody         string
+	Body              string
+	Attributes        []xmlAttribute        `xml:"Attribute"`
+	MessageAttributes []xmlMessageAttribute `xml:"MessageAttribute"`
+}
+
+type xmlAttribute struct {
+	Name  string
+	Value string
+}
+
+type xmlMessageAttribute struct {
+	Name  string
+	Value struct {
+		StringValue string
+		DataType    string
+	}
+}
+
+func writeSQSResponse(w http.ResponseWriter, action string, result interface{}) {
+	resp := sqsResponse{
+		XMLName:   xml.Name{Local: action + "Response"},
+		Result:    result,
+		RequestID: uuid.NewString(),
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	xml.NewEncoder(w).Encode(resp)
+}
+
+func writeSQSError(w http.ResponseWriter, code, msg string) {
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(http.StatusBadRequest)
+	xml.NewEncoder(w).Encode(sqsError{
+		Type:      "Sender",
+		Code:      code,
+		Message:   msg,
+		RequestID: uuid.NewString(),
+	})
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package testutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/stream"
+	"github.com/gorilla/websocket"
+)
+
+// streamPingInterval is how often the fake stream sends a ping message to
+// the agents, so they don't reconnect after the read timeout.
+var streamPingInterval = time.Second
+
+// Stream is an in-process fake of the vulcan-stream service. It sends the
+// abort messages to the agents connected to its websocket endpoint and
+// returns the checks aborted so far from its query endpoint.
+type Stream struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	aborted []string
+	conns   map[*websocket.Conn]struct{}
+	done    chan struct{}
+}
+
+// NewStream starts a fake stream service without aborted checks.
+func NewStream() *Stream {
+	s := &Stream{
+		conns: make(map[*websocket.Conn]struct{}),
+		done:  make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stream", s.handleStream)
+	mux.HandleFunc("/checks", s.handleChecks)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Endpoint returns the websocket endpoint of the stream.
+func (s *Stream) Endpoint() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http") + "/stream"
+}
+
+// QueryEndpoint returns the endpoint that returns the aborted checks.
+func (s *Stream) QueryEndpoint() string {
+	return s.URL + "/checks"
+}
+
+// Abort aborts the given checks and sends the abort messages to the agents
+// connected.
+func (s *Stream) Abort(checkIDs ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.aborted = append(s.aborted, checkIDs...)
+	for _, id := range checkIDs {
+		s.broadcast(stream.Message{Action: "abort", CheckID: id})
+	}
+}
+
+// Clients returns the number of agents connected to the stream.
+func (s *Stream) Clients() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// Close disconnects the agents and stops the service.
+func (s *Stream) Close() {
+	s.mu.Lock()
+	close(s.done)
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.Server.Close()
+}
+
+// broadcast sends the given message to all the agents connected. It must be
+// called holding the lock of the stream.
+func (s *Stream) broadcast(msg stream.Message) {
+	for c := range s.conns {
+		s.send(c, msg)
+	}
+}
+
+func (s *Stream) handleStream(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.conns[conn] = struct{}{}
+	s.mu.Unlock()
+	closed := make(chan struct{})
+	go func() {
+		// The agents don't send messages, reading only detects when they
+		// disconnect.
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	ticker := time.NewTicker(streamPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			s.send(conn, stream.Message{Action: "ping"})
+			s.mu.Unlock()
+		case <-closed:
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			conn.Close()
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// send sends the given message to the given agent if it's still connected.
+// It must be called holding the lock of the stream.
+func (s *Stream) send(conn *websocket.Conn, msg stream.Message) {
+	if _, ok := s.conns[conn]; !ok {
+		return
+	}
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	if err := conn.WriteJSON(msg); err != nil {
+		conn.Close()
+		delete(s.conns, conn)
+	}
+}
+
+func (s *Stream) handleChecks(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	ids := append([]string{}, s.aborted...)
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ids)
+}
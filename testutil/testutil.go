@@ -0,0 +1,174 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+// Package testutil provides in-process fakes of the services used by the
+// agent, so the programs embedding it can run integration tests of the full
+// agent in pure Go, without Docker or LocalStack:
+//
+//	env, err := testutil.NewEnv()
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer env.Close()
+//	env.SendJob(jobrunner.Job{CheckID: "check1", Image: "vulcan-nmap:1", Target: "example.com"})
+//	cfg := env.Config()
+//	cfg.Agent.ExitWhenEmpty = true
+//	agent.Run(cfg, testutil.NewBackend(env.AgentAddr(), nil), &log.NullLog{})
+//	states, err := env.States()
+//
+// The fakes can also be used on their own: SQS implements the SQS API used
+// by the queue readers and writers, Results the vulcan-results service and
+// Stream the vulcan-stream service that aborts the checks.
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/jobrunner"
+	"github.com/adevinta/vulcan-agent/stateupdater"
+)
+
+// Names of the queues created by the Env.
+const (
+	JobsQueue   = "vulcan-agent-jobs"
+	StatesQueue = "vulcan-agent-states"
+)
+
+// DefaultJobTimeout is the timeout, in seconds, of the jobs sent without
+// timeout.
+const DefaultJobTimeout = 60
+
+// Credentials are the AWS credentials used by the agents run with the config
+// of an Env to sign the requests sent to the fake SQS.
+var Credentials = config.AWSCredentialsConfig{
+	AccessKeyID:     "test",
+	SecretAccessKey: "test",
+}
+
+// Env contains the fakes of all the services needed to run an agent: the
+// queue the agent reads the jobs from, the queue where it writes the states
+// of the checks, the results service and the stream.
+type Env struct {
+	SQS     *SQS
+	Results *Results
+	Stream  *Stream
+
+	apiAddr string
+}
+
+// NewEnv starts the fakes of an Env and reserves a free local address for
+// the API of the agent.
+func NewEnv() (*Env, error) {
+	apiAddr, err := freeAddr()
+	if err != nil {
+		return nil, fmt.Errorf("error getting an address for the agent API: %w", err)
+	}
+	e := &Env{
+		SQS:     NewSQS(),
+		Results: NewResults(),
+		Stream:  NewStream(),
+		apiAddr: apiAddr,
+	}
+	e.SQS.CreateQueue(JobsQueue)
+	e.SQS.CreateQueue(StatesQueue)
+	return e, nil
+}
+
+// Close stops all the fakes.
+func (e *Env) Close() {
+	e.SQS.Close()
+	e.Results.Close()
+	e.Stream.Close()
+}
+
+// AgentAddr returns the address of the API of the agents run with the config
+// returned by Config.
+func (e *Env) AgentAddr() string {
+	return "http://" + e.apiAddr
+}
+
+// Config returns the default config of the agent modified to use the fakes
+// of the Env. It runs one check at a time.
+func (e *Env) Config() config.Config {
+	cfg := config.Defaults()
+	cfg.Agent.ConcurrentJobs = 1
+	cfg.API.Port = e.apiAddr
+	cfg.SQSReader = config.SQSReader{
+		Endpoint:          e.SQS.URL,
+		ARN:               e.SQS.ARN(JobsQueue),
+		VisibilityTimeout: DefaultVisibilityTimeout,
+		PollingInterval:   1,
+		ProcessQuantum:    DefaultVisibilityTimeout / 2,
+		Credentials:       Credentials,
+	}
+	cfg.SQSWriter = config.SQSWriter{
+		Endpoint:    e.SQS.URL,
+		ARN:         e.SQS.ARN(StatesQueue),
+		Credentials: Credentials,
+	}
+	cfg.Uploader.Endpoint = e.Results.URL
+	cfg.Uploader.Timeout = 10
+	cfg.Stream.Endpoint = e.Stream.Endpoint()
+	cfg.Stream.QueryEndpoint = e.Stream.QueryEndpoint()
+	return cfg
+}
+
+// SendJob writes the given job to the jobs queue. The start time and the
+// timeout of the job are set if they are empty.
+func (e *Env) SendJob(j jobrunner.Job) error {
+	if j.StartTime.IsZero() {
+		j.StartTime = time.Now()
+	}
+	if j.Timeout == 0 {
+		j.Timeout = DefaultJobTimeout
+	}
+	body, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	return e.SQS.Send(JobsQueue, string(body))
+}
+
+// States returns the state updates written by the agent to the states queue,
+// in the order they were written.
+func (e *Env) States() ([]stateupdater.CheckState, error) {
+	var states []stateupdater.CheckState
+	for _, body := range e.SQS.Messages(StatesQueue) {
+		var s stateupdater.CheckState
+		if err := json.Unmarshal([]byte(body), &s); err != nil {
+			return nil, fmt.Errorf("invalid state update %q: %w", body, err)
+		}
+		states = append(states, s)
+	}
+	return states, nil
+}
+
+// Status returns the last status written by the agent for the given check.
+func (e *Env) Status(checkID string) (string, error) {
+	states, err := e.States()
+	if err != nil {
+		return "", err
+	}
+	var status string
+	for _, s := range states {
+		if s.ID == checkID && s.Status != nil {
+			status = *s.Status
+		}
+	}
+	return status, nil
+}
+
+// freeAddr returns a local address with a port that is not in use.
+func freeAddr() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	return l.Addr().String(), nil
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2022 Adevinta
+*/
+
+package testutil
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-agent/agent"
+	"github.com/adevinta/vulcan-agent/backend"
+	"github.com/adevinta/vulcan-agent/config"
+	"github.com/adevinta/vulcan-agent/jobrunner"
+	"github.com/adevinta/vulcan-agent/log"
+	"github.com/adevinta/vulcan-agent/queue/sqs"
+	"github.com/adevinta/vulcan-agent/stateupdater"
+	report "github.com/adevinta/vulcan-report"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	awssqs "github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// runAgent runs an agent with the given config and backend until it exits
+// or the test times out.
+func runAgent(t *testing.T, cfg config.Config, b backend.Backend) {
+	t.Helper()
+	done := make(chan int, 1)
+	go func() {
+		done <- agent.Run(cfg, b, &log.NullLog{})
+	}()
+	select {
+	case code := <-done:
+		if code != 0 {
+			t.Fatalf("agent exited with code %d", code)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatalf("agent didn't exit")
+	}
+}
+
+func TestEnv_RunChecks(t *testing.T) {
+	env, err := NewEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer env.Close()
+	for _, id := range []string{"check1", "check2"} {
+		err := env.SendJob(jobrunner.Job{
+			CheckID: id,
+			Image:   "vulcan-nmap:1",
+			Target:  "example.com",
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	b := NewBackend(env.AgentAddr(), func(ctx context.Context, params backend.RunParams) (report.Report, error) {
+		if params.CheckID == "check2" {
+			return report.Report{}, errors.New("scanner crashed")
+		}
+		var r report.Report
+		r.Vulnerabilities = []report.Vulnerability{{Summary: "Open port"}}
+		return r, nil
+	})
+	cfg := env.Config()
+	cfg.Agent.ExitWhenEmpty = true
+	runAgent(t, cfg, b)
+
+	for id, want := range map[string]string{
+		"check1": stateupdater.StatusFinished,
+		"check2": stateupdater.StatusFailed,
+	} {
+		status, err := env.Status(id)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if status != want {
+			t.Errorf("want status %s for %s, got %s", want, id, status)
+		}
+		if _, ok := env.Results.Raw(id); !ok {
+			t.Errorf("logs of %s not uploaded", id)
+		}
+	}
+	r, ok := env.Results.Report("check1")
+	if !ok {
+		t.Fatalf("report of check1 not uploaded")
+	}
+	if r.ChecktypeName != "vulcan-nmap" || len(r.Vulnerabilities) != 1 {
+		t.Errorf("unexpected report %+v", r)
+	}
+	if msgs := env.SQS.Messages(JobsQueue); len(msgs) != 0 {
+		t.Errorf("jobs not deleted from the queue: %v", msgs)
+	}
+}
+
+func TestEnv_AbortCheck(t *testing.T) {
+	env, err := NewEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer env.Close()
+	if err := env.SendJob(jobrunner.Job{CheckID: "check1", Image: "vulcan-nmap:1", Target: "example.com"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	started := make(chan struct{})
+	b := NewBackend(env.AgentAddr(), func(ctx context.Context, params backend.RunParams) (report.Report, error) {
+		close(started)
+		<-ctx.Done()
+		return report.Report{}, ctx.Err()
+	})
+	go func() {
+		<-started
+		for env.Stream.Clients() == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		env.Stream.Abort("check1")
+	}()
+	cfg := env.Config()
+	cfg.Agent.ExitWhenEmpty = true
+	runAgent(t, cfg, b)
+
+	status, err := env.Status("check1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status != stateupdater.StatusAborted {
+		t.Errorf("want status %s, got %s", stateupdater.StatusAborted, status)
+	}
+}
+
+func TestSQS_Visibility(t *testing.T) {
+	s := NewSQS()
+	defer s.Close()
+	s.CreateQueue("jobs")
+	w, err := sqs.NewWriter(s.ARN("jobs"), s.URL, Credentials, &log.NullLog{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := w.Write("job1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sess := session.Must(session.NewSession(aws.NewConfig().
+		WithEndpoint(s.URL).
+		WithRegion(Region).
+		WithCredentials(credentials.NewStaticCredentials("test", "test", ""))))
+	client := awssqs.New(sess)
+	receive := func() []*awssqs.Message {
+		out, err := client.ReceiveMessage(&awssqs.ReceiveMessageInput{
+			QueueUrl:          aws.String(s.QueueURL("jobs")),
+			VisibilityTimeout: aws.Int64(1),
+			AttributeNames:    []*string{aws.String("ApproximateReceiveCount")},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return out.Messages
+	}
+	msgs := receive()
+	if len(msgs) != 1 || aws.StringValue(msgs[0].Body) != "job1" {
+		t.Fatalf("unexpected messages %v", msgs)
+	}
+	if msgs := receive(); len(msgs) != 0 {
+		t.Fatalf("hidden message received: %v", msgs)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	msgs = receive()
+	if len(msgs) != 1 || aws.StringValue(msgs[0].Attributes["ApproximateReceiveCount"]) != "2" {
+		t.Fatalf("unexpected messages after the visibility timeout %v", msgs)
+	}
+	_, err = client.DeleteMessage(&awssqs.DeleteMessageInput{
+		QueueUrl:      aws.String(s.QueueURL("jobs")),
+		ReceiptHandle: msgs[0].ReceiptHandle,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if msgs := s.Messages("jobs"); len(msgs) != 0 {
+		t.Errorf("message not deleted: %v", msgs)
+	}
+}